@@ -2,10 +2,16 @@ module email-verification
 
 go 1.22
 
-require github.com/AfterShip/email-verifier v1.4.1
+require (
+	github.com/AfterShip/email-verifier v1.4.1
+	github.com/expr-lang/expr v1.17.8
+	github.com/tetratelabs/wazero v1.8.2
+	golang.org/x/crypto v0.27.0
+	golang.org/x/net v0.29.0
+)
 
 require (
 	github.com/hbollon/go-edlib v1.6.0 // indirect
-	golang.org/x/net v0.29.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
 	golang.org/x/text v0.18.0 // indirect
 )
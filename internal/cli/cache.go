@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"slices"
+
+	"email-verification/internal/verify"
+)
+
+// RunCache lists, clears or refreshes the on-disk caches
+func RunCache(args []string) error {
+	fs := newFlagSet("cache", "[flags] [list | clear [name...] | refresh [name...]]", "Manages the caches kept between runs; clear and refresh act on all of them without names.")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	action, names := "list", []string(nil)
+	if fs.NArg() > 0 {
+		action, names = fs.Arg(0), fs.Args()[1:]
+	}
+	if !slices.Contains([]string{"list", "clear", "refresh"}, action) {
+		return usageError(fs)
+	}
+	return verify.ManageCaches(config, action, names)
+}
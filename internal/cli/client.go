@@ -0,0 +1,32 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunClient submits a local input file to a remote server, streams progress and downloads the results
+func RunClient(args []string) error {
+	fs := newFlagSet("client", "[flags] [input] [output]", "")
+	defaults := verify.DefaultConfig()
+	opts := verify.ClientOptions{Token: getEnvString("API_TOKEN", "")}
+	fs.StringVar(&opts.ServerURL, "server", getEnvString("SERVER_URL", "http://localhost:8080"), "Base URL of a server started with the serve command")
+	fs.StringVar(&opts.InputFile, "input", getEnvString("INPUT_FILE", defaults.InputFile), "Input JSON file with emails")
+	fs.StringVar(&opts.OutputFile, "output", getEnvString("OUTPUT_FILE", defaults.OutputFile), "Output JSON file for invalid emails")
+	fs.IntVar(&opts.Workers, "workers", 0, "Workers for this job, up to the server's maximum (0 = server default)")
+	fs.StringVar(&opts.Rate, "rate", "", "Rate limit between verifications per worker for this job, no shorter than the server's minimum")
+	fs.StringVar(&opts.SMTP, "smtp", "", "Set to false to skip SMTP verification for this job")
+	fs.StringVar(&opts.Level, "level", "", "Verification level for this job: syntax, dns or smtp, no deeper than the server's")
+	fs.IntVar(&opts.ChunkSizeMB, "chunk-size", getEnvInt("UPLOAD_CHUNK_SIZE", 16), "Upload inputs larger than this many MB in resumable chunks (0 sends them in one request)")
+	fs.IntVar(&opts.Retries, "retries", getEnvInt("UPLOAD_RETRIES", 5), "Retries per chunk on network and server errors")
+	fs.StringVar(&opts.UploadID, "upload-id", "", "Resume the chunked upload with this ID, left unfinished by an earlier run")
+	fs.BoolVar(&opts.Delete, "delete", getEnvBool("DELETE_RESULTS", false), "Delete the job's results from the server once downloaded")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if fs.NArg() > 0 {
+		opts.InputFile = fs.Arg(0)
+	}
+	if fs.NArg() > 1 {
+		opts.OutputFile = fs.Arg(1)
+	}
+	return verify.SubmitFile(opts)
+}
@@ -0,0 +1,74 @@
+// Package cli is the command line of the email-verification tool: each command's flags and
+// environment variables, parsed into the settings the engine in internal/verify runs with.
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"email-verification/internal/verify"
+)
+
+// ExitError ends a command with a status of its own rather than 1: 2 for invalid usage, or the
+// verdict of check. Err, if set, is reported first; without it the command already has.
+type ExitError struct {
+	Code int
+	Err  error
+}
+
+func (e *ExitError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("exit status %d", e.Code)
+	}
+	return e.Err.Error()
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+// Setup loads the .env file, if any, and sends the log where the environment says. Commands
+// with the run's flags switch to their -log-format and -log-level once parsed. Logs go to
+// stderr, so results can own stdout.
+func Setup() error {
+	loadEnvFile(".env")
+	defaults := verify.DefaultConfig()
+	if err := verify.SetupLogging(getEnvString("LOG_FORMAT", defaults.LogFormat), getEnvString("LOG_LEVEL", defaults.LogLevel)); err != nil {
+		return fmt.Errorf("invalid logging settings: %w", err)
+	}
+	return nil
+}
+
+// newFlagSet returns the flags of a command, whose usage line is the command and its arguments,
+// followed by about if set
+func newFlagSet(name, arguments, about string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n", os.Args[0], name, arguments)
+		if about != "" {
+			fmt.Fprintf(fs.Output(), "%s\n\n", about)
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses a command's arguments. The flag package reports invalid ones, which then
+// end the command with status 2; -h returns flag.ErrHelp.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &ExitError{Code: 2}
+	}
+	return nil
+}
+
+// usageError prints a command's usage for arguments it can't take, ending it with status 2
+func usageError(fs *flag.FlagSet) error {
+	fs.Usage()
+	return &ExitError{Code: 2}
+}
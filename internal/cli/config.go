@@ -0,0 +1,353 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+
+	"email-verification/internal/verify"
+)
+
+// parseConfig parses a command's arguments into the settings of a run, with the flags of the
+// command defined on fs beforehand
+func parseConfig(fs *flag.FlagSet, args []string) (verify.Config, error) {
+	config := verify.DefaultConfig()
+	configFlags(fs, &config)
+	if err := parseFlags(fs, args); err != nil {
+		return config, err
+	}
+	if err := config.Normalize(); err != nil {
+		return config, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := verify.SetupLogging(config.LogFormat, config.LogLevel); err != nil {
+		return config, fmt.Errorf("invalid logging settings: %w", err)
+	}
+	return config, nil
+}
+
+// configFlags defines the flags of the settings on fs, defaulting to the environment where set and
+// to config otherwise, and fills in the settings only the environment sets
+func configFlags(fs *flag.FlagSet, config *verify.Config) {
+	// Default values from environment variables
+	defaultWorkers := getEnvInt("WORKERS", config.Workers)
+	defaultBatchSize := getEnvInt("BATCH_SIZE", config.BatchSize)
+	defaultRateLimit := getEnvDuration("RATE_LIMIT", config.RateLimit)
+	defaultEnableSMTP := getEnvBool("ENABLE_SMTP", config.EnableSMTP)
+	defaultLevel := getEnvString("LEVEL", config.Level)
+	defaultEgressCheck := getEnvBool("EGRESS_CHECK", config.EgressCheck)
+	defaultEgressIPs := getEnvString("EGRESS_IPS", config.EgressIPs)
+	defaultDNSBLs := getEnvString("DNSBL_ZONES", config.DNSBLs)
+	defaultEgressInterval := getEnvDuration("EGRESS_CHECK_INTERVAL", config.EgressInterval)
+	defaultBlocklistAction := getEnvString("BLOCKLIST_ACTION", config.BlocklistAction)
+	defaultFCrDNSCheck := getEnvBool("FCRDNS_CHECK", config.FCrDNSCheck)
+	defaultHelloName := getEnvString("HELO_NAME", config.HelloName)
+	defaultFromEmail := getEnvString("FROM_EMAIL", config.FromEmail)
+	defaultIPFamily := getEnvString("IP_FAMILY", config.IPFamily)
+	defaultSMTPPort := getEnvInt("SMTP_PORT", config.SMTPPort)
+	defaultSMTPConnectTimeout := getEnvDuration("SMTP_CONNECT_TIMEOUT", config.SMTPConnectTimeout)
+	defaultSMTPOperationTimeout := getEnvDuration("SMTP_OPERATION_TIMEOUT", config.SMTPOperationTimeout)
+	defaultEmailTimeout := getEnvDuration("EMAIL_TIMEOUT", config.EmailTimeout)
+	defaultProxies := getEnvString("PROXIES", config.Proxies)
+	defaultProxyRotation := getEnvString("PROXY_ROTATION", config.ProxyRotation)
+	defaultVerbose := getEnvBool("VERBOSE", config.Verbose)
+	defaultLogFormat := getEnvString("LOG_FORMAT", config.LogFormat)
+	defaultLogLevel := getEnvString("LOG_LEVEL", config.LogLevel)
+	defaultSimulate := getEnvBool("SIMULATE", config.Simulate)
+	defaultResolver := getEnvString("RESOLVER", config.Resolver)
+	defaultDNSCacheSize := getEnvInt("DNS_CACHE_SIZE", config.DNSCacheSize)
+	defaultDNSUpstreams := getEnvString("DNS_UPSTREAMS", config.DNSUpstreams)
+	defaultRecordFile := getEnvString("RECORD_FILE", config.RecordFile)
+	defaultReplayFile := getEnvString("REPLAY_FILE", config.ReplayFile)
+	defaultRetries := getEnvInt("RETRIES", config.Retries)
+	defaultRetryBackoff := getEnvDuration("RETRY_BACKOFF", config.RetryBackoff)
+	defaultGreylistRetry := getEnvDuration("GREYLIST_RETRY", config.GreylistRetry)
+	defaultMaxPerDomain := getEnvInt("MAX_PER_DOMAIN", config.MaxPerDomain)
+	defaultFairSchedule := getEnvBool("FAIR_SCHEDULE", config.FairSchedule)
+	defaultRampUp := getEnvDuration("RAMP_UP", config.RampUp)
+	defaultDedupeProbes := getEnvBool("DEDUPE_PROBES", config.DedupeProbes)
+	defaultDomainCache := getEnvBool("DOMAIN_CACHE", config.DomainCache)
+	defaultCatchAllSamples := getEnvInt("CATCH_ALL_SAMPLES", config.CatchAllSamples)
+	defaultCatchAllRisky := getEnvBool("CATCH_ALL_RISKY", config.CatchAllRisky)
+	defaultRejectRoles := getEnvBool("REJECT_ROLE_ACCOUNTS", config.RejectRoles)
+	defaultRolePrefixes := getEnvString("ROLE_PREFIXES", config.RolePrefixes)
+	defaultExcludeFree := getEnvBool("EXCLUDE_FREE", config.ExcludeFree)
+	defaultRCPTTiming := getEnvBool("RCPT_TIMING", config.RCPTTiming)
+	defaultLookalikes := getEnvBool("LOOKALIKE_CHECK", config.Lookalikes)
+	defaultRepair := getEnvString("REPAIR", config.Repair)
+	defaultRepairFile := getEnvString("REPAIR_FILE", config.RepairFile)
+	defaultCheckpointFile := getEnvString("CHECKPOINT_FILE", config.CheckpointFile)
+	defaultDedupe := getEnvString("DEDUPE", config.Dedupe)
+	defaultResume := getEnvBool("RESUME", config.Resume)
+	defaultInputFile := getEnvString("INPUT_FILE", config.InputFile)
+	defaultOutputFile := getEnvString("OUTPUT_FILE", config.OutputFile)
+	defaultEnableRDAP := getEnvBool("ENABLE_RDAP", config.EnableRDAP)
+	defaultRDAPRateLimit := getEnvDuration("RDAP_RATE_LIMIT", config.RDAPRateLimit)
+	defaultMinDomainAge := getEnvDuration("MIN_DOMAIN_AGE", config.MinDomainAge)
+	defaultEnableHIBP := getEnvBool("ENABLE_HIBP", config.EnableHIBP)
+	defaultHIBPRateLimit := getEnvDuration("HIBP_RATE_LIMIT", config.HIBPRateLimit)
+	defaultEnableCompany := getEnvBool("ENABLE_COMPANY", config.EnableCompany)
+	defaultCompanyRateLimit := getEnvDuration("COMPANY_RATE_LIMIT", config.CompanyRateLimit)
+	defaultEnableGeo := getEnvBool("ENABLE_GEO", config.EnableGeo)
+	defaultOnlyCountries := getEnvString("ONLY_COUNTRIES", config.OnlyCountries)
+	defaultExcludeCountries := getEnvString("EXCLUDE_COUNTRIES", config.ExcludeCountries)
+	defaultRegions := getEnvString("REGIONS", config.Regions)
+	defaultRegionDataDir := getEnvString("REGION_DATA_DIR", config.RegionDataDir)
+	defaultDisposableList := getEnvString("DISPOSABLE_LIST", config.DisposableList)
+	defaultDisposableExtra := getEnvString("DISPOSABLE_EXTRA", config.DisposableExtra)
+	defaultDisposableRefresh := getEnvDuration("DISPOSABLE_REFRESH", config.DisposableRefresh)
+	// ALLOW_LIST_FILE and SUPPRESS_LIST_FILE are older spellings, still read when the others are unset
+	defaultAllowlist := getEnvString("ALLOWLIST_FILE", getEnvString("ALLOW_LIST_FILE", config.Allowlist))
+	defaultBlocklist := getEnvString("BLOCKLIST_FILE", getEnvString("SUPPRESS_LIST_FILE", config.Blocklist))
+	defaultTypoMarkets := getEnvString("TYPO_MARKETS", config.TypoMarkets)
+	defaultKeyboardLayout := getEnvString("KEYBOARD_LAYOUT", config.KeyboardLayout)
+	defaultEnableTLDCheck := getEnvBool("ENABLE_TLD_CHECK", config.EnableTLDCheck)
+	defaultTLDMaxAge := getEnvDuration("TLD_MAX_AGE", config.TLDMaxAge)
+	defaultProviderRates := getEnvString("PROVIDER_RATES", config.ProviderRates)
+	defaultDomainRate := getEnvString("DOMAIN_RATE", config.DomainRate)
+	defaultDomainRates := getEnvString("DOMAIN_RATES", config.DomainRates)
+	defaultRateLimitRedis := getEnvString("RATE_LIMIT_REDIS", config.RateLimitRedis)
+	defaultEnableStrategies := getEnvBool("ENABLE_STRATEGIES", config.EnableStrategies)
+	defaultStrategyFile := getEnvString("STRATEGY_FILE", config.StrategyFile)
+	defaultPolicyFile := getEnvString("POLICY_FILE", config.PolicyFile)
+	defaultNoProbeProviders := getEnvString("NO_PROBE_PROVIDERS", config.NoProbeProviders)
+	defaultChecks := getEnvString("CHECKS", config.Checks)
+	defaultVerdictExpr := getEnvString("VERDICT_EXPR", config.VerdictExpr)
+	defaultPreHook := getEnvString("PRE_HOOK", config.PreHook)
+	defaultPostHook := getEnvString("POST_HOOK", config.PostHook)
+	defaultSinks := getEnvString("SINKS", config.Sinks)
+	defaultBigQueryProject := getEnvString("BIGQUERY_PROJECT", config.BigQueryProject)
+	defaultBigQueryDataset := getEnvString("BIGQUERY_DATASET", config.BigQueryDataset)
+	defaultBigQueryTable := getEnvString("BIGQUERY_TABLE", config.BigQueryTable)
+	defaultBigQueryMode := getEnvString("BIGQUERY_MODE", config.BigQueryMode)
+	defaultBigQueryBatch := getEnvInt("BIGQUERY_BATCH", config.BigQueryBatch)
+	defaultElasticsearchURL := getEnvString("ELASTICSEARCH_URL", config.ElasticsearchURL)
+	defaultElasticsearchIndex := getEnvString("ELASTICSEARCH_INDEX", config.ElasticsearchIndex)
+	defaultElasticsearchMapping := getEnvString("ELASTICSEARCH_MAPPING", config.ElasticsearchMapping)
+	defaultElasticsearchBatch := getEnvInt("ELASTICSEARCH_BATCH", config.ElasticsearchBatch)
+	defaultClickHouseURL := getEnvString("CLICKHOUSE_URL", config.ClickHouseURL)
+	defaultClickHouseTable := getEnvString("CLICKHOUSE_TABLE", config.ClickHouseTable)
+	defaultClickHouseBatch := getEnvInt("CLICKHOUSE_BATCH", config.ClickHouseBatch)
+	defaultLDAPBaseDN := getEnvString("LDAP_BASE_DN", config.LDAPBaseDN)
+	defaultLDAPFilter := getEnvString("LDAP_FILTER", config.LDAPFilter)
+	defaultLDAPAttributes := getEnvString("LDAP_ATTRIBUTES", config.LDAPAttributes)
+	defaultLDAPBindDN := getEnvString("LDAP_BIND_DN", config.LDAPBindDN)
+	defaultLDAPStartTLS := getEnvBool("LDAP_STARTTLS", config.LDAPStartTLS)
+	defaultLDAPPageSize := getEnvInt("LDAP_PAGE_SIZE", config.LDAPPageSize)
+	defaultMongoCollection := getEnvString("MONGO_COLLECTION", config.MongoCollection)
+	defaultMongoQuery := getEnvString("MONGO_QUERY", config.MongoQuery)
+	defaultMongoField := getEnvString("MONGO_FIELD", config.MongoField)
+	defaultMongoResultField := getEnvString("MONGO_RESULT_FIELD", config.MongoResultField)
+	defaultMongoBatch := getEnvInt("MONGO_BATCH", config.MongoBatch)
+	defaultSheetsColumn := getEnvString("SHEETS_COLUMN", config.SheetsColumn)
+	defaultSheetsResults := getEnvString("SHEETS_RESULTS", config.SheetsResults)
+	defaultSheetsBatch := getEnvInt("SHEETS_BATCH", config.SheetsBatch)
+	defaultDetailsFile := getEnvString("DETAILS_FILE", config.DetailsFile)
+	defaultValidFile := getEnvString("VALID_OUTPUT_FILE", config.ValidFile)
+	defaultFreeFile := getEnvString("FREE_OUTPUT_FILE", config.FreeFile)
+	defaultOutputTemplate := getEnvString("OUTPUT_TEMPLATE", config.OutputTemplate)
+	defaultDomainStore := getEnvString("DOMAIN_STORE", config.DomainStore)
+	defaultEnablePatterns := getEnvBool("ENABLE_PATTERNS", config.EnablePatterns)
+	defaultPatternsFile := getEnvString("PATTERNS_FILE", config.PatternsFile)
+	defaultPatternScore := getEnvBool("PATTERN_SCORE", config.PatternScore)
+	defaultResourceReport := getEnvString("RESOURCE_REPORT_FILE", config.ResourceReport)
+	defaultValidityWindows := getEnvString("VALIDITY_WINDOWS", config.ValidityWindows)
+	defaultActions := getEnvBool("ACTIONS", config.Actions)
+	defaultQuarantinePeriod := getEnvString("QUARANTINE_PERIOD", config.QuarantinePeriod)
+	defaultSortBy := getEnvString("SORT_BY", config.SortBy)
+	defaultUploadPartSize := getEnvInt("UPLOAD_PART_SIZE", config.UploadPartSize)
+	defaultUploadRetries := getEnvInt("UPLOAD_RETRIES", config.UploadRetries)
+	defaultManifestFile := getEnvString("MANIFEST_FILE", config.ManifestFile)
+	defaultSignKey := getEnvString("SIGN_KEY", config.SignKey)
+	defaultGroupBy := getEnvString("GROUP_BY", config.GroupBy)
+	defaultOutputIndent := getEnvInt("OUTPUT_INDENT", config.OutputIndent)
+	defaultOutputCompact := getEnvBool("OUTPUT_COMPACT", config.OutputCompact)
+	defaultOutputColumns := getEnvString("OUTPUT_COLUMNS", config.OutputColumns)
+	defaultOutputHeader := getEnvBool("OUTPUT_HEADER", config.OutputHeader)
+	defaultOutputFileFormat := getEnvString("OUTPUT_FORMAT", config.OutputFileFormat)
+	defaultDetailColumns := getEnvString("DETAILS_COLUMNS", config.DetailColumns)
+	defaultSplitRecords := getEnvBool("SPLIT_RECORDS", config.SplitRecords)
+	defaultRecordsFile := getEnvString("RECORDS_FILE", config.RecordsFile)
+	defaultWarehouse := getEnvString("WAREHOUSE", config.Warehouse)
+	defaultWarehouseStage := getEnvString("WAREHOUSE_STAGE", config.WarehouseStage)
+	defaultWarehouseTable := getEnvString("WAREHOUSE_TABLE", config.WarehouseTable)
+	defaultWarehouseAuth := getEnvString("WAREHOUSE_AUTH", config.WarehouseAuth)
+	defaultWarehouseShardRows := getEnvInt("WAREHOUSE_SHARD_ROWS", config.WarehouseShardRows)
+	defaultInputFormat := getEnvString("INPUT_FORMAT", config.InputFormat)
+	defaultInputColumn := getEnvString("INPUT_COLUMN", config.InputColumn)
+	defaultInputIDColumn := getEnvString("INPUT_ID_COLUMN", config.InputIDColumn)
+	defaultInputHeader := getEnvBool("INPUT_HEADER", config.InputHeader)
+
+	// Command line flags (override environment variables)
+	fs.StringVar(&config.InputFile, "input", defaultInputFile, "Input file with emails, or - for stdin (read by default when piped)")
+	fs.StringVar(&config.OutputFile, "output", defaultOutputFile, "Output JSON file for invalid emails (.jsonl/.ndjson for JSON Lines, .csv/.tsv for delimited), or - for NDJSON on stdout")
+	fs.IntVar(&config.Workers, "workers", defaultWorkers, "Number of concurrent workers")
+	fs.IntVar(&config.BatchSize, "batch", defaultBatchSize, "Batch size for progress reporting")
+	fs.DurationVar(&config.RateLimit, "rate", defaultRateLimit, "Rate limit between verifications per worker")
+	fs.BoolVar(&config.EnableSMTP, "smtp", defaultEnableSMTP, "Enable SMTP verification (disable with -smtp=false if blocked by ISP)")
+	fs.StringVar(&config.Level, "level", defaultLevel, "Verification depth: syntax (no network), dns (plus MX records) or smtp (plus a mailbox probe); overrides -smtp (default: smtp, or dns with -smtp=false)")
+	fs.BoolVar(&config.Verbose, "verbose", defaultVerbose, "Log every address and lookup failure (same as -log-level=debug)")
+	fs.StringVar(&config.LogFormat, "log-format", defaultLogFormat, "Log format on stderr: text (key=value) or json (one object per line)")
+	fs.StringVar(&config.LogLevel, "log-level", defaultLogLevel, "Least severe log level to write: debug, info, warn or error")
+	fs.IntVar(&config.Retries, "retries", defaultRetries, "Retries of DNS lookups and SMTP probes that fail transiently (timeouts, dropped connections, 4xx) before the address is reported as a verification error")
+	fs.DurationVar(&config.RetryBackoff, "retry-backoff", defaultRetryBackoff, "Wait before the first retry, doubling for each one after")
+	fs.DurationVar(&config.GreylistRetry, "greylist-retry", defaultGreylistRetry, "Try greylisted addresses again this long after they were deferred, reporting them as unknown if still deferred (0 disables)")
+	fs.IntVar(&config.MaxPerDomain, "max-per-domain", defaultMaxPerDomain, "Most addresses of one domain to probe over SMTP in a run; the rest are reported as deferred (0 = no limit)")
+	fs.BoolVar(&config.FairSchedule, "fair-schedule", defaultFairSchedule, "Verify addresses round-robin across domains rather than in input order, so lists sorted by domain don't hammer one provider at a time")
+	fs.DurationVar(&config.RampUp, "ramp-up", defaultRampUp, "After a pause (blocklisted egress IP, greylist wait, resumed run), bring workers back one at a time over this period rather than all at once (0 disables)")
+	fs.BoolVar(&config.Simulate, "simulate", defaultSimulate, "Verify against a deterministic fake DNS and SMTP instead of the network, for testing integrations")
+	fs.StringVar(&config.Resolver, "resolver", defaultResolver, "DNS server (host:port) for every lookup instead of the system resolver, such as a mock-mx server in tests")
+	fs.IntVar(&config.DNSCacheSize, "dns-cache-size", defaultDNSCacheSize, "Answers the embedded caching resolver keeps for every lookup of the process (0 to use the system resolver directly)")
+	fs.StringVar(&config.DNSUpstreams, "dns-upstreams", defaultDNSUpstreams, "Comma-separated DNS servers (host:port) the caching resolver forwards to (default: -resolver, else the system's nameservers)")
+	fs.StringVar(&config.RecordFile, "record", defaultRecordFile, "Record the run's MX lookups and SMTP probes, with mailbox names hashed, to this JSONL file for -replay")
+	fs.StringVar(&config.ReplayFile, "replay", defaultReplayFile, "Answer MX lookups and SMTP probes from a file written with -record instead of contacting servers")
+	fs.BoolVar(&config.EgressCheck, "egress-check", defaultEgressCheck, "Check the egress IP against DNSBLs at startup and periodically while probing over SMTP")
+	fs.StringVar(&config.EgressIPs, "egress-ips", defaultEgressIPs, "Comma-separated egress IPs to check (detected when empty)")
+	fs.StringVar(&config.DNSBLs, "dnsbl", defaultDNSBLs, "Comma-separated DNSBL zones to check the egress IP against")
+	fs.DurationVar(&config.EgressInterval, "egress-interval", defaultEgressInterval, "How often to recheck the egress IP against the DNSBLs")
+	fs.StringVar(&config.BlocklistAction, "blocklist-action", defaultBlocklistAction, "What to do while the egress IP is blocklisted: pause verification or warn and continue")
+	fs.BoolVar(&config.FCrDNSCheck, "fcrdns-check", defaultFCrDNSCheck, "Warn at startup when the egress IP lacks forward-confirmed reverse DNS matching the HELO name")
+	fs.StringVar(&config.HelloName, "helo", defaultHelloName, "Name SMTP probes introduce themselves with in HELO/EHLO, ideally the egress IP's reverse DNS name")
+	fs.StringVar(&config.FromEmail, "from", defaultFromEmail, "MAIL FROM address of SMTP probes, ideally at a domain you control with SPF covering the egress IP")
+	fs.StringVar(&config.IPFamily, "ip-family", defaultIPFamily, "Address family SMTP probes connect over: auto (IPv6 first with IPv4 fallback), ipv4 or ipv6")
+	fs.IntVar(&config.SMTPPort, "smtp-port", defaultSMTPPort, "Port SMTP probes connect to on MX hosts, other than 25 only for test servers such as mock-mx")
+	fs.DurationVar(&config.SMTPConnectTimeout, "smtp-connect-timeout", defaultSMTPConnectTimeout, "Timeout for connecting to an MX host")
+	fs.DurationVar(&config.SMTPOperationTimeout, "smtp-operation-timeout", defaultSMTPOperationTimeout, "Timeout for the SMTP commands of a probe once connected")
+	fs.DurationVar(&config.EmailTimeout, "email-timeout", defaultEmailTimeout, "Deadline for verifying one address, retries and extra probes included (0 for none)")
+	fs.StringVar(&config.Proxies, "proxies", defaultProxies, "Comma-separated socks5:// proxies, or a file listing them, to spread SMTP probes over")
+	fs.StringVar(&config.ProxyRotation, "proxy-rotation", defaultProxyRotation, "How probes pick a proxy: round-robin, or sticky to keep each domain on one proxy")
+	fs.IntVar(&config.CatchAllSamples, "catch-all-samples", defaultCatchAllSamples, "On catch-all domains, probe this many random mailboxes alongside the address to estimate a confidence (0 disables)")
+	fs.BoolVar(&config.CatchAllRisky, "catch-all-risky", defaultCatchAllRisky, "Classify addresses on catch-all domains as risky rather than valid")
+	fs.BoolVar(&config.RejectRoles, "reject-role-accounts", defaultRejectRoles, "Classify role addresses (info@, admin@, noreply@) as invalid")
+	fs.StringVar(&config.RolePrefixes, "role-prefixes", defaultRolePrefixes, "Comma-separated role prefixes, or a file listing one per line, replacing the built-in role account list")
+	fs.BoolVar(&config.ExcludeFree, "exclude-free", defaultExcludeFree, "Classify addresses at free email providers (gmail.com, yahoo.com) as invalid")
+	fs.BoolVar(&config.RCPTTiming, "rcpt-timing", defaultRCPTTiming, "Record RCPT latency of accepted addresses against control probes on the same connection")
+	fs.BoolVar(&config.Lookalikes, "lookalikes", defaultLookalikes, "Flag domains imitating major mailbox providers (homoglyphs, g00gle-mail style) as risky")
+	fs.StringVar(&config.Repair, "repair", defaultRepair, "Repair artifacts such as mailto: prefixes, spaces and ,com: off, suggest (report candidates) or auto (verify the repaired address)")
+	fs.StringVar(&config.RepairFile, "repair-file", defaultRepairFile, "JSON file the repair candidates are written to, in the input format")
+	fs.StringVar(&config.CheckpointFile, "checkpoint", defaultCheckpointFile, "Optional file journaling every verified address, so an interrupted run can be resumed")
+	fs.BoolVar(&config.Resume, "resume", defaultResume, "Continue from the -checkpoint file instead of starting over")
+	fs.StringVar(&config.Dedupe, "dedupe", defaultDedupe, "Drop duplicate addresses before verification: off, exact, normalized (trimmed and lowercased) or mailbox (Gmail dots, +tags, domain aliases)")
+	fs.BoolVar(&config.DedupeProbes, "dedupe-probes", defaultDedupeProbes, "Probe each mailbox once when several addresses canonicalize to it (case, Gmail dots, +tags)")
+	fs.BoolVar(&config.DomainCache, "domain-cache", defaultDomainCache, "Resolve MX records, disposable checks and catch-all detection once per domain rather than once per address")
+	fs.BoolVar(&config.EnableRDAP, "rdap", defaultEnableRDAP, "Look up domain registration dates via RDAP and flag young domains as risky")
+	fs.DurationVar(&config.RDAPRateLimit, "rdap-rate", defaultRDAPRateLimit, "Minimum interval between RDAP queries")
+	fs.DurationVar(&config.MinDomainAge, "min-domain-age", defaultMinDomainAge, "Domains registered more recently than this are flagged as risky")
+	fs.BoolVar(&config.EnableHIBP, "hibp", defaultEnableHIBP, "Report whether addresses appear in known breaches (requires HIBP_API_KEY)")
+	fs.DurationVar(&config.HIBPRateLimit, "hibp-rate", defaultHIBPRateLimit, "Minimum interval between breach range queries")
+	fs.BoolVar(&config.EnableCompany, "company", defaultEnableCompany, "Enrich corporate domains with firmographic data in the details output")
+	fs.DurationVar(&config.CompanyRateLimit, "company-rate", defaultCompanyRateLimit, "Minimum interval between company provider queries")
+	fs.BoolVar(&config.EnableGeo, "geo", defaultEnableGeo, "Infer the likely country of each address from its domain")
+	fs.StringVar(&config.OnlyCountries, "only-countries", defaultOnlyCountries, "Comma-separated country codes (or EU/EEA) to keep; others are marked invalid")
+	fs.StringVar(&config.ExcludeCountries, "exclude-countries", defaultExcludeCountries, "Comma-separated country codes (or EU/EEA) to mark invalid")
+	fs.StringVar(&config.Regions, "regions", defaultRegions, "Comma-separated regional free/disposable lists to load (ru, cn, in, eu or all)")
+	fs.StringVar(&config.RegionDataDir, "region-data", defaultRegionDataDir, "Directory with additional free/<region>.txt and disposable/<region>.txt lists")
+	fs.StringVar(&config.DisposableList, "disposable-list", defaultDisposableList, "Comma-separated files or URLs of disposable domains replacing the built-in list")
+	fs.StringVar(&config.DisposableExtra, "disposable-extra", defaultDisposableExtra, "Comma-separated files or URLs of disposable domains added to the built-in list")
+	fs.DurationVar(&config.DisposableRefresh, "disposable-refresh", defaultDisposableRefresh, "How often the -disposable-list and -disposable-extra sources are reloaded (0 loads them once)")
+	fs.StringVar(&config.Allowlist, "allowlist", defaultAllowlist, "File of addresses, domains and * patterns that skip verification and are always valid")
+	fs.StringVar(&config.Blocklist, "blocklist", defaultBlocklist, "File of addresses, domains and * patterns that are always invalid, as \"blocklisted\", such as contractual suppression lists")
+	fs.StringVar(&config.Allowlist, "allow-list", defaultAllowlist, "Alias of -allowlist")
+	fs.StringVar(&config.Blocklist, "suppress-list", defaultBlocklist, "Alias of -blocklist")
+	fs.StringVar(&config.TypoMarkets, "typo-markets", defaultTypoMarkets, "Comma-separated target markets for locale-aware typo suggestions (e.g. de,pl,cz)")
+	fs.StringVar(&config.KeyboardLayout, "keyboard", defaultKeyboardLayout, "Keyboard layout for typo distance (qwerty, qwertz, azerty; default from first market)")
+	fs.BoolVar(&config.EnableTLDCheck, "tld-check", defaultEnableTLDCheck, "Reject addresses whose TLD is not in the IANA list before any DNS lookup")
+	fs.DurationVar(&config.TLDMaxAge, "tld-max-age", defaultTLDMaxAge, "Refresh the cached IANA TLD list when older than this")
+	fs.BoolVar(&config.RefreshTLDs, "refresh-tlds", false, "Force a refresh of the cached IANA TLD list")
+	fs.StringVar(&config.ProviderRates, "provider-rate", defaultProviderRates, "Minimum interval between verifications per mailbox provider (e.g. google=200ms,microsoft=1s)")
+	fs.StringVar(&config.DomainRate, "domain-rate", defaultDomainRate, "Token bucket rate per recipient domain across all workers, as count/s, /m or /h with an optional :burst (e.g. 5/s:10)")
+	fs.StringVar(&config.DomainRates, "domain-rates", defaultDomainRates, "Per-domain overrides of -domain-rate (e.g. gmail.com=2/s,example.com=30/m:5, 0 for unlimited)")
+	fs.StringVar(&config.RateLimitRedis, "rate-limit-redis", defaultRateLimitRedis, "Redis URL to share provider rate limits across instances (e.g. redis://:password@redis:6379/0)")
+	fs.BoolVar(&config.EnableStrategies, "strategies", defaultEnableStrategies, "Apply built-in per-provider probe style, pacing and confidence when SMTP is enabled")
+	fs.StringVar(&config.StrategyFile, "strategy-file", defaultStrategyFile, "JSON file overriding per-provider strategies")
+	fs.StringVar(&config.PolicyFile, "policy", defaultPolicyFile, "JSON file of domains and providers never to probe over SMTP, added to the built-in list of sensitive infrastructure")
+	fs.StringVar(&config.NoProbeProviders, "no-probe-providers", defaultNoProbeProviders, "Comma-separated providers known to ban verification probing, whose addresses only get DNS checks (empty probes them all)")
+	fs.StringVar(&config.Checks, "checks", defaultChecks, "Comma-separated custom checks (built-in names or exec:/path/to/plugin)")
+	fs.StringVar(&config.VerdictExpr, "verdict-expr", defaultVerdictExpr, "Expression computing the final verdict (true or \"invalid\" rejects, \"risky\" flags)")
+	fs.StringVar(&config.PreHook, "pre-hook", defaultPreHook, "Command that transforms each input address before verification")
+	fs.StringVar(&config.PostHook, "post-hook", defaultPostHook, "Command that transforms each result before writing")
+	fs.StringVar(&config.Sinks, "sinks", defaultSinks, "Comma-separated extensions (exec:/path or wasm:/path) receiving every result")
+	fs.StringVar(&config.BigQueryProject, "bigquery-project", defaultBigQueryProject, "Google Cloud project of the BigQuery table (default: the service account's project)")
+	fs.StringVar(&config.BigQueryDataset, "bigquery-dataset", defaultBigQueryDataset, "BigQuery dataset of the results table")
+	fs.StringVar(&config.BigQueryTable, "bigquery-table", defaultBigQueryTable, "BigQuery table receiving every result, created if needed (table, dataset.table or project.dataset.table)")
+	fs.StringVar(&config.BigQueryMode, "bigquery-mode", defaultBigQueryMode, "How results reach BigQuery: stream (streaming inserts) or load (one load job per run)")
+	fs.IntVar(&config.BigQueryBatch, "bigquery-batch", defaultBigQueryBatch, "Rows per BigQuery streaming insert request")
+	fs.StringVar(&config.ElasticsearchURL, "elasticsearch-url", defaultElasticsearchURL, "Elasticsearch or OpenSearch URL every result is indexed at (credentials in the URL or ELASTICSEARCH_API_KEY)")
+	fs.StringVar(&config.ElasticsearchIndex, "elasticsearch-index", defaultElasticsearchIndex, "Index or alias receiving the results, created with the mapping if needed")
+	fs.StringVar(&config.ElasticsearchMapping, "elasticsearch-mapping", defaultElasticsearchMapping, "JSON file with the settings and mappings to create the index with (default: the built-in mapping)")
+	fs.IntVar(&config.ElasticsearchBatch, "elasticsearch-batch", defaultElasticsearchBatch, "Results per Elasticsearch bulk request")
+	fs.StringVar(&config.ClickHouseURL, "clickhouse-url", defaultClickHouseURL, "ClickHouse HTTP interface URL every result is inserted at (credentials in the URL or CLICKHOUSE_USER)")
+	fs.StringVar(&config.ClickHouseTable, "clickhouse-table", defaultClickHouseTable, "Table, or database.table, receiving the results, created if needed")
+	fs.IntVar(&config.ClickHouseBatch, "clickhouse-batch", defaultClickHouseBatch, "Results per ClickHouse insert")
+	fs.StringVar(&config.LDAPBaseDN, "ldap-base-dn", defaultLDAPBaseDN, "Base DN searched when the input is an ldap:// or ldaps:// URL (default: the URL's path)")
+	fs.StringVar(&config.LDAPFilter, "ldap-filter", defaultLDAPFilter, "LDAP filter selecting the entries to verify")
+	fs.StringVar(&config.LDAPAttributes, "ldap-attributes", defaultLDAPAttributes, "Comma-separated attributes holding addresses, such as mail,proxyAddresses")
+	fs.StringVar(&config.LDAPBindDN, "ldap-bind-dn", defaultLDAPBindDN, "DN to bind as, with the password in LDAP_BIND_PASSWORD (default: anonymous)")
+	fs.BoolVar(&config.LDAPStartTLS, "ldap-starttls", defaultLDAPStartTLS, "Upgrade ldap:// connections with StartTLS")
+	fs.IntVar(&config.LDAPPageSize, "ldap-page-size", defaultLDAPPageSize, "Entries per page of the LDAP search")
+	fs.StringVar(&config.MongoCollection, "mongo-collection", defaultMongoCollection, "Collection addresses are read from when the input is a mongodb:// URL")
+	fs.StringVar(&config.MongoQuery, "mongo-query", defaultMongoQuery, "Extended JSON filter selecting the documents to verify (default: all)")
+	fs.StringVar(&config.MongoField, "mongo-field", defaultMongoField, "Dotted path of the field holding the address, or an array of them")
+	fs.StringVar(&config.MongoResultField, "mongo-result-field", defaultMongoResultField, "Field each document gets its result set in (empty to only read)")
+	fs.IntVar(&config.MongoBatch, "mongo-batch", defaultMongoBatch, "Documents per MongoDB update command")
+	fs.StringVar(&config.SheetsColumn, "sheets-column", defaultSheetsColumn, "Header or letter of the address column when the input is a Google Sheets URL")
+	fs.StringVar(&config.SheetsResults, "sheets-results", defaultSheetsResults, "Comma-separated result fields written back as columns of the sheet (empty to only read)")
+	fs.IntVar(&config.SheetsBatch, "sheets-batch", defaultSheetsBatch, "Rows per Google Sheets update request")
+	fs.StringVar(&config.DetailsFile, "details", defaultDetailsFile, "Optional JSON file with per-email details for every address (.jsonl/.ndjson for JSON Lines)")
+	fs.StringVar(&config.ValidFile, "valid-output", defaultValidFile, "Optional file listing the valid emails, as an input document (.txt for one per line, .jsonl/.ndjson, .csv/.tsv)")
+	fs.StringVar(&config.FreeFile, "free-output", defaultFreeFile, "Optional file the valid emails at free providers are listed in instead of -valid-output, in the same formats")
+	fs.StringVar(&config.OutputTemplate, "output-template", defaultOutputTemplate, "Go text/template file used to render the output file instead of JSON")
+	fs.StringVar(&config.DomainStore, "domain-store", defaultDomainStore, "JSON file accumulating per-domain intelligence across runs (served by the serve command)")
+	fs.BoolVar(&config.EnablePatterns, "patterns", defaultEnablePatterns, "Infer the address pattern (first.last, flast, ...) of corporate domains from verified addresses")
+	fs.StringVar(&config.PatternsFile, "patterns-file", defaultPatternsFile, "JSON file the inferred patterns are written to")
+	fs.BoolVar(&config.PatternScore, "pattern-score", defaultPatternScore, "Score unverifiable addresses by how well they match their domain's pattern (implies -patterns)")
+	fs.StringVar(&config.ResourceReport, "resource-report", defaultResourceReport, "Optional JSON file the run's CPU time, peak memory, DNS queries, SMTP connections and bytes transferred are written to")
+	fs.BoolVar(&config.SplitRecords, "split-records", defaultSplitRecords, "Split input entries holding several addresses (separated by ; or ,) and group results by record")
+	fs.StringVar(&config.RecordsFile, "records-file", defaultRecordsFile, "JSON file the results grouped by input record are written to (with -split-records)")
+	fs.StringVar(&config.Warehouse, "warehouse", defaultWarehouse, "Warehouse the staged results are loaded into: snowflake or redshift")
+	fs.StringVar(&config.WarehouseStage, "warehouse-stage", defaultWarehouseStage, "Directory, s3:// or gs:// prefix receiving every result as gzip CSV shards plus load.sql with the COPY statements")
+	fs.StringVar(&config.WarehouseTable, "warehouse-table", defaultWarehouseTable, "Table the load statements create and copy into (table, schema.table or database.schema.table)")
+	fs.StringVar(&config.WarehouseAuth, "warehouse-auth", defaultWarehouseAuth, "IAM role ARN for Redshift's COPY, or Snowflake storage integration reading an object storage stage")
+	fs.IntVar(&config.WarehouseShardRows, "warehouse-shard-rows", defaultWarehouseShardRows, "Results per staged shard")
+	fs.StringVar(&config.InputFormat, "format", defaultInputFormat, "Input format: json, jsonl, csv, tsv, txt (one address per line), vcf (vCards), outlook (Outlook contacts CSV), or auto to go by the file extension")
+	fs.StringVar(&config.InputColumn, "input-column", defaultInputColumn, "Column of CSV/TSV input holding the address: header name, or position starting at 1")
+	fs.StringVar(&config.InputIDColumn, "input-id-column", defaultInputIDColumn, "Column of CSV/TSV input holding the record ID (optional)")
+	fs.BoolVar(&config.InputHeader, "input-header", defaultInputHeader, "CSV/TSV input starts with a header row")
+	fs.IntVar(&config.UploadPartSize, "upload-part-size", defaultUploadPartSize, "Part size in MB for multipart uploads of s3:// and gs:// outputs (minimum 5)")
+	fs.IntVar(&config.UploadRetries, "upload-retries", defaultUploadRetries, "Retries per upload request on network and server errors")
+	fs.StringVar(&config.ManifestFile, "manifest", defaultManifestFile, "Optional JSON manifest listing every artifact with its SHA-256 checksum and record count")
+	fs.StringVar(&config.SignKey, "sign-key", defaultSignKey, "minisign secret key to sign the manifest with (requires -manifest; password in SIGN_KEY_PASSWORD)")
+	fs.StringVar(&config.SortBy, "sort-by", defaultSortBy, "Sort the output by reason, domain or email instead of completion order")
+	fs.StringVar(&config.GroupBy, "group-by", defaultGroupBy, "Group the output by domain")
+	fs.IntVar(&config.OutputIndent, "output-indent", defaultOutputIndent, "Spaces per nesting level in the JSON output and details files")
+	fs.BoolVar(&config.OutputCompact, "output-compact", defaultOutputCompact, "Write the JSON output and details files minified onto a single line")
+	fs.StringVar(&config.OutputColumns, "columns", defaultOutputColumns, "Columns of .csv and .tsv outputs: field, field:header or =value:header for static columns")
+	fs.BoolVar(&config.OutputHeader, "output-header", defaultOutputHeader, "Write a header row in .csv and .tsv outputs")
+	fs.StringVar(&config.OutputFileFormat, "output-format", defaultOutputFileFormat, "Format of the output and details files: json, jsonl, csv, tsv, or auto to go by the file extension")
+	fs.StringVar(&config.DetailColumns, "details-columns", defaultDetailColumns, "Columns of .csv and .tsv details files: field, field:header or =value:header for static columns")
+	fs.StringVar(&config.ValidityWindows, "validity", defaultValidityWindows, "How long verdicts stay valid per type before results expire (e.g. valid=90d,risky=30d,invalid=180d,error=1d)")
+	fs.BoolVar(&config.Actions, "actions", defaultActions, "Recommend an action for each address that didn't pass: delete, quarantine or retry")
+	fs.StringVar(&config.QuarantinePeriod, "quarantine-period", defaultQuarantinePeriod, "How long quarantined addresses are held before review with -actions (e.g. 30d)")
+
+	config.RDAPURL = getEnvString("RDAP_URL", config.RDAPURL)
+	config.HIBPURL = getEnvString("HIBP_URL", config.HIBPURL)
+	config.HIBPAPIKey = getEnvString("HIBP_API_KEY", config.HIBPAPIKey)
+	config.CompanyProvider = getEnvString("COMPANY_PROVIDER", config.CompanyProvider)
+	config.CompanyAPIURL = getEnvString("COMPANY_API_URL", config.CompanyAPIURL)
+	config.CompanyAPIKey = getEnvString("COMPANY_API_KEY", config.CompanyAPIKey)
+	config.GeoIPURL = getEnvString("GEOIP_URL", config.GeoIPURL)
+	config.TLDListURL = getEnvString("TLD_LIST_URL", config.TLDListURL)
+	config.TLDCacheFile = getEnvString("TLD_CACHE_FILE", config.TLDCacheFile)
+	config.EgressIPURL = getEnvString("EGRESS_IP_URL", config.EgressIPURL)
+	config.RateLimitPrefix = getEnvString("RATE_LIMIT_REDIS_PREFIX", config.RateLimitPrefix)
+	config.BigQueryEndpoint = getEnvString("BIGQUERY_ENDPOINT", config.BigQueryEndpoint)
+	config.GoogleCredentials = getEnvString("GOOGLE_APPLICATION_CREDENTIALS", config.GoogleCredentials)
+	config.GCEMetadataHost = getEnvString("GCE_METADATA_HOST", config.GCEMetadataHost)
+	config.ElasticsearchAPIKey = getEnvString("ELASTICSEARCH_API_KEY", config.ElasticsearchAPIKey)
+	config.ElasticsearchUsername = getEnvString("ELASTICSEARCH_USERNAME", config.ElasticsearchUsername)
+	config.ElasticsearchPassword = getEnvString("ELASTICSEARCH_PASSWORD", config.ElasticsearchPassword)
+	config.ElasticsearchCACert = getEnvString("ELASTICSEARCH_CA_CERT", config.ElasticsearchCACert)
+	config.ClickHouseUser = getEnvString("CLICKHOUSE_USER", config.ClickHouseUser)
+	config.ClickHousePassword = getEnvString("CLICKHOUSE_PASSWORD", config.ClickHousePassword)
+	config.LDAPBindPassword = getEnvString("LDAP_BIND_PASSWORD", config.LDAPBindPassword)
+	config.SheetsEndpoint = getEnvString("SHEETS_ENDPOINT", config.SheetsEndpoint)
+	config.SignKeyPassword = getEnvString("SIGN_KEY_PASSWORD", config.SignKeyPassword)
+}
@@ -0,0 +1,27 @@
+package cli
+
+import (
+	"flag"
+	"testing"
+
+	"email-verification/internal/verify"
+)
+
+func TestAddressListFlagAliases(t *testing.T) {
+	t.Setenv("ALLOWLIST_FILE", "")
+	t.Setenv("BLOCKLIST_FILE", "")
+	t.Setenv("ALLOW_LIST_FILE", "allowed.txt")
+	t.Setenv("SUPPRESS_LIST_FILE", "suppressed.txt")
+	var config verify.Config
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	configFlags(fs, &config)
+	if config.Allowlist != "allowed.txt" || config.Blocklist != "suppressed.txt" {
+		t.Errorf("older variables gave allowlist %q, blocklist %q", config.Allowlist, config.Blocklist)
+	}
+	if err := fs.Parse([]string{"-allowlist=a.txt", "-suppress-list=b.txt"}); err != nil {
+		t.Fatal(err)
+	}
+	if config.Allowlist != "a.txt" || config.Blocklist != "b.txt" {
+		t.Errorf("flags gave allowlist %q, blocklist %q", config.Allowlist, config.Blocklist)
+	}
+}
@@ -0,0 +1,15 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunDoctor checks that the machine is fit for verification with the given settings. It ends
+// with status 1 if a check failed.
+func RunDoctor(args []string) error {
+	fs := newFlagSet("doctor", "[flags]", "Checks DNS, outbound SMTP, the egress IP and the configured extensions.")
+	domain := fs.String("domain", "gmail.com", "Domain whose mail servers the DNS and SMTP checks use")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	return verify.Doctor(config, *domain)
+}
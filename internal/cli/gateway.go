@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"time"
+
+	"email-verification/internal/verify"
+)
+
+// RunGateway answers RCPT TO over SMTP with the verdict of verifying the recipient
+func RunGateway(args []string) error {
+	fs := newFlagSet("gateway", "[flags]", "Answers RCPT TO over SMTP with the verdict of verifying the recipient.")
+	var opts verify.GatewayOptions
+	fs.StringVar(&opts.Listen, "listen", getEnvString("GATEWAY_LISTEN_ADDR", ":2525"), "Address to accept SMTP connections on")
+	fs.StringVar(&opts.Risky, "risky", getEnvString("GATEWAY_RISKY", "accept"), "Answer for risky recipients: accept, reject or tempfail")
+	fs.StringVar(&opts.Unknown, "unknown", getEnvString("GATEWAY_UNKNOWN", "tempfail"), "Answer for recipients that couldn't be verified (timeouts, greylisting, deferrals): accept, reject or tempfail")
+	fs.DurationVar(&opts.CacheTTL, "cache-ttl", getEnvDuration("GATEWAY_CACHE_TTL", time.Hour), "How long valid, invalid and risky verdicts are reused for repeated recipients (0 disables)")
+	fs.IntVar(&opts.MaxConnections, "max-connections", getEnvInt("GATEWAY_MAX_CONNECTIONS", 100), "Most SMTP connections served at once; more are turned away with 421")
+	fs.DurationVar(&opts.IdleTimeout, "idle-timeout", getEnvDuration("GATEWAY_IDLE_TIMEOUT", 5*time.Minute), "Close connections that send no command for this long")
+	fs.IntVar(&opts.MaxRecipients, "max-recipients", getEnvInt("GATEWAY_MAX_RECIPIENTS", 100), "Most recipients verified per transaction; more get 452")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	return verify.ServeGateway(config, opts)
+}
@@ -0,0 +1,21 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunGenerate writes a synthetic email list for load testing the pipeline and downstream systems
+func RunGenerate(args []string) error {
+	fs := newFlagSet("generate", "[flags]", "Generates a synthetic email list for load testing.")
+	var opts verify.GenerateOptions
+	output := fs.String("output", verify.StdoutOutput, "Output file (.json, .jsonl, .csv, .tsv or .txt), or - for one address per line on stdout")
+	fs.IntVar(&opts.Count, "count", 10000, "Number of addresses to generate")
+	fs.Uint64Var(&opts.Seed, "seed", 1, "Random seed; the same seed and options generate the same list")
+	fs.StringVar(&opts.Domains, "domains", verify.DefaultGenerateDomains, "Domain distribution as domain=weight pairs, with * for company domains")
+	fs.IntVar(&opts.CompanyDomains, "company-domains", 500, "Number of distinct company domains * draws from")
+	fs.Float64Var(&opts.TypoRatio, "typos", 0.02, "Share of addresses with a misspelled domain")
+	fs.Float64Var(&opts.DisposableRatio, "disposable", 0.02, "Share of addresses on disposable domains")
+	fs.Float64Var(&opts.InvalidRatio, "invalid", 0.03, "Share of addresses with broken syntax")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return verify.Generate(opts, *output)
+}
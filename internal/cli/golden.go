@@ -0,0 +1,16 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunGolden replays recorded fixtures through the verdict rules and compares the verdicts against
+// golden files. It ends with status 1 if any verdict differs or has no golden file.
+func RunGolden(args []string) error {
+	fs := newFlagSet("golden", "[flags]", "Replays recorded results through the verdict rules and compares them against golden files.")
+	dir := fs.String("dir", verify.DefaultGoldenDir, "Directory of fixtures (*.json) and their golden verdicts (*.golden)")
+	update := fs.Bool("update", false, "Rewrite the golden files with the current verdicts")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	return verify.ReplayGolden(config, *dir, *update)
+}
@@ -0,0 +1,24 @@
+package cli
+
+import (
+	"time"
+
+	"email-verification/internal/verify"
+)
+
+// RunMilter verifies the recipients of an MTA over the milter protocol
+func RunMilter(args []string) error {
+	fs := newFlagSet("milter", "[flags]", "Verifies the recipients of an MTA over the milter protocol.")
+	var opts verify.MilterOptions
+	fs.StringVar(&opts.Listen, "listen", getEnvString("MILTER_LISTEN_ADDR", "inet:127.0.0.1:8899"), "Socket to serve the MTA on: inet:host:port, host:port or unix:/path")
+	fs.StringVar(&opts.Domains, "domains", getEnvString("MILTER_DOMAINS", ""), "Comma-separated recipient domains to verify; others pass unchecked (default: all)")
+	fs.StringVar(&opts.Risky, "risky", getEnvString("MILTER_RISKY", "accept"), "Answer for risky recipients: accept, reject or tempfail")
+	fs.StringVar(&opts.Unknown, "unknown", getEnvString("MILTER_UNKNOWN", "accept"), "Answer for recipients that couldn't be verified (timeouts, greylisting, deferrals): accept, reject or tempfail")
+	fs.DurationVar(&opts.CacheTTL, "cache-ttl", getEnvDuration("MILTER_CACHE_TTL", time.Hour), "How long valid, invalid and risky verdicts are reused for repeated recipients (0 disables)")
+	fs.DurationVar(&opts.Timeout, "timeout", getEnvDuration("MILTER_TIMEOUT", 20*time.Second), "Deadline for verifying a recipient; keep it below the MTA's milter command timeout (Postfix: 30s)")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	return verify.ServeMilter(config, opts)
+}
@@ -0,0 +1,19 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunMockMX serves mock DNS and SMTP until interrupted
+func RunMockMX(args []string) error {
+	fs := newFlagSet("mock-mx", "[flags]", "Serves mock DNS and SMTP for end-to-end tests of probing.")
+	var opts verify.MockMXOptions
+	fs.StringVar(&opts.SMTPListen, "smtp-listen", ":25", "SMTP listen address; probes connect to its port with -smtp-port")
+	fs.StringVar(&opts.DNSListen, "dns-listen", "127.0.0.1:5353", "DNS listen address (UDP), to pass as -resolver")
+	fs.StringVar(&opts.IP, "ip", "127.0.0.1", "IPv4 address the MX hosts resolve to (empty for IPv6-only hosts)")
+	fs.StringVar(&opts.IPv6, "ipv6", "", "IPv6 address the MX hosts resolve to, such as ::1 (empty for IPv4-only hosts)")
+	fs.StringVar(&opts.Behaviors, "behaviors", "*=reject", "Mailbox behaviors as key=action[:delay] pairs, keyed by address, *@domain or *; actions are accept, reject, greylist, tarpit and nomx")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "Log every DNS query and RCPT")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return verify.ServeMockMX(opts)
+}
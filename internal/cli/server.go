@@ -0,0 +1,46 @@
+package cli
+
+import (
+	"os"
+	"time"
+
+	"email-verification/internal/verify"
+)
+
+// RunServe starts the HTTP API: batch verification jobs and the domain intelligence
+// accumulated by past runs
+func RunServe(args []string) error {
+	fs := newFlagSet("serve", "[flags]", "")
+	opts := verify.ServeOptions{Token: getEnvString("API_TOKEN", "")}
+	fs.StringVar(&opts.Listen, "listen", getEnvString("LISTEN_ADDR", ":8080"), "Address to listen on")
+	fs.IntVar(&opts.QueueSize, "queue", getEnvInt("JOB_QUEUE_SIZE", 16), "Maximum number of jobs waiting to run")
+	fs.DurationVar(&opts.Retention, "job-retention", getEnvDuration("JOB_RETENTION", 24*time.Hour), "How long finished jobs and their results are kept (0 keeps them until restart)")
+	fs.IntVar(&opts.MaxPending, "max-pending", getEnvInt("MAX_PENDING_EMAILS", 0), "Turn jobs away while more addresses than this are waiting to be checked (0 = no limit)")
+	fs.IntVar(&opts.MaxMemory, "max-memory", getEnvInt("MAX_MEMORY_MB", 0), "Turn jobs away while the heap in use exceeds this many MB (0 = no limit)")
+	fs.BoolVar(&opts.Distributed, "distributed", getEnvBool("DISTRIBUTED", false), "Hand jobs to worker processes in work units instead of verifying them here")
+	fs.IntVar(&opts.UnitSize, "unit-size", getEnvInt("WORK_UNIT_SIZE", 1000), "Addresses per work unit in distributed mode")
+	fs.IntVar(&opts.CheckpointSize, "checkpoint-size", getEnvInt("WORK_CHECKPOINT_SIZE", 100), "Addresses workers verify between checkpoints of a work unit (0 = report whole units)")
+	fs.DurationVar(&opts.WorkerTimeout, "worker-timeout", getEnvDuration("WORKER_TIMEOUT", time.Minute), "Reassign a work unit when its worker misses heartbeats for this long")
+	fs.BoolVar(&opts.Operator, "kubernetes", getEnvBool("KUBERNETES_OPERATOR", false), "Run VerificationJob resources of the server's namespace on worker pods (requires -distributed)")
+	fs.StringVar(&opts.KubeAPI, "kube-api", getEnvString("KUBE_API_URL", ""), "Kubernetes API server URL, e.g. from kubectl proxy (default: the cluster the server runs in)")
+	fs.BoolVar(&opts.LeaderElect, "leader-elect", getEnvBool("LEADER_ELECT", false), "Elect a leader among server instances with a Kubernetes Lease; only the leader runs the operator and reports ready")
+	fs.StringVar(&opts.LeaseName, "lease-name", getEnvString("LEADER_LEASE_NAME", "email-verification"), "Name of the Lease used for leader election")
+	fs.DurationVar(&opts.LeaseDuration, "lease-duration", getEnvDuration("LEADER_LEASE_DURATION", 15*time.Second), "How long a leader that stopped renewing keeps the Lease")
+	hostname, _ := os.Hostname()
+	fs.StringVar(&opts.Identity, "identity", getEnvString("POD_NAME", hostname), "Name this instance holds the Lease under")
+	fs.DurationVar(&opts.Resync, "resync", getEnvDuration("KUBERNETES_RESYNC", 10*time.Second), "How often the operator reconciles VerificationJobs")
+	fs.StringVar(&opts.ServiceURL, "service-url", getEnvString("SERVICE_URL", "http://email-verification:8080"), "URL worker pods reach this server at")
+	fs.StringVar(&opts.WorkerImage, "worker-image", getEnvString("WORKER_IMAGE", ""), "Container image of the worker pods")
+	fs.StringVar(&opts.WorkerEnvSecret, "worker-env-secret", getEnvString("WORKER_ENV_SECRET", ""), "Secret whose keys are set in the worker pods' environment")
+	fs.IntVar(&opts.MaxWorkers, "max-job-workers", getEnvInt("MAX_JOB_WORKERS", 0), "Most workers a job may ask for (0 = the -workers setting)")
+	fs.StringVar(&opts.MinJobRate, "min-job-rate", getEnvString("MIN_JOB_RATE", ""), "Shortest rate limit a job may ask for (default: the -rate setting)")
+	fs.StringVar(&opts.GRPCListen, "grpc-listen", getEnvString("GRPC_LISTEN_ADDR", ""), "Address to serve the gRPC Verifier service on (see proto/verification.proto); empty disables it")
+	fs.IntVar(&opts.MaxBatch, "max-batch", getEnvInt("MAX_BATCH_SIZE", 1000), "Most addresses a POST /verify/batch request may hold; larger lists go through /jobs")
+	fs.IntVar(&opts.FeedSize, "connector-feed", getEnvInt("CONNECTOR_FEED_SIZE", 10000), "Latest results kept for the /connector/results polling endpoint (0 disables it)")
+	fs.StringVar(&opts.APIKeys, "api-keys", getEnvString("API_KEYS", ""), "Comma-separated API keys accepted in the X-API-Key header or api_key parameter, as no-code platforms send them")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	return verify.Serve(config, opts)
+}
@@ -0,0 +1,17 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunStats summarizes details or checkpoint files of earlier runs
+func RunStats(args []string) error {
+	fs := newFlagSet("stats", "[flags] results-file...", "Summarizes -details outputs (JSON or JSON Lines) and -checkpoint journals.")
+	top := fs.Int("top", 10, "Most reasons and domains to list")
+	asJSON := fs.Bool("json", false, "Print the summary as JSON")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageError(fs)
+	}
+	return verify.PrintStats(fs.Args(), *top, *asJSON)
+}
@@ -0,0 +1,13 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunUpload resumes the uploads of staged outputs that an earlier run could not finish
+func RunUpload(args []string) error {
+	fs := newFlagSet("upload", "[flags]", "Resumes the uploads of outputs staged in data/uploads.")
+	retries := fs.Int("retries", getEnvInt("UPLOAD_RETRIES", 5), "Retries per request on network and server errors")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	return verify.ResumeUploads(*retries)
+}
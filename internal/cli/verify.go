@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"email-verification/internal/verify"
+)
+
+// RunVerify verifies an input file, the tool's main job
+func RunVerify(args []string) error {
+	config, err := verifyConfig("verify", "Verifies the addresses of an input file and writes the invalid ones.", args)
+	if err != nil {
+		return err
+	}
+	return interrupted(verify.VerifyFile(config))
+}
+
+// RunResume continues the interrupted run journaled in the checkpoint
+func RunResume(args []string) error {
+	config, err := verifyConfig("resume", "Continues the interrupted run journaled in -checkpoint, with the same input and flags.", args)
+	if err != nil {
+		return err
+	}
+	return interrupted(verify.ResumeFile(config))
+}
+
+// interrupted ends an interrupted run with status 1, so scripts don't mistake its partial results
+// for a finished run; the run has logged what it got through
+func interrupted(err error) error {
+	if errors.Is(err, verify.ErrInterrupted) {
+		return &ExitError{Code: 1}
+	}
+	return err
+}
+
+// verifyConfig parses the flags and [input] [output] arguments of a verification run
+func verifyConfig(name, about string, args []string) (verify.Config, error) {
+	fs := newFlagSet(name, "[flags] [input] [output]", about)
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return config, err
+	}
+
+	// Override with positional arguments for backwards compatibility
+	if args := fs.Args(); len(args) > 0 {
+		config.InputFile = args[0]
+		if len(args) > 1 {
+			config.OutputFile = args[1]
+		}
+	}
+
+	// Emails piped in are read from stdin unless an input file was named
+	if !inputNamed(fs) && stdinPiped() {
+		config.InputFile = verify.StdinInput
+	}
+	return config, nil
+}
+
+// inputNamed reports whether an input file was given as a flag, argument or environment variable
+func inputNamed(fs *flag.FlagSet) bool {
+	named := fs.NArg() > 0 || os.Getenv("INPUT_FILE") != ""
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "input" {
+			named = true
+		}
+	})
+	return named
+}
+
+// stdinPiped reports whether stdin is a pipe or file rather than a terminal
+func stdinPiped() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice == 0
+}
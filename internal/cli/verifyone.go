@@ -0,0 +1,44 @@
+package cli
+
+import "email-verification/internal/verify"
+
+// RunVerifyOne verifies a single address with every configured check and prints the full result.
+// It ends with status 1 if the address is not valid.
+func RunVerifyOne(args []string) error {
+	result, err := verifySingleCommand("verify-one", args)
+	if err != nil {
+		return err
+	}
+	if !result.IsValid {
+		return &ExitError{Code: 1}
+	}
+	return nil
+}
+
+// RunCheck verifies a single address like verify-one, ending with a status telling the verdict
+// apart: valid, invalid, risky, or unknown when verification failed or the server kept deferring
+func RunCheck(args []string) error {
+	result, err := verifySingleCommand("check", args)
+	if err != nil {
+		return err
+	}
+	if status := verify.CheckStatus(result); status != verify.CheckValid {
+		return &ExitError{Code: status}
+	}
+	return nil
+}
+
+// verifySingleCommand parses the flags of a single-address command and verifies the address they
+// name, printing the full result
+func verifySingleCommand(name string, args []string) (verify.EmailResult, error) {
+	fs := newFlagSet(name, "[flags] user@example.com", "")
+	asJSON := fs.Bool("json", false, "Print the result as JSON")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return verify.EmailResult{}, err
+	}
+	if fs.NArg() != 1 {
+		return verify.EmailResult{}, usageError(fs)
+	}
+	return verify.VerifyOne(config, fs.Arg(0), *asJSON)
+}
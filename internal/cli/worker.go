@@ -0,0 +1,25 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"email-verification/internal/verify"
+)
+
+// RunWorker verifies work units leased from a server running in distributed mode
+func RunWorker(args []string) error {
+	fs := newFlagSet("worker", "[flags]", "")
+	opts := verify.WorkerOptions{Token: getEnvString("API_TOKEN", "")}
+	fs.StringVar(&opts.ServerURL, "server", getEnvString("SERVER_URL", "http://localhost:8080"), "Base URL of a server started with serve -distributed")
+	hostname, _ := os.Hostname()
+	fs.StringVar(&opts.Name, "name", getEnvString("WORKER_NAME", fmt.Sprintf("%s-%d", hostname, os.Getpid())), "Name the worker reports to the server")
+	fs.DurationVar(&opts.Poll, "poll", getEnvDuration("WORKER_POLL", 2*time.Second), "How often to ask for work while there is none")
+	fs.StringVar(&opts.MetricsListen, "metrics-listen", getEnvString("WORKER_METRICS_ADDR", ""), "Address to serve the worker's /metrics on (empty disables)")
+	config, err := parseConfig(fs, args)
+	if err != nil {
+		return err
+	}
+	return verify.Work(config, opts)
+}
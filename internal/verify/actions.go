@@ -0,0 +1,80 @@
+package verify
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Recommended actions for addresses that didn't pass, with -actions
+const (
+	actionDelete     = "delete"     // can never receive mail: purge now
+	actionQuarantine = "quarantine" // likely bad, but suppress and review rather than purge
+	actionRetry      = "retry"      // couldn't be verified: check again next run
+)
+
+// actionDeleteConfidence is the confidence a mailbox rejection needs for deletion, that of
+// providers without a specific strategy; less trustworthy rejections are quarantined
+const actionDeleteConfidence = 0.8
+
+// deleteReasons are the verdicts, as evaluateResult gives them, that prove an address dead
+var deleteReasons = map[string]bool{
+	"invalid email syntax":     true,
+	"disposable email address": true,
+	"domain has no MX records": true,
+	"email is not deliverable": true,
+	blocklistedReason:          true, // suppressed for good by contract
+}
+
+// ActionPolicy recommends what list hygiene should do with each address that didn't pass, rather
+// than a binary purge: delete the provably dead, quarantine the doubtful for a review period, and
+// retry those that couldn't be verified
+type ActionPolicy struct {
+	quarantine time.Duration
+}
+
+// newActionPolicy creates the policy from a quarantine period (a Go duration or days, as 30d)
+func newActionPolicy(quarantine string) (*ActionPolicy, error) {
+	period, err := parseWindow(strings.TrimSpace(quarantine))
+	if err != nil || period <= 0 {
+		return nil, fmt.Errorf("invalid quarantine period %q", quarantine)
+	}
+	return &ActionPolicy{quarantine: period}, nil
+}
+
+// Stamp records the recommended action of a result, and for quarantined ones when to review
+// them. Valid results get none.
+func (p *ActionPolicy) Stamp(result *EmailResult) {
+	if p == nil {
+		return
+	}
+	result.Action, result.ReviewAfter = p.action(*result), nil
+	if result.Action == actionQuarantine {
+		review := result.CheckedAt.Add(p.quarantine)
+		result.ReviewAfter = &review
+	}
+}
+
+func (p *ActionPolicy) action(result EmailResult) string {
+	switch {
+	case result.IsValid:
+		return ""
+	case result.Risky:
+		return actionQuarantine
+	// A typo may be corrected by the owner of the list, so it isn't a reason to purge
+	case strings.HasPrefix(result.Reason, "possible typo"):
+		return actionQuarantine
+	case result.errored, result.Greylisted, result.Deferred:
+		return actionRetry
+	case deleteReasons[result.Reason]:
+		// Providers that accept or reject every probe make rejections less conclusive; no
+		// confidence means no strategy rated the provider
+		if result.Reason == "email is not deliverable" && result.Confidence > 0 && result.Confidence < actionDeleteConfidence {
+			return actionQuarantine
+		}
+		return actionDelete
+	}
+	// Policy rejections (role accounts, free providers, countries, custom checks and verdict
+	// expressions) and disabled or unreachable mailboxes
+	return actionQuarantine
+}
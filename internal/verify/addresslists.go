@@ -0,0 +1,104 @@
+package verify
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// blocklistedReason is the reason of addresses on the -blocklist
+const blocklistedReason = "blocklisted"
+
+// AddressList is an allowlist or blocklist read from a file listing one entry per line, with #
+// comments. An entry is an exact address (jane@example.com), a domain (example.com or
+// @example.com), or a pattern with * wildcards, matched against the domain when it has no @
+// (*.example.com) and else against the whole address (*@example.com, sales-*@example.com).
+// A * matches any run of characters, / included, as local parts may contain it.
+type AddressList struct {
+	emails   map[string]bool
+	domains  map[string]bool
+	patterns []string // domain patterns, and address patterns containing an @
+}
+
+// loadAddressList reads the list in a file
+func loadAddressList(file string) (*AddressList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open address list: %w", err)
+	}
+	defer f.Close()
+	entries := make(map[string]bool)
+	if err := scanDomainList(f, entries); err != nil {
+		return nil, fmt.Errorf("failed to read address list %s: %w", file, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries in address list %s", file)
+	}
+
+	l := &AddressList{emails: make(map[string]bool), domains: make(map[string]bool)}
+	for entry := range entries {
+		switch {
+		case strings.Contains(entry, "*"):
+			l.patterns = append(l.patterns, entry)
+		case strings.HasPrefix(entry, "@"):
+			l.domains[strings.TrimSuffix(entry[1:], ".")] = true
+		case strings.Contains(entry, "@"):
+			l.emails[entry] = true
+		default:
+			l.domains[strings.TrimSuffix(entry, ".")] = true
+		}
+	}
+	return l, nil
+}
+
+// Match reports whether an address is on the list
+func (l *AddressList) Match(email string) bool {
+	if l == nil {
+		return false
+	}
+	email = strings.ToLower(strings.TrimSpace(email))
+	if l.emails[email] {
+		return true
+	}
+	domain := strings.TrimSuffix(emailDomain(email), ".")
+	if l.domains[domain] {
+		return true
+	}
+	for _, pattern := range l.patterns {
+		subject := domain
+		if strings.Contains(pattern, "@") {
+			subject = email
+		}
+		if globMatch(pattern, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns how many entries are listed
+func (l *AddressList) Len() int {
+	return len(l.emails) + len(l.domains) + len(l.patterns)
+}
+
+// globMatch reports whether s matches pattern, in which * stands for any run of characters and
+// every other character for itself
+func globMatch(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return len(s) >= len(last) && strings.HasSuffix(s, last)
+}
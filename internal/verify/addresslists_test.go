@@ -0,0 +1,105 @@
+package verify
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddressList(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "list.txt")
+	list := `# Suppressed on request
+Jane@Example.com
+@competitor.com
+partner.org.
+*.internal.example.com
+sales-*@example.com
+*/*@slashes.test
+`
+	if err := os.WriteFile(file, []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+	l, err := loadAddressList(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Len() != 6 {
+		t.Errorf("Len() = %d, want 6", l.Len())
+	}
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		// Exact addresses, compared case-insensitively
+		{"jane@example.com", true},
+		{" JANE@EXAMPLE.COM ", true},
+		{"john@example.com", false},
+		// Domains, with or without @, cover that domain only
+		{"anyone@competitor.com", true},
+		{"anyone@mail.competitor.com", false},
+		{"anyone@partner.org", true},
+		{"anyone@partner.org.uk", false},
+		// Domain patterns
+		{"ops@eu.internal.example.com", true},
+		{"ops@internal.example.com", false},
+		// Address patterns
+		{"sales-emea@example.com", true},
+		{"sales-@example.com", true},
+		{"sales@example.com", false},
+		{"sales-emea@example.org", false},
+		// A * matches across /, a legal local-part character
+		{"sales-a/b@example.com", true},
+		{"a/b@slashes.test", true},
+		{"ab@slashes.test", false},
+		{"not-an-email", false},
+	}
+	for _, tt := range tests {
+		if got := l.Match(tt.email); got != tt.want {
+			t.Errorf("Match(%q) = %v, want %v", tt.email, got, tt.want)
+		}
+	}
+
+	var none *AddressList
+	if none.Match("jane@example.com") {
+		t.Error("nil list matched")
+	}
+}
+
+func TestAddressListErrors(t *testing.T) {
+	if _, err := loadAddressList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("missing file loaded")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	os.WriteFile(empty, []byte("# only comments\n\n"), 0644)
+	if _, err := loadAddressList(empty); err == nil {
+		t.Error("list without entries loaded")
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"*", "", true},
+		{"*", "a/b", true},
+		{"a*", "a", true},
+		{"*c", "abc", true},
+		{"a*c", "ac", true},
+		{"a*c", "ab/c", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "acb", false},
+		{"*aa", "aa", true},
+		{"aa*aa", "aaa", false},
+		{"[a]*", "[a]x", true},
+		{"a?c", "abc", false},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.s); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"time"
+)
+
+// Reasons a job is turned away until the server has capacity
+var (
+	errBacklogFull    = errors.New("too many addresses waiting to be checked")
+	errMemoryPressure = errors.New("server is low on memory")
+)
+
+// AdmissionLimits bound the work the server accepts; zero disables a limit
+type AdmissionLimits struct {
+	MaxPending  int // addresses queued or not yet checked, across jobs
+	MaxMemoryMB int // heap in use
+}
+
+// admissionError is a job turned away for now; RetryAfter estimates when there will be room
+type admissionError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *admissionError) Error() string { return e.Err.Error() }
+func (e *admissionError) Unwrap() error { return e.Err }
+
+// Admit checks whether a job of n addresses fits the server's capacity right now. A job is
+// always admitted when nothing else is pending, however large, so it can't be starved.
+func (m *JobManager) Admit(n int) error {
+	if m.limits.MaxMemoryMB > 0 {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		if inUse := mem.HeapInuse >> 20; inUse > uint64(m.limits.MaxMemoryMB) {
+			return &admissionError{
+				Err:        fmt.Errorf("%w: %d MB heap in use, limit %d MB", errMemoryPressure, inUse, m.limits.MaxMemoryMB),
+				RetryAfter: m.retryAfter(-1),
+			}
+		}
+	}
+	if m.limits.MaxPending > 0 {
+		if pending, _ := m.pending(); pending > 0 && pending+int64(n) > int64(m.limits.MaxPending) {
+			return &admissionError{
+				Err:        fmt.Errorf("%w: %d pending, limit %d", errBacklogFull, pending, m.limits.MaxPending),
+				RetryAfter: m.retryAfter(pending + int64(n) - int64(m.limits.MaxPending)),
+			}
+		}
+	}
+	return nil
+}
+
+// pending counts the addresses of queued and running jobs not yet checked, and the running
+// job's throughput in addresses per second
+func (m *JobManager) pending() (int64, float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var pending int64
+	var throughput float64
+	for _, j := range m.jobs {
+		status := j.snapshot()
+		switch status.Status {
+		case jobQueued:
+			pending += int64(status.Total)
+		case jobRunning:
+			pending += int64(status.Total) - status.Checked
+			j.mu.Lock()
+			started := j.stats.StartTime
+			j.mu.Unlock()
+			if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+				throughput = float64(status.Checked) / elapsed
+			}
+		}
+	}
+	return pending, throughput
+}
+
+// retryAfter estimates how long until the given number of addresses are checked, or all pending
+// ones if negative, between a second and an hour
+func (m *JobManager) retryAfter(addresses int64) time.Duration {
+	pending, throughput := m.pending()
+	if throughput <= 0 {
+		return time.Minute
+	}
+	if addresses < 0 {
+		addresses = pending
+	}
+	wait := time.Duration(float64(addresses) / throughput * float64(time.Second))
+	return min(max(wait, time.Second), time.Hour)
+}
@@ -0,0 +1,372 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BigQuery write modes
+const (
+	bigQueryStream = "stream" // streaming inserts, queryable within seconds
+	bigQueryLoad   = "load"   // one free load job at the end of the run
+)
+
+const (
+	defaultBigQueryEndpoint = "https://bigquery.googleapis.com"
+	bigQueryScope           = "https://www.googleapis.com/auth/bigquery"
+	bigQueryRetries         = 4
+	// bigQueryJobTimeout bounds the wait for a load job; it keeps running on its own after that
+	bigQueryJobTimeout = 30 * time.Minute
+)
+
+// bigQuerySchema is the schema of a results table created by the sink. The full result is kept
+// as JSON too, so fields without a column of their own can still be queried.
+var bigQuerySchema = []map[string]string{
+	{"name": "email", "type": "STRING", "mode": "REQUIRED"},
+	{"name": "domain", "type": "STRING"},
+	{"name": "valid", "type": "BOOL"},
+	{"name": "risky", "type": "BOOL"},
+	{"name": "reason", "type": "STRING"},
+	{"name": "reachable", "type": "STRING"},
+	{"name": "disposable", "type": "BOOL"},
+	{"name": "role_account", "type": "BOOL"},
+	{"name": "free", "type": "BOOL"},
+	{"name": "confidence", "type": "FLOAT64"},
+	{"name": "country", "type": "STRING"},
+	{"name": "greylisted", "type": "BOOL"},
+	{"name": "catch_all", "type": "BOOL"},
+	{"name": "deferred", "type": "BOOL"},
+	{"name": "policy", "type": "STRING"},
+	{"name": "probed_as", "type": "STRING"},
+	{"name": "checked_at", "type": "TIMESTAMP", "mode": "REQUIRED"},
+	{"name": "expires_at", "type": "TIMESTAMP"},
+	{"name": "result", "type": "JSON"},
+}
+
+// BigQuerySink writes every result to a BigQuery table, creating it if needed. Streaming inserts
+// send rows in batches as they come in; load mode collects them in a temporary file and loads
+// it with a single job once the run is done.
+type BigQuerySink struct {
+	auth     *GoogleAuth
+	endpoint string
+	project  string
+	dataset  string
+	table    string
+	mode     string
+	batch    int
+
+	mu       sync.Mutex
+	rows     []map[string]any // streaming inserts not sent yet
+	load     *os.File         // rows waiting for the load job
+	loadBuf  *bufio.Writer
+	loadRows int
+}
+
+func newBigQuerySink(config Config) (*BigQuerySink, error) {
+	// Load files may take long to upload, but BigQuery should start answering within a minute
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, ResponseHeaderTimeout: time.Minute}}
+	auth, err := newGoogleAuth(client, config.GoogleCredentials, config.GCEMetadataHost, bigQueryScope)
+	if err != nil {
+		return nil, err
+	}
+	sink := &BigQuerySink{
+		auth:     auth,
+		endpoint: strings.TrimSuffix(config.BigQueryEndpoint, "/"),
+		project:  config.BigQueryProject,
+		dataset:  config.BigQueryDataset,
+		table:    config.BigQueryTable,
+		mode:     config.BigQueryMode,
+		batch:    config.BigQueryBatch,
+	}
+	// The table may name its dataset and project too
+	parts := strings.Split(sink.table, ".")
+	switch len(parts) {
+	case 2:
+		sink.dataset, sink.table = parts[0], parts[1]
+	case 3:
+		sink.project, sink.dataset, sink.table = parts[0], parts[1], parts[2]
+	}
+	if sink.project == "" {
+		sink.project = auth.ProjectID()
+	}
+	if sink.project == "" {
+		return nil, fmt.Errorf("no project for table %s, set -bigquery-project", sink.table)
+	}
+	if sink.dataset == "" {
+		return nil, fmt.Errorf("no dataset for table %s, set -bigquery-dataset", sink.table)
+	}
+
+	if err := sink.ensureTable(); err != nil {
+		return nil, err
+	}
+	if sink.mode == bigQueryLoad {
+		file, err := os.CreateTemp("", "bigquery-*.jsonl")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create load file: %w", err)
+		}
+		sink.load, sink.loadBuf = file, bufio.NewWriterSize(file, 1<<20)
+	}
+	slog.Info("writing results to BigQuery", "table", sink.tableID(), "mode", sink.mode)
+	return sink, nil
+}
+
+// tableID is the table's fully qualified name
+func (s *BigQuerySink) tableID() string {
+	return s.project + "." + s.dataset + "." + s.table
+}
+
+func (s *BigQuerySink) apiURL(path string, query url.Values) string {
+	target := s.endpoint + "/bigquery/v2/projects/" + url.PathEscape(s.project) + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target
+}
+
+func (s *BigQuerySink) tableURL(suffix string) string {
+	return s.apiURL("/datasets/"+url.PathEscape(s.dataset)+"/tables/"+url.PathEscape(s.table)+suffix, nil)
+}
+
+// ensureTable creates the table, partitioned by day of verification, unless it exists already
+func (s *BigQuerySink) ensureTable() error {
+	err := retryGoogle(bigQueryRetries, "BigQuery table lookup", func() error {
+		_, err := s.auth.do(http.MethodGet, s.tableURL(""), nil, nil)
+		return err
+	})
+	if !notFound(err) {
+		return err
+	}
+
+	table := map[string]any{
+		"tableReference":   map[string]string{"projectId": s.project, "datasetId": s.dataset, "tableId": s.table},
+		"schema":           map[string]any{"fields": bigQuerySchema},
+		"timePartitioning": map[string]string{"type": "DAY", "field": "checked_at"},
+	}
+	err = retryGoogle(bigQueryRetries, "BigQuery table creation", func() error {
+		_, err := s.auth.do(http.MethodPost, s.apiURL("/datasets/"+url.PathEscape(s.dataset)+"/tables", nil), table, nil)
+		return err
+	})
+	if notFound(err) {
+		return fmt.Errorf("dataset %s.%s does not exist: %w", s.project, s.dataset, err)
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("created BigQuery table", "table", s.tableID())
+	return nil
+}
+
+// bigQueryRow converts a result to a table row. Streaming inserts take JSON columns as strings,
+// load jobs as JSON values.
+func bigQueryRow(result EmailResult, load bool) map[string]any {
+	row := resultRow(result)
+	if data, err := json.Marshal(result); err == nil {
+		if load {
+			row["result"] = json.RawMessage(data)
+		} else {
+			row["result"] = string(data)
+		}
+	}
+	return row
+}
+
+// Write queues a result, sending a batch of streaming inserts once enough have come in
+func (s *BigQuerySink) Write(result EmailResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.mode == bigQueryLoad {
+		data, err := json.Marshal(bigQueryRow(result, true))
+		if err != nil {
+			return fmt.Errorf("failed to encode BigQuery row: %w", err)
+		}
+		if _, err := s.loadBuf.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write load file: %w", err)
+		}
+		s.loadRows++
+		return nil
+	}
+
+	s.rows = append(s.rows, bigQueryRow(result, false))
+	if len(s.rows) < s.batch {
+		return nil
+	}
+	// Callers log sink errors only with -verbose, but a lost batch matters
+	if err := s.insert(); err != nil {
+		slog.Warn("failed to write results to BigQuery", "table", s.tableID(), "error", err)
+		return err
+	}
+	return nil
+}
+
+// Flush sends the results queued so far: the rest of the streaming inserts, or the load job
+func (s *BigQuerySink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mode == bigQueryLoad {
+		return s.runLoadJob()
+	}
+	return s.insert()
+}
+
+// Close removes the load file. Runs flush the sink when they are done, so nothing is left.
+func (s *BigQuerySink) Close() error {
+	if s.load == nil {
+		return nil
+	}
+	s.load.Close()
+	return os.Remove(s.load.Name())
+}
+
+// insert sends the queued rows as streaming inserts. Rows carry an insert ID, so BigQuery drops
+// the duplicates a retried request would otherwise add.
+func (s *BigQuerySink) insert() error {
+	if len(s.rows) == 0 {
+		return nil
+	}
+	rows := make([]map[string]any, len(s.rows))
+	for i, row := range s.rows {
+		rows[i] = map[string]any{
+			"insertId": fmt.Sprint(row["email"], "/", row["checked_at"]),
+			"json":     row,
+		}
+	}
+	s.rows = s.rows[:0]
+
+	var response struct {
+		InsertErrors []struct {
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	// Tables created by older versions lack the columns added since, which are kept in result
+	body := map[string]any{"rows": rows, "ignoreUnknownValues": true}
+	err := retryGoogle(bigQueryRetries, "BigQuery insert", func() error {
+		_, err := s.auth.do(http.MethodPost, s.tableURL("/insertAll"), body, &response)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stream %d rows: %w", len(rows), err)
+	}
+	if len(response.InsertErrors) > 0 {
+		first := response.InsertErrors[0]
+		message := "unknown error"
+		if len(first.Errors) > 0 {
+			message = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("BigQuery rejected %d of %d rows: %s", len(response.InsertErrors), len(rows), message)
+	}
+	slog.Debug("streamed results to BigQuery", "table", s.tableID(), "rows", len(rows))
+	return nil
+}
+
+// bigQueryJob is the part of a job resource needed to follow it
+type bigQueryJob struct {
+	JobReference struct {
+		JobID    string `json:"jobId"`
+		Location string `json:"location"`
+	} `json:"jobReference"`
+	Status struct {
+		State       string `json:"state"`
+		ErrorResult *struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errorResult"`
+	} `json:"status"`
+	Statistics struct {
+		Load struct {
+			OutputRows string `json:"outputRows"`
+		} `json:"load"`
+	} `json:"statistics"`
+}
+
+// runLoadJob uploads the load file with a resumable upload, waits for its job to load it into
+// the table and starts a new file
+func (s *BigQuerySink) runLoadJob() error {
+	if s.loadRows == 0 {
+		return nil
+	}
+	if err := s.loadBuf.Flush(); err != nil {
+		return fmt.Errorf("failed to write load file: %w", err)
+	}
+	size, err := s.load.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fmt.Errorf("failed to read load file: %w", err)
+	}
+
+	config := map[string]any{
+		"configuration": map[string]any{
+			"load": map[string]any{
+				"destinationTable":    map[string]string{"projectId": s.project, "datasetId": s.dataset, "tableId": s.table},
+				"sourceFormat":        "NEWLINE_DELIMITED_JSON",
+				"writeDisposition":    "WRITE_APPEND",
+				"createDisposition":   "CREATE_NEVER",
+				"ignoreUnknownValues": true,
+			},
+		},
+	}
+	var job bigQueryJob
+	err = retryGoogle(bigQueryRetries, "BigQuery load job", func() error {
+		header, err := s.auth.do(http.MethodPost, s.endpoint+"/upload/bigquery/v2/projects/"+url.PathEscape(s.project)+"/jobs?uploadType=resumable", config, nil)
+		if err != nil {
+			return err
+		}
+		session := header.Get("Location")
+		if session == "" {
+			return fmt.Errorf("BigQuery returned no upload session")
+		}
+		_, err = s.auth.do(http.MethodPut, session, io.NewSectionReader(s.load, 0, size), &job)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(bigQueryJobTimeout)
+	for job.Status.State != "DONE" {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("BigQuery load job %s still running after %s", job.JobReference.JobID, bigQueryJobTimeout)
+		}
+		time.Sleep(2 * time.Second)
+		query := url.Values{}
+		if job.JobReference.Location != "" {
+			query.Set("location", job.JobReference.Location)
+		}
+		target := s.apiURL("/jobs/"+url.PathEscape(job.JobReference.JobID), query)
+		err := retryGoogle(bigQueryRetries, "BigQuery job status", func() error {
+			_, err := s.auth.do(http.MethodGet, target, nil, &job)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if failure := job.Status.ErrorResult; failure != nil {
+		return fmt.Errorf("BigQuery load job %s failed: %s: %s", job.JobReference.JobID, failure.Reason, failure.Message)
+	}
+	loaded, _ := strconv.Atoi(job.Statistics.Load.OutputRows)
+	slog.Info("loaded results into BigQuery", "table", s.tableID(), "rows", loaded, "job", job.JobReference.JobID)
+
+	// Rows are loaded, so the next run starts on an empty file
+	if err := s.load.Truncate(0); err != nil {
+		return fmt.Errorf("failed to reset load file: %w", err)
+	}
+	if _, err := s.load.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to reset load file: %w", err)
+	}
+	s.loadBuf.Reset(s.load)
+	s.loadRows = 0
+	return nil
+}
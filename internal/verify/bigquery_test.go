@@ -0,0 +1,373 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// apiCall is a request a fake API server got
+type apiCall struct {
+	method string
+	path   string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+// fakeReply is a canned response of a fake API server
+type fakeReply struct {
+	status int
+	body   string
+}
+
+// fakeBigQuery serves the BigQuery endpoints the sink calls and the metadata server's token
+type fakeBigQuery struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu          sync.Mutex
+	tableExists bool
+	createReply fakeReply
+	inserts     []fakeReply // replies to insertAll, in turn; success once used up
+	jobError    string      // the errorResult reason the load job ends with
+	calls       []apiCall
+}
+
+func newFakeBigQuery(t *testing.T) *fakeBigQuery {
+	b := &fakeBigQuery{t: t, createReply: fakeReply{http.StatusOK, "{}"}}
+	b.server = httptest.NewServer(http.HandlerFunc(b.serve))
+	t.Cleanup(b.server.Close)
+	return b
+}
+
+func (b *fakeBigQuery) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if r.URL.Path == "/computeMetadata/v1/instance/service-accounts/default/token" {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			b.t.Error("token request without Metadata-Flavor")
+		}
+		fmt.Fprint(w, `{"access_token": "ya29.test", "expires_in": 3600}`)
+		return
+	}
+	if r.Header.Get("Authorization") != "Bearer ya29.test" {
+		b.t.Errorf("%s %s authorized with %q", r.Method, r.URL.Path, r.Header.Get("Authorization"))
+	}
+	b.calls = append(b.calls, apiCall{method: r.Method, path: r.URL.Path, query: r.URL.RawQuery, header: r.Header, body: body})
+
+	const table = "/bigquery/v2/projects/acme-data/datasets/crm/tables/verifications"
+	reply := fakeReply{http.StatusOK, "{}"}
+	switch path := r.Method + " " + r.URL.Path; path {
+	case "GET " + table:
+		if !b.tableExists {
+			reply = fakeReply{http.StatusNotFound, `{"error": {"code": 404, "message": "Not found: Table acme-data:crm.verifications"}}`}
+		}
+	case "POST /bigquery/v2/projects/acme-data/datasets/crm/tables":
+		reply = b.createReply
+	case "POST " + table + "/insertAll":
+		if len(b.inserts) > 0 {
+			reply, b.inserts = b.inserts[0], b.inserts[1:]
+		}
+	case "POST /upload/bigquery/v2/projects/acme-data/jobs":
+		w.Header().Set("Location", b.server.URL+"/upload/session/7")
+	case "PUT /upload/session/7":
+		reply.body = `{"jobReference": {"jobId": "job_7", "location": "EU"}, "status": {"state": "RUNNING"}}`
+	case "GET /bigquery/v2/projects/acme-data/jobs/job_7":
+		errorResult := "null"
+		if b.jobError != "" {
+			errorResult = `{"reason": "` + b.jobError + `", "message": "Error while reading data"}`
+		}
+		reply.body = `{"jobReference": {"jobId": "job_7", "location": "EU"}, "status": {"state": "DONE", "errorResult": ` +
+			errorResult + `}, "statistics": {"load": {"outputRows": "3"}}}`
+	default:
+		b.t.Errorf("unexpected request %s", path)
+		reply = fakeReply{http.StatusNotFound, "{}"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.status)
+	io.WriteString(w, reply.body)
+}
+
+// received returns the API calls made so far whose method and path end as given
+func (b *fakeBigQuery) received(suffix string) []apiCall {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var calls []apiCall
+	for _, call := range b.calls {
+		if strings.HasSuffix(call.method+" "+call.path, suffix) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (b *fakeBigQuery) config(mode string) Config {
+	config := DefaultConfig()
+	config.BigQueryEndpoint = b.server.URL + "/"
+	config.GCEMetadataHost = strings.TrimPrefix(b.server.URL, "http://")
+	config.BigQueryProject = "acme-data"
+	config.BigQueryTable = "crm.verifications"
+	config.BigQueryMode = mode
+	config.BigQueryBatch = 2
+	return config
+}
+
+// insertedRows decodes the rows of an insertAll request
+func insertedRows(t *testing.T, call apiCall) []map[string]any {
+	t.Helper()
+	var request struct {
+		Rows []struct {
+			InsertID string         `json:"insertId"`
+			JSON     map[string]any `json:"json"`
+		} `json:"rows"`
+		IgnoreUnknownValues bool `json:"ignoreUnknownValues"`
+	}
+	if err := json.Unmarshal(call.body, &request); err != nil {
+		t.Fatal(err)
+	}
+	if !request.IgnoreUnknownValues {
+		t.Error("insertAll without ignoreUnknownValues")
+	}
+	rows := make([]map[string]any, len(request.Rows))
+	for i, row := range request.Rows {
+		if want := fmt.Sprint(row.JSON["email"], "/", row.JSON["checked_at"]); row.InsertID != want {
+			t.Errorf("insert ID %q, want %q", row.InsertID, want)
+		}
+		rows[i] = row.JSON
+	}
+	return rows
+}
+
+// sinkResults are results for the sink tests to write
+func sinkResults() []EmailResult {
+	checked := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	return []EmailResult{
+		{Email: "jane@acme.com", IsValid: true, CheckedAt: checked},
+		{Email: "bob@acme.com", Reason: "mailbox does not exist", CheckedAt: checked},
+		{Email: "info@acme.com", Risky: true, Reason: "role account", CheckedAt: checked.Add(time.Second)},
+	}
+}
+
+func TestBigQueryStreamingInserts(t *testing.T) {
+	api := newFakeBigQuery(t)
+	// The first batch hits a transient error and is retried whole
+	api.inserts = []fakeReply{{http.StatusServiceUnavailable, `{"error": {"message": "Backend error"}}`}}
+	sink, err := newBigQuerySink(api.config(bigQueryStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// The missing table is created, partitioned by day of verification
+	create := api.received("POST /bigquery/v2/projects/acme-data/datasets/crm/tables")
+	if len(create) != 1 {
+		t.Fatalf("%d table creations", len(create))
+	}
+	var table struct {
+		TableReference   map[string]string `json:"tableReference"`
+		Schema           struct{ Fields []map[string]string }
+		TimePartitioning map[string]string `json:"timePartitioning"`
+	}
+	json.Unmarshal(create[0].body, &table)
+	if table.TableReference["tableId"] != "verifications" || len(table.Schema.Fields) != len(bigQuerySchema) || table.TimePartitioning["field"] != "checked_at" {
+		t.Errorf("created table %s", create[0].body)
+	}
+
+	results := sinkResults()
+	if err := sink.Write(results[0]); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(api.received("/insertAll")); n != 0 {
+		t.Fatalf("%d inserts before the batch filled", n)
+	}
+	if err := sink.Write(results[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Write(results[2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	inserts := api.received("/insertAll")
+	if len(inserts) != 3 {
+		t.Fatalf("%d insertAll requests, want a failed and a retried batch of 2 and a flushed batch of 1", len(inserts))
+	}
+	if !bytes.Equal(inserts[0].body, inserts[1].body) {
+		t.Error("retry sent different rows")
+	}
+	first, last := insertedRows(t, inserts[1]), insertedRows(t, inserts[2])
+	if len(first) != 2 || first[0]["email"] != "jane@acme.com" || first[0]["valid"] != true || first[1]["reason"] != "mailbox does not exist" {
+		t.Errorf("first batch %v", first)
+	}
+	if len(last) != 1 || last[0]["email"] != "info@acme.com" || last[0]["domain"] != "acme.com" {
+		t.Errorf("last batch %v", last)
+	}
+	// Streaming inserts take the JSON column as a string
+	if result, ok := first[0]["result"].(string); !ok || !strings.Contains(result, `"email":"jane@acme.com"`) {
+		t.Errorf("result column %#v", first[0]["result"])
+	}
+	// Nothing is left to flush
+	if err := sink.Flush(); err != nil || len(api.received("/insertAll")) != 3 {
+		t.Errorf("empty flush: %v", err)
+	}
+}
+
+func TestBigQueryInsertErrors(t *testing.T) {
+	api := newFakeBigQuery(t)
+	api.tableExists = true
+	api.inserts = []fakeReply{
+		{http.StatusOK, `{"insertErrors": [{"index": 1, "errors": [{"reason": "invalid", "location": "reachable", "message": "Cannot convert value to string."}]}]}`},
+		{http.StatusBadRequest, `{"error": {"code": 400, "message": "Request payload size exceeds the limit"}}`},
+	}
+	sink, err := newBigQuerySink(api.config(bigQueryStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	if n := len(api.received("POST /bigquery/v2/projects/acme-data/datasets/crm/tables")); n != 0 {
+		t.Errorf("existing table created %d times", n)
+	}
+
+	// Some rows rejected: the request succeeded, its rows partly didn't
+	results := sinkResults()
+	sink.Write(results[0])
+	err = sink.Write(results[1])
+	if err == nil || err.Error() != "BigQuery rejected 1 of 2 rows: invalid: Cannot convert value to string." {
+		t.Errorf("got %v", err)
+	}
+
+	// Client errors fail at once
+	sink.Write(results[2])
+	err = sink.Flush()
+	if err == nil || !strings.Contains(err.Error(), "failed to stream 1 rows: BigQuery insert: Google API returned 400: Request payload size exceeds the limit") {
+		t.Errorf("got %v", err)
+	}
+	if n := len(api.received("/insertAll")); n != 2 {
+		t.Errorf("%d insertAll requests, want no retries", n)
+	}
+}
+
+func TestBigQueryLoadJob(t *testing.T) {
+	api := newFakeBigQuery(t)
+	api.tableExists = true
+	sink, err := newBigQuerySink(api.config(bigQueryLoad))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	// Rows wait for the load job, however many come in
+	for _, result := range sinkResults() {
+		if err := sink.Write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(api.received("/jobs")) != 0 || len(api.received("/insertAll")) != 0 {
+		t.Fatal("rows sent before Flush")
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	start := api.received("POST /upload/bigquery/v2/projects/acme-data/jobs")
+	if len(start) != 1 || start[0].query != "uploadType=resumable" {
+		t.Fatalf("load job starts %v", start)
+	}
+	var job struct {
+		Configuration struct {
+			Load struct {
+				DestinationTable  map[string]string `json:"destinationTable"`
+				SourceFormat      string            `json:"sourceFormat"`
+				WriteDisposition  string            `json:"writeDisposition"`
+				CreateDisposition string            `json:"createDisposition"`
+			} `json:"load"`
+		} `json:"configuration"`
+	}
+	json.Unmarshal(start[0].body, &job)
+	load := job.Configuration.Load
+	if load.DestinationTable["tableId"] != "verifications" || load.SourceFormat != "NEWLINE_DELIMITED_JSON" ||
+		load.WriteDisposition != "WRITE_APPEND" || load.CreateDisposition != "CREATE_NEVER" {
+		t.Errorf("load job %s", start[0].body)
+	}
+
+	upload := api.received("PUT /upload/session/7")
+	if len(upload) != 1 || upload[0].header.Get("Content-Type") != "application/octet-stream" {
+		t.Fatalf("uploads %v", upload)
+	}
+	var rows []map[string]any
+	for scanner := bufio.NewScanner(bytes.NewReader(upload[0].body)); scanner.Scan(); {
+		var row map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("load file line %q: %v", scanner.Text(), err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) != 3 || rows[2]["email"] != "info@acme.com" {
+		t.Errorf("loaded rows %v", rows)
+	}
+	// Load jobs take the JSON column as a JSON value
+	if result, ok := rows[0]["result"].(map[string]any); !ok || result["email"] != "jane@acme.com" {
+		t.Errorf("result column %#v", rows[0]["result"])
+	}
+	// The running job is followed in its location until done
+	poll := api.received("GET /bigquery/v2/projects/acme-data/jobs/job_7")
+	if len(poll) != 1 || poll[0].query != "location=EU" {
+		t.Errorf("job polls %v", poll)
+	}
+
+	// The next load starts on an empty file, and a failed job is reported
+	api.mu.Lock()
+	api.jobError = "invalid"
+	api.mu.Unlock()
+	sink.Write(sinkResults()[0])
+	err = sink.Flush()
+	if err == nil || err.Error() != "BigQuery load job job_7 failed: invalid: Error while reading data" {
+		t.Errorf("got %v", err)
+	}
+	upload = api.received("PUT /upload/session/7")
+	if len(upload) != 2 || bytes.Count(upload[1].body, []byte("\n")) != 1 {
+		t.Errorf("second load uploaded %q", upload[len(upload)-1].body)
+	}
+}
+
+func TestBigQueryTableNames(t *testing.T) {
+	api := newFakeBigQuery(t)
+	api.tableExists = true
+
+	// The table may name its project and dataset
+	config := api.config(bigQueryStream)
+	config.BigQueryProject = ""
+	config.BigQueryTable = "acme-data.crm.verifications"
+	if _, err := newBigQuerySink(config); err != nil {
+		t.Errorf("project.dataset.table: %v", err)
+	}
+	config.BigQueryTable = "verifications"
+	if _, err := newBigQuerySink(config); err == nil || !strings.Contains(err.Error(), "set -bigquery-project") {
+		t.Errorf("no project: %v", err)
+	}
+	config.BigQueryProject = "acme-data"
+	if _, err := newBigQuerySink(config); err == nil || !strings.Contains(err.Error(), "set -bigquery-dataset") {
+		t.Errorf("no dataset: %v", err)
+	}
+
+	// A table can't be created in a missing dataset
+	api.mu.Lock()
+	api.tableExists = false
+	api.createReply = fakeReply{http.StatusNotFound, `{"error": {"message": "Not found: Dataset acme-data:crm"}}`}
+	api.mu.Unlock()
+	if _, err := newBigQuerySink(api.config(bigQueryStream)); err == nil || !strings.Contains(err.Error(), "dataset acme-data.crm does not exist") {
+		t.Errorf("missing dataset: %v", err)
+	}
+}
@@ -0,0 +1,429 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// bsonDoc is a BSON document with its keys in order, which commands depend on
+type bsonDoc []bsonElem
+
+type bsonElem struct {
+	Key   string
+	Value any
+}
+
+// bsonObjectID is a MongoDB ObjectId
+type bsonObjectID [12]byte
+
+func (id bsonObjectID) String() string { return hex.EncodeToString(id[:]) }
+
+// bsonRaw is a value of a type nothing here needs to look into, kept as is so it can be sent
+// back, such as a Decimal128 or UUID _id
+type bsonRaw struct {
+	Type byte
+	Data []byte
+}
+
+// BSON element types
+const (
+	bsonDouble    = 0x01
+	bsonString    = 0x02
+	bsonDocument  = 0x03
+	bsonArray     = 0x04
+	bsonBinary    = 0x05
+	bsonUndefined = 0x06
+	bsonOID       = 0x07
+	bsonBool      = 0x08
+	bsonDateTime  = 0x09
+	bsonNull      = 0x0A
+	bsonRegex     = 0x0B
+	bsonDBPointer = 0x0C
+	bsonCode      = 0x0D
+	bsonSymbol    = 0x0E
+	bsonCodeScope = 0x0F
+	bsonInt32     = 0x10
+	bsonTimestamp = 0x11
+	bsonInt64     = 0x12
+	bsonDecimal   = 0x13
+	bsonMinKey    = 0xFF
+	bsonMaxKey    = 0x7F
+)
+
+// Get returns the value of a key, nil if there is none
+func (d bsonDoc) Get(key string) any {
+	for _, elem := range d {
+		if elem.Key == key {
+			return elem.Value
+		}
+	}
+	return nil
+}
+
+// Path returns the value at a dotted path into embedded documents, such as contact.email
+func (d bsonDoc) Path(path string) any {
+	key, rest, nested := strings.Cut(path, ".")
+	value := d.Get(key)
+	if !nested {
+		return value
+	}
+	if doc, ok := value.(bsonDoc); ok {
+		return doc.Path(rest)
+	}
+	return nil
+}
+
+// bsonInt returns a numeric value as an int64
+func bsonInt(value any) (int64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return int64(v), true
+	case int64:
+		return v, true
+	case float64:
+		return int64(v), v == math.Trunc(v)
+	}
+	return 0, false
+}
+
+// encodeBSON encodes a document
+func encodeBSON(doc bsonDoc) ([]byte, error) {
+	return appendBSONDoc(nil, doc)
+}
+
+func appendBSONDoc(dst []byte, doc bsonDoc) ([]byte, error) {
+	start := len(dst)
+	dst = append(dst, 0, 0, 0, 0)
+	for _, elem := range doc {
+		if strings.IndexByte(elem.Key, 0) >= 0 {
+			return nil, fmt.Errorf("BSON key %q contains a NUL byte", elem.Key)
+		}
+		var err error
+		if dst, err = appendBSONValue(dst, elem.Key, elem.Value); err != nil {
+			return nil, err
+		}
+	}
+	dst = append(dst, 0)
+	binary.LittleEndian.PutUint32(dst[start:], uint32(len(dst)-start))
+	return dst, nil
+}
+
+func appendBSONValue(dst []byte, key string, value any) ([]byte, error) {
+	element := func(t byte) {
+		dst = append(dst, t)
+		dst = append(dst, key...)
+		dst = append(dst, 0)
+	}
+	switch v := value.(type) {
+	case nil:
+		element(bsonNull)
+	case float64:
+		element(bsonDouble)
+		dst = binary.LittleEndian.AppendUint64(dst, math.Float64bits(v))
+	case string:
+		element(bsonString)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(v)+1))
+		dst = append(append(dst, v...), 0)
+	case bsonDoc:
+		element(bsonDocument)
+		return appendBSONDoc(dst, v)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		doc := make(bsonDoc, len(keys))
+		for i, k := range keys {
+			doc[i] = bsonElem{k, v[k]}
+		}
+		element(bsonDocument)
+		return appendBSONDoc(dst, doc)
+	case []any:
+		doc := make(bsonDoc, len(v))
+		for i, item := range v {
+			doc[i] = bsonElem{strconv.Itoa(i), item}
+		}
+		element(bsonArray)
+		return appendBSONDoc(dst, doc)
+	case []byte:
+		element(bsonBinary)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(len(v)))
+		dst = append(append(dst, 0), v...)
+	case bsonObjectID:
+		element(bsonOID)
+		dst = append(dst, v[:]...)
+	case bool:
+		element(bsonBool)
+		if v {
+			dst = append(dst, 1)
+		} else {
+			dst = append(dst, 0)
+		}
+	case time.Time:
+		element(bsonDateTime)
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(v.UnixMilli()))
+	case int32:
+		element(bsonInt32)
+		dst = binary.LittleEndian.AppendUint32(dst, uint32(v))
+	case int:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return appendBSONValue(dst, key, int32(v))
+		}
+		return appendBSONValue(dst, key, int64(v))
+	case int64:
+		element(bsonInt64)
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(v))
+	case bsonRaw:
+		element(v.Type)
+		dst = append(dst, v.Data...)
+	default:
+		return nil, fmt.Errorf("can't encode %T as BSON", value)
+	}
+	return dst, nil
+}
+
+var errBSONTruncated = errors.New("truncated BSON document")
+
+// decodeBSON decodes a document
+func decodeBSON(data []byte) (bsonDoc, error) {
+	if len(data) < 5 {
+		return nil, errBSONTruncated
+	}
+	size := int(binary.LittleEndian.Uint32(data))
+	if size < 5 || size > len(data) || data[size-1] != 0 {
+		return nil, errBSONTruncated
+	}
+	data = data[4 : size-1]
+
+	doc := bsonDoc{}
+	for len(data) > 0 {
+		t := data[0]
+		end := bytes.IndexByte(data[1:], 0)
+		if end < 0 {
+			return nil, errBSONTruncated
+		}
+		key := string(data[1 : 1+end])
+		data = data[2+end:]
+		n, err := bsonValueSize(t, data)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		value, err := decodeBSONValue(t, data[:n])
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", key, err)
+		}
+		doc = append(doc, bsonElem{key, value})
+		data = data[n:]
+	}
+	return doc, nil
+}
+
+// bsonValueSize returns how many bytes of data the value of a type takes
+func bsonValueSize(t byte, data []byte) (int, error) {
+	length := func(extra int) (int, error) {
+		if len(data) < 4 {
+			return 0, errBSONTruncated
+		}
+		n := int(int32(binary.LittleEndian.Uint32(data))) + extra
+		if n < 4 || n > len(data) {
+			return 0, errBSONTruncated
+		}
+		return n, nil
+	}
+	fixed := func(n int) (int, error) {
+		if n > len(data) {
+			return 0, errBSONTruncated
+		}
+		return n, nil
+	}
+	switch t {
+	case bsonDouble, bsonDateTime, bsonTimestamp, bsonInt64:
+		return fixed(8)
+	case bsonString, bsonCode, bsonSymbol:
+		return length(4)
+	case bsonDocument, bsonArray, bsonCodeScope:
+		return length(0)
+	case bsonBinary:
+		return length(5)
+	case bsonUndefined, bsonNull, bsonMinKey, bsonMaxKey:
+		return 0, nil
+	case bsonOID:
+		return fixed(12)
+	case bsonBool:
+		return fixed(1)
+	case bsonRegex:
+		pattern := bytes.IndexByte(data, 0)
+		if pattern < 0 {
+			return 0, errBSONTruncated
+		}
+		options := bytes.IndexByte(data[pattern+1:], 0)
+		if options < 0 {
+			return 0, errBSONTruncated
+		}
+		return pattern + options + 2, nil
+	case bsonDBPointer:
+		n, err := length(4)
+		if err != nil {
+			return 0, err
+		}
+		return fixed(n + 12)
+	case bsonInt32:
+		return fixed(4)
+	case bsonDecimal:
+		return fixed(16)
+	}
+	return 0, fmt.Errorf("unknown BSON type 0x%02x", t)
+}
+
+func decodeBSONValue(t byte, data []byte) (any, error) {
+	switch t {
+	case bsonDouble:
+		return math.Float64frombits(binary.LittleEndian.Uint64(data)), nil
+	case bsonString:
+		if len(data) < 5 {
+			return nil, errBSONTruncated
+		}
+		return string(data[4 : len(data)-1]), nil
+	case bsonDocument:
+		return decodeBSON(data)
+	case bsonArray:
+		doc, err := decodeBSON(data)
+		if err != nil {
+			return nil, err
+		}
+		values := make([]any, len(doc))
+		for i, elem := range doc {
+			values[i] = elem.Value
+		}
+		return values, nil
+	case bsonBinary:
+		if data[4] == 0 {
+			return data[5:], nil
+		}
+	case bsonOID:
+		var id bsonObjectID
+		copy(id[:], data)
+		return id, nil
+	case bsonBool:
+		return data[0] != 0, nil
+	case bsonDateTime:
+		return time.UnixMilli(int64(binary.LittleEndian.Uint64(data))).UTC(), nil
+	case bsonNull:
+		return nil, nil
+	case bsonInt32:
+		return int32(binary.LittleEndian.Uint32(data)), nil
+	case bsonInt64:
+		return int64(binary.LittleEndian.Uint64(data)), nil
+	}
+	return bsonRaw{Type: t, Data: data}, nil
+}
+
+// parseExtendedJSON parses a query document written as JSON into BSON, keeping its keys in
+// order. ObjectIds, dates and 64-bit integers can be given in extended JSON: {"$oid": "..."},
+// {"$date": "2024-01-01T00:00:00Z"} and {"$numberLong": "..."}.
+func parseExtendedJSON(s string) (bsonDoc, error) {
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	value, err := parseJSONValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err == nil {
+		return nil, errors.New("unexpected data after the document")
+	}
+	doc, ok := value.(bsonDoc)
+	if !ok {
+		return nil, errors.New("expected a JSON object")
+	}
+	return doc, nil
+}
+
+func parseJSONValue(dec *json.Decoder) (any, error) {
+	token, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch v := token.(type) {
+	case json.Delim:
+		if v == '[' {
+			values := []any{}
+			for dec.More() {
+				value, err := parseJSONValue(dec)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, value)
+			}
+			_, err := dec.Token()
+			return values, err
+		}
+		doc := bsonDoc{}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := parseJSONValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bsonElem{key.(string), value})
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return extendedJSONValue(doc)
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n, nil
+		}
+		return v.Float64()
+	}
+	return token, nil
+}
+
+// extendedJSONValue turns the extended JSON forms of types JSON lacks into their values
+func extendedJSONValue(doc bsonDoc) (any, error) {
+	if len(doc) != 1 {
+		return doc, nil
+	}
+	switch value := doc[0].Value; doc[0].Key {
+	case "$oid":
+		s, _ := value.(string)
+		var id bsonObjectID
+		if n, err := hex.Decode(id[:], []byte(s)); err != nil || n != len(id) || len(s) != 2*len(id) {
+			return nil, fmt.Errorf("invalid ObjectId %q", s)
+		}
+		return id, nil
+	case "$date":
+		switch v := value.(type) {
+		case string:
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid date %q", v)
+			}
+			return t, nil
+		case int64:
+			return time.UnixMilli(v).UTC(), nil
+		}
+		return nil, fmt.Errorf("invalid date %v", value)
+	case "$numberLong":
+		s, _ := value.(string)
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid $numberLong %q", s)
+		}
+		return n, nil
+	}
+	return doc, nil
+}
@@ -0,0 +1,207 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBSONSpecExample(t *testing.T) {
+	// The first example of bsonspec.org: {"hello": "world"}
+	want := []byte("\x16\x00\x00\x00\x02hello\x00\x06\x00\x00\x00world\x00\x00")
+	got, err := encodeBSON(bsonDoc{{"hello", "world"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("encoded %q, want %q", got, want)
+	}
+
+	// The second: {"BSON": ["awesome", 5.05, 1986]}
+	want = []byte("\x31\x00\x00\x00\x04BSON\x00\x26\x00\x00\x00\x020\x00\x08\x00\x00\x00awesome\x00" +
+		"\x011\x00\x33\x33\x33\x33\x33\x33\x14\x40\x102\x00\xc2\x07\x00\x00\x00\x00")
+	doc, err := decodeBSON(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(doc, bsonDoc{{"BSON", []any{"awesome", 5.05, int32(1986)}}}) {
+		t.Errorf("decoded %#v", doc)
+	}
+	if got, _ := encodeBSON(doc); !bytes.Equal(got, want) {
+		t.Errorf("re-encoded %q, want %q", got, want)
+	}
+}
+
+func TestBSONRoundTrip(t *testing.T) {
+	var id bsonObjectID
+	hex.Decode(id[:], []byte("65a1b2c3d4e5f60718293a4b"))
+	checked := time.Date(2024, 3, 1, 12, 30, 45, 123000000, time.UTC)
+	decimal := bsonRaw{Type: bsonDecimal, Data: bytes.Repeat([]byte{0x01}, 16)}
+	uuid := bsonRaw{Type: bsonBinary, Data: append([]byte{16, 0, 0, 0, 4}, bytes.Repeat([]byte{0xab}, 16)...)}
+
+	// Every type the client sends: commands, queries, updates and their values
+	doc := bsonDoc{
+		{"find", "users"},
+		{"filter", bsonDoc{{"status", "new"}, {"created", bsonDoc{{"$gte", checked}}}}},
+		{"batchSize", int32(10000)},
+		{"getMore", int64(1) << 40},
+		{"small", 7},
+		{"large", 1 << 40},
+		{"confidence", 0.75},
+		{"ordered", false},
+		{"done", true},
+		{"payload", []byte("n,,n=user,r=nonce")},
+		{"empty", []byte{}},
+		{"_id", id},
+		{"expires_at", nil},
+		{"updates", []any{bsonDoc{{"q", bsonDoc{{"_id", id}}}}, "tag", int32(3)}},
+		{"row", map[string]any{"valid": true, "email": "jane@acme.com"}},
+		{"decimal", decimal},
+		{"uuid", uuid},
+	}
+	data, err := encodeBSON(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeBSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := slices.Clone(doc)
+	// Go ints become the narrowest integer that holds them, maps documents with sorted keys
+	want[4].Value = int32(7)
+	want[5].Value = int64(1 << 40)
+	want[14].Value = bsonDoc{{"email", "jane@acme.com"}, {"valid", true}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("round trip gave\n%#v\nwant\n%#v", got, want)
+	}
+
+	// Raw values go back out byte for byte
+	again, err := encodeBSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("re-encoding the decoded document changed it")
+	}
+}
+
+func TestBSONDecodesServerTypes(t *testing.T) {
+	// Types only servers send are skipped over correctly and kept raw
+	doc := []byte{}
+	doc = append(doc, bsonRegex)
+	doc = append(doc, "re\x00^a.*\x00i\x00"...)
+	doc = append(doc, bsonTimestamp)
+	doc = append(doc, "ts\x00\x01\x00\x00\x00\x02\x00\x00\x00"...)
+	doc = append(doc, bsonMinKey)
+	doc = append(doc, "min\x00"...)
+	doc = append(doc, bsonString)
+	doc = append(doc, "after\x00\x03\x00\x00\x00ok\x00"...)
+	data := append([]byte{byte(len(doc) + 5), 0, 0, 0}, doc...)
+	data = append(data, 0)
+
+	got, err := decodeBSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bsonDoc{
+		{"re", bsonRaw{Type: bsonRegex, Data: []byte("^a.*\x00i\x00")}},
+		{"ts", bsonRaw{Type: bsonTimestamp, Data: []byte{1, 0, 0, 0, 2, 0, 0, 0}}},
+		{"min", bsonRaw{Type: bsonMinKey, Data: []byte{}}},
+		{"after", "ok"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %#v", got)
+	}
+}
+
+func TestBSONTruncated(t *testing.T) {
+	data, err := encodeBSON(bsonDoc{{"email", "jane@acme.com"}, {"nested", bsonDoc{{"n", int64(1)}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := range len(data) {
+		if _, err := decodeBSON(data[:n]); err == nil {
+			t.Errorf("decoded the first %d of %d bytes", n, len(data))
+		}
+	}
+	// A nested length running past its parent
+	corrupt := bytes.Clone(data)
+	corrupt[bytes.Index(data, []byte("\x03nested\x00"))+8] = 0x7f
+	if _, err := decodeBSON(corrupt); !errors.Is(err, errBSONTruncated) {
+		t.Errorf("oversized nested document gave %v", err)
+	}
+	if _, err := encodeBSON(bsonDoc{{"bad\x00key", 1}}); err == nil {
+		t.Error("encoded a key with a NUL byte")
+	}
+	if _, err := encodeBSON(bsonDoc{{"c", make(chan int)}}); err == nil {
+		t.Error("encoded a channel")
+	}
+}
+
+func TestBSONPath(t *testing.T) {
+	doc := bsonDoc{{"contact", bsonDoc{{"email", "jane@acme.com"}, {"phone", nil}}}, {"n", 3.0}}
+	if got := doc.Path("contact.email"); got != "jane@acme.com" {
+		t.Errorf("contact.email = %v", got)
+	}
+	for _, path := range []string{"contact.fax", "n.email", "missing.email"} {
+		if got := doc.Path(path); got != nil {
+			t.Errorf("%s = %v, want nil", path, got)
+		}
+	}
+	for value, want := range map[any]int64{int32(5): 5, int64(6): 6, 7.0: 7} {
+		if got, ok := bsonInt(value); !ok || got != want {
+			t.Errorf("bsonInt(%#v) = %d, %v", value, got, ok)
+		}
+	}
+	if _, ok := bsonInt(1.5); ok {
+		t.Error("1.5 taken for an integer")
+	}
+}
+
+func TestParseExtendedJSON(t *testing.T) {
+	doc, err := parseExtendedJSON(`{"status": "new", "_id": {"$oid": "65a1b2c3d4e5f60718293a4b"},
+		"created": {"$gte": {"$date": "2024-03-01T12:30:45Z"}}, "score": {"$numberLong": "9007199254740993"},
+		"tags": {"$in": ["a", 2, 2.5]}, "seen": {"$date": 1709296245000}, "flag": true, "none": null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var id bsonObjectID
+	hex.Decode(id[:], []byte("65a1b2c3d4e5f60718293a4b"))
+	created := time.Date(2024, 3, 1, 12, 30, 45, 0, time.UTC)
+	want := bsonDoc{
+		{"status", "new"},
+		{"_id", id},
+		{"created", bsonDoc{{"$gte", created}}},
+		{"score", int64(9007199254740993)},
+		{"tags", bsonDoc{{"$in", []any{"a", int64(2), 2.5}}}},
+		{"seen", created},
+		{"flag", true},
+		{"none", nil},
+	}
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("parsed %#v", doc)
+	}
+	if _, err := encodeBSON(doc); err != nil {
+		t.Errorf("parsed query doesn't encode: %v", err)
+	}
+
+	for _, bad := range []string{
+		`["not", "an", "object"]`,
+		`{"a": 1} {"b": 2}`,
+		`{"_id": {"$oid": "xyz"}}`,
+		`{"_id": {"$oid": "65a1b2c3"}}`,
+		`{"at": {"$date": "yesterday"}}`,
+		`{"n": {"$numberLong": "1.5"}}`,
+		`{"open": `,
+	} {
+		if _, err := parseExtendedJSON(bad); err == nil {
+			t.Errorf("parsed %s", bad)
+		}
+	}
+}
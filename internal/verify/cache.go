@@ -0,0 +1,120 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// cacheFile is an on-disk cache the tool keeps between runs
+type cacheFile struct {
+	name  string
+	path  string
+	about string
+	// refresh fetches the cache anew, nil if it is only built up by runs
+	refresh func() error
+}
+
+// cacheFiles lists the caches at the paths the configuration gives them
+func cacheFiles(config Config) []cacheFile {
+	domainStore := config.DomainStore
+	if domainStore == "" {
+		domainStore = dataDir + "/domains.json"
+	}
+	return []cacheFile{
+		{
+			name:  "tlds",
+			path:  config.TLDCacheFile,
+			about: "IANA TLD list (-validate-tld)",
+			refresh: func() error {
+				return downloadTLDList(config.TLDListURL, config.TLDCacheFile)
+			},
+		},
+		{
+			name:  "domains",
+			path:  domainStore,
+			about: "Per-domain intelligence (-domain-store)",
+		},
+	}
+}
+
+// ManageCaches lists, clears or refreshes the named caches, all of them without names
+func ManageCaches(config Config, action string, names []string) error {
+	caches := cacheFiles(config)
+	var selected []cacheFile
+	for _, cache := range caches {
+		if len(names) == 0 || slices.Contains(names, cache.name) {
+			selected = append(selected, cache)
+		}
+	}
+	for _, name := range names {
+		if !slices.ContainsFunc(caches, func(c cacheFile) bool { return c.name == name }) {
+			return fmt.Errorf("unknown cache %q", name)
+		}
+	}
+
+	switch action {
+	case "list":
+		listCaches(selected)
+	case "clear":
+		for _, cache := range selected {
+			err := os.Remove(cache.path)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("failed to clear cache %s: %w", cache.name, err)
+			}
+			slog.Info("cleared cache", "cache", cache.name, "path", cache.path)
+		}
+	case "refresh":
+		for _, cache := range selected {
+			if cache.refresh == nil {
+				if len(names) > 0 {
+					return fmt.Errorf("cache %s is built up by runs and can't be refreshed", cache.name)
+				}
+				continue
+			}
+			if err := cache.refresh(); err != nil {
+				return fmt.Errorf("failed to refresh cache %s: %w", cache.name, err)
+			}
+			slog.Info("refreshed cache", "cache", cache.name, "path", cache.path)
+		}
+	default:
+		return fmt.Errorf("unknown cache action %q (expected list, clear or refresh)", action)
+	}
+	return nil
+}
+
+func listCaches(caches []cacheFile) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CACHE\tPATH\tSIZE\tUPDATED\tCONTENTS")
+	for _, cache := range caches {
+		size, updated := "-", "never"
+		if info, err := os.Stat(cache.path); err == nil {
+			size = formatBytes(info.Size())
+			updated = time.Since(info.ModTime()).Round(time.Second).String() + " ago"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", cache.name, cache.path, size, updated, cache.about)
+	}
+	tw.Flush()
+}
+
+// formatBytes renders a size in bytes with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,48 @@
+package verify
+
+import "strings"
+
+// domainAliases maps alternate domains of a mailbox provider to its primary domain
+var domainAliases = map[string]string{
+	"googlemail.com": "gmail.com",
+}
+
+// dotInsensitiveDomains ignore dots in the local part
+var dotInsensitiveDomains = map[string]bool{
+	"gmail.com": true,
+}
+
+// subaddressingProviders deliver user+tag@ to user@
+var subaddressingProviders = map[string]bool{
+	"google":    true,
+	"microsoft": true,
+	"apple":     true,
+	"fastmail":  true,
+	"proton":    true,
+	"zoho":      true,
+}
+
+// canonicalMailbox returns the address of the mailbox an address delivers to. Addresses are
+// lowercased; dots, +tags and domain aliases are only folded for providers known to ignore them.
+func canonicalMailbox(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+	local, domain := email[:at], email[at+1:]
+
+	if alias, ok := domainAliases[domain]; ok {
+		domain = alias
+	}
+	if subaddressingProviders[mailboxProviders[domain]] {
+		if plus := strings.IndexByte(local, '+'); plus > 0 {
+			local = local[:plus]
+		}
+	}
+	if dotInsensitiveDomains[domain] {
+		local = strings.ReplaceAll(local, ".", "")
+	}
+
+	return local + "@" + domain
+}
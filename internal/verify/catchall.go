@@ -0,0 +1,214 @@
+package verify
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// The verifier library's defaults, the defaults of -helo, -from, -smtp-port,
+// -smtp-connect-timeout and -smtp-operation-timeout
+const (
+	libraryHelloName        = "localhost"
+	librarySMTPPort         = 25
+	libraryFromEmail        = "user@example.org"
+	libraryConnectTimeout   = 10 * time.Second
+	libraryOperationTimeout = 10 * time.Second
+)
+
+// smtpSession is how probes reach an MX host, introduce themselves and how long they wait for it
+type smtpSession struct {
+	dial             smtpDial
+	port             string // of MX hosts
+	hello            string
+	from             string
+	connectTimeout   time.Duration
+	operationTimeout time.Duration // per command
+}
+
+// CatchAllSample is what probing a catch-all domain with random mailboxes revealed about an address
+type CatchAllSample struct {
+	Probes      int      `json:"probes"`
+	TargetCode  int      `json:"target_code"`
+	RandomCodes []int    `json:"random_codes"`
+	TimingZ     float64  `json:"timing_z"`
+	Signals     []string `json:"signals,omitempty"`
+	Confidence  float64  `json:"confidence"`
+}
+
+// rcptReply is the server's answer to one RCPT TO and how long it took
+type rcptReply struct {
+	code    int
+	message string
+	latency time.Duration
+}
+
+// catchAllConfirm checks the probe of an address whose domain the library took for catch-all,
+// returning the probe as it should stand
+type catchAllConfirm func(domain, username string, probe *emailverifier.SMTP) (*emailverifier.SMTP, error)
+
+// confirmCatchAll checks a domain the library took for catch-all, which it does whenever its random
+// mailbox isn't rejected with a 550, a timeout or deferral included. A random mailbox and the
+// address are probed in one session: the domain is only catch-all if the random mailbox gets a 2xx
+// reply, and otherwise the address's own reply decides. A server that doesn't answer is an error,
+// as a tarpit leaves the address unverified rather than accepted.
+func confirmCatchAll(session smtpSession, mxHost, email string, probe *emailverifier.SMTP) (*emailverifier.SMTP, error) {
+	replies, err := probeRecipients(session, mxHost, []string{randomMailbox() + "@" + emailDomain(email), email})
+	if err != nil {
+		return nil, fmt.Errorf("failed to confirm catch-all: %w", err)
+	}
+	// The probe may be shared with other addresses, so it is replaced rather than changed
+	smtp := *probe
+	smtp.CatchAll = replies[0].code/100 == 2
+	smtp.Deliverable = !smtp.CatchAll && replies[1].code/100 == 2
+	return &smtp, nil
+}
+
+// sampleCatchAll probes the target and n random mailboxes on the domain in one session
+// and estimates how likely the target mailbox is to exist, also returning the reply timings
+func sampleCatchAll(session smtpSession, mxHost, email string, n int) (*CatchAllSample, *RCPTTiming, error) {
+	domain := emailDomain(email)
+
+	// Put the target at a random position so connection warm-up doesn't single it out
+	recipients := make([]string, 0, n+1)
+	for i := 0; i < n; i++ {
+		recipients = append(recipients, randomMailbox()+"@"+domain)
+	}
+	target := rand.IntN(n + 1)
+	recipients = append(recipients[:target], append([]string{email}, recipients[target:]...)...)
+
+	replies, err := probeRecipients(session, mxHost, recipients)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	randoms := append(append([]rcptReply{}, replies[:target]...), replies[target+1:]...)
+	return assessCatchAll(replies[target], randoms), rcptTiming(replies[target], randoms), nil
+}
+
+// probeRecipients issues RCPT TO for each recipient in a single SMTP session
+func probeRecipients(session smtpSession, mxHost string, recipients []string) ([]rcptReply, error) {
+	conn, err := session.dial(net.JoinHostPort(mxHost, session.port), session.connectTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", mxHost, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(session.operationTimeout * time.Duration(len(recipients)+3)))
+
+	client, err := smtp.NewClient(conn, mxHost)
+	if err != nil {
+		return nil, fmt.Errorf("SMTP greeting from %s failed: %w", mxHost, err)
+	}
+	defer client.Quit()
+
+	if err := client.Hello(session.hello); err != nil {
+		return nil, fmt.Errorf("HELO to %s failed: %w", mxHost, err)
+	}
+	if err := client.Mail(session.from); err != nil {
+		return nil, fmt.Errorf("MAIL FROM to %s failed: %w", mxHost, err)
+	}
+
+	// net/smtp hides RCPT codes, so talk to the connection directly
+	replies := make([]rcptReply, 0, len(recipients))
+	for _, recipient := range recipients {
+		start := time.Now()
+		id, err := client.Text.Cmd("RCPT TO:<%s>", recipient)
+		if err != nil {
+			return nil, fmt.Errorf("RCPT to %s failed: %w", mxHost, err)
+		}
+		client.Text.StartResponse(id)
+		code, message, err := client.Text.ReadResponse(0)
+		client.Text.EndResponse(id)
+		if err != nil {
+			return nil, fmt.Errorf("RCPT to %s failed: %w", mxHost, err)
+		}
+		replies = append(replies, rcptReply{code: code, message: message, latency: time.Since(start)})
+	}
+	return replies, nil
+}
+
+// assessCatchAll compares the target's reply with the random mailboxes'. A catch-all server that
+// answers the target with a different code or message, or noticeably faster or slower, looked the
+// mailbox up; one that answers everything alike tells us nothing, leaving the confidence at 0.5.
+func assessCatchAll(target rcptReply, randoms []rcptReply) *CatchAllSample {
+	sample := &CatchAllSample{Probes: len(randoms) + 1, TargetCode: target.code, Confidence: 0.5}
+
+	sameCode, sameMessage := true, true
+	for _, r := range randoms {
+		sample.RandomCodes = append(sample.RandomCodes, r.code)
+		if r.code != randoms[0].code {
+			sameCode = false
+		}
+		if normalizeReply(r.message) != normalizeReply(randoms[0].message) {
+			sameMessage = false
+		}
+	}
+	if len(randoms) == 0 {
+		return sample
+	}
+
+	switch {
+	case target.code >= 400 && randoms[0].code < 300 && sameCode:
+		sample.Signals = append(sample.Signals, "target rejected while random mailboxes were accepted")
+		sample.Confidence = 0.05
+		return sample
+	case target.code != randoms[0].code && sameCode:
+		sample.Signals = append(sample.Signals, fmt.Sprintf("target answered %d, random mailboxes %d", target.code, randoms[0].code))
+		sample.Confidence = 0.85
+	case sameMessage && normalizeReply(target.message) != normalizeReply(randoms[0].message):
+		sample.Signals = append(sample.Signals, "target reply text differs from random mailboxes")
+		sample.Confidence += 0.15
+	}
+
+	// Timing needs a few random samples to estimate their spread
+	if len(randoms) >= 3 {
+		var mean float64
+		for _, r := range randoms {
+			mean += float64(r.latency)
+		}
+		mean /= float64(len(randoms))
+		var variance float64
+		for _, r := range randoms {
+			variance += math.Pow(float64(r.latency)-mean, 2)
+		}
+		// Floor the spread so sub-millisecond jitter doesn't look significant
+		stddev := math.Max(math.Sqrt(variance/float64(len(randoms)-1)), float64(2*time.Millisecond))
+
+		sample.TimingZ = math.Round((float64(target.latency)-mean)/stddev*100) / 100
+		if math.Abs(sample.TimingZ) >= 3 {
+			sample.Signals = append(sample.Signals, fmt.Sprintf("target reply time is %.1f standard deviations from random mailboxes", sample.TimingZ))
+			sample.Confidence += 0.15
+		}
+	}
+
+	sample.Confidence = math.Min(sample.Confidence, 0.95)
+	return sample
+}
+
+// normalizeReply strips the parts of a reply that name the recipient
+func normalizeReply(message string) string {
+	fields := strings.Fields(strings.ToLower(message))
+	kept := fields[:0]
+	for _, field := range fields {
+		if !strings.Contains(field, "@") {
+			kept = append(kept, field)
+		}
+	}
+	return strings.Join(kept, " ")
+}
+
+// randomMailbox returns a local part that almost certainly doesn't exist
+func randomMailbox() string {
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 16)
+	for i := range b {
+		b[i] = letters[rand.IntN(len(letters))]
+	}
+	return string(b)
+}
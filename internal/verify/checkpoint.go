@@ -0,0 +1,205 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+)
+
+// checkpointHeader opens a checkpoint, identifying the input it belongs to
+type checkpointHeader struct {
+	Input    string `json:"input"`
+	Emails   int    `json:"emails"`
+	Checksum string `json:"checksum"`
+}
+
+// checkpointEntry is one verified address, by its position in the input
+type checkpointEntry struct {
+	Index  int         `json:"i"`
+	Result EmailResult `json:"r"`
+}
+
+// RunCheckpoint journals every verified address of a batch run as JSON Lines, so a run that
+// crashes, runs out of memory or is rebooted away can resume where it left off instead of
+// starting over. Entries are flushed and synced at least as often as progress is reported.
+type RunCheckpoint struct {
+	path    string
+	file    *os.File
+	buf     *bufio.Writer
+	encoder *json.Encoder
+
+	done    []bool
+	resumed int
+	end     int64 // journal bytes holding the resumed entries
+}
+
+// openRunCheckpoint starts a checkpoint for the emails, or with resume picks up the existing one.
+// A checkpoint written for a different input is refused rather than mixed in.
+func openRunCheckpoint(path, input string, emails []string, resume bool) (*RunCheckpoint, error) {
+	header := checkpointHeader{Input: input, Emails: len(emails), Checksum: emailsChecksum(emails)}
+	c := &RunCheckpoint{path: path, done: make([]bool, len(emails))}
+
+	if resume {
+		file, err := os.OpenFile(path, os.O_RDWR, 0)
+		if errors.Is(err, fs.ErrNotExist) {
+			slog.Info("no checkpoint to resume, starting from the beginning", "checkpoint", path)
+			resume = false
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to open checkpoint %s: %w", path, err)
+		} else {
+			c.file = file
+			if err := c.load(header); err != nil {
+				file.Close()
+				return nil, err
+			}
+		}
+	}
+	if !resume {
+		file, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create checkpoint %s: %w", path, err)
+		}
+		c.file = file
+	}
+
+	c.buf = bufio.NewWriterSize(c.file, 1024*1024) // 1MB buffer
+	c.encoder = json.NewEncoder(c.buf)
+	if !resume {
+		if err := c.encoder.Encode(header); err != nil {
+			return nil, fmt.Errorf("failed to write checkpoint %s: %w", path, err)
+		}
+		if err := c.Flush(); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// emailsChecksum fingerprints the input, so a checkpoint can't be resumed against another list
+func emailsChecksum(emails []string) string {
+	hash := sha256.New()
+	for _, email := range emails {
+		io.WriteString(hash, email)
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// load reads which addresses the checkpoint holds and cuts off an entry left half-written by a
+// crash, so new entries append cleanly
+func (c *RunCheckpoint) load(want checkpointHeader) error {
+	reader := bufio.NewReader(c.file)
+	for line := 0; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint %s: %w", c.path, err)
+		}
+
+		if line == 0 {
+			var header checkpointHeader
+			if err := json.Unmarshal(data, &header); err != nil {
+				return fmt.Errorf("checkpoint %s is not a checkpoint: %w", c.path, err)
+			}
+			if header.Emails != want.Emails || header.Checksum != want.Checksum {
+				return fmt.Errorf("checkpoint %s is for a different input (%s, %d emails); remove it or drop -resume", c.path, header.Input, header.Emails)
+			}
+		} else {
+			var entry checkpointEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				break
+			}
+			if entry.Index < 0 || entry.Index >= len(c.done) {
+				return fmt.Errorf("checkpoint %s has an entry for address %d of %d", c.path, entry.Index, len(c.done))
+			}
+			if !c.done[entry.Index] {
+				c.done[entry.Index] = true
+				c.resumed++
+			}
+		}
+		c.end += int64(len(data))
+	}
+	if c.end == 0 {
+		return fmt.Errorf("checkpoint %s is empty", c.path)
+	}
+
+	if err := c.file.Truncate(c.end); err != nil {
+		return fmt.Errorf("failed to truncate checkpoint %s: %w", c.path, err)
+	}
+	if _, err := c.file.Seek(c.end, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek checkpoint %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// Resumed is the number of addresses verified before the resume
+func (c *RunCheckpoint) Resumed() int { return c.resumed }
+
+// Done reports whether the address at index was verified before the resume
+func (c *RunCheckpoint) Done(index int) bool { return c.done[index] }
+
+// Replay calls each for every result verified before the resume, streaming them from the
+// journal rather than holding them in memory
+func (c *RunCheckpoint) Replay(each func(index int, result EmailResult)) error {
+	if c.resumed == 0 {
+		return nil
+	}
+	file, err := os.Open(c.path)
+	if err != nil {
+		return fmt.Errorf("failed to open checkpoint %s: %w", c.path, err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(io.LimitReader(file, c.end), 1024*1024)
+	if _, err := reader.ReadBytes('\n'); err != nil {
+		return fmt.Errorf("failed to read checkpoint %s: %w", c.path, err)
+	}
+	for {
+		data, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(data)) > 0 {
+			var entry checkpointEntry
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("failed to read checkpoint %s: %w", c.path, err)
+			}
+			each(entry.Index, entry.Result)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read checkpoint %s: %w", c.path, err)
+		}
+	}
+}
+
+// Record journals a verified address
+func (c *RunCheckpoint) Record(index int, result EmailResult) error {
+	if err := c.encoder.Encode(checkpointEntry{Index: index, Result: result}); err != nil {
+		return fmt.Errorf("failed to write checkpoint %s: %w", c.path, err)
+	}
+	return nil
+}
+
+// Flush writes the journaled entries through to the disk
+func (c *RunCheckpoint) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write checkpoint %s: %w", c.path, err)
+	}
+	return c.file.Sync()
+}
+
+// Remove deletes the checkpoint once the run is complete and its outputs are written
+func (c *RunCheckpoint) Remove() error {
+	c.file.Close()
+	return os.Remove(c.path)
+}
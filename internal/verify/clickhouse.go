@@ -0,0 +1,294 @@
+package verify
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	clickHouseRetries = 4
+	clickHouseTimeout = 5 * time.Minute
+)
+
+// clickHouseTable creates the results table when it doesn't exist. Low-cardinality columns are
+// dictionary encoded, and ordering by domain keeps one domain's rows together for the queries
+// analytics run most. The deduplication window makes retried inserts idempotent, as replicated
+// tables are by default.
+const clickHouseTable = `CREATE TABLE IF NOT EXISTS %s (
+  email String,
+  domain LowCardinality(String),
+  valid Bool,
+  risky Bool,
+  reason String,
+  reachable LowCardinality(String),
+  disposable Bool,
+  role_account Bool,
+  free Bool,
+  confidence Nullable(Float32),
+  country LowCardinality(String),
+  greylisted Bool,
+  catch_all Bool,
+  deferred Bool,
+  policy LowCardinality(String),
+  probed_as LowCardinality(String),
+  checked_at DateTime64(3, 'UTC'),
+  expires_at Nullable(DateTime64(3, 'UTC'))
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(checked_at)
+ORDER BY (domain, email, checked_at)
+SETTINGS non_replicated_deduplication_window = 1000`
+
+// clickHouseRetryable are the exception codes of failures that pass: timeouts, too many queries or
+// parts, memory limits, network errors and read-only replicas
+var clickHouseRetryable = []int{159, 202, 209, 210, 241, 242, 252, 999}
+
+// ClickHouseSink inserts every result into a ClickHouse table over the HTTP interface. Results are
+// inserted in large gzipped JSONEachRow batches from a goroutine of their own, so workers only
+// wait for ClickHouse when it falls a batch behind.
+type ClickHouseSink struct {
+	client   *http.Client
+	endpoint string
+	settings url.Values
+	table    string
+	batch    int
+	username string
+	password string
+
+	mu      sync.Mutex
+	rows    bytes.Buffer // JSON lines not sent yet
+	count   int
+	queue   chan clickHouseBatch
+	pending sync.WaitGroup
+	closed  bool
+	failed  atomic.Int64 // rows of failed inserts since the last flush
+}
+
+// clickHouseBatch is a batch of rows handed to the sending goroutine
+type clickHouseBatch struct {
+	rows  []byte
+	count int
+}
+
+// clickHouseError is a failed ClickHouse request
+type clickHouseError struct {
+	Status    int
+	Code      int
+	Message   string
+	Retryable bool
+}
+
+func (e *clickHouseError) Error() string {
+	if e.Status == 0 {
+		return "ClickHouse request failed: " + e.Message
+	}
+	return fmt.Sprintf("ClickHouse returned %d: %s", e.Status, e.Message)
+}
+
+func newClickHouseSink(config Config) (*ClickHouseSink, error) {
+	u, err := url.Parse(config.ClickHouseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.ClickHouseURL)
+	}
+	sink := &ClickHouseSink{
+		client:   &http.Client{Timeout: clickHouseTimeout},
+		settings: u.Query(),
+		table:    config.ClickHouseTable,
+		batch:    config.ClickHouseBatch,
+		username: config.ClickHouseUser,
+		password: config.ClickHousePassword,
+		queue:    make(chan clickHouseBatch, 1),
+	}
+	// Credentials may come with the URL too, and settings such as database in its query
+	if u.User != nil {
+		sink.username = u.User.Username()
+		sink.password, _ = u.User.Password()
+		u.User = nil
+	}
+	u.RawQuery = ""
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	sink.endpoint = u.String()
+
+	if err := sink.exec(fmt.Sprintf(clickHouseTable, sink.table)); err != nil {
+		return nil, fmt.Errorf("failed to create table %s: %w", sink.table, err)
+	}
+	go sink.send()
+	slog.Info("writing results to ClickHouse", "url", sink.endpoint, "table", sink.table)
+	return sink, nil
+}
+
+// do sends a request with the query and settings given, and gzip content encoding for bodies
+func (s *ClickHouseSink) do(settings url.Values, body []byte) error {
+	params := url.Values{}
+	for key, values := range s.settings {
+		params[key] = values
+	}
+	for key, values := range settings {
+		params[key] = values
+	}
+	req, err := http.NewRequest(http.MethodPost, s.endpoint+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if s.username != "" {
+		req.Header.Set("X-ClickHouse-User", s.username)
+		req.Header.Set("X-ClickHouse-Key", s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &clickHouseError{Message: err.Error(), Retryable: true}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &clickHouseError{Message: err.Error(), Retryable: true}
+	}
+	if resp.StatusCode >= 300 {
+		code, _ := strconv.Atoi(resp.Header.Get("X-ClickHouse-Exception-Code"))
+		return &clickHouseError{
+			Status:  resp.StatusCode,
+			Code:    code,
+			Message: strings.TrimSpace(string(data)),
+			Retryable: resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusBadGateway ||
+				resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout ||
+				slices.Contains(clickHouseRetryable, code),
+		}
+	}
+	return nil
+}
+
+// exec runs a statement without data
+func (s *ClickHouseSink) exec(statement string) error {
+	return retryClickHouse(clickHouseRetries, "ClickHouse statement", func() error {
+		return s.do(url.Values{"query": {statement}}, nil)
+	})
+}
+
+// Write queues a result, handing a batch to the sending goroutine once enough have come in
+func (s *ClickHouseSink) Write(result EmailResult) error {
+	row, err := json.Marshal(resultRow(result))
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return errors.New("ClickHouse sink is closed")
+	}
+	s.rows.Write(row)
+	s.rows.WriteByte('\n')
+	s.count++
+	if s.count >= s.batch {
+		s.enqueue()
+	}
+	return nil
+}
+
+// enqueue hands the queued rows to the sending goroutine, waiting while it's a batch behind
+func (s *ClickHouseSink) enqueue() {
+	if s.count == 0 {
+		return
+	}
+	s.pending.Add(1)
+	s.queue <- clickHouseBatch{rows: bytes.Clone(s.rows.Bytes()), count: s.count}
+	s.rows.Reset()
+	s.count = 0
+}
+
+// send inserts the batches handed to it until the sink is closed
+func (s *ClickHouseSink) send() {
+	for batch := range s.queue {
+		if err := s.insert(batch); err != nil {
+			slog.Warn("failed to insert results into ClickHouse", "table", s.table, "rows", batch.count, "error", err)
+			s.failed.Add(int64(batch.count))
+		}
+		s.pending.Done()
+	}
+}
+
+// insert inserts a batch in one request. Retries carry the same deduplication token, so a batch
+// ClickHouse took in before the connection failed isn't inserted twice.
+func (s *ClickHouseSink) insert(batch clickHouseBatch) error {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	zw.Write(batch.rows)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	settings := url.Values{
+		"query":                            {"INSERT INTO " + s.table + " FORMAT JSONEachRow"},
+		"date_time_input_format":           {"best_effort"},
+		"input_format_skip_unknown_fields": {"1"},
+		"insert_deduplication_token":       {sha256Hex(batch.rows)},
+	}
+	err := retryClickHouse(clickHouseRetries, "ClickHouse insert", func() error {
+		return s.do(settings, body.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	slog.Debug("inserted results into ClickHouse", "table", s.table, "rows", batch.count, "bytes", body.Len())
+	return nil
+}
+
+// Flush sends the rest of the queued results and waits for the inserts in flight
+func (s *ClickHouseSink) Flush() error {
+	s.mu.Lock()
+	if !s.closed {
+		s.enqueue()
+	}
+	s.mu.Unlock()
+	s.pending.Wait()
+
+	if failed := s.failed.Swap(0); failed > 0 {
+		return fmt.Errorf("failed to insert %d results into ClickHouse", failed)
+	}
+	return nil
+}
+
+// Close sends what's queued and stops the sending goroutine
+func (s *ClickHouseSink) Close() error {
+	err := s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	return err
+}
+
+// retryClickHouse retries retryable ClickHouse failures with exponential backoff
+func retryClickHouse(retries int, what string, fn func() error) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var apiErr *clickHouseError
+		if err == nil || !errors.As(err, &apiErr) || !apiErr.Retryable || attempt > retries {
+			if err != nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			return nil
+		}
+		slog.Warn(what+" failed, retrying", "attempt", attempt, "attempts", retries+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
@@ -0,0 +1,222 @@
+package verify
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clickHouseReply is a canned response of the fake ClickHouse server, with its exception code
+type clickHouseReply struct {
+	status int
+	code   int
+	body   string
+}
+
+// clickHouseCall is a request the fake ClickHouse server got, its body decompressed
+type clickHouseCall struct {
+	query  url.Values
+	header http.Header
+	rows   []map[string]any
+	raw    string
+}
+
+// fakeClickHouse serves the HTTP interface of ClickHouse
+type fakeClickHouse struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu      sync.Mutex
+	replies []clickHouseReply // replies to the requests, in turn; success once used up
+	calls   []clickHouseCall
+}
+
+func newFakeClickHouse(t *testing.T) *fakeClickHouse {
+	c := &fakeClickHouse{t: t}
+	c.server = httptest.NewServer(http.HandlerFunc(c.serve))
+	t.Cleanup(c.server.Close)
+	return c
+}
+
+func (c *fakeClickHouse) serve(w http.ResponseWriter, r *http.Request) {
+	call := clickHouseCall{query: r.URL.Query(), header: r.Header}
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			c.t.Errorf("body isn't gzipped: %v", err)
+			return
+		}
+		data, _ := io.ReadAll(zr)
+		call.raw = string(data)
+		for _, line := range strings.Split(strings.TrimSuffix(call.raw, "\n"), "\n") {
+			var row map[string]any
+			if err := json.Unmarshal([]byte(line), &row); err != nil {
+				c.t.Errorf("invalid JSONEachRow line %q", line)
+			}
+			call.rows = append(call.rows, row)
+		}
+	}
+
+	c.mu.Lock()
+	c.calls = append(c.calls, call)
+	reply := clickHouseReply{status: http.StatusOK}
+	if len(c.replies) > 0 {
+		reply, c.replies = c.replies[0], c.replies[1:]
+	}
+	c.mu.Unlock()
+	if reply.code != 0 {
+		w.Header().Set("X-ClickHouse-Exception-Code", strconv.Itoa(reply.code))
+	}
+	w.WriteHeader(reply.status)
+	io.WriteString(w, reply.body)
+}
+
+// inserts returns the insert requests made so far
+func (c *fakeClickHouse) inserts() []clickHouseCall {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []clickHouseCall
+	for _, call := range c.calls {
+		if strings.HasPrefix(call.query.Get("query"), "INSERT") {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *fakeClickHouse) reply(replies ...clickHouseReply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replies = append(c.replies, replies...)
+}
+
+func TestClickHouseBatches(t *testing.T) {
+	db := newFakeClickHouse(t)
+	config := DefaultConfig()
+	config.ClickHouseURL = strings.Replace(db.server.URL, "http://", "http://analytics:s3cret@", 1) + "/?database=crm&async_insert=0"
+	config.ClickHouseBatch = 2
+	sink, err := newClickHouseSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The table is created first, with the URL's settings and credentials
+	db.mu.Lock()
+	create := db.calls[0]
+	db.mu.Unlock()
+	if !strings.HasPrefix(create.query.Get("query"), "CREATE TABLE IF NOT EXISTS email_verifications (") ||
+		create.query.Get("database") != "crm" || create.query.Get("async_insert") != "0" {
+		t.Errorf("create request %v", create.query)
+	}
+	if create.header.Get("X-ClickHouse-User") != "analytics" || create.header.Get("X-ClickHouse-Key") != "s3cret" || create.header.Get("Authorization") != "" {
+		t.Errorf("create request authorized with %v", create.header)
+	}
+
+	results := sinkResults()
+	for _, result := range results {
+		if err := sink.Write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// A full batch goes out on its own, the rest waits for a flush
+	deadline := time.Now().Add(5 * time.Second)
+	for len(db.inserts()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	inserts := db.inserts()
+	if len(inserts) != 1 || len(inserts[0].rows) != 2 {
+		t.Fatalf("%d inserts before Close, want one of 2 rows", len(inserts))
+	}
+
+	// Close sends the partial batch
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+	inserts = db.inserts()
+	if len(inserts) != 2 || len(inserts[1].rows) != 1 || inserts[1].rows[0]["email"] != "info@acme.com" {
+		t.Fatalf("inserts after Close %v", inserts)
+	}
+
+	first := inserts[0]
+	if first.query.Get("query") != "INSERT INTO email_verifications FORMAT JSONEachRow" || first.query.Get("database") != "crm" ||
+		first.query.Get("input_format_skip_unknown_fields") != "1" || first.query.Get("date_time_input_format") != "best_effort" {
+		t.Errorf("insert settings %v", first.query)
+	}
+	if first.query.Get("insert_deduplication_token") != sha256Hex([]byte(first.raw)) {
+		t.Error("deduplication token isn't the batch's hash")
+	}
+	if first.rows[0]["email"] != "jane@acme.com" || first.rows[0]["valid"] != true || first.rows[1]["reason"] != "mailbox does not exist" {
+		t.Errorf("inserted rows %v", first.rows)
+	}
+
+	if err := sink.Write(results[0]); err == nil {
+		t.Error("wrote to a closed sink")
+	}
+	if err := sink.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
+
+func TestClickHouseErrors(t *testing.T) {
+	db := newFakeClickHouse(t)
+	config := DefaultConfig()
+	config.ClickHouseURL = db.server.URL
+	config.ClickHouseTable = "crm.verifications"
+	config.ClickHouseBatch = 10
+
+	// Errors carry the exception ClickHouse answered with
+	db.reply(clickHouseReply{http.StatusForbidden, 516, "Code: 516. DB::Exception: default: Authentication failed: password is incorrect. (AUTHENTICATION_FAILED)\n"})
+	_, err := newClickHouseSink(config)
+	if err == nil || err.Error() != "failed to create table crm.verifications: ClickHouse statement: ClickHouse returned 403: "+
+		"Code: 516. DB::Exception: default: Authentication failed: password is incorrect. (AUTHENTICATION_FAILED)" {
+		t.Errorf("got %v", err)
+	}
+
+	sink, err := newClickHouseSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+	results := sinkResults()
+
+	// Too many simultaneous queries passes: the insert is retried with the same token
+	db.reply(clickHouseReply{http.StatusInternalServerError, 202, "Code: 202. DB::Exception: Too many simultaneous queries. (TOO_MANY_SIMULTANEOUS_QUERIES)"})
+	sink.Write(results[0])
+	if err := sink.Flush(); err != nil {
+		t.Errorf("retried insert: %v", err)
+	}
+	inserts := db.inserts()
+	if len(inserts) != 2 || inserts[0].raw != inserts[1].raw ||
+		inserts[0].query.Get("insert_deduplication_token") != inserts[1].query.Get("insert_deduplication_token") {
+		t.Fatalf("%d inserts, want the same batch twice", len(inserts))
+	}
+
+	// An unknown table doesn't, and Flush reports the rows lost
+	db.reply(clickHouseReply{http.StatusNotFound, 60, "Code: 60. DB::Exception: Table crm.verifications does not exist. (UNKNOWN_TABLE)"})
+	sink.Write(results[1])
+	sink.Write(results[2])
+	if err := sink.Flush(); err == nil || err.Error() != "failed to insert 2 results into ClickHouse" {
+		t.Errorf("got %v", err)
+	}
+	if n := len(db.inserts()); n != 3 {
+		t.Errorf("%d inserts, want no retry of the failed one", n)
+	}
+	// The failure is reported once
+	if err := sink.Flush(); err != nil {
+		t.Errorf("second Flush: %v", err)
+	}
+
+	config.ClickHouseURL = "clickhouse://db:9000"
+	if _, err := newClickHouseSink(config); err == nil {
+		t.Error("accepted a native protocol URL")
+	}
+}
@@ -0,0 +1,382 @@
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClientOptions are the settings of submitting a file to a remote server
+type ClientOptions struct {
+	ServerURL  string // base URL of a server started with the serve command
+	Token      string // API token the server requires, if any
+	InputFile  string
+	OutputFile string
+	// Workers, Rate, SMTP and Level ask for job options other than the server's defaults where set
+	Workers     int
+	Rate        string
+	SMTP        string
+	Level       string
+	ChunkSizeMB int    // upload inputs larger than this in resumable chunks, 0 in one request
+	Retries     int    // retries per chunk on network and server errors
+	UploadID    string // chunked upload left unfinished by an earlier run, to resume
+	Delete      bool   // delete the job's results from the server once downloaded
+}
+
+// SubmitFile submits a local input file to a remote server, streams progress and downloads the
+// results
+func SubmitFile(opts ClientOptions) error {
+	client := &apiClient{
+		baseURL:   strings.TrimSuffix(opts.ServerURL, "/"),
+		token:     opts.Token,
+		http:      &http.Client{},
+		chunkSize: int64(opts.ChunkSizeMB) << 20,
+		retries:   opts.Retries,
+		uploadID:  opts.UploadID,
+	}
+
+	options := url.Values{}
+	if opts.Workers > 0 {
+		options.Set("workers", strconv.Itoa(opts.Workers))
+	}
+	if opts.Rate != "" {
+		options.Set("rate", opts.Rate)
+	}
+	if opts.SMTP != "" {
+		options.Set("smtp", opts.SMTP)
+	}
+	if opts.Level != "" {
+		options.Set("level", opts.Level)
+	}
+
+	var status JobStatus
+	var err error
+	for {
+		status, err = client.submit(opts.InputFile, options)
+		var apiErr *apiError
+		if !errors.As(err, &apiErr) || apiErr.RetryAfter == 0 {
+			break
+		}
+		slog.Info("server is busy, submitting again", "reason", apiErr.Message, "retry_after", apiErr.RetryAfter)
+		time.Sleep(apiErr.RetryAfter)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to submit job: %w", err)
+	}
+	slog.Info("submitted job", "job", status.ID, "emails", status.Total, "server", client.baseURL,
+		"workers", status.Options.Workers, "rate_limit", status.Options.Rate, "level", status.Options.level())
+
+	status, err = client.follow(status.ID)
+	if err != nil {
+		return fmt.Errorf("failed to follow job %s: %w", status.ID, err)
+	}
+	if status.Status == jobFailed {
+		return fmt.Errorf("job %s failed: %s", status.ID, status.Error)
+	}
+
+	if err := client.download(status.ID, opts.OutputFile); err != nil {
+		return fmt.Errorf("failed to download results: %w", err)
+	}
+	if opts.Delete {
+		if err := client.delete(status.ID); err != nil {
+			slog.Warn("failed to delete job from the server", "job", status.ID, "error", err)
+		}
+	}
+
+	slog.Info("verification complete", "job", status.ID, "checked", status.Checked, "valid", status.Valid,
+		"invalid", status.Invalid, "risky", status.Risky, "output", opts.OutputFile)
+	return nil
+}
+
+// apiClient talks to the job endpoints of a remote server
+type apiClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+
+	chunkSize int64
+	retries   int
+	uploadID  string
+}
+
+func (c *apiClient) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.send(req)
+}
+
+// send makes a request with the token, turning error responses into errors
+func (c *apiClient) send(req *http.Request) (*http.Response, error) {
+	method, path := req.Method, req.URL.Path
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request to %s failed: %w", c.baseURL, err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var body struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&body)
+		apiErr := &apiError{Method: method, Path: path, Status: resp.Status, Message: body.Error}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && resp.StatusCode == http.StatusServiceUnavailable {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// apiError is an error response from the server; RetryAfter is set when the server is too busy for now
+type apiError struct {
+	Method     string
+	Path       string
+	Status     string
+	Message    string
+	RetryAfter time.Duration
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s %s returned %s: %s", e.Method, e.Path, e.Status, e.Message)
+}
+
+// submit uploads the input file as a new job with the given overrides of the server's settings
+func (c *apiClient) submit(filename string, options url.Values) (JobStatus, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return JobStatus{}, fmt.Errorf("failed to open file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return JobStatus{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	var body io.Reader = file
+	if c.uploadID != "" || (c.chunkSize > 0 && info.Size() > c.chunkSize) {
+		id, err := c.uploadChunked(file, info.Size())
+		if err != nil {
+			return JobStatus{}, err
+		}
+		c.uploadID = id // a resubmission reuses the upload
+		options.Set("upload", id)
+		body = nil
+	}
+
+	path := "/jobs"
+	if len(options) > 0 {
+		path += "?" + options.Encode()
+	}
+	resp, err := c.do(http.MethodPost, path, body)
+	if err != nil {
+		return JobStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	var status JobStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return JobStatus{}, fmt.Errorf("failed to decode job status: %w", err)
+	}
+	return status, nil
+}
+
+// uploadChunked uploads a large input file in chunks, retrying failed chunks from wherever the
+// server says the upload stands, and returns the upload's ID
+func (c *apiClient) uploadChunked(file *os.File, size int64) (string, error) {
+	id := c.uploadID
+	if id == "" {
+		req, err := http.NewRequest(http.MethodPost, c.baseURL+"/uploads", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Upload-Length", strconv.FormatInt(size, 10))
+		resp, err := c.send(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to start upload: %w", err)
+		}
+		var upload InputUpload
+		err = json.NewDecoder(resp.Body).Decode(&upload)
+		resp.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to decode upload: %w", err)
+		}
+		id = upload.ID
+	}
+	slog.Info("uploading input in chunks, resume with -upload-id", "upload", id, "bytes", size)
+
+	offset, err := c.uploadOffset(id, size)
+	if err != nil {
+		return "", err
+	}
+	chunkSize := max(c.chunkSize, 1<<20)
+	backoff := time.Second
+	failures := 0
+	for offset < size {
+		n := min(chunkSize, size-offset)
+		req, err := http.NewRequest(http.MethodPatch, c.baseURL+"/uploads/"+id, io.NewSectionReader(file, offset, n))
+		if err != nil {
+			return "", err
+		}
+		req.ContentLength = n
+		req.Header.Set("Content-Type", "application/offset+octet-stream")
+		req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+
+		resp, err := c.send(req)
+		if err == nil {
+			resp.Body.Close()
+			offset, err = strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+		}
+		if err == nil {
+			failures = 0
+			backoff = time.Second
+			slog.Info("upload progress", "upload", id, "uploaded_bytes", offset, "bytes", size)
+			continue
+		}
+
+		failures++
+		if failures > c.retries {
+			return "", fmt.Errorf("upload %s failed at byte %d (resume with -upload-id=%s): %w", id, offset, id, err)
+		}
+		slog.Warn("chunk upload failed, retrying", "attempt", failures, "attempts", c.retries+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+		// Part of the chunk may have arrived; carry on from wherever the server got to
+		if current, err := c.uploadOffset(id, size); err == nil {
+			offset = current
+		}
+	}
+	return id, nil
+}
+
+// uploadOffset asks the server how much of an upload it has received
+func (c *apiClient) uploadOffset(id string, size int64) (int64, error) {
+	req, err := http.NewRequest(http.MethodHead, c.baseURL+"/uploads/"+id, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to look up upload %s: %w", id, err)
+	}
+	resp.Body.Close()
+	if length, _ := strconv.ParseInt(resp.Header.Get("Upload-Length"), 10, 64); length != size {
+		return 0, fmt.Errorf("upload %s is for a file of %d bytes, not this one of %d", id, length, size)
+	}
+	return strconv.ParseInt(resp.Header.Get("Upload-Offset"), 10, 64)
+}
+
+// follow streams progress until the job finishes, reconnecting if the stream drops
+func (c *apiClient) follow(id string) (JobStatus, error) {
+	status := JobStatus{ID: id}
+	lastReport := time.Time{}
+	failures := 0
+
+	for {
+		err := c.stream(id, func(s JobStatus) {
+			status = s
+			failures = 0
+			if s.Finished() || time.Since(lastReport) >= 5*time.Second {
+				logJobProgress(s)
+				lastReport = time.Now()
+			}
+		})
+		if status.Finished() {
+			return status, nil
+		}
+
+		failures++
+		if failures >= 5 {
+			if err == nil {
+				err = errors.New("progress stream ended before the job finished")
+			}
+			return status, err
+		}
+		if err != nil {
+			slog.Warn("progress stream interrupted, reconnecting", "error", err)
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// stream reads the newline-delimited status updates of a job
+func (c *apiClient) stream(id string, update func(JobStatus)) error {
+	resp, err := c.do(http.MethodGet, "/jobs/"+id+"/events", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var status JobStatus
+		if err := decoder.Decode(&status); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		update(status)
+	}
+}
+
+// download saves the job's output document
+func (c *apiClient) download(id, filename string) error {
+	resp, err := c.do(http.MethodGet, "/jobs/"+id+"/results", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// delete drops a finished job and its results from the server
+func (c *apiClient) delete(id string) error {
+	resp, err := c.do(http.MethodDelete, "/jobs/"+id, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func logJobProgress(s JobStatus) {
+	if s.Status == jobQueued {
+		slog.Info("job is queued", "job", s.ID)
+		return
+	}
+
+	percent := 0.0
+	if s.Total > 0 {
+		percent = float64(s.Checked) / float64(s.Total) * 100
+	}
+	slog.Info("progress", "job", s.ID, "checked", s.Checked, "total", s.Total, "percent", math.Round(percent*10)/10,
+		"invalid", s.Invalid, "status", s.Status)
+}
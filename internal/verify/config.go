@@ -0,0 +1,506 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Config holds the application configuration
+type Config struct {
+	InputFile  string
+	OutputFile string
+	Workers    int
+	BatchSize  int
+	RateLimit  time.Duration
+	EnableSMTP bool
+	Level      string
+	Verbose    bool
+	LogFormat  string
+	LogLevel   string
+	Simulate   bool
+	Resolver   string
+	RecordFile string
+	ReplayFile string
+
+	DNSCacheSize int
+	DNSUpstreams string
+
+	Retries       int
+	RetryBackoff  time.Duration
+	GreylistRetry time.Duration
+	MaxPerDomain  int
+	FairSchedule  bool
+	RampUp        time.Duration
+
+	EgressCheck     bool
+	EgressIPs       string
+	EgressIPURL     string
+	DNSBLs          string
+	EgressInterval  time.Duration
+	BlocklistAction string
+	FCrDNSCheck     bool
+	HelloName       string
+	FromEmail       string
+
+	IPFamily             string
+	SMTPPort             int
+	SMTPConnectTimeout   time.Duration
+	SMTPOperationTimeout time.Duration
+	EmailTimeout         time.Duration
+
+	Proxies       string
+	ProxyRotation string
+
+	DedupeProbes    bool
+	DomainCache     bool
+	CatchAllSamples int
+	CatchAllRisky   bool
+	RCPTTiming      bool
+	RejectRoles     bool
+	RolePrefixes    string
+	ExcludeFree     bool
+	Lookalikes      bool
+	Repair          string
+	RepairFile      string
+
+	CheckpointFile string
+	Resume         bool
+
+	Dedupe string
+
+	EnableRDAP    bool
+	RDAPURL       string
+	RDAPRateLimit time.Duration
+	MinDomainAge  time.Duration
+
+	EnableHIBP    bool
+	HIBPURL       string
+	HIBPAPIKey    string
+	HIBPRateLimit time.Duration
+
+	EnableGeo        bool
+	GeoIPURL         string
+	OnlyCountries    string
+	ExcludeCountries string
+
+	Regions       string
+	RegionDataDir string
+
+	DisposableList    string
+	DisposableExtra   string
+	DisposableRefresh time.Duration
+
+	Allowlist string
+	Blocklist string
+
+	TypoMarkets    string
+	KeyboardLayout string
+
+	EnableTLDCheck bool
+	TLDListURL     string
+	TLDCacheFile   string
+	TLDMaxAge      time.Duration
+	RefreshTLDs    bool
+
+	ProviderRates    string
+	DomainRate       string
+	DomainRates      string
+	RateLimitRedis   string
+	RateLimitPrefix  string
+	EnableStrategies bool
+	StrategyFile     string
+	PolicyFile       string
+	NoProbeProviders string
+
+	Checks      string
+	VerdictExpr string
+	PreHook     string
+	PostHook    string
+	Sinks       string
+
+	BigQueryProject string
+	BigQueryDataset string
+	BigQueryTable   string
+	BigQueryMode    string
+	BigQueryBatch   int
+	// BigQueryEndpoint is BIGQUERY_ENDPOINT, an emulator in tests
+	BigQueryEndpoint string
+
+	// Google credentials of the BigQuery sink and Sheets input: a service account key file, else
+	// the metadata server's
+	GoogleCredentials string
+	GCEMetadataHost   string
+
+	ElasticsearchURL      string
+	ElasticsearchIndex    string
+	ElasticsearchMapping  string
+	ElasticsearchBatch    int
+	ElasticsearchAPIKey   string
+	ElasticsearchUsername string
+	ElasticsearchPassword string
+	ElasticsearchCACert   string
+
+	ClickHouseURL      string
+	ClickHouseTable    string
+	ClickHouseBatch    int
+	ClickHouseUser     string
+	ClickHousePassword string
+
+	LDAPBaseDN       string
+	LDAPFilter       string
+	LDAPAttributes   string
+	LDAPBindDN       string
+	LDAPBindPassword string
+	LDAPStartTLS     bool
+	LDAPPageSize     int
+
+	MongoCollection  string
+	MongoQuery       string
+	MongoField       string
+	MongoResultField string
+	MongoBatch       int
+
+	SheetsColumn  string
+	SheetsResults string
+	SheetsBatch   int
+	// SheetsEndpoint is SHEETS_ENDPOINT, an emulator in tests
+	SheetsEndpoint string
+
+	EnableCompany    bool
+	CompanyProvider  string
+	CompanyAPIURL    string
+	CompanyAPIKey    string
+	CompanyRateLimit time.Duration
+
+	DetailsFile    string
+	ValidFile      string
+	FreeFile       string
+	OutputTemplate string
+	DomainStore    string
+
+	EnablePatterns bool
+	PatternsFile   string
+	PatternScore   bool
+
+	ResourceReport string
+
+	ValidityWindows string
+
+	Actions          bool
+	QuarantinePeriod string
+
+	SortBy  string
+	GroupBy string
+
+	OutputIndent     int
+	OutputCompact    bool
+	OutputColumns    string
+	OutputHeader     bool
+	OutputFileFormat string
+	DetailColumns    string
+
+	UploadPartSize int
+	UploadRetries  int
+
+	ManifestFile    string
+	SignKey         string
+	SignKeyPassword string
+
+	SplitRecords bool
+	RecordsFile  string
+
+	Warehouse          string
+	WarehouseStage     string
+	WarehouseTable     string
+	WarehouseAuth      string
+	WarehouseShardRows int
+
+	InputFormat   string
+	InputColumn   string
+	InputIDColumn string
+	InputHeader   bool
+
+	// keepResults keeps every result for a caller of processEmails, such as the server's /verify
+	keepResults bool
+	// onResult is called with each result as it comes in, for Runner.Stream
+	onResult func(EmailResult)
+}
+
+// csvInput is how addresses are read from delimited input files
+func (c Config) csvInput() CSVInput {
+	return CSVInput{Column: c.InputColumn, IDColumn: c.InputIDColumn, Header: c.InputHeader}
+}
+
+// wantsDetails reports whether every result must be kept, not just invalid ones
+func (c Config) wantsDetails() bool {
+	return c.DetailsFile != "" || c.OutputTemplate != "" || c.SplitRecords || c.WarehouseStage != "" || c.keepResults
+}
+
+// DefaultConfig returns the default settings of a run, those of the flags with no environment
+// variables set
+func DefaultConfig() Config {
+	return Config{
+		Workers:              runtime.NumCPU() * 2,
+		BatchSize:            1000,
+		RateLimit:            10 * time.Millisecond,
+		EnableSMTP:           true,
+		DNSBLs:               defaultDNSBLs,
+		EgressInterval:       10 * time.Minute,
+		BlocklistAction:      blocklistPause,
+		FCrDNSCheck:          true,
+		HelloName:            libraryHelloName,
+		FromEmail:            libraryFromEmail,
+		IPFamily:             familyAuto,
+		SMTPPort:             librarySMTPPort,
+		SMTPConnectTimeout:   libraryConnectTimeout,
+		SMTPOperationTimeout: libraryOperationTimeout,
+		EmailTimeout:         2 * time.Minute,
+		ProxyRotation:        proxyRoundRobin,
+		LogFormat:            logFormatText,
+		LogLevel:             "info",
+		DNSCacheSize:         10000,
+		Retries:              2,
+		RetryBackoff:         time.Second,
+		FairSchedule:         true,
+		RampUp:               2 * time.Minute,
+		DedupeProbes:         true,
+		DomainCache:          true,
+		Lookalikes:           true,
+		Repair:               repairOff,
+		RepairFile:           dataDir + "/repairs.json",
+		Dedupe:               dedupeOff,
+		InputFile:            dataDir + "/data.json",
+		OutputFile:           dataDir + "/invalid_emails.json",
+		RDAPRateLimit:        500 * time.Millisecond,
+		MinDomainAge:         30 * 24 * time.Hour,
+		HIBPRateLimit:        6 * time.Second,
+		CompanyRateLimit:     200 * time.Millisecond,
+		DisposableRefresh:    24 * time.Hour,
+		TLDMaxAge:            7 * 24 * time.Hour,
+		EnableStrategies:     true,
+		NoProbeProviders:     defaultNoProbeProviders,
+		BigQueryMode:         bigQueryStream,
+		BigQueryBatch:        500,
+		BigQueryEndpoint:     defaultBigQueryEndpoint,
+		GCEMetadataHost:      defaultMetadataHost,
+		ElasticsearchIndex:   "email-verifications",
+		ElasticsearchBatch:   500,
+		ClickHouseTable:      "email_verifications",
+		ClickHouseBatch:      100000,
+		LDAPFilter:           "(mail=*)",
+		LDAPAttributes:       "mail",
+		LDAPPageSize:         500,
+		MongoField:           "email",
+		MongoResultField:     "verification",
+		MongoBatch:           500,
+		SheetsColumn:         "email",
+		SheetsResults:        "valid,risky,reason",
+		SheetsBatch:          1000,
+		SheetsEndpoint:       defaultSheetsEndpoint,
+		PatternsFile:         dataDir + "/patterns.json",
+		ValidityWindows:      builtinValidityWindows,
+		QuarantinePeriod:     "30d",
+		UploadPartSize:       8,
+		UploadRetries:        5,
+		OutputIndent:         2,
+		OutputColumns:        defaultColumns,
+		OutputHeader:         true,
+		OutputFileFormat:     outputAuto,
+		DetailColumns:        defaultDetailColumns,
+		RecordsFile:          dataDir + "/records.json",
+		Warehouse:            warehouseSnowflake,
+		WarehouseTable:       "email_verifications",
+		WarehouseShardRows:   1000000,
+		InputFormat:          inputAuto,
+		InputColumn:          "email",
+		InputHeader:          true,
+		RDAPURL:              "https://rdap.org",
+		HIBPURL:              "https://haveibeenpwned.com/api/v3",
+		CompanyProvider:      "http",
+		TLDListURL:           ianaTLDListURL,
+		TLDCacheFile:         dataDir + "/tlds.txt",
+		EgressIPURL:          "https://api.ipify.org",
+		RateLimitPrefix:      "email-verification:rate:",
+	}
+}
+
+// Normalize checks the settings, resolving the ones implied by others
+func (c *Config) Normalize() error {
+	switch c.Level = strings.ToLower(c.Level); c.Level {
+	case "":
+		c.Level = levelDNS
+		if c.EnableSMTP {
+			c.Level = levelSMTP
+		}
+	case levelSyntax, levelDNS, levelSMTP:
+		c.EnableSMTP = c.Level == levelSMTP
+	default:
+		return fmt.Errorf("invalid level %q (expected %s, %s or %s)", c.Level, levelSyntax, levelDNS, levelSMTP)
+	}
+	if c.Simulate && c.ReplayFile != "" {
+		return errors.New("-simulate and -replay both stand in for the network; use one")
+	}
+	// Simulated and replayed runs make no connections, so checks of the probing setup have nothing to check
+	if c.Simulate || c.ReplayFile != "" {
+		c.EgressCheck, c.FCrDNSCheck = false, false
+		c.CatchAllSamples, c.RCPTTiming = 0, false
+		c.GreylistRetry = 0
+	}
+
+	if c.HelloName == "" || strings.ContainsAny(c.HelloName, " \t<>@") {
+		return fmt.Errorf("invalid HELO name %q (expected a host name)", c.HelloName)
+	}
+	if at := strings.LastIndex(c.FromEmail, "@"); at < 1 || at == len(c.FromEmail)-1 || strings.ContainsAny(c.FromEmail, " \t<>") {
+		return fmt.Errorf("invalid MAIL FROM address %q", c.FromEmail)
+	}
+	if !validIPFamily(c.IPFamily) {
+		return fmt.Errorf("invalid IP family %q (expected %s, %s or %s)", c.IPFamily, familyAuto, familyIPv4, familyIPv6)
+	}
+	if c.SMTPPort < 1 || c.SMTPPort > 65535 {
+		return fmt.Errorf("invalid SMTP port %d", c.SMTPPort)
+	}
+	if c.IPFamily != familyAuto && c.Proxies != "" {
+		return fmt.Errorf("-ip-family=%s can't be combined with -proxies, which pick the family they reach MX hosts over", c.IPFamily)
+	}
+	if c.SMTPConnectTimeout <= 0 || c.SMTPOperationTimeout <= 0 {
+		return fmt.Errorf("SMTP timeouts must be positive, got %v and %v", c.SMTPConnectTimeout, c.SMTPOperationTimeout)
+	}
+	if c.EmailTimeout < 0 {
+		return fmt.Errorf("invalid email timeout %v", c.EmailTimeout)
+	}
+	if c.Proxies != "" {
+		c.ProxyRotation = strings.ToLower(c.ProxyRotation)
+		if c.ProxyRotation != proxyRoundRobin && c.ProxyRotation != proxySticky {
+			return fmt.Errorf("invalid proxy rotation %q (expected %s or %s)", c.ProxyRotation, proxyRoundRobin, proxySticky)
+		}
+		// Mail servers see the proxies' IPs, which only they know unless listed
+		if c.EgressIPs == "" {
+			c.EgressCheck, c.FCrDNSCheck = false, false
+		}
+	}
+
+	if c.Retries < 0 {
+		return fmt.Errorf("invalid retries %d (expected 0 or more)", c.Retries)
+	}
+	if c.GreylistRetry < 0 {
+		return fmt.Errorf("invalid greylist retry delay %v", c.GreylistRetry)
+	}
+	// -verbose is the debug level, which logs every address
+	if c.Verbose {
+		c.LogLevel = "debug"
+	} else if strings.EqualFold(c.LogLevel, "debug") {
+		c.Verbose = true
+	}
+
+	if c.BigQueryTable != "" {
+		c.BigQueryMode = strings.ToLower(c.BigQueryMode)
+		if c.BigQueryMode != bigQueryStream && c.BigQueryMode != bigQueryLoad {
+			return fmt.Errorf("invalid BigQuery mode %q (expected %s or %s)", c.BigQueryMode, bigQueryStream, bigQueryLoad)
+		}
+		if c.BigQueryBatch < 1 || c.BigQueryBatch > 50000 {
+			return fmt.Errorf("invalid BigQuery batch %d (expected 1 to 50000)", c.BigQueryBatch)
+		}
+	}
+	if c.ElasticsearchURL != "" {
+		if c.ElasticsearchIndex == "" || c.ElasticsearchIndex != strings.ToLower(c.ElasticsearchIndex) || strings.ContainsAny(c.ElasticsearchIndex, ` "*\/<>|?,#:`) || strings.ContainsAny(c.ElasticsearchIndex[:1], "-_+") {
+			return fmt.Errorf("invalid Elasticsearch index %q (expected lowercase, without spaces or special characters)", c.ElasticsearchIndex)
+		}
+		if c.ElasticsearchBatch < 1 {
+			return fmt.Errorf("invalid Elasticsearch batch %d (expected 1 or more)", c.ElasticsearchBatch)
+		}
+	}
+	if c.ClickHouseURL != "" {
+		if !sqlIdentifier.MatchString(c.ClickHouseTable) || strings.Count(c.ClickHouseTable, ".") > 1 {
+			return fmt.Errorf("invalid ClickHouse table %q (expected table or database.table)", c.ClickHouseTable)
+		}
+		if c.ClickHouseBatch < 1 {
+			return fmt.Errorf("invalid ClickHouse batch %d (expected 1 or more)", c.ClickHouseBatch)
+		}
+	}
+	if c.LDAPPageSize < 1 {
+		return fmt.Errorf("invalid LDAP page size %d (expected 1 or more)", c.LDAPPageSize)
+	}
+	if c.MongoBatch < 1 {
+		return fmt.Errorf("invalid MongoDB batch %d (expected 1 or more)", c.MongoBatch)
+	}
+	if c.SheetsBatch < 1 {
+		return fmt.Errorf("invalid Google Sheets batch %d (expected 1 or more)", c.SheetsBatch)
+	}
+	if c.WarehouseStage != "" {
+		c.Warehouse = strings.ToLower(c.Warehouse)
+		if c.Warehouse != warehouseSnowflake && c.Warehouse != warehouseRedshift {
+			return fmt.Errorf("invalid warehouse %q (expected %s or %s)", c.Warehouse, warehouseSnowflake, warehouseRedshift)
+		}
+		if obj, ok := parseObjectURL(c.WarehouseStage); c.Warehouse == warehouseRedshift && (!ok || obj.Scheme != schemeS3) {
+			return fmt.Errorf("invalid warehouse stage %q (Redshift copies from an s3:// prefix)", c.WarehouseStage)
+		}
+		if !sqlIdentifier.MatchString(c.WarehouseTable) {
+			return fmt.Errorf("invalid warehouse table %q", c.WarehouseTable)
+		}
+		if c.Warehouse == warehouseSnowflake && c.WarehouseAuth != "" && !sqlIdentifier.MatchString(c.WarehouseAuth) {
+			return fmt.Errorf("invalid Snowflake storage integration %q", c.WarehouseAuth)
+		}
+		if c.WarehouseShardRows < 1 {
+			return fmt.Errorf("invalid warehouse shard rows %d (expected 1 or more)", c.WarehouseShardRows)
+		}
+	}
+	if c.RampUp < 0 {
+		return fmt.Errorf("invalid ramp-up %v", c.RampUp)
+	}
+	if c.MaxPerDomain < 0 {
+		return fmt.Errorf("invalid max per domain %d (expected 0 for no limit or more)", c.MaxPerDomain)
+	}
+	if c.Resume && c.CheckpointFile == "" {
+		return errors.New("-resume needs a -checkpoint file to resume from")
+	}
+	if !validDedupeMode(c.Dedupe) {
+		return fmt.Errorf("invalid dedupe mode %q (expected %s, %s, %s or %s)", c.Dedupe, dedupeOff, dedupeExact, dedupeNormalized, dedupeMailbox)
+	}
+	if !validRepairMode(c.Repair) {
+		return fmt.Errorf("invalid repair mode %q (expected %s, %s or %s)", c.Repair, repairOff, repairSuggest, repairAuto)
+	}
+
+	if c.DNSCacheSize < 0 {
+		return fmt.Errorf("invalid DNS cache size %d", c.DNSCacheSize)
+	}
+	if c.DisposableRefresh < 0 {
+		return fmt.Errorf("invalid disposable list refresh interval %v", c.DisposableRefresh)
+	}
+	if !validInputFormat(c.InputFormat) {
+		return fmt.Errorf("invalid input format %q (expected %s, %s, %s, %s, %s, %s, %s or %s)", c.InputFormat, inputAuto, inputJSON, inputJSONL, inputCSV, inputTSV, inputText, inputVCard, inputOutlook)
+	}
+	if !validBlocklistAction(c.BlocklistAction) {
+		return fmt.Errorf("invalid blocklist action %q (expected %s or %s)", c.BlocklistAction, blocklistPause, blocklistWarn)
+	}
+	if c.EgressInterval < time.Minute {
+		return fmt.Errorf("egress check interval must be at least 1m, got %v", c.EgressInterval)
+	}
+	if !validOutputFormat(c.OutputFileFormat) {
+		return fmt.Errorf("invalid output format %q (expected %s, %s, %s, %s or %s)", c.OutputFileFormat, outputAuto, outputJSON, outputJSONL, outputCSV, outputTSV)
+	}
+	if !validSortKey(c.SortBy) {
+		return fmt.Errorf("invalid sort key %q (expected %s, %s or %s)", c.SortBy, sortByReason, sortByDomain, sortByEmail)
+	}
+	if !validGroupKey(c.GroupBy) {
+		return fmt.Errorf("invalid grouping %q (expected %s)", c.GroupBy, groupByDomain)
+	}
+	if c.SignKey != "" && c.ManifestFile == "" {
+		return errors.New("signing requires a manifest to sign (-manifest)")
+	}
+	if c.OutputIndent < 0 {
+		return fmt.Errorf("invalid output indent %d (expected 0 or more spaces)", c.OutputIndent)
+	}
+
+	if c.PatternScore {
+		c.EnablePatterns = true
+	}
+
+	if c.EnableHIBP && c.HIBPAPIKey == "" {
+		return errors.New("breach checks require HIBP_API_KEY to be set")
+	}
+
+	return nil
+}
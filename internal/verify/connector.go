@@ -0,0 +1,271 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connector page sizes, as ?limit= on the polling endpoints
+const (
+	connectorPageSize    = 100
+	connectorMaxPageSize = 1000
+)
+
+// FeedEntry is a result as no-code platforms such as Zapier and Make consume it: flat, with every
+// field present, and an id that is unique across server restarts so pollers can deduplicate
+type FeedEntry struct {
+	ID         string    `json:"id"`
+	Email      string    `json:"email"`
+	Status     string    `json:"status"` // valid, risky, invalid or unknown
+	Valid      bool      `json:"valid"`
+	Risky      bool      `json:"risky"`
+	Reason     string    `json:"reason"`
+	Confidence float64   `json:"confidence"`
+	Country    string    `json:"country"`
+	Greylisted bool      `json:"greylisted"`
+	JobID      string    `json:"job_id"`
+	CheckedAt  time.Time `json:"checked_at"`
+
+	seq int64
+}
+
+// ResultFeed keeps the latest results the server verified, newest last, for polling triggers.
+// Once full, the oldest results make way for new ones.
+type ResultFeed struct {
+	epoch string // tells the ids of this server process apart from those of earlier ones
+
+	mu      sync.Mutex
+	entries []FeedEntry // a ring once full, the oldest at head
+	head    int
+	size    int
+	next    int64
+}
+
+// newResultFeed creates a feed of the latest size results, or nil for none
+func newResultFeed(size int) *ResultFeed {
+	if size <= 0 {
+		return nil
+	}
+	return &ResultFeed{epoch: strconv.FormatInt(time.Now().Unix(), 36), size: size, next: 1}
+}
+
+// Add appends a result, verified by the given job or by a synchronous request if jobID is empty.
+// Without a feed the entry is only converted.
+func (f *ResultFeed) Add(result EmailResult, jobID string) FeedEntry {
+	status := "invalid"
+	switch {
+	case result.IsValid:
+		status = "valid"
+	case result.Risky:
+		status = "risky"
+	case result.errored:
+		status = "unknown"
+	}
+	entry := FeedEntry{
+		Email:      result.Email,
+		Status:     status,
+		Valid:      result.IsValid,
+		Risky:      result.Risky,
+		Reason:     result.Reason,
+		Confidence: result.Confidence,
+		Country:    result.Country,
+		Greylisted: result.Greylisted,
+		JobID:      jobID,
+		CheckedAt:  result.CheckedAt,
+	}
+	if f == nil {
+		return entry
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entry.seq = f.next
+	entry.ID = f.epoch + "-" + strconv.FormatInt(f.next, 10)
+	f.next++
+	if len(f.entries) < f.size {
+		f.entries = append(f.entries, entry)
+	} else {
+		f.entries[f.head] = entry
+		f.head = (f.head + 1) % f.size
+	}
+	return entry
+}
+
+// Page returns up to limit entries matching status (any if empty), newest first, starting after
+// the entry the cursor names, and the cursor of the next page if there is one. Cursors of an
+// earlier server process start over from the newest entry.
+func (f *ResultFeed) Page(cursor, status string, limit int) ([]FeedEntry, string) {
+	if f == nil {
+		return []FeedEntry{}, ""
+	}
+	before := int64(-1)
+	if epoch, seq, ok := strings.Cut(cursor, "-"); ok && epoch == f.epoch {
+		before, _ = strconv.ParseInt(seq, 10, 64)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	page := make([]FeedEntry, 0, min(limit, len(f.entries)))
+	for i := len(f.entries) - 1; i >= 0; i-- {
+		entry := f.entries[(f.head+i)%len(f.entries)]
+		if before >= 0 && entry.seq >= before {
+			continue
+		}
+		if status != "" && entry.Status != status {
+			continue
+		}
+		if len(page) == limit {
+			return page, page[len(page)-1].ID
+		}
+		page = append(page, entry)
+	}
+	return page, ""
+}
+
+// Size returns how many results the feed keeps
+func (f *ResultFeed) Size() int {
+	if f == nil {
+		return 0
+	}
+	return f.size
+}
+
+// FeedJob is the status of a job, flat like FeedEntry
+type FeedJob struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Checked    int64      `json:"checked"`
+	Valid      int64      `json:"valid"`
+	Invalid    int64      `json:"invalid"`
+	Risky      int64      `json:"risky"`
+	Error      string     `json:"error"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	ResultsURL string     `json:"results_url"`
+}
+
+// handleConnector serves the endpoints no-code platforms poll and call: an authentication test, a
+// verification action, and triggers for new results and jobs, paginated by cursor
+func handleConnector(mux *http.ServeMux, jobs *JobManager, config Config, limits JobLimits) {
+	mux.HandleFunc("GET /connector/me", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"ok": true, "level": config.Level, "feed_size": jobs.feed.Size()})
+	})
+
+	mux.HandleFunc("POST /connector/verify", func(w http.ResponseWriter, r *http.Request) {
+		options, err := parseJobOptions(r.URL.Query(), config, limits)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		// Platforms send JSON or form fields, and some only query parameters
+		email := r.URL.Query().Get("email")
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+			var request struct {
+				Email string `json:"email"`
+			}
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&request); err == nil && request.Email != "" {
+				email = request.Email
+			}
+		} else if err := r.ParseForm(); err == nil && r.PostForm.Get("email") != "" {
+			email = r.PostForm.Get("email")
+		}
+		if email = strings.TrimSpace(email); email == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "expected an email field"})
+			return
+		}
+		if err := jobs.Admit(1); err != nil {
+			rejectJob(w, err)
+			return
+		}
+		results, _ := jobs.verify(r.Context(), []string{email}, options)
+		if len(results) == 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "the pre-hook dropped the address"})
+			return
+		}
+		writeJSON(w, http.StatusOK, jobs.feed.Add(results[0], ""))
+	})
+
+	mux.HandleFunc("GET /connector/results", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit, err := connectorLimit(query.Get("limit"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		status := query.Get("status")
+		if status != "" && status != "valid" && status != "risky" && status != "invalid" && status != "unknown" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "status must be valid, risky, invalid or unknown"})
+			return
+		}
+		results, next := jobs.feed.Page(query.Get("cursor"), status, limit)
+		writeJSON(w, http.StatusOK, map[string]any{"results": results, "next_cursor": next})
+	})
+
+	mux.HandleFunc("GET /connector/jobs", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		limit, err := connectorLimit(query.Get("limit"))
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		statuses := jobs.snapshots()
+		// Newest first, ties broken by id so pages are stable
+		slices.SortFunc(statuses, func(a, b JobStatus) int {
+			if c := b.CreatedAt.Compare(a.CreatedAt); c != 0 {
+				return c
+			}
+			return strings.Compare(a.ID, b.ID)
+		})
+		cursor := query.Get("cursor")
+		page := make([]FeedJob, 0, min(limit, len(statuses)))
+		next := ""
+		for _, status := range statuses {
+			if cursor != "" {
+				if status.ID == cursor {
+					cursor = ""
+				}
+				continue
+			}
+			if s := query.Get("status"); s != "" && status.Status != s {
+				continue
+			}
+			if len(page) == limit {
+				next = page[len(page)-1].ID
+				break
+			}
+			page = append(page, FeedJob{
+				ID:         status.ID,
+				Status:     status.Status,
+				Total:      status.Total,
+				Checked:    status.Checked,
+				Valid:      status.Valid,
+				Invalid:    status.Invalid,
+				Risky:      status.Risky,
+				Error:      status.Error,
+				CreatedAt:  status.CreatedAt,
+				FinishedAt: status.FinishedAt,
+				ResultsURL: "/jobs/" + status.ID + "/results",
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"jobs": page, "next_cursor": next})
+	})
+}
+
+// connectorLimit parses a page size, defaulting to connectorPageSize
+func connectorLimit(v string) (int, error) {
+	if v == "" {
+		return connectorPageSize, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > connectorMaxPageSize {
+		return 0, fmt.Errorf("limit must be between 1 and %d", connectorMaxPageSize)
+	}
+	return limit, nil
+}
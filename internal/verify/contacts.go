@@ -0,0 +1,196 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"mime/quotedprintable"
+	"regexp"
+	"strings"
+)
+
+// outlookEmailColumn matches the address columns of an Outlook contacts export: E-mail Address,
+// E-mail 2 Address and E-mail 3 Address
+var outlookEmailColumn = regexp.MustCompile(`(?i)^e-?mail( \d)? address$`)
+
+// contactFormat reports whether an input format holds contacts, each with several addresses
+func contactFormat(format string) bool {
+	return format == inputVCard || format == inputOutlook
+}
+
+// contactRecord makes a record of a contact's addresses, identified by its name
+func contactRecord(name string, emails []string) InputRecord {
+	return InputRecord{ID: strings.Join(strings.Fields(name), " "), Email: strings.Join(emails, "; ")}
+}
+
+// decodeVCardInput calls each for every contact of a vCard file (versions 2.1, 3.0 and 4.0) that
+// has an address, with all of its EMAIL properties
+func decodeVCardInput(r io.Reader, each func(InputRecord)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	// Lines are unfolded before parsing: continuations start with a space or tab, and version 2.1
+	// quoted-printable values end with = when they go on
+	var lines []string
+	number, qpContinued := 0, false
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if number++; number == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		switch {
+		case qpContinued && len(lines) > 0:
+			lines[len(lines)-1] = strings.TrimSuffix(lines[len(lines)-1], "=") + line
+		case (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0:
+			lines[len(lines)-1] += line[1:]
+		case line != "":
+			lines = append(lines, line)
+		}
+		qpContinued = strings.HasSuffix(line, "=") && len(lines) > 0 && strings.Contains(strings.ToUpper(vCardParams(lines[len(lines)-1])), "QUOTED-PRINTABLE")
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read vCard input: %w", err)
+	}
+
+	var name, structured, org string
+	var emails []string
+	inCard := false
+	for _, line := range lines {
+		property, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		params := vCardParams(property)
+		property, _, _ = strings.Cut(property, ";")
+		if _, after, grouped := strings.Cut(property, "."); grouped {
+			property = after // item1.EMAIL, as Apple Contacts writes
+		}
+		if strings.Contains(strings.ToUpper(params), "QUOTED-PRINTABLE") {
+			if decoded, err := io.ReadAll(quotedprintable.NewReader(strings.NewReader(value))); err == nil {
+				value = string(decoded)
+			}
+		}
+
+		switch strings.ToUpper(property) {
+		case "BEGIN":
+			if strings.EqualFold(value, "VCARD") {
+				inCard, name, structured, org, emails = true, "", "", "", nil
+			}
+		case "END":
+			if strings.EqualFold(value, "VCARD") && inCard {
+				inCard = false
+				if len(emails) == 0 {
+					continue
+				}
+				if name == "" {
+					name = structured
+				}
+				if name == "" {
+					name = org
+				}
+				each(contactRecord(name, emails))
+			}
+		case "FN":
+			name = vCardText(value)
+		case "N":
+			// Family;Given;Additional;Prefixes;Suffixes, shown as written in English
+			parts := append(strings.Split(value, ";"), "", "", "", "")
+			structured = vCardText(strings.Join([]string{parts[3], parts[1], parts[2], parts[0], parts[4]}, " "))
+		case "ORG":
+			org, _, _ = strings.Cut(value, ";")
+			org = vCardText(org)
+		case "EMAIL":
+			email := strings.TrimSpace(vCardText(value))
+			if len(email) > 7 && strings.EqualFold(email[:7], "mailto:") {
+				email = email[7:]
+			}
+			if email != "" {
+				emails = append(emails, email)
+			}
+		}
+	}
+	return nil
+}
+
+// vCardParams returns the parameters of a property line, the part between its name and the colon
+func vCardParams(line string) string {
+	property, _, _ := strings.Cut(line, ":")
+	_, params, _ := strings.Cut(property, ";")
+	return params
+}
+
+// vCardText unescapes a vCard text value
+func vCardText(value string) string {
+	return strings.NewReplacer(`\n`, " ", `\N`, " ", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// decodeOutlookInput calls each for every contact of an Outlook contacts CSV export that has an
+// address, with the addresses of all of its e-mail columns
+func decodeOutlookInput(r io.Reader, each func(InputRecord)) error {
+	buffered := bufio.NewReaderSize(r, 1024*1024) // 1MB buffer
+	// Outlook writes a byte-order mark, which would break the quotes of the first column
+	if bom, _ := buffered.Peek(3); string(bom) == "\ufeff" {
+		buffered.Discard(3)
+	}
+	reader := csv.NewReader(buffered)
+	reader.FieldsPerRecord = -1
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read Outlook CSV header: %w", err)
+	}
+
+	var emailColumns, nameColumns []int
+	companyColumn := -1
+	for i, column := range header {
+		switch column = strings.TrimSpace(column); {
+		case outlookEmailColumn.MatchString(column):
+			emailColumns = append(emailColumns, i)
+		case strings.EqualFold(column, "First Name"), strings.EqualFold(column, "Middle Name"), strings.EqualFold(column, "Last Name"):
+			nameColumns = append(nameColumns, i)
+		case strings.EqualFold(column, "Company"):
+			companyColumn = i
+		}
+	}
+	if len(emailColumns) == 0 {
+		return fmt.Errorf("no e-mail address columns in the header (%s); is it an Outlook contacts export?", strings.Join(header, ", "))
+	}
+
+	field := func(row []string, i int) string {
+		if i < 0 || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read Outlook CSV: %w", err)
+		}
+		var emails []string
+		for _, i := range emailColumns {
+			// Exchange contacts carry X.500 addresses (/o=ExchangeLabs/...) that can't be verified
+			if email := field(row, i); email != "" && !strings.HasPrefix(email, "/") {
+				emails = append(emails, email)
+			}
+		}
+		if len(emails) == 0 {
+			continue
+		}
+		var names []string
+		for _, i := range nameColumns {
+			names = append(names, field(row, i))
+		}
+		name := strings.Join(names, " ")
+		if strings.TrimSpace(name) == "" {
+			name = field(row, companyColumn)
+		}
+		each(contactRecord(name, emails))
+	}
+}
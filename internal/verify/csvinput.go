@@ -0,0 +1,92 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// CSVInput configures how addresses are read from .csv and .tsv input files
+type CSVInput struct {
+	Column   string // header name, or position starting at 1
+	IDColumn string // optional column holding the record ID
+	Header   bool   // whether the first row names the columns
+}
+
+// decodeCSVInput calls each for every row of a delimited input file, reading it row by row
+func decodeCSVInput(r io.Reader, delimiter rune, opts CSVInput, each func(InputRecord)) error {
+	reader := csv.NewReader(bufio.NewReaderSize(r, 1024*1024)) // 1MB buffer
+	reader.Comma = delimiter
+	reader.FieldsPerRecord = -1 // exports often have ragged rows
+	reader.ReuseRecord = true
+
+	var header []string
+	if opts.Header {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV header: %w", err)
+		}
+		header = make([]string, len(row))
+		for i, name := range row {
+			header[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")) // Excel writes a BOM
+		}
+	}
+
+	emailColumn, err := csvColumn(opts.Column, header)
+	if err != nil {
+		return err
+	}
+	idColumn := -1
+	if opts.IDColumn != "" {
+		if idColumn, err = csvColumn(opts.IDColumn, header); err != nil {
+			return err
+		}
+	}
+
+	for {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CSV: %w", err)
+		}
+		if len(row) == 1 && row[0] == "" {
+			continue
+		}
+		if emailColumn >= len(row) {
+			line, _ := reader.FieldPos(0)
+			return fmt.Errorf("line %d has %d columns, expected the address in column %d", line, len(row), emailColumn+1)
+		}
+		record := InputRecord{Email: strings.TrimSpace(row[emailColumn])}
+		if idColumn >= 0 && idColumn < len(row) {
+			record.ID = strings.TrimSpace(row[idColumn])
+		}
+		each(record)
+	}
+}
+
+// csvColumn resolves a column given by header name (case-insensitive) or position starting at 1
+func csvColumn(column string, header []string) (int, error) {
+	if position, err := strconv.Atoi(column); err == nil {
+		if position < 1 {
+			return 0, fmt.Errorf("invalid column %d: positions start at 1", position)
+		}
+		return position - 1, nil
+	}
+	if header == nil {
+		return 0, fmt.Errorf("column %q can only be found by name with a header row; give its position instead", column)
+	}
+	for i, name := range header {
+		if strings.EqualFold(name, column) {
+			return i, nil
+		}
+	}
+	return 0, fmt.Errorf("no column %q in the header (%s)", column, strings.Join(header, ", "))
+}
@@ -0,0 +1,59 @@
+package verify
+
+import (
+	"log/slog"
+	"strings"
+)
+
+// Dedupe modes
+const (
+	dedupeOff        = "off"
+	dedupeExact      = "exact"
+	dedupeNormalized = "normalized"
+	dedupeMailbox    = "mailbox"
+)
+
+// validDedupeMode reports whether mode is a known dedupe mode
+func validDedupeMode(mode string) bool {
+	switch mode {
+	case dedupeOff, dedupeExact, dedupeNormalized, dedupeMailbox:
+		return true
+	}
+	return false
+}
+
+// dedupeKey is what two addresses share when they count as duplicates: the address itself,
+// trimmed and lowercased when normalized, or the mailbox it delivers to (Gmail dots, +tags,
+// domain aliases)
+func dedupeKey(email, mode string) string {
+	switch mode {
+	case dedupeNormalized:
+		return strings.ToLower(strings.TrimSpace(email))
+	case dedupeMailbox:
+		return canonicalMailbox(email)
+	}
+	return email
+}
+
+// dedupeEmails drops repeats of addresses earlier in the list, keeping the first spelling, and
+// reports how many were dropped. The list is filtered in place.
+func dedupeEmails(emails []string, mode string) ([]string, int) {
+	if mode == dedupeOff {
+		return emails, 0
+	}
+	seen := make(map[string]struct{}, len(emails))
+	kept := emails[:0]
+	for _, email := range emails {
+		key := dedupeKey(email, mode)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		kept = append(kept, email)
+	}
+	dropped := len(emails) - len(kept)
+	if dropped > 0 {
+		slog.Info("dropped duplicate addresses", "duplicates", dropped, "mode", mode, "remaining", len(kept))
+	}
+	return kept, dropped
+}
@@ -0,0 +1,182 @@
+package verify
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultColumns are the columns of delimited output unless configured otherwise
+const defaultColumns = "email,reason,risky,expires_at"
+
+// defaultDetailColumns are the columns of a delimited details output unless configured otherwise
+const defaultDetailColumns = "email,valid,reason,reachable,disposable,suggestion"
+
+// Column is a column of delimited output: a result field, or a static value such as a campaign ID
+type Column struct {
+	Header string
+	Field  string
+	Value  string
+}
+
+// invalidFields are the fields of an invalid email available as columns
+var invalidFields = map[string]func(InvalidEmail) string{
+	"email":  func(e InvalidEmail) string { return e.Email },
+	"domain": func(e InvalidEmail) string { return emailDomain(e.Email) },
+	"reason": func(e InvalidEmail) string { return e.Reason },
+	"risky":  func(e InvalidEmail) string { return strconv.FormatBool(e.Risky) },
+	"expires_at": func(e InvalidEmail) string {
+		if e.ExpiresAt == nil {
+			return ""
+		}
+		return e.ExpiresAt.Format(time.RFC3339)
+	}, "action": func(e InvalidEmail) string { return e.Action },
+	"review_after": func(e InvalidEmail) string {
+		if e.ReviewAfter == nil {
+			return ""
+		}
+		return e.ReviewAfter.Format(time.RFC3339)
+	},
+}
+
+// resultFields are the fields of a full result available as details columns. The library's
+// signals are empty for addresses whose verification errored.
+var resultFields = map[string]func(EmailResult) string{
+	"email":  func(r EmailResult) string { return r.Email },
+	"domain": func(r EmailResult) string { return emailDomain(r.Email) },
+	"valid":  func(r EmailResult) string { return strconv.FormatBool(r.IsValid) },
+	"risky":  func(r EmailResult) string { return strconv.FormatBool(r.Risky) },
+	"reason": func(r EmailResult) string { return r.Reason },
+	"reachable": func(r EmailResult) string {
+		if r.raw == nil {
+			return ""
+		}
+		return r.raw.Reachable
+	},
+	"disposable": func(r EmailResult) string {
+		if r.raw == nil {
+			return ""
+		}
+		return strconv.FormatBool(r.raw.Disposable)
+	},
+	"role_account": func(r EmailResult) string {
+		if r.raw == nil {
+			return ""
+		}
+		return strconv.FormatBool(r.raw.RoleAccount)
+	},
+	"free": func(r EmailResult) string {
+		if r.raw == nil {
+			return ""
+		}
+		return strconv.FormatBool(r.raw.Free)
+	},
+	"suggestion": func(r EmailResult) string {
+		if r.raw == nil {
+			return ""
+		}
+		return r.raw.Suggestion
+	},
+	"confidence": func(r EmailResult) string {
+		if r.Confidence == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(r.Confidence, 'f', -1, 64)
+	},
+	"country":     func(r EmailResult) string { return r.Country },
+	"greylisted":  func(r EmailResult) string { return strconv.FormatBool(r.Greylisted) },
+	"allowlisted": func(r EmailResult) string { return strconv.FormatBool(r.Allowlisted) },
+	"catch_all":   func(r EmailResult) string { return strconv.FormatBool(r.CatchAllDomain) },
+	"deferred":    func(r EmailResult) string { return strconv.FormatBool(r.Deferred) },
+	"policy":      func(r EmailResult) string { return r.Policy },
+	"probed_as":   func(r EmailResult) string { return r.ProbedAs },
+	"checked_at":  func(r EmailResult) string { return r.CheckedAt.Format(time.RFC3339) },
+	"expires_at": func(r EmailResult) string {
+		if r.ExpiresAt == nil {
+			return ""
+		}
+		return r.ExpiresAt.Format(time.RFC3339)
+	}, "action": func(r EmailResult) string { return r.Action },
+	"review_after": func(r EmailResult) string {
+		if r.ReviewAfter == nil {
+			return ""
+		}
+		return r.ReviewAfter.Format(time.RFC3339)
+	},
+}
+
+// parseColumns parses a column spec such as "email:Email Address,reason,=CMP-42:campaign_id".
+// Each entry is a field, optionally renamed with :Header, or =value:Header for a static column.
+func parseColumns[T any](spec string, fields map[string]func(T) string) ([]Column, error) {
+	var columns []Column
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		source, header, renamed := strings.Cut(entry, ":")
+		if value, static := strings.CutPrefix(source, "="); static {
+			if !renamed || header == "" {
+				return nil, fmt.Errorf("static column %q needs a header, as =value:header", entry)
+			}
+			columns = append(columns, Column{Header: header, Value: value})
+			continue
+		}
+		if _, ok := fields[source]; !ok {
+			return nil, fmt.Errorf("unknown column %q (expected one of %s, or =value:header)", source, strings.Join(columnFields(fields), ", "))
+		}
+		if !renamed || header == "" {
+			header = source
+		}
+		columns = append(columns, Column{Header: header, Field: source})
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no columns configured")
+	}
+	return columns, nil
+}
+
+// columnFields lists the fields available as columns
+func columnFields[T any](fields map[string]func(T) string) []string {
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// delimiterFor returns the delimiter of a CSV or TSV file, going by its extension
+func delimiterFor(filename string) (rune, bool) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return ',', true
+	case ".tsv":
+		return '\t', true
+	}
+	return 0, false
+}
+
+// writeDetailsDelimited writes every result as delimited rows with the configured columns
+func writeDetailsDelimited(filename string, details []EmailResult, columns []Column, delimiter rune, header bool) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	writer := newDelimitedWriter(file, file, columns, resultFields, delimiter, header)
+	for _, result := range details {
+		if err := writer.Write(result); err != nil {
+			return fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+	}
+	if err := writer.Close(nil); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
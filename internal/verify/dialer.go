@@ -0,0 +1,173 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+)
+
+// Address families SMTP probes connect over, with -ip-family
+const (
+	familyAuto = "auto"
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+)
+
+func validIPFamily(family string) bool {
+	return family == familyAuto || family == familyIPv4 || family == familyIPv6
+}
+
+// happyEyeballsDelay is how long a connection attempt runs alone before the next address is
+// tried alongside it, as RFC 8305 recommends
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// ProbeDialer connects SMTP probes to MX hosts over IPv6 and IPv4 with Happy Eyeballs (RFC 8305):
+// addresses of both families are tried in turn, IPv6 first, each getting a head start before
+// the next joins, and the first to connect wins. IPv6-only and IPv4-only hosts both work, and a
+// broken IPv6 route only costs the head start. -ip-family restricts probes to one family.
+type ProbeDialer struct {
+	family   string
+	resolver *net.Resolver
+}
+
+func newProbeDialer(family string, resolver *net.Resolver) *ProbeDialer {
+	return &ProbeDialer{family: family, resolver: resolver}
+}
+
+// DialTimeout connects a probe, resolving the MX host with the dialer's resolver. The connection
+// carries the family it was made over, for connFamily.
+func (d *ProbeDialer) DialTimeout(addr string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	conn, family, err := d.dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	return &familyConn{Conn: conn, family: family}, nil
+}
+
+// familyConn is a connection of the probe dialer and the address family it was made over
+type familyConn struct {
+	net.Conn
+	family string
+}
+
+// connFamily returns the address family of a connection of the probe dialer, or "" for others,
+// such as those through a proxy
+func connFamily(conn net.Conn) string {
+	if conn, ok := conn.(*familyConn); ok {
+		return conn.family
+	}
+	return ""
+}
+
+// dial connects to the host of a host:port, returning the family of the address that answered
+func (d *ProbeDialer) dial(ctx context.Context, addr string) (net.Conn, string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, "", err
+	}
+	ips, err := d.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, "", err
+	}
+	ordered := orderAddresses(ips, d.family)
+	if len(ordered) == 0 {
+		return nil, "", fmt.Errorf("dial tcp %s: host has no %s address", addr, map[string]string{familyIPv4: "IPv4", familyIPv6: "IPv6"}[d.family])
+	}
+	var dialer net.Dialer
+	conn, family, err := dialHappyEyeballs(ctx, dialer.DialContext, ordered, port)
+	if err != nil {
+		return nil, "", err
+	}
+	return smtpTraffic.count(conn), family, nil
+}
+
+// orderAddresses returns the addresses of the family, or of both with auto, interleaved IPv6 first
+// and keeping the resolver's order within each family
+func orderAddresses(ips []net.IPAddr, family string) []net.IP {
+	var v6, v4 []net.IP
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip.IP)
+		} else {
+			v6 = append(v6, ip.IP)
+		}
+	}
+	switch family {
+	case familyIPv4:
+		v6 = nil
+	case familyIPv6:
+		v4 = nil
+	}
+	var ordered []net.IP
+	for i := 0; i < max(len(v6), len(v4)); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
+
+// dialHappyEyeballs starts a connection to each address in order with dial, the next one once the
+// last has failed or had its head start, and returns the first to connect
+func dialHappyEyeballs(ctx context.Context, dial func(ctx context.Context, network, addr string) (net.Conn, error), ips []net.IP, port string) (net.Conn, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type dialed struct {
+		conn   net.Conn
+		family string
+		err    error
+	}
+	results := make(chan dialed, len(ips))
+	start := func(ip net.IP) {
+		network, family := "tcp6", familyIPv6
+		if ip.To4() != nil {
+			network, family = "tcp4", familyIPv4
+		}
+		go func() {
+			conn, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+			results <- dialed{conn, family, err}
+		}()
+	}
+
+	start(ips[0])
+	next, pending := 1, 1
+	headStart := time.NewTimer(happyEyeballsDelay)
+	defer headStart.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case result := <-results:
+			pending--
+			if result.err == nil {
+				// Connections still being made are cancelled, and closed should they win the race
+				go func() {
+					for ; pending > 0; pending-- {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}()
+				return result.conn, result.family, nil
+			}
+			if firstErr == nil {
+				firstErr = result.err
+			}
+		case <-headStart.C:
+		}
+		// A failure or the end of the head start lets the next address in
+		if next < len(ips) {
+			start(ips[next])
+			next++
+			pending++
+			headStart.Reset(happyEyeballsDelay)
+		}
+	}
+	return nil, "", firstErr
+}
@@ -0,0 +1,220 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDial answers connection attempts by address: an error fails at once, a delay connects once
+// it has passed, and an address without either stalls until the attempt is cancelled
+type fakeDial struct {
+	delays map[string]time.Duration
+	errs   map[string]error
+
+	mu      sync.Mutex
+	started []string
+	closed  []string
+}
+
+func (f *fakeDial) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	f.mu.Lock()
+	f.started = append(f.started, addr)
+	f.mu.Unlock()
+	if err, ok := f.errs[addr]; ok {
+		return nil, err
+	}
+	delay, ok := f.delays[addr]
+	if !ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	// A connection that completes regardless of the cancellation, as a racing one can
+	time.Sleep(delay)
+	client, server := net.Pipe()
+	server.Close()
+	return &closeRecorder{Conn: client, dial: f, addr: addr}, nil
+}
+
+func (f *fakeDial) attempts() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.Clone(f.started)
+}
+
+type closeRecorder struct {
+	net.Conn
+	dial *fakeDial
+	addr string
+}
+
+func (c *closeRecorder) Close() error {
+	c.dial.mu.Lock()
+	c.dial.closed = append(c.dial.closed, c.addr)
+	c.dial.mu.Unlock()
+	return c.Conn.Close()
+}
+
+var (
+	testIPv6  = net.ParseIP("2001:db8::1")
+	testIPv6b = net.ParseIP("2001:db8::2")
+	testIPv4  = net.ParseIP("192.0.2.1")
+	testIPv4b = net.ParseIP("192.0.2.2")
+)
+
+func TestOrderAddresses(t *testing.T) {
+	resolved := []net.IPAddr{{IP: testIPv4}, {IP: testIPv4b}, {IP: testIPv6}, {IP: testIPv6b}}
+	tests := []struct {
+		family string
+		want   []net.IP
+	}{
+		{familyAuto, []net.IP{testIPv6, testIPv4, testIPv6b, testIPv4b}},
+		{familyIPv4, []net.IP{testIPv4, testIPv4b}},
+		{familyIPv6, []net.IP{testIPv6, testIPv6b}},
+	}
+	for _, tt := range tests {
+		got := orderAddresses(resolved, tt.family)
+		if !slices.EqualFunc(got, tt.want, net.IP.Equal) {
+			t.Errorf("%s: got %v, want %v", tt.family, got, tt.want)
+		}
+	}
+	if got := orderAddresses([]net.IPAddr{{IP: testIPv4}}, familyIPv6); len(got) != 0 {
+		t.Errorf("IPv6 of an IPv4-only host: got %v, want none", got)
+	}
+}
+
+func TestHappyEyeballsPrefersFirstAddress(t *testing.T) {
+	dial := &fakeDial{delays: map[string]time.Duration{"[2001:db8::1]:25": 0, "192.0.2.1:25": 0}}
+	conn, family, err := dialHappyEyeballs(context.Background(), dial.dial, []net.IP{testIPv6, testIPv4}, "25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if family != familyIPv6 {
+		t.Errorf("family = %s, want %s", family, familyIPv6)
+	}
+	// The first address connected within its head start, so the next was never tried
+	if got := dial.attempts(); len(got) != 1 {
+		t.Errorf("attempts = %v, want only the IPv6 address", got)
+	}
+}
+
+func TestHappyEyeballsFallsBackOnFailure(t *testing.T) {
+	dial := &fakeDial{
+		errs:   map[string]error{"[2001:db8::1]:25": errors.New("network is unreachable")},
+		delays: map[string]time.Duration{"192.0.2.1:25": 0},
+	}
+	start := time.Now()
+	conn, family, err := dialHappyEyeballs(context.Background(), dial.dial, []net.IP{testIPv6, testIPv4}, "25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if family != familyIPv4 {
+		t.Errorf("family = %s, want %s", family, familyIPv4)
+	}
+	// A failure lets the next address in without waiting for the head start
+	if elapsed := time.Since(start); elapsed >= happyEyeballsDelay {
+		t.Errorf("fallback took %v, want less than the %v head start", elapsed, happyEyeballsDelay)
+	}
+}
+
+func TestHappyEyeballsHeadStart(t *testing.T) {
+	// The IPv6 address stalls; the IPv4 one only joins after the head start, and connects
+	dial := &fakeDial{delays: map[string]time.Duration{"192.0.2.1:25": 0}}
+	start := time.Now()
+	conn, family, err := dialHappyEyeballs(context.Background(), dial.dial, []net.IP{testIPv6, testIPv4}, "25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if family != familyIPv4 {
+		t.Errorf("family = %s, want %s", family, familyIPv4)
+	}
+	if elapsed := time.Since(start); elapsed < happyEyeballsDelay {
+		t.Errorf("IPv4 connected after %v, before the %v head start", elapsed, happyEyeballsDelay)
+	}
+	if got, want := dial.attempts(), []string{"[2001:db8::1]:25", "192.0.2.1:25"}; !slices.Equal(got, want) {
+		t.Errorf("attempts = %v, want %v", got, want)
+	}
+}
+
+func TestHappyEyeballsOrderAndLateConnections(t *testing.T) {
+	// Every address gets its head start in order; the second connects first, and the first,
+	// connecting later, is closed rather than leaked
+	dial := &fakeDial{delays: map[string]time.Duration{
+		"[2001:db8::1]:25": 3 * happyEyeballsDelay / 2,
+		"192.0.2.1:25":     happyEyeballsDelay / 10,
+	}}
+	ips := []net.IP{testIPv6, testIPv4, testIPv6b, testIPv4b}
+	conn, family, err := dialHappyEyeballs(context.Background(), dial.dial, ips, "25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if family != familyIPv4 {
+		t.Errorf("family = %s, want %s", family, familyIPv4)
+	}
+	if got, want := dial.attempts(), []string{"[2001:db8::1]:25", "192.0.2.1:25"}; !slices.Equal(got, want) {
+		t.Errorf("attempts = %v, want %v", got, want)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		dial.mu.Lock()
+		closed := slices.Clone(dial.closed)
+		dial.mu.Unlock()
+		if slices.Contains(closed, "[2001:db8::1]:25") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("late IPv6 connection was not closed, closed %v", closed)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHappyEyeballsAllFail(t *testing.T) {
+	refused := errors.New("connection refused")
+	dial := &fakeDial{errs: map[string]error{
+		"[2001:db8::1]:25": refused,
+		"192.0.2.1:25":     errors.New("no route to host"),
+	}}
+	_, _, err := dialHappyEyeballs(context.Background(), dial.dial, []net.IP{testIPv6, testIPv4}, "25")
+	if !errors.Is(err, refused) {
+		t.Errorf("err = %v, want the first failure", err)
+	}
+	if got := dial.attempts(); len(got) != 2 {
+		t.Errorf("attempts = %v, want both addresses", got)
+	}
+}
+
+func TestIPFamilyRejectedWithProxies(t *testing.T) {
+	config := DefaultConfig()
+	config.Proxies = "socks5://127.0.0.1:1080"
+	config.IPFamily = familyIPv6
+	if err := config.Normalize(); err == nil {
+		t.Error("normalize accepted -ip-family=ipv6 with -proxies")
+	}
+	config.IPFamily = familyAuto
+	if err := config.Normalize(); err != nil {
+		t.Errorf("normalize rejected -ip-family=auto with -proxies: %v", err)
+	}
+}
+
+func TestSMTPPortValidated(t *testing.T) {
+	config := DefaultConfig()
+	config.Proxies = "socks5://127.0.0.1:1080"
+	config.SMTPPort = 2525
+	if err := config.Normalize(); err != nil {
+		t.Errorf("normalize rejected -smtp-port=2525 with -proxies: %v", err)
+	}
+	config.SMTPPort = 0
+	if err := config.Normalize(); err == nil {
+		t.Error("normalize accepted -smtp-port=0")
+	}
+}
@@ -0,0 +1,187 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// disposableMaxList bounds a fetched list; the upstream list of every known service is a few MB
+const disposableMaxList = 64 << 20
+
+// DisposableDomains checks domains against custom disposable lists, loaded from files or URLs
+// listing one domain per line or holding a JSON array like the upstream list. The -disposable-list
+// sources replace the built-in list, while -disposable-extra sources supplement it. A listed
+// domain covers its subdomains too. Sources are reloaded every interval, and one that fails to
+// reload keeps its previous domains.
+type DisposableDomains struct {
+	replace  []string
+	extra    []string
+	regional *RegionalLists // still applies when the built-in list is replaced
+	http     *http.Client
+
+	mu      sync.Mutex
+	sources map[string]map[string]bool // the domains of each source, as last loaded
+	domains atomic.Pointer[map[string]bool]
+	stop    chan struct{}
+}
+
+// newDisposableDomains loads the comma-separated replace and extra sources, failing if any can't
+// be loaded, and reloads them every interval unless it is 0
+func newDisposableDomains(replace, extra string, interval time.Duration, regional *RegionalLists) (*DisposableDomains, error) {
+	d := &DisposableDomains{
+		replace:  splitList(replace),
+		extra:    splitList(extra),
+		regional: regional,
+		http:     &http.Client{Timeout: 30 * time.Second},
+		sources:  make(map[string]map[string]bool),
+		stop:     make(chan struct{}),
+	}
+	for _, source := range append(append([]string(nil), d.replace...), d.extra...) {
+		domains, err := d.load(source)
+		if err != nil {
+			return nil, err
+		}
+		d.sources[source] = domains
+	}
+	d.merge()
+	if interval > 0 {
+		go d.run(interval)
+	}
+	return d, nil
+}
+
+// load reads the domains of a file or http(s) URL
+func (d *DisposableDomains) load(source string) (map[string]bool, error) {
+	var data []byte
+	if strings.HasPrefix(source, "http://") || strings.HasPrefix(source, "https://") {
+		resp, err := d.http.Get(source)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch disposable list: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to fetch disposable list: %s returned %d", source, resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, disposableMaxList)); err != nil {
+			return nil, fmt.Errorf("failed to fetch disposable list %s: %w", source, err)
+		}
+	} else {
+		var err error
+		if data, err = os.ReadFile(source); err != nil {
+			return nil, fmt.Errorf("failed to read disposable list: %w", err)
+		}
+	}
+
+	domains := make(map[string]bool)
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		var list []string
+		if err := json.Unmarshal(trimmed, &list); err != nil {
+			return nil, fmt.Errorf("invalid disposable list %s: %w", source, err)
+		}
+		for _, domain := range list {
+			if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+				domains[domain] = true
+			}
+		}
+	} else if err := scanDomainList(bytes.NewReader(data), domains); err != nil {
+		return nil, fmt.Errorf("failed to read disposable list %s: %w", source, err)
+	}
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("no domains in disposable list %s", source)
+	}
+	return domains, nil
+}
+
+// merge combines the domains of every source into the set lookups are answered from
+func (d *DisposableDomains) merge() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	merged := make(map[string]bool)
+	for _, domains := range d.sources {
+		for domain := range domains {
+			merged[strings.TrimSuffix(domain, ".")] = true
+		}
+	}
+	d.domains.Store(&merged)
+}
+
+func (d *DisposableDomains) run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+		}
+		for source := range d.sources {
+			domains, err := d.load(source)
+			if err != nil {
+				slog.Warn("failed to refresh disposable list, keeping the previous one", "source", source, "error", err)
+				continue
+			}
+			d.mu.Lock()
+			d.sources[source] = domains
+			d.mu.Unlock()
+		}
+		d.merge()
+		slog.Debug("refreshed disposable lists", "domains", d.Len())
+	}
+}
+
+// Close stops the periodic refresh
+func (d *DisposableDomains) Close() {
+	close(d.stop)
+}
+
+// Len returns how many domains the custom lists hold
+func (d *DisposableDomains) Len() int {
+	return len(*d.domains.Load())
+}
+
+// Replaces reports whether the custom lists replace the built-in one
+func (d *DisposableDomains) Replaces() bool {
+	return len(d.replace) > 0
+}
+
+// match reports whether a domain or one of its parent domains is on the custom lists
+func (d *DisposableDomains) match(domain string) bool {
+	domains := *d.domains.Load()
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for {
+		if domains[domain] {
+			return true
+		}
+		_, parent, ok := strings.Cut(domain, ".")
+		if !ok || !strings.Contains(parent, ".") {
+			return false
+		}
+		domain = parent
+	}
+}
+
+// Wrap returns the disposable check to use: the custom lists, with the built-in check unless
+// they replace it. Without custom lists the built-in check is returned as is.
+func (d *DisposableDomains) Wrap(builtin func(string) bool) func(string) bool {
+	if d == nil {
+		return builtin
+	}
+	return func(domain string) bool {
+		if d.match(domain) {
+			return true
+		}
+		if d.Replaces() {
+			return d.regional != nil && d.regional.IsDisposable(domain)
+		}
+		return builtin(domain)
+	}
+}
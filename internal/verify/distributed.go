@@ -0,0 +1,426 @@
+package verify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxUnitAttempts is how many times a work unit is handed out before its job is failed, so a unit
+// that crashes every worker can't go round forever
+const maxUnitAttempts = 3
+
+// Work unit errors
+var (
+	errUnitNotFound = errors.New("work unit not found")
+	errLeaseLost    = errors.New("work unit was reassigned to another worker")
+	errJobCancelled = errors.New("job was cancelled")
+	errCheckpoint   = errors.New("checkpoint covers more addresses than the unit holds")
+	errUnitShrunk   = errors.New("work unit shrank since it was leased; report it with checkpoints")
+)
+
+// WorkUnit is a slice of a job leased to a worker
+type WorkUnit struct {
+	ID         string     `json:"id"`
+	JobID      string     `json:"job_id"`
+	Emails     []string   `json:"emails"`
+	Options    JobOptions `json:"options"`
+	Heartbeat  string     `json:"heartbeat"`  // how often the worker must check in
+	Checkpoint int        `json:"checkpoint"` // addresses between checkpoints, 0 for none
+}
+
+// UnitResult is what a worker reports back for a work unit
+type UnitResult struct {
+	Worker  string         `json:"worker"`
+	Invalid []InvalidEmail `json:"invalid"`
+	Checked int64          `json:"checked"`
+	Valid   int64          `json:"valid"`
+	Risky   int64          `json:"risky"`
+}
+
+// UnitCheckpoint reports the results of the first Done addresses of a unit the worker holds
+type UnitCheckpoint struct {
+	UnitResult
+	Done int `json:"done"`
+}
+
+// QueueDepth is the work waiting for and held by workers, for autoscalers
+type QueueDepth struct {
+	PendingUnits     int `json:"pending_units"`
+	PendingAddresses int `json:"pending_addresses"`
+	LeasedUnits      int `json:"leased_units"`
+	LeasedAddresses  int `json:"leased_addresses"`
+	Workers          int `json:"workers"`
+	StaleWorkers     int `json:"stale_workers"`
+}
+
+// WorkerStatus is a worker as seen by the coordinator
+type WorkerStatus struct {
+	Name     string    `json:"name"`
+	LastSeen time.Time `json:"last_seen"`
+	Units    []string  `json:"units"`
+	Stale    bool      `json:"stale"`
+}
+
+// workUnit is a work unit and its lease
+type workUnit struct {
+	WorkUnit
+	run      *workRun
+	worker   string
+	expires  time.Time
+	attempts int
+	shrunk   bool // by checkpoints or splits, so results for the whole unit as leased no longer fit
+}
+
+// workRun collects the results of one job's units
+type workRun struct {
+	job       *job
+	remaining int
+	invalid   []InvalidEmail
+	err       error
+	done      chan struct{}
+}
+
+// WorkQueue hands the units of running jobs to workers and takes their results. Workers hold a
+// unit only as long as they keep heartbeating; a unit whose lease runs out goes back in the queue.
+// Checkpoints shrink a unit as its addresses are verified, so a reassigned unit resumes where its
+// last worker stopped, and an idle worker takes over the unstarted half of the largest unit.
+type WorkQueue struct {
+	unitSize   int
+	timeout    time.Duration
+	checkpoint int
+
+	mu      sync.Mutex
+	pending []*workUnit
+	leased  map[string]*workUnit
+	workers map[string]time.Time
+}
+
+func newWorkQueue(unitSize int, timeout time.Duration, checkpoint int) *WorkQueue {
+	q := &WorkQueue{
+		unitSize:   unitSize,
+		timeout:    timeout,
+		checkpoint: checkpoint,
+		leased:     make(map[string]*workUnit),
+		workers:    make(map[string]time.Time),
+	}
+	go q.reap(max(timeout/4, time.Second))
+	return q
+}
+
+// Run splits a job into units and waits until workers have verified all of them
+func (q *WorkQueue) Run(j *job, emails []string) ([]InvalidEmail, error) {
+	run := &workRun{job: j, done: make(chan struct{})}
+	var units []*workUnit
+	for start := 0; start < len(emails); start += q.unitSize {
+		unit, err := q.newUnit(run, emails[start:min(start+q.unitSize, len(emails))])
+		if err != nil {
+			return nil, err
+		}
+		units = append(units, unit)
+	}
+	if len(units) == 0 {
+		return nil, nil
+	}
+	run.remaining = len(units)
+
+	q.mu.Lock()
+	q.pending = append(q.pending, units...)
+	q.mu.Unlock()
+	slog.Info("split job into work units", "job", j.id, "units", len(units), "unit_size", q.unitSize)
+
+	<-run.done
+	return run.invalid, run.err
+}
+
+func (q *WorkQueue) newUnit(run *workRun, emails []string) (*workUnit, error) {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return &workUnit{
+		WorkUnit: WorkUnit{
+			ID:         hex.EncodeToString(id),
+			JobID:      run.job.id,
+			Emails:     emails,
+			Options:    run.job.options,
+			Heartbeat:  (q.timeout / 3).String(),
+			Checkpoint: q.checkpoint,
+		},
+		run: run,
+	}, nil
+}
+
+// Lease hands the next waiting unit to a worker, splitting a leased one when none is waiting
+func (q *WorkQueue) Lease(worker string) (WorkUnit, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.workers[worker] = time.Now()
+	if len(q.pending) == 0 {
+		q.split()
+	}
+	if len(q.pending) == 0 {
+		return WorkUnit{}, false
+	}
+	unit := q.pending[0]
+	q.pending = q.pending[1:]
+	unit.worker = worker
+	unit.expires = time.Now().Add(q.timeout)
+	unit.attempts++
+	q.leased[unit.ID] = unit
+	return unit.WorkUnit, true
+}
+
+// Heartbeat extends a worker's lease on a unit
+func (q *WorkQueue) Heartbeat(worker, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.workers[worker] = time.Now()
+	unit, ok := q.leased[id]
+	if !ok {
+		return errUnitNotFound
+	}
+	if unit.worker != worker {
+		return errLeaseLost
+	}
+	unit.expires = time.Now().Add(q.timeout)
+	return nil
+}
+
+// Complete records the results of a whole unit, for workers that don't checkpoint. A worker whose
+// lease ran out may still finish first; its results are as good as anyone's, and the reassigned
+// copy is dropped.
+func (q *WorkQueue) Complete(id string, result UnitResult) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.workers[result.Worker] = time.Now()
+	unit, ok := q.leased[id]
+	if !ok {
+		for i, pending := range q.pending {
+			if pending.ID == id {
+				unit, ok = pending, true
+				q.pending = append(q.pending[:i], q.pending[i+1:]...)
+				break
+			}
+		}
+	}
+	if !ok {
+		return errUnitNotFound
+	}
+	if unit.shrunk {
+		return errUnitShrunk
+	}
+	delete(q.leased, id)
+	unit.run.record(result)
+	unit.run.unitDone()
+	return nil
+}
+
+// Checkpoint records the results of the first addresses of a unit and drops them from it. It
+// returns how many addresses the worker still holds, fewer than it thinks if an idle worker took
+// some over; none left completes the unit. Unlike Complete, only the unit's current holder may
+// checkpoint, as the addresses done count from where the unit stands.
+func (q *WorkQueue) Checkpoint(id string, checkpoint UnitCheckpoint) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.workers[checkpoint.Worker] = time.Now()
+	unit, ok := q.leased[id]
+	if !ok {
+		return 0, errUnitNotFound
+	}
+	if unit.worker != checkpoint.Worker {
+		return 0, errLeaseLost
+	}
+	if checkpoint.Done < 0 || checkpoint.Done > len(unit.Emails) {
+		return 0, errCheckpoint
+	}
+
+	unit.run.record(checkpoint.UnitResult)
+	unit.Emails = unit.Emails[checkpoint.Done:]
+	unit.shrunk = unit.shrunk || checkpoint.Done > 0
+	unit.expires = time.Now().Add(q.timeout)
+	if len(unit.Emails) == 0 {
+		delete(q.leased, id)
+		unit.run.unitDone()
+	}
+	return len(unit.Emails), nil
+}
+
+// Release puts a unit back at the front of the queue, for a worker shutting down between checkpoints
+func (q *WorkQueue) Release(worker, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	unit, ok := q.leased[id]
+	if !ok {
+		return errUnitNotFound
+	}
+	if unit.worker != worker {
+		return errLeaseLost
+	}
+	delete(q.leased, id)
+	// Handing a unit back is not a failed attempt
+	unit.attempts--
+	q.pending = append([]*workUnit{unit}, q.pending...)
+	slog.Info("worker released work unit", "worker", worker, "unit", id, "job", unit.JobID, "remaining", len(unit.Emails))
+	return nil
+}
+
+// split moves the unstarted half of the largest leased unit to a new pending unit. The first
+// checkpoint's worth of addresses stays, as its worker may be verifying them already; units of
+// workers that don't checkpoint are left alone, as they'd never learn of the split. The caller
+// holds q.mu.
+func (q *WorkQueue) split() {
+	if q.checkpoint <= 0 {
+		return
+	}
+	var largest *workUnit
+	for _, unit := range q.leased {
+		if largest == nil || len(unit.Emails) > len(largest.Emails) {
+			largest = unit
+		}
+	}
+	if largest == nil || len(largest.Emails) < 2*q.checkpoint {
+		return
+	}
+	keep := max(q.checkpoint, len(largest.Emails)/2)
+	unit, err := q.newUnit(largest.run, largest.Emails[keep:])
+	if err != nil {
+		return
+	}
+	largest.Emails = largest.Emails[:keep]
+	largest.shrunk = true
+	largest.run.remaining++
+	q.pending = append(q.pending, unit)
+	slog.Info("split addresses off a work unit for an idle worker", "unit", largest.ID, "job", largest.JobID, "emails", len(unit.Emails))
+}
+
+// Depth counts the units and addresses waiting for and held by workers
+func (q *WorkQueue) Depth() QueueDepth {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var depth QueueDepth
+	for _, unit := range q.pending {
+		depth.PendingUnits++
+		depth.PendingAddresses += len(unit.Emails)
+	}
+	for _, unit := range q.leased {
+		depth.LeasedUnits++
+		depth.LeasedAddresses += len(unit.Emails)
+	}
+	for _, seen := range q.workers {
+		if time.Since(seen) > q.timeout {
+			depth.StaleWorkers++
+		} else {
+			depth.Workers++
+		}
+	}
+	return depth
+}
+
+// record adds a unit's results to the run; the caller holds q.mu
+func (r *workRun) record(result UnitResult) {
+	stats := r.job.stats
+	atomic.AddInt64(&stats.TotalChecked, result.Checked)
+	atomic.AddInt64(&stats.TotalValid, result.Valid)
+	atomic.AddInt64(&stats.TotalInvalid, int64(len(result.Invalid)))
+	atomic.AddInt64(&stats.TotalRisky, result.Risky)
+	r.invalid = append(r.invalid, result.Invalid...)
+}
+
+// unitDone counts a unit as finished, ending the run after the last one; the caller holds q.mu
+func (r *workRun) unitDone() {
+	r.remaining--
+	if r.remaining == 0 {
+		close(r.done)
+	}
+}
+
+// Cancel fails a running job, dropping its units; workers still on them get 404 when they report
+func (q *WorkQueue) Cancel(jobID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, unit := range q.pending {
+		if unit.JobID == jobID {
+			q.fail(unit.run, errJobCancelled)
+			return true
+		}
+	}
+	for _, unit := range q.leased {
+		if unit.JobID == jobID {
+			q.fail(unit.run, errJobCancelled)
+			return true
+		}
+	}
+	return false
+}
+
+// Workers lists the workers seen so far, with the units they hold
+func (q *WorkQueue) Workers() []WorkerStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	units := make(map[string][]string)
+	for id, unit := range q.leased {
+		units[unit.worker] = append(units[unit.worker], id)
+	}
+	workers := make([]WorkerStatus, 0, len(q.workers))
+	for name, seen := range q.workers {
+		sort.Strings(units[name])
+		workers = append(workers, WorkerStatus{
+			Name:     name,
+			LastSeen: seen,
+			Units:    append([]string{}, units[name]...),
+			Stale:    time.Since(seen) > q.timeout,
+		})
+	}
+	sort.Slice(workers, func(a, b int) bool { return workers[a].Name < workers[b].Name })
+	return workers
+}
+
+// reap puts units whose worker stopped heartbeating back at the front of the queue
+func (q *WorkQueue) reap(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		q.mu.Lock()
+		for id, unit := range q.leased {
+			if now.Before(unit.expires) {
+				continue
+			}
+			delete(q.leased, id)
+			if unit.attempts >= maxUnitAttempts {
+				slog.Error("work unit lost on too many workers, failing the job", "unit", id, "job", unit.JobID, "attempts", unit.attempts)
+				q.fail(unit.run, fmt.Errorf("work unit %s was lost on %d workers", id, unit.attempts))
+				continue
+			}
+			slog.Warn("worker stopped heartbeating, reassigning its work unit", "worker", unit.worker, "unit", id, "job", unit.JobID)
+			q.pending = append([]*workUnit{unit}, q.pending...)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// fail ends a run with an error, dropping its other units; the caller holds q.mu
+func (q *WorkQueue) fail(run *workRun, err error) {
+	if run.err != nil {
+		return
+	}
+	run.err = err
+	pending := q.pending[:0]
+	for _, unit := range q.pending {
+		if unit.run != run {
+			pending = append(pending, unit)
+		}
+	}
+	q.pending = pending
+	for id, unit := range q.leased {
+		if unit.run == run {
+			delete(q.leased, id)
+		}
+	}
+	close(run.done)
+}
@@ -0,0 +1,357 @@
+package verify
+
+import (
+	"container/list"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// dnsUpstreamTimeout bounds one query to one upstream server before the next is tried
+	dnsUpstreamTimeout = 2 * time.Second
+	// dnsMaxTTL caps how long any answer is kept, whatever its records say
+	dnsMaxTTL = 24 * time.Hour
+	// dnsNegativeTTL caps how long a missing name or record type is remembered
+	dnsNegativeTTL = 5 * time.Minute
+	// dnsFailureTTL is how long a server failure is reused, so a burst of lookups of a broken
+	// domain makes one upstream query rather than one per worker
+	dnsFailureTTL = 5 * time.Second
+)
+
+// DNSCache is a caching stub resolver inside the process. Every lookup of its Resolver, including
+// the MX lookups and SMTP dials of probes, is answered from its cache or forwarded to the
+// upstream servers, so a large run doesn't send millions of queries through the host's resolver
+// and NAT. Answers are kept for their TTL, the least recently used are dropped past the size,
+// and concurrent identical queries share one upstream query.
+type DNSCache struct {
+	upstreams []string
+	size      int
+	// next is the upstream the next query starts with, spreading queries over all of them
+	next atomic.Uint32
+
+	mu      sync.Mutex
+	entries map[dnsKey]*list.Element
+	recent  *list.List // of *dnsEntry, most recently used first
+
+	hits   atomic.Int64
+	misses atomic.Int64
+}
+
+type dnsKey struct {
+	name  string
+	qtype dnsmessage.Type
+	class dnsmessage.Class
+}
+
+// dnsEntry is the response to a question, in flight until done is closed
+type dnsEntry struct {
+	key      dnsKey
+	done     chan struct{}
+	response []byte
+	err      error
+	expires  time.Time
+}
+
+// newDNSCache creates a cache of up to size answers over the upstream servers, host or
+// host:port with 53 as the default port. Host names are resolved once, here, as the cache
+// can't resolve its own upstreams.
+func newDNSCache(upstreams []string, size int) (*DNSCache, error) {
+	if len(upstreams) == 0 {
+		return nil, errors.New("no upstream DNS servers")
+	}
+	c := &DNSCache{size: size, entries: make(map[dnsKey]*list.Element), recent: list.New()}
+	for _, upstream := range upstreams {
+		host, port, err := net.SplitHostPort(upstream)
+		if err != nil {
+			host, port = upstream, "53"
+		}
+		if net.ParseIP(host) == nil {
+			addrs, err := net.DefaultResolver.LookupHost(context.Background(), host)
+			if err != nil {
+				return nil, fmt.Errorf("failed to resolve upstream %s: %w", host, err)
+			}
+			host = addrs[0]
+		}
+		c.upstreams = append(c.upstreams, net.JoinHostPort(host, port))
+	}
+	return c, nil
+}
+
+// systemNameservers returns the nameservers of /etc/resolv.conf, or the local resolver when it
+// lists none, as the Go resolver does
+func systemNameservers() []string {
+	data, err := os.ReadFile("/etc/resolv.conf")
+	if err != nil {
+		return []string{"127.0.0.1:53"}
+	}
+	var servers []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "nameserver" && net.ParseIP(fields[1]) != nil {
+			servers = append(servers, net.JoinHostPort(fields[1], "53"))
+		}
+	}
+	if len(servers) == 0 {
+		return []string{"127.0.0.1:53"}
+	}
+	return servers
+}
+
+// Resolver returns a resolver whose lookups go through the cache. The Go resolver still reads
+// /etc/hosts and applies the search domains, then hands its queries to the cache over an
+// in-memory connection.
+func (c *DNSCache) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			client, server := net.Pipe()
+			go c.serve(server)
+			return client, nil
+		},
+	}
+}
+
+// serve answers the queries of one resolver connection, framed as over TCP with a length prefix
+func (c *DNSCache) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		response, err := c.answer(query)
+		if err != nil {
+			// Closing makes the resolver try again or report the server failure
+			return
+		}
+		packet := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(response)), uint16(len(response)))
+		if _, err := conn.Write(append(packet, response...)); err != nil {
+			return
+		}
+	}
+}
+
+// answer returns the response to a query from the cache, or from upstream on a miss
+func (c *DNSCache) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	questions, err := parser.AllQuestions()
+	if err != nil || len(questions) != 1 || header.OpCode != 0 {
+		// Nothing the resolver sends, but not something to cache either
+		return c.exchange(query)
+	}
+	question := questions[0]
+	key := dnsKey{name: strings.ToLower(question.Name.String()), qtype: question.Type, class: question.Class}
+
+	now := time.Now()
+	c.mu.Lock()
+	if element, ok := c.entries[key]; ok && !expiredDNS(element.Value.(*dnsEntry), now) {
+		c.recent.MoveToFront(element)
+		c.mu.Unlock()
+		entry := element.Value.(*dnsEntry)
+		<-entry.done
+		c.hits.Add(1)
+		return withID(entry.response, header.ID), entry.err
+	} else if ok {
+		c.recent.Remove(element)
+	}
+	entry := &dnsEntry{key: key, done: make(chan struct{})}
+	c.entries[key] = c.recent.PushFront(entry)
+	for c.recent.Len() > c.size {
+		oldest := c.recent.Remove(c.recent.Back()).(*dnsEntry)
+		delete(c.entries, oldest.key)
+	}
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	entry.response, entry.err = c.exchange(query)
+	if entry.err == nil {
+		entry.expires = time.Now().Add(dnsTTL(entry.response))
+	} else {
+		entry.expires = time.Now()
+	}
+	close(entry.done)
+	return entry.response, entry.err
+}
+
+// expiredDNS reports whether an entry is done and past its TTL; queries in flight never are
+func expiredDNS(entry *dnsEntry, now time.Time) bool {
+	select {
+	case <-entry.done:
+		return !now.Before(entry.expires)
+	default:
+		return false
+	}
+}
+
+// withID returns a copy of a cached response answering the query with the given ID
+func withID(response []byte, id uint16) []byte {
+	if len(response) < 2 {
+		return response
+	}
+	response = append([]byte(nil), response...)
+	binary.BigEndian.PutUint16(response, id)
+	return response
+}
+
+// dnsTTL is how long a response may be reused: the lowest TTL of its answers, the SOA's negative
+// TTL for missing names and record types, and briefly for server failures
+func dnsTTL(response []byte) time.Duration {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil || header.Truncated {
+		return 0
+	}
+	if err := parser.SkipAllQuestions(); err != nil {
+		return 0
+	}
+	switch header.RCode {
+	case dnsmessage.RCodeSuccess, dnsmessage.RCodeNameError:
+	case dnsmessage.RCodeServerFailure:
+		return dnsFailureTTL
+	default:
+		return 0
+	}
+
+	ttl, answered := uint32(0), false
+	for {
+		answer, err := parser.AnswerHeader()
+		if errors.Is(err, dnsmessage.ErrSectionDone) {
+			break
+		}
+		if err != nil {
+			return 0
+		}
+		if !answered || answer.TTL < ttl {
+			ttl, answered = answer.TTL, true
+		}
+		if err := parser.SkipAnswer(); err != nil {
+			return 0
+		}
+	}
+	if answered && header.RCode == dnsmessage.RCodeSuccess {
+		return min(time.Duration(ttl)*time.Second, dnsMaxTTL)
+	}
+
+	// RFC 2308: a negative answer lives for the lower of the SOA's TTL and its minimum field
+	negative := dnsNegativeTTL
+	for {
+		authority, err := parser.AuthorityHeader()
+		if err != nil {
+			break
+		}
+		if authority.Type != dnsmessage.TypeSOA {
+			if err := parser.SkipAuthority(); err != nil {
+				break
+			}
+			continue
+		}
+		soa, err := parser.SOAResource()
+		if err != nil {
+			break
+		}
+		negative = min(negative, time.Duration(min(authority.TTL, soa.MinTTL))*time.Second)
+		break
+	}
+	return negative
+}
+
+// exchange forwards a query to the upstreams in turn until one answers. Server failures and
+// refusals move on to the next, and are returned if every upstream gives one.
+func (c *DNSCache) exchange(query []byte) ([]byte, error) {
+	start := int(c.next.Add(1))
+	var response []byte
+	var err error
+	for i := range c.upstreams {
+		upstream := c.upstreams[(start+i)%len(c.upstreams)]
+		response, err = exchangeDNS("udp", upstream, query)
+		if err == nil && truncated(response) {
+			response, err = exchangeDNS("tcp", upstream, query)
+		}
+		if err != nil {
+			continue
+		}
+		if rcode := dnsmessage.RCode(binary.BigEndian.Uint16(response[2:]) & 0xf); rcode != dnsmessage.RCodeServerFailure && rcode != dnsmessage.RCodeRefused {
+			return response, nil
+		}
+	}
+	if response != nil {
+		return response, nil
+	}
+	return nil, fmt.Errorf("no upstream DNS server answered: %w", err)
+}
+
+// truncated reports whether a UDP response was cut short and must be asked again over TCP
+func truncated(response []byte) bool {
+	return response[2]&0x02 != 0
+}
+
+// exchangeDNS sends one query to a server and reads its response, skipping stray responses to
+// other queries on UDP
+func exchangeDNS(network, server string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, server, dnsUpstreamTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn = dnsTraffic.count(conn)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(dnsUpstreamTimeout))
+
+	if network == "tcp" {
+		packet := binary.BigEndian.AppendUint16(make([]byte, 0, 2+len(query)), uint16(len(query)))
+		if _, err := conn.Write(append(packet, query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		response := make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, response); err != nil {
+			return nil, err
+		}
+		if len(response) < 12 || binary.BigEndian.Uint16(response) != binary.BigEndian.Uint16(query) {
+			return nil, fmt.Errorf("invalid response from %s", server)
+		}
+		return response, nil
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 65535)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n >= 12 && binary.BigEndian.Uint16(buf) == binary.BigEndian.Uint16(query) {
+			return append([]byte(nil), buf[:n]...), nil
+		}
+	}
+}
+
+// Stats returns how many queries were answered from the cache and how many went upstream
+func (c *DNSCache) Stats() (hits, misses int64) {
+	if c == nil {
+		return 0, 0
+	}
+	return c.hits.Load(), c.misses.Load()
+}
@@ -0,0 +1,196 @@
+package verify
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakeUpstream is a DNS server answering every MX query with one record of the given TTL, after
+// the given delay, and counting the queries it gets
+type fakeUpstream struct {
+	conn    net.PacketConn
+	ttl     uint32
+	delay   time.Duration
+	queries atomic.Int64
+}
+
+func newFakeUpstream(t *testing.T, ttl uint32, delay time.Duration) *fakeUpstream {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen for DNS: %v", err)
+	}
+	u := &fakeUpstream{conn: conn, ttl: ttl, delay: delay}
+	t.Cleanup(func() { conn.Close() })
+	go u.serve()
+	return u
+}
+
+func (u *fakeUpstream) serve() {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := u.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		u.queries.Add(1)
+		var parser dnsmessage.Parser
+		header, err := parser.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		question, err := parser.Question()
+		if err != nil {
+			continue
+		}
+		builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true})
+		builder.StartQuestions()
+		builder.Question(question)
+		builder.StartAnswers()
+		builder.MXResource(dnsmessage.ResourceHeader{Name: question.Name, Type: dnsmessage.TypeMX, Class: dnsmessage.ClassINET, TTL: u.ttl},
+			dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx." + question.Name.String())})
+		response, err := builder.Finish()
+		if err != nil {
+			continue
+		}
+		go func() {
+			time.Sleep(u.delay)
+			u.conn.WriteTo(response, addr)
+		}()
+	}
+}
+
+// mxQuery builds an MX query for name with the given ID
+func mxQuery(t *testing.T, name string, id uint16) []byte {
+	t.Helper()
+	builder := dnsmessage.NewBuilder(nil, dnsmessage.Header{ID: id, RecursionDesired: true})
+	builder.StartQuestions()
+	builder.Question(dnsmessage.Question{Name: dnsmessage.MustNewName(name), Type: dnsmessage.TypeMX, Class: dnsmessage.ClassINET})
+	query, err := builder.Finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+// responseID returns the ID a response answers
+func responseID(t *testing.T, response []byte) uint16 {
+	t.Helper()
+	var parser dnsmessage.Parser
+	header, err := parser.Start(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return header.ID
+}
+
+func TestDNSCacheExpiry(t *testing.T) {
+	upstream := newFakeUpstream(t, 300, 0)
+	cache, err := newDNSCache([]string{upstream.conn.LocalAddr().String()}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id := uint16(1); id <= 3; id++ {
+		response, err := cache.answer(mxQuery(t, "example.com.", id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := responseID(t, response); got != id {
+			t.Errorf("response ID = %d, want %d", got, id)
+		}
+	}
+	if got := upstream.queries.Load(); got != 1 {
+		t.Errorf("upstream queries within the TTL = %d, want 1", got)
+	}
+	if hits, misses := cache.Stats(); hits != 2 || misses != 1 {
+		t.Errorf("hits, misses = %d, %d, want 2, 1", hits, misses)
+	}
+
+	// Past the record's TTL the answer is fetched again
+	cache.mu.Lock()
+	for _, element := range cache.entries {
+		element.Value.(*dnsEntry).expires = time.Now().Add(-time.Second)
+	}
+	cache.mu.Unlock()
+	if _, err := cache.answer(mxQuery(t, "example.com.", 4)); err != nil {
+		t.Fatal(err)
+	}
+	if got := upstream.queries.Load(); got != 2 {
+		t.Errorf("upstream queries after the TTL = %d, want 2", got)
+	}
+}
+
+func TestDNSCacheZeroTTL(t *testing.T) {
+	upstream := newFakeUpstream(t, 0, 0)
+	cache, err := newDNSCache([]string{upstream.conn.LocalAddr().String()}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for id := uint16(1); id <= 2; id++ {
+		if _, err := cache.answer(mxQuery(t, "example.com.", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := upstream.queries.Load(); got != 2 {
+		t.Errorf("upstream queries of a record with TTL 0 = %d, want 2", got)
+	}
+}
+
+func TestDNSCacheSharesConcurrentLookups(t *testing.T) {
+	upstream := newFakeUpstream(t, 300, 200*time.Millisecond)
+	cache, err := newDNSCache([]string{upstream.conn.LocalAddr().String()}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const lookups = 20
+	queries := make([][]byte, lookups)
+	responses := make([][]byte, lookups)
+	errs := make([]error, lookups)
+	for i := range queries {
+		queries[i] = mxQuery(t, "Example.COM.", uint16(i+1))
+	}
+	var wg sync.WaitGroup
+	for i := range queries {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], errs[i] = cache.answer(queries[i])
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := responseID(t, responses[i]); got != uint16(i+1) {
+			t.Errorf("response to query %d has ID %d", i+1, got)
+		}
+	}
+	if got := upstream.queries.Load(); got != 1 {
+		t.Errorf("upstream queries of %d concurrent lookups = %d, want 1", lookups, got)
+	}
+}
+
+func TestDNSCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	upstream := newFakeUpstream(t, 300, 0)
+	cache, err := newDNSCache([]string{upstream.conn.LocalAddr().String()}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"a.example.", "b.example.", "a.example.", "c.example.", "a.example.", "b.example."} {
+		if _, err := cache.answer(mxQuery(t, name, uint16(i+1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a stays cached as it was used last; b was dropped for c and has to be fetched again
+	if got := upstream.queries.Load(); got != 4 {
+		t.Errorf("upstream queries = %d, want 4", got)
+	}
+}
@@ -0,0 +1,187 @@
+package verify
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Outcomes of a doctor check
+const (
+	doctorOK   = "ok"
+	doctorWarn = "warn"
+	doctorFail = "fail"
+)
+
+// doctorTimeout bounds each network check
+const doctorTimeout = 10 * time.Second
+
+// doctorCheck is one finding of the doctor command
+type doctorCheck struct {
+	name    string
+	outcome string
+	detail  string
+}
+
+// Doctor checks that the machine is fit for verification with the given settings: DNS resolves
+// the MX records of domain, outbound SMTP isn't blocked and the egress IP is neither blocklisted
+// nor without reverse DNS. It prints every finding and fails if a check did.
+func Doctor(config Config, domain string) error {
+	resolver, _, err := newResolver(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure DNS: %w", err)
+	}
+
+	checks := []doctorCheck{doctorDataDir()}
+	mx, check := doctorDNS(resolver, domain)
+	checks = append(checks, check)
+	if config.EnableSMTP {
+		checks = append(checks, doctorSMTP(resolver, mx, config.SMTPPort))
+	}
+	checks = append(checks, doctorEgress(config, resolver)...)
+	checks = append(checks, doctorLookups(config))
+
+	failed := 0
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, check := range checks {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", check.outcome, check.name, check.detail)
+		if check.outcome == doctorFail {
+			failed++
+		}
+	}
+	tw.Flush()
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+// doctorDataDir checks that outputs can be written
+func doctorDataDir() doctorCheck {
+	check := doctorCheck{name: "data directory", outcome: doctorOK, detail: dataDir + "/ is writable"}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return doctorCheck{name: check.name, outcome: doctorFail, detail: err.Error()}
+	}
+	file, err := os.CreateTemp(dataDir, ".doctor-*")
+	if err != nil {
+		return doctorCheck{name: check.name, outcome: doctorFail, detail: err.Error()}
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return check
+}
+
+// doctorDNS looks up the domain's mail servers, returning the preferred one
+func doctorDNS(resolver *net.Resolver, domain string) (string, doctorCheck) {
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	start := time.Now()
+	records, err := resolver.LookupMX(ctx, domain)
+	if err != nil {
+		return "", doctorCheck{name: "DNS", outcome: doctorFail, detail: fmt.Sprintf("MX lookup of %s failed: %v", domain, err)}
+	}
+	if len(records) == 0 {
+		return "", doctorCheck{name: "DNS", outcome: doctorFail, detail: domain + " has no MX records"}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Pref < records[j].Pref })
+	detail := fmt.Sprintf("%s has %d MX records (%s)", domain, len(records), time.Since(start).Round(time.Millisecond))
+	return strings.TrimSuffix(records[0].Host, "."), doctorCheck{name: "DNS", outcome: doctorOK, detail: detail}
+}
+
+// doctorSMTP connects to the -smtp-port of the mail server and waits for its greeting, which
+// fails on the many networks that block outbound SMTP
+func doctorSMTP(resolver *net.Resolver, mx string, port int) doctorCheck {
+	check := doctorCheck{name: fmt.Sprintf("SMTP port %d", port)}
+	if mx == "" {
+		check.outcome, check.detail = doctorWarn, "skipped, no mail server to connect to"
+		return check
+	}
+	addr := net.JoinHostPort(mx, strconv.Itoa(port))
+	dialer := net.Dialer{Timeout: doctorTimeout, Resolver: resolver}
+	conn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		check.outcome = doctorFail
+		check.detail = fmt.Sprintf("connecting to %s failed: %v; the network or ISP may block outbound SMTP", addr, err)
+		return check
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(doctorTimeout))
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.HasPrefix(greeting, "220") {
+		check.outcome = doctorFail
+		check.detail = fmt.Sprintf("%s didn't greet with 220: %q %v", addr, strings.TrimSpace(greeting), err)
+		return check
+	}
+	check.outcome, check.detail = doctorOK, addr+" answered: "+strings.TrimSpace(greeting)
+	return check
+}
+
+// doctorEgress checks the egress IPs against the DNSBLs and for forward-confirmed reverse DNS
+func doctorEgress(config Config, resolver *net.Resolver) []doctorCheck {
+	ips := splitList(config.EgressIPs)
+	if len(ips) == 0 {
+		detected, err := detectEgressIP(resolvingClient(resolver, doctorTimeout), config.EgressIPURL)
+		if err != nil {
+			return []doctorCheck{{name: "egress IP", outcome: doctorWarn, detail: err.Error()}}
+		}
+		ips = detected
+	}
+	checks := []doctorCheck{{name: "egress IP", outcome: doctorOK, detail: strings.Join(ips, ", ")}}
+
+	blocklists := doctorCheck{name: "blocklists", outcome: doctorOK}
+	var listed, failed []string
+	zones := splitList(config.DNSBLs)
+	for _, ip := range ips {
+		for _, zone := range zones {
+			code, isListed, err := lookupDNSBL(resolver, ip, zone)
+			switch {
+			case err != nil:
+				failed = append(failed, err.Error())
+			case isListed:
+				listed = append(listed, fmt.Sprintf("%s is listed on %s (%s)", ip, zone, code))
+			}
+		}
+	}
+	switch {
+	case len(listed) > 0:
+		blocklists.outcome, blocklists.detail = doctorFail, strings.Join(listed, "; ")+"; results of SMTP probes from it are unreliable"
+	case len(failed) > 0:
+		blocklists.outcome, blocklists.detail = doctorWarn, strings.Join(failed, "; ")
+	default:
+		blocklists.detail = fmt.Sprintf("not listed on %d blocklists", len(zones))
+	}
+	checks = append(checks, blocklists)
+
+	if config.EnableSMTP {
+		fcrdns := doctorCheck{name: "reverse DNS", outcome: doctorOK, detail: "FCrDNS confirmed for HELO " + config.HelloName}
+		_, problems, err := fcrdnsReport(resolver, strings.Join(ips, ","), config.EgressIPURL, config.HelloName)
+		switch {
+		case err != nil:
+			fcrdns.outcome, fcrdns.detail = doctorWarn, err.Error()
+		case len(problems) > 0:
+			fcrdns.outcome, fcrdns.detail = doctorWarn, strings.Join(problems, "; ")+"; providers may reject or tarpit SMTP probes"
+		}
+		checks = append(checks, fcrdns)
+	}
+	return checks
+}
+
+// doctorLookups starts the configured checks, hooks, sinks and shared rate limits, which fails
+// for missing plugins, unreachable Redis or bad credentials
+func doctorLookups(config Config) doctorCheck {
+	// Checked above already
+	config.EgressCheck, config.FCrDNSCheck = false, false
+	lookups, err := newLookups(config)
+	if err != nil {
+		return doctorCheck{name: "extensions", outcome: doctorFail, detail: err.Error()}
+	}
+	lookups.Close()
+	return doctorCheck{name: "extensions", outcome: doctorOK, detail: "checks, hooks, sinks and lists load"}
+}
@@ -0,0 +1,150 @@
+package verify
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// DomainCache resolves what the addresses of a domain have in common once per run: the MX
+// lookup, the disposable check and whether the domain is catch-all. Lists dominated by a few
+// providers then cost one DNS lookup per domain instead of one per address, and catch-all
+// domains one SMTP probe.
+type DomainCache struct {
+	grouped map[string]bool // domains with more than one address
+	mu      sync.Mutex
+	domains map[string]*domainFacts
+
+	mxSaved       atomic.Int64
+	catchAllSaved atomic.Int64
+}
+
+// domainFacts is what is known about a domain, resolved by whichever address reaches it first
+type domainFacts struct {
+	once       sync.Once
+	disposable bool
+	mx         *emailverifier.Mx
+	mxHost     string
+	err        error
+	temporary  bool // the lookup failed transiently, so it isn't shared
+
+	mu       sync.Mutex
+	catchAll *emailverifier.SMTP // the probe that found the domain catch-all
+	probed   bool                // a probe found the domain not catch-all
+}
+
+// newDomainCache groups the emails by domain, tracking the domains that appear more than once
+func newDomainCache(emails []string) *DomainCache {
+	seen := make(map[string]bool)
+	grouped := make(map[string]bool)
+	for _, email := range emails {
+		domain := emailDomain(email)
+		if seen[domain] {
+			grouped[domain] = true
+		}
+		seen[domain] = true
+	}
+	return &DomainCache{grouped: grouped, domains: make(map[string]*domainFacts)}
+}
+
+// facts returns the shared facts of a domain, or nil for domains that appear only once
+func (c *DomainCache) facts(domain string) *domainFacts {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	facts, ok := c.domains[domain]
+	if !ok {
+		if !c.grouped[domain] {
+			return nil
+		}
+		facts = &domainFacts{}
+		c.domains[domain] = facts
+	}
+	return facts
+}
+
+// Saved returns how many MX lookups and catch-all probes were answered from the cache
+func (c *DomainCache) Saved() (mx, catchAll int64) {
+	return c.mxSaved.Load(), c.catchAllSaved.Load()
+}
+
+// resolve runs the disposable check and MX lookup, unless another address already has. Only the
+// address that ran the lookup is charged its time.
+func (f *domainFacts) resolve(c *DomainCache, isDisposable func(string) bool, checkMX func(string) (*emailverifier.Mx, error), domain string) (bool, *emailverifier.Mx, string, time.Duration, error) {
+	var dns time.Duration
+	ran := false
+	f.once.Do(func() {
+		ran = true
+		if f.disposable = isDisposable(domain); f.disposable {
+			return
+		}
+		start := time.Now()
+		f.mx, f.err = checkMX(domain)
+		dns = time.Since(start)
+		f.mxHost = primaryMX(f.mx)
+		// A lookup that timed out may well succeed for the next address
+		var dnsErr *net.DNSError
+		f.temporary = errors.As(f.err, &dnsErr) && (dnsErr.IsTimeout || dnsErr.IsTemporary)
+	})
+	if ran || f.disposable {
+		return f.disposable, f.mx, f.mxHost, dns, f.err
+	}
+	if f.temporary {
+		start := time.Now()
+		mx, err := checkMX(domain)
+		return false, mx, primaryMX(mx), time.Since(start), err
+	}
+	c.mxSaved.Add(1)
+	return false, f.mx, f.mxHost, 0, f.err
+}
+
+// knownCatchAll returns the probe that found the domain catch-all, if one has
+func (f *domainFacts) knownCatchAll() *emailverifier.SMTP {
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.catchAll
+}
+
+// knownNotCatchAll reports whether a probe has found the domain not catch-all
+func (f *domainFacts) knownNotCatchAll() bool {
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.probed
+}
+
+// learn records what a completed probe revealed about the domain
+func (f *domainFacts) learn(smtp *emailverifier.SMTP) {
+	if f == nil || smtp == nil || !smtp.HostExists {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if smtp.CatchAll {
+		probe := *smtp
+		probe.Deliverable = false
+		f.catchAll = &probe
+	} else {
+		f.probed = true
+	}
+}
+
+// primaryMX returns the most preferred MX host, or "" without one
+func primaryMX(mx *emailverifier.Mx) string {
+	if mx == nil || len(mx.Records) == 0 {
+		return ""
+	}
+	return strings.TrimSuffix(mx.Records[0].Host, ".")
+}
@@ -0,0 +1,163 @@
+package verify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pruneBuckets is how many domain buckets are kept before full, idle ones are dropped
+const pruneBuckets = 10000
+
+// DomainRate is a token bucket: PerSecond verifications on average, up to Burst at once.
+// A zero rate leaves the domain unlimited.
+type DomainRate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// parseDomainRate parses a rate like "5/s", "300/m" or "1000/h", optionally with a burst as in
+// "5/s:10"; the burst defaults to 1, which spaces verifications evenly
+func parseDomainRate(spec string) (DomainRate, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || spec == "0" {
+		return DomainRate{}, nil
+	}
+	rate := DomainRate{Burst: 1}
+	spec, burst, hasBurst := strings.Cut(spec, ":")
+	if hasBurst {
+		n, err := strconv.Atoi(burst)
+		if err != nil || n < 1 {
+			return DomainRate{}, fmt.Errorf("invalid burst %q, expected a positive number", burst)
+		}
+		rate.Burst = n
+	}
+
+	count, unit, _ := strings.Cut(spec, "/")
+	n, err := strconv.ParseFloat(count, 64)
+	if err != nil || n < 0 {
+		return DomainRate{}, fmt.Errorf("invalid rate %q, expected a number per s, m or h such as 5/s", spec)
+	}
+	switch unit {
+	case "", "s":
+		rate.PerSecond = n
+	case "m":
+		rate.PerSecond = n / 60
+	case "h":
+		rate.PerSecond = n / 3600
+	default:
+		return DomainRate{}, fmt.Errorf("invalid rate unit %q, expected s, m or h", unit)
+	}
+	return rate, nil
+}
+
+// parseDomainRates parses a list like "gmail.com=2/s,example.com=30/m:5" into rates per domain
+func parseDomainRates(spec string) (map[string]DomainRate, error) {
+	rates := make(map[string]DomainRate)
+	for _, part := range splitList(spec) {
+		domain, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid domain rate %q, expected domain=rate", part)
+		}
+		rate, err := parseDomainRate(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rate for domain %s: %w", domain, err)
+		}
+		rates[strings.ToLower(strings.TrimSpace(domain))] = rate
+	}
+	return rates, nil
+}
+
+// String formats the rate the way it is configured
+func (r DomainRate) String() string {
+	if r.PerSecond <= 0 {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%s/s:%d", strconv.FormatFloat(r.PerSecond, 'f', -1, 64), r.Burst)
+}
+
+// DomainLimiter holds a token bucket per recipient domain, so however many workers pick up
+// addresses on the same domain, its mail servers see at most the configured rate. Unlike
+// provider limits, which cover every domain a provider hosts, these apply to each domain alone.
+type DomainLimiter struct {
+	fallback  DomainRate
+	overrides map[string]DomainRate
+
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+func newDomainLimiter(fallback DomainRate, overrides map[string]DomainRate) *DomainLimiter {
+	return &DomainLimiter{fallback: fallback, overrides: overrides, buckets: make(map[string]*tokenBucket)}
+}
+
+// Rate returns the rate that applies to a domain
+func (l *DomainLimiter) Rate(domain string) DomainRate {
+	if rate, ok := l.overrides[domain]; ok {
+		return rate
+	}
+	return l.fallback
+}
+
+// Wait blocks until the domain's bucket has a token for another verification
+func (l *DomainLimiter) Wait(domain string) {
+	rate := l.Rate(domain)
+	if rate.PerSecond <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	now := time.Now()
+	bucket, ok := l.buckets[domain]
+	if !ok {
+		if len(l.buckets) >= pruneBuckets {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{rate: rate, tokens: float64(rate.Burst), last: now}
+		l.buckets[domain] = bucket
+	}
+	wait := bucket.reserve(now)
+	l.mu.Unlock()
+
+	time.Sleep(wait)
+}
+
+// prune drops the buckets that have refilled completely, which are no different from new ones
+func (l *DomainLimiter) prune(now time.Time) {
+	for domain, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, domain)
+		}
+	}
+}
+
+// tokenBucket is the state of one domain's rate; its owner serializes access
+type tokenBucket struct {
+	rate   DomainRate
+	tokens float64 // negative while callers are queued for tokens not yet refilled
+	last   time.Time
+}
+
+// refill adds the tokens accrued since the last call, up to the burst
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.rate.PerSecond, float64(b.rate.Burst))
+	b.last = now
+}
+
+// reserve takes a token and returns how long the caller has to wait until it is refilled
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate.PerSecond * float64(time.Second))
+}
+
+// full reports whether the bucket has refilled to its burst
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(b.rate.Burst)
+}
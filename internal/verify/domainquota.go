@@ -0,0 +1,44 @@
+package verify
+
+import (
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// errDomainQuota is returned for addresses left unprobed because their domain reached -max-per-domain
+var errDomainQuota = errors.New("per-domain probe limit reached")
+
+// DomainQuota caps the SMTP probes of each domain in a run. A list with millions of addresses on
+// one small provider would otherwise probe it at a volume that looks like an attack; the addresses
+// past the cap are deferred to a later run instead.
+type DomainQuota struct {
+	max int
+
+	mu     sync.Mutex
+	probes map[string]int
+}
+
+func newDomainQuota(max int) *DomainQuota {
+	return &DomainQuota{max: max, probes: make(map[string]int)}
+}
+
+// Take reports whether the domain may be probed once more, counting the probe if so
+func (q *DomainQuota) Take(domain string) bool {
+	if q == nil {
+		return true
+	}
+	domain = strings.ToLower(domain)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	count := q.probes[domain]
+	if count >= q.max {
+		return false
+	}
+	q.probes[domain] = count + 1
+	if count+1 == q.max {
+		slog.Info("reached the probe limit of a domain, deferring the rest of its addresses", "domain", domain, "probes", q.max)
+	}
+	return true
+}
@@ -0,0 +1,228 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// DomainIntel is domain-level intelligence accumulated across verification runs
+type DomainIntel struct {
+	Domain     string     `json:"domain"`
+	MXHost     string     `json:"mx_host,omitempty"`
+	MXProvider string     `json:"mx_provider,omitempty"`
+	HasMX      bool       `json:"has_mx"`
+	CatchAll   *bool      `json:"catch_all,omitempty"`
+	Disposable bool       `json:"disposable"`
+	Free       bool       `json:"free"`
+	Checked    int64      `json:"checked"`
+	Invalid    int64      `json:"invalid"`
+	FirstSeen  time.Time  `json:"first_seen"`
+	LastSeen   time.Time  `json:"last_seen"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+
+	// Patterns counts verified addresses per local part pattern; Pattern is the inferred one
+	Patterns PatternCounts `json:"patterns,omitempty"`
+	Pattern  string        `json:"pattern,omitempty"`
+}
+
+// DomainStore persists domain intelligence to a JSON file
+type DomainStore struct {
+	path string
+
+	mu      sync.Mutex
+	domains map[string]*DomainIntel
+	modTime time.Time
+	touched map[string]bool
+}
+
+// openDomainStore loads the store, starting empty if the file doesn't exist yet
+func openDomainStore(path string) (*DomainStore, error) {
+	s := &DomainStore{path: path, domains: make(map[string]*DomainIntel), touched: make(map[string]bool)}
+	if err := s.load(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *DomainStore) load() error {
+	stat, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("failed to read domain store %s: %w", s.path, err)
+	}
+
+	domains := make(map[string]*DomainIntel)
+	if err := json.Unmarshal(data, &domains); err != nil {
+		return fmt.Errorf("failed to decode domain store %s: %w", s.path, err)
+	}
+
+	// Keep what this process observed over what is on disk
+	for domain := range s.touched {
+		domains[domain] = s.domains[domain]
+	}
+	s.domains = domains
+	s.modTime = stat.ModTime()
+	return nil
+}
+
+// Reload re-reads the file if another process has updated it since it was loaded
+func (s *DomainStore) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stat, err := os.Stat(s.path)
+	if err != nil || !stat.ModTime().After(s.modTime) {
+		return nil
+	}
+	return s.load()
+}
+
+// Observe records what a verification revealed about the address's domain
+func (s *DomainStore) Observe(result *emailverifier.Result, emailResult EmailResult) {
+	if !result.Syntax.Valid {
+		return
+	}
+	domain := strings.ToLower(result.Syntax.Domain)
+
+	s.mu.Lock()
+	intel, ok := s.domains[domain]
+	if !ok {
+		intel = &DomainIntel{Domain: domain, FirstSeen: time.Now()}
+		s.domains[domain] = intel
+	}
+	s.touched[domain] = true
+
+	intel.LastSeen = time.Now()
+	intel.ExpiresAt = emailResult.ExpiresAt
+	intel.Checked++
+	if !emailResult.IsValid {
+		intel.Invalid++
+	}
+	intel.Disposable = result.Disposable
+	intel.Free = result.Free
+	intel.HasMX = result.HasMxRecords
+	if result.SMTP != nil && result.SMTP.HostExists {
+		catchAll := result.SMTP.CatchAll
+		intel.CatchAll = &catchAll
+	}
+	if host := emailResult.trace.mxHost; host != "" {
+		intel.MXHost = host
+		intel.MXProvider = mxProvider(host)
+	}
+	s.mu.Unlock()
+}
+
+// Get returns a copy of the intelligence for a domain
+func (s *DomainStore) Get(domain string) (DomainIntel, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	intel, ok := s.domains[strings.ToLower(domain)]
+	if !ok {
+		return DomainIntel{}, false
+	}
+	return intel.copy(), true
+}
+
+// AddPatterns adds verified address pattern counts to a domain and re-infers its pattern
+func (s *DomainStore) AddPatterns(domain string, counts PatternCounts) {
+	domain = strings.ToLower(domain)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	intel, ok := s.domains[domain]
+	if !ok {
+		now := time.Now()
+		intel = &DomainIntel{Domain: domain, FirstSeen: now, LastSeen: now}
+		s.domains[domain] = intel
+	}
+	s.touched[domain] = true
+
+	if intel.Patterns == nil {
+		intel.Patterns = make(PatternCounts)
+	}
+	for pattern, n := range counts {
+		intel.Patterns[pattern] += n
+	}
+	intel.Pattern = ""
+	if inferred := intel.Patterns.Infer(); inferred != nil {
+		intel.Pattern = inferred.Pattern
+	}
+}
+
+// copy returns the intelligence with its own pattern counts
+func (d *DomainIntel) copy() DomainIntel {
+	c := *d
+	c.Patterns = maps.Clone(d.Patterns)
+	return c
+}
+
+// List returns domains sorted by name, paginated by offset and limit
+func (s *DomainStore) List(offset, limit int) ([]DomainIntel, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	names := make([]string, 0, len(s.domains))
+	for name := range s.domains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	total := len(names)
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if limit <= 0 || end > total {
+		end = total
+	}
+
+	page := make([]DomainIntel, 0, end-offset)
+	for _, name := range names[offset:end] {
+		page = append(page, s.domains[name].copy())
+	}
+	return page, total
+}
+
+// Save merges this run into the file, keeping entries other runs wrote in the meantime
+func (s *DomainStore) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.load(); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	data, err := json.MarshalIndent(s.domains, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode domain store: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".domains-*")
+	if err != nil {
+		return fmt.Errorf("failed to create domain store file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write domain store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write domain store: %w", err)
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,273 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Actions when an egress IP is blocklisted
+const (
+	blocklistWarn  = "warn"
+	blocklistPause = "pause"
+)
+
+// defaultDNSBLs are the blocklists mail servers most commonly consult
+const defaultDNSBLs = "zen.spamhaus.org,bl.spamcop.net,b.barracudacentral.org"
+
+// validBlocklistAction reports whether action is a known blocklist action
+func validBlocklistAction(action string) bool {
+	return action == blocklistWarn || action == blocklistPause
+}
+
+// DNSBLListing is an egress IP found on a blocklist, with the blocklist's return code
+type DNSBLListing struct {
+	IP   string `json:"ip"`
+	Zone string `json:"zone"`
+	Code string `json:"code"`
+}
+
+// EgressMonitor checks the public IPs probes leave from against DNSBLs, at startup and then
+// periodically. Probes from a listed IP get rejected or answered with lies, so their results are
+// garbage; with the pause action, workers hold off probing until the IP is delisted.
+type EgressMonitor struct {
+	ips       []string // configured; detected through detectURL when empty
+	detectURL string
+	zones     []string
+	interval  time.Duration
+	pause     bool
+	resolver  *net.Resolver
+	http      *http.Client
+
+	mu     sync.Mutex
+	listed []DNSBLListing
+	resume chan struct{} // closed unless paused
+	stop   chan struct{}
+}
+
+// newEgressMonitor checks the egress IPs once and keeps checking them every interval, querying the
+// blocklists through resolver
+func newEgressMonitor(resolver *net.Resolver, configured, detectURL, zones string, interval time.Duration, action string) (*EgressMonitor, error) {
+	m := &EgressMonitor{
+		ips:       splitList(configured),
+		detectURL: detectURL,
+		zones:     splitList(zones),
+		interval:  interval,
+		pause:     action == blocklistPause,
+		resolver:  resolver,
+		http:      resolvingClient(resolver, 10*time.Second),
+		resume:    make(chan struct{}),
+		stop:      make(chan struct{}),
+	}
+	close(m.resume)
+	if len(m.zones) == 0 {
+		return nil, fmt.Errorf("no blocklists configured")
+	}
+
+	ips, err := m.egressIPs()
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("checking egress IP against blocklists", "ips", strings.Join(ips, ","), "blocklists", len(m.zones), "interval", interval)
+	m.check(ips)
+	go m.run()
+	return m, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Wait blocks while verification is paused for a blocklisted egress IP, reporting whether it was
+func (m *EgressMonitor) Wait() bool {
+	m.mu.Lock()
+	resume := m.resume
+	m.mu.Unlock()
+	select {
+	case <-resume:
+		return false
+	default:
+		<-resume
+		return true
+	}
+}
+
+// Listings returns the blocklist entries found by the latest check
+func (m *EgressMonitor) Listings() []DNSBLListing {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]DNSBLListing(nil), m.listed...)
+}
+
+// Close stops the periodic checks and releases paused workers
+func (m *EgressMonitor) Close() {
+	close(m.stop)
+	m.setListed(nil)
+}
+
+func (m *EgressMonitor) run() {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-ticker.C:
+		}
+		// The egress IP can change under NAT pools, so detection is repeated too
+		ips, err := m.egressIPs()
+		if err != nil {
+			slog.Warn("egress check failed", "error", err)
+			continue
+		}
+		m.check(ips)
+	}
+}
+
+// egressIPs returns the configured egress IPs, or detects the public one
+func (m *EgressMonitor) egressIPs() ([]string, error) {
+	if len(m.ips) > 0 {
+		return m.ips, nil
+	}
+	return detectEgressIP(m.http, m.detectURL)
+}
+
+// detectEgressIP asks detectURL for the public IP connections leave from
+func detectEgressIP(client *http.Client, detectURL string) ([]string, error) {
+	resp, err := client.Get(detectURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect egress IP: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 256))
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect egress IP: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to detect egress IP: %s returned %d", detectURL, resp.StatusCode)
+	}
+	ip := strings.TrimSpace(string(body))
+	if net.ParseIP(ip) == nil {
+		return nil, fmt.Errorf("failed to detect egress IP: %s returned %q", detectURL, ip)
+	}
+	return []string{ip}, nil
+}
+
+// check looks the IPs up on every blocklist and pauses or resumes verification accordingly.
+// Lookups that fail leave the IP's standing as it was judged by the last check.
+func (m *EgressMonitor) check(ips []string) {
+	previous := make(map[string]bool)
+	for _, listing := range m.Listings() {
+		previous[listing.IP+" "+listing.Zone] = true
+	}
+
+	var listed []DNSBLListing
+	for _, ip := range ips {
+		for _, zone := range m.zones {
+			code, found, err := lookupDNSBL(m.resolver, ip, zone)
+			if err != nil {
+				slog.Warn("egress check failed", "error", err)
+				if previous[ip+" "+zone] {
+					listed = append(listed, DNSBLListing{IP: ip, Zone: zone})
+				}
+				continue
+			}
+			if found {
+				listed = append(listed, DNSBLListing{IP: ip, Zone: zone, Code: code})
+				if !previous[ip+" "+zone] {
+					slog.Warn("egress IP is blocklisted, results of SMTP probes from it are unreliable", "ip", ip, "blocklist", zone, "code", code)
+				}
+			}
+		}
+	}
+
+	switch wasListed := len(previous) > 0; {
+	case len(listed) > 0 && !wasListed && m.pause:
+		slog.Warn("pausing verification until the egress IP is delisted", "recheck_interval", m.interval)
+	case len(listed) > 0 && !wasListed:
+		slog.Warn("continuing despite the blocklisting", "blocklist_action", blocklistWarn)
+	case len(listed) == 0 && wasListed:
+		slog.Info("egress IP no longer blocklisted, resuming verification")
+	}
+	m.setListed(listed)
+}
+
+// setListed records the listings, pausing workers while there are any and pausing is enabled
+func (m *EgressMonitor) setListed(listed []DNSBLListing) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listed = listed
+	paused := m.pause && len(listed) > 0
+	select {
+	case <-m.resume:
+		if paused {
+			m.resume = make(chan struct{})
+		}
+	default:
+		if !paused {
+			close(m.resume)
+		}
+	}
+}
+
+// lookupDNSBL reports whether ip is listed on the blocklist zone, looked up through resolver, with
+// the 127.0.0.x code the listing returned
+func lookupDNSBL(resolver *net.Resolver, ip, zone string) (string, bool, error) {
+	query, err := dnsblQuery(ip, zone)
+	if err != nil {
+		return "", false, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	addrs, err := resolver.LookupHost(ctx, query)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, fmt.Errorf("failed to query %s: %w", zone, err)
+	}
+	for _, addr := range addrs {
+		// 127.255.255.x means the blocklist refused the query, commonly from public resolvers
+		if strings.HasPrefix(addr, "127.255.255.") {
+			return "", false, fmt.Errorf("%s refused the query (%s); use a resolver it accepts", zone, addr)
+		}
+	}
+	for _, addr := range addrs {
+		if strings.HasPrefix(addr, "127.") {
+			return addr, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// dnsblQuery builds the DNSBL name of an IP: reversed octets for IPv4, reversed nibbles for IPv6
+func dnsblQuery(ip, zone string) (string, error) {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return "", fmt.Errorf("invalid egress IP %q", ip)
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.%s", v4[3], v4[2], v4[1], v4[0], zone), nil
+	}
+	const hex = "0123456789abcdef"
+	labels := make([]string, 0, 33)
+	for i := len(parsed) - 1; i >= 0; i-- {
+		labels = append(labels, string(hex[parsed[i]&0x0f]), string(hex[parsed[i]>>4]))
+	}
+	return strings.Join(append(labels, zone), "."), nil
+}
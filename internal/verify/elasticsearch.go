@@ -0,0 +1,323 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	elasticRetries = 4
+	elasticTimeout = time.Minute
+)
+
+// elasticMapping creates the index when no mapping file is given: keywords for the fields
+// dashboards aggregate on, and the full result stored but not indexed, so new fields never
+// grow the mapping
+var elasticMapping = map[string]any{
+	"mappings": map[string]any{
+		"dynamic": false,
+		"properties": map[string]any{
+			"email":        map[string]string{"type": "keyword"},
+			"domain":       map[string]string{"type": "keyword"},
+			"valid":        map[string]string{"type": "boolean"},
+			"risky":        map[string]string{"type": "boolean"},
+			"reason":       map[string]any{"type": "keyword", "fields": map[string]any{"text": map[string]string{"type": "text"}}},
+			"reachable":    map[string]string{"type": "keyword"},
+			"disposable":   map[string]string{"type": "boolean"},
+			"role_account": map[string]string{"type": "boolean"},
+			"free":         map[string]string{"type": "boolean"},
+			"confidence":   map[string]string{"type": "float"},
+			"country":      map[string]string{"type": "keyword"},
+			"greylisted":   map[string]string{"type": "boolean"},
+			"catch_all":    map[string]string{"type": "boolean"},
+			"deferred":     map[string]string{"type": "boolean"},
+			"policy":       map[string]string{"type": "keyword"},
+			"probed_as":    map[string]string{"type": "keyword"},
+			"checked_at":   map[string]string{"type": "date"},
+			"expires_at":   map[string]string{"type": "date"},
+			"result":       map[string]any{"type": "object", "enabled": false},
+		},
+	},
+}
+
+// ElasticsearchSink indexes every result into an Elasticsearch or OpenSearch index with the
+// bulk API, creating the index with its mapping if needed
+type ElasticsearchSink struct {
+	client   *http.Client
+	endpoint string
+	index    string
+	batch    int
+	apiKey   string
+	username string
+	password string
+
+	mu   sync.Mutex
+	docs []map[string]any // documents not sent yet
+}
+
+// elasticError is a failed Elasticsearch request
+type elasticError struct {
+	Status    int
+	Message   string
+	Retryable bool
+}
+
+func (e *elasticError) Error() string {
+	if e.Status == 0 {
+		return "Elasticsearch request failed: " + e.Message
+	}
+	return fmt.Sprintf("Elasticsearch returned %d: %s", e.Status, e.Message)
+}
+
+func newElasticsearchSink(config Config) (*ElasticsearchSink, error) {
+	u, err := url.Parse(strings.TrimSuffix(config.ElasticsearchURL, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", config.ElasticsearchURL)
+	}
+	sink := &ElasticsearchSink{
+		client:   &http.Client{Timeout: elasticTimeout},
+		index:    config.ElasticsearchIndex,
+		batch:    config.ElasticsearchBatch,
+		apiKey:   config.ElasticsearchAPIKey,
+		username: config.ElasticsearchUsername,
+		password: config.ElasticsearchPassword,
+	}
+	// Credentials may come with the URL too
+	if u.User != nil {
+		sink.username = u.User.Username()
+		sink.password, _ = u.User.Password()
+		u.User = nil
+	}
+	sink.endpoint = u.String()
+
+	// Clusters set up with security enabled serve a certificate of their own CA
+	if caFile := config.ElasticsearchCACert; caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", caFile)
+		}
+		sink.client.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: &tls.Config{RootCAs: pool}}
+	}
+
+	mapping := elasticMapping
+	if config.ElasticsearchMapping != "" {
+		data, err := os.ReadFile(config.ElasticsearchMapping)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read mapping: %w", err)
+		}
+		mapping = nil
+		if err := json.Unmarshal(data, &mapping); err != nil {
+			return nil, fmt.Errorf("invalid mapping %s: %w", config.ElasticsearchMapping, err)
+		}
+	}
+	if err := sink.ensureIndex(mapping); err != nil {
+		return nil, err
+	}
+	slog.Info("writing results to Elasticsearch", "url", sink.endpoint, "index", sink.index)
+	return sink, nil
+}
+
+// do sends a request and decodes the JSON response of a successful one into out unless it's nil
+func (s *ElasticsearchSink) do(method, path, contentType string, body []byte, out any) error {
+	req, err := http.NewRequest(method, s.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	switch {
+	case s.apiKey != "":
+		req.Header.Set("Authorization", "ApiKey "+s.apiKey)
+	case s.username != "":
+		req.SetBasicAuth(s.username, s.password)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return &elasticError{Message: err.Error(), Retryable: true}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &elasticError{Message: err.Error(), Retryable: true}
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &apiErr) == nil && apiErr.Error.Reason != "" {
+			message = apiErr.Error.Type + ": " + apiErr.Error.Reason
+		}
+		return &elasticError{
+			Status:    resp.StatusCode,
+			Message:   message,
+			Retryable: resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500,
+		}
+	}
+	if out != nil {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// ensureIndex creates the index with the mapping, unless it or an alias of that name exists
+func (s *ElasticsearchSink) ensureIndex(mapping map[string]any) error {
+	path := "/" + url.PathEscape(s.index)
+	err := retryElastic(elasticRetries, "Elasticsearch index lookup", func() error {
+		return s.do(http.MethodHead, path, "", nil, nil)
+	})
+	var apiErr *elasticError
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		return err
+	}
+
+	body, err := json.Marshal(mapping)
+	if err != nil {
+		return err
+	}
+	err = retryElastic(elasticRetries, "Elasticsearch index creation", func() error {
+		return s.do(http.MethodPut, path, "application/json", body, nil)
+	})
+	// Another run may have created it in the meantime
+	if errors.As(err, &apiErr) && strings.Contains(apiErr.Message, "resource_already_exists_exception") {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	slog.Info("created Elasticsearch index", "index", s.index)
+	return nil
+}
+
+// Write queues a result, sending a bulk request once enough have come in
+func (s *ElasticsearchSink) Write(result EmailResult) error {
+	doc := resultRow(result)
+	if data, err := json.Marshal(result); err == nil {
+		doc["result"] = json.RawMessage(data)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs = append(s.docs, doc)
+	if len(s.docs) < s.batch {
+		return nil
+	}
+	// Callers log sink errors only with -verbose, but a lost batch matters
+	if err := s.send(); err != nil {
+		slog.Warn("failed to index results in Elasticsearch", "index", s.index, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Flush sends the rest of the queued results
+func (s *ElasticsearchSink) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.send()
+}
+
+// send indexes the queued documents with the bulk API. Documents are identified by address and
+// time of verification, so a retried request overwrites rather than duplicates them. Documents
+// the cluster rejected for load are sent again with the retries of the request.
+func (s *ElasticsearchSink) send() error {
+	if len(s.docs) == 0 {
+		return nil
+	}
+	docs := s.docs
+	s.docs = nil
+
+	var failed []string
+	err := retryElastic(elasticRetries, "Elasticsearch bulk request", func() error {
+		var body bytes.Buffer
+		encoder := json.NewEncoder(&body)
+		for _, doc := range docs {
+			action := map[string]any{"index": map[string]string{"_id": fmt.Sprint(doc["email"], "/", doc["checked_at"])}}
+			if err := encoder.Encode(action); err != nil {
+				return err
+			}
+			if err := encoder.Encode(doc); err != nil {
+				return err
+			}
+		}
+
+		var response struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				Status int `json:"status"`
+				Error  struct {
+					Type   string `json:"type"`
+					Reason string `json:"reason"`
+				} `json:"error"`
+			} `json:"items"`
+		}
+		if err := s.do(http.MethodPost, "/"+url.PathEscape(s.index)+"/_bulk", "application/x-ndjson", body.Bytes(), &response); err != nil {
+			return err
+		}
+		if !response.Errors {
+			return nil
+		}
+		var throttled []map[string]any
+		for i, item := range response.Items {
+			result := item["index"]
+			switch {
+			case result.Status == http.StatusTooManyRequests && i < len(docs):
+				throttled = append(throttled, docs[i])
+			case result.Status >= 300:
+				failed = append(failed, result.Error.Type+": "+result.Error.Reason)
+			}
+		}
+		if len(throttled) > 0 {
+			docs = throttled
+			return &elasticError{Status: http.StatusTooManyRequests, Message: fmt.Sprintf("%d documents rejected for load", len(throttled)), Retryable: true}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to index %d results: %w", len(docs), err)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Elasticsearch rejected %d results: %s", len(failed), failed[0])
+	}
+	slog.Debug("indexed results in Elasticsearch", "index", s.index, "documents", len(docs))
+	return nil
+}
+
+// retryElastic retries retryable Elasticsearch failures with exponential backoff
+func retryElastic(retries int, what string, fn func() error) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var apiErr *elasticError
+		if err == nil || !errors.As(err, &apiErr) || !apiErr.Retryable || attempt > retries {
+			if err != nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			return nil
+		}
+		slog.Warn(what+" failed, retrying", "attempt", attempt, "attempts", retries+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
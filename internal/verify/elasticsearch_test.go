@@ -0,0 +1,276 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeElastic serves the index and bulk endpoints of an Elasticsearch cluster
+type fakeElastic struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu          sync.Mutex
+	indexExists bool
+	createReply fakeReply
+	bulks       []fakeReply // replies to bulk requests, in turn; success once used up
+	calls       []apiCall
+}
+
+func newFakeElastic(t *testing.T) *fakeElastic {
+	e := &fakeElastic{t: t, createReply: fakeReply{http.StatusOK, `{"acknowledged": true}`}}
+	e.server = httptest.NewServer(http.HandlerFunc(e.serve))
+	t.Cleanup(e.server.Close)
+	return e
+}
+
+func (e *fakeElastic) serve(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, apiCall{method: r.Method, path: r.URL.Path, header: r.Header, body: body})
+
+	reply := fakeReply{http.StatusOK, "{}"}
+	switch path := r.Method + " " + r.URL.Path; path {
+	case "HEAD /email-verifications":
+		if !e.indexExists {
+			reply = fakeReply{http.StatusNotFound, ""}
+		}
+	case "PUT /email-verifications":
+		reply = e.createReply
+	case "POST /email-verifications/_bulk":
+		if len(e.bulks) > 0 {
+			reply, e.bulks = e.bulks[0], e.bulks[1:]
+		} else {
+			reply.body = `{"took": 3, "errors": false, "items": []}`
+		}
+	default:
+		e.t.Errorf("unexpected request %s", path)
+		reply = fakeReply{http.StatusNotFound, "{}"}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(reply.status)
+	io.WriteString(w, reply.body)
+}
+
+// received returns the requests made so far with the given method and path
+func (e *fakeElastic) received(method, path string) []apiCall {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var calls []apiCall
+	for _, call := range e.calls {
+		if call.method == method && call.path == path {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (e *fakeElastic) config() Config {
+	config := DefaultConfig()
+	config.ElasticsearchURL = e.server.URL + "/"
+	config.ElasticsearchAPIKey = "VnVhQ2ZHY0JDZGJrUW0tZTVhT3g6dWkybHAyYXhUTm1zeWFrdzl0dk5udw=="
+	config.ElasticsearchBatch = 2
+	return config
+}
+
+// bulkDocs decodes a bulk request into the IDs of its index actions and their documents
+func bulkDocs(t *testing.T, call apiCall) ([]string, []map[string]any) {
+	t.Helper()
+	if call.header.Get("Content-Type") != "application/x-ndjson" {
+		t.Errorf("bulk request sent as %q", call.header.Get("Content-Type"))
+	}
+	var ids []string
+	var docs []map[string]any
+	scanner := bufio.NewScanner(bytes.NewReader(call.body))
+	for scanner.Scan() {
+		var action struct {
+			Index struct {
+				ID string `json:"_id"`
+			} `json:"index"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil || action.Index.ID == "" {
+			t.Fatalf("invalid action line %q", scanner.Text())
+		}
+		if !scanner.Scan() {
+			t.Fatal("action without a document")
+		}
+		var doc map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &doc); err != nil {
+			t.Fatalf("invalid document line %q", scanner.Text())
+		}
+		ids, docs = append(ids, action.Index.ID), append(docs, doc)
+	}
+	return ids, docs
+}
+
+func TestElasticsearchBulk(t *testing.T) {
+	cluster := newFakeElastic(t)
+	sink, err := newElasticsearchSink(cluster.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The missing index is created with the built-in mapping
+	create := cluster.received(http.MethodPut, "/email-verifications")
+	if len(create) != 1 {
+		t.Fatalf("%d index creations", len(create))
+	}
+	var mapping struct {
+		Mappings struct {
+			Dynamic    bool                      `json:"dynamic"`
+			Properties map[string]map[string]any `json:"properties"`
+		} `json:"mappings"`
+	}
+	json.Unmarshal(create[0].body, &mapping)
+	if mapping.Mappings.Properties["email"]["type"] != "keyword" || mapping.Mappings.Properties["result"]["enabled"] != false {
+		t.Errorf("index created with %s", create[0].body)
+	}
+	if auth := create[0].header.Get("Authorization"); auth != "ApiKey "+cluster.config().ElasticsearchAPIKey {
+		t.Errorf("authorized with %q", auth)
+	}
+
+	results := sinkResults()
+	for _, result := range results {
+		if err := sink.Write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bulks := cluster.received(http.MethodPost, "/email-verifications/_bulk")
+	if len(bulks) != 1 {
+		t.Fatalf("%d bulk requests after 3 results in batches of 2", len(bulks))
+	}
+	if err := sink.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	bulks = cluster.received(http.MethodPost, "/email-verifications/_bulk")
+	if len(bulks) != 2 {
+		t.Fatalf("%d bulk requests after Flush", len(bulks))
+	}
+
+	ids, docs := bulkDocs(t, bulks[0])
+	if len(docs) != 2 || ids[0] != "jane@acme.com/2024-03-01T12:00:00Z" || docs[0]["email"] != "jane@acme.com" || docs[1]["valid"] != false {
+		t.Errorf("first bulk %v %v", ids, docs)
+	}
+	if result, ok := docs[0]["result"].(map[string]any); !ok || result["valid"] != true {
+		t.Errorf("result field %#v", docs[0]["result"])
+	}
+	if ids, _ := bulkDocs(t, bulks[1]); len(ids) != 1 || ids[0] != "info@acme.com/2024-03-01T12:00:01Z" {
+		t.Errorf("flushed bulk %v", ids)
+	}
+}
+
+func TestElasticsearchPartialFailures(t *testing.T) {
+	cluster := newFakeElastic(t)
+	cluster.indexExists = true
+	// One document indexed, one rejected for load and one the mapping can't take; the retry
+	// of the throttled one succeeds
+	cluster.bulks = []fakeReply{
+		{http.StatusOK, `{"took": 5, "errors": true, "items": [
+			{"index": {"_id": "jane@acme.com/2024-03-01T12:00:00Z", "status": 201, "result": "created"}},
+			{"index": {"_id": "bob@acme.com/2024-03-01T12:00:00Z", "status": 429, "error": {"type": "es_rejected_execution_exception", "reason": "rejected execution of coordinating operation"}}},
+			{"index": {"_id": "info@acme.com/2024-03-01T12:00:01Z", "status": 400, "error": {"type": "mapper_parsing_exception", "reason": "failed to parse field [confidence] of type [float]"}}}
+		]}`},
+		{http.StatusOK, `{"took": 2, "errors": false, "items": [{"index": {"status": 201}}]}`},
+	}
+	config := cluster.config()
+	config.ElasticsearchBatch = 3
+	sink, err := newElasticsearchSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(cluster.received(http.MethodPut, "/email-verifications")); n != 0 {
+		t.Errorf("existing index created %d times", n)
+	}
+
+	results := sinkResults()
+	sink.Write(results[0])
+	sink.Write(results[1])
+	err = sink.Write(results[2])
+	if err == nil || err.Error() != "Elasticsearch rejected 1 results: mapper_parsing_exception: failed to parse field [confidence] of type [float]" {
+		t.Errorf("got %v", err)
+	}
+
+	bulks := cluster.received(http.MethodPost, "/email-verifications/_bulk")
+	if len(bulks) != 2 {
+		t.Fatalf("%d bulk requests, want the batch and a retry", len(bulks))
+	}
+	// Only the throttled document is sent again
+	if ids, _ := bulkDocs(t, bulks[1]); len(ids) != 1 || !strings.HasPrefix(ids[0], "bob@acme.com/") {
+		t.Errorf("retried %v", ids)
+	}
+}
+
+func TestElasticsearchRequestErrors(t *testing.T) {
+	cluster := newFakeElastic(t)
+	cluster.indexExists = true
+	cluster.bulks = []fakeReply{
+		// Overloaded nodes are retried
+		{http.StatusServiceUnavailable, `{"error": {"type": "cluster_block_exception", "reason": "blocked by: [SERVICE_UNAVAILABLE/2/no master]"}, "status": 503}`},
+		{http.StatusOK, `{"errors": false, "items": []}`},
+		// Bad requests aren't
+		{http.StatusBadRequest, `{"error": {"type": "illegal_argument_exception", "reason": "The bulk request must be terminated by a newline"}, "status": 400}`},
+	}
+	config := cluster.config()
+	config.ElasticsearchBatch = 1
+	sink, err := newElasticsearchSink(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := sinkResults()
+	if err := sink.Write(results[0]); err != nil {
+		t.Errorf("retried request: %v", err)
+	}
+	err = sink.Write(results[1])
+	if err == nil || err.Error() != "failed to index 1 results: Elasticsearch bulk request: Elasticsearch returned 400: illegal_argument_exception: The bulk request must be terminated by a newline" {
+		t.Errorf("got %v", err)
+	}
+	if n := len(cluster.received(http.MethodPost, "/email-verifications/_bulk")); n != 3 {
+		t.Errorf("%d bulk requests, want 3", n)
+	}
+}
+
+func TestElasticsearchIndexSetup(t *testing.T) {
+	cluster := newFakeElastic(t)
+	// Another run created the index between the lookup and the creation
+	cluster.createReply = fakeReply{http.StatusBadRequest, `{"error": {"type": "resource_already_exists_exception", "reason": "index [email-verifications/abc] already exists"}, "status": 400}`}
+
+	// Credentials in the URL are sent as basic auth, the mapping read from a file
+	mappingFile := filepath.Join(t.TempDir(), "mapping.json")
+	os.WriteFile(mappingFile, []byte(`{"settings": {"number_of_shards": 1}}`), 0o644)
+	config := cluster.config()
+	config.ElasticsearchURL = strings.Replace(cluster.server.URL, "http://", "http://elastic:s3cret@", 1)
+	config.ElasticsearchAPIKey = ""
+	config.ElasticsearchMapping = mappingFile
+	if _, err := newElasticsearchSink(config); err != nil {
+		t.Fatal(err)
+	}
+	create := cluster.received(http.MethodPut, "/email-verifications")
+	if len(create) != 1 || string(create[0].body) != `{"settings":{"number_of_shards":1}}` {
+		t.Errorf("index created with %v", create)
+	}
+	if username, password, ok := (&http.Request{Header: create[0].header}).BasicAuth(); !ok || username != "elastic" || password != "s3cret" {
+		t.Errorf("authorized as %q %q", username, password)
+	}
+
+	cluster.mu.Lock()
+	cluster.createReply = fakeReply{http.StatusForbidden, `{"error": {"type": "security_exception", "reason": "action [indices:admin/create] is unauthorized"}, "status": 403}`}
+	cluster.mu.Unlock()
+	if _, err := newElasticsearchSink(config); err == nil || !strings.Contains(err.Error(), "403: security_exception") {
+		t.Errorf("got %v", err)
+	}
+	config.ElasticsearchURL = "ftp://elastic"
+	if _, err := newElasticsearchSink(config); err == nil {
+		t.Error("accepted an ftp URL")
+	}
+}
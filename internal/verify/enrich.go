@@ -0,0 +1,133 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CompanyInfo is basic firmographic data for a corporate domain
+type CompanyInfo struct {
+	Name      string `json:"name,omitempty"`
+	Industry  string `json:"industry,omitempty"`
+	Employees int    `json:"employees,omitempty"`
+	Country   string `json:"country,omitempty"`
+	Website   string `json:"website,omitempty"`
+}
+
+// CompanyProvider looks up firmographic data for a domain.
+// A nil result with a nil error means the provider knows nothing about the domain.
+type CompanyProvider interface {
+	Lookup(domain string) (*CompanyInfo, error)
+}
+
+// companyProviders maps COMPANY_PROVIDER names to their constructors
+var companyProviders = map[string]func(config Config, resolver *net.Resolver) (CompanyProvider, error){
+	"http": newHTTPCompanyProvider,
+}
+
+// httpCompanyProvider calls a JSON API whose URL contains a {domain} placeholder
+// and whose response matches CompanyInfo
+type httpCompanyProvider struct {
+	urlTemplate string
+	apiKey      string
+	client      *http.Client
+}
+
+func newHTTPCompanyProvider(config Config, resolver *net.Resolver) (CompanyProvider, error) {
+	if !strings.Contains(config.CompanyAPIURL, "{domain}") {
+		return nil, fmt.Errorf("COMPANY_API_URL must contain a {domain} placeholder")
+	}
+	return &httpCompanyProvider{
+		urlTemplate: config.CompanyAPIURL,
+		apiKey:      config.CompanyAPIKey,
+		client:      resolvingClient(resolver, 15*time.Second),
+	}, nil
+}
+
+func (p *httpCompanyProvider) Lookup(domain string) (*CompanyInfo, error) {
+	endpoint := strings.ReplaceAll(p.urlTemplate, "{domain}", url.QueryEscape(domain))
+	req, err := http.NewRequest(http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build company request: %w", err)
+	}
+	if p.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("company request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("company lookup for %s returned %s", domain, resp.Status)
+	}
+
+	var info CompanyInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode company response: %w", err)
+	}
+	return &info, nil
+}
+
+// CompanyEnricher wraps a provider with per-domain caching and rate limiting
+type CompanyEnricher struct {
+	provider CompanyProvider
+	limiter  *intervalLimiter
+
+	mu    sync.Mutex
+	cache map[string]*companyEntry
+}
+
+// companyEntry is a cached company lookup, resolved once per domain
+type companyEntry struct {
+	once sync.Once
+	info *CompanyInfo
+	err  error
+}
+
+func newCompanyEnricher(config Config, resolver *net.Resolver) (*CompanyEnricher, error) {
+	newProvider, ok := companyProviders[config.CompanyProvider]
+	if !ok {
+		return nil, fmt.Errorf("unknown company provider %q", config.CompanyProvider)
+	}
+	provider, err := newProvider(config, resolver)
+	if err != nil {
+		return nil, err
+	}
+	return &CompanyEnricher{
+		provider: provider,
+		limiter:  newIntervalLimiter(config.CompanyRateLimit),
+		cache:    make(map[string]*companyEntry),
+	}, nil
+}
+
+// Company returns firmographic data for a domain, querying the provider at most once per domain
+func (e *CompanyEnricher) Company(domain string) (*CompanyInfo, error) {
+	domain = strings.ToLower(domain)
+
+	e.mu.Lock()
+	entry, ok := e.cache[domain]
+	if !ok {
+		entry = &companyEntry{}
+		e.cache[domain] = entry
+	}
+	e.mu.Unlock()
+
+	entry.once.Do(func() {
+		e.limiter.Wait()
+		entry.info, entry.err = e.provider.Lookup(domain)
+	})
+
+	return entry.info, entry.err
+}
@@ -0,0 +1,140 @@
+package verify
+
+import (
+	"strings"
+	"time"
+)
+
+// etaWindow is how many of a pacing group's latest verifications its dispatch rate is measured over
+const etaWindow = 256
+
+// etaModel estimates the time left from how fast each pacing group of the remaining work is
+// actually getting through, rather than from verification latencies: waits for egress, the
+// adaptive pacer, provider intervals and domain limits are what bound a run's speed, and they
+// show in the rate results arrive at. Groups proceed side by side, so the slowest one decides.
+type etaModel struct {
+	lookups *Lookups
+	now     func() time.Time
+
+	remaining map[string]int
+	overall   dispatchRate
+	rates     map[string]*dispatchRate
+}
+
+// dispatchRate measures how fast a pacing group's verifications complete over its latest ones
+type dispatchRate struct {
+	times []time.Time // the latest completions, a ring once full
+	next  int
+	since time.Time // when the window opened: the completion before the oldest kept, or the first dispatch
+}
+
+func (r *dispatchRate) add(at time.Time, elapsed time.Duration) {
+	switch {
+	case len(r.times) == 0:
+		r.since = at.Add(-elapsed)
+		r.times = append(r.times, at)
+	case len(r.times) < etaWindow:
+		r.times = append(r.times, at)
+	default:
+		r.since = r.times[r.next]
+		r.times[r.next] = at
+		r.next = (r.next + 1) % etaWindow
+	}
+}
+
+// perSecond returns the completions per second up to now, which falls while a group stalls
+func (r *dispatchRate) perSecond(now time.Time) float64 {
+	if r == nil || len(r.times) == 0 || !now.After(r.since) {
+		return 0
+	}
+	return float64(len(r.times)) / now.Sub(r.since).Seconds()
+}
+
+func newETAModel(emails []string, lookups *Lookups) *etaModel {
+	m := &etaModel{
+		lookups:   lookups,
+		now:       time.Now,
+		remaining: make(map[string]int),
+		rates:     make(map[string]*dispatchRate),
+	}
+	for _, email := range emails {
+		m.remaining[emailDomain(email)]++
+	}
+	return m
+}
+
+// pacingGroup returns the group whose pace a domain's verifications share: its provider's if
+// -provider-rate spaces them, the domain's own if -domain-rate limits it, or else the shared pool
+// of workers (an empty string), with the provider's interval if any
+func (m *etaModel) pacingGroup(domain string) (string, time.Duration) {
+	if m.lookups.Providers != nil {
+		if provider, ok := m.lookups.Providers.Known(domain); ok {
+			if interval, ok := m.lookups.ProviderRates[provider]; ok {
+				return "provider " + provider, interval
+			}
+		}
+	}
+	if m.lookups.DomainLimits != nil && m.lookups.DomainLimits.Rate(domain).PerSecond > 0 {
+		return "domain " + domain, 0
+	}
+	return "", 0
+}
+
+// Done records a finished verification and how long it took
+func (m *etaModel) Done(domain string, elapsed time.Duration) {
+	if m.remaining[domain]--; m.remaining[domain] <= 0 {
+		delete(m.remaining, domain)
+	}
+	now := m.now()
+	m.overall.add(now, elapsed)
+	group, _ := m.pacingGroup(domain)
+	rate, ok := m.rates[group]
+	if !ok {
+		rate = &dispatchRate{}
+		m.rates[group] = rate
+	}
+	rate.add(now, elapsed)
+}
+
+// Estimate returns the expected time to finish the remaining work, or 0 before any verification
+// finished. Each pacing group's remaining verifications take as long as its measured dispatch
+// rate says, and no less than its provider's interval or, beyond the burst, its domain limits
+// allow; a group nothing was measured for yet goes at the pace of the whole run.
+func (m *etaModel) Estimate() time.Duration {
+	now := m.now()
+	remaining := make(map[string]int)
+	intervals := make(map[string]time.Duration)
+	var eta time.Duration
+	for domain, n := range m.remaining {
+		group, interval := m.pacingGroup(domain)
+		remaining[group] += n
+		intervals[group] = interval
+		if m.lookups.DomainLimits != nil {
+			if rate := m.lookups.DomainLimits.Rate(domain); rate.PerSecond > 0 && n > rate.Burst {
+				eta = max(eta, time.Duration(float64(n-rate.Burst)/rate.PerSecond*float64(time.Second)))
+			}
+		}
+	}
+
+	overall := m.overall.perSecond(now)
+	for group, n := range remaining {
+		perSecond := m.rates[group].perSecond(now)
+		if perSecond == 0 {
+			perSecond = overall
+		}
+		if perSecond > 0 {
+			eta = max(eta, time.Duration(float64(n)/perSecond*float64(time.Second)))
+		}
+		eta = max(eta, time.Duration(n)*intervals[group])
+	}
+	return eta
+}
+
+// emailDomain returns the lowercased part after the last @, or an empty string
+func emailDomain(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return ""
+	}
+	return strings.ToLower(email[at+1:])
+}
@@ -0,0 +1,109 @@
+package verify
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// syntheticETA is an ETA model on a clock the test advances
+type syntheticETA struct {
+	*etaModel
+	clock time.Time
+}
+
+func newSyntheticETA(counts map[string]int, lookups *Lookups) *syntheticETA {
+	var emails []string
+	for domain, n := range counts {
+		for i := 0; i < n; i++ {
+			emails = append(emails, fmt.Sprintf("user%d@%s", i, domain))
+		}
+	}
+	e := &syntheticETA{etaModel: newETAModel(emails, lookups), clock: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	e.now = func() time.Time { return e.clock }
+	return e
+}
+
+// run finishes n verifications of domain, one every interval, each taking latency
+func (e *syntheticETA) run(domain string, n int, interval, latency time.Duration) {
+	for i := 0; i < n; i++ {
+		e.clock = e.clock.Add(interval)
+		e.Done(domain, latency)
+	}
+}
+
+func TestETAFollowsDispatchRate(t *testing.T) {
+	e := newSyntheticETA(map[string]int{"example.com": 1000}, &Lookups{})
+	if eta := e.Estimate(); eta != 0 {
+		t.Errorf("ETA before any result = %v, want 0", eta)
+	}
+
+	// Fast verifications that only get through at 10/s, as waits dominate: the latency says
+	// nothing about the time left
+	e.clock = e.clock.Add(-50 * time.Millisecond)
+	e.run("example.com", 100, 100*time.Millisecond, 50*time.Millisecond)
+	if eta, want := e.Estimate(), 90*time.Second; !near(eta, want) {
+		t.Errorf("ETA at 10/s = %v, want about %v", eta, want)
+	}
+
+	// The run speeds up to 50/s; the estimate follows once the window has moved on
+	e.run("example.com", 400, 20*time.Millisecond, 50*time.Millisecond)
+	if eta, want := e.Estimate(), 10*time.Second; !near(eta, want) {
+		t.Errorf("ETA at 50/s = %v, want about %v", eta, want)
+	}
+
+	// A stall shows as a falling rate rather than a frozen estimate
+	before := e.Estimate()
+	e.clock = e.clock.Add(30 * time.Second)
+	if eta := e.Estimate(); eta <= before {
+		t.Errorf("ETA after a 30s stall = %v, want more than %v", eta, before)
+	}
+}
+
+func TestETAPacingGroups(t *testing.T) {
+	lookups := &Lookups{
+		Providers:     newProviderResolver(net.LookupMX),
+		ProviderRates: map[string]time.Duration{"google": time.Second},
+	}
+	e := newSyntheticETA(map[string]int{"gmail.com": 30, "example.com": 200}, lookups)
+
+	// Over 10s the shared pool gets through 100 addresses, Google's pacing only 10
+	for i := 0; i < 10; i++ {
+		e.run("example.com", 10, 100*time.Millisecond, 10*time.Millisecond)
+		e.Done("gmail.com", 10*time.Millisecond)
+	}
+
+	// The shared pool needs 10s more, Google 20s at one per second
+	if eta, want := e.Estimate(), 20*time.Second; !near(eta, want) {
+		t.Errorf("ETA = %v, want about %v (Google's remaining addresses)", eta, want)
+	}
+
+	// Once the shared pool is done, Google's pace still bounds the estimate however fast its last
+	// results seemed to come in
+	e.run("example.com", 100, 10*time.Millisecond, 10*time.Millisecond)
+	e.run("gmail.com", 5, 10*time.Millisecond, 10*time.Millisecond)
+	if eta, want := e.Estimate(), 15*time.Second; eta < want {
+		t.Errorf("ETA = %v, want at least %v (15 addresses 1s apart)", eta, want)
+	}
+}
+
+func TestETAUnmeasuredGroup(t *testing.T) {
+	lookups := &Lookups{
+		DomainLimits: newDomainLimiter(DomainRate{}, map[string]DomainRate{"slow.example": {PerSecond: 0.5, Burst: 1}}),
+	}
+	e := newSyntheticETA(map[string]int{"slow.example": 11, "example.com": 100}, lookups)
+
+	// Nothing of slow.example finished yet: it goes at the pace of the run, but no faster than its
+	// limit allows beyond the burst
+	e.run("example.com", 50, 100*time.Millisecond, 10*time.Millisecond)
+	if eta, want := e.Estimate(), 20*time.Second; !near(eta, want) {
+		t.Errorf("ETA = %v, want about %v (10 addresses at 0.5/s)", eta, want)
+	}
+}
+
+// near reports whether an estimate is within 5% of the expected duration
+func near(got, want time.Duration) bool {
+	diff := got - want
+	return diff >= -want/20 && diff <= want/20
+}
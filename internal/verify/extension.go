@@ -0,0 +1,42 @@
+package verify
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Extension spec prefixes selecting how an out-of-tree extension is loaded
+const (
+	execExtensionPrefix = "exec:"
+	wasmExtensionPrefix = "wasm:"
+)
+
+// extension is an out-of-tree extension whose methods take and return JSON documents.
+// External processes (JSON-RPC over stdio) and WASM modules are interchangeable.
+type extension interface {
+	Call(method string, params, out any) error
+	Close() error
+}
+
+// isExtensionSpec reports whether spec names an exec: or wasm: extension
+func isExtensionSpec(spec string) bool {
+	return strings.HasPrefix(spec, execExtensionPrefix) || strings.HasPrefix(spec, wasmExtensionPrefix)
+}
+
+// newExtension loads an extension from an "exec:/path/to/binary" or "wasm:/path/to/module.wasm" spec
+func newExtension(spec string) (extension, error) {
+	if path, ok := strings.CutPrefix(spec, wasmExtensionPrefix); ok {
+		return loadWASMModule(path)
+	}
+	if path, ok := strings.CutPrefix(spec, execExtensionPrefix); ok {
+		return startRPCProcess(path)
+	}
+	return nil, fmt.Errorf("extension %q must start with %s or %s", spec, execExtensionPrefix, wasmExtensionPrefix)
+}
+
+// extensionName derives a short name from an extension spec, e.g. "exec:./bin/crm.py" -> "crm"
+func extensionName(spec string) string {
+	_, path, _ := strings.Cut(spec, ":")
+	return strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+}
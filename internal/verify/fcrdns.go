@@ -0,0 +1,107 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"strings"
+	"time"
+)
+
+// checkFCrDNS checks that each egress IP has forward-confirmed reverse DNS (a PTR name that
+// resolves back to the IP) matching the HELO name probes introduce themselves with. Many
+// providers reject or tarpit probes from IPs without it, which turns deliverable addresses into
+// false negatives. Problems are logged as warnings and returned; verification goes ahead anyway.
+func checkFCrDNS(resolver *net.Resolver, configured, detectURL, helo string) []string {
+	ips, problems, err := fcrdnsReport(resolver, configured, detectURL, helo)
+	if err != nil {
+		slog.Warn("FCrDNS check skipped", "error", err)
+		return nil
+	}
+	for _, problem := range problems {
+		slog.Warn("FCrDNS problem, providers may reject or tarpit SMTP probes", "problem", problem)
+	}
+	if len(problems) == 0 {
+		slog.Info("FCrDNS confirmed", "helo", helo, "ips", strings.Join(ips, ","))
+	}
+	return problems
+}
+
+// fcrdnsReport returns the egress IPs checked and the problems found with their FCrDNS looked up
+// through resolver, or an error if the egress IP couldn't be detected
+func fcrdnsReport(resolver *net.Resolver, configured, detectURL, helo string) ([]string, []string, error) {
+	ips := splitList(configured)
+	if len(ips) == 0 {
+		detected, err := detectEgressIP(resolvingClient(resolver, 10*time.Second), detectURL)
+		if err != nil {
+			return nil, nil, err
+		}
+		ips = detected
+	}
+
+	var problems []string
+	helo = strings.ToLower(strings.TrimSuffix(helo, "."))
+	qualified := strings.Contains(helo, ".")
+	if !qualified {
+		problems = append(problems, fmt.Sprintf("HELO name %q is not a fully qualified domain name", helo))
+	}
+	for _, ip := range ips {
+		problems = append(problems, fcrdnsProblems(resolver, ip, helo, qualified)...)
+	}
+	return ips, problems, nil
+}
+
+// fcrdnsProblems lists what is wrong with the reverse and forward DNS of ip, and with matchHELO
+// whether it fits the HELO name
+func fcrdnsProblems(resolver *net.Resolver, ip, helo string, matchHELO bool) []string {
+	if net.ParseIP(ip) == nil {
+		return []string{fmt.Sprintf("invalid egress IP %q", ip)}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	names, err := resolver.LookupAddr(ctx, ip)
+	if err != nil || len(names) == 0 {
+		return []string{fmt.Sprintf("egress IP %s has no reverse DNS (PTR) record", ip)}
+	}
+
+	var confirmed []string
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		if resolvesTo(ctx, resolver, name, ip) {
+			confirmed = append(confirmed, name)
+		}
+	}
+	if len(confirmed) == 0 {
+		return []string{fmt.Sprintf("PTR name %s of egress IP %s does not resolve back to it", strings.Join(names, ", "), ip)}
+	}
+	if !matchHELO {
+		return nil
+	}
+	for _, name := range confirmed {
+		if name == helo {
+			return nil
+		}
+	}
+	// A HELO name other than the PTR name passes with most providers as long as it resolves to the IP
+	if resolvesTo(ctx, resolver, helo, ip) {
+		return []string{fmt.Sprintf("HELO name %s resolves to %s but its PTR name is %s", helo, ip, strings.Join(confirmed, ", "))}
+	}
+	return []string{fmt.Sprintf("HELO name %s does not match PTR name %s of egress IP %s", helo, strings.Join(confirmed, ", "), ip)}
+}
+
+// resolvesTo reports whether host has ip among its addresses
+func resolvesTo(ctx context.Context, resolver *net.Resolver, host, ip string) bool {
+	want := net.ParseIP(ip)
+	addrs, err := resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(want) {
+			return true
+		}
+	}
+	return false
+}
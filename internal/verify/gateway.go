@@ -0,0 +1,240 @@
+package verify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// gatewayMaxLine is the longest command line RFC 5321 allows, with room for extensions
+const gatewayMaxLine = 4096
+
+// Gateway answers RCPT TO on an SMTP listener with the verdict of verifying the recipient, so a
+// mail server or form handler in front of it can refuse bad addresses before taking a message.
+// It never accepts message data.
+type Gateway struct {
+	recipients    *RecipientVerifier
+	hostname      string
+	idleTimeout   time.Duration
+	maxRecipients int
+
+	// conns bounds the open connections to -max-connections
+	conns chan struct{}
+
+	mu       sync.Mutex
+	open     map[net.Conn]struct{}
+	sessions sync.WaitGroup
+}
+
+// GatewayOptions are the settings of an SMTP gateway
+type GatewayOptions struct {
+	Listen string // address to accept SMTP connections on
+	// Risky and Unknown answer risky recipients and those that couldn't be verified: accept,
+	// reject or tempfail
+	Risky          string
+	Unknown        string
+	CacheTTL       time.Duration // how long verdicts are reused for repeated recipients, 0 for never
+	MaxConnections int           // SMTP connections served at once
+	IdleTimeout    time.Duration // close connections that send no command for this long
+	MaxRecipients  int           // recipients verified per transaction
+}
+
+// ServeGateway answers RCPT TO on an SMTP listener until interrupted
+func ServeGateway(config Config, opts GatewayOptions) error {
+	if opts.MaxConnections < 1 || opts.MaxRecipients < 1 || opts.IdleTimeout <= 0 {
+		return errors.New("invalid gateway settings: -max-connections and -max-recipients must be at least 1 and -idle-timeout positive")
+	}
+	config.Workers = max(config.Workers, 1)
+
+	lookups, err := newLookups(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure lookups: %w", err)
+	}
+	defer lookups.Close()
+
+	recipients, err := newRecipientVerifier(config, lookups, opts.Risky, opts.Unknown, opts.CacheTTL)
+	if err != nil {
+		return fmt.Errorf("invalid gateway settings: %w", err)
+	}
+	g := &Gateway{
+		recipients:    recipients,
+		hostname:      config.HelloName,
+		idleTimeout:   opts.IdleTimeout,
+		maxRecipients: opts.MaxRecipients,
+		conns:         make(chan struct{}, opts.MaxConnections),
+		open:          make(map[net.Conn]struct{}),
+	}
+
+	listener, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for SMTP: %w", err)
+	}
+	slog.Info("SMTP gateway serving", "addr", listener.Addr().String(), "hostname", g.hostname, "risky", opts.Risky, "unknown", opts.Unknown)
+
+	ctx := interruptContext()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		g.closeIdle()
+	}()
+	g.Serve(ctx, listener)
+	g.sessions.Wait()
+	flushSinks(lookups.Sinks)
+	return nil
+}
+
+// Serve accepts connections until the listener is closed
+func (g *Gateway) Serve(ctx context.Context, listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			slog.Warn("failed to accept SMTP connection", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		select {
+		case g.conns <- struct{}{}:
+		default:
+			fmt.Fprintf(conn, "421 4.3.2 %s Too many connections, try again later\r\n", g.hostname)
+			conn.Close()
+			continue
+		}
+		g.sessions.Add(1)
+		go func() {
+			defer g.sessions.Done()
+			defer func() { <-g.conns }()
+			g.session(ctx, conn)
+		}()
+	}
+}
+
+// closeIdle wakes the sessions waiting for a command, so they say goodbye on shutdown. Sessions
+// busy verifying finish their recipient first.
+func (g *Gateway) closeIdle() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for conn := range g.open {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+func (g *Gateway) session(ctx context.Context, conn net.Conn) {
+	g.mu.Lock()
+	g.open[conn] = struct{}{}
+	g.mu.Unlock()
+	defer func() {
+		g.mu.Lock()
+		delete(g.open, conn)
+		g.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReaderSize(conn, gatewayMaxLine)
+	reply := func(line string) {
+		conn.SetWriteDeadline(time.Now().Add(g.idleTimeout))
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 " + g.hostname + " ESMTP address verification gateway")
+	greeted, sender, recipients := false, false, 0
+	for {
+		if ctx.Err() != nil {
+			reply("421 4.3.2 " + g.hostname + " Shutting down")
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(g.idleTimeout))
+		line, err := reader.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			reply("500 5.5.2 Line too long")
+			return
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				reply("421 4.3.2 " + g.hostname + " Shutting down")
+			} else if errors.Is(err, os.ErrDeadlineExceeded) {
+				reply("421 4.4.2 " + g.hostname + " Idle for too long, closing connection")
+			}
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimRight(string(line), "\r\n"), " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			greeted, sender, recipients = true, false, 0
+			reply("250-" + g.hostname)
+			reply("250-ENHANCEDSTATUSCODES")
+			reply("250 8BITMIME")
+		case "HELO":
+			greeted, sender, recipients = true, false, 0
+			reply("250 " + g.hostname)
+		case "MAIL":
+			switch {
+			case !greeted:
+				reply("503 5.5.1 Send HELO or EHLO first")
+			case sender:
+				reply("503 5.5.1 Sender already given")
+			case !strings.HasPrefix(strings.ToUpper(arg), "FROM:"):
+				reply("501 5.5.4 Syntax: MAIL FROM:<address>")
+			default:
+				sender, recipients = true, 0
+				reply("250 2.1.0 OK")
+			}
+		case "RCPT":
+			if !sender {
+				reply("503 5.5.1 Send MAIL first")
+				continue
+			}
+			email, ok := rcptAddress(arg)
+			switch {
+			case !ok:
+				reply("501 5.1.3 Syntax: RCPT TO:<address>")
+			case recipients >= g.maxRecipients:
+				reply("452 4.5.3 Too many recipients")
+			default:
+				recipients++
+				reply(g.recipients.Reply(ctx, email))
+			}
+		case "RSET":
+			sender, recipients = false, 0
+			reply("250 2.0.0 OK")
+		case "NOOP":
+			reply("250 2.0.0 OK")
+		case "VRFY":
+			reply("252 2.5.2 Send RCPT to verify an address")
+		case "DATA", "BDAT":
+			reply("554 5.5.1 Verification only, no messages accepted")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// rcptAddress extracts the address of a RCPT TO:<address> argument, ignoring its parameters
+func rcptAddress(arg string) (string, bool) {
+	if !strings.HasPrefix(strings.ToUpper(arg), "TO:") {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[3:])
+	if start, end := strings.Index(path, "<"), strings.Index(path, ">"); start == 0 && end > start {
+		path = path[1:end]
+	} else if path, _, _ = strings.Cut(path, " "); strings.ContainsAny(path, "<>") {
+		return "", false
+	}
+	// Source routes (<@relay:user@example.com>) are obsolete but still allowed
+	if strings.HasPrefix(path, "@") {
+		_, path, _ = strings.Cut(path, ":")
+	}
+	return path, strings.Contains(path, "@")
+}
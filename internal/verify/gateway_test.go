@@ -0,0 +1,190 @@
+package verify
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startGateway serves a gateway on a local port, shutting it down with the test
+func startGateway(t *testing.T, g *Gateway) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.Serve(ctx, listener)
+		g.sessions.Wait()
+	}()
+	t.Cleanup(func() {
+		cancel()
+		listener.Close()
+		g.closeIdle()
+		<-done
+	})
+	return listener.Addr().String()
+}
+
+// dialGateway connects to the gateway and reads its greeting
+func dialGateway(t *testing.T, addr string) *textproto.Conn {
+	t.Helper()
+	conn, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if _, msg, err := conn.ReadResponse(220); err != nil || msg != "gateway.test ESMTP address verification gateway" {
+		t.Fatalf("greeting %q: %v", msg, err)
+	}
+	return conn
+}
+
+// command sends a command and returns the reply's code and text
+func command(t *testing.T, conn *textproto.Conn, line string) (int, string) {
+	t.Helper()
+	if err := conn.PrintfLine("%s", line); err != nil {
+		t.Fatal(err)
+	}
+	code, msg, err := conn.ReadResponse(0)
+	if _, ok := err.(*textproto.Error); err != nil && !ok {
+		t.Fatalf("%s: %v", line, err)
+	}
+	return code, msg
+}
+
+func TestGatewayReplies(t *testing.T) {
+	recipients := startRecipientVerifier(t, "*=reject,good@acme.test=accept,*@grey.test=greylist:1h", recipientAccept, recipientTempfail)
+	addr := startGateway(t, &Gateway{
+		recipients:    recipients,
+		hostname:      "gateway.test",
+		idleTimeout:   time.Minute,
+		maxRecipients: 4,
+		conns:         make(chan struct{}, 2),
+		open:          make(map[net.Conn]struct{}),
+	})
+	conn := dialGateway(t, addr)
+
+	tests := []struct {
+		line string
+		code int
+		msg  string // prefix of the reply
+	}{
+		{"MAIL FROM:<sender@example.org>", 503, "5.5.1 Send HELO or EHLO first"},
+		{"EHLO client.example.org", 250, "gateway.test\nENHANCEDSTATUSCODES\n8BITMIME"},
+		{"RCPT TO:<good@acme.test>", 503, "5.5.1 Send MAIL first"},
+		{"MAIL FROM:<sender@example.org> SIZE=1024", 250, "2.1.0 OK"},
+		{"MAIL FROM:<sender@example.org>", 503, "5.5.1 Sender already given"},
+		// Accepted, rejected and temporarily failed recipients
+		{"RCPT TO:<good@acme.test> NOTIFY=NEVER", 250, "2.1.5 <good@acme.test>: Recipient OK"},
+		{"RCPT TO:<bad@acme.test>", 550, "5.1.1 <bad@acme.test>: Recipient address rejected: "},
+		{"rcpt to:<jane@grey.test>", 451, "4.4.3 <jane@grey.test>: Recipient address not verified yet"},
+		{"RCPT TO:<@relay.example.org:bad@acme.test>", 550, "5.1.1 <bad@acme.test>: Recipient address rejected: "},
+		{"RCPT TO:<one@acme.test>", 452, "4.5.3 Too many recipients"},
+		{"RCPT TO:postmaster", 501, "5.1.3 Syntax: RCPT TO:<address>"},
+		{"VRFY good@acme.test", 252, "2.5.2 Send RCPT to verify an address"},
+		{"DATA", 554, "5.5.1 Verification only, no messages accepted"},
+		{"STARTTLS", 502, "5.5.2 Command not recognized"},
+		{"RSET", 250, "2.0.0 OK"},
+		{"RCPT TO:<good@acme.test>", 503, "5.5.1 Send MAIL first"},
+		{"HELO client.example.org", 250, "gateway.test"},
+		{"MAIL FROM:<>", 250, "2.1.0 OK"},
+		{"RCPT TO:<bad@acme.test>", 550, "5.1.1 <bad@acme.test>: Recipient address rejected: email is not deliverable"},
+		{"NOOP", 250, "2.0.0 OK"},
+		{"QUIT", 221, "2.0.0 Bye"},
+	}
+	for _, tt := range tests {
+		code, msg := command(t, conn, tt.line)
+		if code != tt.code || !strings.HasPrefix(msg, tt.msg) {
+			t.Errorf("%s: %d %q, want %d %q", tt.line, code, msg, tt.code, tt.msg)
+		}
+	}
+	if _, err := conn.ReadLine(); err == nil {
+		t.Error("connection open after QUIT")
+	}
+}
+
+func TestGatewayLimits(t *testing.T) {
+	g := &Gateway{
+		hostname:      "gateway.test",
+		idleTimeout:   200 * time.Millisecond,
+		maxRecipients: 1,
+		conns:         make(chan struct{}, 1),
+		open:          make(map[net.Conn]struct{}),
+	}
+	addr := startGateway(t, g)
+
+	// A second connection is turned away while the first is open
+	first := dialGateway(t, addr)
+	second, err := textproto.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	if _, msg, err := second.ReadResponse(421); err != nil || msg != "4.3.2 gateway.test Too many connections, try again later" {
+		t.Errorf("second connection got %q: %v", msg, err)
+	}
+
+	// Idle clients are told before being disconnected, freeing their slot
+	if _, msg, err := first.ReadResponse(421); err != nil || msg != "4.4.2 gateway.test Idle for too long, closing connection" {
+		t.Errorf("idle connection got %q: %v", msg, err)
+	}
+
+	// Lines longer than RFC 5321 allows end the session
+	conn := dialGateway(t, addr)
+	if code, msg := command(t, conn, "HELO "+strings.Repeat("x", gatewayMaxLine)); code != 500 || msg != "5.5.2 Line too long" {
+		t.Errorf("long line got %d %q", code, msg)
+	}
+}
+
+func TestRcptAddress(t *testing.T) {
+	tests := []struct {
+		arg   string
+		email string
+		ok    bool
+	}{
+		{"TO:<jane@acme.com>", "jane@acme.com", true},
+		{"to: <jane@acme.com> NOTIFY=SUCCESS ORCPT=rfc822;jane@acme.com", "jane@acme.com", true},
+		{"TO:jane@acme.com", "jane@acme.com", true},
+		{"TO:<@a.example,@b.example:jane@acme.com>", "jane@acme.com", true},
+		{"TO:<postmaster>", "postmaster", false},
+		{"TO:<>", "", false},
+		{"TO:jane@acme.com>", "", false},
+		{"FROM:<jane@acme.com>", "", false},
+	}
+	for _, tt := range tests {
+		email, ok := rcptAddress(tt.arg)
+		if ok != tt.ok || (ok && email != tt.email) {
+			t.Errorf("rcptAddress(%q) = %q, %v, want %q, %v", tt.arg, email, ok, tt.email, tt.ok)
+		}
+	}
+}
+
+func TestRecipientReply(t *testing.T) {
+	tests := []struct {
+		status         int
+		risky, unknown string
+		want           string
+	}{
+		{CheckValid, recipientReject, recipientReject, "250 2.1.5 <jane@acme.com>: Recipient OK: why"},
+		{CheckInvalid, recipientAccept, recipientAccept, "550 5.1.1 <jane@acme.com>: Recipient address rejected: why"},
+		{CheckRisky, recipientReject, recipientAccept, "550 5.7.1 <jane@acme.com>: Recipient address rejected: why"},
+		{CheckRisky, recipientAccept, recipientReject, "250 2.1.5 <jane@acme.com>: Recipient OK: why"},
+		{CheckUnknown, recipientAccept, recipientTempfail, "451 4.4.3 <jane@acme.com>: Recipient address not verified yet: why"},
+		{CheckUnknown, recipientTempfail, recipientReject, "550 5.7.1 <jane@acme.com>: Recipient address rejected: why"},
+	}
+	for _, tt := range tests {
+		if got := recipientReply("jane@acme.com", tt.status, "why", tt.risky, tt.unknown); got != tt.want {
+			t.Errorf("status %d with %s/%s: %q, want %q", tt.status, tt.risky, tt.unknown, got, tt.want)
+		}
+	}
+	// Control characters in the reason can't break the reply line
+	if got := recipientReply("jane@acme.com", CheckInvalid, "no\r\nsuch user", "", ""); got != "550 5.1.1 <jane@acme.com>: Recipient address rejected: no  such user" {
+		t.Errorf("got %q", got)
+	}
+}
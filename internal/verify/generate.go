@@ -0,0 +1,238 @@
+package verify
+
+import (
+	"bufio"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// DefaultGenerateDomains is roughly the mix of a consumer signup list; * stands for company domains
+const DefaultGenerateDomains = "gmail.com=30,yahoo.com=8,outlook.com=6,hotmail.com=6,icloud.com=4,aol.com=2,gmx.de=2,*=42"
+
+// Words synthetic addresses and company domains are made of
+var (
+	generateFirstNames = []string{"james", "mary", "john", "patricia", "robert", "jennifer", "michael", "linda", "david", "elizabeth", "william", "barbara", "richard", "susan", "joseph", "jessica", "thomas", "sarah", "chris", "karen", "daniel", "lisa", "matthew", "nancy", "anthony", "sandra", "mark", "ashley", "paul", "emily", "priya", "wei", "fatima", "lukas", "sofia", "mateo", "aisha", "hiro", "olga", "kwame"}
+	generateLastNames  = []string{"smith", "johnson", "williams", "brown", "jones", "garcia", "miller", "davis", "rodriguez", "martinez", "wilson", "anderson", "taylor", "thomas", "moore", "jackson", "martin", "lee", "thompson", "white", "harris", "clark", "lewis", "walker", "young", "allen", "king", "wright", "scott", "green", "patel", "chen", "kim", "muller", "rossi", "silva", "novak", "nguyen", "okafor", "tanaka"}
+	generateCompanies  = []string{"acme", "globex", "initech", "umbrella", "northwind", "contoso", "fabrikam", "tailspin", "wayne", "stark", "cyberdyne", "hooli", "vandelay", "wonka", "soylent", "tyrell", "massive", "oscorp", "gringotts", "duff", "aperture", "blackmesa", "pied", "sterling", "monarch"}
+	generateSuffixes   = []string{"", "labs", "logistics", "systems", "health", "partners", "group", "tech", "foods", "media", "capital", "energy", "studio"}
+	generateTLDs       = []string{"com", "com", "com", "io", "net", "org", "co.uk", "de", "fr", "com.au"}
+	generateDisposable = []string{"mailinator.com", "guerrillamail.com", "10minutemail.com", "yopmail.com", "temp-mail.org", "trashmail.com", "sharklasers.com", "getnada.com", "dispostable.com", "maildrop.cc"}
+)
+
+// GenerateOptions configures a synthetic email list
+type GenerateOptions struct {
+	Count           int
+	Seed            uint64
+	Domains         string  // domain=weight pairs, * for company domains
+	CompanyDomains  int     // distinct company domains * draws from
+	TypoRatio       float64 // share of addresses with a misspelled domain
+	DisposableRatio float64 // share of addresses on disposable domains
+	InvalidRatio    float64 // share of addresses with broken syntax
+}
+
+// weightedDomain is a domain of the distribution with its cumulative weight
+type weightedDomain struct {
+	domain     string
+	cumulative float64
+}
+
+// emailGenerator produces a synthetic email list, the same one for the same options
+type emailGenerator struct {
+	opts      GenerateOptions
+	rng       *rand.Rand
+	domains   []weightedDomain
+	companies []string
+
+	typos, disposable, invalid int
+}
+
+// Generate writes a synthetic email list to output, a file or StdoutOutput for one address per
+// line, for load testing the pipeline and downstream systems
+func Generate(opts GenerateOptions, output string) error {
+	generator, err := newEmailGenerator(opts)
+	if err != nil {
+		return fmt.Errorf("failed to configure generator: %w", err)
+	}
+	emails := make([]string, opts.Count)
+	for i := range emails {
+		emails[i] = generator.Next()
+	}
+
+	if output == StdoutOutput {
+		writer := bufio.NewWriterSize(os.Stdout, 1024*1024) // 1MB buffer
+		for _, email := range emails {
+			writer.WriteString(email)
+			writer.WriteByte('\n')
+		}
+		err = writer.Flush()
+	} else {
+		err = writeValidEmails(output, emails, OutputFormat{Indent: 2}, true)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	slog.Info("generated addresses", "emails", len(emails), "seed", opts.Seed,
+		"typos", generator.typos, "disposable", generator.disposable, "invalid", generator.invalid)
+	return nil
+}
+
+// newEmailGenerator validates the options and sets up the domain distribution
+func newEmailGenerator(opts GenerateOptions) (*emailGenerator, error) {
+	if opts.Count < 0 {
+		return nil, fmt.Errorf("invalid count %d", opts.Count)
+	}
+	for name, ratio := range map[string]float64{"typos": opts.TypoRatio, "disposable": opts.DisposableRatio, "invalid": opts.InvalidRatio} {
+		if ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("invalid %s ratio %v (expected 0 to 1)", name, ratio)
+		}
+	}
+	if sum := opts.TypoRatio + opts.DisposableRatio + opts.InvalidRatio; sum > 1 {
+		return nil, fmt.Errorf("typo, disposable and invalid ratios add up to %v, more than 1", sum)
+	}
+
+	g := &emailGenerator{opts: opts, rng: rand.New(rand.NewPCG(opts.Seed, opts.Seed))}
+	total := 0.0
+	for _, pair := range splitList(opts.Domains) {
+		// A domain without a weight counts once
+		domain, weight, ok := strings.Cut(pair, "=")
+		w := 1.0
+		if ok {
+			var err error
+			if w, err = strconv.ParseFloat(weight, 64); err != nil || w < 0 {
+				return nil, fmt.Errorf("invalid domain weight %q (expected domain=weight)", pair)
+			}
+		}
+		total += w
+		g.domains = append(g.domains, weightedDomain{domain: strings.ToLower(strings.TrimSpace(domain)), cumulative: total})
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no domains to generate addresses on")
+	}
+	for i := range g.domains {
+		g.domains[i].cumulative /= total
+	}
+
+	if opts.CompanyDomains < 1 {
+		return nil, fmt.Errorf("invalid number of company domains %d", opts.CompanyDomains)
+	}
+	seen := make(map[string]bool)
+	for attempts := 0; len(g.companies) < opts.CompanyDomains && attempts < opts.CompanyDomains*20; attempts++ {
+		name := pick(g.rng, generateCompanies)
+		if suffix := pick(g.rng, generateSuffixes); suffix != "" {
+			name += pick(g.rng, []string{"", "-"}) + suffix
+		}
+		if g.rng.IntN(4) == 0 {
+			name += strconv.Itoa(g.rng.IntN(100))
+		}
+		domain := name + "." + pick(g.rng, generateTLDs)
+		if !seen[domain] {
+			seen[domain] = true
+			g.companies = append(g.companies, domain)
+		}
+	}
+	return g, nil
+}
+
+// Next generates the next address
+func (g *emailGenerator) Next() string {
+	roll := g.rng.Float64()
+	switch {
+	case roll < g.opts.InvalidRatio:
+		g.invalid++
+		return g.breakSyntax(g.localPart(), g.domain())
+	case roll < g.opts.InvalidRatio+g.opts.DisposableRatio:
+		g.disposable++
+		return g.localPart() + "@" + pick(g.rng, generateDisposable)
+	case roll < g.opts.InvalidRatio+g.opts.DisposableRatio+g.opts.TypoRatio:
+		g.typos++
+		return g.localPart() + "@" + g.misspell(g.domain())
+	}
+	return g.localPart() + "@" + g.domain()
+}
+
+// domain draws a domain from the distribution
+func (g *emailGenerator) domain() string {
+	roll := g.rng.Float64()
+	chosen := g.domains[len(g.domains)-1].domain
+	for _, d := range g.domains {
+		if roll < d.cumulative {
+			chosen = d.domain
+			break
+		}
+	}
+	if chosen == "*" {
+		return pick(g.rng, g.companies)
+	}
+	return chosen
+}
+
+// localPart makes a mailbox name in one of the common conventions
+func (g *emailGenerator) localPart() string {
+	first, last := pick(g.rng, generateFirstNames), pick(g.rng, generateLastNames)
+	switch g.rng.IntN(6) {
+	case 0:
+		return first + "." + last
+	case 1:
+		return first[:1] + last
+	case 2:
+		return first + strconv.Itoa(g.rng.IntN(1000))
+	case 3:
+		return first + "_" + last + strconv.Itoa(1950+g.rng.IntN(60))
+	case 4:
+		return first + last[:1]
+	}
+	return first
+}
+
+// misspell introduces the kind of slip people make typing a domain
+func (g *emailGenerator) misspell(domain string) string {
+	name, tld, _ := strings.Cut(domain, ".")
+	if len(name) < 3 {
+		return name + ".con"
+	}
+	i := 1 + g.rng.IntN(len(name)-2)
+	switch g.rng.IntN(4) {
+	case 0: // transposed letters
+		if name[i] != name[i+1] {
+			return name[:i] + string(name[i+1]) + string(name[i]) + name[i+2:] + "." + tld
+		}
+		fallthrough
+	case 1: // dropped letter
+		return name[:i] + name[i+1:] + "." + tld
+	case 2: // doubled letter
+		return name[:i] + string(name[i]) + name[i:] + "." + tld
+	}
+	// mistyped TLD
+	for {
+		if typo := pick(g.rng, []string{"con", "cmo", "co", "om", "comm"}); typo != tld {
+			return name + "." + typo
+		}
+	}
+}
+
+// breakSyntax mangles an address the ways imports and web forms do
+func (g *emailGenerator) breakSyntax(local, domain string) string {
+	switch g.rng.IntN(6) {
+	case 0:
+		return local + domain
+	case 1:
+		return local + "@@" + domain
+	case 2:
+		return local + " @" + domain
+	case 3:
+		return local + ".@" + domain
+	case 4:
+		name, _, _ := strings.Cut(domain, ".")
+		return local + "@" + name
+	}
+	return local + "@" + domain + ","
+}
+
+// pick returns a random element of items
+func pick(rng *rand.Rand, items []string) string {
+	return items[rng.IntN(len(items))]
+}
@@ -0,0 +1,237 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sources of an inferred country, in order of precedence
+const (
+	countrySourceProvider = "provider"
+	countrySourceTLD      = "tld"
+	countrySourceMX       = "mx"
+	countrySourceGeoIP    = "mx_geoip"
+)
+
+// countryGroups are aliases accepted in country filters
+var countryGroups = map[string][]string{
+	"EU": {"AT", "BE", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "GR", "HU", "IE", "IT",
+		"LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE"},
+	"EEA": {"AT", "BE", "BG", "HR", "CY", "CZ", "DK", "EE", "FI", "FR", "DE", "GR", "HU", "IE", "IT",
+		"LV", "LT", "LU", "MT", "NL", "PL", "PT", "RO", "SK", "SI", "ES", "SE", "IS", "LI", "NO"},
+}
+
+// genericCCTLDs are country-code TLDs commonly used without any tie to the country
+var genericCCTLDs = map[string]bool{
+	"ai": true, "cc": true, "co": true, "fm": true, "gg": true, "io": true, "ly": true,
+	"me": true, "nu": true, "to": true, "tv": true, "ws": true, "eu": true,
+}
+
+// ccTLDExceptions are country-code TLDs that differ from the ISO 3166 code
+var ccTLDExceptions = map[string]string{
+	"uk": "GB",
+	"su": "RU",
+	"ac": "SH",
+}
+
+// providerCountries fingerprints regional mailbox providers by domain
+var providerCountries = map[string]string{
+	"mail.ru": "RU", "bk.ru": "RU", "inbox.ru": "RU", "list.ru": "RU", "yandex.ru": "RU", "yandex.com": "RU", "rambler.ru": "RU",
+	"qq.com": "CN", "163.com": "CN", "126.com": "CN", "sina.com": "CN", "sohu.com": "CN", "aliyun.com": "CN", "yeah.net": "CN",
+	"naver.com": "KR", "daum.net": "KR", "hanmail.net": "KR",
+	"web.de": "DE", "gmx.de": "DE", "gmx.net": "DE", "t-online.de": "DE", "freenet.de": "DE",
+	"orange.fr": "FR", "free.fr": "FR", "laposte.net": "FR", "sfr.fr": "FR", "wanadoo.fr": "FR",
+	"libero.it": "IT", "virgilio.it": "IT", "tiscali.it": "IT",
+	"seznam.cz": "CZ", "centrum.cz": "CZ",
+	"wp.pl": "PL", "onet.pl": "PL", "interia.pl": "PL", "o2.pl": "PL",
+	"rediffmail.com": "IN", "uol.com.br": "BR", "bol.com.br": "BR",
+	"btinternet.com": "GB", "sky.com": "GB",
+	"comcast.net": "US", "verizon.net": "US", "att.net": "US", "sbcglobal.net": "US",
+}
+
+// mxCountries fingerprints regional providers by MX host suffix
+var mxCountries = map[string]string{
+	"mxs.mail.ru": "RU", "yandex.net": "RU", "yandex.ru": "RU",
+	"qq.com": "CN", "163.com": "CN", "126.com": "CN", "aliyun.com": "CN",
+	"naver.com": "KR", "daum.net": "KR",
+	"web.de": "DE", "gmx.net": "DE", "t-online.de": "DE",
+	"orange.fr": "FR", "free.fr": "FR",
+	"seznam.cz": "CZ",
+}
+
+// GeoInferrer infers the likely country of a domain from provider, TLD and MX signals
+// and applies the configured country filters
+type GeoInferrer struct {
+	lookupMX func(string) ([]*net.MX, error)
+	resolver *net.Resolver
+	geoIPURL string
+	client   *http.Client
+	only     map[string]bool
+	exclude  map[string]bool
+
+	mu    sync.Mutex
+	cache map[string]*geoEntry
+}
+
+// geoEntry is a cached inference, resolved once per domain
+type geoEntry struct {
+	once    sync.Once
+	country string
+	source  string
+}
+
+// newGeoInferrer creates an inferrer looking up MX records with lookupMX, as net.LookupMX does, and
+// locating MX hosts, resolved with resolver, with the GeoIP API at geoIPURL if set
+func newGeoInferrer(lookupMX func(string) ([]*net.MX, error), resolver *net.Resolver, geoIPURL, onlyCountries, excludeCountries string) *GeoInferrer {
+	return &GeoInferrer{
+		lookupMX: lookupMX,
+		resolver: resolver,
+		geoIPURL: geoIPURL,
+		client:   resolvingClient(resolver, 10*time.Second),
+		only:     parseCountryList(onlyCountries),
+		exclude:  parseCountryList(excludeCountries),
+		cache:    make(map[string]*geoEntry),
+	}
+}
+
+// Country returns the inferred ISO 3166 country code and the signal it came from, or empty strings
+func (g *GeoInferrer) Country(domain string) (string, string) {
+	domain = strings.ToLower(domain)
+
+	g.mu.Lock()
+	entry, ok := g.cache[domain]
+	if !ok {
+		entry = &geoEntry{}
+		g.cache[domain] = entry
+	}
+	g.mu.Unlock()
+
+	entry.once.Do(func() {
+		entry.country, entry.source = g.infer(domain)
+	})
+
+	return entry.country, entry.source
+}
+
+func (g *GeoInferrer) infer(domain string) (string, string) {
+	if country, ok := providerCountries[domain]; ok {
+		return country, countrySourceProvider
+	}
+	if country := countryFromTLD(domain); country != "" {
+		return country, countrySourceTLD
+	}
+
+	records, err := g.lookupMX(domain)
+	if err != nil || len(records) == 0 {
+		return "", ""
+	}
+	host := strings.ToLower(strings.TrimSuffix(records[0].Host, "."))
+
+	for suffix, country := range mxCountries {
+		if host == suffix || strings.HasSuffix(host, "."+suffix) {
+			return country, countrySourceMX
+		}
+	}
+	if country := countryFromTLD(host); country != "" {
+		return country, countrySourceMX
+	}
+
+	if g.geoIPURL != "" {
+		if country, err := g.geolocate(host); err == nil && country != "" {
+			return country, countrySourceGeoIP
+		}
+	}
+
+	return "", ""
+}
+
+// geolocate resolves an MX host and asks the configured GeoIP API where it is
+func (g *GeoInferrer) geolocate(host string) (string, error) {
+	ips, err := g.resolver.LookupIPAddr(context.Background(), host)
+	if err != nil || len(ips) == 0 {
+		return "", fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+
+	resp, err := g.client.Get(strings.ReplaceAll(g.geoIPURL, "{ip}", ips[0].IP.String()))
+	if err != nil {
+		return "", fmt.Errorf("GeoIP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GeoIP lookup returned %s", resp.Status)
+	}
+
+	// Accept the field names used by the common free GeoIP APIs
+	var data struct {
+		CountryCode  string `json:"country_code"`
+		CountryCode2 string `json:"countryCode"`
+		Country      string `json:"country"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return "", fmt.Errorf("failed to decode GeoIP response: %w", err)
+	}
+
+	for _, code := range []string{data.CountryCode, data.CountryCode2, data.Country} {
+		if len(code) == 2 {
+			return strings.ToUpper(code), nil
+		}
+	}
+	return "", nil
+}
+
+// countryFromTLD maps a country-code TLD to its ISO 3166 code, ignoring generically used ones
+func countryFromTLD(domain string) string {
+	tld := domain[strings.LastIndex(domain, ".")+1:]
+	if len(tld) != 2 || genericCCTLDs[tld] {
+		return ""
+	}
+	if country, ok := ccTLDExceptions[tld]; ok {
+		return country
+	}
+	return strings.ToUpper(tld)
+}
+
+// parseCountryList parses a comma-separated list of country codes and group aliases such as EU
+func parseCountryList(list string) map[string]bool {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+
+	countries := make(map[string]bool)
+	for _, code := range strings.Split(list, ",") {
+		code = strings.ToUpper(strings.TrimSpace(code))
+		if code == "" {
+			continue
+		}
+		if group, ok := countryGroups[code]; ok {
+			for _, member := range group {
+				countries[member] = true
+			}
+			continue
+		}
+		countries[code] = true
+	}
+	return countries
+}
+
+// FilterReason returns why a country is excluded by the filters, or an empty string.
+// Addresses whose country could not be inferred are never excluded.
+func (g *GeoInferrer) FilterReason(country string) string {
+	if country == "" {
+		return ""
+	}
+	if g.only != nil && !g.only[country] {
+		return fmt.Sprintf("country %s not in allowed countries", country)
+	}
+	if g.exclude[country] {
+		return fmt.Sprintf("country %s is excluded", country)
+	}
+	return ""
+}
@@ -0,0 +1,150 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// DefaultGoldenDir holds the fixtures and golden verdicts replayed by the golden command
+const DefaultGoldenDir = "testdata/golden"
+
+// GoldenFixture is a recorded verification: the library result for an address, in the format
+// verify-one -json prints, so its output can be saved as a fixture as is
+type GoldenFixture struct {
+	Email   string                `json:"email"`
+	Details *emailverifier.Result `json:"details"`
+}
+
+// GoldenVerdict is the part of a result the rules decide, compared against the golden file
+type GoldenVerdict struct {
+	Email      string                 `json:"email"`
+	IsValid    bool                   `json:"valid"`
+	Risky      bool                   `json:"risky,omitempty"`
+	Reason     string                 `json:"reason,omitempty"`
+	Lookalike  *Lookalike             `json:"lookalike,omitempty"`
+	Confidence float64                `json:"confidence,omitempty"`
+	Country    string                 `json:"country,omitempty"`
+	Checks     map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// ReplayGolden replays the recorded fixtures of dir through the verdict rules and compares the
+// verdicts against their golden files, so a change to the rules or configuration can't silently
+// flip classifications. It fails if any verdict differs or has no golden file; with update it
+// rewrites the golden files instead.
+func ReplayGolden(config Config, dir string, update bool) error {
+	offlineReplay(&config)
+
+	fixtures, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list fixtures: %w", err)
+	}
+	if len(fixtures) == 0 {
+		return fmt.Errorf("no fixtures found in %s", dir)
+	}
+	sort.Strings(fixtures)
+
+	lookups, err := newLookups(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure lookups: %w", err)
+	}
+	defer lookups.Close()
+
+	failed := 0
+	for _, fixture := range fixtures {
+		name := strings.TrimSuffix(filepath.Base(fixture), ".json")
+		got, err := replayFixture(lookups, fixture, config)
+		if err != nil {
+			slog.Error("failed to replay fixture", "fixture", name, "error", err)
+			failed++
+			continue
+		}
+
+		goldenFile := strings.TrimSuffix(fixture, ".json") + ".golden"
+		if update {
+			if err := os.WriteFile(goldenFile, got, 0644); err != nil {
+				return fmt.Errorf("failed to write golden file: %w", err)
+			}
+			continue
+		}
+
+		want, err := os.ReadFile(goldenFile)
+		if err != nil {
+			slog.Error("no golden verdict, run with -update to record it", "fixture", name, "error", err)
+			failed++
+			continue
+		}
+		if !bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+			slog.Error("verdict changed", "fixture", name, "want", compactJSON(want), "got", compactJSON(got))
+			failed++
+		} else if config.Verbose {
+			slog.Debug("verdict unchanged", "fixture", name)
+		}
+	}
+
+	if update {
+		slog.Info("updated golden verdicts", "verdicts", len(fixtures)-failed, "dir", dir)
+	} else {
+		slog.Info("replayed fixtures", "fixtures", len(fixtures), "passed", len(fixtures)-failed, "failed", failed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d fixtures failed", failed, len(fixtures))
+	}
+	return nil
+}
+
+// offlineReplay turns off the lookups that would make a replay depend on the network or leave
+// anything behind
+func offlineReplay(config *Config) {
+	config.EnableRDAP, config.EnableHIBP, config.EnableCompany, config.EnableGeo = false, false, false, false
+	config.CatchAllSamples, config.RCPTTiming = 0, false
+	config.EgressCheck, config.FCrDNSCheck = false, false
+	config.DomainStore = ""
+}
+
+// replayFixture judges a recorded result and returns its verdict as indented JSON
+func replayFixture(lookups *Lookups, path string, config Config) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read fixture: %w", err)
+	}
+	var fixture GoldenFixture
+	if err := json.Unmarshal(data, &fixture); err != nil {
+		return nil, fmt.Errorf("invalid fixture: %w", err)
+	}
+	if fixture.Email == "" || fixture.Details == nil {
+		return nil, fmt.Errorf("invalid fixture: email and details are required")
+	}
+
+	result := judgeResult(lookups, fixture.Email, fixture.Details, verifyTrace{}, "", config)
+	verdict, err := json.MarshalIndent(GoldenVerdict{
+		Email:      result.Email,
+		IsValid:    result.IsValid,
+		Risky:      result.Risky,
+		Reason:     result.Reason,
+		Lookalike:  result.Lookalike,
+		Confidence: result.Confidence,
+		Country:    result.Country,
+		Checks:     result.Checks,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode verdict: %w", err)
+	}
+	return append(verdict, '\n'), nil
+}
+
+// compactJSON squeezes a golden verdict onto one line for the diff report
+func compactJSON(data []byte) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return string(bytes.TrimSpace(data))
+	}
+	return buf.String()
+}
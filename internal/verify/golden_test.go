@@ -0,0 +1,91 @@
+package verify
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "Rewrite the golden files with the current verdicts")
+
+// goldenTestDir is DefaultGoldenDir as seen from the package directory
+var goldenTestDir = filepath.Join("..", "..", DefaultGoldenDir)
+
+func TestGolden(t *testing.T) {
+	tests := []struct {
+		name      string // of the golden file
+		fixture   string // defaults to name
+		configure func(*Config)
+	}{
+		{name: "catch-all"},
+		{name: "catch-all-risky", fixture: "catch-all", configure: func(c *Config) { c.CatchAllRisky = true }},
+		{name: "deliverable"},
+		{name: "deliverable-no-smtp"},
+		{name: "disabled"},
+		{name: "disposable"},
+		{name: "host-missing"},
+		{name: "invalid-syntax"},
+		{name: "lookalike"},
+		{name: "no-mx"},
+		{name: "typo"},
+		{name: "undeliverable"},
+	}
+
+	covered := make(map[string]bool)
+	for _, tt := range tests {
+		fixture := tt.fixture
+		if fixture == "" {
+			fixture = tt.name
+		}
+		covered[fixture] = true
+
+		t.Run(tt.name, func(t *testing.T) {
+			config := DefaultConfig()
+			if tt.configure != nil {
+				tt.configure(&config)
+			}
+			if err := config.Normalize(); err != nil {
+				t.Fatalf("invalid configuration: %v", err)
+			}
+			offlineReplay(&config)
+			lookups, err := newLookups(config)
+			if err != nil {
+				t.Fatalf("failed to configure lookups: %v", err)
+			}
+			defer lookups.Close()
+
+			got, err := replayFixture(lookups, filepath.Join(goldenTestDir, fixture+".json"), config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			goldenFile := filepath.Join(goldenTestDir, tt.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(goldenFile, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatalf("no golden verdict, run with -update to record it: %v", err)
+			}
+			if !bytes.Equal(bytes.TrimSpace(want), bytes.TrimSpace(got)) {
+				t.Errorf("verdict changed\nwant: %s\ngot:  %s", compactJSON(want), compactJSON(got))
+			}
+		})
+	}
+
+	// A fixture without a case would never be replayed
+	fixtures, err := filepath.Glob(filepath.Join(goldenTestDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range fixtures {
+		if name := strings.TrimSuffix(filepath.Base(fixture), ".json"); !covered[name] {
+			t.Errorf("fixture %s has no case in TestGolden", name)
+		}
+	}
+}
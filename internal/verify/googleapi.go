@@ -0,0 +1,283 @@
+package verify
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultMetadataHost serves access tokens of the attached service account on Google Cloud
+const defaultMetadataHost = "metadata.google.internal"
+
+// GoogleAuth gets OAuth access tokens for Google APIs, from the service account key file named by
+// GOOGLE_APPLICATION_CREDENTIALS or, without one, from the metadata server of the Google Cloud
+// machine or pod it runs on
+type GoogleAuth struct {
+	client   *http.Client
+	scopes   []string
+	key      *serviceAccountKey // nil to use the metadata server
+	metadata string             // host of the metadata server
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// serviceAccountKey is the part of a service account's JSON key file needed to sign token requests
+type serviceAccountKey struct {
+	Type        string `json:"type"`
+	ProjectID   string `json:"project_id"`
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+	signer      *rsa.PrivateKey
+}
+
+func newGoogleAuth(client *http.Client, path, metadataHost string, scopes ...string) (*GoogleAuth, error) {
+	auth := &GoogleAuth{client: client, scopes: scopes, metadata: metadataHost}
+	if path == "" {
+		return auth, nil
+	}
+	key, err := loadServiceAccountKey(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+	}
+	auth.key = key
+	return auth, nil
+}
+
+func loadServiceAccountKey(path string) (*serviceAccountKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var key serviceAccountKey
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if key.Type != "service_account" {
+		return nil, fmt.Errorf("%s is a %q key, not a service account key", path, key.Type)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("%s has no PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the private key of %s: %w", path, err)
+	}
+	signer, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the private key of %s is not an RSA key", path)
+	}
+	key.signer = signer
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &key, nil
+}
+
+// ProjectID is the project of the service account key, empty when using the metadata server
+func (a *GoogleAuth) ProjectID() string {
+	if a.key == nil {
+		return ""
+	}
+	return a.key.ProjectID
+}
+
+// Token returns an access token, fetching a new one shortly before the last one expires
+func (a *GoogleAuth) Token() (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Now().Before(a.expiry.Add(-time.Minute)) {
+		return a.token, nil
+	}
+
+	var req *http.Request
+	var err error
+	if a.key != nil {
+		req, err = a.key.tokenRequest(a.scopes, time.Now())
+	} else {
+		host := a.metadata
+		query := url.Values{"scopes": {strings.Join(a.scopes, ",")}}
+		req, err = http.NewRequest(http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token?"+query.Encode(), nil)
+		if req != nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to build token request: %w", err)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Google access token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("Google token endpoint returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode Google access token: %w", err)
+	}
+	a.token = token.AccessToken
+	a.expiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return a.token, nil
+}
+
+// tokenRequest exchanges a JWT signed with the key for an access token (RFC 7523)
+func (k *serviceAccountKey) tokenRequest(scopes []string, now time.Time) (*http.Request, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]any{
+		"iss":   k.ClientEmail,
+		"scope": strings.Join(scopes, " "),
+		"aud":   k.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(nil, k.signer, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign token request: %w", err)
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequest(http.MethodPost, k.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// googleError is a failed Google API request; Retryable marks throttling, server errors and timeouts
+type googleError struct {
+	Status    int
+	Message   string
+	Retryable bool
+}
+
+func (e *googleError) Error() string {
+	if e.Status == 0 {
+		return "Google API request failed: " + e.Message
+	}
+	return fmt.Sprintf("Google API returned %d: %s", e.Status, e.Message)
+}
+
+// do sends an authorized request with a JSON body, or the body as is if it's a reader, and
+// decodes the JSON response of a successful one into out unless it's nil
+func (a *GoogleAuth) do(method, target string, body any, out any) (http.Header, error) {
+	var reader io.Reader
+	contentType := "application/json"
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reader, contentType = b, "application/octet-stream"
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	token, err := a.Token()
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Google API request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	// Uploads are sent with their length rather than chunked
+	if sized, ok := reader.(interface{ Size() int64 }); ok {
+		req.ContentLength = sized.Size()
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, &googleError{Message: err.Error(), Retryable: true}
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &googleError{Status: resp.StatusCode, Message: err.Error(), Retryable: true}
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		message := strings.TrimSpace(string(data))
+		if json.Unmarshal(data, &failure) == nil && failure.Error.Message != "" {
+			message = failure.Error.Message
+		}
+		return nil, &googleError{
+			Status:    resp.StatusCode,
+			Message:   message,
+			Retryable: resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout,
+		}
+	}
+	if out != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, out); err != nil {
+			return nil, fmt.Errorf("failed to decode Google API response: %w", err)
+		}
+	}
+	return resp.Header, nil
+}
+
+// retryGoogle calls fn until it succeeds, fails for good or has been retried retries times
+func retryGoogle(retries int, what string, fn func() error) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var apiErr *googleError
+		if err == nil || !errors.As(err, &apiErr) || !apiErr.Retryable || attempt > retries {
+			if err != nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			return nil
+		}
+		slog.Warn(what+" failed, retrying", "attempt", attempt, "attempts", retries+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+// notFound reports whether a Google API request failed because its resource doesn't exist
+func notFound(err error) bool {
+	var apiErr *googleError
+	return errors.As(err, &apiErr) && apiErr.Status == http.StatusNotFound
+}
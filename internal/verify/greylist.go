@@ -0,0 +1,105 @@
+package verify
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// GreylistQueue defers addresses whose mail server greylisted them, to try them again once the
+// server is likely to accept a second contact. Greylisting answers first contact with a 4xx, which
+// the library's result doesn't keep: the address just looks undeliverable, or the domain catch-all
+// when its random probe was the one deferred. The SMTP probe notes the 4xx in the trace instead.
+type GreylistQueue struct {
+	delay time.Duration
+
+	mu       sync.Mutex
+	deferred []deferredJob
+	retrying map[string]bool // addresses deferred once, keyed as verified
+	final    bool            // the retry pass is running, so nothing is deferred again
+}
+
+// deferredJob is a greylisted address waiting for its retry
+type deferredJob struct {
+	job EmailJob
+	due time.Time
+}
+
+func newGreylistQueue(delay time.Duration) *GreylistQueue {
+	return &GreylistQueue{delay: delay, retrying: make(map[string]bool)}
+}
+
+// Defer queues a job whose address was greylisted for a retry after the delay. It returns false
+// once the retry pass has started, when a result that is still deferred is final.
+func (q *GreylistQueue) Defer(job EmailJob, email string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.final {
+		return false
+	}
+	q.deferred = append(q.deferred, deferredJob{job: job, due: time.Now().Add(q.delay)})
+	q.retrying[email] = true
+	return true
+}
+
+// Drain returns the deferred jobs in the order they are due and starts the retry pass
+func (q *GreylistQueue) Drain() []deferredJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.final = true
+	deferred := q.deferred
+	q.deferred = nil
+	return deferred
+}
+
+// Retrying reports whether an address is being tried again after it was greylisted
+func (q *GreylistQueue) Retrying(email string) bool {
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.retrying[email]
+}
+
+// Check probes the address itself when the SMTP probe was deferred, returning the server's reply
+// if it defers the address again. Addresses the server answered for, such as with a 550, are left
+// alone. When retrying a greylisted address, the direct reply decides the SMTP result, since the
+// library's random catch-all probe is always a first contact.
+func (q *GreylistQueue) Check(session smtpSession, mxHost, email string, deferred bool, result *emailverifier.Result) (string, error) {
+	retrying := q.Retrying(email)
+	if (result.SMTP.Deliverable || !deferred) && !retrying {
+		return "", nil
+	}
+
+	replies, err := probeRecipients(session, mxHost, []string{email})
+	if err != nil {
+		return "", err
+	}
+	reply := replies[0]
+	if greylistReply(reply.code) {
+		return fmt.Sprintf("%d %s", reply.code, reply.message), nil
+	}
+
+	// The SMTP result may be shared with other addresses, so it is replaced rather than changed
+	if retrying {
+		smtp := *result.SMTP
+		smtp.CatchAll, smtp.Deliverable = false, reply.code < 300
+		result.SMTP, result.Reachable = &smtp, "no"
+		if smtp.Deliverable {
+			result.Reachable = "yes"
+		}
+	}
+	return "", nil
+}
+
+// greylistReply reports whether an RCPT reply code defers the address rather than answering for
+// it. 452 is left out: it means a full mailbox or too many recipients.
+func greylistReply(code int) bool {
+	return code == 421 || code == 450 || code == 451
+}
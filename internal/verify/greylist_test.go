@@ -0,0 +1,95 @@
+package verify
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// mockMXSession dials the mock MX directly, counting the connections made
+func mockMXSession(t *testing.T, spec string) (smtpSession, *atomic.Int32) {
+	if testing.Short() {
+		t.Skip("probes a mock mail server")
+	}
+	_, port := startMockMX(t, spec)
+	dials := new(atomic.Int32)
+	return smtpSession{
+		dial: func(addr string, timeout time.Duration) (net.Conn, error) {
+			dials.Add(1)
+			return net.DialTimeout("tcp", addr, timeout)
+		},
+		port:             strconv.Itoa(port),
+		hello:            "verifier.test",
+		from:             "probe@verifier.test",
+		connectTimeout:   time.Second,
+		operationTimeout: time.Second,
+	}, dials
+}
+
+func TestProbeMailboxDeferred(t *testing.T) {
+	session, _ := mockMXSession(t, "*=reject,good@acme.test=accept,*@grey.test=greylist:1h")
+	mx := &emailverifier.Mx{HasMXRecord: true, Records: []*net.MX{{Host: "127.0.0.1"}}}
+
+	tests := []struct {
+		domain, username string
+		checkCatchAll    bool
+		deferred         bool
+	}{
+		{"acme.test", "good", true, false},
+		{"acme.test", "bad", true, false},
+		// Deferring the random mailbox makes the domain look catch-all
+		{"grey.test", "jane", true, true},
+		{"grey.test", "jane", false, true},
+	}
+	for _, tt := range tests {
+		smtp, _, deferred, err := probeMailbox(session, mx, tt.domain, tt.username, tt.checkCatchAll)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if deferred != tt.deferred {
+			t.Errorf("%s@%s: deferred = %t, want %t (%+v)", tt.username, tt.domain, deferred, tt.deferred, smtp)
+		}
+	}
+}
+
+func TestGreylistCheck(t *testing.T) {
+	session, dials := mockMXSession(t, "*=reject,good@acme.test=accept,*@grey.test=greylist:1h")
+	q := newGreylistQueue(time.Minute)
+	undeliverable := func() *emailverifier.Result {
+		return &emailverifier.Result{Reachable: "no", SMTP: &emailverifier.SMTP{HostExists: true}}
+	}
+
+	// A 550 answers for the address, so it isn't probed again
+	deferral, err := q.Check(session, "127.0.0.1", "bad@acme.test", false, undeliverable())
+	if deferral != "" || err != nil || dials.Load() != 0 {
+		t.Errorf("undeliverable address: %q, %v after %d connections", deferral, err, dials.Load())
+	}
+
+	// A deferred probe is checked directly, and the server defers it again
+	deferral, err = q.Check(session, "127.0.0.1", "jane@grey.test", true, undeliverable())
+	if !strings.HasPrefix(deferral, "451 ") || err != nil || dials.Load() != 1 {
+		t.Errorf("deferred address: %q, %v after %d connections", deferral, err, dials.Load())
+	}
+
+	// A deferral that the address gets answered for on the direct probe isn't greylisting
+	deferral, err = q.Check(session, "127.0.0.1", "bad@acme.test", true, undeliverable())
+	if deferral != "" || err != nil || dials.Load() != 2 {
+		t.Errorf("deferred, then rejected address: %q, %v after %d connections", deferral, err, dials.Load())
+	}
+
+	// When retrying, the direct reply decides the result even without a deferral
+	q.Defer(EmailJob{}, "good@acme.test")
+	result := &emailverifier.Result{Reachable: "unknown", SMTP: &emailverifier.SMTP{HostExists: true, CatchAll: true}}
+	shared := result.SMTP
+	if deferral, err := q.Check(session, "127.0.0.1", "good@acme.test", false, result); deferral != "" || err != nil {
+		t.Errorf("retried address: %q, %v", deferral, err)
+	}
+	if !result.SMTP.Deliverable || result.SMTP.CatchAll || result.Reachable != "yes" || !shared.CatchAll {
+		t.Errorf("retried result %+v, %+v", result, result.SMTP)
+	}
+}
@@ -0,0 +1,492 @@
+package verify
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// gRPC status codes returned by the service
+const (
+	grpcOK                = 0
+	grpcCanceled          = 1
+	grpcInvalidArgument   = 3
+	grpcResourceExhausted = 8
+	grpcUnimplemented     = 12
+	grpcInternal          = 13
+	grpcUnauthenticated   = 16
+)
+
+// grpcMaxMessage bounds the size of a received message
+const grpcMaxMessage = 64 << 20
+
+// grpcServicePath prefixes the methods of the Verifier service in proto/verification.proto
+const grpcServicePath = "/emailverification.v1.Verifier/"
+
+// grpcError is a call failing with a gRPC status
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string { return e.message }
+
+func grpcErrorf(code int, format string, args ...any) error {
+	return &grpcError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// GRPCService serves the Verifier service of proto/verification.proto. It speaks the gRPC wire
+// protocol over cleartext HTTP/2 with a hand-written codec for its few messages, so the server
+// needs no code generation or gRPC runtime. Calls run through the same pipeline as /verify.
+type GRPCService struct {
+	jobs     *JobManager
+	config   Config
+	limits   JobLimits
+	maxBatch int
+	token    string
+}
+
+// serveGRPC serves the gRPC service on addr until the listener fails
+func serveGRPC(addr string, service *GRPCService) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           h2c.NewHandler(service, &http2.Server{}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	slog.Info("serving gRPC", "addr", addr)
+	return fmt.Errorf("gRPC server failed: %w", server.ListenAndServe())
+}
+
+func (s *GRPCService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 || r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "gRPC requests only", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+
+	code, message := grpcOK, ""
+	if err := s.call(w, r); err != nil {
+		var status *grpcError
+		if errors.As(err, &status) {
+			code, message = status.code, status.message
+		} else {
+			code, message = grpcInternal, err.Error()
+		}
+	}
+	w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+	if message != "" {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpcPercentEncode(message))
+	}
+}
+
+// call authenticates the call and dispatches it to its method
+func (s *GRPCService) call(w http.ResponseWriter, r *http.Request) error {
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+s.token)) != 1 {
+		return grpcErrorf(grpcUnauthenticated, "missing or invalid API token")
+	}
+	if encoding := r.Header.Get("Grpc-Encoding"); encoding != "" && encoding != "identity" {
+		return grpcErrorf(grpcUnimplemented, "compression %q is not supported", encoding)
+	}
+
+	switch strings.TrimPrefix(r.URL.Path, grpcServicePath) {
+	case "VerifyEmail":
+		return s.verifyEmail(w, r)
+	case "VerifyBatch":
+		return s.verifyBatch(w, r)
+	case "VerifyStream":
+		return s.verifyStream(w, r)
+	default:
+		return grpcErrorf(grpcUnimplemented, "unknown method %s", r.URL.Path)
+	}
+}
+
+func (s *GRPCService) verifyEmail(w http.ResponseWriter, r *http.Request) error {
+	email, options, err := s.readEmailRequest(r.Body)
+	if err != nil {
+		// A unary call without its request is the client's fault, as in VerifyBatch
+		return grpcReadError(err)
+	}
+	if err := s.admit(1); err != nil {
+		return err
+	}
+	results, _ := s.jobs.Verify(r.Context(), []string{email}, options)
+	if len(results) == 0 {
+		return grpcErrorf(grpcInvalidArgument, "the pre-hook dropped the address")
+	}
+	return writeGRPCMessage(w, encodeEmailResult(results[0]))
+}
+
+func (s *GRPCService) verifyBatch(w http.ResponseWriter, r *http.Request) error {
+	data, err := readGRPCMessage(r.Body)
+	if err != nil {
+		return grpcReadError(err)
+	}
+	var emails []string
+	var query url.Values
+	if err := decodeProto(data, func(field int, _ uint64, value []byte) error {
+		switch field {
+		case 1:
+			emails = append(emails, string(value))
+		case 2:
+			query, err = decodeVerifyOptions(value)
+			return err
+		}
+		return nil
+	}); err != nil {
+		return grpcErrorf(grpcInvalidArgument, "invalid request: %v", err)
+	}
+	options, err := parseJobOptions(query, s.config, s.limits)
+	if err != nil {
+		return grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	if len(emails) > s.maxBatch {
+		return grpcErrorf(grpcInvalidArgument, "batch of %d addresses exceeds the limit of %d; use VerifyStream", len(emails), s.maxBatch)
+	}
+	if err := s.admit(len(emails)); err != nil {
+		return err
+	}
+
+	results, stats := s.jobs.Verify(r.Context(), emails, options)
+	var response []byte
+	for _, result := range results {
+		response = appendProtoMessage(response, 1, encodeEmailResult(result))
+	}
+	response = appendProtoInt64(response, 2, stats.TotalChecked)
+	response = appendProtoInt64(response, 3, stats.TotalValid)
+	response = appendProtoInt64(response, 4, stats.TotalInvalid)
+	response = appendProtoInt64(response, 5, stats.TotalRisky)
+	return writeGRPCMessage(w, response)
+}
+
+// verifyStream verifies addresses while the client is still sending them. Whatever has arrived
+// by the time the previous addresses are checked is verified together, up to -max-batch at a
+// time, so a fast sender gets batch throughput and a slow one prompt answers.
+func (s *GRPCService) verifyStream(w http.ResponseWriter, r *http.Request) error {
+	first, options, err := s.readEmailRequest(r.Body)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx := r.Context()
+	emails := make(chan string, s.maxBatch)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(emails)
+		for {
+			email, _, err := s.readEmailRequest(r.Body)
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				readErr <- err
+				return
+			}
+			select {
+			case emails <- email:
+			case <-ctx.Done():
+				readErr <- ctx.Err()
+				return
+			}
+		}
+	}()
+
+	pending := []string{first}
+	for {
+	collect:
+		for len(pending) < s.maxBatch {
+			select {
+			case email, ok := <-emails:
+				if !ok {
+					break collect
+				}
+				pending = append(pending, email)
+			default:
+				break collect
+			}
+		}
+		if len(pending) > 0 {
+			if err := s.admit(len(pending)); err != nil {
+				return err
+			}
+			results, _ := s.jobs.Verify(ctx, pending, options)
+			if ctx.Err() != nil {
+				return grpcErrorf(grpcCanceled, "stream canceled")
+			}
+			for _, result := range results {
+				if err := writeGRPCMessage(w, encodeEmailResult(result)); err != nil {
+					return err
+				}
+			}
+			pending = pending[:0]
+		}
+
+		email, ok := <-emails
+		if !ok {
+			if err := <-readErr; err != nil {
+				return grpcReadError(err)
+			}
+			return nil
+		}
+		pending = append(pending, email)
+	}
+}
+
+// readEmailRequest reads a VerifyEmailRequest, returning io.EOF at the end of the stream
+func (s *GRPCService) readEmailRequest(body io.Reader) (string, JobOptions, error) {
+	data, err := readGRPCMessage(body)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return "", JobOptions{}, err
+		}
+		return "", JobOptions{}, grpcReadError(err)
+	}
+	var email string
+	var query url.Values
+	if err := decodeProto(data, func(field int, _ uint64, value []byte) error {
+		switch field {
+		case 1:
+			email = string(value)
+		case 2:
+			query, err = decodeVerifyOptions(value)
+			return err
+		}
+		return nil
+	}); err != nil {
+		return "", JobOptions{}, grpcErrorf(grpcInvalidArgument, "invalid request: %v", err)
+	}
+	if email == "" {
+		return "", JobOptions{}, grpcErrorf(grpcInvalidArgument, "email is required")
+	}
+	options, err := parseJobOptions(query, s.config, s.limits)
+	if err != nil {
+		return "", JobOptions{}, grpcErrorf(grpcInvalidArgument, "%v", err)
+	}
+	return email, options, nil
+}
+
+// admit applies the server's admission control to a call
+func (s *GRPCService) admit(n int) error {
+	if err := s.jobs.Admit(n); err != nil {
+		return grpcErrorf(grpcResourceExhausted, "%v", err)
+	}
+	return nil
+}
+
+// decodeVerifyOptions turns VerifyOptions into the query parameters parseJobOptions reads
+func decodeVerifyOptions(data []byte) (url.Values, error) {
+	query := url.Values{}
+	err := decodeProto(data, func(field int, varint uint64, value []byte) error {
+		switch field {
+		case 1:
+			query.Set("workers", strconv.Itoa(int(int32(varint))))
+		case 2:
+			query.Set("rate", string(value))
+		case 3:
+			query.Set("smtp", strconv.FormatBool(varint != 0))
+		}
+		return nil
+	})
+	return query, err
+}
+
+// encodeEmailResult encodes a result as the EmailResult message
+func encodeEmailResult(result EmailResult) []byte {
+	var b []byte
+	b = appendProtoString(b, 1, result.Email)
+	b = appendProtoBool(b, 2, result.IsValid)
+	b = appendProtoBool(b, 3, result.Risky)
+	b = appendProtoString(b, 4, result.Reason)
+	if !result.CheckedAt.IsZero() {
+		b = appendProtoString(b, 5, result.CheckedAt.UTC().Format(time.RFC3339))
+	}
+	if result.ExpiresAt != nil {
+		b = appendProtoString(b, 6, result.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	b = appendProtoString(b, 7, result.ProbedAs)
+	if result.Confidence != 0 {
+		b = appendProtoFixed64(b, 8, math.Float64bits(result.Confidence))
+	}
+	b = appendProtoString(b, 9, result.Country)
+	b = appendProtoBool(b, 10, result.Greylisted)
+	b = appendProtoBool(b, 11, result.Deferred)
+	b = appendProtoString(b, 12, result.Policy)
+	if details, err := json.Marshal(result); err == nil {
+		b = appendProtoString(b, 15, string(details))
+	}
+	return b
+}
+
+// readGRPCMessage reads a length-prefixed gRPC message, returning io.EOF at the end of the stream
+func readGRPCMessage(r io.Reader) ([]byte, error) {
+	var prefix [5]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("truncated message: %w", err)
+		}
+		return nil, err
+	}
+	if prefix[0] != 0 {
+		return nil, grpcErrorf(grpcUnimplemented, "compressed messages are not supported")
+	}
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpcMaxMessage {
+		return nil, grpcErrorf(grpcResourceExhausted, "message of %d bytes exceeds the limit of %d", size, grpcMaxMessage)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("truncated message: %w", err)
+	}
+	return data, nil
+}
+
+// grpcReadError maps a failure to read a request to a status, keeping statuses already set
+func grpcReadError(err error) error {
+	var status *grpcError
+	if errors.As(err, &status) {
+		return err
+	}
+	if errors.Is(err, context.Canceled) {
+		return grpcErrorf(grpcCanceled, "stream canceled")
+	}
+	return grpcErrorf(grpcInvalidArgument, "failed to read request: %v", err)
+}
+
+// writeGRPCMessage writes a length-prefixed gRPC message and flushes it to the client
+func writeGRPCMessage(w http.ResponseWriter, message []byte) error {
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		return grpcErrorf(grpcCanceled, "failed to send response: %v", err)
+	}
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// grpcPercentEncode encodes a status message as the grpc-message trailer requires
+func grpcPercentEncode(message string) string {
+	var b strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Protobuf wire types
+const (
+	protoVarint  = 0
+	protoFixed64 = 1
+	protoBytes   = 2
+	protoFixed32 = 5
+)
+
+// appendProtoTag appends a field's key
+func appendProtoTag(b []byte, field, wireType int) []byte {
+	return binary.AppendUvarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// appendProtoString appends a string field, leaving it out when empty as proto3 does
+func appendProtoString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = appendProtoTag(b, field, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// appendProtoMessage appends an embedded message, even an empty one, as repeated fields need
+func appendProtoMessage(b []byte, field int, message []byte) []byte {
+	b = appendProtoTag(b, field, protoBytes)
+	b = binary.AppendUvarint(b, uint64(len(message)))
+	return append(b, message...)
+}
+
+func appendProtoBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, protoVarint), 1)
+}
+
+func appendProtoInt64(b []byte, field int, v int64) []byte {
+	if v == 0 {
+		return b
+	}
+	return binary.AppendUvarint(appendProtoTag(b, field, protoVarint), uint64(v))
+}
+
+func appendProtoFixed64(b []byte, field int, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(appendProtoTag(b, field, protoFixed64), v)
+}
+
+// decodeProto calls each for every varint and length-delimited field of a message; fixed-size
+// fields, which no request has, are skipped
+func decodeProto(data []byte, each func(field int, varint uint64, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), int(key&7)
+
+		switch wireType {
+		case protoVarint:
+			v, n := binary.Uvarint(data)
+			if n <= 0 {
+				return fmt.Errorf("invalid varint in field %d", field)
+			}
+			data = data[n:]
+			if err := each(field, v, nil); err != nil {
+				return err
+			}
+		case protoBytes:
+			size, n := binary.Uvarint(data)
+			if n <= 0 || size > uint64(len(data)-n) {
+				return fmt.Errorf("invalid length of field %d", field)
+			}
+			value := data[n : n+int(size)]
+			data = data[n+int(size):]
+			if err := each(field, 0, value); err != nil {
+				return err
+			}
+		case protoFixed64:
+			if len(data) < 8 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data = data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data = data[4:]
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", wireType, field)
+		}
+	}
+	return nil
+}
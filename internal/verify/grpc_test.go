@@ -0,0 +1,358 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// startGRPC serves the gRPC service over cleartext HTTP/2 as serveGRPC does, verifying addresses
+// against a mock MX, and returns a client speaking HTTP/2 with prior knowledge as gRPC clients do
+func startGRPC(t *testing.T, token string, maxBatch int) (*http.Client, string) {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("probes a mock mail server")
+	}
+	resolver, port := startMockMX(t, "*=reject,good@acme.test=accept")
+	config := DefaultConfig()
+	offlineReplay(&config)
+	config.Resolver = resolver
+	config.SMTPPort = port
+	config.EnableStrategies = false
+	config.RateLimit, config.RampUp = 0, 0
+	config.SMTPOperationTimeout = time.Second
+	if err := config.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	lookups, err := newLookups(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(lookups.Close)
+	jobs := newJobManager(config, lookups, 1, 0, AdmissionLimits{})
+	service := &GRPCService{jobs: jobs, config: config, limits: JobLimits{MaxWorkers: 4, MinRate: 10 * time.Millisecond}, maxBatch: maxBatch, token: token}
+
+	server := httptest.NewServer(h2c.NewHandler(service, &http2.Server{}))
+	t.Cleanup(server.Close)
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+	t.Cleanup(client.CloseIdleConnections)
+	return client, server.URL + grpcServicePath
+}
+
+// grpcFrame prefixes a message with the uncompressed flag and its length
+func grpcFrame(message []byte) []byte {
+	frame := []byte{0}
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(message)))
+	return append(frame, message...)
+}
+
+// grpcReply is what a call got back: its messages, status and status message
+type grpcReply struct {
+	messages [][]byte
+	status   int
+	message  string
+}
+
+// grpcCall makes a call with the given request body, reading the response messages and trailers
+func grpcCall(t *testing.T, client *http.Client, url string, header http.Header, body []byte) grpcReply {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	return readGRPCReply(t, resp)
+}
+
+func readGRPCReply(t *testing.T, resp *http.Response) grpcReply {
+	t.Helper()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/grpc" {
+		t.Fatalf("HTTP %d with content type %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	var reply grpcReply
+	for {
+		message, err := readGRPCMessage(resp.Body)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		reply.messages = append(reply.messages, message)
+	}
+	// The status comes in trailers, which are only there once the body is read
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		t.Fatalf("no grpc-status trailer in %v", resp.Trailer)
+	}
+	reply.status, _ = strconv.Atoi(status)
+	reply.message = resp.Trailer.Get("Grpc-Message")
+	return reply
+}
+
+// emailRequest encodes a VerifyEmailRequest
+func emailRequest(email string, options []byte) []byte {
+	request := appendProtoString(nil, 1, email)
+	if options != nil {
+		request = appendProtoMessage(request, 2, options)
+	}
+	return request
+}
+
+// decodedResult is the EmailResult fields the tests check
+type decodedResult struct {
+	email   string
+	valid   bool
+	reason  string
+	details map[string]any
+}
+
+func decodeEmailResult(t *testing.T, data []byte) decodedResult {
+	t.Helper()
+	var result decodedResult
+	err := decodeProto(data, func(field int, varint uint64, value []byte) error {
+		switch field {
+		case 1:
+			result.email = string(value)
+		case 2:
+			result.valid = varint != 0
+		case 4:
+			result.reason = string(value)
+		case 15:
+			return json.Unmarshal(value, &result.details)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("invalid EmailResult: %v", err)
+	}
+	return result
+}
+
+func TestGRPCVerifyEmail(t *testing.T) {
+	client, url := startGRPC(t, "", 10)
+
+	reply := grpcCall(t, client, url+"VerifyEmail", nil, grpcFrame(emailRequest("good@acme.test", nil)))
+	if reply.status != grpcOK || reply.message != "" || len(reply.messages) != 1 {
+		t.Fatalf("status %d %q with %d messages", reply.status, reply.message, len(reply.messages))
+	}
+	result := decodeEmailResult(t, reply.messages[0])
+	if result.email != "good@acme.test" || !result.valid || result.details["email"] != "good@acme.test" {
+		t.Errorf("result %+v", result)
+	}
+
+	reply = grpcCall(t, client, url+"VerifyEmail", nil, grpcFrame(emailRequest("bad@acme.test", nil)))
+	if result := decodeEmailResult(t, reply.messages[0]); reply.status != grpcOK || result.valid || result.reason == "" {
+		t.Errorf("status %d, result %+v", reply.status, result)
+	}
+}
+
+func TestGRPCVerifyBatch(t *testing.T) {
+	client, url := startGRPC(t, "", 3)
+
+	var request []byte
+	for _, email := range []string{"good@acme.test", "bad@acme.test", "not an address"} {
+		request = appendProtoString(request, 1, email)
+	}
+	reply := grpcCall(t, client, url+"VerifyBatch", nil, grpcFrame(request))
+	if reply.status != grpcOK || len(reply.messages) != 1 {
+		t.Fatalf("status %d %q with %d messages", reply.status, reply.message, len(reply.messages))
+	}
+	var results []decodedResult
+	counts := make(map[int]uint64)
+	decodeProto(reply.messages[0], func(field int, varint uint64, value []byte) error {
+		if field == 1 {
+			results = append(results, decodeEmailResult(t, value))
+		} else {
+			counts[field] = varint
+		}
+		return nil
+	})
+	if len(results) != 3 || results[0].email != "good@acme.test" || !results[0].valid ||
+		results[1].email != "bad@acme.test" || results[1].valid || results[2].valid {
+		t.Errorf("results %+v", results)
+	}
+	if counts[2] != 3 || counts[3] != 1 || counts[4] != 2 {
+		t.Errorf("checked, valid, invalid = %d, %d, %d", counts[2], counts[3], counts[4])
+	}
+
+	// Options: without SMTP the rejected mailbox passes on its MX records alone
+	options := appendProtoInt64(nil, 1, 2)
+	options = appendProtoString(options, 2, "20ms")
+	options = append(appendProtoTag(options, 3, protoVarint), 0)
+	request = appendProtoMessage(appendProtoString(nil, 1, "bad@acme.test"), 2, options)
+	reply = grpcCall(t, client, url+"VerifyBatch", nil, grpcFrame(request))
+	decodeProto(reply.messages[0], func(field int, _ uint64, value []byte) error {
+		if field != 1 {
+			return nil
+		}
+		if result := decodeEmailResult(t, value); !result.valid {
+			t.Errorf("smtp=false still probed: %+v", result)
+		}
+		return nil
+	})
+}
+
+func TestGRPCVerifyStream(t *testing.T) {
+	client, url := startGRPC(t, "", 10)
+
+	// Each result comes back while the request stream is still open
+	body, requests := io.Pipe()
+	req, err := http.NewRequest(http.MethodPost, url+"VerifyStream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Error(err)
+			close(responses)
+			return
+		}
+		responses <- resp
+	}()
+
+	requests.Write(grpcFrame(emailRequest("good@acme.test", nil)))
+	resp, ok := <-responses
+	if !ok {
+		return
+	}
+	defer resp.Body.Close()
+	for _, email := range []string{"good@acme.test", "bad@acme.test"} {
+		if email != "good@acme.test" {
+			requests.Write(grpcFrame(emailRequest(email, nil)))
+		}
+		message, err := readGRPCMessage(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := decodeEmailResult(t, message); result.email != email || result.valid != (email == "good@acme.test") {
+			t.Errorf("streamed %+v for %s", result, email)
+		}
+	}
+	requests.Close()
+	if reply := readGRPCReply(t, resp); reply.status != grpcOK || len(reply.messages) != 0 {
+		t.Errorf("stream ended with status %d %q and %d more messages", reply.status, reply.message, len(reply.messages))
+	}
+}
+
+func TestGRPCStatusCodes(t *testing.T) {
+	client, url := startGRPC(t, "secret", 2)
+	auth := http.Header{"Authorization": {"Bearer secret"}}
+	good := grpcFrame(emailRequest("good@acme.test", nil))
+
+	tests := []struct {
+		name    string
+		method  string
+		header  http.Header
+		body    []byte
+		status  int
+		message string // substring of the status message
+	}{
+		{name: "no token", method: "VerifyEmail", body: good, status: grpcUnauthenticated, message: "missing or invalid API token"},
+		{name: "wrong token", method: "VerifyEmail", header: http.Header{"Authorization": {"Bearer guess"}}, body: good, status: grpcUnauthenticated},
+		{name: "unknown method", method: "VerifyPhone", header: auth, body: good, status: grpcUnimplemented, message: "unknown method /emailverification.v1.Verifier/VerifyPhone"},
+		{name: "gzip", method: "VerifyEmail", header: http.Header{"Authorization": {"Bearer secret"}, "Grpc-Encoding": {"gzip"}}, body: good, status: grpcUnimplemented, message: `compression "gzip"`},
+		{name: "compressed message", method: "VerifyEmail", header: auth, body: append([]byte{1}, good[1:]...), status: grpcUnimplemented},
+		{name: "no email", method: "VerifyEmail", header: auth, body: grpcFrame(nil), status: grpcInvalidArgument, message: "email is required"},
+		{name: "no message", method: "VerifyEmail", header: auth, status: grpcInvalidArgument, message: "failed to read request: EOF"},
+		{name: "truncated", method: "VerifyEmail", header: auth, body: good[:len(good)-3], status: grpcInvalidArgument, message: "truncated message"},
+		{name: "too large", method: "VerifyEmail", header: auth, body: []byte{0, 0xff, 0xff, 0xff, 0xff}, status: grpcResourceExhausted},
+		{name: "invalid protobuf", method: "VerifyEmail", header: auth, body: grpcFrame([]byte{0x0a, 0x7f}), status: grpcInvalidArgument, message: "invalid length of field 1"},
+		{name: "rate below limit", method: "VerifyEmail", header: auth, body: grpcFrame(emailRequest("good@acme.test", appendProtoString(nil, 2, "1ms"))), status: grpcInvalidArgument, message: "rate must be"},
+		{name: "batch too large", method: "VerifyBatch", header: auth, body: grpcFrame(appendProtoString(appendProtoString(appendProtoString(nil, 1, "a@acme.test"), 1, "b@acme.test"), 1, "c@acme.test")), status: grpcInvalidArgument, message: "exceeds the limit of 2; use VerifyStream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := grpcCall(t, client, url+tt.method, tt.header, tt.body)
+			if reply.status != tt.status || !strings.Contains(reply.message, tt.message) {
+				t.Errorf("status %d %q, want %d %q", reply.status, reply.message, tt.status, tt.message)
+			}
+			if len(reply.messages) != 0 {
+				t.Errorf("failed call sent %d messages", len(reply.messages))
+			}
+		})
+	}
+
+	// Requests that aren't gRPC get a plain HTTP error
+	resp, err := client.Get(url + "VerifyEmail")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("GET answered with HTTP %d", resp.StatusCode)
+	}
+}
+
+func TestGRPCPercentEncode(t *testing.T) {
+	// The example of the gRPC HTTP/2 protocol spec, and the escapes it requires
+	if got := grpcPercentEncode("rate must be 100% ≥ 10ms\n"); got != "rate must be 100%25 %E2%89%A5 10ms%0A" {
+		t.Errorf("encoded to %q", got)
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	var message []byte
+	message = appendProtoString(message, 1, "jane@acme.com")
+	message = appendProtoString(message, 2, "")
+	message = appendProtoBool(message, 3, true)
+	message = appendProtoBool(message, 4, false)
+	message = appendProtoInt64(message, 5, 300)
+	message = appendProtoFixed64(message, 8, 42)
+	message = appendProtoMessage(message, 9, nil)
+	// 0a 0d "jane@acme.com", 18 01, 28 ac 02, 41 and eight bytes, 4a 00
+	want := append(append([]byte{0x0a, 0x0d}, "jane@acme.com"...), 0x18, 0x01, 0x28, 0xac, 0x02, 0x41, 42, 0, 0, 0, 0, 0, 0, 0, 0x4a, 0x00)
+	if !bytes.Equal(message, want) {
+		t.Fatalf("encoded % x\nwant % x", message, want)
+	}
+
+	var fields []int
+	if err := decodeProto(message, func(field int, varint uint64, value []byte) error {
+		fields = append(fields, field)
+		if field == 5 && varint != 300 {
+			t.Errorf("field 5 = %d", varint)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// The fixed64 field is skipped
+	if len(fields) != 4 || fields[0] != 1 || fields[1] != 3 || fields[2] != 5 || fields[3] != 9 {
+		t.Errorf("decoded fields %v", fields)
+	}
+	for _, bad := range [][]byte{{0x80}, {0x08}, {0x0a, 0x05, 'a'}, {0x41, 1, 2}, {0x0b}} {
+		if err := decodeProto(bad, func(int, uint64, []byte) error { return nil }); err == nil {
+			t.Errorf("decoded % x", bad)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package verify
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// hibpPrefixLength is the number of SHA-1 hex characters sent to the range API
+const hibpPrefixLength = 6
+
+// BreachChecker queries a Have I Been Pwned style k-anonymity range API.
+// Only a short prefix of the address hash leaves the process; matching is done locally.
+type BreachChecker struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+	limiter *intervalLimiter
+}
+
+// hibpRangeMatch is a single entry in a range API response
+type hibpRangeMatch struct {
+	HashSuffix string   `json:"hashSuffix"`
+	Websites   []string `json:"websites"`
+}
+
+func newBreachChecker(baseURL, apiKey string, minInterval time.Duration, resolver *net.Resolver) *BreachChecker {
+	return &BreachChecker{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		apiKey:  apiKey,
+		client:  resolvingClient(resolver, 15*time.Second),
+		limiter: newIntervalLimiter(minInterval),
+	}
+}
+
+// Breaches returns the names of known breaches containing the address, or an empty slice if none
+func (c *BreachChecker) Breaches(email string) ([]string, error) {
+	sum := sha1.Sum([]byte(strings.ToLower(strings.TrimSpace(email))))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:hibpPrefixLength], hash[hibpPrefixLength:]
+
+	c.limiter.Wait()
+
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/breachedaccount/range/"+prefix, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build breach request: %w", err)
+	}
+	req.Header.Set("hibp-api-key", c.apiKey)
+	req.Header.Set("User-Agent", "email-verification")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("breach request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 404 means no breached account shares this prefix
+	if resp.StatusCode == http.StatusNotFound {
+		return []string{}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("breach range lookup returned %s", resp.Status)
+	}
+
+	var matches []hibpRangeMatch
+	if err := json.NewDecoder(resp.Body).Decode(&matches); err != nil {
+		return nil, fmt.Errorf("failed to decode breach response: %w", err)
+	}
+
+	for _, match := range matches {
+		if strings.EqualFold(match.HashSuffix, suffix) {
+			return match.Websites, nil
+		}
+	}
+
+	return []string{}, nil
+}
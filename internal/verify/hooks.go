@@ -0,0 +1,156 @@
+package verify
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// InputHook transforms an input record before verification.
+// Returning an empty address drops the record.
+type InputHook interface {
+	TransformInput(email string) (string, error)
+}
+
+// ResultHook transforms a result before it is written
+type ResultHook interface {
+	TransformResult(result EmailResult) (EmailResult, error)
+}
+
+// ResultSink receives every final result, e.g. to forward it to another system
+type ResultSink interface {
+	Write(result EmailResult) error
+}
+
+// resultRow flattens a result into the columns the table and index sinks share
+func resultRow(result EmailResult) map[string]any {
+	row := map[string]any{
+		"email":      result.Email,
+		"domain":     emailDomain(result.Email),
+		"valid":      result.IsValid,
+		"risky":      result.Risky,
+		"reason":     result.Reason,
+		"country":    result.Country,
+		"greylisted": result.Greylisted,
+		"catch_all":  result.CatchAllDomain,
+		"deferred":   result.Deferred,
+		"policy":     result.Policy,
+		"probed_as":  result.ProbedAs,
+		"checked_at": result.CheckedAt.Format(time.RFC3339Nano),
+	}
+	if result.raw != nil {
+		row["reachable"] = result.raw.Reachable
+		row["disposable"] = result.raw.Disposable
+		row["role_account"] = result.raw.RoleAccount
+		row["free"] = result.raw.Free
+	}
+	if result.Confidence != 0 {
+		row["confidence"] = result.Confidence
+	}
+	if result.ExpiresAt != nil {
+		row["expires_at"] = result.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	return row
+}
+
+// extensionHook calls the "transform_input", "transform_result" and "sink"
+// methods of an external process or WASM module
+type extensionHook struct {
+	name string
+	ext  extension
+}
+
+// inputRecord is the parameter and result object of the "transform_input" method
+type inputRecord struct {
+	Email string `json:"email"`
+}
+
+// newHook loads a hook from an exec: or wasm: spec, or starts a plain command line
+func newHook(spec string) (*extensionHook, error) {
+	if isExtensionSpec(spec) {
+		ext, err := newExtension(spec)
+		if err != nil {
+			return nil, err
+		}
+		return &extensionHook{name: extensionName(spec), ext: ext}, nil
+	}
+
+	args := strings.Fields(spec)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("empty hook command")
+	}
+	process, err := startRPCProcess(args[0], args[1:]...)
+	if err != nil {
+		return nil, err
+	}
+	return &extensionHook{name: args[0], ext: process}, nil
+}
+
+// newSinks loads the result sinks in a comma-separated list of exec: or wasm: specs
+func newSinks(spec string) ([]ResultSink, error) {
+	var sinks []ResultSink
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		hook, err := newHook(entry)
+		if err != nil {
+			closeSinks(sinks)
+			return nil, err
+		}
+		sinks = append(sinks, hook)
+	}
+	return sinks, nil
+}
+
+// flushSinks sends what sinks that batch results hold on to, once a run is done
+func flushSinks(sinks []ResultSink) {
+	for _, sink := range sinks {
+		if flusher, ok := sink.(interface{ Flush() error }); ok {
+			if err := flusher.Flush(); err != nil {
+				slog.Warn("failed to flush result sink", "error", err)
+			}
+		}
+	}
+}
+
+// closeSinks releases resources held by sinks that need it
+func closeSinks(sinks []ResultSink) {
+	for _, sink := range sinks {
+		if closer, ok := sink.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+func (h *extensionHook) TransformInput(email string) (string, error) {
+	record := inputRecord{Email: email}
+	if err := h.ext.Call("transform_input", inputRecord{Email: email}, &record); err != nil {
+		return email, err
+	}
+	return strings.TrimSpace(record.Email), nil
+}
+
+func (h *extensionHook) TransformResult(result EmailResult) (EmailResult, error) {
+	transformed := result
+	if err := h.ext.Call("transform_result", result, &transformed); err != nil {
+		return result, err
+	}
+	return transformed, nil
+}
+
+func (h *extensionHook) Write(result EmailResult) error {
+	var ignored any
+	if err := h.ext.Call("sink", result, &ignored); err != nil {
+		return fmt.Errorf("sink %s: %w", h.name, err)
+	}
+	return nil
+}
+
+// Close unloads the hook
+func (h *extensionHook) Close() error {
+	return h.ext.Close()
+}
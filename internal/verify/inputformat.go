@@ -0,0 +1,109 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// Input formats
+const (
+	inputAuto  = "auto"
+	inputJSON  = "json"
+	inputJSONL = "jsonl"
+	inputCSV   = "csv"
+	inputTSV   = "tsv"
+	inputText  = "txt"
+	// Contacts, whose addresses are verified and reported together
+	inputVCard   = "vcf"
+	inputOutlook = "outlook"
+)
+
+// validInputFormat reports whether format is a known input format
+func validInputFormat(format string) bool {
+	switch format {
+	case inputAuto, inputJSON, inputJSONL, inputCSV, inputTSV, inputText, inputVCard, inputOutlook:
+		return true
+	}
+	return false
+}
+
+// inputFormatFor resolves the auto format from the file's extension, defaulting to JSON.
+// Stdin has no extension; its format is sniffed from the content instead.
+func inputFormatFor(filename, format string) string {
+	if format != inputAuto || filename == StdinInput {
+		return format
+	}
+	if isJSONLines(filename) {
+		return inputJSONL
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return inputCSV
+	case ".tsv":
+		return inputTSV
+	case ".txt":
+		return inputText
+	case ".vcf", ".vcard":
+		return inputVCard
+	}
+	return inputJSON
+}
+
+// sniffInputFormat tells a JSON document, JSON Lines and a plain list of addresses apart by how
+// they start, dropping a byte-order mark
+func sniffInputFormat(r *bufio.Reader) string {
+	if bom, _ := r.Peek(3); string(bom) == "\ufeff" {
+		r.Discard(3)
+	}
+	for i := 1; ; i++ {
+		peek, err := r.Peek(i)
+		if len(peek) < i {
+			return inputText
+		}
+		switch c := peek[i-1]; {
+		case c == '"':
+			return inputJSONL
+		case c == '{':
+			// A document opens with its "emails" key; a line holds a single entry
+			head, _ := r.Peek(64 * 1024)
+			decoder := json.NewDecoder(bytes.NewReader(head[i-1:]))
+			decoder.Token()
+			if key, _ := decoder.Token(); key == "emails" {
+				return inputJSON
+			}
+			return inputJSONL
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+		default:
+			return inputText
+		}
+		if err != nil {
+			return inputText
+		}
+	}
+}
+
+// decodeTextInput calls each for every non-blank line of a plain list of addresses
+func decodeTextInput(r io.Reader, each func(InputRecord)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	first := true
+	for scanner.Scan() {
+		line := scanner.Text()
+		if first {
+			line = strings.TrimPrefix(line, "\ufeff")
+			first = false
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			each(InputRecord{Email: line})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read text input: %w", err)
+	}
+	return nil
+}
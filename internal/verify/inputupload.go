@@ -0,0 +1,209 @@
+package verify
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// incomingDir holds input files uploaded to the server in chunks, until a job is submitted from them
+var incomingDir = filepath.Join(dataDir, "incoming")
+
+// Chunked upload errors
+var (
+	errUploadNotFound   = errors.New("upload not found")
+	errUploadOffset     = errors.New("chunk does not start at the upload's offset")
+	errUploadTooLong    = errors.New("chunk runs past the declared upload length")
+	errUploadIncomplete = errors.New("upload is incomplete")
+)
+
+// InputUpload is the progress of a chunked upload of an input file. The offset is the size of
+// what arrived so far, so an upload interrupted mid-chunk resumes right after the last byte received.
+type InputUpload struct {
+	ID        string    `json:"id"`
+	Offset    int64     `json:"offset"`
+	Length    int64     `json:"length"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Complete reports whether every declared byte has arrived
+func (u InputUpload) Complete() bool {
+	return u.Offset == u.Length
+}
+
+// InputUploads keeps chunked uploads on disk so they survive a restart of the server. Uploads
+// nobody touched for the retention period are removed.
+type InputUploads struct {
+	retention time.Duration
+
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+func newInputUploads(retention time.Duration) (*InputUploads, error) {
+	if err := os.MkdirAll(incomingDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create incoming directory: %w", err)
+	}
+	u := &InputUploads{retention: retention, locks: make(map[string]*sync.Mutex)}
+	if retention > 0 {
+		go u.expire(min(max(retention/10, time.Second), time.Minute))
+	}
+	return u, nil
+}
+
+// Create starts an upload of length bytes
+func (u *InputUploads) Create(length int64) (InputUpload, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return InputUpload{}, err
+	}
+	upload := InputUpload{ID: hex.EncodeToString(id), Length: length}
+
+	meta, _ := json.Marshal(upload)
+	if err := os.WriteFile(u.metaPath(upload.ID), meta, 0644); err != nil {
+		return InputUpload{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+	if err := os.WriteFile(u.dataPath(upload.ID), nil, 0644); err != nil {
+		return InputUpload{}, fmt.Errorf("failed to create upload: %w", err)
+	}
+	return u.Get(upload.ID)
+}
+
+// Get returns the progress of an upload
+func (u *InputUploads) Get(id string) (InputUpload, error) {
+	if !validUploadID(id) {
+		return InputUpload{}, errUploadNotFound
+	}
+	meta, err := os.ReadFile(u.metaPath(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return InputUpload{}, errUploadNotFound
+	}
+	if err != nil {
+		return InputUpload{}, err
+	}
+	var upload InputUpload
+	if err := json.Unmarshal(meta, &upload); err != nil {
+		return InputUpload{}, fmt.Errorf("failed to read upload %s: %w", id, err)
+	}
+	info, err := os.Stat(u.dataPath(id))
+	if err != nil {
+		return InputUpload{}, errUploadNotFound
+	}
+	upload.Offset = info.Size()
+	upload.UpdatedAt = info.ModTime()
+	return upload, nil
+}
+
+// Append writes a chunk starting at offset, which must be where the upload stands. Whatever part
+// of the chunk arrives is kept, even if the connection drops before the end.
+func (u *InputUploads) Append(id string, offset int64, chunk io.Reader) (InputUpload, error) {
+	lock := u.lock(id)
+	lock.Lock()
+	defer lock.Unlock()
+
+	upload, err := u.Get(id)
+	if err != nil {
+		return upload, err
+	}
+	if offset != upload.Offset {
+		return upload, errUploadOffset
+	}
+
+	file, err := os.OpenFile(u.dataPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return upload, fmt.Errorf("failed to open upload %s: %w", id, err)
+	}
+	_, copyErr := io.Copy(file, io.LimitReader(chunk, upload.Length-upload.Offset))
+	if err := file.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+	if upload, err = u.Get(id); err != nil {
+		return upload, err
+	}
+	if copyErr != nil {
+		return upload, fmt.Errorf("failed to write chunk: %w", copyErr)
+	}
+	if upload.Complete() {
+		if n, _ := chunk.Read(make([]byte, 1)); n > 0 {
+			return upload, errUploadTooLong
+		}
+	}
+	return upload, nil
+}
+
+// Open returns the file of a complete upload
+func (u *InputUploads) Open(id string) (*os.File, InputUpload, error) {
+	upload, err := u.Get(id)
+	if err != nil {
+		return nil, upload, err
+	}
+	if !upload.Complete() {
+		return nil, upload, fmt.Errorf("%w: %d of %d bytes received", errUploadIncomplete, upload.Offset, upload.Length)
+	}
+	file, err := os.Open(u.dataPath(id))
+	if err != nil {
+		return nil, upload, fmt.Errorf("failed to open upload %s: %w", id, err)
+	}
+	return file, upload, nil
+}
+
+// Remove deletes an upload
+func (u *InputUploads) Remove(id string) error {
+	if _, err := u.Get(id); err != nil {
+		return err
+	}
+	os.Remove(u.dataPath(id))
+	os.Remove(u.metaPath(id))
+
+	u.mu.Lock()
+	delete(u.locks, id)
+	u.mu.Unlock()
+	return nil
+}
+
+func (u *InputUploads) lock(id string) *sync.Mutex {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	lock, ok := u.locks[id]
+	if !ok {
+		lock = &sync.Mutex{}
+		u.locks[id] = lock
+	}
+	return lock
+}
+
+// expire periodically removes uploads that haven't received a chunk for the retention period
+func (u *InputUploads) expire(interval time.Duration) {
+	for range time.Tick(interval) {
+		paths, _ := filepath.Glob(filepath.Join(incomingDir, "*.json"))
+		for _, path := range paths {
+			id := strings.TrimSuffix(filepath.Base(path), ".json")
+			upload, err := u.Get(id)
+			if err == nil && time.Since(upload.UpdatedAt) > u.retention {
+				u.Remove(id)
+				slog.Info("expired upload", "upload", id, "received_bytes", upload.Offset, "bytes", upload.Length)
+			}
+		}
+	}
+}
+
+func (u *InputUploads) dataPath(id string) string { return filepath.Join(incomingDir, id+".part") }
+func (u *InputUploads) metaPath(id string) string { return filepath.Join(incomingDir, id+".json") }
+
+// validUploadID reports whether id looks like an upload ID, so it can't reach outside incomingDir
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
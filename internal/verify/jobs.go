@@ -0,0 +1,478 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Job states
+const (
+	jobQueued  = "queued"
+	jobRunning = "running"
+	jobDone    = "done"
+	jobFailed  = "failed"
+)
+
+// errQueueFull is returned when the server already has the maximum number of jobs waiting
+var errQueueFull = errors.New("job queue is full")
+
+// errJobNotFinished is returned when deleting a job that is still queued or running
+var errJobNotFinished = errors.New("job has not finished")
+
+// JobStatus is the progress of a batch job as reported by the server
+type JobStatus struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Checked    int64      `json:"checked"`
+	Valid      int64      `json:"valid"`
+	Invalid    int64      `json:"invalid"`
+	Risky      int64      `json:"risky"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	Options    JobOptions `json:"options"`
+
+	Utilization *UtilizationReport `json:"utilization,omitempty"`
+}
+
+// Finished reports whether the job has reached a terminal state
+func (s JobStatus) Finished() bool {
+	return s.Status == jobDone || s.Status == jobFailed
+}
+
+// JobOptions are the concurrency, rate and verification settings a job runs with
+type JobOptions struct {
+	Workers   int           `json:"workers"`
+	RateLimit time.Duration `json:"-"`
+	Rate      string        `json:"rate"`
+	SMTP      bool          `json:"smtp"`
+	Level     string        `json:"level,omitempty"`
+}
+
+// level is the verification level the options ask for. Those of coordinators predating levels
+// only tell whether to probe SMTP.
+func (o JobOptions) level() string {
+	switch {
+	case o.Level != "":
+		return o.Level
+	case o.SMTP:
+		return levelSMTP
+	}
+	return levelDNS
+}
+
+// JobLimits bound the options a job may ask for
+type JobLimits struct {
+	MaxWorkers int
+	MinRate    time.Duration
+}
+
+// parseJobOptions applies a submission's workers, rate, smtp and level overrides to the server's
+// settings. Overrides beyond the limits, or a level deeper than the server's, are rejected.
+func parseJobOptions(query url.Values, config Config, limits JobLimits) (JobOptions, error) {
+	opts := JobOptions{
+		Workers:   min(config.Workers, limits.MaxWorkers),
+		RateLimit: max(config.RateLimit, limits.MinRate),
+		Level:     config.Level,
+	}
+	if v := query.Get("workers"); v != "" {
+		workers, err := strconv.Atoi(v)
+		if err != nil || workers < 1 || workers > limits.MaxWorkers {
+			return JobOptions{}, fmt.Errorf("workers must be between 1 and %d", limits.MaxWorkers)
+		}
+		opts.Workers = workers
+	}
+	if v := query.Get("rate"); v != "" {
+		rate, err := time.ParseDuration(v)
+		if err != nil || rate < limits.MinRate {
+			return JobOptions{}, fmt.Errorf("rate must be a duration of at least %v", limits.MinRate)
+		}
+		opts.RateLimit = rate
+	}
+	if v := query.Get("smtp"); v != "" {
+		smtp, err := strconv.ParseBool(v)
+		if err != nil {
+			return JobOptions{}, fmt.Errorf("smtp must be true or false")
+		}
+		if smtp && !config.EnableSMTP {
+			return JobOptions{}, fmt.Errorf("SMTP verification is disabled on this server")
+		}
+		if !smtp && opts.Level == levelSMTP {
+			opts.Level = levelDNS
+		}
+	}
+	if v := query.Get("level"); v != "" {
+		if levelDepth(v) < 0 {
+			return JobOptions{}, fmt.Errorf("level must be %s, %s or %s", levelSyntax, levelDNS, levelSMTP)
+		}
+		if levelDepth(v) > levelDepth(config.Level) {
+			return JobOptions{}, fmt.Errorf("level %s is deeper than this server's %s", v, config.Level)
+		}
+		opts.Level = v
+	}
+	opts.SMTP = opts.Level == levelSMTP
+	opts.Rate = opts.RateLimit.String()
+	return opts, nil
+}
+
+// job is a batch verification submitted to the server
+type job struct {
+	id        string
+	emails    []string
+	options   JobOptions
+	stats     *Stats
+	createdAt time.Time
+
+	mu         sync.Mutex
+	status     string
+	total      int
+	err        string
+	results    []byte
+	finishedAt *time.Time
+	expiresAt  *time.Time
+}
+
+// expired reports whether a finished job's retention period is over
+func (j *job) expired(now time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.expiresAt != nil && !now.Before(*j.expiresAt)
+}
+
+// JobManager runs submitted jobs one at a time, sharing lookups and their caches across jobs,
+// or hands them to workers in distributed mode. Finished jobs and their results are dropped
+// once the retention period is over.
+type JobManager struct {
+	config    Config
+	lookups   *Lookups
+	queue     chan *job
+	retention time.Duration
+	limits    AdmissionLimits
+	work      *WorkQueue  // set when workers verify the jobs
+	feed      *ResultFeed // the latest results, for the connector endpoints
+
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+func newJobManager(config Config, lookups *Lookups, queueSize int, retention time.Duration, limits AdmissionLimits) *JobManager {
+	m := &JobManager{
+		config:    config,
+		lookups:   lookups,
+		queue:     make(chan *job, queueSize),
+		retention: retention,
+		limits:    limits,
+		jobs:      make(map[string]*job),
+	}
+	go m.run()
+	if retention > 0 {
+		go m.expire(min(max(retention/10, time.Second), time.Minute))
+	}
+	return m
+}
+
+// Submit queues a job for the given addresses, run with the given options, if the server has room for it
+func (m *JobManager) Submit(emails []string, options JobOptions) (JobStatus, error) {
+	if err := m.Admit(len(emails)); err != nil {
+		return JobStatus{}, err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return JobStatus{}, err
+	}
+
+	j := &job{
+		id:        hex.EncodeToString(id),
+		emails:    emails,
+		options:   options,
+		stats:     &Stats{},
+		createdAt: time.Now(),
+		status:    jobQueued,
+		total:     len(emails),
+	}
+
+	m.mu.Lock()
+	m.jobs[j.id] = j
+	m.mu.Unlock()
+
+	select {
+	case m.queue <- j:
+	default:
+		m.mu.Lock()
+		delete(m.jobs, j.id)
+		m.mu.Unlock()
+		return JobStatus{}, &admissionError{Err: errQueueFull, RetryAfter: m.retryAfter(-1)}
+	}
+
+	return j.snapshot(), nil
+}
+
+// Status returns the progress of a job
+func (m *JobManager) Status(id string) (JobStatus, bool) {
+	j, ok := m.get(id)
+	if !ok {
+		return JobStatus{}, false
+	}
+	return j.snapshot(), true
+}
+
+// Results returns the output document of a finished job
+func (m *JobManager) Results(id string) ([]byte, JobStatus, bool) {
+	j, ok := m.get(id)
+	if !ok {
+		return nil, JobStatus{}, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.results, j.snapshotLocked(), true
+}
+
+// Delete drops a finished job and its results before its retention period is over
+func (m *JobManager) Delete(id string) (bool, error) {
+	j, ok := m.get(id)
+	if !ok {
+		return false, nil
+	}
+	if !j.snapshot().Finished() {
+		return true, errJobNotFinished
+	}
+	m.mu.Lock()
+	delete(m.jobs, id)
+	m.mu.Unlock()
+	return true, nil
+}
+
+// snapshots returns the status of every job kept
+func (m *JobManager) snapshots() []JobStatus {
+	m.mu.Lock()
+	kept := make([]*job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		kept = append(kept, j)
+	}
+	m.mu.Unlock()
+	statuses := make([]JobStatus, 0, len(kept))
+	now := time.Now()
+	for _, j := range kept {
+		if !j.expired(now) {
+			statuses = append(statuses, j.snapshot())
+		}
+	}
+	return statuses
+}
+
+// statuses returns the state of every job kept
+func (m *JobManager) statuses() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	statuses := make([]string, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		j.mu.Lock()
+		statuses = append(statuses, j.status)
+		j.mu.Unlock()
+	}
+	return statuses
+}
+
+func (m *JobManager) get(id string) (*job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if ok && j.expired(time.Now()) {
+		return nil, false
+	}
+	return j, ok
+}
+
+// expire periodically drops jobs whose retention period is over
+func (m *JobManager) expire(interval time.Duration) {
+	for range time.Tick(interval) {
+		now := time.Now()
+		m.mu.Lock()
+		expired := 0
+		for id, j := range m.jobs {
+			if j.expired(now) {
+				delete(m.jobs, id)
+				expired++
+			}
+		}
+		m.mu.Unlock()
+		if expired > 0 {
+			slog.Info("expired finished jobs", "jobs", expired)
+		}
+	}
+}
+
+func (m *JobManager) run() {
+	for j := range m.queue {
+		m.process(j)
+	}
+}
+
+func (m *JobManager) process(j *job) {
+	emails := j.emails
+	if m.lookups.InputHook != nil {
+		emails = applyInputHook(m.lookups.InputHook, emails, m.config.Verbose)
+	}
+
+	emails, duplicates := dedupeEmails(emails, m.config.Dedupe)
+
+	j.mu.Lock()
+	j.status = jobRunning
+	j.total = len(emails)
+	j.stats.Duplicates = int64(duplicates)
+	j.emails = nil
+	j.stats.StartTime = time.Now()
+	if m.work == nil {
+		j.stats.Usage = newUtilization(j.options.Workers)
+	}
+	j.mu.Unlock()
+
+	config := m.config
+	config.Workers = j.options.Workers
+	config.RateLimit = j.options.RateLimit
+	config.Level = j.options.level()
+	config.EnableSMTP = config.Level == levelSMTP
+
+	slog.Info("starting job", "job", j.id, "emails", len(emails),
+		"workers", config.Workers, "rate_limit", config.RateLimit, "level", config.Level)
+	var invalidEmails []InvalidEmail
+	var err error
+	if m.work != nil {
+		// Sorting applies across all units, whichever order workers finished them in
+		if invalidEmails, err = m.work.Run(j, emails); err == nil {
+			sortOutput(invalidEmails, nil, config)
+		}
+	} else {
+		if m.feed != nil {
+			config.onResult = func(result EmailResult) { m.feed.Add(result, j.id) }
+		}
+		invalidEmails, _, _, _, err = processEmails(context.Background(), emails, config, m.lookups, j.stats, nil, nil)
+	}
+
+	// Render the output once so downloads report the run's own processing time
+	var buf bytes.Buffer
+	if err == nil {
+		err = encodeResults(&buf, invalidEmails, j.stats, m.config.outputFormat())
+	}
+
+	// Learned patterns reach clients through the /domains endpoints
+	if m.lookups.Patterns != nil {
+		m.lookups.Patterns.Persist()
+	}
+	if m.lookups.Domains != nil {
+		if err := m.lookups.Domains.Save(); err != nil {
+			slog.Warn("failed to save domain store", "error", err)
+		}
+	}
+
+	now := time.Now()
+	j.mu.Lock()
+	j.finishedAt = &now
+	if m.retention > 0 {
+		expiresAt := now.Add(m.retention)
+		j.expiresAt = &expiresAt
+	}
+	if err != nil {
+		j.status = jobFailed
+		j.err = err.Error()
+	} else {
+		j.status = jobDone
+		j.results = buf.Bytes()
+	}
+	j.mu.Unlock()
+
+	slog.Info("finished job", "job", j.id, "checked", j.stats.TotalChecked, "invalid", j.stats.TotalInvalid,
+		"elapsed", time.Since(j.stats.StartTime).Round(time.Second))
+}
+
+// Verify checks addresses right away with the server's settings and the given options, alongside
+// any running job, and returns their results in input order once all are checked. There is no
+// greylisting second pass, which would hold the request for minutes.
+func (m *JobManager) Verify(ctx context.Context, emails []string, options JobOptions) ([]EmailResult, *Stats) {
+	results, stats := m.verify(ctx, emails, options)
+	for _, result := range results {
+		m.feed.Add(result, "")
+	}
+	return results, stats
+}
+
+// verify is Verify without adding the results to the feed
+func (m *JobManager) verify(ctx context.Context, emails []string, options JobOptions) ([]EmailResult, *Stats) {
+	config := m.config
+	config.Workers = options.Workers
+	config.RateLimit = options.RateLimit
+	config.Level = options.level()
+	config.EnableSMTP = config.Level == levelSMTP
+	config.GreylistRetry = 0
+	return verifyList(ctx, emails, config, m.lookups)
+}
+
+// verifyList verifies the emails without files, returning their results in input order
+func verifyList(ctx context.Context, emails []string, config Config, lookups *Lookups) ([]EmailResult, *Stats) {
+	if lookups.InputHook != nil {
+		emails = applyInputHook(lookups.InputHook, emails, config.Verbose)
+	}
+
+	config.Workers = max(min(config.Workers, len(emails)), 1)
+	// Results are returned or streamed rather than written to files
+	config.DetailsFile, config.ValidFile, config.FreeFile, config.OutputTemplate = "", "", "", ""
+	config.SplitRecords = false
+	config.WarehouseStage = ""
+	config.SortBy, config.GroupBy = "", ""
+	config.keepResults = config.onResult == nil
+
+	// Without an output or checkpoint there is nothing that can fail to be written
+	stats := &Stats{StartTime: time.Now()}
+	_, results, _, _, _ := processEmails(ctx, emails, config, lookups, stats, nil, nil)
+	if results == nil {
+		results = []EmailResult{}
+	}
+	slices.SortFunc(results, func(a, b EmailResult) int {
+		return a.index - b.index
+	})
+	return results, stats
+}
+
+func (j *job) snapshot() JobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshotLocked()
+}
+
+func (j *job) snapshotLocked() JobStatus {
+	var utilization *UtilizationReport
+	if j.stats.Usage != nil {
+		utilization = j.stats.Usage.Report()
+	}
+
+	return JobStatus{
+		ID:         j.id,
+		Status:     j.status,
+		Total:      j.total,
+		Checked:    atomic.LoadInt64(&j.stats.TotalChecked),
+		Valid:      atomic.LoadInt64(&j.stats.TotalValid),
+		Invalid:    atomic.LoadInt64(&j.stats.TotalInvalid),
+		Risky:      atomic.LoadInt64(&j.stats.TotalRisky),
+		Error:      j.err,
+		CreatedAt:  j.createdAt,
+		FinishedAt: j.finishedAt,
+		ExpiresAt:  j.expiresAt,
+		Options:    j.options,
+
+		Utilization: utilization,
+	}
+}
@@ -0,0 +1,72 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isJSONLines reports whether a file holds JSON Lines, going by its .jsonl or .ndjson extension
+func isJSONLines(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".jsonl", ".ndjson":
+		return true
+	}
+	return false
+}
+
+// decodeJSONLines calls each for every line of a JSON Lines input, each an entry as in the
+// "emails" array: an address string or an {"id", "email"} object
+func decodeJSONLines(r io.Reader, each func(InputRecord)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		data := scanner.Bytes()
+		if line == 1 {
+			data = bytes.TrimPrefix(data, []byte("\ufeff"))
+		}
+		if len(bytes.TrimSpace(data)) == 0 {
+			continue
+		}
+		var record InputRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("invalid entry on line %d: %w", line, err)
+		}
+		each(record)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read JSON Lines: %w", err)
+	}
+	return nil
+}
+
+// writeNDJSON writes one entry per line, for jq, line-oriented tools and bulk loaders
+func writeNDJSON[T any](w io.Writer, entries []T) error {
+	writer := bufio.NewWriter(w)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range entries {
+		if err := encoder.Encode(entry); err != nil {
+			return fmt.Errorf("failed to write result: %w", err)
+		}
+	}
+	return writer.Flush()
+}
+
+// writeJSONLinesFile writes entries to a JSON Lines file
+func writeJSONLinesFile[T any](filename string, entries []T) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if err := writeNDJSON(file, entries); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,163 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// jsonStream writes a JSON document incrementally without holding it in memory. Every key and value
+// goes through encoding/json, so arbitrary input (quotes, control characters, invalid UTF-8) can't
+// break the document, and separators and layout follow the nesting. The first error sticks: later
+// writes are skipped and Close reports it.
+type jsonStream struct {
+	w        *bufio.Writer
+	format   OutputFormat
+	open     []streamContainer
+	afterKey bool
+	err      error
+}
+
+// streamContainer is an open object or array. Block containers put each member on its own line;
+// inline ones keep members on the line they started on.
+type streamContainer struct {
+	closer  string
+	inline  bool
+	members int
+}
+
+func newJSONStream(w io.Writer, format OutputFormat) *jsonStream {
+	return &jsonStream{w: bufio.NewWriterSize(w, 1024*1024), format: format} // 1MB buffer
+}
+
+func (s *jsonStream) write(str string) {
+	if s.err == nil {
+		_, s.err = s.w.WriteString(str)
+	}
+}
+
+// member starts the next member of the innermost container, unless a key already did
+func (s *jsonStream) member() {
+	if s.afterKey {
+		s.afterKey = false
+		return
+	}
+	if len(s.open) == 0 {
+		return
+	}
+	c := &s.open[len(s.open)-1]
+	if c.members > 0 {
+		s.write(",")
+	}
+	switch {
+	case !c.inline:
+		s.write(s.format.newline() + s.format.pad(s.depth()))
+	case c.members > 0 && !s.format.Compact:
+		s.write(" ")
+	}
+	c.members++
+}
+
+func (s *jsonStream) begin(opener, closer string, inline bool) {
+	s.member()
+	s.write(opener)
+	s.open = append(s.open, streamContainer{closer: closer, inline: inline})
+}
+
+// BeginObject opens an object as the next value
+func (s *jsonStream) BeginObject(inline bool) { s.begin("{", "}", inline) }
+
+// BeginArray opens an array as the next value
+func (s *jsonStream) BeginArray(inline bool) { s.begin("[", "]", inline) }
+
+// End closes the innermost object or array
+func (s *jsonStream) End() {
+	if len(s.open) == 0 {
+		s.fail(fmt.Errorf("unbalanced end of JSON container"))
+		return
+	}
+	c := s.open[len(s.open)-1]
+	s.open = s.open[:len(s.open)-1]
+	if !c.inline {
+		s.write(s.format.newline() + s.format.pad(s.depth()))
+	}
+	s.write(c.closer)
+}
+
+// depth is the indentation level of the open containers; inline ones don't add a level
+func (s *jsonStream) depth() int {
+	depth := 0
+	for _, c := range s.open {
+		if !c.inline {
+			depth++
+		}
+	}
+	return depth
+}
+
+// Key writes an object key; the next call writes its value
+func (s *jsonStream) Key(name string) {
+	s.member()
+	s.encode(name)
+	s.write(":")
+	if !s.format.Compact {
+		s.write(" ")
+	}
+	s.afterKey = true
+}
+
+// Value writes a value, compactly on one line whatever the layout
+func (s *jsonStream) Value(v any) {
+	s.member()
+	s.encode(v)
+}
+
+// Field writes a key and its value
+func (s *jsonStream) Field(name string, v any) {
+	s.Key(name)
+	s.Value(v)
+}
+
+func (s *jsonStream) encode(v any) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.fail(fmt.Errorf("failed to marshal %T: %w", v, err))
+		return
+	}
+	_, s.err = s.w.Write(data)
+}
+
+func (s *jsonStream) fail(err error) {
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// Flush pushes what has been written so far to the underlying writer
+func (s *jsonStream) Flush() error {
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
+
+// Close ends the document with a newline and flushes it, reporting the first error
+func (s *jsonStream) Close() error {
+	if s.err == nil && len(s.open) > 0 {
+		s.err = fmt.Errorf("%d JSON containers left open", len(s.open))
+	}
+	s.write("\n")
+	if s.err != nil {
+		return s.err
+	}
+	return s.w.Flush()
+}
+
+// fixedDecimals renders a number with a fixed number of decimals as a JSON number
+func fixedDecimals(f float64, decimals int) json.Number {
+	return json.Number(fmt.Sprintf("%.*f", decimals, f))
+}
@@ -0,0 +1,171 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"unicode/utf8"
+)
+
+// streamScript reads a document to write from fuzz input: each value starts with a byte choosing
+// a string, a number, an object or an array, and strings and member counts come from the bytes
+// that follow
+type streamScript struct {
+	data []byte
+}
+
+func (r *streamScript) byte() byte {
+	if len(r.data) == 0 {
+		return 0
+	}
+	b := r.data[0]
+	r.data = r.data[1:]
+	return b
+}
+
+func (r *streamScript) string() string {
+	n := min(int(r.byte()%16), len(r.data))
+	s := string(r.data[:n])
+	r.data = r.data[n:]
+	return s
+}
+
+// value writes the next value to s and returns what encoding/json should decode it as
+func (r *streamScript) value(s *jsonStream, depth int) any {
+	op := r.byte()
+	if depth >= 8 {
+		op %= 2 // no deeper containers
+	}
+	inline := op&4 != 0
+	switch op % 4 {
+	case 0:
+		v := r.string()
+		s.Value(v)
+		return v
+	case 1:
+		v := float64(int8(r.byte()))
+		s.Value(v)
+		return v
+	case 2:
+		v := make(map[string]any)
+		s.BeginObject(inline)
+		for n := r.byte() % 4; n > 0; n-- {
+			key := r.string()
+			s.Key(key)
+			v[key] = r.value(s, depth+1)
+		}
+		s.End()
+		return v
+	default:
+		v := make([]any, 0)
+		s.BeginArray(inline)
+		for n := r.byte() % 4; n > 0; n-- {
+			v = append(v, r.value(s, depth+1))
+		}
+		s.End()
+		return v
+	}
+}
+
+// decodeJSON decodes a document generically, as the reference for comparisons
+func decodeJSON(t *testing.T, data []byte) any {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, data)
+	}
+	return v
+}
+
+func FuzzJSONStream(f *testing.F) {
+	f.Add([]byte{2, 2, 3, 'k', 'e', 'y', 0, 2, 'h', 'i', 0, 1, 'n', 1, 42}, 2, false)
+	f.Add([]byte{3, 3, 6, 2, 0, 0, 2, '"', '\\', 7, 1, 1, 0}, 4, false)
+	f.Add([]byte{2, 1, 3, 0xff, 0xfe, '\n', 0, 4, 0xe2, 0x80, 0xa8, '<'}, 0, true)
+	f.Fuzz(func(t *testing.T, script []byte, indent int, compact bool) {
+		format := OutputFormat{Indent: int(uint(indent) % 9), Compact: compact}
+		var buf bytes.Buffer
+		s := newJSONStream(&buf, format)
+		r := &streamScript{data: script}
+		want := r.value(s, 0)
+		if err := s.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		out := buf.Bytes()
+		if !bytes.HasSuffix(out, []byte("\n")) {
+			t.Fatalf("document doesn't end with a newline: %q", out)
+		}
+		if compact && bytes.Count(out, []byte("\n")) != 1 {
+			t.Fatalf("compact document spans several lines: %q", out)
+		}
+		// Invalid UTF-8 is replaced when encoding, so the reference goes through encoding/json too
+		reference, err := json.Marshal(want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := decodeJSON(t, out), decodeJSON(t, reference); !reflect.DeepEqual(got, want) {
+			t.Fatalf("decoded %#v, want %#v\n%s", got, want, out)
+		}
+	})
+}
+
+// FuzzJSONStreamRoundTrip encodes a record the way the result writers do and decodes it again
+func FuzzJSONStreamRoundTrip(f *testing.F) {
+	f.Add("jane@example.com", "email is not deliverable", 0.95, 2, false)
+	f.Add("", "\x00\u2028\"\\</script>", -1.5, 0, true)
+	f.Add("\xff\xfe", "tab\there", math.Inf(1), 4, false)
+	f.Fuzz(func(t *testing.T, email, reason string, confidence float64, indent int, compact bool) {
+		format := OutputFormat{Indent: int(uint(indent) % 9), Compact: compact}
+		var buf bytes.Buffer
+		s := newJSONStream(&buf, format)
+		s.BeginObject(false)
+		s.Field("email", email)
+		s.Key("result")
+		s.BeginObject(true)
+		s.Field("reason", reason)
+		s.Field("confidence", confidence)
+		s.End()
+		s.Key("tags")
+		s.BeginArray(true)
+		s.Value(email)
+		s.Value(reason)
+		s.End()
+		s.End()
+		err := s.Close()
+
+		// encoding/json has no representation for these, and the first error sticks
+		if math.IsNaN(confidence) || math.IsInf(confidence, 0) {
+			if err == nil {
+				t.Fatalf("Close accepted confidence %v", confidence)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		var got struct {
+			Email  string `json:"email"`
+			Result struct {
+				Reason     string  `json:"reason"`
+				Confidence float64 `json:"confidence"`
+			} `json:"result"`
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON: %v\n%s", err, buf.Bytes())
+		}
+		// Invalid UTF-8 is replaced when encoding
+		if utf8.ValidString(email) && utf8.ValidString(reason) && (got.Email != email || got.Result.Reason != reason) {
+			t.Fatalf("decoded %q, %q, want %q, %q", got.Email, got.Result.Reason, email, reason)
+		}
+		if got.Result.Confidence != confidence {
+			t.Fatalf("decoded confidence %v, want %v", got.Result.Confidence, confidence)
+		}
+		if len(got.Tags) != 2 || got.Tags[0] != got.Email || got.Tags[1] != got.Result.Reason {
+			t.Fatalf("decoded tags %q, want the email and reason", got.Tags)
+		}
+	})
+}
@@ -0,0 +1,332 @@
+package verify
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// judgeResult turns the library result for an address into a verdict, applying the built-in rules,
+// look-alike detection, custom checks, the verdict expression and the enrichments that are enabled
+func judgeResult(lookups *Lookups, email string, result *emailverifier.Result, trace verifyTrace, probedAs string, config Config) EmailResult {
+	// The library's free-provider data is US-centric and not extensible
+	if lookups.Regional != nil && result.Syntax.Valid && lookups.Regional.IsFree(result.Syntax.Domain) {
+		result.Free = true
+	}
+	// Nor is its role account list, which -role-prefixes replaces
+	if lookups.Roles != nil && result.Syntax.Valid {
+		result.RoleAccount = lookups.Roles.Match(result.Syntax.Username)
+	}
+
+	if lookups.Typos != nil && result.Syntax.Valid && result.Suggestion == "" && !result.Free && !result.Disposable {
+		result.Suggestion = lookups.Typos.Suggest(result.Syntax.Domain)
+	}
+
+	isValid, reason := evaluateResult(result, config.Level)
+	risky := false
+
+	// Imitations of major providers are high-risk even when they accept mail
+	var lookalike *Lookalike
+	if config.Lookalikes && result.Syntax.Valid {
+		if lookalike = detectLookalike(result.Syntax.Domain); lookalike != nil && isValid {
+			isValid, risky = false, true
+			reason = fmt.Sprintf("look-alike of %s (%s)", lookalike.Target, lookalike.Technique)
+		}
+	}
+
+	// Custom checks see every syntactically valid address but can only downgrade a passing verdict
+	var checkResults map[string]CheckResult
+	if len(lookups.Checks) > 0 && result.Syntax.Valid {
+		var verdict, checkReason string
+		checkResults, verdict, checkReason = runChecks(lookups.Checks, email, result, config.Verbose)
+		if isValid && verdict != VerdictPass {
+			isValid, risky, reason = false, verdict == VerdictRisky, checkReason
+		}
+	}
+
+	// Only spend RDAP queries on addresses that would otherwise pass
+	if isValid && lookups.DomainAge != nil {
+		if risky, reason = evaluateDomainAge(lookups.DomainAge, result.Syntax.Domain, config); risky {
+			isValid = false
+		}
+	}
+
+	var country, countrySource string
+	if lookups.Geo != nil && result.Syntax.Valid {
+		country, countrySource = lookups.Geo.Country(result.Syntax.Domain)
+		if filterReason := lookups.Geo.FilterReason(country); isValid && filterReason != "" {
+			isValid, reason = false, filterReason
+		}
+	}
+
+	// How far the verdict can be trusted depends on how the provider answers probes
+	var confidence float64
+	if lookups.Strategies != nil && result.HasMxRecords {
+		confidence = lookups.Strategies.For(providerFor(result.Syntax.Domain, trace.mxHost)).Confidence
+	}
+
+	// Catch-all domains accept every address, but sampling random mailboxes can still tell the target apart
+	var catchAll *CatchAllSample
+	var timing *RCPTTiming
+	if config.CatchAllSamples > 0 && result.SMTP != nil && result.SMTP.CatchAll && trace.mxHost != "" && trace.timeLeft() {
+		start := time.Now()
+		sample, sampleTiming, err := sampleCatchAll(lookups.Session(emailDomain(email)), trace.mxHost, email, config.CatchAllSamples)
+		trace.smtp += time.Since(start)
+		if err != nil {
+			if config.Verbose {
+				slog.Debug("catch-all sampling failed", "email", email, "error", err)
+			}
+		} else {
+			catchAll = sample
+			confidence = sample.Confidence
+			if config.RCPTTiming {
+				timing = sampleTiming
+			}
+		}
+	}
+
+	// Sampling already measured the timing; otherwise probe a control mailbox around the address
+	if config.RCPTTiming && timing == nil && result.SMTP != nil && result.SMTP.Deliverable && trace.mxHost != "" && trace.timeLeft() {
+		start := time.Now()
+		measured, err := measureRCPTTiming(lookups.Session(emailDomain(email)), trace.mxHost, email)
+		trace.smtp += time.Since(start)
+		if err != nil {
+			if config.Verbose {
+				slog.Debug("RCPT timing failed", "email", email, "error", err)
+			}
+		} else {
+			timing = measured
+		}
+	}
+
+	// A catch-all domain accepts any recipient, so the probe proves nothing about the mailbox
+	catchAllDomain := result.SMTP != nil && result.SMTP.CatchAll
+	if config.CatchAllRisky && catchAllDomain && isValid {
+		isValid, risky, reason = false, true, "catch-all domain accepts every address"
+		if catchAll != nil {
+			reason += fmt.Sprintf(" (sampled confidence %.2f)", catchAll.Confidence)
+		}
+	}
+
+	// ESPs penalize sending to role accounts, which reach a team or a robot rather than a person
+	if config.RejectRoles && result.RoleAccount && (isValid || risky) {
+		isValid, risky, reason = false, false, "role account"
+	}
+	// B2B lists want company addresses, not gmail and yahoo signups
+	if config.ExcludeFree && result.Free && (isValid || risky) {
+		isValid, risky, reason = false, false, "free email provider"
+	}
+
+	emailResult := EmailResult{
+		Email:          email,
+		IsValid:        isValid,
+		Risky:          risky,
+		Reason:         reason,
+		ProbedAs:       probedAs,
+		Lookalike:      lookalike,
+		Confidence:     confidence,
+		CatchAllDomain: catchAllDomain,
+		CatchAll:       catchAll,
+		RoleAccount:    result.RoleAccount,
+		Free:           result.Free,
+		IPFamily:       trace.family,
+		RCPTTiming:     timing,
+		Country:        country,
+		CountrySource:  countrySource,
+		Checks:         checkResults,
+		Policy:         trace.policy,
+		raw:            result,
+		trace:          trace,
+	}
+
+	if lookups.Breaches != nil && result.Syntax.Valid {
+		checkBreaches(lookups.Breaches, &emailResult, config.Verbose)
+	}
+
+	// Firmographics are only useful for deliverable corporate addresses
+	if lookups.Company != nil && isValid && !result.Free {
+		enrichCompany(lookups.Company, result.Syntax.Domain, &emailResult, config.Verbose)
+	}
+
+	// A verdict expression has the final say over everything gathered above
+	if lookups.Verdict != nil {
+		if err := lookups.Verdict.Apply(&emailResult, result); err != nil && config.Verbose {
+			slog.Debug("verdict expression failed, keeping built-in verdict", "email", email, "error", err)
+		}
+	}
+	lookups.stamp(&emailResult)
+
+	if lookups.Domains != nil {
+		lookups.Domains.Observe(result, emailResult)
+	}
+
+	if config.Verbose {
+		switch {
+		case emailResult.IsValid:
+			slog.Debug("valid", "email", email)
+		case emailResult.Risky:
+			slog.Debug("risky", "email", email, "reason", emailResult.Reason)
+		default:
+			slog.Debug("invalid", "email", email, "reason", emailResult.Reason)
+		}
+	}
+
+	return emailResult
+}
+
+// runChecks runs custom checks in order and returns their results with the most severe verdict.
+// A check that errors is recorded with its error as reason and does not affect the verdict.
+func runChecks(checks []Check, email string, result *emailverifier.Result, verbose bool) (map[string]CheckResult, string, string) {
+	results := make(map[string]CheckResult, len(checks))
+	verdict, reason := VerdictPass, ""
+
+	for _, check := range checks {
+		checkResult, err := check.Run(email, result)
+		if err != nil {
+			if verbose {
+				slog.Debug("check failed", "check", check.Name(), "email", email, "error", err)
+			}
+			results[check.Name()] = CheckResult{Reason: fmt.Sprintf("check error: %v", err)}
+			continue
+		}
+		results[check.Name()] = checkResult
+
+		switch {
+		case checkResult.Verdict == VerdictInvalid && verdict != VerdictInvalid:
+			verdict, reason = VerdictInvalid, fmt.Sprintf("%s: %s", check.Name(), checkResult.Reason)
+		case checkResult.Verdict == VerdictRisky && verdict == VerdictPass:
+			verdict, reason = VerdictRisky, fmt.Sprintf("%s: %s", check.Name(), checkResult.Reason)
+		}
+	}
+
+	return results, verdict, reason
+}
+
+// applyInputHook transforms every input address, dropping those the hook empties.
+// Addresses the hook fails on are kept unchanged.
+func applyInputHook(hook InputHook, emails []string, verbose bool) []string {
+	transformed := emails[:0]
+	for _, email := range emails {
+		out, err := hook.TransformInput(email)
+		if err != nil {
+			if verbose {
+				slog.Debug("pre-hook failed", "email", email, "error", err)
+			}
+			out = email
+		}
+		if out != "" {
+			transformed = append(transformed, out)
+		}
+	}
+
+	if dropped := len(emails) - len(transformed); dropped > 0 {
+		slog.Info("pre-hook dropped emails", "emails", dropped)
+	}
+	return transformed
+}
+
+// applyResultHook transforms a result, keeping the original if the hook fails
+func applyResultHook(hook ResultHook, result EmailResult, verbose bool) EmailResult {
+	transformed, err := hook.TransformResult(result)
+	if err != nil {
+		if verbose {
+			slog.Debug("post-hook failed", "email", result.Email, "error", err)
+		}
+		return result
+	}
+	return transformed
+}
+
+// enrichCompany attaches firmographic data to the result; lookup failures leave it unset
+func enrichCompany(enricher *CompanyEnricher, domain string, emailResult *EmailResult, verbose bool) {
+	company, err := enricher.Company(domain)
+	if err != nil {
+		if verbose {
+			slog.Debug("company lookup failed", "domain", domain, "error", err)
+		}
+		return
+	}
+	emailResult.Company = company
+}
+
+// checkBreaches records breach presence on the result; lookup failures leave it unset
+func checkBreaches(checker *BreachChecker, emailResult *EmailResult, verbose bool) {
+	breaches, err := checker.Breaches(emailResult.Email)
+	if err != nil {
+		if verbose {
+			slog.Debug("breach lookup failed", "email", emailResult.Email, "error", err)
+		}
+		return
+	}
+
+	breached := len(breaches) > 0
+	emailResult.Breached = &breached
+	emailResult.Breaches = breaches
+}
+
+// evaluateDomainAge flags domains registered more recently than the configured minimum age
+func evaluateDomainAge(checker *DomainAgeChecker, domain string, config Config) (bool, string) {
+	registered, err := checker.RegistrationDate(domain)
+	if err != nil {
+		// Many ccTLDs have no RDAP service, so a failed lookup is not a risk signal
+		if config.Verbose {
+			slog.Debug("RDAP lookup failed", "domain", domain, "error", err)
+		}
+		return false, ""
+	}
+
+	age := time.Since(registered)
+	if age < config.MinDomainAge {
+		return true, fmt.Sprintf("domain registered recently (%d days ago)", int(age.Hours()/24))
+	}
+
+	return false, ""
+}
+
+// evaluateResult checks the verification result and returns validity status and reason. The
+// syntax level looks up no MX records, so their absence says nothing there.
+func evaluateResult(result *emailverifier.Result, level string) (bool, string) {
+	// Check syntax first
+	if !result.Syntax.Valid {
+		return false, "invalid email syntax"
+	}
+
+	// Check if it's a disposable email
+	if result.Disposable {
+		return false, "disposable email address"
+	}
+
+	// Check domain suggestion (typo detection)
+	if result.Suggestion != "" {
+		return false, fmt.Sprintf("possible typo, did you mean: %s", result.Suggestion)
+	}
+
+	// Check if MX records exist
+	if !result.HasMxRecords && level != levelSyntax {
+		return false, "domain has no MX records"
+	}
+
+	// Check SMTP result if available
+	if result.SMTP != nil {
+		if !result.SMTP.HostExists {
+			return false, "SMTP host does not exist"
+		}
+		// The library doesn't ask a catch-all server about the address, which it accepts like
+		// any other recipient, mailbox or not
+		if !result.SMTP.Deliverable && !result.SMTP.CatchAll {
+			return false, "email is not deliverable"
+		}
+		if result.SMTP.Disabled {
+			return false, "mailbox is disabled"
+		}
+	}
+
+	// Check reachability
+	if result.Reachable == "no" {
+		return false, "email is not reachable"
+	}
+
+	return true, ""
+}
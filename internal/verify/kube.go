@@ -0,0 +1,141 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into every pod
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// Kubernetes API errors worth telling apart
+var (
+	errKubeNotFound = errors.New("kubernetes resource not found")
+	errKubeConflict = errors.New("kubernetes resource was changed or created concurrently")
+)
+
+// kubeClient makes the few Kubernetes API calls the operator needs, with the pod's service account
+type kubeClient struct {
+	baseURL   string
+	token     string
+	namespace string
+	http      *http.Client
+}
+
+// newKubeClient connects to the API server at apiURL, or to the cluster the process runs in if
+// it is empty. Outside a cluster, `kubectl proxy` gives an apiURL that needs no token.
+func newKubeClient(apiURL string) (*kubeClient, error) {
+	k := &kubeClient{
+		baseURL:   strings.TrimSuffix(apiURL, "/"),
+		token:     os.Getenv("KUBE_TOKEN"),
+		namespace: os.Getenv("KUBE_NAMESPACE"),
+		http:      &http.Client{Timeout: 30 * time.Second},
+	}
+	if k.namespace == "" {
+		if data, err := os.ReadFile(serviceAccountDir + "/namespace"); err == nil {
+			k.namespace = strings.TrimSpace(string(data))
+		} else {
+			k.namespace = "default"
+		}
+	}
+	if k.baseURL != "" {
+		return k, nil
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("not running in a Kubernetes cluster; set KUBE_API_URL")
+	}
+	k.baseURL = "https://" + net.JoinHostPort(host, port)
+	token, err := os.ReadFile(serviceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read service account token: %w", err)
+	}
+	k.token = strings.TrimSpace(string(token))
+	ca, err := os.ReadFile(serviceAccountDir + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cluster CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.New("cluster CA holds no certificates")
+	}
+	k.http.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	return k, nil
+}
+
+// do makes an API call, encoding body as JSON (of the given content type) and decoding the response into out
+func (k *kubeClient) do(method, path, contentType string, body, out any) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, k.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("kubernetes API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return errKubeNotFound
+	case http.StatusConflict:
+		return errKubeConflict
+	}
+	if resp.StatusCode >= 300 {
+		var status struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&status)
+		return fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status, status.Message)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("failed to decode %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// get, list, create, replace and delete go through JSON; status updates are merge patches
+func (k *kubeClient) get(path string, out any) error { return k.do(http.MethodGet, path, "", nil, out) }
+func (k *kubeClient) create(path string, body, out any) error {
+	return k.do(http.MethodPost, path, "application/json", body, out)
+}
+func (k *kubeClient) replace(path string, body, out any) error {
+	return k.do(http.MethodPut, path, "application/json", body, out)
+}
+func (k *kubeClient) delete(path string) error { return k.do(http.MethodDelete, path, "", nil, nil) }
+func (k *kubeClient) mergePatch(path string, body any) error {
+	return k.do(http.MethodPatch, path, "application/merge-patch+json", body, nil)
+}
+
+// namespaced returns the API path of a resource collection in the client's namespace
+func (k *kubeClient) namespaced(apiPrefix, resource string) string {
+	return apiPrefix + "/namespaces/" + k.namespace + "/" + resource
+}
@@ -0,0 +1,31 @@
+package verify
+
+import "strings"
+
+// OutputFormat is how the JSON output and details files are laid out
+type OutputFormat struct {
+	GroupBy string
+	Indent  int  // spaces per nesting level
+	Compact bool // minified onto a single line
+}
+
+// outputFormat returns the layout configured for the JSON writers
+func (c Config) outputFormat() OutputFormat {
+	return OutputFormat{GroupBy: c.GroupBy, Indent: c.OutputIndent, Compact: c.OutputCompact}
+}
+
+// pad returns the indentation of a nesting level
+func (f OutputFormat) pad(level int) string {
+	if f.Compact {
+		return ""
+	}
+	return strings.Repeat(" ", f.Indent*level)
+}
+
+// newline ends a line, except in compact output
+func (f OutputFormat) newline() string {
+	if f.Compact {
+		return ""
+	}
+	return "\n"
+}
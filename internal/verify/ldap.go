@@ -0,0 +1,665 @@
+package verify
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ldapTimeout bounds dialing and each page of a search
+	ldapTimeout = time.Minute
+	// ldapPagedResults is the OID of the simple paged results control, which directories such as
+	// Active Directory require to return more than their size limit
+	ldapPagedResults = "1.2.840.113556.1.4.319"
+	ldapStartTLS     = "1.3.6.1.4.1.1466.20037"
+)
+
+// LDAP protocol operations, as BER application tags
+const (
+	ldapBindRequest     = 0x60
+	ldapUnbindRequest   = 0x42
+	ldapSearchRequest   = 0x63
+	ldapSearchEntry     = 0x64
+	ldapSearchDone      = 0x65
+	ldapSearchReference = 0x73
+	ldapExtendedRequest = 0x77
+)
+
+// BER tags of the universal types LDAP uses
+const (
+	berBoolean     = 0x01
+	berInteger     = 0x02
+	berOctetString = 0x04
+	berEnumerated  = 0x0a
+	berSequence    = 0x30
+)
+
+// isLDAPURL reports whether an input names a directory rather than a file
+func isLDAPURL(name string) bool {
+	return strings.HasPrefix(name, "ldap://") || strings.HasPrefix(name, "ldaps://")
+}
+
+// berElem is a decoded BER element: its tag and contents
+type berElem struct {
+	tag  byte
+	data []byte
+}
+
+// ber encodes an element from a tag and its contents, concatenated
+func ber(tag byte, contents ...[]byte) []byte {
+	size := 0
+	for _, c := range contents {
+		size += len(c)
+	}
+	out := []byte{tag}
+	if size < 0x80 {
+		out = append(out, byte(size))
+	} else {
+		var length []byte
+		for n := size; n > 0; n >>= 8 {
+			length = append([]byte{byte(n)}, length...)
+		}
+		out = append(append(out, 0x80|byte(len(length))), length...)
+	}
+	for _, c := range contents {
+		out = append(out, c...)
+	}
+	return out
+}
+
+// berInt encodes an integer in the fewest bytes of two's complement
+func berInt(tag byte, n int64) []byte {
+	var data []byte
+	for {
+		data = append([]byte{byte(n)}, data...)
+		if (n < 0x80 && n >= -0x80) || len(data) == 8 {
+			break
+		}
+		n >>= 8
+	}
+	return ber(tag, data)
+}
+
+func berString(tag byte, s string) []byte {
+	return ber(tag, []byte(s))
+}
+
+func berBool(b bool) []byte {
+	if b {
+		return ber(berBoolean, []byte{0xff})
+	}
+	return ber(berBoolean, []byte{0})
+}
+
+// parseBER decodes the element at the start of data, returning what follows it
+func parseBER(data []byte) (berElem, []byte, error) {
+	if len(data) < 2 {
+		return berElem{}, nil, errors.New("truncated BER element")
+	}
+	tag, size, rest := data[0], int(data[1]), data[2:]
+	if size&0x80 != 0 {
+		n := size & 0x7f
+		if n == 0 || n > 4 || len(rest) < n {
+			return berElem{}, nil, errors.New("invalid BER length")
+		}
+		size = 0
+		for _, b := range rest[:n] {
+			size = size<<8 | int(b)
+		}
+		rest = rest[n:]
+	}
+	if size > len(rest) {
+		return berElem{}, nil, errors.New("truncated BER element")
+	}
+	return berElem{tag: tag, data: rest[:size]}, rest[size:], nil
+}
+
+// readBER reads an element from a stream
+func readBER(r *bufio.Reader) (berElem, error) {
+	header := make([]byte, 2, 6)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return berElem{}, err
+	}
+	size := int(header[1])
+	if size&0x80 != 0 {
+		n := size & 0x7f
+		if n == 0 || n > 4 {
+			return berElem{}, errors.New("invalid BER length")
+		}
+		length := make([]byte, n)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return berElem{}, err
+		}
+		size = 0
+		for _, b := range length {
+			size = size<<8 | int(b)
+		}
+	}
+	if size > 64<<20 {
+		return berElem{}, fmt.Errorf("BER element of %d bytes is too large", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return berElem{}, err
+	}
+	return berElem{tag: header[0], data: data}, nil
+}
+
+// children decodes the elements of a constructed element
+func (e berElem) children() ([]berElem, error) {
+	var elems []berElem
+	for rest := e.data; len(rest) > 0; {
+		var elem berElem
+		var err error
+		if elem, rest, err = parseBER(rest); err != nil {
+			return nil, err
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
+
+// int decodes an integer or enumerated element
+func (e berElem) int() int64 {
+	var n int64
+	for i, b := range e.data {
+		if i == 0 && b&0x80 != 0 {
+			n = -1
+		}
+		n = n<<8 | int64(b)
+	}
+	return n
+}
+
+// ldapFilter encodes a search filter in the string form of RFC 4515, such as
+// (&(objectClass=user)(mail=*)(!(userAccountControl:1.2.840.113556.1.4.803:=2)))
+func ldapFilter(filter string) ([]byte, error) {
+	filter = strings.TrimSpace(filter)
+	if !strings.HasPrefix(filter, "(") {
+		filter = "(" + filter + ")"
+	}
+	encoded, rest, err := parseLDAPFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	if rest != "" {
+		return nil, fmt.Errorf("unexpected %q after filter", rest)
+	}
+	return encoded, nil
+}
+
+func parseLDAPFilter(s string) ([]byte, string, error) {
+	if !strings.HasPrefix(s, "(") {
+		return nil, "", fmt.Errorf("expected ( at %q", s)
+	}
+	s = s[1:]
+	if s == "" {
+		return nil, "", errors.New("unterminated filter")
+	}
+	var encoded []byte
+	switch op := s[0]; op {
+	case '&', '|', '!':
+		s = s[1:]
+		var parts [][]byte
+		for strings.HasPrefix(s, "(") {
+			part, rest, err := parseLDAPFilter(s)
+			if err != nil {
+				return nil, "", err
+			}
+			parts, s = append(parts, part), rest
+		}
+		switch {
+		case op == '!' && len(parts) != 1:
+			return nil, "", errors.New("! takes exactly one filter")
+		case op == '!':
+			encoded = ber(0xa2, parts[0])
+		case op == '&':
+			encoded = ber(0xa0, parts...)
+		default:
+			encoded = ber(0xa1, parts...)
+		}
+	default:
+		end := strings.IndexByte(s, ')')
+		if end < 0 {
+			return nil, "", errors.New("unterminated filter")
+		}
+		item, err := ldapFilterItem(s[:end])
+		if err != nil {
+			return nil, "", err
+		}
+		encoded, s = item, s[end:]
+	}
+	if !strings.HasPrefix(s, ")") {
+		return nil, "", errors.New("unterminated filter")
+	}
+	return encoded, s[1:], nil
+}
+
+// ldapFilterItem encodes a comparison such as mail=*@example.com
+func ldapFilterItem(item string) ([]byte, error) {
+	eq := strings.IndexByte(item, '=')
+	if eq < 1 {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := byte(0xa3)
+	switch attr[len(attr)-1] {
+	case '>':
+		tag, attr = 0xa5, attr[:len(attr)-1]
+	case '<':
+		tag, attr = 0xa6, attr[:len(attr)-1]
+	case '~':
+		tag, attr = 0xa8, attr[:len(attr)-1]
+	case ':':
+		return ldapExtensibleMatch(attr[:len(attr)-1], value)
+	}
+	if attr == "" {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+	if tag == 0xa3 && value == "*" {
+		return berString(0x87, attr), nil
+	}
+	if tag == 0xa3 && strings.Contains(value, "*") {
+		pieces := strings.Split(value, "*")
+		var subs [][]byte
+		for i, piece := range pieces {
+			if piece == "" {
+				continue
+			}
+			unescaped, err := ldapUnescape(piece)
+			if err != nil {
+				return nil, err
+			}
+			subTag := byte(0x81) // any
+			switch i {
+			case 0:
+				subTag = 0x80 // initial
+			case len(pieces) - 1:
+				subTag = 0x82 // final
+			}
+			subs = append(subs, berString(subTag, unescaped))
+		}
+		return ber(0xa4, berString(berOctetString, attr), ber(berSequence, subs...)), nil
+	}
+	unescaped, err := ldapUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+	return ber(tag, berString(berOctetString, attr), berString(berOctetString, unescaped)), nil
+}
+
+// ldapExtensibleMatch encodes attr:rule:=value and its variants, such as Active Directory's
+// bitwise matching rules
+func ldapExtensibleMatch(spec, value string) ([]byte, error) {
+	parts := strings.Split(spec, ":")
+	unescaped, err := ldapUnescape(value)
+	if err != nil {
+		return nil, err
+	}
+	var rule, attr []byte
+	dn := false
+	if parts[0] != "" {
+		attr = berString(0x82, parts[0])
+	}
+	for _, part := range parts[1:] {
+		switch {
+		case strings.EqualFold(part, "dn"):
+			dn = true
+		case part != "":
+			rule = berString(0x81, part)
+		}
+	}
+	if rule == nil && attr == nil {
+		return nil, fmt.Errorf("invalid extensible match %q", spec)
+	}
+	contents := [][]byte{rule, attr, berString(0x83, unescaped)}
+	if dn {
+		contents = append(contents, ber(0x84, []byte{0xff}))
+	}
+	return ber(0xa9, contents...), nil
+}
+
+// ldapUnescape decodes the \XX escapes of a filter value
+func ldapUnescape(s string) (string, error) {
+	if !strings.Contains(s, `\`) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		n, err := strconv.ParseUint(s[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", fmt.Errorf("invalid escape in %q", s)
+		}
+		b.WriteByte(byte(n))
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// ldapError is a failed LDAP operation
+type ldapError struct {
+	Code    int64
+	Message string
+}
+
+func (e *ldapError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("LDAP result code %d", e.Code)
+	}
+	return fmt.Sprintf("LDAP result code %d: %s", e.Code, e.Message)
+}
+
+// ldapClient is a minimal LDAPv3 client, enough to bind and run a paged search without pulling
+// in a library
+type ldapClient struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	messageID int64
+}
+
+// dialLDAP connects to the server of an ldap:// or ldaps:// URL, upgrading ldap:// connections
+// with StartTLS if asked to
+func dialLDAP(u *url.URL, startTLS bool) (*ldapClient, error) {
+	addr := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+		addr = net.JoinHostPort(u.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: ldapTimeout}
+	var conn net.Conn
+	var err error
+	if u.Scheme == "ldaps" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, &tls.Config{ServerName: u.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+	client := &ldapClient{conn: conn, reader: bufio.NewReader(conn)}
+	if startTLS && u.Scheme == "ldap" {
+		if _, err := client.roundTrip(ber(ldapExtendedRequest, berString(0x80, ldapStartTLS)), nil); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: u.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("StartTLS failed: %w", err)
+		}
+		client.conn, client.reader = tlsConn, bufio.NewReader(tlsConn)
+	}
+	return client, nil
+}
+
+// send writes a request with optional controls
+func (c *ldapClient) send(op []byte, controls []byte) error {
+	c.messageID++
+	msg := [][]byte{berInt(berInteger, c.messageID), op}
+	if controls != nil {
+		msg = append(msg, controls)
+	}
+	c.conn.SetDeadline(time.Now().Add(ldapTimeout))
+	_, err := c.conn.Write(ber(berSequence, msg...))
+	return err
+}
+
+// receive reads the next message of the current request, returning its operation and controls
+func (c *ldapClient) receive() (berElem, []berElem, error) {
+	c.conn.SetDeadline(time.Now().Add(ldapTimeout))
+	for {
+		msg, err := readBER(c.reader)
+		if err != nil {
+			return berElem{}, nil, err
+		}
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 {
+			return berElem{}, nil, errors.New("invalid LDAP message")
+		}
+		// Unsolicited notifications, such as a disconnection notice, have ID 0
+		if id := parts[0].int(); id != c.messageID {
+			if id == 0 {
+				return berElem{}, nil, fmt.Errorf("server ended the session: %w", ldapResult(parts[1]))
+			}
+			continue
+		}
+		var controls []berElem
+		if len(parts) > 2 && parts[2].tag == 0xa0 {
+			controls, _ = parts[2].children()
+		}
+		return parts[1], controls, nil
+	}
+}
+
+// roundTrip sends a request answered by a single response and checks its result
+func (c *ldapClient) roundTrip(op []byte, controls []byte) (berElem, error) {
+	if err := c.send(op, controls); err != nil {
+		return berElem{}, err
+	}
+	reply, _, err := c.receive()
+	if err != nil {
+		return berElem{}, err
+	}
+	return reply, ldapResult(reply)
+}
+
+// ldapResult returns the error of an operation's result, or nil if it succeeded
+func ldapResult(reply berElem) error {
+	parts, err := reply.children()
+	if err != nil || len(parts) < 3 {
+		return errors.New("invalid LDAP result")
+	}
+	if code := parts[0].int(); code != 0 {
+		return &ldapError{Code: code, Message: strings.TrimSpace(string(parts[2].data))}
+	}
+	return nil
+}
+
+// Bind authenticates with a simple bind, anonymously without a DN
+func (c *ldapClient) Bind(dn, password string) error {
+	_, err := c.roundTrip(ber(ldapBindRequest, berInt(berInteger, 3), berString(berOctetString, dn), berString(0x80, password)), nil)
+	return err
+}
+
+// Search runs a subtree search a page at a time, calling fn with every entry's DN and attributes
+func (c *ldapClient) Search(base string, filter []byte, attributes []string, pageSize int, fn func(dn string, attrs map[string][]string)) error {
+	attrs := make([][]byte, len(attributes))
+	for i, attr := range attributes {
+		attrs[i] = berString(berOctetString, attr)
+	}
+	request := ber(ldapSearchRequest,
+		berString(berOctetString, base),
+		berInt(berEnumerated, 2), // whole subtree
+		berInt(berEnumerated, 0), // never dereference aliases
+		berInt(berInteger, 0),    // no size limit
+		berInt(berInteger, 0),    // no time limit
+		berBool(false),
+		filter,
+		ber(berSequence, attrs...),
+	)
+
+	var cookie []byte
+	for {
+		paging := ber(berSequence, berString(berOctetString, ldapPagedResults), berBool(false),
+			ber(berOctetString, ber(berSequence, berInt(berInteger, int64(pageSize)), ber(berOctetString, cookie))))
+		if err := c.send(request, ber(0xa0, paging)); err != nil {
+			return err
+		}
+		for {
+			op, controls, err := c.receive()
+			if err != nil {
+				return err
+			}
+			switch op.tag {
+			case ldapSearchEntry:
+				dn, values, err := ldapEntry(op)
+				if err != nil {
+					return err
+				}
+				fn(dn, values)
+				continue
+			case ldapSearchReference:
+				// Referrals to other servers aren't followed
+				continue
+			case ldapSearchDone:
+			default:
+				return fmt.Errorf("unexpected LDAP operation 0x%x", op.tag)
+			}
+
+			if err := ldapResult(op); err != nil {
+				var ldapErr *ldapError
+				// A server without paging stops at its size limit
+				if errors.As(err, &ldapErr) && ldapErr.Code == 4 {
+					slog.Warn("LDAP search hit the server's size limit, results are incomplete", "error", err)
+					return nil
+				}
+				return err
+			}
+			cookie = nil
+			for _, control := range controls {
+				parts, err := control.children()
+				if err != nil || len(parts) < 2 || string(parts[0].data) != ldapPagedResults {
+					continue
+				}
+				value, _, err := parseBER(parts[len(parts)-1].data)
+				if err != nil {
+					return fmt.Errorf("invalid paged results control: %w", err)
+				}
+				if fields, err := value.children(); err == nil && len(fields) == 2 {
+					cookie = fields[1].data
+				}
+			}
+			if len(cookie) == 0 {
+				return nil
+			}
+			break
+		}
+	}
+}
+
+// ldapEntry decodes a search result entry
+func ldapEntry(op berElem) (string, map[string][]string, error) {
+	parts, err := op.children()
+	if err != nil || len(parts) < 2 {
+		return "", nil, errors.New("invalid LDAP search entry")
+	}
+	attributes, err := parts[1].children()
+	if err != nil {
+		return "", nil, errors.New("invalid LDAP search entry")
+	}
+	values := make(map[string][]string)
+	for _, attribute := range attributes {
+		fields, err := attribute.children()
+		if err != nil || len(fields) < 2 {
+			continue
+		}
+		vals, _ := fields[1].children()
+		name := strings.ToLower(string(fields[0].data))
+		for _, v := range vals {
+			values[name] = append(values[name], string(v.data))
+		}
+	}
+	return string(parts[0].data), values, nil
+}
+
+// Close unbinds and closes the connection
+func (c *ldapClient) Close() {
+	c.send(ber(ldapUnbindRequest), nil)
+	c.conn.Close()
+}
+
+// ldapAddress returns the address of an attribute value, or "" for none. Values of Active
+// Directory's proxyAddresses carry a type prefix: smtp: addresses are kept, X500: and others aren't.
+func ldapAddress(value string) string {
+	prefix, address, ok := strings.Cut(value, ":")
+	if !ok || strings.Contains(prefix, "@") {
+		return value
+	}
+	if strings.EqualFold(prefix, "smtp") {
+		return address
+	}
+	return ""
+}
+
+// readLDAPRecords reads the addresses of the directory entries matching the configured filter,
+// each with its entry's DN
+func readLDAPRecords(config Config) ([]InputRecord, error) {
+	u, err := url.Parse(config.InputFile)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid LDAP URL %q", config.InputFile)
+	}
+	// The base DN may be given as the URL's path, as LDAP URLs do
+	base := config.LDAPBaseDN
+	if base == "" {
+		base, _ = url.PathUnescape(strings.TrimPrefix(u.Path, "/"))
+	}
+	if base == "" {
+		return nil, errors.New("no base DN, set -ldap-base-dn")
+	}
+	filter, err := ldapFilter(config.LDAPFilter)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP filter: %w", err)
+	}
+	var attributes []string
+	for _, attr := range strings.Split(config.LDAPAttributes, ",") {
+		if attr = strings.TrimSpace(attr); attr != "" {
+			attributes = append(attributes, attr)
+		}
+	}
+	if len(attributes) == 0 {
+		return nil, errors.New("no LDAP attributes to read addresses from")
+	}
+
+	client, err := dialLDAP(u, config.LDAPStartTLS)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+	if config.LDAPBindDN != "" {
+		if err := client.Bind(config.LDAPBindDN, config.LDAPBindPassword); err != nil {
+			return nil, fmt.Errorf("failed to bind as %s: %w", config.LDAPBindDN, err)
+		}
+	}
+
+	var records []InputRecord
+	entries := 0
+	err = client.Search(base, filter, attributes, config.LDAPPageSize, func(dn string, values map[string][]string) {
+		entries++
+		seen := make(map[string]bool)
+		for _, attr := range attributes {
+			for _, value := range values[strings.ToLower(attr)] {
+				address := ldapAddress(value)
+				key := strings.ToLower(address)
+				if address == "" || seen[key] {
+					continue
+				}
+				seen[key] = true
+				records = append(records, InputRecord{ID: dn, Email: address})
+			}
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("LDAP search of %s failed: %w", base, err)
+	}
+	slog.Info("read addresses from LDAP", "base_dn", base, "entries", entries, "emails", len(records))
+	return records, nil
+}
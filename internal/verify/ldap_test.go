@@ -0,0 +1,447 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// unhex decodes a packet captured as hex, ignoring the spaces between its bytes
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(strings.Join(strings.Fields(s), ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestLDAPDecodesCapturedPackets(t *testing.T) {
+	// A successful BindResponse to message 1, as OpenLDAP sends it
+	msg, rest, err := parseBER(unhex(t, "30 0c 02 01 01 61 07 0a 01 00 04 00 04 00"))
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("parseBER: %v, %d bytes left", err, len(rest))
+	}
+	parts, err := msg.children()
+	if err != nil || len(parts) != 2 || parts[0].int() != 1 || parts[1].tag != 0x61 {
+		t.Fatalf("bind response decoded to %v, %v", parts, err)
+	}
+	if err := ldapResult(parts[1]); err != nil {
+		t.Errorf("success read as %v", err)
+	}
+
+	// invalidCredentials with a diagnostic message, from Active Directory
+	msg, _, err = parseBER(unhex(t, "30 84 00 00 00 21 02 01 01 61 84 00 00 00 18 0a 01 31 04 00 04 11"+
+		hex.EncodeToString([]byte("80090308: LdapErr"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts, _ = msg.children()
+	var ldapErr *ldapError
+	if err := ldapResult(parts[1]); !errors.As(err, &ldapErr) || ldapErr.Code != 49 || ldapErr.Message != "80090308: LdapErr" {
+		t.Errorf("invalid credentials read as %v", err)
+	}
+
+	// A search entry with a multi-valued attribute, the attribute name lowercased
+	entry := berElem{tag: ldapSearchEntry, data: unhex(t, "04 0f"+hex.EncodeToString([]byte("uid=jane,dc=acm"))+
+		"30 2a 30 28 04 0e"+hex.EncodeToString([]byte("proxyAddresses"))+
+		"31 16 04 08"+hex.EncodeToString([]byte("smtp:j@x"))+"04 0a"+hex.EncodeToString([]byte("X500:/o=ex")))}
+	dn, values, err := ldapEntry(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dn != "uid=jane,dc=acm" || !reflect.DeepEqual(values, map[string][]string{"proxyaddresses": {"smtp:j@x", "X500:/o=ex"}}) {
+		t.Errorf("entry decoded to %q %v", dn, values)
+	}
+
+	if got := (berElem{data: []byte{0xff, 0x38}}).int(); got != -200 {
+		t.Errorf("negative integer decoded to %d", got)
+	}
+	for _, bad := range []string{"30", "30 05 02 01", "30 80", "30 85 01 02 03 04 05", "30 82 01"} {
+		if _, _, err := parseBER(unhex(t, bad)); err == nil {
+			t.Errorf("parsed %s", bad)
+		}
+	}
+	if _, err := readBER(bufio.NewReader(bytes.NewReader(unhex(t, "30 84 7f 00 00 00")))); err == nil {
+		t.Error("read a 2 GB element")
+	}
+}
+
+func TestLDAPEncodesRequests(t *testing.T) {
+	// The simple bind request Wireshark shows for ldapsearch -D cn=admin,dc=example,dc=com -w secret
+	var sent bytes.Buffer
+	client := &ldapClient{conn: &writeConn{w: &sent}}
+	client.send(ber(ldapBindRequest, berInt(berInteger, 3), berString(berOctetString, "cn=admin,dc=example,dc=com"), berString(0x80, "secret")), nil)
+	want := unhex(t, "30 2c 02 01 01 60 27 02 01 03 04 1a"+hex.EncodeToString([]byte("cn=admin,dc=example,dc=com"))+
+		"80 06"+hex.EncodeToString([]byte("secret")))
+	if !bytes.Equal(sent.Bytes(), want) {
+		t.Errorf("bind request\n% x\nwant\n% x", sent.Bytes(), want)
+	}
+
+	// Lengths of 128 bytes and more take the long form
+	long := ber(berOctetString, bytes.Repeat([]byte{'a'}, 300))
+	if !bytes.Equal(long[:4], []byte{0x04, 0x82, 0x01, 0x2c}) {
+		t.Errorf("300-byte string starts % x", long[:4])
+	}
+	for n, want := range map[int64]string{0: "020100", 127: "02017f", 128: "02020080", 256: "02020100", -1: "0201ff", -129: "0202ff7f"} {
+		if got := hex.EncodeToString(berInt(berInteger, n)); got != want {
+			t.Errorf("berInt(%d) = %s, want %s", n, got, want)
+		}
+	}
+}
+
+// writeConn is a connection that only records what's written to it
+type writeConn struct {
+	net.Conn
+	w *bytes.Buffer
+}
+
+func (c *writeConn) Write(p []byte) (int, error) { return c.w.Write(p) }
+
+func (c *writeConn) SetDeadline(time.Time) error { return nil }
+
+func TestLDAPFilter(t *testing.T) {
+	hexOf := func(s string) string { return hex.EncodeToString([]byte(s)) }
+	for filter, want := range map[string]string{
+		// The examples of RFC 4515
+		"(cn=Babs Jensen)":                "a311 0402" + hexOf("cn") + "040b" + hexOf("Babs Jensen"),
+		"(!(cn=Tim Howes))":               "a211 a30f 0402" + hexOf("cn") + "0409" + hexOf("Tim Howes"),
+		"(o=univ*of*mich*)":               "a415 0401" + hexOf("o") + "3010 8004" + hexOf("univ") + "8102" + hexOf("of") + "8104" + hexOf("mich"),
+		"(seeAlso=)":                      "a30b 0407" + hexOf("seeAlso") + "0400",
+		"(cn:1.2.3.4.5:=Fred)":            "a915 8109" + hexOf("1.2.3.4.5") + "8202" + hexOf("cn") + "8304" + hexOf("Fred"),
+		"(o=Parens R Us \\28for all\\29)": "a31a 0401" + hexOf("o") + "0415" + hexOf("Parens R Us (for all)"),
+		// The default, parentheses optional
+		"mail=*": "8704" + hexOf("mail"),
+	} {
+		got, err := ldapFilter(filter)
+		if err != nil {
+			t.Errorf("%s: %v", filter, err)
+			continue
+		}
+		if hex.EncodeToString(got) != strings.ReplaceAll(want, " ", "") {
+			t.Errorf("%s encoded to %x, want %s", filter, got, want)
+		}
+	}
+
+	// The Active Directory filter of the README: an and of an equality, an or and a not
+	encoded, err := ldapFilter("(&(objectClass=user)(|(mail=*)(proxyAddresses=smtp:*))(!(userAccountControl:1.2.840.113556.1.4.803:=2)))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	and, _, _ := parseBER(encoded)
+	items, _ := and.children()
+	var tags []byte
+	for _, item := range items {
+		tags = append(tags, item.tag)
+	}
+	if and.tag != 0xa0 || !bytes.Equal(tags, []byte{0xa3, 0xa1, 0xa2}) {
+		t.Errorf("AD filter encoded to %x", encoded)
+	}
+
+	for _, bad := range []string{"(mail=*", "(&(mail=*)", "(!(a=1)(b=2))", "(=x)", "(mail=\\zz)", "(mail=*)(cn=x)", "(:=x)", "("} {
+		if _, err := ldapFilter(bad); err == nil {
+			t.Errorf("accepted %s", bad)
+		}
+	}
+}
+
+// fakeLDAP is a directory server answering binds and paged searches, recording the requests it got
+type fakeLDAP struct {
+	t        *testing.T
+	listener net.Listener
+	// pages are the entries of each page of a search, after which the search ends with done
+	pages [][][]byte
+	done  []byte
+
+	mu       sync.Mutex
+	requests []ldapRequest
+}
+
+// ldapRequest is an operation a client sent, with the size and cookie of its paging control
+type ldapRequest struct {
+	tag      byte
+	fields   []berElem
+	pageSize int64
+	cookie   string
+}
+
+func newFakeLDAP(t *testing.T, pages [][][]byte) *fakeLDAP {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("can't listen for LDAP: %v", err)
+	}
+	s := &fakeLDAP{t: t, listener: listener, pages: pages, done: ldapDone(0, "")}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.session(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeLDAP) url(base string) string {
+	return "ldap://" + s.listener.Addr().String() + "/" + base
+}
+
+func (s *fakeLDAP) session(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	page := 0
+	for {
+		msg, err := readBER(reader)
+		if err != nil {
+			return
+		}
+		parts, err := msg.children()
+		if err != nil || len(parts) < 2 {
+			s.t.Errorf("invalid LDAP message %x", msg.data)
+			return
+		}
+		id := parts[0].int()
+		request := ldapRequest{tag: parts[1].tag}
+		request.fields, _ = parts[1].children()
+		if len(parts) > 2 {
+			request.pageSize, request.cookie = pagingControl(parts[2])
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, request)
+		s.mu.Unlock()
+
+		switch request.tag {
+		case ldapUnbindRequest:
+			return
+		case ldapBindRequest:
+			code := int64(0)
+			if string(request.fields[1].data) != "cn=audit,dc=acme,dc=com" || string(request.fields[2].data) != "secret" {
+				code = 49
+			}
+			conn.Write(ldapMessage(id, ber(0x61, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, ""))))
+		case ldapSearchRequest:
+			// A reply to another message is skipped
+			conn.Write(ldapMessage(id+100, ldapDone(0, "")))
+			for _, entry := range s.pages[page] {
+				conn.Write(ldapMessage(id, entry))
+			}
+			var cookie string
+			if page++; page < len(s.pages) {
+				cookie = "page" + string(rune('0'+page))
+			}
+			conn.Write(ldapMessage(id, s.done, ldapPagingResponse(cookie)))
+		default:
+			s.t.Errorf("unexpected LDAP operation 0x%x", request.tag)
+			return
+		}
+	}
+}
+
+func (s *fakeLDAP) received() []ldapRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// pagingControl returns the size and cookie of the paged results control among a message's controls
+func pagingControl(controls berElem) (int64, string) {
+	list, _ := controls.children()
+	for _, control := range list {
+		parts, _ := control.children()
+		if len(parts) == 3 && string(parts[0].data) == ldapPagedResults {
+			value, _, _ := parseBER(parts[2].data)
+			fields, _ := value.children()
+			if len(fields) == 2 {
+				return fields[0].int(), string(fields[1].data)
+			}
+		}
+	}
+	return 0, ""
+}
+
+func ldapMessage(id int64, op []byte, controls ...[]byte) []byte {
+	msg := [][]byte{berInt(berInteger, id), op}
+	if len(controls) > 0 {
+		msg = append(msg, ber(0xa0, controls...))
+	}
+	return ber(berSequence, msg...)
+}
+
+func ldapDone(code int64, message string) []byte {
+	return ber(ldapSearchDone, berInt(berEnumerated, code), berString(berOctetString, ""), berString(berOctetString, message))
+}
+
+func ldapPagingResponse(cookie string) []byte {
+	return ber(berSequence, berString(berOctetString, ldapPagedResults),
+		ber(berOctetString, ber(berSequence, berInt(berInteger, 0), berString(berOctetString, cookie))))
+}
+
+func ldapTestEntry(dn string, attrs ...string) []byte {
+	var attributes [][]byte
+	for i := 0; i < len(attrs); i += 2 {
+		var values [][]byte
+		for _, value := range strings.Split(attrs[i+1], ",") {
+			values = append(values, berString(berOctetString, value))
+		}
+		attributes = append(attributes, ber(berSequence, berString(berOctetString, attrs[i]), ber(0x31, values...)))
+	}
+	return ber(ldapSearchEntry, berString(berOctetString, dn), ber(berSequence, attributes...))
+}
+
+func TestLDAPPagedSearch(t *testing.T) {
+	server := newFakeLDAP(t, [][][]byte{
+		{
+			ldapTestEntry("uid=jane,ou=people,dc=acme,dc=com", "mail", "jane@acme.com",
+				"proxyAddresses", "SMTP:jane@acme.com,smtp:j.doe@acme.com,X500:/o=acme/cn=jane"),
+			ber(ldapSearchReference, berString(berOctetString, "ldap://eu.acme.com/ou=people,dc=eu,dc=acme,dc=com")),
+		},
+		{ldapTestEntry("uid=bob,ou=people,dc=acme,dc=com", "MAIL", "bob@acme.com")},
+		{ldapTestEntry("cn=printers,dc=acme,dc=com")},
+	})
+
+	config := DefaultConfig()
+	config.InputFile = server.url("dc=acme,dc=com")
+	config.LDAPFilter = "(|(mail=*)(proxyAddresses=smtp:*))"
+	config.LDAPAttributes = "mail, proxyAddresses"
+	config.LDAPBindDN = "cn=audit,dc=acme,dc=com"
+	config.LDAPBindPassword = "secret"
+	config.LDAPPageSize = 2
+	records, err := readLDAPRecords(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []InputRecord{
+		{ID: "uid=jane,ou=people,dc=acme,dc=com", Email: "jane@acme.com"},
+		{ID: "uid=jane,ou=people,dc=acme,dc=com", Email: "j.doe@acme.com"},
+		{ID: "uid=bob,ou=people,dc=acme,dc=com", Email: "bob@acme.com"},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records %v, want %v", records, want)
+	}
+
+	// The unbind may still be on its way
+	requests := server.received()
+	for deadline := time.Now().Add(time.Second); len(requests) < 5 && time.Now().Before(deadline); requests = server.received() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	var tags []byte
+	for _, request := range requests {
+		tags = append(tags, request.tag)
+	}
+	if !bytes.Equal(tags, []byte{ldapBindRequest, ldapSearchRequest, ldapSearchRequest, ldapSearchRequest, ldapUnbindRequest}) {
+		t.Fatalf("requests % x", tags)
+	}
+	if requests[0].fields[0].int() != 3 {
+		t.Errorf("bound with LDAP version %d", requests[0].fields[0].int())
+	}
+	filter, _ := ldapFilter(config.LDAPFilter)
+	search := requests[1].fields
+	if string(search[0].data) != "dc=acme,dc=com" || search[1].int() != 2 || !bytes.Equal(ber(search[6].tag, search[6].data), filter) {
+		t.Errorf("search request %v", search)
+	}
+	if attrs, _ := search[7].children(); len(attrs) != 2 || string(attrs[0].data) != "mail" || string(attrs[1].data) != "proxyAddresses" {
+		t.Errorf("search asked for %v", attrs)
+	}
+	// Each page asks for the next with the cookie of the one before
+	for i, cookie := range []string{"", "page1", "page2"} {
+		if requests[i+1].pageSize != 2 || requests[i+1].cookie != cookie {
+			t.Errorf("page %d asked with size %d and cookie %q", i, requests[i+1].pageSize, requests[i+1].cookie)
+		}
+	}
+}
+
+func TestLDAPErrors(t *testing.T) {
+	entries := [][][]byte{{ldapTestEntry("uid=jane,dc=acme,dc=com", "mail", "jane@acme.com")}}
+
+	t.Run("bind", func(t *testing.T) {
+		server := newFakeLDAP(t, entries)
+		config := DefaultConfig()
+		config.InputFile = server.url("dc=acme,dc=com")
+		config.LDAPBindDN = "cn=audit,dc=acme,dc=com"
+		config.LDAPBindPassword = "wrong"
+		_, err := readLDAPRecords(config)
+		var ldapErr *ldapError
+		if !errors.As(err, &ldapErr) || ldapErr.Code != 49 || !strings.Contains(err.Error(), "failed to bind as cn=audit") {
+			t.Errorf("got %v, want invalid credentials", err)
+		}
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		// A server without paging returns what it can and sizeLimitExceeded
+		server := newFakeLDAP(t, entries)
+		server.done = ldapDone(4, "Sizelimit exceeded")
+		config := DefaultConfig()
+		config.InputFile = server.url("dc=acme,dc=com")
+		records, err := readLDAPRecords(config)
+		if err != nil || len(records) != 1 {
+			t.Errorf("got %v, %v, want the entries before the limit", records, err)
+		}
+	})
+
+	t.Run("no such object", func(t *testing.T) {
+		server := newFakeLDAP(t, entries)
+		server.done = ldapDone(32, "No such object")
+		config := DefaultConfig()
+		config.InputFile = server.url("dc=missing,dc=com")
+		_, err := readLDAPRecords(config)
+		if err == nil || !strings.Contains(err.Error(), "LDAP search of dc=missing,dc=com failed: LDAP result code 32: No such object") {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("notice of disconnection", func(t *testing.T) {
+		client, serverConn := net.Pipe()
+		defer client.Close()
+		go func() {
+			defer serverConn.Close()
+			readBER(bufio.NewReader(serverConn))
+			serverConn.Write(ldapMessage(0, ber(0x78, berInt(berEnumerated, 52), berString(berOctetString, ""), berString(berOctetString, "server shutting down"))))
+		}()
+		c := &ldapClient{conn: client, reader: bufio.NewReader(client)}
+		err := c.Bind("", "")
+		if err == nil || !strings.Contains(err.Error(), "server ended the session: LDAP result code 52: server shutting down") {
+			t.Errorf("got %v", err)
+		}
+	})
+
+	t.Run("config", func(t *testing.T) {
+		for name, change := range map[string]func(*Config){
+			"no host":       func(c *Config) { c.InputFile = "ldap:///dc=acme" },
+			"no base DN":    func(c *Config) { c.InputFile = "ldap://127.0.0.1:1" },
+			"bad filter":    func(c *Config) { c.LDAPFilter = "(mail=*" },
+			"no attributes": func(c *Config) { c.LDAPAttributes = " , " },
+		} {
+			config := DefaultConfig()
+			config.InputFile = "ldap://127.0.0.1:1/dc=acme"
+			change(&config)
+			if _, err := readLDAPRecords(config); err == nil || strings.Contains(err.Error(), "connect") {
+				t.Errorf("%s: got %v, want a configuration error", name, err)
+			}
+		}
+	})
+}
+
+func TestLDAPAddress(t *testing.T) {
+	for value, want := range map[string]string{
+		"jane@acme.com":       "jane@acme.com",
+		"SMTP:jane@acme.com":  "jane@acme.com",
+		"smtp:j.doe@acme.com": "j.doe@acme.com",
+		"X500:/o=acme/cn=j":   "",
+		"sip:jane@acme.com":   "",
+	} {
+		if got := ldapAddress(value); got != want {
+			t.Errorf("ldapAddress(%q) = %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+	"time"
+)
+
+// microTime is the timestamp format of Lease fields, which Kubernetes parses with exactly six decimals
+type microTime time.Time
+
+func (t microTime) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Time(t).UTC().Format("2006-01-02T15:04:05.000000Z07:00"))
+}
+
+func (t *microTime) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	*t = microTime(parsed)
+	return err
+}
+
+// kubeLease is a coordination.k8s.io/v1 Lease
+type kubeLease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string     `json:"holderIdentity"`
+		LeaseDurationSeconds int        `json:"leaseDurationSeconds"`
+		AcquireTime          *microTime `json:"acquireTime,omitempty"`
+		RenewTime            *microTime `json:"renewTime,omitempty"`
+		LeaseTransitions     int        `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// LeaderElector makes one of several server instances the leader by holding a Kubernetes Lease.
+// The others stand by and take the Lease over once the leader stops renewing it. Expiry is judged
+// by when this instance last saw the Lease change, not by the holder's clock.
+type LeaderElector struct {
+	kube     *kubeClient
+	name     string
+	identity string
+	duration time.Duration
+	leader   atomic.Bool
+
+	observed   string // holder and renew time last seen
+	observedAt time.Time
+	renewedAt  time.Time
+}
+
+func newLeaderElector(kube *kubeClient, name, identity string, duration time.Duration) *LeaderElector {
+	return &LeaderElector{kube: kube, name: name, identity: identity, duration: duration}
+}
+
+// IsLeader reports whether this instance holds the Lease
+func (e *LeaderElector) IsLeader() bool {
+	return e.leader.Load()
+}
+
+// Run tries to acquire the Lease and then keeps renewing it, calling onLeading once it is
+// acquired. It only returns once the Lease is lost, and the instance should then exit, so a
+// restart brings it back as a standby rather than leaving two leaders running.
+func (e *LeaderElector) Run(onLeading func()) error {
+	slog.Info("standing for leader", "identity", e.identity, "namespace", e.kube.namespace, "lease", e.name)
+	for {
+		leading, err := e.tryAcquire()
+		now := time.Now()
+		if err != nil {
+			slog.Warn("leader election failed", "error", err)
+		}
+		switch {
+		case leading:
+			e.renewedAt = now
+			if !e.leader.Swap(true) {
+				slog.Info("became the leader", "identity", e.identity)
+				go onLeading()
+			}
+		case e.leader.Load() && (err == nil || now.Sub(e.renewedAt) > e.duration):
+			return fmt.Errorf("lost leadership of lease %s, exiting to rejoin as a standby", e.name)
+		}
+		time.Sleep(e.duration / 3)
+	}
+}
+
+// tryAcquire creates, renews or takes over the Lease, reporting whether this instance holds it
+func (e *LeaderElector) tryAcquire() (bool, error) {
+	path := e.kube.namespaced("/apis/coordination.k8s.io/v1", "leases")
+	now := microTime(time.Now())
+
+	var lease kubeLease
+	err := e.kube.get(path+"/"+e.name, &lease)
+	if errors.Is(err, errKubeNotFound) {
+		lease.APIVersion, lease.Kind = "coordination.k8s.io/v1", "Lease"
+		lease.Metadata.Name = e.name
+		e.hold(&lease, now, true)
+		err = e.kube.create(path, lease, nil)
+		if errors.Is(err, errKubeConflict) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if record := lease.Spec.HolderIdentity + " " + timeString(lease.Spec.RenewTime); record != e.observed {
+		e.observed, e.observedAt = record, time.Now()
+	}
+	holder := lease.Spec.HolderIdentity
+	expired := holder == "" || time.Since(e.observedAt) > time.Duration(lease.Spec.LeaseDurationSeconds)*time.Second
+	if holder != e.identity && !expired {
+		return false, nil
+	}
+
+	e.hold(&lease, now, holder != e.identity)
+	// The resource version makes this fail if another instance got there first
+	err = e.kube.replace(path+"/"+e.name, lease, nil)
+	if errors.Is(err, errKubeConflict) {
+		return false, nil
+	}
+	if err == nil && holder != e.identity && holder != "" {
+		slog.Info("took over lease", "lease", e.name, "previous_holder", holder)
+	}
+	return err == nil, err
+}
+
+// hold sets this instance as the Lease's holder, renewed now
+func (e *LeaderElector) hold(lease *kubeLease, now microTime, acquired bool) {
+	if acquired {
+		lease.Spec.AcquireTime = &now
+		if lease.Spec.HolderIdentity != "" {
+			lease.Spec.LeaseTransitions++
+		}
+	}
+	lease.Spec.HolderIdentity = e.identity
+	lease.Spec.LeaseDurationSeconds = int(e.duration.Seconds())
+	lease.Spec.RenewTime = &now
+}
+
+func timeString(t *microTime) string {
+	if t == nil {
+		return ""
+	}
+	return time.Time(*t).String()
+}
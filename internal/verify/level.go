@@ -0,0 +1,36 @@
+package verify
+
+import (
+	"slices"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// Verification levels, from cheapest to most thorough
+const (
+	levelSyntax = "syntax" // syntax and the built-in lists, without network access
+	levelDNS    = "dns"    // plus the domain's MX records
+	levelSMTP   = "smtp"   // plus an SMTP probe of the mailbox
+)
+
+// levelDepth orders the levels by how much they check, -1 for an unknown one
+func levelDepth(level string) int {
+	return slices.Index([]string{levelSyntax, levelDNS, levelSMTP}, level)
+}
+
+// checkSyntax runs the checks of the syntax level, which need no network: the address's
+// syntax, the disposable, free-provider and role account lists, and domain suggestions
+func checkSyntax(verifier *emailverifier.Verifier, email string, isDisposable func(string) bool) *emailverifier.Result {
+	result := &emailverifier.Result{Email: email, Reachable: "unknown"}
+	result.Syntax = verifier.ParseAddress(email)
+	if !result.Syntax.Valid {
+		return result
+	}
+	domain := result.Syntax.Domain
+	result.Free = verifier.IsFreeDomain(domain)
+	result.RoleAccount = verifier.IsRoleAccount(result.Syntax.Username)
+	if result.Disposable = isDisposable(domain); !result.Disposable {
+		result.Suggestion = verifier.SuggestDomain(domain)
+	}
+	return result
+}
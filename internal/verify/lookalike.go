@@ -0,0 +1,113 @@
+package verify
+
+import (
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/net/publicsuffix"
+)
+
+// Look-alike techniques
+const (
+	lookalikeHomoglyph    = "homoglyph"    // Unicode characters that render like Latin ones
+	lookalikeSubstitution = "substitution" // ASCII stand-ins such as 0 for o or rn for m
+	lookalikeCombosquat   = "combosquat"   // the brand name with words or hyphens added
+)
+
+// lookalikeBrands maps mailbox brand names to the domain they imitate
+var lookalikeBrands = map[string]string{
+	"gmail":      "gmail.com",
+	"googlemail": "googlemail.com",
+	"google":     "gmail.com",
+	"yahoo":      "yahoo.com",
+	"ymail":      "ymail.com",
+	"hotmail":    "hotmail.com",
+	"outlook":    "outlook.com",
+	"microsoft":  "outlook.com",
+	"icloud":     "icloud.com",
+	"protonmail": "protonmail.com",
+	"live":       "live.com",
+	"aol":        "aol.com",
+	"apple":      "icloud.com",
+}
+
+// wholeNameBrands are common words that only count as the entire name, not as one hyphenated part
+var wholeNameBrands = map[string]bool{"live": true, "aol": true, "apple": true}
+
+// homoglyphs maps non-Latin characters to the Latin letters they are confused with
+var homoglyphs = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'ь': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'һ': 'h', 'і': 'i', 'ј': 'j', 'к': 'k',
+	'ӏ': 'l', 'м': 'm', 'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'ѵ': 'v', 'ԝ': 'w',
+	'х': 'x', 'у': 'y',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v', 'ο': 'o', 'ρ': 'p',
+	'τ': 't', 'υ': 'u', 'χ': 'x', 'γ': 'y',
+	// Latin variants and accented letters
+	'ɡ': 'g', 'ɩ': 'i', 'ı': 'i', 'ł': 'l', 'ø': 'o', 'à': 'a', 'á': 'a', 'â': 'a', 'ä': 'a',
+	'å': 'a', 'ç': 'c', 'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ì': 'i', 'í': 'i', 'î': 'i',
+	'ï': 'i', 'ñ': 'n', 'ò': 'o', 'ó': 'o', 'ô': 'o', 'ö': 'o', 'ù': 'u', 'ú': 'u', 'û': 'u',
+	'ü': 'u', 'ý': 'y', 'ÿ': 'y',
+}
+
+// asciiSubstitutes are stand-ins that read as the letters they replace
+var asciiSubstitutes = strings.NewReplacer(
+	"0", "o", "1", "l", "3", "e", "4", "a", "5", "s", "7", "t", "8", "b", "9", "g",
+	"rn", "m", "vv", "w", "cl", "d",
+)
+
+// Lookalike describes a domain imitating a major mailbox provider
+type Lookalike struct {
+	Target    string `json:"target"`
+	Technique string `json:"technique"`
+}
+
+// detectLookalike reports whether a domain is a confusable imitation of a major provider's domain,
+// or nil. Unlike typo suggestions it catches deliberate imitations that are several edits away.
+func detectLookalike(domain string) *Lookalike {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if unicode, err := idna.ToUnicode(domain); err == nil {
+		domain = unicode
+	}
+
+	registered, err := publicsuffix.EffectiveTLDPlusOne(domain)
+	if err != nil {
+		return nil
+	}
+	suffix, _ := publicsuffix.PublicSuffix(registered)
+	name := strings.TrimSuffix(registered, "."+suffix)
+
+	// The genuine brand on any suffix is the provider itself or a typo, which suggestions cover
+	if _, ok := lookalikeBrands[name]; ok {
+		return nil
+	}
+
+	technique := ""
+	skeleton := []rune{}
+	for _, r := range name {
+		if latin, ok := homoglyphs[r]; ok {
+			technique = lookalikeHomoglyph
+			r = latin
+		}
+		skeleton = append(skeleton, r)
+	}
+	folded := asciiSubstitutes.Replace(string(skeleton))
+	if technique == "" && folded != string(skeleton) {
+		technique = lookalikeSubstitution
+	}
+	if technique == "" {
+		technique = lookalikeCombosquat
+	}
+
+	// g00gle-mail reads as googlemail, gmail-secure as gmail with a word added
+	isSeparator := func(r rune) bool { return r == '-' || r == '_' }
+	if target, ok := lookalikeBrands[strings.Join(strings.FieldsFunc(folded, isSeparator), "")]; ok {
+		return &Lookalike{Target: target, Technique: technique}
+	}
+	for _, part := range strings.FieldsFunc(folded, isSeparator) {
+		if target, ok := lookalikeBrands[part]; ok && !wholeNameBrands[part] {
+			return &Lookalike{Target: target, Technique: technique}
+		}
+	}
+	return nil
+}
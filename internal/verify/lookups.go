@@ -0,0 +1,531 @@
+package verify
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Lookups holds optional external lookups shared by all workers
+type Lookups struct {
+	DomainAge  *DomainAgeChecker
+	Breaches   *BreachChecker
+	Company    *CompanyEnricher
+	Geo        *GeoInferrer
+	Regional   *RegionalLists
+	Disposable *DisposableDomains
+	Roles      *RoleAccounts
+	Allowlist  *AddressList
+	Blocklist  *AddressList
+	Typos      *TypoSuggester
+	TLDs       *TLDList
+	Checks     []Check
+	Verdict    *VerdictExpression
+	Domains    *DomainStore
+	Providers  *ProviderResolver
+	Strategies *Strategies
+	Patterns   *EmailPatterns
+	Validity   ValidityWindows
+	Actions    *ActionPolicy
+
+	// ProviderRates is the minimum interval between verifications per mailbox provider
+	ProviderRates  map[string]time.Duration
+	providerLimits map[string]*intervalLimiter
+	sharedLimits   *redisClient
+
+	// DomainLimits caps the verification rate of each recipient domain
+	DomainLimits *DomainLimiter
+
+	// Retry is applied to DNS lookups and SMTP probes that fail transiently
+	Retry RetryPolicy
+
+	// Simulator stands in for DNS and SMTP with -simulate
+	Simulator *Simulator
+
+	// Policy limits sensitive domains and providers to DNS-level checks
+	Policy *ProbePolicy
+
+	// Recorder writes the MX lookups and SMTP probes to a recording with -record
+	Recorder *Recorder
+
+	// Replayer answers MX lookups and SMTP probes from a recording with -replay
+	Replayer *Replayer
+
+	// Egress watches the probing IP for blocklistings
+	Egress *EgressMonitor
+
+	// Resolver answers the run's DNS lookups, through DNS when caching
+	Resolver *net.Resolver
+	// DNS answers every lookup of Resolver from its cache, with -dns-cache-size
+	DNS *DNSCache
+
+	// Proxies spread SMTP probes over SOCKS proxies, with -proxies
+	Proxies *ProxyPool
+	// probe is the identity and timeouts of SMTP probes, with -helo, -from and the -smtp timeouts
+	probe smtpSession
+	// Dialer connects probes to MX hosts over IPv6 and IPv4, with -ip-family
+	Dialer *ProbeDialer
+
+	// Pacer ramps probing back up after pauses, with -ramp-up
+	Pacer *CatchUpPacer
+
+	InputHook  InputHook
+	ResultHook ResultHook
+	Sinks      []ResultSink
+}
+
+// newLookups creates the lookups enabled in the configuration
+func newLookups(config Config) (*Lookups, error) {
+	validity, err := parseValidityWindows(config.ValidityWindows)
+	if err != nil {
+		return nil, err
+	}
+	lookups := &Lookups{Validity: validity, Retry: RetryPolicy{Retries: config.Retries, Backoff: config.RetryBackoff}}
+	if config.Actions {
+		if lookups.Actions, err = newActionPolicy(config.QuarantinePeriod); err != nil {
+			return nil, err
+		}
+	}
+	lookups.probe = smtpSession{
+		port:             strconv.Itoa(config.SMTPPort),
+		hello:            config.HelloName,
+		from:             config.FromEmail,
+		connectTimeout:   config.SMTPConnectTimeout,
+		operationTimeout: config.SMTPOperationTimeout,
+	}
+	resolver, dns, err := newResolver(config)
+	if err != nil {
+		return nil, fmt.Errorf("DNS cache: %w", err)
+	}
+	lookups.Resolver, lookups.DNS = resolver, dns
+	policy, err := loadProbePolicy(config.PolicyFile, config.NoProbeProviders)
+	if err != nil {
+		return nil, fmt.Errorf("probe policy: %w", err)
+	}
+	lookups.Policy = policy
+	if config.PolicyFile != "" {
+		domains, providers := policy.Size()
+		slog.Info("loaded probe policy", "domains", domains, "providers", providers)
+	}
+	if config.Simulate {
+		lookups.Simulator = newSimulator(config.EnableSMTP)
+		slog.Info("simulating DNS and SMTP, results are derived from the addresses, not verified")
+	}
+	if config.ReplayFile != "" {
+		replayer, count, err := loadReplayer(config.ReplayFile)
+		if err != nil {
+			return nil, fmt.Errorf("replay: %w", err)
+		}
+		lookups.Replayer = replayer
+		slog.Info("replaying recorded interactions instead of contacting servers", "interactions", count, "recording", config.ReplayFile)
+	}
+	if config.RecordFile != "" {
+		recorder, err := newRecorder(config.RecordFile)
+		if err != nil {
+			return nil, fmt.Errorf("record: %w", err)
+		}
+		lookups.Recorder = recorder
+		slog.Info("recording MX lookups and SMTP probes", "recording", config.RecordFile)
+	}
+
+	if config.EnableRDAP {
+		lookups.DomainAge = newDomainAgeChecker(config.RDAPURL, config.RDAPRateLimit, resolver)
+	}
+
+	if config.EnableHIBP {
+		lookups.Breaches = newBreachChecker(config.HIBPURL, config.HIBPAPIKey, config.HIBPRateLimit, resolver)
+	}
+
+	if config.EnableCompany {
+		enricher, err := newCompanyEnricher(config, resolver)
+		if err != nil {
+			return nil, fmt.Errorf("company enrichment: %w", err)
+		}
+		lookups.Company = enricher
+	}
+
+	// Provider detection and country inference look up MX records of their own, which a simulated
+	// run answers too; the simulated MX hosts don't exist to be located
+	lookupMX, geoIPURL := lookupMXWith(resolver), config.GeoIPURL
+	if lookups.Simulator != nil {
+		lookupMX, geoIPURL = lookups.Simulator.LookupMX, ""
+	}
+
+	if config.EnableGeo || config.OnlyCountries != "" || config.ExcludeCountries != "" {
+		lookups.Geo = newGeoInferrer(lookupMX, resolver, geoIPURL, config.OnlyCountries, config.ExcludeCountries)
+	}
+
+	if config.Regions != "" || config.RegionDataDir != "" {
+		regional, err := loadRegionalLists(config.Regions, config.RegionDataDir)
+		if err != nil {
+			return nil, fmt.Errorf("regional lists: %w", err)
+		}
+		regional.Register()
+		lookups.Regional = regional
+
+		free, disposable := regional.Counts()
+		slog.Info("loaded regional lists", "free_providers", free, "disposable_domains", disposable)
+	}
+
+	if config.DisposableList != "" || config.DisposableExtra != "" {
+		disposable, err := newDisposableDomains(config.DisposableList, config.DisposableExtra, config.DisposableRefresh, lookups.Regional)
+		if err != nil {
+			return nil, fmt.Errorf("disposable lists: %w", err)
+		}
+		lookups.Disposable = disposable
+		slog.Info("loaded disposable lists", "domains", disposable.Len(), "replaces_builtin", disposable.Replaces(), "refresh", config.DisposableRefresh)
+	}
+
+	if config.Allowlist != "" {
+		if lookups.Allowlist, err = loadAddressList(config.Allowlist); err != nil {
+			return nil, fmt.Errorf("allowlist: %w", err)
+		}
+		slog.Info("loaded allowlist", "entries", lookups.Allowlist.Len())
+	}
+	if config.Blocklist != "" {
+		if lookups.Blocklist, err = loadAddressList(config.Blocklist); err != nil {
+			return nil, fmt.Errorf("blocklist: %w", err)
+		}
+		slog.Info("loaded blocklist", "entries", lookups.Blocklist.Len())
+	}
+
+	if config.RolePrefixes != "" {
+		roles, err := loadRoleAccounts(config.RolePrefixes)
+		if err != nil {
+			return nil, fmt.Errorf("role accounts: %w", err)
+		}
+		lookups.Roles = roles
+		slog.Info("loaded role prefixes", "prefixes", roles.Len())
+	}
+
+	if config.TypoMarkets != "" || config.KeyboardLayout != "" {
+		typos, err := newTypoSuggester(config.TypoMarkets, config.KeyboardLayout)
+		if err != nil {
+			return nil, fmt.Errorf("typo suggestions: %w", err)
+		}
+		lookups.Typos = typos
+	}
+
+	if config.EnableTLDCheck {
+		tlds, err := loadTLDList(config.TLDListURL, config.TLDCacheFile, config.TLDMaxAge, config.RefreshTLDs)
+		if err != nil {
+			return nil, fmt.Errorf("TLD list: %w", err)
+		}
+		lookups.TLDs = tlds
+	}
+
+	if config.DomainStore != "" {
+		domains, err := openDomainStore(config.DomainStore)
+		if err != nil {
+			return nil, fmt.Errorf("domain store: %w", err)
+		}
+		lookups.Domains = domains
+	}
+
+	if config.EnablePatterns {
+		lookups.Patterns = newEmailPatterns(lookups.Domains)
+	}
+
+	// Strategies only matter when mailboxes are probed
+	rates := make(map[string]time.Duration)
+	if config.EnableSMTP && config.EnableStrategies {
+		strategies, err := loadStrategies(config.StrategyFile)
+		if err != nil {
+			return nil, fmt.Errorf("strategies: %w", err)
+		}
+		lookups.Strategies = strategies
+		rates = strategies.Pacing()
+	}
+
+	// Explicit provider rates override strategy pacing
+	if config.ProviderRates != "" {
+		overrides, err := parseProviderRates(config.ProviderRates)
+		if err != nil {
+			return nil, fmt.Errorf("provider rates: %w", err)
+		}
+		for provider, interval := range overrides {
+			rates[provider] = interval
+		}
+	}
+
+	if len(rates) > 0 {
+		lookups.Providers = newProviderResolver(lookupMX)
+		lookups.ProviderRates = rates
+		lookups.providerLimits = make(map[string]*intervalLimiter)
+		for provider, interval := range rates {
+			lookups.providerLimits[provider] = newIntervalLimiter(interval)
+		}
+	}
+
+	if config.DomainRate != "" || config.DomainRates != "" {
+		fallback, err := parseDomainRate(config.DomainRate)
+		if err != nil {
+			return nil, fmt.Errorf("domain rate: %w", err)
+		}
+		overrides, err := parseDomainRates(config.DomainRates)
+		if err != nil {
+			return nil, fmt.Errorf("domain rates: %w", err)
+		}
+		lookups.DomainLimits = newDomainLimiter(fallback, overrides)
+		slog.Info("limiting the rate of each domain", "rate_limit", fallback.String(), "overrides", len(overrides))
+	}
+
+	// Provider limits shared through Redis span every instance using the same key prefix
+	if config.RateLimitRedis != "" && len(rates) > 0 {
+		redis, err := newRedisClient(config.RateLimitRedis)
+		if err != nil {
+			return nil, fmt.Errorf("shared rate limits: %w", err)
+		}
+		lookups.sharedLimits = redis
+		for provider, limiter := range lookups.providerLimits {
+			limiter.shared = newSharedSlots(redis, config.RateLimitPrefix+provider)
+		}
+		slog.Info("sharing provider rate limits through Redis", "addr", redis.addr)
+	}
+
+	// Only SMTP probes reveal the egress IP to mail servers
+	if config.EgressCheck && config.EnableSMTP {
+		egress, err := newEgressMonitor(resolver, config.EgressIPs, config.EgressIPURL, config.DNSBLs, config.EgressInterval, config.BlocklistAction)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("egress check: %w", err)
+		}
+		lookups.Egress = egress
+	}
+	// Simulated and replayed runs make no connections to dial or spread, and through a proxy the
+	// proxy picks the family it reaches the MX host over
+	if config.EnableSMTP && !config.Simulate && config.ReplayFile == "" && config.Proxies == "" {
+		lookups.Dialer = newProbeDialer(config.IPFamily, resolver)
+	}
+	if config.Proxies != "" && config.EnableSMTP && !config.Simulate && config.ReplayFile == "" {
+		proxies, err := newProxyPool(config.Proxies, config.ProxyRotation)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("proxies: %w", err)
+		}
+		lookups.Proxies = proxies
+		slog.Info("probing through proxies", "proxies", len(proxies.proxies), "rotation", config.ProxyRotation)
+	}
+	if config.RampUp > 0 {
+		lookups.Pacer = newCatchUpPacer(config.RampUp)
+	}
+	if config.FCrDNSCheck && config.EnableSMTP {
+		checkFCrDNS(resolver, config.EgressIPs, config.EgressIPURL, config.HelloName)
+	}
+
+	if config.Checks != "" {
+		checks, err := newChecks(config.Checks)
+		if err != nil {
+			return nil, fmt.Errorf("custom checks: %w", err)
+		}
+		lookups.Checks = checks
+	}
+
+	if config.VerdictExpr != "" {
+		verdict, err := newVerdictExpression(config.VerdictExpr)
+		if err != nil {
+			lookups.Close()
+			return nil, err
+		}
+		lookups.Verdict = verdict
+	}
+
+	if config.PreHook != "" {
+		hook, err := newHook(config.PreHook)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("pre-hook: %w", err)
+		}
+		lookups.InputHook = hook
+	}
+
+	if config.PostHook != "" {
+		hook, err := newHook(config.PostHook)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("post-hook: %w", err)
+		}
+		lookups.ResultHook = hook
+	}
+
+	if config.Sinks != "" {
+		sinks, err := newSinks(config.Sinks)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("sinks: %w", err)
+		}
+		lookups.Sinks = sinks
+	}
+	if config.BigQueryTable != "" {
+		sink, err := newBigQuerySink(config)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("BigQuery: %w", err)
+		}
+		lookups.Sinks = append(lookups.Sinks, sink)
+	}
+	if config.ElasticsearchURL != "" {
+		sink, err := newElasticsearchSink(config)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("Elasticsearch: %w", err)
+		}
+		lookups.Sinks = append(lookups.Sinks, sink)
+	}
+	if config.ClickHouseURL != "" {
+		sink, err := newClickHouseSink(config)
+		if err != nil {
+			lookups.Close()
+			return nil, fmt.Errorf("ClickHouse: %w", err)
+		}
+		lookups.Sinks = append(lookups.Sinks, sink)
+	}
+
+	return lookups, nil
+}
+
+// stamp records when a result expires and, with -actions, what to do with it
+func (l *Lookups) stamp(result *EmailResult) {
+	l.Validity.Stamp(result)
+	l.Actions.Stamp(result)
+}
+
+// Close releases resources held by lookups, such as external plugin and hook processes
+func (l *Lookups) Close() {
+	closeChecks(l.Checks)
+	closeSinks(l.Sinks)
+	if l.sharedLimits != nil {
+		l.sharedLimits.Close()
+	}
+	if l.Egress != nil {
+		l.Egress.Close()
+	}
+	if l.Disposable != nil {
+		l.Disposable.Close()
+	}
+	if l.Recorder != nil {
+		if err := l.Recorder.Close(); err != nil {
+			slog.Warn("failed to close recording", "error", err)
+		}
+	}
+	for _, hook := range []any{l.InputHook, l.ResultHook} {
+		if closer, ok := hook.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// Session returns how a probe of a domain reaches its MX hosts: through a proxy of the pool, the
+// probe dialer, or directly
+func (l *Lookups) Session(domain string) smtpSession {
+	session := l.probe
+	switch {
+	case l.Proxies != nil:
+		session.dial = l.Proxies.Dialer(domain)
+	case l.Dialer != nil:
+		session.dial = l.Dialer.DialTimeout
+	default:
+		session.dial = func(addr string, timeout time.Duration) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: timeout, Resolver: l.Resolver}
+			conn, err := dialer.Dial("tcp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return smtpTraffic.count(conn), nil
+		}
+	}
+	return session
+}
+
+// WaitForEgress blocks while verification is paused for a blocklisted egress IP, ramping back up
+// once it is delisted
+func (l *Lookups) WaitForEgress() {
+	if l.Egress != nil && l.Egress.Wait() {
+		l.Pacer.Resume()
+	}
+}
+
+// WaitForProvider blocks until the rate limit of the domain's mailbox provider allows another verification
+func (l *Lookups) WaitForProvider(domain string) {
+	if l.Providers == nil || domain == "" {
+		return
+	}
+	if limiter, ok := l.providerLimits[l.Providers.Provider(domain)]; ok {
+		limiter.Wait()
+	}
+}
+
+// WaitForDomain blocks until the domain's own rate limit allows another verification
+func (l *Lookups) WaitForDomain(domain string) {
+	if l.DomainLimits != nil && domain != "" {
+		l.DomainLimits.Wait(domain)
+	}
+}
+
+// intervalLimiter spaces out calls so that at most one starts per interval across all goroutines
+type intervalLimiter struct {
+	interval time.Duration
+	waiting  atomic.Int64
+	shared   *sharedSlots // optional, reserves slots across instances
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newIntervalLimiter(interval time.Duration) *intervalLimiter {
+	return &intervalLimiter{interval: interval}
+}
+
+// Wait blocks until the caller's reserved slot is reached
+func (l *intervalLimiter) Wait() {
+	if l.interval <= 0 {
+		return
+	}
+
+	var slot time.Time
+	if wait, ok := l.reserveShared(); ok {
+		slot = time.Now().Add(wait)
+	} else {
+		l.mu.Lock()
+		now := time.Now()
+		slot = l.next
+		if slot.Before(now) {
+			slot = now
+		}
+		l.next = slot.Add(l.interval)
+		l.mu.Unlock()
+	}
+
+	l.waiting.Add(1)
+	time.Sleep(time.Until(slot))
+	l.waiting.Add(-1)
+}
+
+// reserveShared takes the slot from the shared schedule, if there is one and it can be reached.
+// The local schedule follows along so the backlog metric stays meaningful.
+func (l *intervalLimiter) reserveShared() (time.Duration, bool) {
+	if l.shared == nil {
+		return 0, false
+	}
+	wait, ok := l.shared.reserve(l.interval)
+	if ok {
+		l.mu.Lock()
+		if next := time.Now().Add(wait + l.interval); next.After(l.next) {
+			l.next = next
+		}
+		l.mu.Unlock()
+	}
+	return wait, ok
+}
+
+// backlog is how long a caller arriving now would wait for its slot
+func (l *intervalLimiter) backlog() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return max(time.Until(l.next), 0)
+}
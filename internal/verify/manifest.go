@@ -0,0 +1,64 @@
+package verify
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// Artifact is a file produced by a run, as listed in the manifest
+type Artifact struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	SHA256  string `json:"sha256"`
+	Bytes   int64  `json:"bytes"`
+	Records int    `json:"records"`
+}
+
+// Manifest lists a run's artifacts with checksums so transfers can be verified end to end
+type Manifest struct {
+	Input     string     `json:"input"`
+	CreatedAt time.Time  `json:"created_at"`
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+// Add checksums a written artifact. Object storage outputs are read from their staging file
+// but listed under their URL, where downstream steps will fetch them from.
+func (m *Manifest) Add(kind, name string, records int) error {
+	file, err := os.Open(stagedOutput(name))
+	if err != nil {
+		return fmt.Errorf("failed to open %s for checksum: %w", name, err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return fmt.Errorf("failed to checksum %s: %w", name, err)
+	}
+	m.Artifacts = append(m.Artifacts, Artifact{
+		Kind:    kind,
+		Path:    name,
+		SHA256:  hex.EncodeToString(hash.Sum(nil)),
+		Bytes:   size,
+		Records: records,
+	})
+	return nil
+}
+
+// writeManifest writes the manifest as indented JSON
+func writeManifest(filename string, manifest *Manifest) error {
+	manifest.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
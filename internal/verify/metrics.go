@@ -0,0 +1,101 @@
+package verify
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// metricsWriter writes gauges in the Prometheus text exposition format, which KEDA's and the
+// HPA's Prometheus adapters scrape
+type metricsWriter struct {
+	w       *bufio.Writer
+	written map[string]bool
+}
+
+func newMetricsWriter(w io.Writer) *metricsWriter {
+	return &metricsWriter{w: bufio.NewWriter(w), written: make(map[string]bool)}
+}
+
+// labelEscaper escapes label values as the exposition format specifies: only backslashes, double
+// quotes and newlines, leaving other characters, non-ASCII ones included, as UTF-8
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// gauge writes one sample, with its help text the first time the metric appears. Labels are
+// name and value pairs.
+func (m *metricsWriter) gauge(name, help string, value float64, labels ...string) {
+	name = "email_verification_" + name
+	if !m.written[name] {
+		m.written[name] = true
+		fmt.Fprintf(m.w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	m.w.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, labels[i]+`="`+labelEscaper.Replace(labels[i+1])+`"`)
+		}
+		m.w.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(m.w, " %g\n", value)
+}
+
+func (m *metricsWriter) Flush() error { return m.w.Flush() }
+
+// writeLimiterMetrics reports how saturated each provider's rate limiter is: workers blocked on
+// it and how far ahead its slots are taken. Saturated limiters mean more workers won't help.
+func writeLimiterMetrics(m *metricsWriter, lookups *Lookups) {
+	providers := make([]string, 0, len(lookups.providerLimits))
+	for provider := range lookups.providerLimits {
+		providers = append(providers, provider)
+	}
+	sort.Strings(providers)
+	for _, provider := range providers {
+		limiter := lookups.providerLimits[provider]
+		m.gauge("provider_limiter_waiting", "Workers waiting on the provider's rate limiter", float64(limiter.waiting.Load()), "provider", provider)
+	}
+	for _, provider := range providers {
+		limiter := lookups.providerLimits[provider]
+		m.gauge("provider_limiter_backlog_seconds", "How long a verification for the provider would wait for its slot", limiter.backlog().Seconds(), "provider", provider)
+	}
+	// Workers are paused outright while a blocklisted egress IP is, with -blocklist-action=pause
+	if lookups.Egress != nil {
+		m.gauge("egress_blocklistings", "DNSBL listings of the egress IPs found by the latest check", float64(len(lookups.Egress.Listings())))
+	}
+}
+
+// serveMetrics writes the server's job queue, work queue and limiter metrics
+func serveMetrics(jobs *JobManager, lookups *Lookups) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m := newMetricsWriter(w)
+
+		counts := map[string]int{jobQueued: 0, jobRunning: 0}
+		for _, status := range jobs.statuses() {
+			if _, ok := counts[status]; ok {
+				counts[status]++
+			}
+		}
+		for _, state := range []string{jobQueued, jobRunning} {
+			m.gauge("jobs", "Jobs queued or running", float64(counts[state]), "state", state)
+		}
+		pending, throughput := jobs.pending()
+		m.gauge("pending_addresses", "Addresses of queued and running jobs not yet checked", float64(pending))
+		m.gauge("throughput_addresses_per_second", "Addresses checked per second by the running job", throughput)
+
+		if jobs.work != nil {
+			depth := jobs.work.Depth()
+			m.gauge("work_units", "Work units waiting for or held by workers", float64(depth.PendingUnits), "state", "pending")
+			m.gauge("work_units", "Work units waiting for or held by workers", float64(depth.LeasedUnits), "state", "leased")
+			m.gauge("work_unit_addresses", "Addresses in work units waiting for or held by workers", float64(depth.PendingAddresses), "state", "pending")
+			m.gauge("work_unit_addresses", "Addresses in work units waiting for or held by workers", float64(depth.LeasedAddresses), "state", "leased")
+			m.gauge("workers", "Workers that checked in within the worker timeout, or went stale", float64(depth.Workers), "state", "active")
+			m.gauge("workers", "Workers that checked in within the worker timeout, or went stale", float64(depth.StaleWorkers), "state", "stale")
+		}
+		writeLimiterMetrics(m, lookups)
+		m.Flush()
+	}
+}
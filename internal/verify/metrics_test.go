@@ -0,0 +1,29 @@
+package verify
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"gmail", `{provider="gmail"}`},
+		{"münchen.de", `{provider="münchen.de"}`},
+		{`say "hi"`, `{provider="say \"hi\""}`},
+		{`back\slash`, `{provider="back\\slash"}`},
+		{"two\nlines", `{provider="two\nlines"}`},
+		{"tab\there", "{provider=\"tab\there\"}"},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		m := newMetricsWriter(&buf)
+		m.gauge("test", "Test metric.", 1, "provider", tt.value)
+		m.Flush()
+		want := "# HELP email_verification_test Test metric.\n# TYPE email_verification_test gauge\nemail_verification_test" + tt.want + " 1\n"
+		if got := buf.String(); got != want {
+			t.Errorf("label %q: got %q, want %q", tt.value, got, want)
+		}
+	}
+}
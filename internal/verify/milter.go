@@ -0,0 +1,263 @@
+package verify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Milter commands from the MTA and responses to them, as libmilter defines them
+const (
+	milterAbort    = 'A'
+	milterBody     = 'B'
+	milterConnect  = 'C'
+	milterMacro    = 'D'
+	milterBodyEOB  = 'E'
+	milterHelo     = 'H'
+	milterQuitNC   = 'K'
+	milterHeader   = 'L'
+	milterMail     = 'M'
+	milterEOH      = 'N'
+	milterOptNeg   = 'O'
+	milterQuit     = 'Q'
+	milterRcpt     = 'R'
+	milterData     = 'T'
+	milterUnknown  = 'U'
+	milterContinue = 'c'
+	milterReply    = 'y'
+)
+
+// Protocol steps the milter asks the MTA to skip: everything but RCPT
+const (
+	milterNoConnect = 0x01
+	milterNoHelo    = 0x02
+	milterNoMail    = 0x04
+	milterNoBody    = 0x10
+	milterNoHeaders = 0x20
+	milterNoEOH     = 0x40
+	milterNoUnknown = 0x100
+	milterNoData    = 0x200
+
+	milterSkipSteps = milterNoConnect | milterNoHelo | milterNoMail | milterNoBody | milterNoHeaders | milterNoEOH | milterNoUnknown | milterNoData
+)
+
+const (
+	// milterVersion is the highest protocol version spoken, that of Sendmail 8.14 and Postfix
+	milterVersion = 6
+	// milterMaxPacket bounds packets from the MTA; body chunks, the largest, are at most 64KB
+	milterMaxPacket = 1024 * 1024
+)
+
+// Milter lets Postfix or Sendmail consult the verifier at SMTP time: each RCPT TO of the
+// configured domains is verified, and the MTA rejects the recipients found invalid with the
+// verdict's reply before accepting any mail for them
+type Milter struct {
+	recipients *RecipientVerifier
+	domains    map[string]bool // recipient domains verified, all when empty
+
+	mu       sync.Mutex
+	open     map[net.Conn]struct{}
+	sessions sync.WaitGroup
+}
+
+// MilterOptions are the settings of a milter
+type MilterOptions struct {
+	Listen  string // socket to serve the MTA on: inet:host:port, host:port or unix:/path
+	Domains string // comma-separated recipient domains to verify, all when empty
+	// Risky and Unknown answer risky recipients and those that couldn't be verified: accept,
+	// reject or tempfail
+	Risky    string
+	Unknown  string
+	CacheTTL time.Duration // how long verdicts are reused for repeated recipients, 0 for never
+	Timeout  time.Duration // deadline for verifying a recipient, below the MTA's own
+}
+
+// ServeMilter verifies the recipients of an MTA over the milter protocol until interrupted
+func ServeMilter(config Config, opts MilterOptions) error {
+	if opts.Timeout <= 0 {
+		return fmt.Errorf("invalid -timeout %v, expected a positive duration", opts.Timeout)
+	}
+	// The MTA gives up on a milter that takes too long, so -email-timeout may only be shorter
+	if config.EmailTimeout == 0 || config.EmailTimeout > opts.Timeout {
+		config.EmailTimeout = opts.Timeout
+	}
+	config.Workers = max(config.Workers, 1)
+
+	network, address := "tcp", strings.TrimPrefix(opts.Listen, "inet:")
+	if path, ok := strings.CutPrefix(opts.Listen, "unix:"); ok {
+		network, address = "unix", path
+	}
+
+	lookups, err := newLookups(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure lookups: %w", err)
+	}
+	defer lookups.Close()
+
+	recipients, err := newRecipientVerifier(config, lookups, opts.Risky, opts.Unknown, opts.CacheTTL)
+	if err != nil {
+		return fmt.Errorf("invalid milter settings: %w", err)
+	}
+	m := &Milter{recipients: recipients, domains: make(map[string]bool), open: make(map[net.Conn]struct{})}
+	for _, domain := range splitList(opts.Domains) {
+		m.domains[strings.ToLower(strings.TrimSuffix(domain, "."))] = true
+	}
+
+	// A socket left behind by an earlier run would make the listen fail
+	if network == "unix" {
+		os.Remove(address)
+		defer os.Remove(address)
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen for the MTA: %w", err)
+	}
+	slog.Info("milter serving", "addr", opts.Listen, "domains", len(m.domains), "risky", opts.Risky, "unknown", opts.Unknown)
+
+	ctx := interruptContext()
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		m.closeIdle()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			break
+		}
+		if err != nil {
+			slog.Warn("failed to accept milter connection", "error", err)
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		m.sessions.Add(1)
+		go func() {
+			defer m.sessions.Done()
+			m.session(ctx, conn, config.Verbose)
+		}()
+	}
+	m.sessions.Wait()
+	flushSinks(lookups.Sinks)
+	return nil
+}
+
+// closeIdle wakes the sessions waiting for the MTA's next command, so they end on shutdown
+func (m *Milter) closeIdle() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for conn := range m.open {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// session serves one MTA connection, which the MTA may reuse for several SMTP sessions
+func (m *Milter) session(ctx context.Context, conn net.Conn, verbose bool) {
+	m.mu.Lock()
+	m.open[conn] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		delete(m.open, conn)
+		m.mu.Unlock()
+		conn.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	for ctx.Err() == nil {
+		command, data, err := readMilterPacket(reader)
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil && verbose {
+				slog.Debug("milter connection failed", "error", err)
+			}
+			return
+		}
+
+		var response []byte
+		switch command {
+		case milterOptNeg:
+			if len(data) < 12 {
+				return
+			}
+			// Take the MTA's version if older, act on nothing but replies, and skip every step the
+			// MTA lets us skip but RCPT
+			version := min(binary.BigEndian.Uint32(data), milterVersion)
+			protocol := binary.BigEndian.Uint32(data[8:]) & milterSkipSteps
+			response = binary.BigEndian.AppendUint32(nil, version)
+			response = binary.BigEndian.AppendUint32(response, 0)
+			response = binary.BigEndian.AppendUint32(response, protocol)
+			err = writeMilterPacket(conn, milterOptNeg, response)
+		case milterRcpt:
+			err = m.rcpt(ctx, conn, data, verbose)
+		case milterMacro, milterAbort:
+			// Neither gets a response
+		case milterQuit:
+			return
+		case milterConnect, milterHelo, milterMail, milterData, milterHeader, milterEOH, milterBody, milterBodyEOB, milterUnknown:
+			err = writeMilterPacket(conn, milterContinue, nil)
+		case milterQuitNC:
+			// The connection is kept for the MTA's next SMTP session
+		default:
+			if verbose {
+				slog.Debug("unknown milter command", "command", string(rune(command)))
+			}
+			err = writeMilterPacket(conn, milterContinue, nil)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// rcpt answers RCPT with the recipient's verdict: continue for recipients the MTA may accept, or
+// the verdict's SMTP reply for those it should refuse
+func (m *Milter) rcpt(ctx context.Context, conn net.Conn, data []byte, verbose bool) error {
+	// The arguments are NUL-terminated strings: the address in angle brackets, then ESMTP parameters
+	arg, _, _ := strings.Cut(string(data), "\x00")
+	email := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(arg), "<"), ">")
+	if _, domain, ok := strings.Cut(email, "@"); !ok || (len(m.domains) > 0 && !m.domains[strings.ToLower(domain)]) {
+		return writeMilterPacket(conn, milterContinue, nil)
+	}
+
+	reply := m.recipients.Reply(ctx, email)
+	if verbose {
+		slog.Debug("milter RCPT", "email", email, "reply", reply)
+	}
+	if strings.HasPrefix(reply, "2") {
+		return writeMilterPacket(conn, milterContinue, nil)
+	}
+	// The MTA formats the reply text, so a literal % is doubled
+	return writeMilterPacket(conn, milterReply, append([]byte(strings.ReplaceAll(reply, "%", "%%")), 0))
+}
+
+// readMilterPacket reads a packet: its length, including the command byte, then the command and data
+func readMilterPacket(r io.Reader) (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(header[:])
+	if length == 0 || length > milterMaxPacket {
+		return 0, nil, fmt.Errorf("invalid milter packet length %d", length)
+	}
+	packet := make([]byte, length)
+	if _, err := io.ReadFull(r, packet); err != nil {
+		return 0, nil, fmt.Errorf("failed to read milter packet: %w", err)
+	}
+	return packet[0], packet[1:], nil
+}
+
+func writeMilterPacket(w io.Writer, command byte, data []byte) error {
+	packet := binary.BigEndian.AppendUint32(make([]byte, 0, 5+len(data)), uint32(1+len(data)))
+	packet = append(append(packet, command), data...)
+	_, err := w.Write(packet)
+	return err
+}
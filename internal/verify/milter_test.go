@@ -0,0 +1,189 @@
+package verify
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startRecipientVerifier answers recipients with verdicts from a mock MX behaving as spec says
+func startRecipientVerifier(t *testing.T, spec, risky, unknown string) *RecipientVerifier {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("probes a mock mail server")
+	}
+	resolver, port := startMockMX(t, spec)
+	config := DefaultConfig()
+	offlineReplay(&config)
+	config.Resolver = resolver
+	config.SMTPPort = port
+	config.EnableStrategies = false
+	config.RateLimit, config.RampUp = 0, 0
+	config.SMTPOperationTimeout = time.Second
+	config.Workers = 2
+	if err := config.Normalize(); err != nil {
+		t.Fatal(err)
+	}
+	lookups, err := newLookups(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(lookups.Close)
+	recipients, err := newRecipientVerifier(config, lookups, risky, unknown, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipients
+}
+
+// milterMTA plays the MTA's side of a milter connection
+type milterMTA struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// startMilter serves a milter session on one end of a pipe, returning the MTA's end
+func startMilter(t *testing.T, m *Milter) *milterMTA {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m.session(context.Background(), server, false)
+	}()
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+	client.SetDeadline(time.Now().Add(30 * time.Second))
+	return &milterMTA{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+// send sends a command, which some commands expect no response to
+func (mta *milterMTA) send(command byte, data string) {
+	mta.t.Helper()
+	if err := writeMilterPacket(mta.conn, command, []byte(data)); err != nil {
+		mta.t.Fatalf("failed to send %c: %v", command, err)
+	}
+}
+
+// expect reads the milter's response and checks its command and data
+func (mta *milterMTA) expect(command byte, data string) {
+	mta.t.Helper()
+	got, gotData, err := readMilterPacket(mta.reader)
+	if err != nil {
+		mta.t.Fatalf("no response, want %c: %v", command, err)
+	}
+	if got != command || string(gotData) != data {
+		mta.t.Errorf("response %c %q, want %c %q", got, gotData, command, data)
+	}
+}
+
+// optNeg encodes the option negotiation data: version, actions and protocol steps
+func optNeg(version, actions, protocol uint32) string {
+	data := binary.BigEndian.AppendUint32(nil, version)
+	data = binary.BigEndian.AppendUint32(data, actions)
+	return string(binary.BigEndian.AppendUint32(data, protocol))
+}
+
+func TestMilterPostfixConversation(t *testing.T) {
+	recipients := startRecipientVerifier(t, "*=reject,good@acme.test=accept,*@grey.test=greylist:1h", recipientAccept, recipientTempfail)
+	m := &Milter{recipients: recipients, domains: map[string]bool{"acme.test": true, "grey.test": true}, open: make(map[net.Conn]struct{})}
+	mta := startMilter(t, m)
+
+	// Postfix 3 offers protocol version 6, every action and every step to skip (SMFIP_* up to
+	// 0x1fffff); the milter asks to skip all but RCPT and requests no actions
+	mta.send(milterOptNeg, optNeg(6, 0x1ff, 0x1fffff))
+	mta.expect(milterOptNeg, optNeg(6, 0, milterSkipSteps))
+
+	// An SMTP session as Postfix sends it: macros get no response, steps the milter didn't skip do
+	mta.send(milterMacro, "Cj\x00mx.acme.test\x00{daemon_name}\x00smtpd\x00")
+	mta.send(milterConnect, "client.example.org\x004\x00\x00\x00192.0.2.1\x00")
+	mta.expect(milterContinue, "")
+	mta.send(milterMacro, "M{mail_addr}\x00sender@example.org\x00")
+	mta.send(milterMail, "<sender@example.org>\x00SIZE=1024\x00")
+	mta.expect(milterContinue, "")
+
+	tests := []struct {
+		rcpt    string
+		command byte
+		reply   string
+	}{
+		{"<good@acme.test>\x00NOTIFY=NEVER\x00", milterContinue, ""},
+		{"<bad@acme.test>\x00", milterReply, "550 5.1.1 <bad@acme.test>: Recipient address rejected: "},
+		{"<jane@grey.test>\x00", milterReply, "451 4.4.3 <jane@grey.test>: Recipient address not verified yet"},
+		// Domains outside -domains aren't verified
+		{"<anyone@example.org>\x00", milterContinue, ""},
+		// Neither are local parts without a domain, as postmaster
+		{"<postmaster>\x00", milterContinue, ""},
+	}
+	for _, tt := range tests {
+		mta.send(milterMacro, "R{rcpt_addr}\x00"+strings.Trim(strings.Split(tt.rcpt, "\x00")[0], "<>")+"\x00")
+		mta.send(milterRcpt, tt.rcpt)
+		command, data, err := readMilterPacket(mta.reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if command != tt.command || !strings.HasPrefix(string(data), tt.reply) {
+			t.Errorf("RCPT %q answered %c %q, want %c %q", tt.rcpt, command, data, tt.command, tt.reply)
+		}
+		if command == milterReply && !strings.HasSuffix(string(data), "\x00") {
+			t.Errorf("reply %q isn't NUL-terminated", data)
+		}
+	}
+
+	// The message is aborted after the rejected recipients; the connection is kept for the next
+	// SMTP session, whose repeated recipient is answered from the cache
+	mta.send(milterAbort, "")
+	mta.send(milterQuitNC, "")
+	mta.send(milterMacro, "Cj\x00mx.acme.test\x00")
+	mta.send(milterRcpt, "<bad@acme.test>\x00")
+	mta.expect(milterReply, "550 5.1.1 <bad@acme.test>: Recipient address rejected: email is not deliverable\x00")
+	// Commands the milter doesn't know are continued
+	mta.send('Z', "")
+	mta.expect(milterContinue, "")
+	mta.send(milterQuit, "")
+	if _, _, err := readMilterPacket(mta.reader); err == nil {
+		t.Error("connection open after QUIT")
+	}
+}
+
+func TestMilterOlderMTA(t *testing.T) {
+	m := &Milter{open: make(map[net.Conn]struct{})}
+
+	// Sendmail 8.13 speaks version 2 and can't skip DATA or unknown commands
+	mta := startMilter(t, m)
+	mta.send(milterOptNeg, optNeg(2, 0x3f, 0x7f))
+	mta.expect(milterOptNeg, optNeg(2, 0, 0x77))
+	mta.send(milterHelo, "client.example.org\x00")
+	mta.expect(milterContinue, "")
+	mta.send(milterQuit, "")
+
+	// Truncated negotiation and packets of invalid length end the session
+	for _, packet := range []string{"\x00\x00\x00\x05O\x00\x00\x00\x06", "\x00\x00\x00\x00", "\x00\x20\x00\x00O"} {
+		mta := startMilter(t, m)
+		mta.conn.Write([]byte(packet))
+		if _, _, err := readMilterPacket(mta.reader); err == nil {
+			t.Errorf("packet %q answered", packet)
+		}
+	}
+}
+
+func TestMilterPackets(t *testing.T) {
+	var b strings.Builder
+	writeMilterPacket(&b, milterReply, []byte("550 5.1.1 rejected\x00"))
+	if got := b.String(); got != "\x00\x00\x00\x14y550 5.1.1 rejected\x00" {
+		t.Errorf("packet %q", got)
+	}
+	command, data, err := readMilterPacket(strings.NewReader(b.String()))
+	if err != nil || command != milterReply || string(data) != "550 5.1.1 rejected\x00" {
+		t.Errorf("read back %c %q, %v", command, data, err)
+	}
+	if _, _, err := readMilterPacket(strings.NewReader("\x00\x00\x00\x09Rshort")); err == nil {
+		t.Error("read a truncated packet")
+	}
+}
@@ -0,0 +1,318 @@
+package verify
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// Mailbox behaviors of the mock mail server
+const (
+	mockAccept   = "accept"   // 250 for the mailbox
+	mockReject   = "reject"   // 550 user unknown
+	mockGreylist = "greylist" // 451 until the delay has passed since the first attempt, then 250
+	mockTarpit   = "tarpit"   // 250 after stalling for the delay
+	mockNoMX     = "nomx"     // the domain does not resolve
+)
+
+// Defaults of the mock server
+const (
+	defaultMockGreylist = time.Minute
+	defaultMockTarpit   = 30 * time.Second
+	mockSessionTimeout  = 5 * time.Minute
+	mockTTL             = 60
+)
+
+// MockBehavior is how the mock server answers for a mailbox or domain
+type MockBehavior struct {
+	Action string
+	Delay  time.Duration // greylisting period or tarpit stall
+}
+
+// parseMockBehaviors parses rules like "*=reject,jane@acme.test=accept,*@slow.test=tarpit:10s".
+// Keys are an address, *@domain for a whole domain, or * for everything else.
+func parseMockBehaviors(spec string) (map[string]MockBehavior, error) {
+	behaviors := make(map[string]MockBehavior)
+	for _, part := range splitList(spec) {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid behavior %q, expected address=action", part)
+		}
+		action, delay, hasDelay := strings.Cut(strings.TrimSpace(value), ":")
+		behavior := MockBehavior{Action: action}
+		switch action {
+		case mockAccept, mockReject, mockNoMX:
+		case mockGreylist:
+			behavior.Delay = defaultMockGreylist
+		case mockTarpit:
+			behavior.Delay = defaultMockTarpit
+		default:
+			return nil, fmt.Errorf("invalid action %q for %s (expected %s, %s, %s, %s or %s)", action, key, mockAccept, mockReject, mockGreylist, mockTarpit, mockNoMX)
+		}
+		if hasDelay {
+			d, err := time.ParseDuration(delay)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("invalid delay %q for %s", delay, key)
+			}
+			behavior.Delay = d
+		}
+		behaviors[strings.ToLower(strings.TrimSpace(key))] = behavior
+	}
+	return behaviors, nil
+}
+
+// MockMX is a DNS and SMTP server for end-to-end tests. Its DNS answers every domain with an MX
+// record pointing back at it, so with -resolver set to it every probe lands on its SMTP server,
+// which answers RCPT per mailbox as configured.
+type MockMX struct {
+	behaviors map[string]MockBehavior
+	ip        net.IP // IPv4 address of the MX hosts, nil for IPv6-only
+	ipv6      net.IP // IPv6 address of the MX hosts, nil for IPv4-only
+	verbose   bool
+
+	mu        sync.Mutex
+	firstSeen map[string]time.Time // first attempt per greylisted mailbox
+}
+
+// MockMXOptions are the settings of a mock MX server
+type MockMXOptions struct {
+	SMTPListen string
+	DNSListen  string // UDP address, to pass as -resolver
+	IP         string // IPv4 address the MX hosts resolve to, empty for IPv6-only hosts
+	IPv6       string // IPv6 address the MX hosts resolve to, empty for IPv4-only hosts
+	Behaviors  string // mailbox behaviors as key=action[:delay] pairs
+	Verbose    bool   // log every DNS query and RCPT
+}
+
+// ServeMockMX serves mock DNS and SMTP until interrupted
+func ServeMockMX(opts MockMXOptions) error {
+	behaviors, err := parseMockBehaviors(opts.Behaviors)
+	if err != nil {
+		return fmt.Errorf("failed to configure mock server: %w", err)
+	}
+	m := &MockMX{behaviors: behaviors, verbose: opts.Verbose, firstSeen: make(map[string]time.Time)}
+	if opts.IP != "" {
+		if m.ip = net.ParseIP(opts.IP).To4(); m.ip == nil {
+			return fmt.Errorf("invalid IPv4 address %q", opts.IP)
+		}
+	}
+	if opts.IPv6 != "" {
+		if m.ipv6 = net.ParseIP(opts.IPv6); m.ipv6 == nil || m.ipv6.To4() != nil {
+			return fmt.Errorf("invalid IPv6 address %q", opts.IPv6)
+		}
+	}
+	if m.ip == nil && m.ipv6 == nil {
+		return errors.New("the MX hosts need an -ip or -ipv6 address")
+	}
+
+	dns, err := net.ListenPacket("udp", opts.DNSListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for DNS: %w", err)
+	}
+	smtp, err := net.Listen("tcp", opts.SMTPListen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for SMTP: %w", err)
+	}
+	go m.serveDNS(dns)
+	go m.serveSMTP(smtp)
+	slog.Info("mock MX serving", "dns", dns.LocalAddr().String(), "smtp", smtp.Addr().String(), "behaviors", len(behaviors))
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	<-sig
+	dns.Close()
+	smtp.Close()
+	return nil
+}
+
+// behavior returns the rule for an address: its own, its domain's, or the catch-all rule.
+// Without a * rule unknown mailboxes are rejected, so catch-all detection sees a normal server.
+func (m *MockMX) behavior(address string) MockBehavior {
+	address = strings.ToLower(address)
+	if behavior, ok := m.behaviors[address]; ok {
+		return behavior
+	}
+	if _, domain, ok := strings.Cut(address, "@"); ok {
+		if behavior, ok := m.behaviors["*@"+domain]; ok {
+			return behavior
+		}
+	}
+	if behavior, ok := m.behaviors["*"]; ok {
+		return behavior
+	}
+	return MockBehavior{Action: mockReject}
+}
+
+// serveDNS answers MX queries with mx.<domain>, and A and AAAA queries with the configured IPs
+func (m *MockMX) serveDNS(conn net.PacketConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if response, err := m.answer(buf[:n]); err != nil {
+			slog.Warn("mock DNS failed", "error", err)
+		} else {
+			conn.WriteTo(response, from)
+		}
+	}
+}
+
+// answer builds the response to a DNS query
+func (m *MockMX) answer(query []byte) ([]byte, error) {
+	var parser dnsmessage.Parser
+	header, err := parser.Start(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid query: %w", err)
+	}
+	question, err := parser.Question()
+	if err != nil {
+		return nil, fmt.Errorf("invalid question: %w", err)
+	}
+	name := strings.ToLower(strings.TrimSuffix(question.Name.String(), "."))
+	domain := strings.TrimPrefix(name, "mx.")
+
+	response := dnsmessage.Header{ID: header.ID, Response: true, Authoritative: true, RecursionDesired: header.RecursionDesired}
+	if m.behavior("*@"+name).Action == mockNoMX || m.behavior("*@"+domain).Action == mockNoMX {
+		response.RCode = dnsmessage.RCodeNameError
+	}
+	if m.verbose {
+		slog.Info("DNS query", "type", strings.TrimPrefix(question.Type.String(), "Type"), "name", name, "rcode", strings.TrimPrefix(response.RCode.String(), "RCode"))
+	}
+
+	builder := dnsmessage.NewBuilder(nil, response)
+	builder.EnableCompression()
+	if err := builder.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := builder.Question(question); err != nil {
+		return nil, err
+	}
+	if err := builder.StartAnswers(); err != nil {
+		return nil, err
+	}
+	if response.RCode == dnsmessage.RCodeSuccess {
+		resource := dnsmessage.ResourceHeader{Name: question.Name, Class: dnsmessage.ClassINET, TTL: mockTTL}
+		switch question.Type {
+		case dnsmessage.TypeMX:
+			mx, err := dnsmessage.NewName("mx." + name + ".")
+			if err != nil {
+				return nil, err
+			}
+			if err := builder.MXResource(resource, dnsmessage.MXResource{Pref: 10, MX: mx}); err != nil {
+				return nil, err
+			}
+		case dnsmessage.TypeA:
+			if m.ip != nil {
+				if err := builder.AResource(resource, dnsmessage.AResource{A: [4]byte(m.ip)}); err != nil {
+					return nil, err
+				}
+			}
+		case dnsmessage.TypeAAAA:
+			if m.ipv6 != nil {
+				if err := builder.AAAAResource(resource, dnsmessage.AAAAResource{AAAA: [16]byte(m.ipv6)}); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return builder.Finish()
+}
+
+// serveSMTP accepts SMTP sessions until the listener is closed
+func (m *MockMX) serveSMTP(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.session(conn)
+	}
+}
+
+// session speaks enough SMTP for probes: greeting, EHLO/HELO, MAIL, RCPT, RSET and QUIT
+func (m *MockMX) session(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(mockSessionTimeout))
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	reply("220 mock-mx ESMTP ready")
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			reply("250 mock-mx")
+		case "MAIL":
+			reply("250 2.1.0 OK")
+		case "RCPT":
+			reply(m.rcpt(arg))
+		case "RSET", "NOOP":
+			reply("250 2.0.0 OK")
+		case "VRFY":
+			reply("252 2.5.2 Cannot VRFY user")
+		case "DATA":
+			reply("554 5.5.1 No messages accepted")
+		case "QUIT":
+			reply("221 2.0.0 Bye")
+			return
+		default:
+			reply("502 5.5.2 Command not recognized")
+		}
+	}
+}
+
+// rcpt answers RCPT TO:<address> according to the mailbox's behavior
+func (m *MockMX) rcpt(arg string) string {
+	address := arg
+	if start, end := strings.Index(arg, "<"), strings.LastIndex(arg, ">"); start >= 0 && end > start {
+		address = arg[start+1 : end]
+	}
+	behavior := m.behavior(address)
+
+	response := "250 2.1.5 OK"
+	switch behavior.Action {
+	case mockReject, mockNoMX:
+		response = "550 5.1.1 User unknown"
+	case mockGreylist:
+		if m.greylisted(address, behavior.Delay) {
+			response = "451 4.7.1 Greylisted, please try again later"
+		}
+	case mockTarpit:
+		time.Sleep(behavior.Delay)
+	}
+	if m.verbose {
+		slog.Info("RCPT", "address", address, "behavior", behavior.Action, "response", response)
+	}
+	return response
+}
+
+// greylisted reports whether an address is still within its greylisting period
+func (m *MockMX) greylisted(address string, delay time.Duration) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	address = strings.ToLower(address)
+	first, ok := m.firstSeen[address]
+	if !ok {
+		m.firstSeen[address] = time.Now()
+		return true
+	}
+	return time.Since(first) < delay
+}
@@ -0,0 +1,101 @@
+package verify
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startMockMX serves mock DNS and SMTP on free ports, returning the DNS address to use as
+// -resolver and the SMTP port to use as -smtp-port
+func startMockMX(t *testing.T, spec string) (string, int) {
+	t.Helper()
+	behaviors, err := parseMockBehaviors(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &MockMX{behaviors: behaviors, ip: net.IPv4(127, 0, 0, 1).To4(), firstSeen: make(map[string]time.Time)}
+	dns, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dns.Close() })
+	smtp, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { smtp.Close() })
+	go m.serveDNS(dns)
+	go m.serveSMTP(smtp)
+	return dns.LocalAddr().String(), smtp.Addr().(*net.TCPAddr).Port
+}
+
+func TestMockMXVerdicts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("probes a mock mail server")
+	}
+	resolver, port := startMockMX(t, "*=reject,good@acme.test=accept,*@grey.test=greylist:1h,*@soon.test=greylist:500ms,*@slow.test=tarpit:5s,*@catchall.test=accept")
+
+	config := DefaultConfig()
+	offlineReplay(&config)
+	config.Resolver = resolver
+	config.SMTPPort = port
+	config.EnableStrategies = false
+	config.RateLimit, config.RampUp = 0, 0
+	config.GreylistRetry = time.Second
+	config.SMTPOperationTimeout = time.Second
+	config.CatchAllRisky = true
+	runner, err := NewRunner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	tests := []struct {
+		email      string
+		valid      bool
+		risky      bool
+		greylisted bool
+		errored    bool
+		reason     string // prefix of the reason
+	}{
+		{email: "good@acme.test", valid: true},
+		{email: "bad@acme.test", reason: "email is not deliverable"},
+		{email: "jane@grey.test", greylisted: true, errored: true, reason: "still greylisted after retrying"},
+		{email: "jane@soon.test", valid: true, greylisted: true},
+		{email: "jane@slow.test", errored: true, reason: "verification error"},
+		{email: "jane@catchall.test", risky: true, reason: "catch-all domain accepts every address"},
+	}
+	emails := make([]string, len(tests))
+	for i, tt := range tests {
+		emails[i] = tt.email
+	}
+	results, _, err := runner.Verify(context.Background(), emails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byEmail := make(map[string]EmailResult)
+	for _, result := range results {
+		byEmail[result.Email] = result
+	}
+
+	for _, tt := range tests {
+		result, ok := byEmail[tt.email]
+		if !ok {
+			t.Errorf("%s: no result", tt.email)
+			continue
+		}
+		if result.IsValid != tt.valid || result.Risky != tt.risky || result.errored != tt.errored {
+			t.Errorf("%s: valid, risky, errored = %t, %t, %t, want %t, %t, %t (%s)", tt.email,
+				result.IsValid, result.Risky, result.errored, tt.valid, tt.risky, tt.errored, result.Reason)
+		}
+		if result.Greylisted != tt.greylisted {
+			t.Errorf("%s: greylisted = %t, want %t", tt.email, result.Greylisted, tt.greylisted)
+		}
+		if !strings.HasPrefix(result.Reason, tt.reason) {
+			t.Errorf("%s: reason %q, want %q", tt.email, result.Reason, tt.reason)
+		}
+	}
+}
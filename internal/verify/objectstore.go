@@ -0,0 +1,350 @@
+package verify
+
+import (
+	"bytes"
+	"cmp"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Object storage URL schemes accepted wherever results are written
+const (
+	schemeS3  = "s3"
+	schemeGCS = "gs"
+)
+
+// gcsEndpoint is Google Cloud Storage's S3-compatible XML API, used with HMAC keys
+const gcsEndpoint = "https://storage.googleapis.com"
+
+// ObjectURL is an object in a bucket, written as s3://bucket/key or gs://bucket/key
+type ObjectURL struct {
+	Scheme string
+	Bucket string
+	Key    string
+}
+
+func (u ObjectURL) String() string {
+	return u.Scheme + "://" + u.Bucket + "/" + u.Key
+}
+
+// parseObjectURL reports whether name is an object storage URL and parses it
+func parseObjectURL(name string) (ObjectURL, bool) {
+	scheme, rest, ok := strings.Cut(name, "://")
+	if !ok || (scheme != schemeS3 && scheme != schemeGCS) {
+		return ObjectURL{}, false
+	}
+	bucket, key, _ := strings.Cut(rest, "/")
+	return ObjectURL{Scheme: scheme, Bucket: bucket, Key: key}, true
+}
+
+// ObjectStore is a minimal S3 multipart upload client signing requests with AWS Signature V4.
+// GCS is reached through its XML API, which accepts the same requests signed with HMAC keys.
+type ObjectStore struct {
+	endpoint     string // empty for AWS virtual-hosted URLs
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+// newObjectStore configures a client for the URL's scheme from the environment
+func newObjectStore(scheme string) (*ObjectStore, error) {
+	store := &ObjectStore{
+		endpoint:     strings.TrimSuffix(os.Getenv("S3_ENDPOINT"), "/"),
+		region:       cmp.Or(os.Getenv("AWS_REGION"), "us-east-1"),
+		accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		client:       &http.Client{Timeout: 5 * time.Minute},
+	}
+	if scheme == schemeGCS {
+		store.endpoint = strings.TrimSuffix(cmp.Or(os.Getenv("GCS_ENDPOINT"), gcsEndpoint), "/")
+		store.region = "auto"
+		store.accessKey = os.Getenv("GCS_HMAC_ACCESS_ID")
+		store.secretKey = os.Getenv("GCS_HMAC_SECRET")
+		store.sessionToken = ""
+	}
+	if store.accessKey == "" || store.secretKey == "" {
+		if scheme == schemeGCS {
+			return nil, errors.New("gs:// outputs require GCS_HMAC_ACCESS_ID and GCS_HMAC_SECRET to be set")
+		}
+		return nil, errors.New("s3:// outputs require AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY to be set")
+	}
+	return store, nil
+}
+
+// objectError is a failed object storage request; Retryable marks throttling, server errors and timeouts
+type objectError struct {
+	Status    int
+	Code      string
+	Message   string
+	Retryable bool
+}
+
+func (e *objectError) Error() string {
+	switch {
+	case e.Status == 0:
+		return "object storage request failed: " + e.Message
+	case e.Code != "":
+		return fmt.Sprintf("object storage returned %d %s: %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("object storage returned %d", e.Status)
+}
+
+// errNoSuchUpload is returned when a multipart upload was completed, aborted or expired
+var errNoSuchUpload = errors.New("multipart upload no longer exists")
+
+// objectURL returns the request URL for an object, path-style for custom endpoints
+func (s *ObjectStore) objectURL(obj ObjectURL, query url.Values) *url.URL {
+	u := &url.URL{Scheme: "https", Host: obj.Bucket + ".s3." + s.region + ".amazonaws.com", Path: "/" + obj.Key}
+	if s.endpoint != "" {
+		if parsed, err := url.Parse(s.endpoint); err == nil {
+			u.Scheme, u.Host = parsed.Scheme, parsed.Host
+			u.Path = strings.TrimSuffix(parsed.Path, "/") + "/" + obj.Bucket + "/" + obj.Key
+		}
+	}
+	// Keys are sent exactly as they are signed
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return u
+}
+
+// do signs and sends a request, returning the response body of a successful one
+func (s *ObjectStore) do(method string, obj ObjectURL, query url.Values, body []byte) (http.Header, []byte, error) {
+	req, err := http.NewRequest(method, s.objectURL(obj, query).String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build object storage request: %w", err)
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		// Connection resets and timeouts are the blips retries are for
+		return nil, nil, &objectError{Message: err.Error(), Retryable: true}
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, &objectError{Status: resp.StatusCode, Message: err.Error(), Retryable: true}
+	}
+	// CompleteMultipartUpload may fail after sending 200, with the error in the body
+	var failure struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string   `xml:"Code"`
+		Message string   `xml:"Message"`
+	}
+	failed := resp.StatusCode >= 300
+	if xml.Unmarshal(data, &failure) == nil {
+		failed = true
+	}
+	if !failed {
+		return resp.Header, data, nil
+	}
+	if failure.Code == "NoSuchUpload" {
+		return nil, nil, errNoSuchUpload
+	}
+	return nil, nil, &objectError{
+		Status:    resp.StatusCode,
+		Code:      failure.Code,
+		Message:   failure.Message,
+		Retryable: resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusRequestTimeout || failure.Code == "InternalError" || failure.Code == "SlowDown",
+	}
+}
+
+// CreateMultipartUpload starts an upload and returns its ID
+func (s *ObjectStore) CreateMultipartUpload(obj ObjectURL) (string, error) {
+	_, data, err := s.do(http.MethodPost, obj, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("failed to decode multipart upload ID: %s", data)
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads one part and returns its ETag
+func (s *ObjectStore) UploadPart(obj ObjectURL, uploadID string, number int, data []byte) (string, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	header, _, err := s.do(http.MethodPut, obj, query, data)
+	if err != nil {
+		return "", err
+	}
+	return header.Get("ETag"), nil
+}
+
+// ListParts returns the ETags of the parts the store already holds, by part number
+func (s *ObjectStore) ListParts(obj ObjectURL, uploadID string) (map[int]string, error) {
+	parts := make(map[int]string)
+	marker := ""
+	for {
+		query := url.Values{"uploadId": {uploadID}}
+		if marker != "" {
+			query.Set("part-number-marker", marker)
+		}
+		_, data, err := s.do(http.MethodGet, obj, query, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result struct {
+			Parts []struct {
+				PartNumber int    `xml:"PartNumber"`
+				ETag       string `xml:"ETag"`
+			} `xml:"Part"`
+			IsTruncated          bool   `xml:"IsTruncated"`
+			NextPartNumberMarker string `xml:"NextPartNumberMarker"`
+		}
+		if err := xml.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("failed to decode part list: %w", err)
+		}
+		for _, part := range result.Parts {
+			parts[part.PartNumber] = part.ETag
+		}
+		if !result.IsTruncated || result.NextPartNumberMarker == "" {
+			return parts, nil
+		}
+		marker = result.NextPartNumberMarker
+	}
+}
+
+// CompleteMultipartUpload assembles the parts into the object
+func (s *ObjectStore) CompleteMultipartUpload(obj ObjectURL, uploadID string, parts map[int]string) error {
+	type completedPart struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	}
+	var body struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}
+	for number, etag := range parts {
+		body.Parts = append(body.Parts, completedPart{PartNumber: number, ETag: etag})
+	}
+	sort.Slice(body.Parts, func(i, j int) bool { return body.Parts[i].PartNumber < body.Parts[j].PartNumber })
+
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode completed parts: %w", err)
+	}
+	_, _, err = s.do(http.MethodPost, obj, url.Values{"uploadId": {uploadID}}, data)
+	return err
+}
+
+// AbortMultipartUpload discards an upload's parts
+func (s *ObjectStore) AbortMultipartUpload(obj ObjectURL, uploadID string) error {
+	_, _, err := s.do(http.MethodDelete, obj, url.Values{"uploadId": {uploadID}}, nil)
+	return err
+}
+
+// GetObject downloads an object
+func (s *ObjectStore) GetObject(obj ObjectURL) ([]byte, error) {
+	_, data, err := s.do(http.MethodGet, obj, nil, nil)
+	return data, err
+}
+
+// sign adds AWS Signature V4 headers to a request
+func (s *ObjectStore) sign(req *http.Request, body []byte, now time.Time) {
+	payloadHash := sha256Hex(body)
+	amzDate := now.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	signed := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.sessionToken != "" {
+		signed = append(signed, "x-amz-security-token")
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := req.Host
+		if name != "host" {
+			value = req.Header.Get(name)
+		}
+		headers.WriteString(name + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(signed, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		headers.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	for _, part := range []string{s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes query parameters sorted by name, as signing requires
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and slashes unless encodeSlash
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
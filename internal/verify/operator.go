@@ -0,0 +1,430 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+)
+
+// VerificationJob custom resource, served by the CRD in deploy/kubernetes
+const (
+	crdGroup   = "email-verification.io"
+	crdVersion = "v1alpha1"
+	crdAPI     = "/apis/" + crdGroup + "/" + crdVersion
+	jobLabel   = crdGroup + "/job"
+)
+
+// VerificationJob phases
+const (
+	phasePending   = "Pending"
+	phaseRunning   = "Running"
+	phaseSucceeded = "Succeeded"
+	phaseFailed    = "Failed"
+)
+
+// VerificationJob is a batch verification declared as a Kubernetes resource
+type VerificationJob struct {
+	Metadata struct {
+		Name      string `json:"name"`
+		UID       string `json:"uid"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec   VerificationJobSpec   `json:"spec"`
+	Status VerificationJobStatus `json:"status"`
+}
+
+// VerificationJobSpec says where the addresses come from, where the results go, and how many
+// worker pods verify them with which settings
+type VerificationJobSpec struct {
+	Input          string        `json:"input,omitempty"` // s3:// or gs:// URL of an input document
+	InputConfigMap *ConfigMapKey `json:"inputConfigMap,omitempty"`
+	Output         string        `json:"output,omitempty"` // s3:// or gs:// URL, or a path on the coordinator
+	Workers        int           `json:"workers,omitempty"`
+	Concurrency    int           `json:"concurrency,omitempty"`
+	Rate           string        `json:"rate,omitempty"`
+	SMTP           *bool         `json:"smtp,omitempty"`
+}
+
+// ConfigMapKey is an input document held in a ConfigMap
+type ConfigMapKey struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// VerificationJobStatus is written back by the operator. Every field is sent on each update, so
+// merge patches clear what no longer applies.
+type VerificationJobStatus struct {
+	Phase      string     `json:"phase"`
+	JobID      string     `json:"jobID"`
+	Total      int        `json:"total"`
+	Checked    int64      `json:"checked"`
+	Invalid    int64      `json:"invalid"`
+	Risky      int64      `json:"risky"`
+	WorkerPods int        `json:"workerPods"`
+	Message    string     `json:"message"`
+	StartedAt  *time.Time `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt"`
+}
+
+// OperatorOptions configures the worker pods the operator spawns
+type OperatorOptions struct {
+	Resync          time.Duration
+	ServiceURL      string // how worker pods reach this server
+	WorkerImage     string
+	WorkerEnvSecret string // Secret whose keys become the worker pods' environment
+}
+
+// Operator turns VerificationJob resources into server jobs, runs worker pods for them while they
+// are in progress and writes their progress back to the resource's status
+type Operator struct {
+	kube    *kubeClient
+	jobs    *JobManager
+	config  Config
+	limits  JobLimits
+	opts    OperatorOptions
+	uploads UploadOptions
+
+	running map[string]string // resource UID -> job ID
+}
+
+func newOperator(kube *kubeClient, jobs *JobManager, config Config, limits JobLimits, opts OperatorOptions) *Operator {
+	return &Operator{
+		kube:    kube,
+		jobs:    jobs,
+		config:  config,
+		limits:  limits,
+		opts:    opts,
+		uploads: UploadOptions{PartSizeMB: config.UploadPartSize, Retries: config.UploadRetries},
+		running: make(map[string]string),
+	}
+}
+
+// Run reconciles every VerificationJob in the namespace once per resync period
+func (o *Operator) Run() {
+	slog.Info("watching VerificationJobs", "namespace", o.kube.namespace, "resync", o.opts.Resync)
+	for {
+		if err := o.reconcile(); err != nil {
+			slog.Warn("failed to reconcile VerificationJobs", "error", err)
+		}
+		time.Sleep(o.opts.Resync)
+	}
+}
+
+func (o *Operator) reconcile() error {
+	var list struct {
+		Items []VerificationJob `json:"items"`
+	}
+	if err := o.kube.get(o.kube.namespaced(crdAPI, "verificationjobs"), &list); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for i := range list.Items {
+		vj := &list.Items[i]
+		seen[vj.Metadata.UID] = true
+		if err := o.reconcileJob(vj); err != nil {
+			slog.Warn("failed to reconcile VerificationJob", "verification_job", vj.Metadata.Name, "error", err)
+		}
+	}
+
+	// Worker pods of deleted resources are garbage collected through their owner references
+	for uid, id := range o.running {
+		if !seen[uid] {
+			delete(o.running, uid)
+			if o.jobs.work.Cancel(id) {
+				slog.Info("cancelled job of a deleted VerificationJob", "job", id)
+			}
+		}
+	}
+	return nil
+}
+
+func (o *Operator) reconcileJob(vj *VerificationJob) error {
+	switch vj.Status.Phase {
+	case "", phasePending:
+		return o.start(vj)
+	case phaseRunning:
+		return o.track(vj)
+	default:
+		return o.scaleWorkers(vj, 0)
+	}
+}
+
+// start submits a pending resource's addresses as a job. A job turned away for lack of capacity
+// stays pending and is submitted again on a later resync.
+func (o *Operator) start(vj *VerificationJob) error {
+	if id, ok := o.running[vj.Metadata.UID]; ok {
+		// Submitted on an earlier resync whose status update didn't make it
+		vj.Status.JobID = id
+		return o.track(vj)
+	}
+	query := url.Values{}
+	if vj.Spec.Concurrency > 0 {
+		query.Set("workers", strconv.Itoa(vj.Spec.Concurrency))
+	}
+	if vj.Spec.Rate != "" {
+		query.Set("rate", vj.Spec.Rate)
+	}
+	if vj.Spec.SMTP != nil {
+		query.Set("smtp", strconv.FormatBool(*vj.Spec.SMTP))
+	}
+	options, err := parseJobOptions(query, o.config, o.limits)
+	if err != nil {
+		return o.finish(vj, VerificationJobStatus{Phase: phaseFailed, Message: "invalid spec: " + err.Error()})
+	}
+	emails, err := o.readInput(vj.Spec)
+	if err != nil {
+		return o.finish(vj, VerificationJobStatus{Phase: phaseFailed, Message: err.Error()})
+	}
+
+	status, err := o.jobs.Submit(emails, options)
+	var admission *admissionError
+	if errors.As(err, &admission) {
+		message := fmt.Sprintf("waiting for capacity: %v", err)
+		if vj.Status.Phase == phasePending && vj.Status.Message == message {
+			return nil
+		}
+		return o.setStatus(vj, VerificationJobStatus{Phase: phasePending, Message: message})
+	}
+	if err != nil {
+		return o.finish(vj, VerificationJobStatus{Phase: phaseFailed, Message: err.Error()})
+	}
+
+	slog.Info("VerificationJob started job", "verification_job", vj.Metadata.Name, "job", status.ID, "emails", status.Total)
+	o.running[vj.Metadata.UID] = status.ID
+	now := time.Now()
+	if err := o.setStatus(vj, VerificationJobStatus{Phase: phaseRunning, JobID: status.ID, Total: status.Total, StartedAt: &now}); err != nil {
+		return err
+	}
+	return o.track(vj)
+}
+
+// track keeps a running resource's worker pods up and its status current, and publishes the
+// results once the job finishes
+func (o *Operator) track(vj *VerificationJob) error {
+	id := vj.Status.JobID
+	job, ok := o.jobs.Status(id)
+	if !ok {
+		// The server restarted, or the job expired; its addresses have to be submitted again
+		delete(o.running, vj.Metadata.UID)
+		return o.setStatus(vj, VerificationJobStatus{Phase: phasePending, Message: "job " + id + " was lost; resubmitting"})
+	}
+	o.running[vj.Metadata.UID] = id
+
+	status := vj.Status
+	status.Total, status.Checked, status.Invalid, status.Risky = job.Total, job.Checked, job.Invalid, job.Risky
+	switch job.Status {
+	case jobFailed:
+		status.Phase, status.Message = phaseFailed, job.Error
+		return o.finish(vj, status)
+	case jobDone:
+		status.Phase, status.Message = phaseSucceeded, ""
+		if err := o.writeOutput(vj.Spec.Output, id); err != nil {
+			status.Phase, status.Message = phaseFailed, err.Error()
+		}
+		return o.finish(vj, status)
+	}
+
+	status.Phase = phaseRunning
+	pods, err := o.ensureWorkers(vj, max(vj.Spec.Workers, 1))
+	if err != nil {
+		status.Message = err.Error()
+	} else {
+		status.WorkerPods, status.Message = pods, ""
+	}
+	if status == vj.Status {
+		return nil
+	}
+	return o.setStatus(vj, status)
+}
+
+// finish records a terminal status and stops the resource's worker pods
+func (o *Operator) finish(vj *VerificationJob, status VerificationJobStatus) error {
+	delete(o.running, vj.Metadata.UID)
+	now := time.Now()
+	status.FinishedAt, status.WorkerPods = &now, 0
+	if status.Phase == phaseSucceeded {
+		slog.Info("VerificationJob succeeded", "verification_job", vj.Metadata.Name, "checked", status.Checked, "invalid", status.Invalid)
+	} else {
+		slog.Error("VerificationJob failed", "verification_job", vj.Metadata.Name, "error", status.Message)
+	}
+	if err := o.setStatus(vj, status); err != nil {
+		return err
+	}
+	return o.scaleWorkers(vj, 0)
+}
+
+func (o *Operator) setStatus(vj *VerificationJob, status VerificationJobStatus) error {
+	path := o.kube.namespaced(crdAPI, "verificationjobs") + "/" + vj.Metadata.Name + "/status"
+	if err := o.kube.mergePatch(path, map[string]any{"status": status}); err != nil {
+		return fmt.Errorf("failed to update status: %w", err)
+	}
+	vj.Status = status
+	return nil
+}
+
+// readInput fetches the addresses of a resource's input document
+func (o *Operator) readInput(spec VerificationJobSpec) ([]string, error) {
+	var data []byte
+	switch {
+	case spec.InputConfigMap != nil:
+		var cm struct {
+			Data map[string]string `json:"data"`
+		}
+		path := o.kube.namespaced("/api/v1", "configmaps") + "/" + spec.InputConfigMap.Name
+		if err := o.kube.get(path, &cm); err != nil {
+			return nil, fmt.Errorf("failed to read ConfigMap %s: %w", spec.InputConfigMap.Name, err)
+		}
+		value, ok := cm.Data[spec.InputConfigMap.Key]
+		if !ok {
+			return nil, fmt.Errorf("ConfigMap %s has no key %q", spec.InputConfigMap.Name, spec.InputConfigMap.Key)
+		}
+		data = []byte(value)
+	case spec.Input != "":
+		obj, ok := parseObjectURL(spec.Input)
+		if !ok || obj.Bucket == "" || obj.Key == "" {
+			return nil, fmt.Errorf("invalid input %q, expected s3://bucket/key or gs://bucket/key", spec.Input)
+		}
+		store, err := newObjectStore(obj.Scheme)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = store.GetObject(obj); err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", obj, err)
+		}
+	default:
+		return nil, errors.New("spec needs an input or inputConfigMap")
+	}
+
+	emails, err := decodeEmails(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	return emails, nil
+}
+
+// writeOutput writes a finished job's results to the resource's output, if it has one
+func (o *Operator) writeOutput(output, id string) error {
+	if output == "" {
+		return nil
+	}
+	results, _, ok := o.jobs.Results(id)
+	if !ok {
+		return fmt.Errorf("results of job %s are gone", id)
+	}
+	if err := checkObjectOutputs(output); err != nil {
+		return err
+	}
+	if err := os.WriteFile(stagedOutput(output), results, 0644); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	if err := publishOutput(output, o.uploads); err != nil {
+		return fmt.Errorf("failed to upload output: %w", err)
+	}
+	return nil
+}
+
+// kubePod is the part of a pod the operator looks at
+type kubePod struct {
+	Metadata struct {
+		Name              string     `json:"name"`
+		DeletionTimestamp *time.Time `json:"deletionTimestamp"`
+	} `json:"metadata"`
+	Status struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// workerPods lists a resource's worker pods that are neither finished nor being deleted
+func (o *Operator) workerPods(vj *VerificationJob) ([]kubePod, error) {
+	var list struct {
+		Items []kubePod `json:"items"`
+	}
+	query := url.Values{"labelSelector": {jobLabel + "=" + vj.Metadata.Name}}
+	if err := o.kube.get(o.kube.namespaced("/api/v1", "pods")+"?"+query.Encode(), &list); err != nil {
+		return nil, fmt.Errorf("failed to list worker pods: %w", err)
+	}
+	var live []kubePod
+	for _, pod := range list.Items {
+		if pod.Metadata.DeletionTimestamp == nil && pod.Status.Phase != "Succeeded" && pod.Status.Phase != "Failed" {
+			live = append(live, pod)
+		}
+	}
+	return live, nil
+}
+
+// ensureWorkers creates worker pods until the resource has want of them, returning how many it has
+func (o *Operator) ensureWorkers(vj *VerificationJob, want int) (int, error) {
+	pods, err := o.workerPods(vj)
+	if err != nil {
+		return 0, err
+	}
+	for have := len(pods); have < want; have++ {
+		if err := o.kube.create(o.kube.namespaced("/api/v1", "pods"), o.workerPod(vj), nil); err != nil {
+			return have, fmt.Errorf("failed to create worker pod: %w", err)
+		}
+	}
+	if len(pods) < want {
+		slog.Info("started worker pods", "verification_job", vj.Metadata.Name, "pods", want-len(pods))
+	}
+	return max(len(pods), want), nil
+}
+
+// scaleWorkers deletes worker pods beyond want; a finished resource keeps none
+func (o *Operator) scaleWorkers(vj *VerificationJob, want int) error {
+	pods, err := o.workerPods(vj)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods[min(want, len(pods)):] {
+		if err := o.kube.delete(o.kube.namespaced("/api/v1", "pods") + "/" + pod.Metadata.Name); err != nil && !errors.Is(err, errKubeNotFound) {
+			return fmt.Errorf("failed to delete worker pod %s: %w", pod.Metadata.Name, err)
+		}
+	}
+	return nil
+}
+
+// workerPod is the manifest of a worker pod owned by the resource, so deleting it removes its pods
+func (o *Operator) workerPod(vj *VerificationJob) map[string]any {
+	container := map[string]any{
+		"name":  "worker",
+		"image": o.opts.WorkerImage,
+		"args":  []string{"worker"},
+		"env": []map[string]any{
+			{"name": "SERVER_URL", "value": o.opts.ServiceURL},
+			{"name": "WORKER_NAME", "valueFrom": map[string]any{"fieldRef": map[string]string{"fieldPath": "metadata.name"}}},
+		},
+	}
+	if o.opts.WorkerEnvSecret != "" {
+		container["envFrom"] = []map[string]any{{"secretRef": map[string]string{"name": o.opts.WorkerEnvSecret}}}
+	}
+	return map[string]any{
+		"apiVersion": "v1",
+		"kind":       "Pod",
+		"metadata": map[string]any{
+			"generateName": vj.Metadata.Name + "-worker-",
+			"labels": map[string]string{
+				"app.kubernetes.io/name":      "email-verification",
+				"app.kubernetes.io/component": "worker",
+				jobLabel:                      vj.Metadata.Name,
+			},
+			"ownerReferences": []map[string]any{{
+				"apiVersion":         crdGroup + "/" + crdVersion,
+				"kind":               "VerificationJob",
+				"name":               vj.Metadata.Name,
+				"uid":                vj.Metadata.UID,
+				"controller":         true,
+				"blockOwnerDeletion": true,
+			}},
+		},
+		"spec": map[string]any{
+			"restartPolicy": "Always",
+			"containers":    []map[string]any{container},
+		},
+	}
+}
@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Output sort keys; without one results are written in completion order
+const (
+	sortByReason = "reason"
+	sortByDomain = "domain"
+	sortByEmail  = "email"
+)
+
+// groupByDomain nests the output under each address's domain
+const groupByDomain = "domain"
+
+// validSortKey reports whether key is a known sort key, or empty for completion order
+func validSortKey(key string) bool {
+	return key == "" || key == sortByReason || key == sortByDomain || key == sortByEmail
+}
+
+// validGroupKey reports whether key is a known grouping, or empty for a flat list
+func validGroupKey(key string) bool {
+	return key == "" || key == groupByDomain
+}
+
+// outputOrder compares two entries by email and reason for the sort and group options.
+// Grouping sorts by domain first; ties fall back to the address so output is reproducible.
+func outputOrder(sortBy, groupBy string) func(aEmail, aReason, bEmail, bReason string) int {
+	return func(aEmail, aReason, bEmail, bReason string) int {
+		if groupBy == groupByDomain || sortBy == sortByDomain {
+			if c := cmp.Compare(emailDomain(aEmail), emailDomain(bEmail)); c != 0 {
+				return c
+			}
+		}
+		if sortBy == sortByReason {
+			if c := cmp.Compare(aReason, bReason); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(aEmail, bEmail)
+	}
+}
+
+// sortOutput orders the invalid emails and details in place, unless no sort or grouping was asked for
+func sortOutput(invalid []InvalidEmail, details []EmailResult, config Config) {
+	if config.SortBy == "" && config.GroupBy == "" {
+		return
+	}
+	order := outputOrder(config.SortBy, config.GroupBy)
+	slices.SortStableFunc(invalid, func(a, b InvalidEmail) int {
+		return order(a.Email, a.Reason, b.Email, b.Reason)
+	})
+	slices.SortStableFunc(details, func(a, b EmailResult) int {
+		return order(a.Email, a.Reason, b.Email, b.Reason)
+	})
+}
+
+// sortValid orders the valid emails the same way, unless no sort or grouping was asked for
+func sortValid(valid []string, config Config) {
+	if config.SortBy == "" && config.GroupBy == "" {
+		return
+	}
+	order := outputOrder(config.SortBy, config.GroupBy)
+	slices.SortStableFunc(valid, func(a, b string) int {
+		return order(a, "", b, "")
+	})
+}
+
+// DomainGroup is the output entries of one domain
+type DomainGroup[T any] struct {
+	Domain  string
+	Entries []T
+}
+
+// groupDomains splits entries sorted by domain into one group per domain
+func groupDomains[T any](entries []T, email func(T) string) []DomainGroup[T] {
+	var groups []DomainGroup[T]
+	for _, entry := range entries {
+		domain := emailDomain(email(entry))
+		if len(groups) == 0 || groups[len(groups)-1].Domain != domain {
+			groups = append(groups, DomainGroup[T]{Domain: domain})
+		}
+		last := &groups[len(groups)-1]
+		last.Entries = append(last.Entries, entry)
+	}
+	return groups
+}
+
+// invalidGroups groups the invalid emails by domain for the output writers
+func invalidGroups(invalid []InvalidEmail) []DomainGroup[InvalidEmail] {
+	return groupDomains(invalid, func(e InvalidEmail) string { return e.Email })
+}
+
+// resultGroups groups the details by domain for the output writers
+func resultGroups(details []EmailResult) []DomainGroup[EmailResult] {
+	return groupDomains(details, func(r EmailResult) string { return r.Email })
+}
+
+// writeEntries writes one entry per line into the open array
+func writeEntries[T any](stream *jsonStream, entries []T) {
+	for _, entry := range entries {
+		stream.Value(entry)
+	}
+}
+
+// writeDomainGroups writes the entries nested under their domains as a "domains" array, with the
+// entries of each domain under field
+func writeDomainGroups[T any](stream *jsonStream, field string, groups []DomainGroup[T]) {
+	stream.Key("domains")
+	stream.BeginArray(false)
+	for _, group := range groups {
+		stream.BeginObject(true)
+		stream.Field("domain", group.Domain)
+		stream.Field("count", len(group.Entries))
+		stream.Key(field)
+		stream.BeginArray(false)
+		writeEntries(stream, group.Entries)
+		stream.End()
+		stream.End()
+	}
+	stream.End()
+}
@@ -0,0 +1,58 @@
+package verify
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Output formats
+const (
+	outputAuto  = "auto"
+	outputJSON  = "json"
+	outputJSONL = "jsonl"
+	outputCSV   = "csv"
+	outputTSV   = "tsv"
+)
+
+// validOutputFormat reports whether format is a known output format
+func validOutputFormat(format string) bool {
+	switch format {
+	case outputAuto, outputJSON, outputJSONL, outputCSV, outputTSV:
+		return true
+	}
+	return false
+}
+
+// outputFormatFor resolves the auto format from the file's extension, defaulting to the JSON
+// document. Stdout has no extension; it gets JSON Lines.
+func outputFormatFor(filename, format string) string {
+	if format != outputAuto {
+		return format
+	}
+	if filename == StdoutOutput || isJSONLines(filename) {
+		return outputJSONL
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return outputCSV
+	case ".tsv":
+		return outputTSV
+	}
+	return outputJSON
+}
+
+// formatDelimiter returns the delimiter of a CSV or TSV output format
+func formatDelimiter(format string) (rune, bool) {
+	switch format {
+	case outputCSV:
+		return ',', true
+	case outputTSV:
+		return '\t', true
+	}
+	return 0, false
+}
+
+// formatOf returns the format the output or details file is written in
+func (c Config) formatOf(filename string) string {
+	return outputFormatFor(filename, c.OutputFileFormat)
+}
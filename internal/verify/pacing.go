@@ -0,0 +1,49 @@
+package verify
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// CatchUpPacer ramps verification back up after a pause, such as a blocklisted egress IP or the
+// wait for greylisted addresses. Resuming every worker at once sends a burst of probes that
+// providers penalize, so workers rejoin one at a time over the ramp-up period instead.
+type CatchUpPacer struct {
+	ramp time.Duration
+
+	mu        sync.Mutex
+	resumedAt time.Time
+}
+
+func newCatchUpPacer(ramp time.Duration) *CatchUpPacer {
+	return &CatchUpPacer{ramp: ramp}
+}
+
+// Resume starts a ramp-up, unless one is already under way
+func (p *CatchUpPacer) Resume() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	if now.Before(p.resumedAt.Add(p.ramp)) {
+		return
+	}
+	p.resumedAt = now
+	slog.Info("ramping verification back up after the pause", "ramp_up", p.ramp)
+}
+
+// Wait blocks worker id of workers until its turn to rejoin during a ramp-up: the first worker
+// right away, the last one just before the ramp-up ends
+func (p *CatchUpPacer) Wait(ctx context.Context, id, workers int) {
+	if p == nil || workers <= 0 {
+		return
+	}
+	p.mu.Lock()
+	rejoin := p.resumedAt.Add(p.ramp * time.Duration(id) / time.Duration(workers))
+	p.mu.Unlock()
+	sleepContext(ctx, time.Until(rejoin))
+}
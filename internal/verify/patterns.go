@@ -0,0 +1,274 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"maps"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// minPatternSamples is how many verified addresses a domain needs before its pattern is inferred
+const minPatternSamples = 3
+
+// patternOther is any local part that doesn't look like a name
+const patternOther = "other"
+
+// firstNames tells given names apart from surnames and initials in local parts
+var firstNames = loadFirstNames()
+
+func loadFirstNames() map[string]bool {
+	names := make(map[string]bool)
+	if err := readDomainList(builtinLists, "lists/names/first.txt", names); err != nil {
+		slog.Warn("failed to load first names", "error", err)
+	}
+	return names
+}
+
+// classifyLocalPart describes the shape of a local part as a pattern such as first.last or flast
+func classifyLocalPart(local string) string {
+	local = strings.ToLower(local)
+	if plus := strings.IndexByte(local, '+'); plus >= 0 {
+		local = local[:plus]
+	}
+
+	sep := ""
+	for _, r := range local {
+		switch {
+		case r >= 'a' && r <= 'z':
+		case r == '.' || r == '_' || r == '-':
+			if sep != "" && sep != string(r) {
+				return patternOther
+			}
+			sep = string(r)
+		default:
+			return patternOther
+		}
+	}
+
+	if sep != "" {
+		parts := strings.Split(local, sep)
+		if len(parts) != 2 {
+			return patternOther
+		}
+		a, b := parts[0], parts[1]
+		switch {
+		case len(a) == 1 && len(b) >= 2:
+			return "f" + sep + "last"
+		case len(a) >= 2 && len(b) == 1:
+			return "first" + sep + "l"
+		case len(a) >= 2 && len(b) >= 2:
+			if firstNames[b] && !firstNames[a] {
+				return "last" + sep + "first"
+			}
+			return "first" + sep + "last"
+		}
+		return patternOther
+	}
+
+	if firstNames[local] {
+		return "first"
+	}
+	// The longest given name the local part starts with
+	for i := len(local) - 1; i >= 3; i-- {
+		if !firstNames[local[:i]] {
+			continue
+		}
+		if len(local)-i == 1 {
+			return "firstl"
+		}
+		return "firstlast"
+	}
+	if len(local) >= 4 && len(local) <= 10 {
+		return "flast"
+	}
+	return patternOther
+}
+
+// PatternCounts counts verified addresses per local part pattern
+type PatternCounts map[string]int
+
+// DomainPattern is the address pattern inferred for a domain
+type DomainPattern struct {
+	Domain  string        `json:"domain"`
+	Pattern string        `json:"pattern"`
+	Share   float64       `json:"share"`
+	Samples int           `json:"samples"`
+	Counts  PatternCounts `json:"counts"`
+}
+
+// Infer returns the most common pattern, or nil with too few samples to tell
+func (c PatternCounts) Infer() *DomainPattern {
+	var total int
+	best := ""
+	for pattern, n := range c {
+		total += n
+		if pattern != patternOther && (n > c[best] || n == c[best] && pattern < best) {
+			best = pattern
+		}
+	}
+	if total < minPatternSamples || best == "" {
+		return nil
+	}
+	return &DomainPattern{
+		Pattern: best,
+		Share:   roundShare(float64(c[best]) / float64(total)),
+		Samples: total,
+		Counts:  maps.Clone(c),
+	}
+}
+
+// PatternMatch scores how well an unverifiable address fits its domain's pattern
+type PatternMatch struct {
+	Pattern  string  `json:"pattern"`
+	Inferred string  `json:"inferred_pattern"`
+	Score    float64 `json:"score"`
+	Samples  int     `json:"samples"`
+}
+
+// EmailPatterns learns address patterns per corporate domain from verified addresses.
+// Counts from earlier runs come from the domain store, and this run's are added to it by Persist.
+type EmailPatterns struct {
+	store *DomainStore
+
+	mu      sync.Mutex
+	learned map[string]PatternCounts
+}
+
+func newEmailPatterns(store *DomainStore) *EmailPatterns {
+	return &EmailPatterns{store: store, learned: make(map[string]PatternCounts)}
+}
+
+// patternEvidence reports whether a result verified a personal mailbox on a corporate domain,
+// or left one unverifiable because SMTP was skipped, failed or hit a catch-all
+func patternEvidence(result *emailverifier.Result) (verified, unverifiable bool) {
+	if result == nil || !result.Syntax.Valid || !result.HasMxRecords || result.Free || result.RoleAccount || result.Disposable {
+		return false, false
+	}
+	if result.SMTP != nil && result.SMTP.HostExists && !result.SMTP.CatchAll {
+		return result.SMTP.Deliverable, false
+	}
+	return false, true
+}
+
+// Learn counts a verified address's pattern for its domain
+func (p *EmailPatterns) Learn(email string) {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return
+	}
+	domain := emailDomain(email)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	counts, ok := p.learned[domain]
+	if !ok {
+		counts = make(PatternCounts)
+		p.learned[domain] = counts
+	}
+	counts[classifyLocalPart(email[:at])]++
+}
+
+// counts combines the store's counts for a domain with this run's
+func (p *EmailPatterns) counts(domain string) PatternCounts {
+	counts := make(PatternCounts)
+	if p.store != nil {
+		if intel, ok := p.store.Get(domain); ok {
+			maps.Copy(counts, intel.Patterns)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for pattern, n := range p.learned[domain] {
+		counts[pattern] += n
+	}
+	return counts
+}
+
+// Infer returns the pattern inferred for a domain, or nil if there isn't enough evidence
+func (p *EmailPatterns) Infer(domain string) *DomainPattern {
+	inferred := p.counts(domain).Infer()
+	if inferred != nil {
+		inferred.Domain = domain
+	}
+	return inferred
+}
+
+// Score rates an address by the share of its domain's verified addresses that use the same pattern
+func (p *EmailPatterns) Score(email string) *PatternMatch {
+	at := strings.LastIndexByte(email, '@')
+	if at <= 0 {
+		return nil
+	}
+	counts := p.counts(emailDomain(email))
+	inferred := counts.Infer()
+	if inferred == nil {
+		return nil
+	}
+
+	pattern := classifyLocalPart(email[:at])
+	match := &PatternMatch{Pattern: pattern, Inferred: inferred.Pattern, Samples: inferred.Samples}
+	if pattern != patternOther {
+		match.Score = roundShare(float64(counts[pattern]) / float64(inferred.Samples))
+	}
+	return match
+}
+
+// Report returns the inferred pattern of every domain this run learned from, sorted by domain
+func (p *EmailPatterns) Report() []DomainPattern {
+	p.mu.Lock()
+	domains := make([]string, 0, len(p.learned))
+	for domain := range p.learned {
+		domains = append(domains, domain)
+	}
+	p.mu.Unlock()
+	sort.Strings(domains)
+
+	report := make([]DomainPattern, 0, len(domains))
+	for _, domain := range domains {
+		if inferred := p.Infer(domain); inferred != nil {
+			report = append(report, *inferred)
+		}
+	}
+	return report
+}
+
+// Persist adds this run's counts to the domain store so later runs and the /domains API see them
+func (p *EmailPatterns) Persist() {
+	if p.store == nil {
+		return
+	}
+
+	p.mu.Lock()
+	learned := p.learned
+	p.learned = make(map[string]PatternCounts)
+	p.mu.Unlock()
+
+	for domain, counts := range learned {
+		p.store.AddPatterns(domain, counts)
+	}
+}
+
+// writePatternReport writes the inferred patterns as a JSON array
+func writePatternReport(filename string, report []DomainPattern) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode pattern report: %w", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write pattern report: %w", err)
+	}
+	return nil
+}
+
+// roundShare rounds a fraction to two decimals
+func roundShare(share float64) float64 {
+	return math.Round(share*100) / 100
+}
@@ -0,0 +1,691 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// processEmails verifies the emails, returning the invalid ones, the details if wanted and the
+// valid ones if wanted. Given an output, invalid emails are written to it as they come in rather
+// than returned. Given a checkpoint, every result is journaled to it, and addresses it already
+// holds are replayed from it instead of verified again. Failing to write either stops the run.
+func processEmails(ctx context.Context, emails []string, config Config, lookups *Lookups, stats *Stats, output ResultWriter, checkpoint *RunCheckpoint) ([]InvalidEmail, []EmailResult, []string, []string, error) {
+	totalEmails := len(emails)
+	pending, resumed := emails, 0
+	if checkpoint != nil && checkpoint.Resumed() > 0 {
+		resumed = checkpoint.Resumed()
+		pending = make([]string, 0, len(emails)-resumed)
+		for i, email := range emails {
+			if !checkpoint.Done(i) {
+				pending = append(pending, email)
+			}
+		}
+	}
+
+	// The first failure to journal or write a result stops the run
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var failed error
+	var failOnce sync.Once
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failed = err
+			cancel()
+		})
+	}
+
+	// Create channels
+	jobs := make(chan EmailJob, config.Workers*2)
+	results := make(chan verifiedEmail, config.Workers*2)
+
+	if stats.Usage == nil {
+		stats.Usage = newUtilization(config.Workers)
+	}
+
+	// Addresses are verified as repaired
+	verified := pending
+	if config.Repair == repairAuto {
+		verified = make([]string, len(pending))
+		for i, email := range pending {
+			verified[i], _ = repairInput(email, config.Repair)
+		}
+	}
+
+	// Aliases of the same mailbox share one SMTP probe
+	var probes *ProbeCache
+	if config.EnableSMTP && config.DedupeProbes {
+		probes = newProbeCache(verified)
+	}
+
+	// Addresses on the same domain share its MX lookup and catch-all probe
+	dnsHits, dnsMisses := lookups.DNS.Stats()
+	var domains *DomainCache
+	if config.DomainCache {
+		domains = newDomainCache(verified)
+	}
+
+	// Domains past -max-per-domain probes are deferred to a later run
+	var quota *DomainQuota
+	if config.EnableSMTP && config.MaxPerDomain > 0 {
+		quota = newDomainQuota(config.MaxPerDomain)
+	}
+
+	// Greylisted addresses are tried again once the server is likely to accept them
+	var greylist *GreylistQueue
+	if config.EnableSMTP && config.GreylistRetry > 0 {
+		greylist = newGreylistQueue(config.GreylistRetry)
+	}
+
+	// Create worker pool
+	var wg sync.WaitGroup
+	for i := 0; i < config.Workers; i++ {
+		wg.Add(1)
+		go worker(ctx, i, jobs, results, config, lookups, probes, domains, quota, greylist, stats.Usage, &wg)
+	}
+
+	// Start result collector
+	var invalidEmails []InvalidEmail
+	var details []EmailResult
+	var valid, free []string
+	var unverifiable []int
+	var invalidMu sync.Mutex
+	var collectorWg sync.WaitGroup
+	collectorWg.Add(1)
+
+	go func() {
+		defer collectorWg.Done()
+		lastReport := time.Now()
+		eta := newETAModel(pending, lookups)
+
+		for verified := range results {
+			result := verified.EmailResult
+			if !verified.resumed {
+				eta.Done(verified.domain, verified.elapsed)
+				atomic.AddInt64(&stats.Retries, int64(verified.retries))
+				if checkpoint != nil {
+					if err := checkpoint.Record(verified.index, result); err != nil {
+						fail(fmt.Errorf("failed to write checkpoint: %w", err))
+					}
+				}
+			}
+
+			if lookups.Patterns != nil && verified.verified {
+				lookups.Patterns.Learn(result.Email)
+			}
+			result.index = verified.index
+			if config.wantsDetails() {
+				if config.PatternScore && verified.unverifiable {
+					unverifiable = append(unverifiable, len(details))
+				}
+				details = append(details, result)
+			}
+			// Sinks already got resumed results the first time round
+			for _, sink := range lookups.Sinks {
+				if verified.resumed {
+					break
+				}
+				if err := sink.Write(result); err != nil && config.Verbose {
+					slog.Debug("sink failed", "email", result.Email, "error", err)
+				}
+			}
+			if config.onResult != nil {
+				config.onResult(result)
+			}
+
+			if result.Policy != "" {
+				atomic.AddInt64(&stats.NotProbed, 1)
+			}
+			if result.CatchAllDomain {
+				atomic.AddInt64(&stats.CatchAll, 1)
+			}
+			if result.RoleAccount {
+				atomic.AddInt64(&stats.RoleAccounts, 1)
+			}
+			if result.Free {
+				atomic.AddInt64(&stats.Free, 1)
+			}
+			if result.Allowlisted {
+				atomic.AddInt64(&stats.Allowlisted, 1)
+			} else if result.Reason == blocklistedReason {
+				atomic.AddInt64(&stats.Blocklisted, 1)
+			}
+			switch result.Action {
+			case actionDelete:
+				atomic.AddInt64(&stats.ToDelete, 1)
+			case actionQuarantine:
+				atomic.AddInt64(&stats.ToQuarantine, 1)
+			case actionRetry:
+				atomic.AddInt64(&stats.ToRetry, 1)
+			}
+			if result.IsValid {
+				atomic.AddInt64(&stats.TotalValid, 1)
+				if config.FreeFile != "" && result.Free {
+					free = append(free, result.Email)
+				} else if config.ValidFile != "" {
+					valid = append(valid, result.Email)
+				}
+			} else {
+				if result.Risky {
+					atomic.AddInt64(&stats.TotalRisky, 1)
+				} else {
+					atomic.AddInt64(&stats.TotalInvalid, 1)
+				}
+				if result.Greylisted {
+					atomic.AddInt64(&stats.Greylisted, 1)
+				}
+				if result.Deferred {
+					atomic.AddInt64(&stats.Deferred, 1)
+				}
+				invalid := InvalidEmail{
+					Email:       result.Email,
+					Reason:      result.Reason,
+					Risky:       result.Risky,
+					ExpiresAt:   result.ExpiresAt,
+					Action:      result.Action,
+					ReviewAfter: result.ReviewAfter,
+				}
+				if output != nil {
+					if err := output.Write(invalid); err != nil {
+						fail(fmt.Errorf("failed to write output file: %w", err))
+					}
+				} else {
+					invalidMu.Lock()
+					invalidEmails = append(invalidEmails, invalid)
+					invalidMu.Unlock()
+				}
+			}
+
+			checked := atomic.AddInt64(&stats.TotalChecked, 1)
+
+			// Progress reporting every batch or every 5 seconds
+			if checked%int64(config.BatchSize) == 0 || time.Since(lastReport) > 5*time.Second {
+				elapsed := time.Since(stats.StartTime)
+				rate := float64(max(checked-int64(resumed), 0)) / elapsed.Seconds()
+
+				slog.Info("progress",
+					"checked", checked,
+					"total", totalEmails,
+					"percent", math.Round(float64(checked)/float64(totalEmails)*1000)/10,
+					"emails_per_second", math.Round(rate*10)/10,
+					"eta", eta.Estimate().Round(time.Second),
+					"invalid", atomic.LoadInt64(&stats.TotalInvalid),
+					"rate_limited_percent", math.Round(stats.Usage.RateLimitShare()*100))
+				lastReport = time.Now()
+
+				// Results reach the disk at least as often as progress is reported
+				if output != nil {
+					if err := output.Flush(); err != nil {
+						fail(fmt.Errorf("failed to write output file: %w", err))
+					}
+				}
+				if checkpoint != nil {
+					if err := checkpoint.Flush(); err != nil {
+						fail(fmt.Errorf("failed to write checkpoint: %w", err))
+					}
+				}
+			}
+		}
+	}()
+
+	// Resumed results go through the collector first, so outputs and stats cover the whole input.
+	// The interruption was a pause like any other, so probing ramps back up.
+	if resumed > 0 {
+		lookups.Pacer.Resume()
+		err := checkpoint.Replay(func(index int, result EmailResult) {
+			results <- verifiedEmail{EmailResult: result, index: index, domain: emailDomain(result.Email), resumed: true}
+		})
+		if err != nil {
+			fail(fmt.Errorf("failed to replay checkpoint: %w", err))
+		}
+	}
+
+	// Send jobs to workers until the run is interrupted
+dispatch:
+	for _, i := range dispatchOrder(emails, config.FairSchedule) {
+		if resumed > 0 && checkpoint.Done(i) {
+			continue
+		}
+		select {
+		case jobs <- EmailJob{Index: i, Email: emails[i]}:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+
+	// Wait for workers to finish
+	wg.Wait()
+
+	// The retry pass goes through the same collector, so its results land in the outputs as usual
+	if greylist != nil && ctx.Err() == nil {
+		if deferred := greylist.Drain(); len(deferred) > 0 {
+			slog.Info("retrying greylisted addresses", "emails", len(deferred), "from", deferred[0].due.Format(time.TimeOnly))
+			jobs = make(chan EmailJob, config.Workers*2)
+			for i := 0; i < config.Workers; i++ {
+				wg.Add(1)
+				go worker(ctx, i, jobs, results, config, lookups, probes, domains, quota, greylist, stats.Usage, &wg)
+			}
+			for n, d := range deferred {
+				if sleepContext(ctx, time.Until(d.due)); ctx.Err() != nil {
+					break
+				}
+				// Probing stopped while waiting for the first one
+				if n == 0 {
+					lookups.Pacer.Resume()
+				}
+				jobs <- d.job
+			}
+			close(jobs)
+			wg.Wait()
+		}
+	}
+	close(results)
+
+	// Wait for collector to finish
+	collectorWg.Wait()
+	flushSinks(lookups.Sinks)
+	stats.Interrupted = stats.TotalChecked < int64(totalEmails)
+
+	// An interrupted run keeps its checkpoint, which must then hold every result up to here
+	if checkpoint != nil {
+		if err := checkpoint.Flush(); err != nil {
+			fail(fmt.Errorf("failed to write checkpoint: %w", err))
+		}
+	}
+	if failed != nil {
+		return nil, nil, nil, nil, failed
+	}
+
+	if domains != nil {
+		if mx, catchAll := domains.Saved(); mx+catchAll > 0 {
+			slog.Info("domain cache saved lookups", "mx_lookups", mx, "catch_all_probes", catchAll)
+		}
+	}
+	if hits, misses := lookups.DNS.Stats(); hits+misses > dnsHits+dnsMisses {
+		slog.Info("DNS cache", "hits", hits-dnsHits, "queries", misses-dnsMisses)
+	}
+	if lookups.Proxies != nil {
+		live, total := lookups.Proxies.Live()
+		slog.Info("proxy pool", "live", live, "proxies", total)
+	}
+
+	// Scoring waits for the whole run so every address benefits from all verified ones
+	for _, i := range unverifiable {
+		details[i].PatternMatch = lookups.Patterns.Score(details[i].Email)
+	}
+
+	sortOutput(invalidEmails, details, config)
+	sortValid(valid, config)
+	sortValid(free, config)
+
+	return invalidEmails, details, valid, free, nil
+}
+
+// verifiedEmail is a worker's result along with what the ETA model and pattern inference need to know about it
+type verifiedEmail struct {
+	EmailResult
+	index   int
+	resumed bool // replayed from a checkpoint rather than verified in this run
+	domain  string
+	elapsed time.Duration
+	retries int
+
+	// verified and unverifiable are the result's pattern evidence, see patternEvidence
+	verified     bool
+	unverifiable bool
+}
+
+func worker(ctx context.Context, id int, jobs <-chan EmailJob, results chan<- verifiedEmail, config Config, lookups *Lookups, probes *ProbeCache, domains *DomainCache, quota *DomainQuota, greylist *GreylistQueue, usage *Utilization, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	// Each worker gets its own verifier instance
+	verifier := newVerifier(config)
+
+	for job := range jobs {
+		// Jobs still queued when the run is interrupted are left for a resumed run
+		if ctx.Err() != nil {
+			continue
+		}
+		domain := emailDomain(job.Email)
+		waitStart := time.Now()
+		// Listed addresses are settled without a lookup, so they don't wait for rate limits
+		if !lookups.Allowlist.Match(job.Email) && !lookups.Blocklist.Match(job.Email) {
+			lookups.WaitForEgress()
+			if config.EnableSMTP {
+				lookups.Pacer.Wait(ctx, id, config.Workers)
+			}
+			lookups.WaitForProvider(domain)
+			lookups.WaitForDomain(domain)
+		}
+		waited := time.Since(waitStart)
+
+		start := time.Now()
+		email, repair := repairInput(job.Email, config.Repair)
+		result := verifyEmail(verifier, lookups, probes, domains, quota, greylist, email, config)
+		result.Repair = repair
+		elapsed := time.Since(start)
+		trace := result.trace
+		verified, unverifiable := patternEvidence(result.raw)
+
+		// A deferred address only gets its result on the retry pass
+		if !(result.Greylisted && result.errored && greylist.Defer(job, email)) {
+			if lookups.ResultHook != nil {
+				result = applyResultHook(lookups.ResultHook, result, config.Verbose)
+			}
+			results <- verifiedEmail{EmailResult: result, index: job.Index, domain: domain, elapsed: elapsed, retries: trace.retries, verified: verified, unverifiable: unverifiable}
+		}
+
+		// Rate limiting per worker
+		if config.RateLimit > 0 {
+			time.Sleep(config.RateLimit)
+			waited += config.RateLimit
+		}
+
+		usage.Record(id, providerFor(domain, trace.mxHost), phaseTimes{
+			rateLimit: waited,
+			dns:       trace.dns,
+			smtp:      trace.smtp,
+			other:     elapsed - trace.dns - trace.smtp,
+		})
+	}
+}
+
+// newVerifier creates a verifier for the library's offline checks: syntax, free and role
+// accounts, disposable domains and suggestions. DNS and SMTP are left to lookupMX and probeMailbox,
+// which go through the run's resolver and dialer.
+func newVerifier(config Config) *emailverifier.Verifier {
+	verifier := emailverifier.NewVerifier().EnableDomainSuggest()
+
+	// Simulated and replayed runs stay offline with the built-in disposable list, and a replaced
+	// one needn't be kept up to date
+	if !config.Simulate && config.ReplayFile == "" && config.DisposableList == "" {
+		verifier = verifier.EnableAutoUpdateDisposable()
+	}
+	return verifier
+}
+
+// verifyAddress runs the library's checks like Verifier.Verify (with domain suggestions, without
+// Gravatar), timing the DNS and SMTP phases and keeping the primary MX host. With domains, what
+// the address's domain has in common with others in the run is only resolved once.
+func verifyAddress(verifier *emailverifier.Verifier, email string, smtpEnabled bool, lookups *Lookups, domains *DomainCache, quota *DomainQuota, deadline time.Time) (*emailverifier.Result, verifyTrace, error) {
+	trace := verifyTrace{deadline: deadline}
+	result := &emailverifier.Result{Email: email, Reachable: "unknown"}
+
+	result.Syntax = verifier.ParseAddress(email)
+	if !result.Syntax.Valid {
+		return result, trace, nil
+	}
+	domain := result.Syntax.Domain
+	// The probe goes to the MX hosts looked up, and skips the random mailbox of a domain known not
+	// to be catch-all
+	var mx *emailverifier.Mx
+	checkCatchAll := true
+	lookupMX := func(domain string) (*emailverifier.Mx, error) {
+		return resolveMX(lookups.Resolver, domain)
+	}
+	probeSMTP := func(domain, username string) (*emailverifier.SMTP, error) {
+		session := lookups.Session(domain)
+		// A probe gets no more than the time the address has left
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			session.connectTimeout, session.operationTimeout = min(session.connectTimeout, left), min(session.operationTimeout, left)
+		}
+		smtp, family, deferred, err := probeMailbox(session, mx, domain, username, checkCatchAll)
+		if family != "" {
+			trace.family = family
+		}
+		trace.deferred = deferred
+		return smtp, err
+	}
+	isDisposable := lookups.Disposable.Wrap(verifier.IsDisposable)
+	if lookups.Simulator != nil {
+		lookupMX, probeSMTP = lookups.Simulator.CheckMX, lookups.Simulator.CheckSMTP
+	}
+	if lookups.Replayer != nil {
+		lookupMX, probeSMTP = lookups.Replayer.CheckMX, lookups.Replayer.CheckSMTP
+	}
+	// The library's random mailbox is probed again alongside the address in one session
+	confirm := func(domain, username string, probe *emailverifier.SMTP) (*emailverifier.SMTP, error) {
+		return confirmCatchAll(lookups.Session(domain), trace.mxHost, username+"@"+domain, probe)
+	}
+	if lookups.Replayer != nil {
+		confirm = lookups.Replayer.ConfirmCatchAll
+	}
+	if lookups.Recorder != nil {
+		lookupMX, probeSMTP = lookups.Recorder.Wrap(lookupMX, probeSMTP)
+		confirm = lookups.Recorder.WrapCatchAll(confirm)
+	}
+	// Transient failures are retried before they count against the address, while it has time left
+	retry := lookups.Retry
+	retry.Deadline = deadline
+	checkMX := func(domain string) (*emailverifier.Mx, error) {
+		var mx *emailverifier.Mx
+		retries, err := retry.do(func() (err error) {
+			mx, err = lookupMX(domain)
+			return err
+		})
+		trace.retries += retries
+		return mx, retriedError(err, retries)
+	}
+	checkSMTP := func(domain, username string) (*emailverifier.SMTP, error) {
+		var smtp *emailverifier.SMTP
+		retries, err := retry.do(func() (err error) {
+			if !deadline.IsZero() && time.Until(deadline) <= 0 {
+				return errEmailTimeout
+			}
+			smtp, err = probeSMTP(domain, username)
+			return err
+		})
+		trace.retries += retries
+		return smtp, retriedError(err, retries)
+	}
+
+	result.Free = verifier.IsFreeDomain(domain)
+	result.RoleAccount = verifier.IsRoleAccount(result.Syntax.Username)
+
+	var err error
+	facts := domains.facts(domain)
+	if facts != nil {
+		result.Disposable, mx, trace.mxHost, trace.dns, err = facts.resolve(domains, isDisposable, checkMX, domain)
+	} else if result.Disposable = isDisposable(domain); !result.Disposable {
+		start := time.Now()
+		mx, err = checkMX(domain)
+		trace.dns = time.Since(start)
+		trace.mxHost = primaryMX(mx)
+	}
+
+	// Disposable domains are not worth a DNS lookup or SMTP probe
+	if result.Disposable {
+		return result, trace, nil
+	}
+	if err != nil {
+		return result, trace, err
+	}
+	result.HasMxRecords = mx.HasMXRecord
+	result.Suggestion = verifier.SuggestDomain(domain)
+
+	// The probe policy limits some domains to DNS-level checks
+	if smtpEnabled {
+		if trace.policy = lookups.Policy.Forbids(domain, trace.mxHost); trace.policy != "" {
+			return result, trace, nil
+		}
+	}
+
+	// Some providers accept every recipient, so probing them only costs time
+	if lookups.Strategies != nil && lookups.Strategies.For(providerFor(domain, trace.mxHost)).Probe == probeSkip {
+		return result, trace, nil
+	}
+
+	// Catch-all domains accept every recipient too, which one probe of the domain established
+	if probe := facts.knownCatchAll(); probe != nil {
+		smtp := *probe
+		result.SMTP = &smtp
+		domains.catchAllSaved.Add(1)
+		return result, trace, nil
+	}
+	// Without SMTP probes the result rests on DNS
+	if !smtpEnabled {
+		return result, trace, nil
+	}
+	// Past the run's cap for the domain, the address is left for a later run
+	if !quota.Take(domain) {
+		return result, trace, errDomainQuota
+	}
+	// Nor does a domain known not to be catch-all need its random mailbox probed again
+	knownNotCatchAll := facts.knownNotCatchAll()
+	if knownNotCatchAll {
+		checkCatchAll = false
+		domains.catchAllSaved.Add(1)
+	}
+
+	start := time.Now()
+	smtp, err := checkSMTP(domain, result.Syntax.Username)
+	trace.smtp = time.Since(start)
+	if knownNotCatchAll && smtp != nil {
+		smtp.CatchAll = false
+	}
+	if err != nil {
+		return result, trace, err
+	}
+	// Only a random mailbox the server accepts makes the domain catch-all
+	if smtp != nil && smtp.CatchAll && !knownNotCatchAll && lookups.Simulator == nil && trace.mxHost != "" {
+		start := time.Now()
+		smtp, err = confirm(domain, result.Syntax.Username, smtp)
+		trace.smtp += time.Since(start)
+		if err != nil {
+			return result, trace, err
+		}
+	}
+	facts.learn(smtp)
+	result.SMTP = smtp
+	switch {
+	case smtp.Deliverable:
+		result.Reachable = "yes"
+	case !smtp.CatchAll:
+		result.Reachable = "no"
+	}
+
+	return result, trace, nil
+}
+
+// verifyEmail verifies one address with every configured check; probes, domains and greylist may be nil
+func verifyEmail(verifier *emailverifier.Verifier, lookups *Lookups, probes *ProbeCache, domains *DomainCache, quota *DomainQuota, greylist *GreylistQueue, email string, config Config) EmailResult {
+	// Blocklists hold suppressions that must be honored whatever verification would say, so they win over the allowlist
+	if lookups.Blocklist.Match(email) {
+		if config.Verbose {
+			slog.Debug("invalid", "email", email, "reason", blocklistedReason)
+		}
+		emailResult := EmailResult{Email: email, IsValid: false, Reason: blocklistedReason}
+		lookups.stamp(&emailResult)
+		return emailResult
+	}
+	if lookups.Allowlist.Match(email) {
+		emailResult := EmailResult{Email: email, IsValid: true, Allowlisted: true}
+		lookups.stamp(&emailResult)
+		return emailResult
+	}
+
+	// Reject nonexistent TLDs before spending a DNS lookup on them
+	if lookups.TLDs != nil {
+		if syntax := verifier.ParseAddress(email); syntax.Valid && !lookups.TLDs.Valid(syntax.Domain) {
+			reason := "nonexistent top-level domain"
+			if lookups.Typos != nil {
+				if suggestion := lookups.Typos.Suggest(syntax.Domain); suggestion != "" {
+					reason = fmt.Sprintf("possible typo, did you mean: %s", suggestion)
+				}
+			}
+			if config.Verbose {
+				slog.Debug("invalid", "email", email, "reason", reason)
+			}
+			emailResult := EmailResult{Email: email, IsValid: false, Reason: reason}
+			lookups.stamp(&emailResult)
+			return emailResult
+		}
+	}
+
+	var result *emailverifier.Result
+	var trace verifyTrace
+	var err error
+	var deadline time.Time
+	if config.EmailTimeout > 0 {
+		deadline = time.Now().Add(config.EmailTimeout)
+	}
+	probedAs := ""
+	if config.Level == levelSyntax {
+		result = checkSyntax(verifier, email, lookups.Disposable.Wrap(verifier.IsDisposable))
+	} else if probes != nil {
+		var probed string
+		result, trace, probed, err = probes.Verify(verifier, email, config.EnableSMTP, lookups, domains, quota, deadline)
+		if probed != email {
+			probedAs = probed
+		}
+	} else {
+		result, trace, err = verifyAddress(verifier, email, config.EnableSMTP, lookups, domains, quota, deadline)
+	}
+	trace.deadline = deadline
+	if errors.Is(err, errDomainQuota) {
+		reason := fmt.Sprintf("deferred: %d addresses of %s already probed in this run", config.MaxPerDomain, result.Syntax.Domain)
+		if config.Verbose {
+			slog.Debug("deferred", "email", email, "reason", reason)
+		}
+		emailResult := EmailResult{Email: email, IsValid: false, Reason: reason, ProbedAs: probedAs, Deferred: true, trace: trace, errored: true}
+		lookups.stamp(&emailResult)
+		return emailResult
+	}
+	if err != nil {
+		reason := fmt.Sprintf("verification error: %v", err)
+		// Misspelled domains usually fail DNS, so a typo explains the error better
+		if lookups.Typos != nil && result != nil && result.Syntax.Valid {
+			if suggestion := lookups.Typos.Suggest(result.Syntax.Domain); suggestion != "" {
+				reason = fmt.Sprintf("possible typo, did you mean: %s", suggestion)
+			}
+		}
+		emailResult := EmailResult{Email: email, IsValid: false, Reason: reason, ProbedAs: probedAs, trace: trace, errored: true}
+		// Look-alike domains are often parked without mail service; the imitation is what matters
+		if config.Lookalikes && result != nil && result.Syntax.Valid {
+			if lookalike := detectLookalike(result.Syntax.Domain); lookalike != nil {
+				emailResult.Lookalike, emailResult.Risky, emailResult.errored = lookalike, true, false
+				emailResult.Reason = fmt.Sprintf("look-alike of %s (%s)", lookalike.Target, lookalike.Technique)
+			}
+		}
+		if config.Verbose {
+			slog.Debug("invalid", "email", email, "reason", emailResult.Reason)
+		}
+		lookups.stamp(&emailResult)
+		return emailResult
+	}
+
+	// Greylisting servers defer first contact, which would otherwise pass for an undeliverable address
+	if greylist != nil && result.SMTP != nil && result.SMTP.HostExists && trace.mxHost != "" && trace.timeLeft() {
+		start := time.Now()
+		deferral, err := greylist.Check(lookups.Session(emailDomain(email)), trace.mxHost, email, trace.deferred, result)
+		trace.smtp += time.Since(start)
+		if err != nil && config.Verbose {
+			slog.Debug("greylisting check failed", "email", email, "error", err)
+		}
+		if deferral != "" {
+			if config.Verbose {
+				slog.Debug("greylisted", "email", email, "reply", deferral)
+			}
+			reason := fmt.Sprintf("greylisted: %s", deferral)
+			if greylist.Retrying(email) {
+				reason = fmt.Sprintf("still greylisted after retrying: %s", deferral)
+			}
+			emailResult := EmailResult{Email: email, IsValid: false, Reason: reason, ProbedAs: probedAs, Greylisted: true, trace: trace, errored: true}
+			lookups.stamp(&emailResult)
+			return emailResult
+		}
+	}
+
+	emailResult := judgeResult(lookups, email, result, trace, probedAs, config)
+	emailResult.Greylisted = greylist.Retrying(email)
+	return emailResult
+}
@@ -0,0 +1,119 @@
+package verify
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// Check verdicts a custom check may return
+const (
+	VerdictPass    = ""
+	VerdictInvalid = "invalid"
+	VerdictRisky   = "risky"
+)
+
+// Check is a custom per-email check whose verdict feeds the final classification
+type Check interface {
+	Name() string
+	Run(email string, result *emailverifier.Result) (CheckResult, error)
+}
+
+// CheckResult is the outcome of a single custom check
+type CheckResult struct {
+	Verdict string         `json:"verdict,omitempty"`
+	Reason  string         `json:"reason,omitempty"`
+	Data    map[string]any `json:"data,omitempty"`
+}
+
+// checkRegistry holds compiled-in checks by name
+var checkRegistry = map[string]func() Check{}
+
+// RegisterCheck adds a compiled-in check to the registry, for -checks to name. Programs embedding
+// the package register theirs from an init function.
+func RegisterCheck(name string, factory func() Check) {
+	checkRegistry[name] = factory
+}
+
+func init() {
+	RegisterCheck("plus-address", func() Check { return plusAddressCheck{} })
+}
+
+// newChecks builds the checks named in a comma-separated list.
+// Entries prefixed with "exec:" or "wasm:" load an external plugin.
+func newChecks(spec string) ([]Check, error) {
+	var checks []Check
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if isExtensionSpec(name) {
+			ext, err := newExtension(name)
+			if err != nil {
+				closeChecks(checks)
+				return nil, err
+			}
+			checks = append(checks, &extensionCheck{name: extensionName(name), ext: ext})
+			continue
+		}
+
+		factory, ok := checkRegistry[name]
+		if !ok {
+			closeChecks(checks)
+			return nil, fmt.Errorf("unknown check %q", name)
+		}
+		checks = append(checks, factory())
+	}
+	return checks, nil
+}
+
+// closeChecks releases resources held by checks that need it
+func closeChecks(checks []Check) {
+	for _, check := range checks {
+		if closer, ok := check.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
+// plusAddressCheck reports the sub-address tag of user+tag@domain addresses
+type plusAddressCheck struct{}
+
+func (plusAddressCheck) Name() string { return "plus-address" }
+
+func (plusAddressCheck) Run(email string, result *emailverifier.Result) (CheckResult, error) {
+	_, tag, found := strings.Cut(result.Syntax.Username, "+")
+	if !found {
+		return CheckResult{}, nil
+	}
+	return CheckResult{Data: map[string]any{"tag": tag}}, nil
+}
+
+// extensionCheck runs the "check" method of an external plugin
+type extensionCheck struct {
+	name string
+	ext  extension
+}
+
+// checkPayload is the parameter object of the "check" method
+type checkPayload struct {
+	Email  string                `json:"email"`
+	Result *emailverifier.Result `json:"result"`
+}
+
+func (c *extensionCheck) Name() string { return c.name }
+
+func (c *extensionCheck) Run(email string, result *emailverifier.Result) (CheckResult, error) {
+	var checkResult CheckResult
+	err := c.ext.Call("check", checkPayload{Email: email, Result: result}, &checkResult)
+	return checkResult, err
+}
+
+// Close unloads the plugin
+func (c *extensionCheck) Close() error {
+	return c.ext.Close()
+}
@@ -0,0 +1,138 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// builtinNoProbe is sensitive infrastructure that is never probed over SMTP, whatever the policy
+// file says: probing it is at best unwelcome and at worst reported as an attack. Entries match
+// the domain and every domain under it.
+var builtinNoProbe = []string{
+	// Government, military and intergovernmental domains
+	"gov", "mil", "int", "europa.eu",
+	"gov.uk", "parliament.uk", "police.uk", "mod.uk", "gov.au", "gc.ca", "govt.nz", "gov.ie",
+	"gouv.fr", "bund.de", "admin.ch", "gv.at", "gov.it", "gob.es", "gob.mx", "gov.br",
+	"gov.in", "nic.in", "gov.za", "gov.sg", "go.jp", "gov.cn", "go.kr",
+	// Public health services
+	"nhs.uk", "nhs.net",
+	// Blocklist operators and abuse desks, which list probing IPs
+	"spamhaus.org", "spamcop.net", "abuse.ch", "surbl.org", "uribl.com", "abuseat.org",
+	"barracudacentral.org", "sorbs.net",
+	// Internet registries and root infrastructure
+	"iana.org", "icann.org", "ripe.net", "arin.net", "apnic.net", "lacnic.net", "afrinic.net",
+	"root-servers.org",
+}
+
+// defaultNoProbeProviders are mailbox providers known to ban verification probing and to
+// blocklist the IPs doing it, as a comma-separated list for -no-probe-providers
+const defaultNoProbeProviders = "t-online,gmx,ionos"
+
+// ProbePolicy is the acceptable-use policy for SMTP probes: the domains and mailbox providers
+// whose addresses only get DNS-level checks. The built-in entries always apply; a policy file
+// can only add to them.
+type ProbePolicy struct {
+	domains   map[string]bool
+	providers map[string]bool
+	banning   map[string]bool // providers that ban probing, from the -no-probe-providers registry
+}
+
+// policyFile is the format of -policy files
+type policyFile struct {
+	Domains   []string `json:"domains"`
+	Providers []string `json:"providers"`
+}
+
+// loadProbePolicy returns the built-in policy with the entries of file added, if set, and the
+// registry of providers that ban probing
+func loadProbePolicy(file, noProbeProviders string) (*ProbePolicy, error) {
+	p := &ProbePolicy{domains: make(map[string]bool), providers: make(map[string]bool), banning: make(map[string]bool)}
+	for _, domain := range builtinNoProbe {
+		p.domains[domain] = true
+	}
+	for _, provider := range splitList(noProbeProviders) {
+		p.banning[strings.ToLower(provider)] = true
+	}
+	if file == "" {
+		return p, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy file %s: %w", file, err)
+	}
+	var policy policyFile
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, fmt.Errorf("failed to decode policy file %s: %w", file, err)
+	}
+	for _, domain := range policy.Domains {
+		domain = strings.Trim(strings.ToLower(strings.TrimSpace(domain)), ".")
+		domain = strings.TrimPrefix(domain, "*.")
+		if domain == "" {
+			return nil, fmt.Errorf("empty domain in policy file %s", file)
+		}
+		p.domains[domain] = true
+	}
+	for _, provider := range policy.Providers {
+		provider = strings.ToLower(strings.TrimSpace(provider))
+		if provider == "" {
+			return nil, fmt.Errorf("empty provider in policy file %s", file)
+		}
+		p.providers[provider] = true
+	}
+	return p, nil
+}
+
+// Forbids returns the rule that rules out probing a domain, or an empty string if it may be
+// probed. Domains are matched by name and by the MX host they were resolved to, so a vanity
+// domain hosted on listed infrastructure is covered too.
+func (p *ProbePolicy) Forbids(domain, mxHost string) string {
+	if p == nil {
+		return ""
+	}
+	if listed := p.listedDomain(domain); listed != "" {
+		return "domain " + listed
+	}
+	if listed := p.listedDomain(mxHost); listed != "" {
+		return "mx " + listed
+	}
+	provider := providerFor(domain, mxHost)
+	if provider == "" {
+		return ""
+	}
+	if p.providers[provider] {
+		return "provider " + provider
+	}
+	if p.banning[provider] {
+		return "provider policy: " + provider
+	}
+	return ""
+}
+
+// listedDomain returns the entry matching host or one of its parent domains
+func (p *ProbePolicy) listedDomain(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for host != "" {
+		if p.domains[host] {
+			return host
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			return ""
+		}
+		host = host[dot+1:]
+	}
+	return ""
+}
+
+// Size returns the number of domain and provider entries, counting the registry's providers
+func (p *ProbePolicy) Size() (domains, providers int) {
+	for provider := range p.banning {
+		if !p.providers[provider] {
+			providers++
+		}
+	}
+	return len(p.domains), len(p.providers) + providers
+}
@@ -0,0 +1,136 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+	"golang.org/x/net/idna"
+)
+
+// resolveMX returns the MX records of a domain through resolver, sorted by preference, as the
+// library's CheckMX does through the system's resolver
+func resolveMX(resolver *net.Resolver, domain string) (*emailverifier.Mx, error) {
+	if ascii, err := idna.ToASCII(domain); err == nil {
+		domain = ascii
+	}
+	records, err := resolver.LookupMX(context.Background(), domain)
+	if err != nil && len(records) == 0 {
+		return nil, err
+	}
+	return &emailverifier.Mx{HasMXRecord: len(records) > 0, Records: records}, nil
+}
+
+// probeMailbox checks an address over SMTP the way the library's CheckSMTP does, but connecting
+// through the session's dialer: every MX host is dialed at once and the first to greet is used
+// for HELO, MAIL FROM and, unless checkCatchAll is off, a random mailbox before the address. It
+// also returns the address family of the connection, where the dialer knows it, and whether a
+// RCPT was deferred with a temporary 4xx, as greylisting servers answer first contact.
+func probeMailbox(session smtpSession, mx *emailverifier.Mx, domain, username string, checkCatchAll bool) (*emailverifier.SMTP, string, bool, error) {
+	var ret emailverifier.SMTP
+	client, family, err := dialMX(session, mx)
+	if err != nil {
+		return &ret, "", false, emailverifier.ParseSMTPError(err)
+	}
+	defer client.Close()
+
+	if err := client.Hello(session.hello); err != nil {
+		return &ret, family, false, emailverifier.ParseSMTPError(err)
+	}
+	if err := client.Mail(session.from); err != nil {
+		return &ret, family, false, emailverifier.ParseSMTPError(err)
+	}
+	ret.HostExists = true
+	ret.CatchAll = true
+
+	deferred := false
+	if checkCatchAll {
+		if err := client.Rcpt(emailverifier.GenerateRandomEmail(domain)); err != nil {
+			deferred = deferredRcpt(err)
+			switch emailverifier.ParseSMTPError(err).Message {
+			case emailverifier.ErrFullInbox:
+				ret.FullInbox = true
+			case emailverifier.ErrNotAllowed:
+				ret.Disabled = true
+			case emailverifier.ErrServerUnavailable:
+				// Typically a 550 5.1.1, the random mailbox doesn't exist
+				ret.CatchAll = false
+			}
+		}
+		if ret.CatchAll {
+			return &ret, family, deferred, nil
+		}
+	}
+	if username == "" {
+		return &ret, family, deferred, nil
+	}
+	err = client.Rcpt(username + "@" + domain)
+	if err == nil {
+		ret.Deliverable = true
+	}
+	return &ret, family, deferred || deferredRcpt(err), nil
+}
+
+// deferredRcpt reports whether a RCPT failed with a reply that defers the address
+func deferredRcpt(err error) bool {
+	var reply *textproto.Error
+	return errors.As(err, &reply) && greylistReply(reply.Code)
+}
+
+// dialMX connects to every MX host at once and returns a client of the first to greet, with one
+// operation timeout for the whole session as the library gives it
+func dialMX(session smtpSession, mx *emailverifier.Mx) (*smtp.Client, string, error) {
+	if mx == nil || len(mx.Records) == 0 {
+		return nil, "", errors.New("no MX records found")
+	}
+	type greeted struct {
+		client *smtp.Client
+		family string
+		err    error
+	}
+	results := make(chan greeted, len(mx.Records))
+	for _, record := range mx.Records {
+		host := strings.TrimSuffix(record.Host, ".")
+		go func() {
+			conn, err := session.dial(net.JoinHostPort(host, session.port), session.connectTimeout)
+			if err != nil {
+				results <- greeted{err: err}
+				return
+			}
+			conn.SetDeadline(time.Now().Add(session.operationTimeout))
+			client, err := smtp.NewClient(conn, host)
+			if err != nil {
+				conn.Close()
+				results <- greeted{err: err}
+				return
+			}
+			results <- greeted{client: client, family: connFamily(conn)}
+		}()
+	}
+
+	var firstErr error
+	for pending := len(mx.Records); pending > 0; pending-- {
+		result := <-results
+		if result.err == nil {
+			// Hosts still greeting are hung up on once they do
+			go func() {
+				for pending--; pending > 0; pending-- {
+					if late := <-results; late.client != nil {
+						late.client.Close()
+					}
+				}
+			}()
+			return result.client, result.family, nil
+		}
+		if firstErr == nil {
+			firstErr = result.err
+		}
+	}
+	// Left unwrapped, as the reply code at its start classifies it
+	return nil, "", firstErr
+}
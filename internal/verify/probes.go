@@ -0,0 +1,80 @@
+package verify
+
+import (
+	"sync"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// ProbeCache shares one library verification between input addresses that canonicalize to the
+// same mailbox, so each mailbox is only probed over SMTP once per run
+type ProbeCache struct {
+	mu      sync.Mutex
+	pending map[string]int
+	probes  map[string]*probe
+}
+
+// probe is the verification of a duplicated mailbox, run by whichever address reaches it first
+type probe struct {
+	once   sync.Once
+	email  string
+	result *emailverifier.Result
+	trace  verifyTrace
+	err    error
+}
+
+// newProbeCache tracks the mailboxes that appear more than once in emails
+func newProbeCache(emails []string) *ProbeCache {
+	pending := make(map[string]int)
+	for _, email := range emails {
+		pending[canonicalMailbox(email)]++
+	}
+	for mailbox, count := range pending {
+		if count < 2 {
+			delete(pending, mailbox)
+		}
+	}
+	return &ProbeCache{pending: pending, probes: make(map[string]*probe)}
+}
+
+// Verify returns the library result for an address and the address that was actually probed.
+// Only the address that ran the probe gets its timings; the others reused it for free.
+func (c *ProbeCache) Verify(verifier *emailverifier.Verifier, email string, smtpEnabled bool, lookups *Lookups, domains *DomainCache, quota *DomainQuota, deadline time.Time) (*emailverifier.Result, verifyTrace, string, error) {
+	mailbox := canonicalMailbox(email)
+
+	c.mu.Lock()
+	if _, duplicated := c.pending[mailbox]; !duplicated {
+		c.mu.Unlock()
+		result, trace, err := verifyAddress(verifier, email, smtpEnabled, lookups, domains, quota, deadline)
+		return result, trace, email, err
+	}
+	p, ok := c.probes[mailbox]
+	if !ok {
+		p = &probe{email: email}
+		c.probes[mailbox] = p
+	}
+	// Forget the mailbox once every address for it has been handed its probe
+	if c.pending[mailbox]--; c.pending[mailbox] == 0 {
+		delete(c.pending, mailbox)
+		delete(c.probes, mailbox)
+	}
+	c.mu.Unlock()
+
+	ran := false
+	p.once.Do(func() {
+		p.result, p.trace, p.err = verifyAddress(verifier, p.email, smtpEnabled, lookups, domains, quota, deadline)
+		ran = true
+	})
+
+	// Every caller gets its own copy, since verifyEmail adjusts the result it is given
+	result := *p.result
+	result.Email = email
+	result.Syntax = verifier.ParseAddress(email)
+
+	trace := verifyTrace{mxHost: p.trace.mxHost, policy: p.trace.policy, deferred: p.trace.deferred}
+	if ran {
+		trace = p.trace
+	}
+	return &result, trace, p.email, p.err
+}
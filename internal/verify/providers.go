@@ -0,0 +1,170 @@
+package verify
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// mxProviders fingerprints mailbox providers by MX host suffix
+var mxProviders = map[string]string{
+	"google.com":            "google",
+	"googlemail.com":        "google",
+	"outlook.com":           "microsoft",
+	"hotmail.com":           "microsoft",
+	"yahoodns.net":          "yahoo",
+	"icloud.com":            "apple",
+	"me.com":                "apple",
+	"mail.ru":               "mailru",
+	"yandex.net":            "yandex",
+	"yandex.ru":             "yandex",
+	"zoho.com":              "zoho",
+	"zoho.eu":               "zoho",
+	"protonmail.ch":         "proton",
+	"qq.com":                "tencent",
+	"163.com":               "netease",
+	"secureserver.net":      "godaddy",
+	"pphosted.com":          "proofpoint",
+	"ppe-hosted.com":        "proofpoint",
+	"mimecast.com":          "mimecast",
+	"messagelabs.com":       "symantec",
+	"barracudanetworks.com": "barracuda",
+	"gmx.net":               "gmx",
+	"web.de":                "gmx",
+	"aol.com":               "yahoo",
+	"fastmail.com":          "fastmail",
+	"messagingengine.com":   "fastmail",
+	"mailgun.org":           "mailgun",
+	"sendgrid.net":          "sendgrid",
+	"amazonaws.com":         "amazon",
+	"emailsrvr.com":         "rackspace",
+	"ovh.net":               "ovh",
+	"one.com":               "one.com",
+	"ionos.com":             "ionos",
+	"kundenserver.de":       "ionos",
+	"t-online.de":           "t-online",
+}
+
+// mxProvider returns the provider operating an MX host, or an empty string if unknown
+func mxProvider(host string) string {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for {
+		if provider, ok := mxProviders[host]; ok {
+			return provider
+		}
+		dot := strings.IndexByte(host, '.')
+		if dot < 0 {
+			return ""
+		}
+		host = host[dot+1:]
+	}
+}
+
+// mailboxProviders maps popular mailbox domains to their provider without an MX lookup
+var mailboxProviders = map[string]string{
+	"gmail.com": "google", "googlemail.com": "google",
+	"outlook.com": "microsoft", "hotmail.com": "microsoft", "live.com": "microsoft", "msn.com": "microsoft",
+	"hotmail.co.uk": "microsoft", "hotmail.fr": "microsoft", "hotmail.de": "microsoft", "outlook.de": "microsoft",
+	"yahoo.com": "yahoo", "ymail.com": "yahoo", "aol.com": "yahoo", "yahoo.co.uk": "yahoo", "yahoo.fr": "yahoo", "yahoo.de": "yahoo",
+	"icloud.com": "apple", "me.com": "apple", "mac.com": "apple",
+	"mail.ru": "mailru", "bk.ru": "mailru", "inbox.ru": "mailru", "list.ru": "mailru",
+	"yandex.ru": "yandex", "yandex.com": "yandex", "ya.ru": "yandex",
+	"gmx.de": "gmx", "gmx.net": "gmx", "web.de": "gmx",
+	"t-online.de": "t-online", "magenta.de": "t-online",
+	"protonmail.com": "proton", "proton.me": "proton",
+	"qq.com": "tencent", "foxmail.com": "tencent",
+	"163.com": "netease", "126.com": "netease",
+	"zoho.com": "zoho", "fastmail.com": "fastmail",
+}
+
+// providerFor identifies a domain's provider from its name or the MX host it was verified against
+func providerFor(domain, mxHost string) string {
+	if provider, ok := mailboxProviders[domain]; ok {
+		return provider
+	}
+	return mxProvider(mxHost)
+}
+
+// ProviderResolver maps domains to mailbox providers, resolving MX records once per domain
+type ProviderResolver struct {
+	lookupMX func(string) ([]*net.MX, error)
+
+	mu    sync.Mutex
+	cache map[string]*providerEntry
+}
+
+// providerEntry is a cached provider, resolved once per domain
+type providerEntry struct {
+	once     sync.Once
+	done     atomic.Bool
+	provider string
+}
+
+// newProviderResolver creates a resolver looking up MX records with lookupMX, as net.LookupMX does
+func newProviderResolver(lookupMX func(string) ([]*net.MX, error)) *ProviderResolver {
+	return &ProviderResolver{lookupMX: lookupMX, cache: make(map[string]*providerEntry)}
+}
+
+// Provider returns the provider operating the domain's mail, or an empty string if unknown
+func (r *ProviderResolver) Provider(domain string) string {
+	domain = strings.ToLower(domain)
+	if provider, ok := mailboxProviders[domain]; ok {
+		return provider
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[domain]
+	if !ok {
+		entry = &providerEntry{}
+		r.cache[domain] = entry
+	}
+	r.mu.Unlock()
+
+	entry.once.Do(func() {
+		if records, err := r.lookupMX(domain); err == nil && len(records) > 0 {
+			entry.provider = mxProvider(records[0].Host)
+		}
+		entry.done.Store(true)
+	})
+	return entry.provider
+}
+
+// Known returns the domain's provider if it can be determined without a lookup
+func (r *ProviderResolver) Known(domain string) (string, bool) {
+	domain = strings.ToLower(domain)
+	if provider, ok := mailboxProviders[domain]; ok {
+		return provider, true
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[domain]
+	r.mu.Unlock()
+	if !ok || !entry.done.Load() {
+		return "", false
+	}
+	return entry.provider, true
+}
+
+// parseProviderRates parses a list like "google=200ms,microsoft=1s" into minimum intervals per provider
+func parseProviderRates(spec string) (map[string]time.Duration, error) {
+	rates := make(map[string]time.Duration)
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		provider, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid provider rate %q, expected provider=duration", part)
+		}
+		interval, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval for provider %s: %w", provider, err)
+		}
+		rates[strings.ToLower(strings.TrimSpace(provider))] = interval
+	}
+	return rates, nil
+}
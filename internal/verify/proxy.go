@@ -0,0 +1,197 @@
+package verify
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// How probes are spread over the proxies of a pool
+const (
+	proxyRoundRobin = "round-robin" // each probe takes the next proxy
+	proxySticky     = "sticky"      // each recipient domain sees the same proxy
+)
+
+const (
+	// proxyEvictAfter is how many probes in a row a proxy may fail before it's taken out
+	proxyEvictAfter = 3
+	// proxyCooldown is how long an evicted proxy sits out before it gets another chance
+	proxyCooldown = 5 * time.Minute
+)
+
+// smtpDial opens a connection to an MX host's SMTP port, directly or through a proxy
+type smtpDial func(addr string, timeout time.Duration) (net.Conn, error)
+
+// ProxyPool spreads SMTP probes over SOCKS proxies, so no single IP draws a provider's
+// per-IP throttling. Proxies that can't be reached are evicted for a while.
+type ProxyPool struct {
+	proxies []*poolProxy
+	sticky  bool
+	next    atomic.Uint64
+
+	mu sync.Mutex
+}
+
+// poolProxy is a proxy of a pool and its health
+type poolProxy struct {
+	uri      string
+	addr     string
+	dialer   proxy.ContextDialer
+	failures int       // probes failed in a row
+	evicted  time.Time // until when it sits out
+}
+
+// newProxyPool creates a pool from a comma-separated list of socks5:// URLs, or a file listing
+// one per line
+func newProxyPool(spec, mode string) (*ProxyPool, error) {
+	var uris []string
+	if strings.Contains(spec, "://") {
+		uris = strings.Split(spec, ",")
+	} else {
+		file, err := os.Open(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open proxy list: %w", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			uris = append(uris, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read proxy list: %w", err)
+		}
+	}
+
+	pool := &ProxyPool{sticky: mode == proxySticky}
+	for _, uri := range uris {
+		uri = strings.TrimSpace(uri)
+		if uri == "" || strings.HasPrefix(uri, "#") {
+			continue
+		}
+		u, err := url.Parse(uri)
+		if err != nil || (u.Scheme != "socks5" && u.Scheme != "socks5h") || u.Host == "" {
+			return nil, fmt.Errorf("invalid proxy %q (expected socks5://[user:password@]host:port)", uri)
+		}
+		dialer, err := proxy.FromURL(u, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %s: %w", u.Redacted(), err)
+		}
+		pool.proxies = append(pool.proxies, &poolProxy{uri: uri, addr: u.Host, dialer: dialer.(proxy.ContextDialer)})
+	}
+	if len(pool.proxies) == 0 {
+		return nil, errors.New("no proxies in the list")
+	}
+	return pool, nil
+}
+
+// pick chooses the proxy for a probe of a domain among those not evicted, or among all of them
+// once every one is
+func (p *ProxyPool) pick(domain string) *poolProxy {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	live := make([]*poolProxy, 0, len(p.proxies))
+	for _, entry := range p.proxies {
+		if now.After(entry.evicted) {
+			live = append(live, entry)
+		}
+	}
+	if len(live) == 0 {
+		live = p.proxies
+	}
+	if !p.sticky {
+		return live[p.next.Add(1)%uint64(len(live))]
+	}
+
+	// Rendezvous hashing moves only the domains of an evicted proxy elsewhere
+	var best *poolProxy
+	var bestScore uint64
+	for _, entry := range live {
+		h := fnv.New64a()
+		h.Write([]byte(entry.uri))
+		h.Write([]byte(strings.ToLower(domain)))
+		if score := h.Sum64(); best == nil || score > bestScore {
+			best, bestScore = entry, score
+		}
+	}
+	return best
+}
+
+// report records the outcome of a probe through a proxy, evicting it once it failed too often.
+// Only failures to reach or authenticate with the proxy count, not the MX host's.
+func (p *ProxyPool) report(entry *poolProxy, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil || !proxyFailed(entry, err) {
+		entry.failures = 0
+		return
+	}
+	entry.failures++
+	if entry.failures >= proxyEvictAfter && time.Now().After(entry.evicted) {
+		entry.evicted = time.Now().Add(proxyCooldown)
+		entry.failures = 0
+		slog.Warn("evicted unreachable proxy", "proxy", entry.addr, "cooldown", proxyCooldown, "error", err)
+	}
+}
+
+// proxyFailed reports whether a probe failed at the proxy itself: connecting to it or
+// authenticating, rather than the proxy reaching the MX host
+func proxyFailed(entry *poolProxy, err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "dial tcp "+entry.addr) || strings.Contains(message, "authentication failed")
+}
+
+// Dialer returns how a probe of a domain connects to its MX hosts: through the proxy picked for
+// the probe, which its every connection goes through
+func (p *ProxyPool) Dialer(domain string) smtpDial {
+	entry := p.pick(domain)
+	return func(addr string, timeout time.Duration) (net.Conn, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		conn, err := countedDialer{entry.dialer}.DialContext(ctx, "tcp", addr)
+		p.report(entry, err)
+		return conn, err
+	}
+}
+
+// countedDialer connects through a proxy, counting the connections' traffic
+type countedDialer struct {
+	dialer proxy.ContextDialer
+}
+
+func (d countedDialer) Dial(network, addr string) (net.Conn, error) {
+	return d.DialContext(context.Background(), network, addr)
+}
+
+func (d countedDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	conn, err := d.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return smtpTraffic.count(conn), nil
+}
+
+// Live returns how many proxies are in use, for the run summary
+func (p *ProxyPool) Live() (live, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	for _, entry := range p.proxies {
+		if now.After(entry.evicted) {
+			live++
+		}
+	}
+	return live, len(p.proxies)
+}
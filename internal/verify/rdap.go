@@ -0,0 +1,100 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DomainAgeChecker looks up domain registration dates via RDAP with caching and rate limiting
+type DomainAgeChecker struct {
+	baseURL string
+	client  *http.Client
+	limiter *intervalLimiter
+
+	mu    sync.Mutex
+	cache map[string]*domainAgeEntry
+}
+
+// domainAgeEntry is a cached RDAP lookup, resolved once per domain
+type domainAgeEntry struct {
+	once       sync.Once
+	registered time.Time
+	err        error
+}
+
+// rdapDomain is the subset of an RDAP domain response we care about
+type rdapDomain struct {
+	Events []struct {
+		EventAction string `json:"eventAction"`
+		EventDate   string `json:"eventDate"`
+	} `json:"events"`
+}
+
+func newDomainAgeChecker(baseURL string, minInterval time.Duration, resolver *net.Resolver) *DomainAgeChecker {
+	return &DomainAgeChecker{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		client:  resolvingClient(resolver, 15*time.Second),
+		limiter: newIntervalLimiter(minInterval),
+		cache:   make(map[string]*domainAgeEntry),
+	}
+}
+
+// RegistrationDate returns the registration date of a domain, querying RDAP at most once per domain
+func (c *DomainAgeChecker) RegistrationDate(domain string) (time.Time, error) {
+	domain = strings.ToLower(domain)
+
+	c.mu.Lock()
+	entry, ok := c.cache[domain]
+	if !ok {
+		entry = &domainAgeEntry{}
+		c.cache[domain] = entry
+	}
+	c.mu.Unlock()
+
+	entry.once.Do(func() {
+		c.limiter.Wait()
+		entry.registered, entry.err = c.query(domain)
+	})
+
+	return entry.registered, entry.err
+}
+
+func (c *DomainAgeChecker) query(domain string) (time.Time, error) {
+	req, err := http.NewRequest(http.MethodGet, c.baseURL+"/domain/"+domain, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to build RDAP request: %w", err)
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("RDAP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, fmt.Errorf("RDAP lookup for %s returned %s", domain, resp.Status)
+	}
+
+	var data rdapDomain
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return time.Time{}, fmt.Errorf("failed to decode RDAP response: %w", err)
+	}
+
+	for _, event := range data.Events {
+		if event.EventAction == "registration" {
+			registered, err := time.Parse(time.RFC3339, event.EventDate)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("invalid RDAP registration date %q: %w", event.EventDate, err)
+			}
+			return registered, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("no registration date in RDAP response for %s", domain)
+}
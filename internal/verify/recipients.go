@@ -0,0 +1,198 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// Answers to recipients that are neither plainly valid nor invalid
+const (
+	recipientAccept   = "accept"   // 250, let the message through
+	recipientReject   = "reject"   // 550, refuse the recipient for good
+	recipientTempfail = "tempfail" // 451, ask the client to try again later
+)
+
+// recipientSweepEvery is how many verdicts are cached between sweeps of expired ones
+const recipientSweepEvery = 1000
+
+// RecipientVerifier answers for recipients at SMTP time, for the gateway and the milter. It
+// verifies them on a pool of -workers verifiers through the same limits, hooks and sinks as a
+// run, and remembers the verdicts for repeated recipients.
+type RecipientVerifier struct {
+	config   Config
+	lookups  *Lookups
+	risky    string
+	unknown  string
+	cacheTTL time.Duration
+
+	workers  chan recipientWorker
+	greylist *GreylistQueue
+
+	mu       sync.Mutex
+	verdicts map[string]recipientVerdict
+	cached   int
+}
+
+// recipientVerdict is a cached verdict for an address
+type recipientVerdict struct {
+	status  int
+	reason  string
+	expires time.Time
+}
+
+// recipientWorker is a verifier of the pool, with the worker number the pacer spreads probes by
+type recipientWorker struct {
+	id       int
+	verifier *emailverifier.Verifier
+}
+
+// newRecipientVerifier checks the -risky and -unknown answers and starts the verifier pool
+func newRecipientVerifier(config Config, lookups *Lookups, risky, unknown string, cacheTTL time.Duration) (*RecipientVerifier, error) {
+	for name, action := range map[string]string{"-risky": risky, "-unknown": unknown} {
+		if action != recipientAccept && action != recipientReject && action != recipientTempfail {
+			return nil, fmt.Errorf("invalid %s answer %q (expected %s, %s or %s)", name, action, recipientAccept, recipientReject, recipientTempfail)
+		}
+	}
+	if cacheTTL < 0 {
+		return nil, fmt.Errorf("invalid -cache-ttl %s, expected a non-negative duration", cacheTTL)
+	}
+	v := &RecipientVerifier{
+		config:   config,
+		lookups:  lookups,
+		risky:    risky,
+		unknown:  unknown,
+		cacheTTL: cacheTTL,
+		workers:  make(chan recipientWorker, config.Workers),
+		verdicts: make(map[string]recipientVerdict),
+	}
+	for i := 0; i < config.Workers; i++ {
+		v.workers <- recipientWorker{id: i, verifier: newVerifier(config)}
+	}
+	// Each recipient is answered while the client waits, so there is no retry pass: the queue only
+	// tells greylisted recipients apart, which get the -unknown answer
+	if config.EnableSMTP {
+		v.greylist = newGreylistQueue(0)
+	}
+	return v, nil
+}
+
+// Reply verifies a recipient and returns the SMTP reply to RCPT for its verdict
+func (v *RecipientVerifier) Reply(ctx context.Context, email string) string {
+	key := addressKey(email)
+	if v.cacheTTL > 0 {
+		v.mu.Lock()
+		verdict, ok := v.verdicts[key]
+		v.mu.Unlock()
+		if ok && time.Now().Before(verdict.expires) {
+			return recipientReply(email, verdict.status, verdict.reason, v.risky, v.unknown)
+		}
+	}
+
+	var worker recipientWorker
+	select {
+	case worker = <-v.workers:
+	case <-ctx.Done():
+		return "421 4.3.2 Shutting down"
+	}
+	result, ok := v.verify(ctx, worker, email)
+	v.workers <- worker
+	if !ok {
+		return fmt.Sprintf("550 5.7.1 <%s>: Recipient refused by the pre-hook", email)
+	}
+
+	status := CheckStatus(result)
+	reply := recipientReply(email, status, result.Reason, v.risky, v.unknown)
+	if v.config.Verbose {
+		slog.Debug("RCPT", "email", email, "reason", result.Reason, "reply", reply)
+	}
+
+	// Unknown verdicts are worth another try on the client's next attempt
+	if v.cacheTTL > 0 && status != CheckUnknown {
+		v.mu.Lock()
+		v.verdicts[key] = recipientVerdict{status: status, reason: result.Reason, expires: time.Now().Add(v.cacheTTL)}
+		if v.cached++; v.cached%recipientSweepEvery == 0 {
+			now := time.Now()
+			for key, verdict := range v.verdicts {
+				if !now.Before(verdict.expires) {
+					delete(v.verdicts, key)
+				}
+			}
+		}
+		v.mu.Unlock()
+	}
+	return reply
+}
+
+// verify checks an address like a run's worker does, waiting on the same limits and passing the
+// result through the hooks and sinks. It returns false if the pre-hook dropped the address.
+func (v *RecipientVerifier) verify(ctx context.Context, worker recipientWorker, email string) (EmailResult, bool) {
+	config, lookups := v.config, v.lookups
+	if lookups.InputHook != nil {
+		transformed := applyInputHook(lookups.InputHook, []string{email}, config.Verbose)
+		if len(transformed) == 0 {
+			return EmailResult{}, false
+		}
+		email = transformed[0]
+	}
+
+	domain := emailDomain(email)
+	lookups.WaitForEgress()
+	if config.EnableSMTP {
+		lookups.Pacer.Wait(ctx, worker.id, config.Workers)
+	}
+	lookups.WaitForProvider(domain)
+	lookups.WaitForDomain(domain)
+
+	verified, repair := repairInput(email, config.Repair)
+	result := verifyEmail(worker.verifier, lookups, nil, nil, nil, v.greylist, verified, config)
+	result.Repair = repair
+	if lookups.ResultHook != nil {
+		result = applyResultHook(lookups.ResultHook, result, config.Verbose)
+	}
+	for _, sink := range lookups.Sinks {
+		if err := sink.Write(result); err != nil && config.Verbose {
+			slog.Debug("sink failed", "email", result.Email, "error", err)
+		}
+	}
+
+	// The worker is held for the rate limit, so -rate paces each of them as in a run
+	if config.RateLimit > 0 {
+		time.Sleep(config.RateLimit)
+	}
+	return result, true
+}
+
+// recipientReply is the RCPT reply for a verdict, one of the check command's exit statuses
+func recipientReply(email string, status int, reason, risky, unknown string) string {
+	reason = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, reason)
+	if reason != "" {
+		reason = ": " + reason
+	}
+	action := recipientAccept
+	switch status {
+	case CheckInvalid:
+		return fmt.Sprintf("550 5.1.1 <%s>: Recipient address rejected%s", email, reason)
+	case CheckRisky:
+		action = risky
+	case CheckUnknown:
+		action = unknown
+	}
+	switch action {
+	case recipientReject:
+		return fmt.Sprintf("550 5.7.1 <%s>: Recipient address rejected%s", email, reason)
+	case recipientTempfail:
+		return fmt.Sprintf("451 4.4.3 <%s>: Recipient address not verified yet%s", email, reason)
+	}
+	return fmt.Sprintf("250 2.1.5 <%s>: Recipient OK%s", email, reason)
+}
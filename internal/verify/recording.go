@@ -0,0 +1,293 @@
+package verify
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// Kinds of recorded interactions
+const (
+	interactionMX       = "mx"
+	interactionSMTP     = "smtp"
+	interactionCatchAll = "catch_all"
+)
+
+// Kinds of recorded errors, so replay returns errors the retry and verdict rules treat the same
+const (
+	recordedDNSError    = "dns"
+	recordedLookupError = "lookup"
+	recordedOtherError  = "other"
+)
+
+// Interaction is one recorded MX lookup, SMTP probe or catch-all confirmation, a line of a
+// recording file. Mailbox names
+// are replaced by a hash, in the mailbox field and in server replies, so recordings can be
+// attached to bug reports.
+type Interaction struct {
+	Kind      string              `json:"kind"`
+	Domain    string              `json:"domain"`
+	Mailbox   string              `json:"mailbox,omitempty"`
+	MX        *emailverifier.Mx   `json:"mx,omitempty"`
+	SMTP      *emailverifier.SMTP `json:"smtp,omitempty"`
+	Error     *RecordedError      `json:"error,omitempty"`
+	ElapsedMs int64               `json:"elapsed_ms"`
+}
+
+// RecordedError is a failed lookup or probe
+type RecordedError struct {
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	Details   string `json:"details,omitempty"`
+	Server    string `json:"server,omitempty"`
+	NotFound  bool   `json:"not_found,omitempty"`
+	Timeout   bool   `json:"timeout,omitempty"`
+	Temporary bool   `json:"temporary,omitempty"`
+}
+
+// sanitizeMailbox stands in for a mailbox name in recordings. Replay hashes the addresses it
+// verifies the same way to find their interactions.
+func sanitizeMailbox(username string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(username)))
+	return "h" + hex.EncodeToString(sum[:6])
+}
+
+// sanitizeReply replaces the address in a server reply with its sanitized form
+func sanitizeReply(text, username, domain string) string {
+	if text == "" || username == "" {
+		return text
+	}
+	address := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(username+"@"+domain))
+	return address.ReplaceAllLiteralString(text, sanitizeMailbox(username)+"@"+domain)
+}
+
+// recordError captures an error with what decides how it is handled: whether it is a DNS error
+// and how it failed, or the library's classification of an SMTP reply
+func recordError(err error, username, domain string) *RecordedError {
+	if err == nil {
+		return nil
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &RecordedError{
+			Kind:      recordedDNSError,
+			Message:   dnsErr.Err,
+			Server:    dnsErr.Server,
+			NotFound:  dnsErr.IsNotFound,
+			Timeout:   dnsErr.IsTimeout,
+			Temporary: dnsErr.IsTemporary,
+		}
+	}
+	var lookupErr *emailverifier.LookupError
+	if errors.As(err, &lookupErr) {
+		return &RecordedError{
+			Kind:    recordedLookupError,
+			Message: lookupErr.Message,
+			Details: sanitizeReply(lookupErr.Details, username, domain),
+		}
+	}
+	return &RecordedError{Kind: recordedOtherError, Message: sanitizeReply(err.Error(), username, domain)}
+}
+
+// err rebuilds the recorded error
+func (e *RecordedError) err(domain string) error {
+	if e == nil {
+		return nil
+	}
+	switch e.Kind {
+	case recordedDNSError:
+		return &net.DNSError{Err: e.Message, Name: domain, Server: e.Server, IsNotFound: e.NotFound, IsTimeout: e.Timeout, IsTemporary: e.Temporary}
+	case recordedLookupError:
+		return &emailverifier.LookupError{Message: e.Message, Details: e.Details}
+	default:
+		return errors.New(e.Message)
+	}
+}
+
+// Recorder appends the MX lookups and SMTP probes of a run to a recording file
+type Recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newRecorder(path string) (*Recorder, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	writer := bufio.NewWriter(file)
+	return &Recorder{file: file, writer: writer, encoder: json.NewEncoder(writer)}, nil
+}
+
+// record appends an interaction
+func (r *Recorder) record(interaction Interaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.encoder.Encode(interaction); err != nil {
+		logRecordingError(err)
+	}
+}
+
+// recordingErrorOnce keeps a failing recording from logging on every interaction
+var recordingErrorOnce sync.Once
+
+func logRecordingError(err error) {
+	recordingErrorOnce.Do(func() {
+		slog.Warn("failed to write recording", "error", err)
+	})
+}
+
+// Wrap returns lookup and probe functions that record what the given ones return
+func (r *Recorder) Wrap(lookupMX func(string) (*emailverifier.Mx, error), probeSMTP func(string, string) (*emailverifier.SMTP, error)) (func(string) (*emailverifier.Mx, error), func(string, string) (*emailverifier.SMTP, error)) {
+	checkMX := func(domain string) (*emailverifier.Mx, error) {
+		start := time.Now()
+		mx, err := lookupMX(domain)
+		r.record(Interaction{
+			Kind:      interactionMX,
+			Domain:    domain,
+			MX:        mx,
+			Error:     recordError(err, "", domain),
+			ElapsedMs: time.Since(start).Milliseconds(),
+		})
+		return mx, err
+	}
+	checkSMTP := func(domain, username string) (*emailverifier.SMTP, error) {
+		start := time.Now()
+		smtp, err := probeSMTP(domain, username)
+		r.record(Interaction{
+			Kind:      interactionSMTP,
+			Domain:    domain,
+			Mailbox:   sanitizeMailbox(username),
+			SMTP:      smtp,
+			Error:     recordError(err, username, domain),
+			ElapsedMs: time.Since(start).Milliseconds(),
+		})
+		return smtp, err
+	}
+	return checkMX, checkSMTP
+}
+
+// WrapCatchAll returns a catch-all confirmation that records what the given one returns, keyed
+// like the probe of the address it confirmed
+func (r *Recorder) WrapCatchAll(confirm catchAllConfirm) catchAllConfirm {
+	return func(domain, username string, probe *emailverifier.SMTP) (*emailverifier.SMTP, error) {
+		start := time.Now()
+		smtp, err := confirm(domain, username, probe)
+		r.record(Interaction{
+			Kind:      interactionCatchAll,
+			Domain:    domain,
+			Mailbox:   sanitizeMailbox(username),
+			SMTP:      smtp,
+			Error:     recordError(err, username, domain),
+			ElapsedMs: time.Since(start).Milliseconds(),
+		})
+		return smtp, err
+	}
+}
+
+// Close flushes and closes the recording
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return fmt.Errorf("failed to write recording: %w", err)
+	}
+	return r.file.Close()
+}
+
+// Replayer answers MX lookups, SMTP probes and catch-all confirmations from a recording instead of the network. The
+// interactions of a domain or mailbox are replayed in the order they were recorded, so a retried
+// failure is replayed as it happened; once they run out, the last one is repeated.
+type Replayer struct {
+	mu           sync.Mutex
+	interactions map[string][]Interaction
+}
+
+func loadReplayer(path string) (*Replayer, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer file.Close()
+
+	replayer := &Replayer{interactions: make(map[string][]Interaction)}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	count := 0
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(scanner.Bytes(), &interaction); err != nil {
+			return nil, 0, fmt.Errorf("invalid recording at line %d: %w", line, err)
+		}
+		key := replayKey(interaction.Kind, interaction.Domain, interaction.Mailbox)
+		replayer.interactions[key] = append(replayer.interactions[key], interaction)
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read recording: %w", err)
+	}
+	return replayer, count, nil
+}
+
+func replayKey(kind, domain, mailbox string) string {
+	return kind + ":" + strings.ToLower(domain) + ":" + mailbox
+}
+
+// next returns the next recorded interaction for a key
+func (r *Replayer) next(key string) (Interaction, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	queue := r.interactions[key]
+	if len(queue) == 0 {
+		return Interaction{}, false
+	}
+	if len(queue) > 1 {
+		r.interactions[key] = queue[1:]
+	}
+	return queue[0], true
+}
+
+// CheckMX replays the MX lookup of a domain
+func (r *Replayer) CheckMX(domain string) (*emailverifier.Mx, error) {
+	interaction, ok := r.next(replayKey(interactionMX, domain, ""))
+	if !ok {
+		return nil, fmt.Errorf("no MX lookup of %s in the recording", domain)
+	}
+	return interaction.MX, interaction.Error.err(domain)
+}
+
+// CheckSMTP replays the probe of an address
+func (r *Replayer) CheckSMTP(domain, username string) (*emailverifier.SMTP, error) {
+	interaction, ok := r.next(replayKey(interactionSMTP, domain, sanitizeMailbox(username)))
+	if !ok {
+		return nil, fmt.Errorf("no probe of %s in the recording", sanitizeMailbox(username)+"@"+domain)
+	}
+	return interaction.SMTP, interaction.Error.err(domain)
+}
+
+// ConfirmCatchAll replays the confirmation of an address's domain as catch-all
+func (r *Replayer) ConfirmCatchAll(domain, username string, probe *emailverifier.SMTP) (*emailverifier.SMTP, error) {
+	interaction, ok := r.next(replayKey(interactionCatchAll, domain, sanitizeMailbox(username)))
+	if !ok {
+		return nil, fmt.Errorf("no catch-all confirmation of %s in the recording", sanitizeMailbox(username)+"@"+domain)
+	}
+	return interaction.SMTP, interaction.Error.err(domain)
+}
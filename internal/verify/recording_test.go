@@ -0,0 +1,52 @@
+package verify
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+func TestRecordedCatchAllConfirmationReplays(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "run.rec.jsonl")
+	recorder, err := newRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe := &emailverifier.SMTP{HostExists: true, CatchAll: true}
+	live := recorder.WrapCatchAll(func(domain, username string, probe *emailverifier.SMTP) (*emailverifier.SMTP, error) {
+		if username == "tarpit" {
+			return nil, errors.New("failed to confirm catch-all: i/o timeout")
+		}
+		smtp := *probe
+		smtp.CatchAll, smtp.Deliverable = false, true
+		return &smtp, nil
+	})
+	confirmed, _ := live("acme.test", "Jane", probe)
+	_, liveErr := live("acme.test", "tarpit", probe)
+	if err := recorder.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayer, count, err := loadReplayer(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Fatalf("recorded %d interactions, want 2", count)
+	}
+	replayed, err := replayer.ConfirmCatchAll("acme.test", "jane", probe)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *replayed != *confirmed {
+		t.Errorf("replayed %+v, recorded %+v", *replayed, *confirmed)
+	}
+	if _, err := replayer.ConfirmCatchAll("acme.test", "tarpit", probe); err == nil || err.Error() != liveErr.Error() {
+		t.Errorf("replayed error %v, recorded %v", err, liveErr)
+	}
+	if _, err := replayer.ConfirmCatchAll("other.test", "jane", probe); err == nil {
+		t.Error("replayed a confirmation that wasn't recorded")
+	}
+}
@@ -0,0 +1,138 @@
+package verify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// InputRecord is one entry of the input file: an address field and, for object entries, the
+// caller's record ID. Entries are either "user@example.com" or {"id": "crm-42", "email": "..."}.
+type InputRecord struct {
+	ID    string
+	Email string
+}
+
+func (r *InputRecord) UnmarshalJSON(data []byte) error {
+	if data = bytes.TrimSpace(data); len(data) > 0 && data[0] != '{' {
+		return json.Unmarshal(data, &r.Email)
+	}
+
+	var entry struct {
+		ID    json.RawMessage `json:"id"`
+		Email string          `json:"email"`
+	}
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	r.Email = entry.Email
+	// IDs may be strings or numbers; keep numbers exactly as written
+	if err := json.Unmarshal(entry.ID, &r.ID); err != nil {
+		r.ID = string(entry.ID)
+	}
+	return nil
+}
+
+// splitAddresses splits a field holding several addresses separated by semicolons or commas.
+// Pieces without an @ stay attached to the previous one, so "jane@acme,com" is left whole.
+func splitAddresses(field string) []string {
+	var addresses []string
+	for _, piece := range strings.FieldsFunc(field, func(r rune) bool { return r == ';' || r == ',' }) {
+		piece = strings.TrimSpace(piece)
+		switch {
+		case piece == "":
+		case !strings.Contains(piece, "@") && len(addresses) > 0:
+			addresses[len(addresses)-1] += "," + piece
+		default:
+			addresses = append(addresses, piece)
+		}
+	}
+	if len(addresses) == 0 {
+		return []string{field}
+	}
+	return addresses
+}
+
+// recordEmails returns the addresses to verify for the records, one per record unless split
+func recordEmails(records []InputRecord, split bool) []string {
+	emails := make([]string, 0, len(records))
+	for _, record := range records {
+		if split {
+			emails = append(emails, splitAddresses(record.Email)...)
+		} else {
+			emails = append(emails, record.Email)
+		}
+	}
+	return emails
+}
+
+// addressKey is how results are matched to the rows or documents their address was read from
+func addressKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// RecordResult is the results of a record's addresses, grouped back under the record
+type RecordResult struct {
+	ID      string        `json:"id"`
+	Input   string        `json:"input"`
+	Valid   int           `json:"valid"`
+	Invalid int           `json:"invalid"`
+	Risky   int           `json:"risky"`
+	Results []EmailResult `json:"results"`
+}
+
+// groupRecords matches results to the records their addresses came from. Records without an ID
+// are numbered by their position in the input, starting at 1.
+func groupRecords(records []InputRecord, results []EmailResult) []RecordResult {
+	byEmail := make(map[string]EmailResult, len(results))
+	for _, result := range results {
+		byEmail[result.Email] = result
+		// Automatic repairs verify a different address than the input holds
+		if result.Repair != nil && result.Repair.Original != "" {
+			byEmail[result.Repair.Original] = result
+		}
+	}
+
+	groups := make([]RecordResult, 0, len(records))
+	for i, record := range records {
+		group := RecordResult{ID: record.ID, Input: record.Email, Results: []EmailResult{}}
+		if group.ID == "" {
+			group.ID = strconv.Itoa(i + 1)
+		}
+		for _, email := range splitAddresses(record.Email) {
+			result, ok := byEmail[email]
+			if !ok {
+				// Dropped or rewritten by a pre-hook
+				continue
+			}
+			switch {
+			case result.IsValid:
+				group.Valid++
+			case result.Risky:
+				group.Risky++
+			default:
+				group.Invalid++
+			}
+			group.Results = append(group.Results, result)
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// writeRecordResults writes the grouped results as {"records": [...]}
+func writeRecordResults(filename string, groups []RecordResult) error {
+	data, err := json.MarshalIndent(struct {
+		Records []RecordResult `json:"records"`
+	}{groups}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode record results: %w", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write record results: %w", err)
+	}
+	return nil
+}
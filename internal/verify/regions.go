@@ -0,0 +1,137 @@
+package verify
+
+import (
+	"bufio"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// builtinLists holds the shipped regional datasets, laid out as lists/<kind>/<region>.txt
+//
+//go:embed lists
+var builtinLists embed.FS
+
+// Kinds of regional dataset
+const (
+	listKindFree       = "free"
+	listKindDisposable = "disposable"
+)
+
+// RegionalLists supplements the library's free-provider and disposable data with regional datasets
+type RegionalLists struct {
+	free       map[string]bool
+	disposable map[string]bool
+}
+
+// loadRegionalLists loads the named regions ("all" for every region) from the shipped datasets
+// and from dataDir, which uses the same layout and may add new regions
+func loadRegionalLists(regions, dataDir string) (*RegionalLists, error) {
+	var sources []fs.FS
+	builtin, err := fs.Sub(builtinLists, "lists")
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, builtin)
+	if dataDir != "" {
+		sources = append(sources, os.DirFS(dataDir))
+	}
+
+	wanted := make(map[string]bool)
+	for _, region := range strings.Split(regions, ",") {
+		if region = strings.ToLower(strings.TrimSpace(region)); region != "" {
+			wanted[region] = true
+		}
+	}
+
+	lists := &RegionalLists{free: make(map[string]bool), disposable: make(map[string]bool)}
+	found := make(map[string]bool)
+
+	for _, source := range sources {
+		for kind, target := range map[string]map[string]bool{listKindFree: lists.free, listKindDisposable: lists.disposable} {
+			files, err := fs.Glob(source, kind+"/*.txt")
+			if err != nil {
+				return nil, err
+			}
+			for _, file := range files {
+				region := strings.TrimSuffix(path.Base(file), ".txt")
+				if !wanted["all"] && !wanted[region] {
+					continue
+				}
+				found[region] = true
+				if err := readDomainList(source, file, target); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	var missing []string
+	for region := range wanted {
+		if region != "all" && !found[region] {
+			missing = append(missing, region)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("no regional lists for: %s", strings.Join(missing, ", "))
+	}
+
+	return lists, nil
+}
+
+// readDomainList adds the domains in a list file (one per line, # comments) to target
+func readDomainList(source fs.FS, name string, target map[string]bool) error {
+	file, err := source.Open(name)
+	if err != nil {
+		return fmt.Errorf("failed to open list %s: %w", name, err)
+	}
+	defer file.Close()
+
+	return scanDomainList(file, target)
+}
+
+// scanDomainList adds the domains read from r (one per line, # comments) to target
+func scanDomainList(r io.Reader, target map[string]bool) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		target[line] = true
+	}
+	return scanner.Err()
+}
+
+// Register adds the regional disposable domains to the verifier library.
+// The library keeps them globally, so this must run before workers start.
+func (r *RegionalLists) Register() {
+	domains := make([]string, 0, len(r.disposable))
+	for domain := range r.disposable {
+		domains = append(domains, domain)
+	}
+	emailverifier.NewVerifier().AddDisposableDomains(domains)
+}
+
+// IsFree reports whether a domain is a regional free mailbox provider
+func (r *RegionalLists) IsFree(domain string) bool {
+	return r.free[strings.ToLower(domain)]
+}
+
+// IsDisposable reports whether a domain is on a regional disposable list
+func (r *RegionalLists) IsDisposable(domain string) bool {
+	return r.disposable[strings.ToLower(domain)]
+}
+
+// Counts returns the number of free-provider and disposable domains loaded
+func (r *RegionalLists) Counts() (int, int) {
+	return len(r.free), len(r.disposable)
+}
@@ -0,0 +1,163 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Repair modes
+const (
+	repairOff     = "off"
+	repairSuggest = "suggest" // verify the address as given and suggest the repaired one
+	repairAuto    = "auto"    // verify the repaired address instead
+)
+
+// Repair is how an address with copy-and-paste or encoding artifacts was, or could be, corrected.
+// Suggested repairs carry the candidate; applied ones the original input.
+type Repair struct {
+	Original  string   `json:"original,omitempty"`
+	Candidate string   `json:"candidate,omitempty"`
+	Fixes     []string `json:"fixes"`
+}
+
+// tldTypos are top-level domains mistyped often enough to correct without a suggestion
+var tldTypos = map[string]string{
+	"con": "com", "cmo": "com", "ocm": "com", "c0m": "com", "vom": "com", "xom": "com", "comm": "com",
+	"ogr": "org", "nte": "net",
+}
+
+// repairEmail strips common artifacts from an address and lists the fixes applied
+func repairEmail(raw string) (string, []string) {
+	var fixes []string
+	fix := func(applied bool, name string) {
+		if applied {
+			fixes = append(fixes, name)
+		}
+	}
+
+	// Zero-width characters and non-breaking spaces survive most trimming
+	var invisible, nbsp, space bool
+	email := strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff':
+			invisible = true
+		case r == '\u00a0' || r == '\u202f':
+			nbsp = true
+		case unicode.IsSpace(r):
+			space = true
+		default:
+			return r
+		}
+		return -1
+	}, raw)
+	fix(invisible, "invisible-characters")
+	fix(nbsp, "non-breaking-space")
+	fix(space, "whitespace")
+
+	// "Jane Doe" <jane@example.com>
+	if open := strings.LastIndexByte(email, '<'); open >= 0 {
+		if end := strings.IndexByte(email[open:], '>'); end > 0 && open > 0 {
+			email = email[open+1 : open+end]
+			fixes = append(fixes, "display-name")
+		}
+	}
+
+	unwrapped := strings.Trim(email, `<>"'.,;:`)
+	fix(unwrapped != email, "wrapping-punctuation")
+	email = unwrapped
+
+	if len(email) >= 7 && strings.EqualFold(email[:7], "mailto:") {
+		email = email[7:]
+		// mailto: links may carry a subject or body
+		if query := strings.IndexByte(email, '?'); query >= 0 {
+			email = email[:query]
+		}
+		fixes = append(fixes, "mailto")
+	}
+
+	deduped := strings.ReplaceAll(email, "@@", "@")
+	fix(deduped != email, "doubled-at")
+	email = deduped
+
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email, fixes
+	}
+	local, domain := email[:at], email[at+1:]
+
+	commas := strings.ReplaceAll(domain, ",", ".")
+	fix(commas != domain, "comma-in-domain")
+	domain = commas
+
+	collapsed := domain
+	for strings.Contains(collapsed, "..") {
+		collapsed = strings.ReplaceAll(collapsed, "..", ".")
+	}
+	fix(collapsed != domain, "repeated-dots")
+	domain = collapsed
+
+	if dot := strings.LastIndexByte(domain, '.'); dot >= 0 {
+		if tld, ok := tldTypos[strings.ToLower(domain[dot+1:])]; ok {
+			domain = domain[:dot+1] + tld
+			fixes = append(fixes, "tld-typo")
+		}
+	}
+
+	return local + "@" + domain, fixes
+}
+
+// repairInput applies the repair mode to an input address, returning the address to verify
+// and the repair to report, if any
+func repairInput(raw, mode string) (string, *Repair) {
+	if mode == "" || mode == repairOff {
+		return raw, nil
+	}
+	candidate, fixes := repairEmail(raw)
+	if len(fixes) == 0 {
+		return raw, nil
+	}
+	if mode == repairAuto {
+		return candidate, &Repair{Original: raw, Fixes: fixes}
+	}
+	return raw, &Repair{Candidate: candidate, Fixes: fixes}
+}
+
+// validRepairMode reports whether mode is a known repair mode
+func validRepairMode(mode string) bool {
+	return mode == repairOff || mode == repairSuggest || mode == repairAuto
+}
+
+// repairCandidates lists the repairable addresses of the input with their candidates
+func repairCandidates(emails []string) []Repair {
+	var repairs []Repair
+	for _, email := range emails {
+		if candidate, fixes := repairEmail(email); len(fixes) > 0 {
+			repairs = append(repairs, Repair{Original: email, Candidate: candidate, Fixes: fixes})
+		}
+	}
+	return repairs
+}
+
+// writeRepairCandidates writes the candidates in the input format, so the file can be verified
+// as is, followed by the repairs that produced them
+func writeRepairCandidates(filename string, repairs []Repair) error {
+	candidates := make([]string, 0, len(repairs))
+	for _, repair := range repairs {
+		candidates = append(candidates, repair.Candidate)
+	}
+
+	data, err := json.MarshalIndent(struct {
+		Emails  []string `json:"emails"`
+		Repairs []Repair `json:"repairs"`
+	}{candidates, repairs}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode repair candidates: %w", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write repair candidates: %w", err)
+	}
+	return nil
+}
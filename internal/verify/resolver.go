@@ -0,0 +1,79 @@
+package verify
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// resolverDialTimeout bounds connecting to the configured DNS server
+const resolverDialTimeout = 5 * time.Second
+
+// newResolver returns the resolver of a run's DNS lookups as configured: through the embedded
+// cache, forwarding to -dns-upstreams, else -resolver, else the system's nameservers; with the
+// cache disabled straight to -resolver, or the system resolver. The process's own resolver is
+// left alone, so runs with different settings can share a process.
+func newResolver(config Config) (*net.Resolver, *DNSCache, error) {
+	if config.DNSCacheSize == 0 {
+		if config.Resolver == "" {
+			return net.DefaultResolver, nil, nil
+		}
+		resolver, addr := serverResolver(config.Resolver)
+		slog.Info("resolving DNS through a custom resolver", "resolver", addr)
+		return resolver, nil, nil
+	}
+	upstreams := splitList(config.DNSUpstreams)
+	if len(upstreams) == 0 && config.Resolver != "" {
+		upstreams = []string{config.Resolver}
+	}
+	if len(upstreams) == 0 {
+		upstreams = systemNameservers()
+	}
+	cache, err := newDNSCache(upstreams, config.DNSCacheSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	log := slog.Debug
+	if config.DNSUpstreams != "" || config.Resolver != "" {
+		log = slog.Info
+	}
+	log("caching DNS lookups", "upstreams", strings.Join(cache.upstreams, ","), "size", config.DNSCacheSize)
+	return cache.Resolver(), cache, nil
+}
+
+// serverResolver returns a resolver sending every lookup to the DNS server at addr instead of
+// the system's, and addr with the port, which defaults to 53
+func serverResolver(addr string) (*net.Resolver, string) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			dialer := net.Dialer{Timeout: resolverDialTimeout}
+			conn, err := dialer.DialContext(ctx, network, addr)
+			if err != nil {
+				return nil, err
+			}
+			return dnsTraffic.count(conn), nil
+		},
+	}, addr
+}
+
+// lookupMXWith returns an MX lookup through the resolver, as net.LookupMX does through the
+// system's
+func lookupMXWith(resolver *net.Resolver) func(string) ([]*net.MX, error) {
+	return func(name string) ([]*net.MX, error) {
+		return resolver.LookupMX(context.Background(), name)
+	}
+}
+
+// resolvingClient returns an HTTP client whose connections look up host names with the resolver
+func resolvingClient(resolver *net.Resolver, timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Resolver: resolver}).DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
@@ -0,0 +1,207 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// netCounter counts the connections of one protocol, the writes made on them and the bytes they
+// carried, over the life of the process
+type netCounter struct {
+	connections atomic.Int64
+	writes      atomic.Int64
+	sent        atomic.Int64
+	received    atomic.Int64
+}
+
+var (
+	// dnsTraffic is the queries sent to DNS servers, by the DNS cache or to -resolver; each query
+	// is one write
+	dnsTraffic netCounter
+	// smtpTraffic is the connections to MX hosts, directly or through proxies
+	smtpTraffic netCounter
+)
+
+// count returns the connection counting its traffic. Packet connections stay packet connections,
+// as the Go resolver frames its queries by whether they are.
+func (c *netCounter) count(conn net.Conn) net.Conn {
+	c.connections.Add(1)
+	counted := &countedConn{Conn: conn, counter: c}
+	if packet, ok := conn.(net.PacketConn); ok {
+		return &countedPacketConn{countedConn: counted, packet: packet}
+	}
+	return counted
+}
+
+type countedConn struct {
+	net.Conn
+	counter *netCounter
+}
+
+func (c *countedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.counter.received.Add(int64(n))
+	return n, err
+}
+
+func (c *countedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.counter.writes.Add(1)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+type countedPacketConn struct {
+	*countedConn
+	packet net.PacketConn
+}
+
+func (c *countedPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.packet.ReadFrom(b)
+	c.counter.received.Add(int64(n))
+	return n, addr, err
+}
+
+func (c *countedPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.packet.WriteTo(b, addr)
+	c.counter.writes.Add(1)
+	c.counter.sent.Add(int64(n))
+	return n, err
+}
+
+// netTotals is what a netCounter has counted so far
+type netTotals struct {
+	connections, writes, sent, received int64
+}
+
+func (c *netCounter) totals() netTotals {
+	return netTotals{c.connections.Load(), c.writes.Load(), c.sent.Load(), c.received.Load()}
+}
+
+// resourceSnapshot is the process's resource use at a point in time
+type resourceSnapshot struct {
+	at        time.Time
+	cpu       time.Duration
+	dnsHits   int64
+	dns, smtp netTotals
+}
+
+func takeResourceSnapshot(dns *DNSCache) resourceSnapshot {
+	s := resourceSnapshot{at: time.Now(), dns: dnsTraffic.totals(), smtp: smtpTraffic.totals()}
+	s.cpu, _, _ = processRusage()
+	s.dnsHits, _ = dns.Stats()
+	return s
+}
+
+// ResourceUsage measures what a run cost: the CPU time and memory of the process, the DNS queries
+// and SMTP connections it made, and the bytes they carried
+type ResourceUsage struct {
+	start resourceSnapshot
+	dns   *DNSCache
+}
+
+func newResourceUsage(dns *DNSCache) *ResourceUsage {
+	return &ResourceUsage{start: takeResourceSnapshot(dns), dns: dns}
+}
+
+// ResourceReport is the resources a run used, in total and per thousand addresses, for sizing
+// the hosts of larger runs
+type ResourceReport struct {
+	Emails            int64                `json:"emails"`
+	ElapsedSeconds    float64              `json:"elapsed_seconds"`
+	CPUSeconds        float64              `json:"cpu_seconds"`
+	PeakRSSBytes      int64                `json:"peak_rss_bytes"`
+	DNSQueries        int64                `json:"dns_queries"`
+	DNSCacheHits      int64                `json:"dns_cache_hits"`
+	DNSBytesSent      int64                `json:"dns_bytes_sent"`
+	DNSBytesReceived  int64                `json:"dns_bytes_received"`
+	SMTPConnections   int64                `json:"smtp_connections"`
+	SMTPBytesSent     int64                `json:"smtp_bytes_sent"`
+	SMTPBytesReceived int64                `json:"smtp_bytes_received"`
+	PerThousand       *ResourcePerThousand `json:"per_1000_emails,omitempty"`
+}
+
+// ResourcePerThousand is the resources used per thousand addresses checked
+type ResourcePerThousand struct {
+	CPUSeconds      float64 `json:"cpu_seconds"`
+	DNSQueries      float64 `json:"dns_queries"`
+	SMTPConnections float64 `json:"smtp_connections"`
+	Bytes           float64 `json:"bytes"`
+}
+
+// Report returns the resources used since the run started, having checked emails addresses
+func (u *ResourceUsage) Report(emails int64) ResourceReport {
+	now := takeResourceSnapshot(u.dns)
+	_, peakRSS, _ := processRusage()
+	cpu := (now.cpu - u.start.cpu).Seconds()
+	report := ResourceReport{
+		Emails:            emails,
+		ElapsedSeconds:    roundTo(now.at.Sub(u.start.at).Seconds(), 2),
+		CPUSeconds:        roundTo(cpu, 2),
+		PeakRSSBytes:      peakRSS,
+		DNSQueries:        now.dns.writes - u.start.dns.writes,
+		DNSCacheHits:      now.dnsHits - u.start.dnsHits,
+		DNSBytesSent:      now.dns.sent - u.start.dns.sent,
+		DNSBytesReceived:  now.dns.received - u.start.dns.received,
+		SMTPConnections:   now.smtp.connections - u.start.smtp.connections,
+		SMTPBytesSent:     now.smtp.sent - u.start.smtp.sent,
+		SMTPBytesReceived: now.smtp.received - u.start.smtp.received,
+	}
+	if emails > 0 {
+		per := func(v float64) float64 {
+			return roundTo(v*1000/float64(emails), 2)
+		}
+		report.PerThousand = &ResourcePerThousand{
+			CPUSeconds:      per(cpu),
+			DNSQueries:      per(float64(report.DNSQueries)),
+			SMTPConnections: per(float64(report.SMTPConnections)),
+			Bytes:           per(float64(report.bytes())),
+		}
+	}
+	return report
+}
+
+func (r ResourceReport) bytes() int64 {
+	return r.DNSBytesSent + r.DNSBytesReceived + r.SMTPBytesSent + r.SMTPBytesReceived
+}
+
+// Log prints the report as one record, next to the run summary
+func (r ResourceReport) Log() {
+	attrs := []any{
+		"cpu", time.Duration(r.CPUSeconds * float64(time.Second)).Round(10 * time.Millisecond),
+		"peak_rss_mb", roundTo(float64(r.PeakRSSBytes)/(1<<20), 1),
+		"dns_queries", r.DNSQueries,
+		"dns_cache_hits", r.DNSCacheHits,
+		"smtp_connections", r.SMTPConnections,
+		"bytes_sent", r.DNSBytesSent + r.SMTPBytesSent,
+		"bytes_received", r.DNSBytesReceived + r.SMTPBytesReceived,
+	}
+	if r.PerThousand != nil {
+		attrs = append(attrs, "cpu_seconds_per_1000", r.PerThousand.CPUSeconds, "bytes_per_1000", r.PerThousand.Bytes)
+	}
+	slog.Info("resource usage", attrs...)
+}
+
+// writeResourceReport writes the report as a JSON document
+func writeResourceReport(filename string, report ResourceReport) error {
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode resource report: %w", err)
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write resource report: %w", err)
+	}
+	return nil
+}
+
+// roundTo rounds a value to the given number of decimals
+func roundTo(value float64, decimals int) float64 {
+	scale := math.Pow(10, float64(decimals))
+	return math.Round(value*scale) / scale
+}
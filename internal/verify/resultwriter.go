@@ -0,0 +1,184 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// ResultWriter writes invalid emails to the output as they come in, so a run that dies partway
+// through keeps everything up to the last flush instead of losing it all
+type ResultWriter interface {
+	Write(email InvalidEmail) error
+	// Flush pushes buffered entries to the file
+	Flush() error
+	// Close finishes the output, with the run's statistics where the format has room for them
+	Close(stats *Stats) error
+}
+
+// newResultWriter creates the output for invalid emails in its format: JSON Lines, delimited rows
+// with the configured columns, or the JSON document. The file is created at path, which differs
+// from name for object storage outputs staged locally.
+func newResultWriter(name, path string, config Config, columns []Column) (ResultWriter, error) {
+	var w io.Writer = os.Stdout
+	var file *os.File
+	if name != StdoutOutput {
+		created, err := os.Create(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file %s: %w", path, err)
+		}
+		w, file = created, created
+	}
+	format := config.formatOf(name)
+	if delimiter, ok := formatDelimiter(format); ok {
+		return newDelimitedWriter(w, file, columns, invalidFields, delimiter, config.OutputHeader), nil
+	}
+	if format == outputJSONL {
+		return newLineResultWriter(w, file), nil
+	}
+	return newDocumentResultWriter(w, file, config.outputFormat()), nil
+}
+
+// closeOutput closes the output file, leaving stdout open
+func closeOutput(file *os.File) error {
+	if file == nil {
+		return nil
+	}
+	return file.Close()
+}
+
+// lineResultWriter writes one JSON entry per line. Every flushed line is a complete entry, so
+// this is the format to pick for outputs that must survive a crash.
+type lineResultWriter struct {
+	file    *os.File // nil for stdout, which is left open
+	buf     *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newLineResultWriter(w io.Writer, file *os.File) *lineResultWriter {
+	buf := bufio.NewWriterSize(w, 1024*1024) // 1MB buffer
+	return &lineResultWriter{file: file, buf: buf, encoder: json.NewEncoder(buf)}
+}
+
+func (l *lineResultWriter) Write(email InvalidEmail) error {
+	if err := l.encoder.Encode(email); err != nil {
+		return fmt.Errorf("failed to write result: %w", err)
+	}
+	return nil
+}
+
+func (l *lineResultWriter) Flush() error { return l.buf.Flush() }
+
+func (l *lineResultWriter) Close(*Stats) error {
+	if err := l.buf.Flush(); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	return closeOutput(l.file)
+}
+
+// delimitedWriter writes entries as delimited rows with the configured columns, each looked up
+// in fields
+type delimitedWriter[T any] struct {
+	file    *os.File // nil for stdout
+	buf     *bufio.Writer
+	writer  *csv.Writer
+	columns []Column
+	fields  map[string]func(T) string
+	row     []string
+}
+
+func newDelimitedWriter[T any](w io.Writer, file *os.File, columns []Column, fields map[string]func(T) string, delimiter rune, header bool) *delimitedWriter[T] {
+	buf := bufio.NewWriterSize(w, 1024*1024) // 1MB buffer
+	d := &delimitedWriter[T]{file: file, buf: buf, writer: csv.NewWriter(buf), columns: columns, fields: fields, row: make([]string, len(columns))}
+	d.writer.Comma = delimiter
+	if header {
+		for i, column := range columns {
+			d.row[i] = column.Header
+		}
+		d.writer.Write(d.row)
+	}
+	return d
+}
+
+func (d *delimitedWriter[T]) Write(entry T) error {
+	for i, column := range d.columns {
+		if column.Field == "" {
+			d.row[i] = column.Value
+		} else {
+			d.row[i] = d.fields[column.Field](entry)
+		}
+	}
+	return d.writer.Write(d.row)
+}
+
+func (d *delimitedWriter[T]) Flush() error {
+	d.writer.Flush()
+	if err := d.writer.Error(); err != nil {
+		return err
+	}
+	return d.buf.Flush()
+}
+
+func (d *delimitedWriter[T]) Close(*Stats) error {
+	if err := d.Flush(); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	return closeOutput(d.file)
+}
+
+// documentResultWriter writes the output JSON document, with the invalid emails one per line and
+// the run's statistics as a footer once the run is done. A document cut short by a crash is
+// missing its closing brackets, but every line flushed before it is a complete entry.
+type documentResultWriter struct {
+	file   *os.File // nil for stdout
+	stream *jsonStream
+}
+
+func newDocumentResultWriter(w io.Writer, file *os.File, format OutputFormat) *documentResultWriter {
+	stream := newJSONStream(w, format)
+	stream.BeginObject(false)
+	stream.Key("invalid_emails")
+	stream.BeginArray(false)
+	return &documentResultWriter{file: file, stream: stream}
+}
+
+func (d *documentResultWriter) Write(email InvalidEmail) error {
+	d.stream.Value(email)
+	return d.stream.err
+}
+
+func (d *documentResultWriter) Flush() error { return d.stream.Flush() }
+
+func (d *documentResultWriter) Close(stats *Stats) error {
+	d.stream.End()
+	writeStatsFooter(d.stream, stats)
+	d.stream.End()
+	if err := d.stream.Close(); err != nil {
+		return fmt.Errorf("failed to write results: %w", err)
+	}
+	return closeOutput(d.file)
+}
+
+// writeStatsFooter writes the run's statistics into the open output document
+func writeStatsFooter(stream *jsonStream, stats *Stats) {
+	stream.Field("checked_at", time.Now().Format(time.RFC3339))
+	stream.Field("completed", !stats.Interrupted)
+	stream.Field("total_checked", stats.TotalChecked)
+	stream.Field("total_valid", stats.TotalValid)
+	stream.Field("total_invalid", stats.TotalInvalid)
+	stream.Field("total_risky", stats.TotalRisky)
+	if stats.Duplicates > 0 {
+		stream.Field("duplicates_dropped", stats.Duplicates)
+	}
+	stream.Field("processing_time_seconds", fixedDecimals(time.Since(stats.StartTime).Seconds(), 2))
+}
+
+// groupsDocument reports whether the output is a JSON document grouped by domain, which can only
+// be written once every result is in
+func groupsDocument(config Config) bool {
+	return config.GroupBy == groupByDomain && config.formatOf(config.OutputFile) == outputJSON
+}
@@ -0,0 +1,93 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// maxRetryBackoff caps the wait between attempts however many retries are allowed
+const maxRetryBackoff = time.Minute
+
+// errEmailTimeout stops verifying an address that ran past -email-timeout
+var errEmailTimeout = errors.New("ran out of time (-email-timeout)")
+
+// RetryPolicy retries verification steps that failed for transient reasons, such as DNS
+// timeouts and dropped SMTP connections, rather than reporting network noise as invalid addresses
+type RetryPolicy struct {
+	Retries  int
+	Backoff  time.Duration // before the first retry, doubling for each one after
+	Deadline time.Time     // past which no retry starts, with -email-timeout
+}
+
+// do calls step until it succeeds, fails for good or runs out of retries, returning how many
+// retries it made
+func (p RetryPolicy) do(step func() error) (int, error) {
+	err := step()
+	retries := 0
+	for ; err != nil && retries < p.Retries && transientError(err); retries++ {
+		delay := p.delay(retries)
+		if !p.Deadline.IsZero() && time.Now().Add(delay).After(p.Deadline) {
+			break
+		}
+		time.Sleep(delay)
+		err = step()
+	}
+	return retries, err
+}
+
+// delay is the backoff before the given retry, jittered by up to 25% either way so workers that
+// failed together don't retry in lockstep
+func (p RetryPolicy) delay(retry int) time.Duration {
+	backoff := min(p.Backoff<<retry, maxRetryBackoff)
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(float64(backoff) * (0.75 + rand.Float64()/2))
+}
+
+// retriedError notes how many attempts a step that still failed was given
+func retriedError(err error, retries int) error {
+	if err == nil || retries == 0 {
+		return err
+	}
+	return fmt.Errorf("%w (after %d attempts)", err, retries+1)
+}
+
+// transientError reports whether a failed DNS lookup or SMTP session may well succeed if tried
+// again. Answers about the address or domain itself, such as NXDOMAIN or 550, are not.
+func transientError(err error) bool {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return dnsErr.IsTimeout || dnsErr.IsTemporary
+	}
+
+	var lookupErr *emailverifier.LookupError
+	if errors.As(err, &lookupErr) && lookupErr != nil {
+		switch lookupErr.Message {
+		case emailverifier.ErrTimeout, emailverifier.ErrTryAgainLater, emailverifier.ErrMailboxBusy,
+			emailverifier.ErrExceededMessagingLimits, emailverifier.ErrTooManyRCPT:
+			return true
+		}
+		// Connection failures are passed through with the network error as the message
+		err = errors.New(lookupErr.Details)
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	message := strings.ToLower(err.Error())
+	for _, transient := range []string{"connection refused", "connection reset", "broken pipe", "i/o timeout"} {
+		if strings.Contains(message, transient) {
+			return true
+		}
+	}
+	// The server hung up mid-session
+	return message == "eof" || strings.HasSuffix(message, ": eof")
+}
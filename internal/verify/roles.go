@@ -0,0 +1,72 @@
+package verify
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// roleSeparators end a role prefix inside a longer local part, as in sales-emea or noreply+bounces
+const roleSeparators = ".-_+"
+
+// RoleAccounts recognizes role addresses from a custom list of prefixes, replacing the library's
+// fixed list. A local part is a role account when it is a listed prefix, or starts with one
+// followed by a separator.
+type RoleAccounts struct {
+	prefixes map[string]bool
+}
+
+// loadRoleAccounts reads the prefixes from a file listing one per line, if spec names one, or
+// else from the comma-separated list in spec
+func loadRoleAccounts(spec string) (*RoleAccounts, error) {
+	var prefixes []string
+	if info, err := os.Stat(spec); err == nil && !info.IsDir() {
+		file, err := os.Open(spec)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open role prefix list: %w", err)
+		}
+		defer file.Close()
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			prefixes = append(prefixes, scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read role prefix list: %w", err)
+		}
+	} else {
+		prefixes = strings.Split(spec, ",")
+	}
+
+	roles := &RoleAccounts{prefixes: make(map[string]bool)}
+	for _, prefix := range prefixes {
+		prefix = strings.ToLower(strings.TrimSpace(prefix))
+		if prefix == "" || strings.HasPrefix(prefix, "#") {
+			continue
+		}
+		roles.prefixes[prefix] = true
+	}
+	if len(roles.prefixes) == 0 {
+		return nil, fmt.Errorf("no role prefixes in %q", spec)
+	}
+	return roles, nil
+}
+
+// Match reports whether a local part belongs to a role rather than a person
+func (r *RoleAccounts) Match(username string) bool {
+	username = strings.ToLower(username)
+	if r.prefixes[username] {
+		return true
+	}
+	for i, c := range username {
+		if strings.ContainsRune(roleSeparators, c) && r.prefixes[username[:i]] {
+			return true
+		}
+	}
+	return false
+}
+
+// Len returns how many prefixes are listed
+func (r *RoleAccounts) Len() int {
+	return len(r.prefixes)
+}
@@ -0,0 +1,112 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+// rpcProcess talks JSON-RPC 2.0 to an external program over stdin/stdout, one message per line.
+// Calls are serialized, so a program only ever sees one request at a time.
+// It implements extension for exec: specs.
+type rpcProcess struct {
+	name  string
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	mu      sync.Mutex
+	scanner *bufio.Scanner
+	nextID  int
+}
+
+// rpcRequest is a JSON-RPC 2.0 request
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int    `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response
+type rpcResponse struct {
+	ID     int             `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// startRPCProcess starts a program with arguments; its stderr is passed through
+func startRPCProcess(name string, args ...string) (*rpcProcess, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdin of %s: %w", name, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open stdout of %s: %w", name, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	return &rpcProcess{name: name, cmd: cmd, stdin: stdin, scanner: scanner}, nil
+}
+
+// Call sends one request and decodes the response's result into out.
+// A null result leaves out untouched.
+func (p *rpcProcess) Call(method string, params, out any) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextID++
+	request, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: p.nextID, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %w", method, err)
+	}
+	if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+		return fmt.Errorf("failed to write to %s: %w", p.name, err)
+	}
+
+	if !p.scanner.Scan() {
+		if err := p.scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read from %s: %w", p.name, err)
+		}
+		return fmt.Errorf("%s exited", p.name)
+	}
+
+	var response rpcResponse
+	if err := json.Unmarshal(p.scanner.Bytes(), &response); err != nil {
+		return fmt.Errorf("invalid response from %s: %w", p.name, err)
+	}
+	if response.ID != p.nextID {
+		return fmt.Errorf("%s answered request %d, expected %d", p.name, response.ID, p.nextID)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %s (code %d)", p.name, response.Error.Message, response.Error.Code)
+	}
+	if len(response.Result) == 0 || string(response.Result) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, out); err != nil {
+		return fmt.Errorf("invalid %s result from %s: %w", method, p.name, err)
+	}
+	return nil
+}
+
+// Close ends the program's input and waits for it to exit
+func (p *rpcProcess) Close() error {
+	p.stdin.Close()
+	return p.cmd.Wait()
+}
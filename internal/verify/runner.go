@@ -0,0 +1,98 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Runner is the bulk-verification engine of the command-line tool, for programs embedding it: the
+// worker pool, lookups, checks and verdict rules of a run, without its input and output files.
+// A runner can verify several lists, concurrently too; its caches and rate limits span all of them.
+type Runner struct {
+	config Config
+
+	start   sync.Once
+	lookups *Lookups
+	err     error // of starting the lookups
+}
+
+// NewRunner creates a runner with the given settings, usually DefaultConfig with some changed.
+// It only checks them: the lookups, extensions and background checks they call for start with
+// the first Verify or Stream. Close it once done to stop them.
+func NewRunner(config Config) (*Runner, error) {
+	if err := config.Normalize(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return &Runner{config: config}, nil
+}
+
+// started returns the runner's lookups, starting them on first use
+func (r *Runner) started() (*Lookups, error) {
+	r.start.Do(func() {
+		if r.lookups, r.err = newLookups(r.config); r.err != nil {
+			r.err = fmt.Errorf("failed to configure lookups: %w", r.err)
+		}
+	})
+	return r.lookups, r.err
+}
+
+// Verify verifies the emails, returning their results in input order and the run's statistics,
+// or an error if the lookups failed to start. Addresses left unverified when ctx is cancelled are
+// missing from the results.
+func (r *Runner) Verify(ctx context.Context, emails []string) ([]EmailResult, *Stats, error) {
+	lookups, err := r.started()
+	if err != nil {
+		return nil, nil, err
+	}
+	results, stats := verifyList(ctx, emails, r.config, lookups)
+	return results, stats, nil
+}
+
+// Stream verifies the emails like Verify, calling handle with each result as it comes in rather
+// than keeping them, so lists of any size fit in memory. handle is called from one goroutine.
+func (r *Runner) Stream(ctx context.Context, emails []string, handle func(EmailResult)) (*Stats, error) {
+	lookups, err := r.started()
+	if err != nil {
+		return nil, err
+	}
+	config := r.config
+	config.onResult = handle
+	_, stats := verifyList(ctx, emails, config, lookups)
+	return stats, nil
+}
+
+// ReadEmails reads the addresses of an input file as the command-line tool does, in the format
+// of its extension or the configured -format
+func (r *Runner) ReadEmails(filename string) ([]string, error) {
+	format := inputFormatFor(filename, r.config.InputFormat)
+	records, err := readRecordsStreaming(filename, format, r.config.csvInput())
+	if err != nil {
+		return nil, err
+	}
+	return recordEmails(records, r.config.SplitRecords || contactFormat(format)), nil
+}
+
+// WriteDetails writes results to a details file as the command-line tool does, in the format of
+// its extension or the configured -output-format
+func (r *Runner) WriteDetails(filename string, results []EmailResult) error {
+	var columns []Column
+	if _, ok := formatDelimiter(r.config.formatOf(filename)); ok {
+		parsed, err := parseColumns(r.config.DetailColumns, resultFields)
+		if err != nil {
+			return fmt.Errorf("invalid details columns: %w", err)
+		}
+		columns = parsed
+	}
+	return writeDetailsFile(filename, results, columns, r.config)
+}
+
+// Close stops the runner's background checks and extension processes, if started. The runner
+// can't verify anything after.
+func (r *Runner) Close() {
+	r.start.Do(func() { r.err = errors.New("runner is closed") })
+	if r.lookups != nil {
+		r.lookups.Close()
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package verify
+
+import "time"
+
+// processRusage isn't available here, so CPU time and peak memory are reported as zero
+func processRusage() (cpu time.Duration, peakRSS int64, ok bool) {
+	return 0, 0, false
+}
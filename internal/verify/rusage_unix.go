@@ -0,0 +1,25 @@
+//go:build unix
+
+package verify
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// processRusage returns the CPU time the process has used, user and system, and its peak
+// resident set size in bytes
+func processRusage() (cpu time.Duration, peakRSS int64, ok bool) {
+	var usage syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); err != nil {
+		return 0, 0, false
+	}
+	cpu = time.Duration(usage.Utime.Nano() + usage.Stime.Nano())
+	peakRSS = int64(usage.Maxrss)
+	// macOS reports bytes, Linux and the BSDs kilobytes
+	if runtime.GOOS != "darwin" && runtime.GOOS != "ios" {
+		peakRSS *= 1024
+	}
+	return cpu, peakRSS, true
+}
@@ -0,0 +1,40 @@
+package verify
+
+// dispatchOrder returns the order to verify the emails in, as indexes into them. With fair
+// scheduling, domains take turns, one address each in order of first appearance, so a list sorted
+// by domain spreads its probes across providers instead of working through them one at a time.
+func dispatchOrder(emails []string, fair bool) []int {
+	order := make([]int, 0, len(emails))
+	if !fair {
+		for i := range emails {
+			order = append(order, i)
+		}
+		return order
+	}
+
+	var queues [][]int
+	byDomain := make(map[string]int)
+	for i, email := range emails {
+		domain := emailDomain(email)
+		q, ok := byDomain[domain]
+		if !ok {
+			q = len(queues)
+			byDomain[domain] = q
+			queues = append(queues, nil)
+		}
+		queues[q] = append(queues[q], i)
+	}
+
+	// Each round takes the next address of every domain with some left
+	for len(order) < len(emails) {
+		active := queues[:0]
+		for _, queue := range queues {
+			order = append(order, queue[0])
+			if len(queue) > 1 {
+				active = append(active, queue[1:])
+			}
+		}
+		queues = active
+	}
+	return order
+}
@@ -0,0 +1,566 @@
+package verify
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// ServeOptions are the settings of the server on top of those of its runs
+type ServeOptions struct {
+	Listen     string
+	Token      string // API token required of every request, if set
+	APIKeys    string // comma-separated keys also accepted, as no-code platforms send them
+	GRPCListen string // address of the gRPC Verifier service, empty for none
+
+	QueueSize  int           // jobs waiting to run
+	Retention  time.Duration // how long finished jobs are kept, 0 until restart
+	MaxPending int           // turn jobs away past this many addresses waiting, 0 for no limit
+	MaxMemory  int           // turn jobs away past this many MB of heap in use, 0 for no limit
+	MaxWorkers int           // most workers a job may ask for, 0 for the run's
+	MinJobRate string        // shortest rate limit a job may ask for, empty for the run's
+	MaxBatch   int           // most addresses of a POST /verify/batch
+	FeedSize   int           // latest results kept for /connector/results, 0 for none
+
+	// Distributed mode hands jobs to workers in units of UnitSize addresses, checkpointed every
+	// CheckpointSize and reassigned after WorkerTimeout without heartbeats
+	Distributed    bool
+	UnitSize       int
+	CheckpointSize int
+	WorkerTimeout  time.Duration
+
+	// Operator runs VerificationJobs on worker pods, reconciling every Resync
+	Operator        bool
+	KubeAPI         string // API server URL, empty for the cluster the server runs in
+	Resync          time.Duration
+	ServiceURL      string // URL worker pods reach the server at
+	WorkerImage     string
+	WorkerEnvSecret string
+
+	// LeaderElect elects a leader among instances with the Lease LeaseName, held as Identity
+	LeaderElect   bool
+	LeaseName     string
+	LeaseDuration time.Duration
+	Identity      string
+}
+
+// Serve starts the HTTP API: batch verification jobs and the domain intelligence accumulated
+// by past runs. Domain lookups never trigger a verification.
+func Serve(config Config, opts ServeOptions) error {
+	if config.DomainStore == "" {
+		config.DomainStore = dataDir + "/domains.json"
+	}
+	// Jobs only produce the invalid emails document
+	config.DetailsFile = ""
+	config.ValidFile = ""
+	config.FreeFile = ""
+	config.OutputTemplate = ""
+	config.SplitRecords = false
+	config.WarehouseStage = ""
+
+	limits := JobLimits{MaxWorkers: opts.MaxWorkers, MinRate: config.RateLimit}
+	if limits.MaxWorkers <= 0 {
+		limits.MaxWorkers = config.Workers
+	}
+	if opts.MinJobRate != "" {
+		rate, err := time.ParseDuration(opts.MinJobRate)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("invalid -min-job-rate %q, expected a non-negative duration", opts.MinJobRate)
+		}
+		limits.MinRate = rate
+	}
+
+	if opts.MaxBatch < 1 {
+		return fmt.Errorf("invalid -max-batch %d, expected at least 1", opts.MaxBatch)
+	}
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	lookups, err := newLookups(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure lookups: %w", err)
+	}
+	defer lookups.Close()
+
+	store := lookups.Domains
+	jobs := newJobManager(config, lookups, opts.QueueSize, opts.Retention, AdmissionLimits{MaxPending: opts.MaxPending, MaxMemoryMB: opts.MaxMemory})
+	jobs.feed = newResultFeed(opts.FeedSize)
+	if opts.Distributed {
+		if opts.UnitSize < 1 || opts.WorkerTimeout < 3*time.Second || opts.CheckpointSize < 0 {
+			return errors.New("invalid distributed mode settings: -unit-size must be at least 1, -worker-timeout at least 3s and -checkpoint-size not negative")
+		}
+		jobs.work = newWorkQueue(opts.UnitSize, opts.WorkerTimeout, opts.CheckpointSize)
+	}
+	var kube *kubeClient
+	if opts.Operator || opts.LeaderElect {
+		if kube, err = newKubeClient(opts.KubeAPI); err != nil {
+			return fmt.Errorf("failed to configure Kubernetes client: %w", err)
+		}
+	}
+	// The operator is a singleton: with leader election only the leader runs it
+	onLeading := func() {}
+	if opts.Operator {
+		if jobs.work == nil || opts.WorkerImage == "" || opts.Resync <= 0 {
+			return errors.New("invalid operator settings: -kubernetes requires -distributed, -worker-image and a positive -resync")
+		}
+		onLeading = newOperator(kube, jobs, config, limits, OperatorOptions{
+			Resync:          opts.Resync,
+			ServiceURL:      opts.ServiceURL,
+			WorkerImage:     opts.WorkerImage,
+			WorkerEnvSecret: opts.WorkerEnvSecret,
+		}).Run
+	}
+	// The gRPC server and leader election end the server as its own listener failing does
+	failed := make(chan error, 3)
+	var elector *LeaderElector
+	if opts.LeaderElect {
+		if opts.LeaseDuration < 3*time.Second {
+			return errors.New("invalid leader election settings: -lease-duration must be at least 3s")
+		}
+		elector = newLeaderElector(kube, opts.LeaseName, opts.Identity, opts.LeaseDuration)
+		go func() { failed <- elector.Run(onLeading) }()
+	} else {
+		go onLeading()
+	}
+	uploads, err := newInputUploads(opts.Retention)
+	if err != nil {
+		return fmt.Errorf("failed to configure uploads: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	// Standbys are alive but not ready, which keeps them out of the Service until they lead
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if elector != nil && !elector.IsLeader() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "standby"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	})
+
+	mux.HandleFunc("POST /jobs", func(w http.ResponseWriter, r *http.Request) {
+		options, err := parseJobOptions(r.URL.Query(), config, limits)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		// Turn the job away before reading a possibly huge body if the server is already saturated
+		if err := jobs.Admit(0); err != nil {
+			rejectJob(w, err)
+			return
+		}
+		var emails []string
+		uploadID := r.URL.Query().Get("upload")
+		if uploadID != "" {
+			emails, err = decodeUpload(uploads, uploadID)
+		} else {
+			emails, err = decodeEmails(r.Body, r.ContentLength)
+		}
+		if errors.Is(err, errUploadNotFound) {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		status, err := jobs.Submit(emails, options)
+		if err != nil {
+			rejectJob(w, err)
+			return
+		}
+		if uploadID != "" {
+			uploads.Remove(uploadID)
+		}
+		slog.Info("queued job", "job", status.ID, "emails", status.Total)
+		writeJSON(w, http.StatusAccepted, status)
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		status, ok := jobs.Status(r.PathValue("id"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, status)
+	})
+	mux.HandleFunc("GET /jobs/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		streamJobStatus(w, r, jobs)
+	})
+	mux.HandleFunc("GET /jobs/{id}/results", func(w http.ResponseWriter, r *http.Request) {
+		results, status, ok := jobs.Results(r.PathValue("id"))
+		switch {
+		case !ok:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		case status.Status == jobFailed:
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": status.Error})
+		case status.Status != jobDone:
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "job is " + status.Status})
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			if status.ExpiresAt != nil {
+				w.Header().Set("Expires", status.ExpiresAt.UTC().Format(http.TimeFormat))
+			}
+			w.Write(results)
+		}
+	})
+	mux.HandleFunc("DELETE /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		found, err := jobs.Delete(id)
+		switch {
+		case !found:
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		case err != nil:
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		default:
+			slog.Info("deleted job", "job", id)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	handleVerify(mux, jobs, config, limits, opts.MaxBatch)
+	handleConnector(mux, jobs, config, limits)
+
+	mux.HandleFunc("POST /uploads", func(w http.ResponseWriter, r *http.Request) {
+		length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+		if err != nil || length <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Upload-Length header must give the size of the input file"})
+			return
+		}
+		upload, err := uploads.Create(length)
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		slog.Info("started upload", "upload", upload.ID, "bytes", upload.Length)
+		w.Header().Set("Location", "/uploads/"+upload.ID)
+		writeUpload(w, http.StatusCreated, upload)
+	})
+	mux.HandleFunc("GET /uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		upload, err := uploads.Get(r.PathValue("id"))
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeUpload(w, http.StatusOK, upload)
+	})
+	mux.HandleFunc("PATCH /uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Upload-Offset header must give where the chunk starts"})
+			return
+		}
+		upload, err := uploads.Append(r.PathValue("id"), offset, r.Body)
+		switch {
+		case errors.Is(err, errUploadNotFound):
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+		case errors.Is(err, errUploadOffset), errors.Is(err, errUploadTooLong):
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		case err != nil:
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		default:
+			w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("DELETE /uploads/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if err := uploads.Remove(r.PathValue("id")); err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	if jobs.work != nil {
+		handleWork(mux, jobs.work)
+	}
+	mux.HandleFunc("GET /metrics", serveMetrics(jobs, lookups))
+
+	mux.HandleFunc("GET /domains", func(w http.ResponseWriter, r *http.Request) {
+		reloadDomainStore(store)
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil {
+			limit = 100
+		}
+		domains, total := store.List(max(offset, 0), limit)
+		writeJSON(w, http.StatusOK, map[string]any{"domains": domains, "total": total})
+	})
+	mux.HandleFunc("GET /domains/{domain}", func(w http.ResponseWriter, r *http.Request) {
+		reloadDomainStore(store)
+		intel, ok := store.Get(r.PathValue("domain"))
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "domain not seen in any run"})
+			return
+		}
+		writeJSON(w, http.StatusOK, intel)
+	})
+
+	if opts.GRPCListen != "" {
+		service := &GRPCService{jobs: jobs, config: config, limits: limits, maxBatch: opts.MaxBatch, token: opts.Token}
+		go func() { failed <- serveGRPC(opts.GRPCListen, service) }()
+	}
+
+	server := &http.Server{
+		Addr:              opts.Listen,
+		Handler:           requireToken(opts.Token, splitList(opts.APIKeys), mux),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	slog.Info("serving jobs and domain intelligence", "addr", opts.Listen, "domain_store", config.DomainStore)
+	go func() { failed <- fmt.Errorf("server failed: %w", server.ListenAndServe()) }()
+	return <-failed
+}
+
+// handleVerify serves synchronous verification, answering with the results once checked:
+// POST /verify for one address and POST /verify/batch for a short list
+func handleVerify(mux *http.ServeMux, jobs *JobManager, config Config, limits JobLimits, maxBatch int) {
+	mux.HandleFunc("POST /verify", func(w http.ResponseWriter, r *http.Request) {
+		options, err := parseJobOptions(r.URL.Query(), config, limits)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		var request struct {
+			Email string `json:"email"`
+		}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&request); err != nil || request.Email == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": `expected {"email": "user@example.com"}`})
+			return
+		}
+		if err := jobs.Admit(1); err != nil {
+			rejectJob(w, err)
+			return
+		}
+		results, _ := jobs.Verify(r.Context(), []string{request.Email}, options)
+		if len(results) == 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "the pre-hook dropped the address"})
+			return
+		}
+		writeJSON(w, http.StatusOK, results[0])
+	})
+
+	mux.HandleFunc("POST /verify/batch", func(w http.ResponseWriter, r *http.Request) {
+		options, err := parseJobOptions(r.URL.Query(), config, limits)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		emails, err := decodeEmails(r.Body, r.ContentLength)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if len(emails) > maxBatch {
+			writeJSON(w, http.StatusRequestEntityTooLarge, map[string]string{"error": fmt.Sprintf("batch of %d addresses exceeds the limit of %d; submit it as a job", len(emails), maxBatch)})
+			return
+		}
+		if err := jobs.Admit(len(emails)); err != nil {
+			rejectJob(w, err)
+			return
+		}
+		results, stats := jobs.Verify(r.Context(), emails, options)
+		writeJSON(w, http.StatusOK, map[string]any{
+			"results": results,
+			"checked": stats.TotalChecked,
+			"valid":   stats.TotalValid,
+			"invalid": stats.TotalInvalid,
+			"risky":   stats.TotalRisky,
+		})
+	})
+}
+
+// streamJobStatus writes a job's status as newline-delimited JSON every second until it finishes
+func streamJobStatus(w http.ResponseWriter, r *http.Request, jobs *JobManager) {
+	id := r.PathValue("id")
+	status, ok := jobs.Status(id)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "job not found"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		if err := encoder.Encode(status); err != nil {
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if status.Finished() {
+			return
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		status, _ = jobs.Status(id)
+	}
+}
+
+// requireToken rejects requests without the bearer token or one of the API keys, if any are
+// configured; probes stay open
+func requireToken(token string, keys []string, next http.Handler) http.Handler {
+	if token == "" && len(keys) == 0 {
+		return next
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		authorized := token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) == 1
+		key := r.Header.Get("X-API-Key")
+		if key == "" {
+			key = r.URL.Query().Get("api_key")
+		}
+		for _, k := range keys {
+			if key != "" && subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+				authorized = true
+			}
+		}
+		if !authorized {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or invalid API token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reloadDomainStore picks up results saved by runs since the server started
+func reloadDomainStore(store *DomainStore) {
+	if err := store.Reload(); err != nil {
+		slog.Warn("failed to reload domain store", "error", err)
+	}
+}
+
+// handleWork serves the endpoints workers lease work units from in distributed mode
+func handleWork(mux *http.ServeMux, work *WorkQueue) {
+	mux.HandleFunc("POST /work/lease", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Worker string `json:"worker"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Worker == "" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "request must name the worker"})
+			return
+		}
+		unit, ok := work.Lease(req.Worker)
+		if !ok {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, unit)
+	})
+	mux.HandleFunc("POST /work/{id}/heartbeat", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Worker string `json:"worker"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		writeWorkError(w, work.Heartbeat(req.Worker, r.PathValue("id")))
+	})
+	mux.HandleFunc("POST /work/{id}/checkpoint", func(w http.ResponseWriter, r *http.Request) {
+		var checkpoint UnitCheckpoint
+		if err := json.NewDecoder(r.Body).Decode(&checkpoint); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		remaining, err := work.Checkpoint(r.PathValue("id"), checkpoint)
+		if err != nil {
+			writeWorkError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]int{"remaining": remaining})
+	})
+	mux.HandleFunc("POST /work/{id}/release", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Worker string `json:"worker"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		writeWorkError(w, work.Release(req.Worker, r.PathValue("id")))
+	})
+	mux.HandleFunc("POST /work/{id}/results", func(w http.ResponseWriter, r *http.Request) {
+		var result UnitResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		writeWorkError(w, work.Complete(r.PathValue("id"), result))
+	})
+	mux.HandleFunc("GET /workers", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"workers": work.Workers()})
+	})
+	mux.HandleFunc("GET /work/queue", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, work.Depth())
+	})
+}
+
+// writeWorkError answers a worker's request: 404 for units that are gone, 409 for units it no
+// longer holds or reports that don't fit the unit
+func writeWorkError(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, errUnitNotFound):
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+	default:
+		writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+}
+
+// writeUpload reports an upload's progress, in headers as well for HEAD requests
+func writeUpload(w http.ResponseWriter, status int, upload InputUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, status, upload)
+}
+
+// decodeUpload reads the addresses of a completed upload
+func decodeUpload(uploads *InputUploads, id string) ([]string, error) {
+	file, upload, err := uploads.Open(id)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return decodeEmails(file, upload.Length)
+}
+
+// rejectJob reports a submission that failed; jobs turned away for lack of capacity get 503
+// with a Retry-After estimate
+func rejectJob(w http.ResponseWriter, err error) {
+	var admission *admissionError
+	if !errors.As(err, &admission) {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	slog.Info("turned a job away", "reason", err, "retry_after", admission.RetryAfter.Round(time.Second))
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(admission.RetryAfter.Seconds()))))
+	writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,62 @@
+package verify
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// reserveSlotScript takes the next slot of a shared interval limit and returns how many
+// microseconds to wait for it. Time comes from Redis so instances with skewed clocks agree, and
+// the key expires once its schedule has passed.
+const reserveSlotScript = `
+redis.replicate_commands()
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local interval = tonumber(ARGV[1])
+local slot = math.max(tonumber(redis.call('GET', KEYS[1]) or '0'), now)
+redis.call('SET', KEYS[1], string.format('%d', slot + interval), 'PX', string.format('%d', math.ceil((slot + interval - now) / 1000) + 1000))
+return slot - now
+`
+
+// sharedSlots is the slot schedule of a provider's rate limit kept in Redis, so that instances
+// probing from the same egress IPs jointly respect the limit instead of each assuming it's alone
+type sharedSlots struct {
+	redis   *redisClient
+	key     string
+	failing atomic.Bool
+}
+
+func newSharedSlots(redis *redisClient, key string) *sharedSlots {
+	return &sharedSlots{redis: redis, key: key}
+}
+
+// reserve takes the next shared slot, returning how long to wait for it. While Redis can't be
+// reached it reports false and the caller falls back to limiting locally.
+func (s *sharedSlots) reserve(interval time.Duration) (time.Duration, bool) {
+	wait, err := s.eval(interval)
+	if err != nil {
+		if !s.failing.Swap(true) {
+			slog.Warn("shared rate limit unavailable, limiting locally", "key", s.key, "error", err)
+		}
+		return 0, false
+	}
+	if s.failing.Swap(false) {
+		slog.Info("shared rate limit available again", "key", s.key)
+	}
+	return wait, true
+}
+
+func (s *sharedSlots) eval(interval time.Duration) (time.Duration, error) {
+	reply, err := s.redis.Do("EVAL", reserveSlotScript, "1", s.key, strconv.FormatInt(interval.Microseconds(), 10))
+	if err != nil {
+		return 0, err
+	}
+	micros, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply %v", reply)
+	}
+	return time.Duration(micros) * time.Microsecond, nil
+}
@@ -0,0 +1,336 @@
+package verify
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	sheetsURLPrefix       = "https://docs.google.com/spreadsheets/d/"
+	defaultSheetsEndpoint = "https://sheets.googleapis.com"
+	sheetsScope           = "https://www.googleapis.com/auth/spreadsheets"
+	sheetsReadOnlyScope   = "https://www.googleapis.com/auth/spreadsheets.readonly"
+	sheetsRetries         = 4
+)
+
+// sheetsResultFields are the result fields that can be written back as columns
+var sheetsResultFields = []string{"valid", "risky", "reason", "reachable", "disposable", "role_account", "free", "confidence", "country", "greylisted", "catch_all", "deferred", "policy", "probed_as", "checked_at", "expires_at"}
+
+// isSheetsURL reports whether an input names a Google Sheet rather than a file
+func isSheetsURL(name string) bool {
+	return strings.HasPrefix(name, sheetsURLPrefix)
+}
+
+// SheetStore reads the addresses to verify from a column of a Google Sheet and writes the
+// verdict of each into columns of the row it came from
+type SheetStore struct {
+	auth        *GoogleAuth
+	endpoint    string
+	spreadsheet string
+	gid         string // tab of the URL, the first one when empty
+	sheetID     int
+	sheet       string // title of the tab
+	columns     int    // columns of the tab's grid
+	column      string
+	results     []string
+	resultCols  []int // column of each result field, 0-based
+	batch       int
+
+	mu        sync.Mutex
+	rows      map[string][]int // sheet rows by address, 1-based
+	updates   []sheetsValueRange
+	unmatched int
+}
+
+// sheetsValueRange is a range of cells and their values, as the Sheets API takes them
+type sheetsValueRange struct {
+	Range          string  `json:"range"`
+	MajorDimension string  `json:"majorDimension,omitempty"`
+	Values         [][]any `json:"values"`
+}
+
+// openSheetStore opens the spreadsheet the input URL names, such as
+// https://docs.google.com/spreadsheets/d/<id>/edit#gid=0
+func openSheetStore(config Config) (*SheetStore, error) {
+	u, err := url.Parse(config.InputFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Google Sheets URL %q", config.InputFile)
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(config.InputFile, sheetsURLPrefix), "/")
+	id, _, _ = strings.Cut(id, "?")
+	id, _, _ = strings.Cut(id, "#")
+	if id == "" {
+		return nil, fmt.Errorf("no spreadsheet ID in %q", config.InputFile)
+	}
+	// The tab is in the fragment of URLs copied from the browser, and sometimes the query
+	gid := u.Query().Get("gid")
+	if fragment, err := url.ParseQuery(u.Fragment); err == nil && fragment.Get("gid") != "" {
+		gid = fragment.Get("gid")
+	}
+
+	var results []string
+	for _, field := range strings.Split(config.SheetsResults, ",") {
+		if field = strings.TrimSpace(field); field == "" {
+			continue
+		}
+		if !slices.Contains(sheetsResultFields, field) {
+			return nil, fmt.Errorf("unknown result field %q (expected %s)", field, strings.Join(sheetsResultFields, ", "))
+		}
+		results = append(results, field)
+	}
+	if config.SheetsColumn == "" {
+		return nil, errors.New("no address column, set -sheets-column")
+	}
+
+	scope := sheetsScope
+	if len(results) == 0 {
+		scope = sheetsReadOnlyScope
+	}
+	auth, err := newGoogleAuth(&http.Client{Timeout: time.Minute}, config.GoogleCredentials, config.GCEMetadataHost, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &SheetStore{
+		auth:        auth,
+		endpoint:    strings.TrimSuffix(config.SheetsEndpoint, "/") + "/v4/spreadsheets/" + url.PathEscape(id),
+		spreadsheet: id,
+		gid:         gid,
+		column:      config.SheetsColumn,
+		results:     results,
+		batch:       config.SheetsBatch,
+		rows:        make(map[string][]int),
+	}, nil
+}
+
+// findSheet looks up the title of the URL's tab
+func (s *SheetStore) findSheet() error {
+	var spreadsheet struct {
+		Sheets []struct {
+			Properties struct {
+				SheetID        int    `json:"sheetId"`
+				Title          string `json:"title"`
+				GridProperties struct {
+					ColumnCount int `json:"columnCount"`
+				} `json:"gridProperties"`
+			} `json:"properties"`
+		} `json:"sheets"`
+	}
+	err := retryGoogle(sheetsRetries, "Sheets lookup", func() error {
+		_, err := s.auth.do(http.MethodGet, s.endpoint+"?fields=sheets.properties(sheetId,title,gridProperties.columnCount)", nil, &spreadsheet)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open spreadsheet %s: %w", s.spreadsheet, err)
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if s.gid == "" || strconv.Itoa(sheet.Properties.SheetID) == s.gid {
+			s.sheetID, s.sheet, s.columns = sheet.Properties.SheetID, sheet.Properties.Title, sheet.Properties.GridProperties.ColumnCount
+			return nil
+		}
+	}
+	return fmt.Errorf("spreadsheet %s has no tab with gid %s", s.spreadsheet, s.gid)
+}
+
+// quotedSheet is the tab's title as A1 notation quotes it
+func (s *SheetStore) quotedSheet() string {
+	return "'" + strings.ReplaceAll(s.sheet, "'", "''") + "'"
+}
+
+// a1Range names cells of the tab in A1 notation, a row from one column to another
+func (s *SheetStore) a1Range(row, from, to int) string {
+	return fmt.Sprintf("%s!%s%d:%s%d", s.quotedSheet(), columnName(from), row, columnName(to), row)
+}
+
+// columnName converts a 0-based column index to its letters: A, B, ..., Z, AA, ...
+func columnName(index int) string {
+	name := ""
+	for index++; index > 0; index = (index - 1) / 26 {
+		name = string(rune('A'+(index-1)%26)) + name
+	}
+	return name
+}
+
+// columnIndex converts column letters to a 0-based index, or -1 if they're not letters
+func columnIndex(name string) int {
+	if name == "" || len(name) > 3 {
+		return -1
+	}
+	index := 0
+	for _, r := range strings.ToUpper(name) {
+		if r < 'A' || r > 'Z' {
+			return -1
+		}
+		index = index*26 + int(r-'A'+1)
+	}
+	return index - 1
+}
+
+// headerColumn finds a column by its header, ignoring case
+func headerColumn(header []string, name string) int {
+	for i, cell := range header {
+		if strings.EqualFold(strings.TrimSpace(cell), name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// Records reads the addresses of the tab's address column, the first row being the header. Result
+// columns are those with a result field's header, or new ones after the last column.
+func (s *SheetStore) Records() ([]InputRecord, error) {
+	if err := s.findSheet(); err != nil {
+		return nil, err
+	}
+	var values struct {
+		Values [][]string `json:"values"`
+	}
+	target := s.endpoint + "/values/" + url.PathEscape(s.quotedSheet()) + "?majorDimension=ROWS&valueRenderOption=FORMATTED_VALUE"
+	err := retryGoogle(sheetsRetries, "Sheets read", func() error {
+		_, err := s.auth.do(http.MethodGet, target, nil, &values)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tab %q: %w", s.sheet, err)
+	}
+	if len(values.Values) == 0 {
+		return nil, fmt.Errorf("tab %q is empty", s.sheet)
+	}
+
+	header := values.Values[0]
+	column := headerColumn(header, s.column)
+	if column < 0 {
+		column = columnIndex(s.column)
+	}
+	if column < 0 {
+		return nil, fmt.Errorf("no column %q in the header row of tab %q", s.column, s.sheet)
+	}
+
+	// New result columns go after the widest row, so they never overwrite data
+	width := 0
+	for _, row := range values.Values {
+		width = max(width, len(row))
+	}
+	var headers []sheetsValueRange
+	for _, field := range s.results {
+		col := headerColumn(header, field)
+		if col < 0 {
+			col = width
+			width++
+			headers = append(headers, sheetsValueRange{Range: s.a1Range(1, col, col), Values: [][]any{{field}}})
+		}
+		s.resultCols = append(s.resultCols, col)
+	}
+	if width > s.columns {
+		// Cells can't be written past the grid, so it grows to fit the new columns. Setting the
+		// column count rather than appending columns keeps retries idempotent.
+		grow := map[string]any{"requests": []any{map[string]any{"updateSheetProperties": map[string]any{
+			"properties": map[string]any{"sheetId": s.sheetID, "gridProperties": map[string]any{"columnCount": width}},
+			"fields":     "gridProperties.columnCount",
+		}}}}
+		err := retryGoogle(sheetsRetries, "Sheets resize", func() error {
+			_, err := s.auth.do(http.MethodPost, s.endpoint+":batchUpdate", grow, nil)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to add result columns: %w", err)
+		}
+	}
+	if err := s.update(headers); err != nil {
+		return nil, fmt.Errorf("failed to add result columns: %w", err)
+	}
+
+	var records []InputRecord
+	for i, row := range values.Values[1:] {
+		if column >= len(row) || strings.TrimSpace(row[column]) == "" {
+			continue
+		}
+		email, number := strings.TrimSpace(row[column]), i+2
+		records = append(records, InputRecord{ID: strconv.Itoa(number), Email: email})
+		s.rows[addressKey(email)] = append(s.rows[addressKey(email)], number)
+	}
+	slog.Info("read addresses from Google Sheets", "spreadsheet", s.spreadsheet, "tab", s.sheet, "rows", len(values.Values)-1, "emails", len(records))
+	return records, nil
+}
+
+// Write queues the result's cells in the rows its address came from, sending them once enough
+// rows have come in
+func (s *SheetStore) Write(result EmailResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rows := s.rows[addressKey(result.Email)]
+	if len(rows) == 0 {
+		// Hooks and repairs may have changed the address
+		s.unmatched++
+		return nil
+	}
+	// One range per row spans the result columns; cells between them are left alone as nulls
+	first, last := slices.Min(s.resultCols), slices.Max(s.resultCols)
+	values := make([]any, last-first+1)
+	fields := resultRow(result)
+	for i, field := range s.results {
+		value, ok := fields[field]
+		if !ok {
+			value = ""
+		}
+		values[s.resultCols[i]-first] = value
+	}
+	for _, row := range rows {
+		s.updates = append(s.updates, sheetsValueRange{Range: s.a1Range(row, first, last), MajorDimension: "ROWS", Values: [][]any{values}})
+	}
+	if len(s.updates) < s.batch {
+		return nil
+	}
+	// Callers log sink errors only with -verbose, but lost results matter
+	if err := s.send(); err != nil {
+		slog.Warn("failed to write results to Google Sheets", "spreadsheet", s.spreadsheet, "error", err)
+		return err
+	}
+	return nil
+}
+
+// Flush sends the rest of the queued cells
+func (s *SheetStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.unmatched > 0 {
+		slog.Warn("results not written back to Google Sheets, their address differs from the rows'", "results", s.unmatched)
+		s.unmatched = 0
+	}
+	return s.send()
+}
+
+// send writes the queued rows in one request
+func (s *SheetStore) send() error {
+	if len(s.updates) == 0 {
+		return nil
+	}
+	updates := s.updates
+	s.updates = nil
+	if err := s.update(updates); err != nil {
+		return fmt.Errorf("failed to update %d rows: %w", len(updates), err)
+	}
+	slog.Debug("wrote results to Google Sheets", "spreadsheet", s.spreadsheet, "rows", len(updates))
+	return nil
+}
+
+// update sets the cells of ranges as given, without parsing them as formulas. Setting cells is
+// idempotent, so retried requests are safe.
+func (s *SheetStore) update(ranges []sheetsValueRange) error {
+	if len(ranges) == 0 {
+		return nil
+	}
+	body := map[string]any{"valueInputOption": "RAW", "data": ranges}
+	return retryGoogle(sheetsRetries, "Sheets update", func() error {
+		_, err := s.auth.do(http.MethodPost, s.endpoint+"/values:batchUpdate", body, nil)
+		return err
+	})
+}
@@ -0,0 +1,25 @@
+package verify
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// interruptContext returns a context cancelled by the first SIGINT or SIGTERM, after which a run
+// stops starting verifications and writes the results it has. A second signal quits at once, as
+// the signals are left to their default action again.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		slog.Warn("interrupted, finishing in-flight verifications and writing partial results (interrupt again to quit now)")
+		cancel()
+		signal.Stop(signals)
+	}()
+	return ctx
+}
@@ -0,0 +1,149 @@
+package verify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+// minisign key and signature algorithms
+var (
+	minisignEd25519   = []byte("Ed")
+	minisignPrehashed = []byte("ED") // Ed25519 over a BLAKE2b-512 hash of the file
+	minisignScrypt    = []byte("Sc")
+	minisignBlake2b   = []byte("B2")
+)
+
+// minisignKey is a decrypted minisign secret key
+type minisignKey struct {
+	id  [8]byte
+	key ed25519.PrivateKey
+}
+
+// loadMinisignKey reads a secret key created with `minisign -G`, decrypting it with the password
+// unless it was created without one (`minisign -G -W`)
+func loadMinisignKey(filename, password string) (*minisignKey, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) < 2 || !strings.HasPrefix(lines[0], "untrusted comment:") {
+		return nil, errors.New("signing key is not a minisign secret key")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(raw) != 158 {
+		return nil, errors.New("signing key is not a minisign secret key")
+	}
+
+	// sig_alg(2) kdf_alg(2) cksum_alg(2) salt(32) opslimit(8) memlimit(8) key_id(8) sk(64) checksum(32)
+	sigAlg, kdfAlg, cksumAlg := raw[0:2], raw[2:4], raw[4:6]
+	salt := raw[6:38]
+	opsLimit, memLimit := binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54])
+	keynum := bytes.Clone(raw[54:158])
+	if !bytes.Equal(sigAlg, minisignEd25519) || !bytes.Equal(cksumAlg, minisignBlake2b) {
+		return nil, fmt.Errorf("unsupported minisign key algorithms %q/%q", sigAlg, cksumAlg)
+	}
+
+	switch {
+	case bytes.Equal(kdfAlg, minisignScrypt):
+		if password == "" {
+			return nil, errors.New("signing key is encrypted; set SIGN_KEY_PASSWORD")
+		}
+		n, r, p := scryptParams(opsLimit, memLimit)
+		stream, err := scrypt.Key([]byte(password), salt, n, r, p, len(keynum))
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive signing key: %w", err)
+		}
+		for i := range keynum {
+			keynum[i] ^= stream[i]
+		}
+	case kdfAlg[0] == 0 && kdfAlg[1] == 0:
+	default:
+		return nil, fmt.Errorf("unsupported minisign key derivation %q", kdfAlg)
+	}
+
+	key := &minisignKey{key: ed25519.PrivateKey(keynum[8:72])}
+	copy(key.id[:], keynum[:8])
+	checksum, _ := blake2b.New256(nil)
+	checksum.Write(sigAlg)
+	checksum.Write(keynum[:72])
+	if !bytes.Equal(checksum.Sum(nil), keynum[72:]) {
+		return nil, errors.New("wrong password for signing key")
+	}
+	return key, nil
+}
+
+// scryptParams converts libsodium's opslimit and memlimit into scrypt parameters, as minisign does
+func scryptParams(opsLimit, memLimit uint64) (n, r, p int) {
+	opsLimit = max(opsLimit, 32768)
+	r = 8
+	var nLog2 uint
+	if opsLimit < memLimit/32 {
+		p = 1
+		maxN := opsLimit / uint64(r*4)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+	} else {
+		maxN := memLimit / uint64(r*128)
+		for nLog2 = 1; nLog2 < 63; nLog2++ {
+			if uint64(1)<<nLog2 > maxN/2 {
+				break
+			}
+		}
+		maxRP := min((opsLimit/4)/(uint64(1)<<nLog2), 0x3fffffff)
+		p = int(maxRP) / r
+	}
+	return 1 << nLog2, r, p
+}
+
+// Sign returns a minisign signature of the data, verifiable with `minisign -V`. The trusted
+// comment is covered by the signature; the untrusted one is not.
+func (k *minisignKey) Sign(data []byte, trustedComment string) []byte {
+	hash := blake2b.Sum512(data)
+	signature := append(append(bytes.Clone(minisignPrehashed), k.id[:]...), ed25519.Sign(k.key, hash[:])...)
+	global := ed25519.Sign(k.key, append(bytes.Clone(signature[10:]), trustedComment...))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "untrusted comment: signature from email-verification secret key %016X\n", binary.LittleEndian.Uint64(k.id[:]))
+	b.WriteString(base64.StdEncoding.EncodeToString(signature) + "\n")
+	b.WriteString("trusted comment: " + trustedComment + "\n")
+	b.WriteString(base64.StdEncoding.EncodeToString(global) + "\n")
+	return []byte(b.String())
+}
+
+// signatureFile is where a manifest's signature goes, next to it as minisign expects
+func signatureFile(manifestFile string) string {
+	return manifestFile + ".minisig"
+}
+
+// signManifest writes a minisign signature of the (staged) manifest
+func signManifest(manifestFile string, key *minisignKey) error {
+	data, err := os.ReadFile(stagedOutput(manifestFile))
+	if err != nil {
+		return fmt.Errorf("failed to read manifest: %w", err)
+	}
+	name := filepath.Base(manifestFile)
+	if obj, ok := parseObjectURL(manifestFile); ok {
+		name = path.Base(obj.Key)
+	}
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", time.Now().Unix(), name)
+	if err := os.WriteFile(stagedOutput(signatureFile(manifestFile)), key.Sign(data, comment), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest signature: %w", err)
+	}
+	return nil
+}
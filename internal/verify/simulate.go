@@ -0,0 +1,75 @@
+package verify
+
+import (
+	"hash/fnv"
+	"net"
+	"strings"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// Simulator stands in for DNS and SMTP with verdicts derived from a hash of the domain or
+// address, so reading, scheduling, rate limiting and writing can be exercised end to end without
+// network access. The same input always gets the same results:
+//   - 5% of domains have no mail server, 3% time out when probed and 10% are catch-all
+//   - on the others, 15% of addresses are not deliverable
+type Simulator struct {
+	smtp bool
+}
+
+func newSimulator(smtp bool) *Simulator {
+	return &Simulator{smtp: smtp}
+}
+
+// simulatedBucket maps s to one of 100 buckets, the same one on every run
+func simulatedBucket(s string) uint32 {
+	hash := fnv.New32a()
+	hash.Write([]byte(strings.ToLower(s)))
+	return hash.Sum32() % 100
+}
+
+// CheckMX simulates the MX lookup of a domain, taking 1-10ms
+func (s *Simulator) CheckMX(domain string) (*emailverifier.Mx, error) {
+	bucket := simulatedBucket("mx:" + domain)
+	time.Sleep(time.Duration(1+bucket%10) * time.Millisecond)
+	if bucket < 5 {
+		return nil, &net.DNSError{Err: "no such host", Name: domain, IsNotFound: true}
+	}
+	return &emailverifier.Mx{
+		HasMXRecord: true,
+		Records:     []*net.MX{{Host: "mx." + domain + ".", Pref: 10}},
+	}, nil
+}
+
+// LookupMX simulates the plain MX lookup of provider detection and country inference
+func (s *Simulator) LookupMX(domain string) ([]*net.MX, error) {
+	mx, err := s.CheckMX(domain)
+	if err != nil {
+		return nil, err
+	}
+	return mx.Records, nil
+}
+
+// CheckSMTP simulates probing an address, taking 5-50ms
+func (s *Simulator) CheckSMTP(domain, username string) (*emailverifier.SMTP, error) {
+	if !s.smtp {
+		return nil, nil
+	}
+	server := simulatedBucket("smtp:" + domain)
+	mailbox := simulatedBucket(username + "@" + domain)
+	time.Sleep(time.Duration(5+(server+mailbox)%46) * time.Millisecond)
+
+	smtp := &emailverifier.SMTP{}
+	switch {
+	case server < 3:
+		return smtp, &emailverifier.LookupError{Message: emailverifier.ErrTimeout, Details: "simulated"}
+	case server < 13:
+		smtp.HostExists, smtp.CatchAll = true, true
+	case mailbox < 15:
+		smtp.HostExists = true
+	default:
+		smtp.HostExists, smtp.Deliverable = true, true
+	}
+	return smtp, nil
+}
@@ -0,0 +1,46 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestSimulateMakesNoDNSQueries(t *testing.T) {
+	upstream := newFakeUpstream(t, 300, 0)
+
+	config := DefaultConfig()
+	config.Simulate = true
+	config.Workers, config.RateLimit = 4, 0
+	config.DNSUpstreams = upstream.conn.LocalAddr().String()
+	// Provider pacing and country inference both look up MX records of their own
+	config.ProviderRates = "google=1ms,microsoft=1ms"
+	config.EnableGeo = true
+	runner, err := NewRunner(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runner.Close()
+
+	var emails []string
+	for i := 0; i < 50; i++ {
+		emails = append(emails, fmt.Sprintf("user%d@company%d.com", i, i%10), fmt.Sprintf("user%d@gmail.com", i))
+	}
+	before := dnsTraffic.totals()
+	results, stats, err := runner.Verify(context.Background(), emails)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(emails) {
+		t.Fatalf("got %d results for %d addresses", len(results), len(emails))
+	}
+	if stats.TotalChecked != int64(len(emails)) {
+		t.Errorf("checked %d of %d addresses", stats.TotalChecked, len(emails))
+	}
+	if got := upstream.queries.Load(); got != 0 {
+		t.Errorf("simulated run sent %d DNS queries, want none", got)
+	}
+	if after := dnsTraffic.totals(); after != before {
+		t.Errorf("DNS traffic went from %+v to %+v, want none", before, after)
+	}
+}
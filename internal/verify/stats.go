@@ -0,0 +1,267 @@
+package verify
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// ResultStats summarizes a file of results
+type ResultStats struct {
+	File         string       `json:"file"`
+	Total        int          `json:"total"`
+	Valid        int          `json:"valid"`
+	Invalid      int          `json:"invalid"`
+	Risky        int          `json:"risky"`
+	Greylisted   int          `json:"greylisted"`
+	Deferred     int          `json:"deferred"`
+	NotProbed    int          `json:"not_probed"`
+	CatchAll     int          `json:"catch_all"`
+	RoleAccounts int          `json:"role_accounts"`
+	Free         int          `json:"free"`
+	Reasons      []StatsCount `json:"reasons"`
+	Domains      []StatsCount `json:"domains"`
+}
+
+// StatsCount is how many results share a reason or domain, and how many of them are invalid
+type StatsCount struct {
+	Name    string `json:"name"`
+	Count   int    `json:"count"`
+	Invalid int    `json:"invalid,omitempty"`
+}
+
+// PrintStats summarizes details or checkpoint files of earlier runs on stdout, keeping the top
+// reasons and domains of each
+func PrintStats(files []string, top int, asJSON bool) error {
+	var all []ResultStats
+	for _, file := range files {
+		stats, err := summarizeResults(file, top)
+		if err != nil {
+			return fmt.Errorf("failed to summarize %s: %w", file, err)
+		}
+		all = append(all, stats)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(all); err != nil {
+			return fmt.Errorf("failed to write summary: %w", err)
+		}
+		return nil
+	}
+	for i, stats := range all {
+		if i > 0 {
+			fmt.Println()
+		}
+		printResultStats(os.Stdout, stats)
+	}
+	return nil
+}
+
+// summarizeResults counts the results of a file, keeping the top reasons and domains
+func summarizeResults(file string, top int) (ResultStats, error) {
+	stats := ResultStats{File: file}
+	reasons := make(map[string]*StatsCount)
+	domains := make(map[string]*StatsCount)
+	count := func(counts map[string]*StatsCount, name string, invalid bool) {
+		c, ok := counts[name]
+		if !ok {
+			c = &StatsCount{Name: name}
+			counts[name] = c
+		}
+		c.Count++
+		if invalid {
+			c.Invalid++
+		}
+	}
+
+	err := readResults(file, func(result EmailResult) {
+		stats.Total++
+		if result.IsValid {
+			stats.Valid++
+		} else {
+			stats.Invalid++
+		}
+		if result.Risky {
+			stats.Risky++
+		}
+		if result.Greylisted {
+			stats.Greylisted++
+		}
+		if result.Deferred {
+			stats.Deferred++
+		}
+		if result.Policy != "" {
+			stats.NotProbed++
+		}
+		if result.CatchAllDomain {
+			stats.CatchAll++
+		}
+		if result.RoleAccount {
+			stats.RoleAccounts++
+		}
+		if result.Free {
+			stats.Free++
+		}
+		if result.Reason != "" {
+			count(reasons, result.Reason, !result.IsValid)
+		}
+		count(domains, emailDomain(result.Email), !result.IsValid)
+	})
+	if err != nil {
+		return stats, err
+	}
+	stats.Reasons = topCounts(reasons, top)
+	stats.Domains = topCounts(domains, top)
+	return stats, nil
+}
+
+// topCounts returns the n largest counts, ties by name
+func topCounts(counts map[string]*StatsCount, n int) []StatsCount {
+	sorted := make([]StatsCount, 0, len(counts))
+	for _, c := range counts {
+		sorted = append(sorted, *c)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Count != sorted[j].Count {
+			return sorted[i].Count > sorted[j].Count
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return sorted[:min(len(sorted), n)]
+}
+
+func printResultStats(w io.Writer, stats ResultStats) {
+	percent := func(n int) string {
+		if stats.Total == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.1f%%", float64(n)*100/float64(stats.Total))
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "%s\n", stats.File)
+	for _, row := range []struct {
+		label string
+		n     int
+	}{
+		{"Addresses", stats.Total},
+		{"Valid", stats.Valid},
+		{"Invalid", stats.Invalid},
+		{"Risky", stats.Risky},
+		{"Greylisted", stats.Greylisted},
+		{"Deferred", stats.Deferred},
+		{"Not probed", stats.NotProbed},
+		{"Catch-all", stats.CatchAll},
+		{"Role accounts", stats.RoleAccounts},
+		{"Free providers", stats.Free},
+	} {
+		fmt.Fprintf(tw, "  %s:\t%d\t%s\t\n", row.label, row.n, percent(row.n))
+	}
+	tw.Flush()
+
+	if len(stats.Reasons) > 0 {
+		fmt.Fprintf(w, "\nTop reasons:\n")
+		for _, reason := range stats.Reasons {
+			fmt.Fprintf(w, "  %8d  %s\n", reason.Count, reason.Name)
+		}
+	}
+	if len(stats.Domains) > 0 {
+		fmt.Fprintf(w, "\nTop domains:\n")
+		for _, domain := range stats.Domains {
+			fmt.Fprintf(w, "  %8d  %-32s %5.1f%% invalid\n", domain.Count, domain.Name, float64(domain.Invalid)*100/float64(domain.Count))
+		}
+	}
+}
+
+// readResults streams the results of a details output or checkpoint journal: JSON Lines by the
+// file's extension, otherwise a JSON details document, grouped by domain or not
+func readResults(file string, each func(EmailResult)) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	reader := bufio.NewReaderSize(f, 1024*1024)
+
+	if !isJSONLines(file) {
+		return walkResults(json.NewDecoder(reader), "", each)
+	}
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			// Checkpoints hold a header line, then each result with its input position
+			var entry struct {
+				Checksum string          `json:"checksum"`
+				Result   json.RawMessage `json:"r"`
+			}
+			if err := json.Unmarshal(data, &entry); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if entry.Result != nil {
+				data = entry.Result
+			}
+			if entry.Checksum == "" {
+				var result EmailResult
+				if err := json.Unmarshal(data, &result); err != nil {
+					return fmt.Errorf("line %d: %w", line, err)
+				}
+				each(result)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// walkResults decodes every element of the "results" arrays in the next JSON value, skipping
+// everything else
+func walkResults(dec *json.Decoder, key string, each func(EmailResult)) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	switch token {
+	case json.Delim('['):
+		for dec.More() {
+			if key != "results" {
+				if err := walkResults(dec, "", each); err != nil {
+					return err
+				}
+				continue
+			}
+			var result EmailResult
+			if err := dec.Decode(&result); err != nil {
+				return err
+			}
+			each(result)
+		}
+	case json.Delim('{'):
+		for dec.More() {
+			name, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			field, _ := name.(string)
+			if err := walkResults(dec, strings.ToLower(field), each); err != nil {
+				return err
+			}
+		}
+	default:
+		return nil
+	}
+	// The closing bracket or brace
+	_, err = dec.Token()
+	return err
+}
@@ -0,0 +1,140 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Probe styles
+const (
+	probeSMTP = "smtp" // RCPT probe of the mailbox
+	probeSkip = "skip" // MX only; the provider's RCPT answers say nothing about the mailbox
+)
+
+// Strategy is how addresses hosted by a mailbox provider are verified
+type Strategy struct {
+	Probe      string       `json:"probe"`
+	Pacing     jsonDuration `json:"pacing"`
+	Confidence float64      `json:"confidence"`
+}
+
+// defaultStrategy applies to providers without a strategy of their own
+var defaultStrategy = Strategy{Probe: probeSMTP, Confidence: 0.8}
+
+// builtinStrategies reflect how major providers respond to verification probes
+var builtinStrategies = map[string]Strategy{
+	// Gmail answers RCPT truthfully and tolerates moderate pacing
+	"google": {Probe: probeSMTP, Pacing: jsonDuration(100 * time.Millisecond), Confidence: 0.95},
+	// Outlook and Microsoft 365 throttle and greylist aggressive probing
+	"microsoft": {Probe: probeSMTP, Pacing: jsonDuration(time.Second), Confidence: 0.85},
+	// Yahoo and AOL accept every RCPT, so probes can't tell real mailboxes apart
+	"yahoo": {Probe: probeSkip, Confidence: 0.5},
+	// iCloud accepts every RCPT as well
+	"apple": {Probe: probeSkip, Confidence: 0.5},
+	// Security gateways rate limit hard and often accept before filtering
+	"proofpoint": {Probe: probeSMTP, Pacing: jsonDuration(2 * time.Second), Confidence: 0.6},
+	"mimecast":   {Probe: probeSMTP, Pacing: jsonDuration(2 * time.Second), Confidence: 0.6},
+	"symantec":   {Probe: probeSMTP, Pacing: jsonDuration(2 * time.Second), Confidence: 0.6},
+	"barracuda":  {Probe: probeSMTP, Pacing: jsonDuration(2 * time.Second), Confidence: 0.6},
+}
+
+// Strategies picks the verification strategy per mailbox provider
+type Strategies struct {
+	byProvider map[string]Strategy
+	fallback   Strategy
+}
+
+// loadStrategies returns the built-in strategies with the overrides from file applied, if set.
+// The file maps provider names (or "default") to the fields to change.
+func loadStrategies(file string) (*Strategies, error) {
+	s := &Strategies{byProvider: make(map[string]Strategy), fallback: defaultStrategy}
+	for provider, strategy := range builtinStrategies {
+		s.byProvider[provider] = strategy
+	}
+	if file == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read strategy file %s: %w", file, err)
+	}
+	var overrides map[string]json.RawMessage
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return nil, fmt.Errorf("failed to decode strategy file %s: %w", file, err)
+	}
+
+	// The default applies first so providers new to the file start from it
+	if raw, ok := overrides["default"]; ok {
+		if s.fallback, err = applyStrategyOverride("default", s.fallback, raw); err != nil {
+			return nil, err
+		}
+	}
+	for provider, raw := range overrides {
+		provider = strings.ToLower(provider)
+		if provider == "default" {
+			continue
+		}
+		strategy, ok := s.byProvider[provider]
+		if !ok {
+			strategy = s.fallback
+		}
+		if s.byProvider[provider], err = applyStrategyOverride(provider, strategy, raw); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// applyStrategyOverride sets the fields present in raw; missing fields keep their current values
+func applyStrategyOverride(provider string, strategy Strategy, raw json.RawMessage) (Strategy, error) {
+	if err := json.Unmarshal(raw, &strategy); err != nil {
+		return Strategy{}, fmt.Errorf("invalid strategy for %s: %w", provider, err)
+	}
+	if strategy.Probe != probeSMTP && strategy.Probe != probeSkip {
+		return Strategy{}, fmt.Errorf("invalid probe style %q for %s (expected %s or %s)", strategy.Probe, provider, probeSMTP, probeSkip)
+	}
+	return strategy, nil
+}
+
+// For returns the strategy for a provider
+func (s *Strategies) For(provider string) Strategy {
+	if strategy, ok := s.byProvider[provider]; ok {
+		return strategy
+	}
+	return s.fallback
+}
+
+// Pacing returns the minimum interval between verifications for providers that need one
+func (s *Strategies) Pacing() map[string]time.Duration {
+	pacing := make(map[string]time.Duration)
+	for provider, strategy := range s.byProvider {
+		if strategy.Pacing > 0 {
+			pacing[provider] = time.Duration(strategy.Pacing)
+		}
+	}
+	return pacing
+}
+
+// jsonDuration is a time.Duration written as a string like "500ms" in JSON
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *jsonDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = jsonDuration(duration)
+	return nil
+}
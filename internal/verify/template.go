@@ -0,0 +1,92 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+)
+
+// TemplateData is the value passed to output templates
+type TemplateData struct {
+	Invalid   []InvalidEmail
+	Results   []EmailResult
+	Stats     *Stats
+	CheckedAt time.Time
+	Elapsed   time.Duration
+
+	// Set with -group-by=domain
+	InvalidByDomain []DomainGroup[InvalidEmail]
+	ResultsByDomain []DomainGroup[EmailResult]
+}
+
+// templateFuncs are helpers for producing common bespoke formats
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"xml": func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+	"csv": func(s string) string {
+		if strings.ContainsAny(s, ",\"\r\n") {
+			return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+		}
+		return s
+	},
+	"padRight": func(width int, s string) string { return fixedWidth(s, width, false) },
+	"padLeft":  func(width int, s string) string { return fixedWidth(s, width, true) },
+	"upper":    strings.ToUpper,
+	"lower":    strings.ToLower,
+	"join":     strings.Join,
+	"replace":  strings.ReplaceAll,
+	"date":     func(layout string, t time.Time) string { return t.Format(layout) },
+}
+
+// fixedWidth pads or truncates s to exactly width runes
+func fixedWidth(s string, width int, alignRight bool) string {
+	if n := utf8.RuneCountInString(s); n > width {
+		return string([]rune(s)[:width])
+	} else if alignRight {
+		return strings.Repeat(" ", width-n) + s
+	} else {
+		return s + strings.Repeat(" ", width-n)
+	}
+}
+
+// loadOutputTemplate parses a template file so errors surface before verification starts
+func loadOutputTemplate(path string) (*template.Template, error) {
+	tmpl, err := template.New(filepath.Base(path)).Funcs(templateFuncs).ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse output template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// writeResultsTemplate renders results through a user template, to stdout for "-"
+func writeResultsTemplate(filename string, tmpl *template.Template, data TemplateData) error {
+	file := os.Stdout
+	if filename != StdoutOutput {
+		var err error
+		if file, err = os.Create(filename); err != nil {
+			return fmt.Errorf("failed to create file %s: %w", filename, err)
+		}
+		defer file.Close()
+	}
+
+	writer := bufio.NewWriterSize(file, 1024*1024) // 1MB buffer
+
+	if err := tmpl.Execute(writer, data); err != nil {
+		return fmt.Errorf("failed to render output template: %w", err)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,51 @@
+package verify
+
+import (
+	"math"
+	"time"
+)
+
+// RCPTTiming compares how long the server took to answer RCPT for the address and for control
+// mailboxes on the same connection. Some accept-all servers look existing mailboxes up, which
+// shows as a consistent delta.
+type RCPTTiming struct {
+	TargetMS  float64 `json:"target_ms"`
+	ControlMS float64 `json:"control_ms"`
+	DeltaMS   float64 `json:"delta_ms"`
+}
+
+// measureRCPTTiming probes a control mailbox before and after the address in one session
+func measureRCPTTiming(session smtpSession, mxHost, email string) (*RCPTTiming, error) {
+	domain := emailDomain(email)
+	replies, err := probeRecipients(session, mxHost, []string{
+		randomMailbox() + "@" + domain,
+		email,
+		randomMailbox() + "@" + domain,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rcptTiming(replies[1], []rcptReply{replies[0], replies[2]}), nil
+}
+
+// rcptTiming summarizes the target's latency against the mean of the controls
+func rcptTiming(target rcptReply, controls []rcptReply) *RCPTTiming {
+	var control time.Duration
+	for _, c := range controls {
+		control += c.latency
+	}
+	if len(controls) > 0 {
+		control /= time.Duration(len(controls))
+	}
+
+	return &RCPTTiming{
+		TargetMS:  roundMS(target.latency),
+		ControlMS: roundMS(control),
+		DeltaMS:   roundMS(target.latency - control),
+	}
+}
+
+// roundMS converts a duration to milliseconds rounded to two decimals
+func roundMS(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*100) / 100
+}
@@ -0,0 +1,107 @@
+package verify
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/idna"
+)
+
+// ianaTLDListURL is the authoritative list of delegated top-level domains
+const ianaTLDListURL = "https://data.iana.org/TLD/tlds-alpha-by-domain.txt"
+
+// TLDList validates top-level domains against the IANA list
+type TLDList struct {
+	tlds map[string]bool
+}
+
+// loadTLDList reads the cached IANA list, downloading it when missing, older than maxAge or forced.
+// A stale cache is used if the download fails.
+func loadTLDList(url, cacheFile string, maxAge time.Duration, forceRefresh bool) (*TLDList, error) {
+	stat, statErr := os.Stat(cacheFile)
+	fresh := statErr == nil && time.Since(stat.ModTime()) < maxAge
+
+	if forceRefresh || !fresh {
+		if err := downloadTLDList(url, cacheFile); err != nil {
+			if statErr != nil {
+				return nil, err
+			}
+			slog.Warn("failed to refresh TLD list, using the cached copy", "cached_at", stat.ModTime().Format(time.RFC3339), "error", err)
+		} else {
+			slog.Info("refreshed TLD list", "url", url)
+		}
+	}
+
+	file, err := os.Open(cacheFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TLD list %s: %w", cacheFile, err)
+	}
+	defer file.Close()
+
+	list := &TLDList{tlds: make(map[string]bool)}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		list.tlds[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read TLD list: %w", err)
+	}
+	if len(list.tlds) == 0 {
+		return nil, fmt.Errorf("TLD list %s is empty", cacheFile)
+	}
+
+	return list, nil
+}
+
+// downloadTLDList fetches the list and atomically replaces the cache file
+func downloadTLDList(url, cacheFile string) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(url)
+	if err != nil {
+		return fmt.Errorf("TLD list request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("TLD list download returned %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+		return fmt.Errorf("failed to create TLD cache directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(cacheFile), ".tlds-*")
+	if err != nil {
+		return fmt.Errorf("failed to create TLD cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to download TLD list: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write TLD cache file: %w", err)
+	}
+	return os.Rename(tmp.Name(), cacheFile)
+}
+
+// Valid reports whether the domain's TLD is delegated; internationalized TLDs are compared in punycode
+func (l *TLDList) Valid(domain string) bool {
+	tld := strings.ToLower(strings.TrimSuffix(domain, "."))
+	tld = tld[strings.LastIndex(tld, ".")+1:]
+	if ascii, err := idna.Lookup.ToASCII(tld); err == nil {
+		tld = ascii
+	}
+	return l.tlds[tld]
+}
@@ -0,0 +1,195 @@
+package verify
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// keyboardLayouts lists the letter rows of supported layouts, used to find neighbouring keys
+var keyboardLayouts = map[string][]string{
+	"qwerty": {"1234567890-", "qwertyuiop", "asdfghjkl", "zxcvbnm"},
+	"qwertz": {"1234567890-", "qwertzuiop", "asdfghjkl", "yxcvbnm"},
+	"azerty": {"1234567890-", "azertyuiop", "qsdfghjklm", "wxcvbn"},
+}
+
+// globalPopularDomains are suggested in every market
+var globalPopularDomains = []string{
+	"gmail.com", "googlemail.com", "yahoo.com", "hotmail.com", "outlook.com", "live.com",
+	"icloud.com", "me.com", "aol.com", "msn.com", "protonmail.com",
+}
+
+// marketDomains are popular mailbox domains per target market (ISO country code)
+var marketDomains = map[string][]string{
+	"de": {"web.de", "gmx.de", "gmx.net", "t-online.de", "freenet.de", "posteo.de", "yahoo.de", "hotmail.de", "outlook.de"},
+	"at": {"gmx.at", "aon.at", "chello.at", "a1.net", "yahoo.de"},
+	"ch": {"bluewin.ch", "gmx.ch", "hispeed.ch", "sunrise.ch"},
+	"pl": {"wp.pl", "o2.pl", "onet.pl", "op.pl", "interia.pl", "gazeta.pl", "poczta.fm"},
+	"cz": {"seznam.cz", "email.cz", "centrum.cz", "atlas.cz", "volny.cz"},
+	"sk": {"azet.sk", "centrum.sk", "zoznam.sk"},
+	"fr": {"orange.fr", "free.fr", "laposte.net", "sfr.fr", "wanadoo.fr", "neuf.fr", "yahoo.fr", "hotmail.fr"},
+	"be": {"telenet.be", "skynet.be", "proximus.be", "hotmail.be"},
+	"nl": {"ziggo.nl", "kpnmail.nl", "home.nl", "planet.nl", "hotmail.nl", "live.nl"},
+	"it": {"libero.it", "virgilio.it", "tiscali.it", "alice.it", "yahoo.it", "hotmail.it", "tim.it"},
+	"es": {"terra.es", "telefonica.net", "yahoo.es", "hotmail.es"},
+	"pt": {"sapo.pt", "hotmail.pt"},
+	"gb": {"btinternet.com", "sky.com", "yahoo.co.uk", "hotmail.co.uk", "virginmedia.com", "talktalk.net"},
+	"ru": {"mail.ru", "yandex.ru", "bk.ru", "inbox.ru", "list.ru", "rambler.ru", "ya.ru"},
+	"cn": {"qq.com", "163.com", "126.com", "sina.com", "sohu.com", "foxmail.com", "yeah.net"},
+	"in": {"rediffmail.com", "yahoo.co.in", "yahoo.in"},
+	"jp": {"yahoo.co.jp", "docomo.ne.jp", "ezweb.ne.jp", "softbank.ne.jp"},
+	"br": {"uol.com.br", "bol.com.br", "terra.com.br", "yahoo.com.br", "hotmail.com.br"},
+	"us": {"comcast.net", "verizon.net", "att.net", "sbcglobal.net", "cox.net", "ymail.com"},
+}
+
+// marketKeyboards is the dominant keyboard layout per market, defaulting to qwerty
+var marketKeyboards = map[string]string{
+	"de": "qwertz", "at": "qwertz", "ch": "qwertz", "cz": "qwertz", "sk": "qwertz",
+	"fr": "azerty", "be": "azerty",
+}
+
+// TypoSuggester suggests popular domains for misspelled ones using keyboard-aware distance
+type TypoSuggester struct {
+	candidates []string
+	known      map[string]bool
+	neighbours map[rune]map[rune]bool
+}
+
+// newTypoSuggester builds a suggester for the given markets; an empty layout uses the first market's
+func newTypoSuggester(markets, layout string) (*TypoSuggester, error) {
+	s := &TypoSuggester{known: make(map[string]bool)}
+	for _, domain := range globalPopularDomains {
+		s.add(domain)
+	}
+
+	for _, market := range strings.Split(markets, ",") {
+		market = strings.ToLower(strings.TrimSpace(market))
+		if market == "" {
+			continue
+		}
+		domains, ok := marketDomains[market]
+		if !ok {
+			return nil, fmt.Errorf("unknown typo market %q (supported: %s)", market, typoMarkets())
+		}
+		for _, domain := range domains {
+			s.add(domain)
+		}
+		if layout == "" {
+			layout = marketKeyboards[market]
+		}
+	}
+
+	if layout == "" {
+		layout = "qwerty"
+	}
+	rows, ok := keyboardLayouts[strings.ToLower(layout)]
+	if !ok {
+		return nil, fmt.Errorf("unknown keyboard layout %q", layout)
+	}
+	s.neighbours = keyboardNeighbours(rows)
+
+	return s, nil
+}
+
+func (s *TypoSuggester) add(domain string) {
+	if !s.known[domain] {
+		s.known[domain] = true
+		s.candidates = append(s.candidates, domain)
+	}
+}
+
+// Suggest returns the closest popular domain if the domain looks like a typo of it, or an empty string
+func (s *TypoSuggester) Suggest(domain string) string {
+	domain = strings.ToLower(domain)
+	if s.known[domain] {
+		return ""
+	}
+
+	// Allow one edit on short domains and two on longer ones
+	maxDistance := 1.0
+	if len(domain) > 9 {
+		maxDistance = 2.0
+	}
+
+	best, bestDistance := "", math.Inf(1)
+	for _, candidate := range s.candidates {
+		if d := s.distance(domain, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+
+	if bestDistance > 0 && bestDistance <= maxDistance {
+		return best
+	}
+	return ""
+}
+
+// distance is a Damerau-Levenshtein distance where hitting a neighbouring key costs half an edit
+func (s *TypoSuggester) distance(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]float64, len(ra)+1)
+	for i := range d {
+		d[i] = make([]float64, len(rb)+1)
+		d[i][0] = float64(i)
+	}
+	for j := range d[0] {
+		d[0][j] = float64(j)
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 0.0
+			if ra[i-1] != rb[j-1] {
+				cost = 1
+				if s.neighbours[ra[i-1]][rb[j-1]] {
+					cost = 0.5
+				}
+			}
+			d[i][j] = math.Min(math.Min(d[i-1][j]+1, d[i][j-1]+1), d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = math.Min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
+// keyboardNeighbours maps each key to the keys physically adjacent to it
+func keyboardNeighbours(rows []string) map[rune]map[rune]bool {
+	type position struct{ row, col int }
+	positions := make(map[rune]position)
+	for r, row := range rows {
+		for c, key := range []rune(row) {
+			positions[key] = position{r, c}
+		}
+	}
+
+	neighbours := make(map[rune]map[rune]bool)
+	for key, p := range positions {
+		neighbours[key] = make(map[rune]bool)
+		for other, q := range positions {
+			if other != key && abs(p.row-q.row) <= 1 && abs(p.col-q.col) <= 1 {
+				neighbours[key][other] = true
+			}
+		}
+	}
+	return neighbours
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// typoMarkets lists the supported typo markets for error messages
+func typoMarkets() string {
+	markets := make([]string, 0, len(marketDomains))
+	for market := range marketDomains {
+		markets = append(markets, market)
+	}
+	sort.Strings(markets)
+	return strings.Join(markets, ", ")
+}
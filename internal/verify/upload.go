@@ -0,0 +1,291 @@
+package verify
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// uploadsDir holds outputs staged for object storage along with the state of their uploads
+var uploadsDir = filepath.Join(dataDir, "uploads")
+
+// S3 multipart limits; only the last part may be smaller than the minimum
+const (
+	minPartSize = 5 << 20
+	maxParts    = 10000
+)
+
+// UploadOptions configures multipart uploads of outputs to object storage
+type UploadOptions struct {
+	PartSizeMB int
+	Retries    int
+}
+
+// uploadState is saved after every part, so an interrupted upload resumes where it stopped
+type uploadState struct {
+	URL      string         `json:"url"`
+	File     string         `json:"file"`
+	Size     int64          `json:"size"`
+	Modified time.Time      `json:"modified"`
+	PartSize int64          `json:"part_size"`
+	UploadID string         `json:"upload_id,omitempty"`
+	Parts    map[int]string `json:"parts"`
+}
+
+// stagedOutput returns the file an output is written to: the name itself, or a staging file
+// for s3:// and gs:// URLs that is uploaded once complete
+func stagedOutput(name string) string {
+	obj, ok := parseObjectURL(name)
+	if !ok {
+		return name
+	}
+	return filepath.Join(uploadsDir, obj.Scheme+"-"+obj.Bucket+"-"+url.PathEscape(obj.Key))
+}
+
+// checkObjectOutputs fails fast on object storage outputs that can't be uploaded, before a long run
+func checkObjectOutputs(names ...string) error {
+	for _, name := range names {
+		obj, ok := parseObjectURL(name)
+		if !ok {
+			continue
+		}
+		if obj.Bucket == "" || obj.Key == "" {
+			return fmt.Errorf("invalid object storage URL %q, expected %s://bucket/key", name, obj.Scheme)
+		}
+		if _, err := newObjectStore(obj.Scheme); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+			return fmt.Errorf("failed to create uploads directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// publishOutput uploads a staged output to object storage; local outputs are left as they are
+func publishOutput(name string, opts UploadOptions) error {
+	obj, ok := parseObjectURL(name)
+	if !ok {
+		return nil
+	}
+	return uploadStaged(obj, stagedOutput(name), opts)
+}
+
+// uploadStaged uploads a staged file in parts, resuming an earlier attempt at the same file.
+// The staging file and state are removed once the object is complete.
+func uploadStaged(obj ObjectURL, file string, opts UploadOptions) error {
+	store, err := newObjectStore(obj.Scheme)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		return fmt.Errorf("failed to read staged output: %w", err)
+	}
+	statePath := file + ".upload.json"
+
+	state, err := loadUploadState(statePath)
+	if err != nil {
+		slog.Warn("ignoring unreadable upload state", "path", statePath, "error", err)
+	}
+	if state != nil && (state.URL != obj.String() || state.Size != info.Size() || !state.Modified.Equal(info.ModTime())) {
+		// The output was rewritten since; its parts are of no use
+		if state.UploadID != "" {
+			store.AbortMultipartUpload(obj, state.UploadID)
+		}
+		state = nil
+	}
+	if state == nil {
+		state = &uploadState{
+			URL:      obj.String(),
+			File:     file,
+			Size:     info.Size(),
+			Modified: info.ModTime(),
+			PartSize: partSize(info.Size(), opts.PartSizeMB),
+			Parts:    make(map[int]string),
+		}
+	}
+	parts := int((state.Size + state.PartSize - 1) / state.PartSize)
+	if parts == 0 {
+		parts = 1
+	}
+
+	// The store's list of parts wins over the saved one: a part may have landed after the last save
+	if state.UploadID != "" {
+		var uploaded map[int]string
+		err := retryObject(opts.Retries, "listing uploaded parts", func() (err error) {
+			uploaded, err = store.ListParts(obj, state.UploadID)
+			return err
+		})
+		switch {
+		case errors.Is(err, errNoSuchUpload):
+			slog.Warn("upload expired, starting over", "object", obj.String())
+			state.UploadID = ""
+			state.Parts = make(map[int]string)
+		case err != nil:
+			return err
+		default:
+			state.Parts = uploaded
+			slog.Info("resuming upload", "object", obj.String(), "uploaded_parts", len(uploaded), "parts", parts)
+		}
+	}
+	if state.UploadID == "" {
+		err := retryObject(opts.Retries, "starting upload", func() (err error) {
+			state.UploadID, err = store.CreateMultipartUpload(obj)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := saveUploadState(statePath, state); err != nil {
+		return err
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("failed to open staged output: %w", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, state.PartSize)
+	for number := 1; number <= parts; number++ {
+		if _, ok := state.Parts[number]; ok {
+			continue
+		}
+		n, err := f.ReadAt(buf, int64(number-1)*state.PartSize)
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read staged output: %w", err)
+		}
+		var etag string
+		err = retryObject(opts.Retries, fmt.Sprintf("uploading part %d/%d", number, parts), func() (err error) {
+			etag, err = store.UploadPart(obj, state.UploadID, number, buf[:n])
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		state.Parts[number] = etag
+		if err := saveUploadState(statePath, state); err != nil {
+			return err
+		}
+	}
+
+	err = retryObject(opts.Retries, "completing upload", func() error {
+		return store.CompleteMultipartUpload(obj, state.UploadID, state.Parts)
+	})
+	if err != nil {
+		return err
+	}
+
+	os.Remove(statePath)
+	os.Remove(file)
+	slog.Info("uploaded", "object", obj.String(), "bytes", state.Size, "parts", parts)
+	return nil
+}
+
+// partSize returns the part size for a file, growing past the configured size to stay within the part limit
+func partSize(size int64, partSizeMB int) int64 {
+	part := max(int64(partSizeMB)<<20, minPartSize)
+	if size > part*maxParts {
+		part = (size + maxParts - 1) / maxParts
+	}
+	return part
+}
+
+// retryObject retries retryable object storage failures with exponential backoff
+func retryObject(retries int, what string, fn func() error) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		var objErr *objectError
+		if err == nil || !errors.As(err, &objErr) || !objErr.Retryable || attempt > retries {
+			if err != nil {
+				return fmt.Errorf("%s: %w", what, err)
+			}
+			return nil
+		}
+		slog.Warn(what+" failed, retrying", "attempt", attempt, "attempts", retries+1, "backoff", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, time.Minute)
+	}
+}
+
+func loadUploadState(path string) (*uploadState, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var state uploadState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Parts == nil {
+		state.Parts = make(map[int]string)
+	}
+	return &state, nil
+}
+
+// saveUploadState writes the state through a temporary file so a crash never leaves it half-written
+func saveUploadState(path string, state *uploadState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload state: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write upload state: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write upload state: %w", err)
+	}
+	return nil
+}
+
+// ResumeUploads resumes the uploads of staged outputs that an earlier run could not finish,
+// retrying each request up to retries times
+func ResumeUploads(retries int) error {
+	paths, err := filepath.Glob(filepath.Join(uploadsDir, "*.upload.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list pending uploads: %w", err)
+	}
+	if len(paths) == 0 {
+		slog.Info("no pending uploads", "dir", uploadsDir)
+		return nil
+	}
+
+	failed := 0
+	for _, path := range paths {
+		state, err := loadUploadState(path)
+		if err != nil {
+			slog.Error("unreadable upload state", "path", path, "error", err)
+			failed++
+			continue
+		}
+		obj, ok := parseObjectURL(state.URL)
+		if !ok {
+			slog.Error("invalid object URL in upload state", "url", state.URL, "path", path)
+			failed++
+			continue
+		}
+		// The saved part size is kept; the configured one only matters for new uploads
+		opts := UploadOptions{PartSizeMB: int(state.PartSize >> 20), Retries: retries}
+		if err := uploadStaged(obj, state.File, opts); err != nil {
+			slog.Error("upload failed", "object", obj.String(), "error", err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d uploads failed, run upload again to resume", failed, len(paths))
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package verify
+
+import (
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// verifyTrace records where a verification spent its time and which MX host it reached
+type verifyTrace struct {
+	dns     time.Duration
+	smtp    time.Duration
+	mxHost  string
+	retries int    // of failed DNS lookups and SMTP probes
+	policy  string // the probe policy rule that ruled out the SMTP probe
+	family  string // of the connection the library's SMTP probe used
+	// deferred is set when the SMTP probe got a temporary 4xx to a RCPT, as greylisting answers
+	deferred bool
+	// deadline is when verifying the address must wrap up, with -email-timeout
+	deadline time.Time
+}
+
+// timeLeft reports whether the address is still within its deadline, so another probe may start
+func (t verifyTrace) timeLeft() bool {
+	return t.deadline.IsZero() || time.Now().Before(t.deadline)
+}
+
+// phaseTimes accumulates worker time per phase
+type phaseTimes struct {
+	rateLimit time.Duration
+	dns       time.Duration
+	smtp      time.Duration
+	other     time.Duration
+	count     int64
+}
+
+func (p *phaseTimes) add(o phaseTimes) {
+	p.rateLimit += o.rateLimit
+	p.dns += o.dns
+	p.smtp += o.smtp
+	p.other += o.other
+	p.count += o.count
+}
+
+func (p phaseTimes) total() time.Duration {
+	return p.rateLimit + p.dns + p.smtp + p.other
+}
+
+// PhaseReport is time spent per phase, in seconds
+type PhaseReport struct {
+	Verifications int64   `json:"verifications"`
+	RateLimit     float64 `json:"rate_limit_seconds"`
+	DNS           float64 `json:"dns_seconds"`
+	SMTP          float64 `json:"smtp_seconds"`
+	Other         float64 `json:"other_seconds"`
+}
+
+func (p phaseTimes) report() PhaseReport {
+	return PhaseReport{
+		Verifications: p.count,
+		RateLimit:     p.rateLimit.Seconds(),
+		DNS:           p.dns.Seconds(),
+		SMTP:          p.smtp.Seconds(),
+		Other:         p.other.Seconds(),
+	}
+}
+
+// UtilizationReport breaks worker time down per worker and per mailbox provider
+type UtilizationReport struct {
+	Total     PhaseReport            `json:"total"`
+	Workers   []PhaseReport          `json:"workers"`
+	Providers map[string]PhaseReport `json:"providers"`
+}
+
+// Utilization tracks how long workers spend blocked on rate limiters, DNS, SMTP and everything else
+type Utilization struct {
+	mu        sync.Mutex
+	workers   []phaseTimes
+	providers map[string]*phaseTimes
+}
+
+func newUtilization(workers int) *Utilization {
+	return &Utilization{
+		workers:   make([]phaseTimes, workers),
+		providers: make(map[string]*phaseTimes),
+	}
+}
+
+// Record adds one verification's phase times for a worker and provider
+func (u *Utilization) Record(worker int, provider string, times phaseTimes) {
+	times.count = 1
+	if provider == "" {
+		provider = "other"
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	u.workers[worker].add(times)
+	p, ok := u.providers[provider]
+	if !ok {
+		p = &phaseTimes{}
+		u.providers[provider] = p
+	}
+	p.add(times)
+}
+
+func (u *Utilization) totals() phaseTimes {
+	var total phaseTimes
+	for _, w := range u.workers {
+		total.add(w)
+	}
+	return total
+}
+
+// Report returns a snapshot of the utilization so far
+func (u *Utilization) Report() *UtilizationReport {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	report := &UtilizationReport{
+		Total:     u.totals().report(),
+		Workers:   make([]PhaseReport, len(u.workers)),
+		Providers: make(map[string]PhaseReport, len(u.providers)),
+	}
+	for i, w := range u.workers {
+		report.Workers[i] = w.report()
+	}
+	for name, p := range u.providers {
+		report.Providers[name] = p.report()
+	}
+	return report
+}
+
+// RateLimitShare returns the fraction of worker time spent waiting on rate limiters
+func (u *Utilization) RateLimitShare() float64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	total := u.totals()
+	if total.total() == 0 {
+		return 0
+	}
+	return float64(total.rateLimit) / float64(total.total())
+}
+
+// LogSummary prints the phase breakdown overall and for the busiest providers,
+// with a hint whether more workers would help
+func (u *Utilization) LogSummary() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	total := u.totals()
+	if total.total() == 0 {
+		return
+	}
+	slog.Info("worker time", phaseShares(total)...)
+
+	names := make([]string, 0, len(u.providers))
+	for name := range u.providers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return u.providers[names[i]].total() > u.providers[names[j]].total()
+	})
+	for _, name := range names[:min(len(names), 5)] {
+		p := u.providers[name]
+		slog.Info("worker time of provider", append([]any{"provider", name, "emails", p.count}, phaseShares(*p)...)...)
+	}
+
+	rateLimit := float64(total.rateLimit) / float64(total.total())
+	network := float64(total.dns+total.smtp) / float64(total.total())
+	switch {
+	case rateLimit > 0.5:
+		slog.Info("workers mostly waited on rate limits; adding workers won't help, relax -rate, -provider-rate or -domain-rate instead")
+	case network > 0.7:
+		slog.Info("workers mostly waited on the network; adding workers should increase throughput")
+	}
+}
+
+// phaseShares returns each phase's percentage of the time as log attributes
+func phaseShares(p phaseTimes) []any {
+	total := float64(p.total())
+	if total == 0 {
+		return nil
+	}
+	percent := func(d time.Duration) float64 {
+		return math.Round(float64(d)/total*1000) / 10
+	}
+	return []any{
+		"rate_limit_percent", percent(p.rateLimit),
+		"dns_percent", percent(p.dns),
+		"smtp_percent", percent(p.smtp),
+		"other_percent", percent(p.other),
+	}
+}
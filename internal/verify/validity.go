@@ -0,0 +1,89 @@
+package verify
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verdict types with their own validity window
+const (
+	verdictTypeValid   = "valid"
+	verdictTypeRisky   = "risky"
+	verdictTypeInvalid = "invalid"
+	verdictTypeError   = "error"
+)
+
+// builtinValidityWindows is how long verdicts stay trustworthy: mailboxes get deleted over time,
+// dead addresses rarely come back, and verification errors are worth retrying soon
+const builtinValidityWindows = "valid=90d,risky=30d,invalid=180d,error=1d"
+
+// ValidityWindows is how long a verdict can be relied on per verdict type; zero never expires
+type ValidityWindows map[string]time.Duration
+
+// parseValidityWindows parses "valid=90d,risky=30d" over the defaults. Windows are Go durations
+// or whole days with a d suffix.
+func parseValidityWindows(spec string) (ValidityWindows, error) {
+	windows := make(ValidityWindows)
+	for _, s := range []string{builtinValidityWindows, spec} {
+		for _, part := range strings.Split(s, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			verdict, value, ok := strings.Cut(part, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid validity window %q, expected verdict=duration", part)
+			}
+			verdict = strings.ToLower(strings.TrimSpace(verdict))
+			switch verdict {
+			case verdictTypeValid, verdictTypeRisky, verdictTypeInvalid, verdictTypeError:
+			default:
+				return nil, fmt.Errorf("unknown verdict %q in validity windows (expected valid, risky, invalid or error)", verdict)
+			}
+			window, err := parseWindow(strings.TrimSpace(value))
+			if err != nil {
+				return nil, fmt.Errorf("invalid validity window for %s: %w", verdict, err)
+			}
+			windows[verdict] = window
+		}
+	}
+	return windows, nil
+}
+
+// parseWindow parses a Go duration or a number of days such as 90d
+func parseWindow(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid number of days %q", value)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// Stamp records when the result was checked and when its verdict should be re-checked
+func (w ValidityWindows) Stamp(result *EmailResult) {
+	now := time.Now().UTC().Truncate(time.Second)
+	result.CheckedAt = now
+	result.ExpiresAt = nil
+	if window := w[verdictType(*result)]; window > 0 {
+		expires := now.Add(window)
+		result.ExpiresAt = &expires
+	}
+}
+
+// verdictType classifies a result for its validity window
+func verdictType(result EmailResult) string {
+	switch {
+	case result.errored:
+		return verdictTypeError
+	case result.IsValid:
+		return verdictTypeValid
+	case result.Risky:
+		return verdictTypeRisky
+	}
+	return verdictTypeInvalid
+}
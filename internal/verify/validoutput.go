@@ -0,0 +1,60 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// writeValidEmails writes the valid emails in a form that can be fed straight to a mailing system,
+// or back in as input: one per line for .txt, JSON strings for JSON Lines, an "email" column for
+// .csv and .tsv, and an {"emails": [...]} document otherwise
+func writeValidEmails(filename string, valid []string, format OutputFormat, header bool) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	buffered := bufio.NewWriterSize(file, 1024*1024) // 1MB buffer
+	delimiter, delimited := delimiterFor(filename)
+	switch {
+	case isJSONLines(filename):
+		err = writeNDJSON(buffered, valid)
+	case delimited:
+		writer := csv.NewWriter(buffered)
+		writer.Comma = delimiter
+		if header {
+			writer.Write([]string{"email"})
+		}
+		for _, email := range valid {
+			writer.Write([]string{email})
+		}
+		writer.Flush()
+		err = writer.Error()
+	case strings.ToLower(filepath.Ext(filename)) == ".txt":
+		for _, email := range valid {
+			buffered.WriteString(email)
+			buffered.WriteByte('\n')
+		}
+	default:
+		stream := newJSONStream(buffered, format)
+		stream.BeginObject(false)
+		stream.Key("emails")
+		stream.BeginArray(false)
+		writeEntries(stream, valid)
+		stream.End()
+		stream.End()
+		err = stream.Close()
+	}
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return file.Close()
+}
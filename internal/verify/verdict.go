@@ -0,0 +1,124 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// VerdictExpression computes the final verdict from a user-supplied expr expression.
+// The expression returns either a bool (true rejects the address) or one of
+// "valid", "invalid" or "risky".
+type VerdictExpression struct {
+	source  string
+	program *vm.Program
+}
+
+// newVerdictExpression compiles the expression and checks it against a sample result
+func newVerdictExpression(source string) (*VerdictExpression, error) {
+	sample := verdictEnv(EmailResult{Email: "user@example.com"}, &emailverifier.Result{})
+
+	program, err := expr.Compile(source, expr.Env(sample))
+	if err != nil {
+		return nil, fmt.Errorf("invalid verdict expression: %w", err)
+	}
+
+	v := &VerdictExpression{source: source, program: program}
+	if _, _, err := v.evaluate(sample); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Apply replaces the verdict on emailResult with the expression's outcome
+func (v *VerdictExpression) Apply(emailResult *EmailResult, result *emailverifier.Result) error {
+	isValid, risky, err := v.evaluate(verdictEnv(*emailResult, result))
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case isValid:
+		emailResult.Reason = ""
+	case emailResult.IsValid || emailResult.Risky != risky:
+		// The built-in reason no longer explains the verdict
+		emailResult.Reason = "rejected by verdict expression"
+	}
+	emailResult.IsValid = isValid
+	emailResult.Risky = risky
+	return nil
+}
+
+// evaluate runs the program and maps its output to validity and riskiness
+func (v *VerdictExpression) evaluate(env map[string]any) (bool, bool, error) {
+	output, err := expr.Run(v.program, env)
+	if err != nil {
+		return false, false, fmt.Errorf("verdict expression failed: %w", err)
+	}
+
+	switch out := output.(type) {
+	case bool:
+		return !out, false, nil
+	case string:
+		switch out {
+		case "valid":
+			return true, false, nil
+		case "invalid":
+			return false, false, nil
+		case "risky":
+			return false, true, nil
+		}
+	}
+	return false, false, fmt.Errorf("verdict expression must return a bool or \"valid\", \"invalid\" or \"risky\", got %v", output)
+}
+
+// verdictEnv exposes the built-in verdict and the full verifier result, using JSON field names
+func verdictEnv(emailResult EmailResult, result *emailverifier.Result) map[string]any {
+	full := *result
+	if full.SMTP == nil {
+		// Let expressions like result.smtp.catch_all evaluate when SMTP was skipped
+		full.SMTP = &emailverifier.SMTP{}
+	}
+
+	lookalike := ""
+	if emailResult.Lookalike != nil {
+		lookalike = emailResult.Lookalike.Target
+	}
+	var rcptDelta float64
+	if emailResult.RCPTTiming != nil {
+		rcptDelta = emailResult.RCPTTiming.DeltaMS
+	}
+
+	return map[string]any{
+		"email":         emailResult.Email,
+		"domain":        result.Syntax.Domain,
+		"valid":         emailResult.IsValid,
+		"risky":         emailResult.Risky,
+		"reason":        emailResult.Reason,
+		"breached":      emailResult.Breached != nil && *emailResult.Breached,
+		"country":       emailResult.Country,
+		"confidence":    emailResult.Confidence,
+		"catch_all":     emailResult.CatchAllDomain,
+		"lookalike":     lookalike,
+		"rcpt_delta_ms": rcptDelta,
+		"result":        toJSONMap(full),
+		"checks":        toJSONMap(emailResult.Checks),
+		"company":       toJSONMap(emailResult.Company),
+	}
+}
+
+// toJSONMap converts a value to its generic JSON representation
+func toJSONMap(value any) map[string]any {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return map[string]any{}
+	}
+	out := map[string]any{}
+	if err := json.Unmarshal(data, &out); err != nil || out == nil {
+		return map[string]any{}
+	}
+	return out
+}
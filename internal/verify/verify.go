@@ -0,0 +1,708 @@
+package verify
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"text/template"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// InvalidEmail represents an email that failed verification
+type InvalidEmail struct {
+	Email       string     `json:"email"`
+	Reason      string     `json:"reason"`
+	Risky       bool       `json:"risky,omitempty"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	Action      string     `json:"action,omitempty"`
+	ReviewAfter *time.Time `json:"review_after,omitempty"`
+}
+
+// Stats tracks verification statistics
+type Stats struct {
+	TotalChecked int64
+	TotalValid   int64
+	TotalInvalid int64
+	TotalRisky   int64
+	Duplicates   int64 // dropped from the input before verification
+	Retries      int64 // of transiently failed DNS lookups and SMTP probes
+	Greylisted   int64 // still deferred after the retry pass, so of unknown validity
+	Deferred     int64 // not probed because their domain reached -max-per-domain
+	NotProbed    int64 // only checked at DNS level under the probe policy
+	CatchAll     int64 // on domains that accept every address
+	RoleAccounts int64 // addressed to a role rather than a person
+	Free         int64 // at free email providers
+	Allowlisted  int64 // valid without verification, by -allowlist
+	Blocklisted  int64 // invalid without verification, by -blocklist
+	ToDelete     int64 // recommended for deletion with -actions
+	ToQuarantine int64 // recommended for quarantine with -actions
+	ToRetry      int64 // recommended for a retry next run with -actions
+	Interrupted  bool  // the run was stopped before every address was verified
+	StartTime    time.Time
+	Usage        *Utilization
+}
+
+// EmailJob represents a job for the worker pool
+type EmailJob struct {
+	Index int
+	Email string
+}
+
+// EmailResult represents the result of email verification
+type EmailResult struct {
+	Email          string                 `json:"email"`
+	IsValid        bool                   `json:"valid"`
+	Risky          bool                   `json:"risky,omitempty"`
+	Reason         string                 `json:"reason,omitempty"`
+	CheckedAt      time.Time              `json:"checked_at"`
+	ExpiresAt      *time.Time             `json:"expires_at,omitempty"`
+	Action         string                 `json:"action,omitempty"`
+	ReviewAfter    *time.Time             `json:"review_after,omitempty"`
+	ProbedAs       string                 `json:"probed_as,omitempty"`
+	Lookalike      *Lookalike             `json:"lookalike,omitempty"`
+	Repair         *Repair                `json:"repair,omitempty"`
+	Confidence     float64                `json:"confidence,omitempty"`
+	CatchAllDomain bool                   `json:"catch_all,omitempty"`
+	RoleAccount    bool                   `json:"role_account,omitempty"`
+	Free           bool                   `json:"free,omitempty"`
+	Allowlisted    bool                   `json:"allowlisted,omitempty"`
+	IPFamily       string                 `json:"ip_family,omitempty"`
+	CatchAll       *CatchAllSample        `json:"catch_all_sample,omitempty"`
+	RCPTTiming     *RCPTTiming            `json:"rcpt_timing,omitempty"`
+	PatternMatch   *PatternMatch          `json:"pattern_match,omitempty"`
+	Country        string                 `json:"country,omitempty"`
+	CountrySource  string                 `json:"country_source,omitempty"`
+	Breached       *bool                  `json:"breached,omitempty"`
+	Breaches       []string               `json:"breaches,omitempty"`
+	Company        *CompanyInfo           `json:"company,omitempty"`
+	Checks         map[string]CheckResult `json:"checks,omitempty"`
+	Greylisted     bool                   `json:"greylisted,omitempty"`
+	Deferred       bool                   `json:"deferred,omitempty"`
+	Policy         string                 `json:"policy,omitempty"`
+
+	// raw is the library result the verdict was based on, nil if verification errored
+	raw *emailverifier.Result
+	// trace is where the library verification spent its time
+	trace verifyTrace
+	// errored is set when verification failed rather than produced a verdict
+	errored bool
+	// index is the address's position in the run's input
+	index int
+}
+
+const dataDir = "data"
+
+// StdoutOutput as the output file writes results to stdout as NDJSON, for piping
+const StdoutOutput = "-"
+
+// StdinInput as the input file reads emails from stdin
+const StdinInput = "-"
+
+// ErrInterrupted ends a run stopped by SIGINT or SIGTERM, whose outputs hold partial results
+var ErrInterrupted = errors.New("verification interrupted")
+
+// ResumeFile continues the interrupted run journaled in the checkpoint. Unlike -resume, which
+// starts from the beginning without one, it fails when there is nothing to resume.
+func ResumeFile(config Config) error {
+	if config.CheckpointFile == "" {
+		return errors.New("nothing to resume: set -checkpoint or CHECKPOINT_FILE to the interrupted run's checkpoint")
+	}
+	if _, err := os.Stat(config.CheckpointFile); err != nil {
+		return fmt.Errorf("nothing to resume: %w", err)
+	}
+	config.Resume = true
+	return VerifyFile(config)
+}
+
+// VerifyFile verifies the input file of a run and writes its outputs, the tool's main job. It
+// returns ErrInterrupted if a signal stopped the run.
+func VerifyFile(config Config) error {
+	// Contacts hold several addresses each, so their results are always grouped by contact
+	if contactFormat(inputFormatFor(config.InputFile, config.InputFormat)) {
+		config.SplitRecords = true
+	}
+
+	// Ensure data directory exists
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	// Likewise for object storage outputs that could never be uploaded
+	if err := checkObjectOutputs(config.OutputFile, config.DetailsFile, config.ValidFile, config.FreeFile, config.RecordsFile, config.ManifestFile); err != nil {
+		return fmt.Errorf("invalid outputs: %w", err)
+	}
+	if config.WarehouseStage != "" {
+		if err := checkObjectOutputs(stagePath(config.WarehouseStage, warehouseScript)); err != nil {
+			return fmt.Errorf("invalid warehouse stage: %w", err)
+		}
+	}
+	var signingKey *minisignKey
+	if config.SignKey != "" {
+		key, err := loadMinisignKey(config.SignKey, config.SignKeyPassword)
+		if err != nil {
+			return fmt.Errorf("failed to load signing key: %w", err)
+		}
+		signingKey = key
+	}
+
+	// Parse the output template up front so mistakes don't cost a full run
+	var outputTemplate *template.Template
+	if config.OutputTemplate != "" {
+		tmpl, err := loadOutputTemplate(config.OutputTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to load output template: %w", err)
+		}
+		outputTemplate = tmpl
+	}
+	var columns []Column
+	if _, ok := formatDelimiter(config.formatOf(config.OutputFile)); ok {
+		parsed, err := parseColumns(config.OutputColumns, invalidFields)
+		if err != nil {
+			return fmt.Errorf("invalid output columns: %w", err)
+		}
+		columns = parsed
+	}
+	var detailColumns []Column
+	if _, ok := formatDelimiter(config.formatOf(config.DetailsFile)); ok && config.DetailsFile != "" {
+		parsed, err := parseColumns(config.DetailColumns, resultFields)
+		if err != nil {
+			return fmt.Errorf("invalid details columns: %w", err)
+		}
+		detailColumns = parsed
+	}
+
+	// Read emails from input file, a directory, a MongoDB collection or a Google Sheet
+	var records []InputRecord
+	var mongo *MongoStore
+	var sheet *SheetStore
+	var err error
+	input := config.InputFile
+	if isMongoURL(config.InputFile) {
+		input = redactMongoURL(config.InputFile)
+		if mongo, err = openMongoStore(config); err != nil {
+			return fmt.Errorf("failed to open MongoDB input %s: %w", input, err)
+		}
+		records, err = mongo.Records()
+	} else if isSheetsURL(config.InputFile) {
+		if sheet, err = openSheetStore(config); err != nil {
+			return fmt.Errorf("failed to open Google Sheets input %s: %w", input, err)
+		}
+		records, err = sheet.Records()
+	} else if isLDAPURL(config.InputFile) {
+		records, err = readLDAPRecords(config)
+	} else {
+		records, err = readRecordsStreaming(config.InputFile, inputFormatFor(config.InputFile, config.InputFormat), config.csvInput())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read input file: %w", err)
+	}
+	emails := recordEmails(records, config.SplitRecords)
+	if config.SplitRecords {
+		slog.Info("split records into addresses", "records", len(records), "emails", len(emails))
+	} else {
+		records = nil
+	}
+
+	// Shared lookups are created once so their caches and rate limits span all workers
+	lookups, err := newLookups(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure lookups: %w", err)
+	}
+	defer lookups.Close()
+	if mongo != nil {
+		if config.MongoResultField != "" {
+			lookups.Sinks = append(lookups.Sinks, mongo)
+		} else {
+			defer mongo.Close()
+		}
+	}
+	if sheet != nil && len(sheet.results) > 0 {
+		lookups.Sinks = append(lookups.Sinks, sheet)
+	}
+
+	if lookups.InputHook != nil {
+		emails = applyInputHook(lookups.InputHook, emails, config.Verbose)
+	}
+	emails, duplicates := dedupeEmails(emails, config.Dedupe)
+
+	// Every artifact is checksummed once written, for the manifest
+	manifest := &Manifest{Input: input, Artifacts: []Artifact{}}
+	var manifestErr error
+	addArtifact := func(kind, name string, records int) {
+		if config.ManifestFile == "" || manifestErr != nil {
+			return
+		}
+		if err := manifest.Add(kind, name, records); err != nil {
+			manifestErr = fmt.Errorf("failed to add %s artifact to manifest: %w", kind, err)
+		}
+	}
+
+	if config.Repair != repairOff {
+		repairs := repairCandidates(emails)
+		if err := writeRepairCandidates(config.RepairFile, repairs); err != nil {
+			return fmt.Errorf("failed to write repair candidates: %w", err)
+		}
+		addArtifact("repairs", config.RepairFile, len(repairs))
+		if len(repairs) > 0 {
+			slog.Info("addresses have repairable artifacts", "emails", len(repairs), "repair_file", config.RepairFile)
+		}
+	}
+
+	var checkpoint *RunCheckpoint
+	if config.CheckpointFile != "" {
+		checkpoint, err = openRunCheckpoint(config.CheckpointFile, input, emails, config.Resume)
+		if err != nil {
+			return fmt.Errorf("failed to open checkpoint: %w", err)
+		}
+		if checkpoint.Resumed() > 0 {
+			slog.Info("resuming from checkpoint", "checkpoint", config.CheckpointFile, "verified", checkpoint.Resumed(), "emails", len(emails))
+		}
+	}
+
+	totalEmails := len(emails)
+	slog.Info("starting email verification", "emails", totalEmails,
+		"workers", config.Workers, "batch_size", config.BatchSize, "rate_limit", config.RateLimit, "level", config.Level)
+
+	// Initialize stats
+	stats := &Stats{
+		Duplicates: int64(duplicates),
+		StartTime:  time.Now(),
+	}
+	resources := newResourceUsage(lookups.DNS)
+
+	// Invalid emails are written as they come in, unless a template or grouped document needs all
+	// of them first. Sorted outputs are opened now but written once the run is done.
+	var output, streamed ResultWriter
+	if outputTemplate == nil && !groupsDocument(config) {
+		output, err = newResultWriter(config.OutputFile, stagedOutput(config.OutputFile), config, columns)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		if config.SortBy == "" && config.GroupBy == "" {
+			streamed = output
+		}
+	}
+
+	// Process emails concurrently
+	invalidEmails, details, validEmails, freeEmails, err := processEmails(interruptContext(), emails, config, lookups, stats, streamed, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// Write results
+	if outputTemplate != nil {
+		data := TemplateData{
+			Invalid:   invalidEmails,
+			Results:   details,
+			Stats:     stats,
+			CheckedAt: time.Now(),
+			Elapsed:   time.Since(stats.StartTime),
+		}
+		if config.GroupBy == groupByDomain {
+			data.InvalidByDomain = invalidGroups(invalidEmails)
+			data.ResultsByDomain = resultGroups(details)
+		}
+		if err := writeResultsTemplate(stagedOutput(config.OutputFile), outputTemplate, data); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	} else if output != nil {
+		for _, email := range invalidEmails {
+			if err := output.Write(email); err != nil {
+				return fmt.Errorf("failed to write output file: %w", err)
+			}
+		}
+		if err := output.Close(stats); err != nil {
+			return fmt.Errorf("failed to write output file: %w", err)
+		}
+	} else if config.OutputFile == StdoutOutput {
+		if err := encodeResults(os.Stdout, invalidEmails, stats, config.outputFormat()); err != nil {
+			return fmt.Errorf("failed to write results to stdout: %w", err)
+		}
+	} else if err := writeResultsStreaming(stagedOutput(config.OutputFile), invalidEmails, stats, config.outputFormat()); err != nil {
+		return fmt.Errorf("failed to write output file: %w", err)
+	}
+	var outputs []string
+	if config.OutputFile != StdoutOutput {
+		addArtifact("output", config.OutputFile, int(stats.TotalInvalid+stats.TotalRisky))
+		outputs = append(outputs, config.OutputFile)
+	}
+	if config.DetailsFile != "" {
+		if err := writeDetailsFile(config.DetailsFile, details, detailColumns, config); err != nil {
+			return fmt.Errorf("failed to write details file: %w", err)
+		}
+		addArtifact("details", config.DetailsFile, len(details))
+		outputs = append(outputs, config.DetailsFile)
+	}
+	if config.ValidFile != "" {
+		if err := writeValidEmails(stagedOutput(config.ValidFile), validEmails, config.outputFormat(), config.OutputHeader); err != nil {
+			return fmt.Errorf("failed to write valid emails file: %w", err)
+		}
+		addArtifact("valid", config.ValidFile, len(validEmails))
+		outputs = append(outputs, config.ValidFile)
+	}
+	if config.FreeFile != "" {
+		if err := writeValidEmails(stagedOutput(config.FreeFile), freeEmails, config.outputFormat(), config.OutputHeader); err != nil {
+			return fmt.Errorf("failed to write free emails file: %w", err)
+		}
+		addArtifact("free", config.FreeFile, len(freeEmails))
+		outputs = append(outputs, config.FreeFile)
+	}
+	if config.SplitRecords {
+		groups := groupRecords(records, details)
+		if err := writeRecordResults(stagedOutput(config.RecordsFile), groups); err != nil {
+			return fmt.Errorf("failed to write records file: %w", err)
+		}
+		addArtifact("records", config.RecordsFile, len(groups))
+		outputs = append(outputs, config.RecordsFile)
+	}
+	if config.WarehouseStage != "" {
+		files, err := writeWarehouseStage(details, config)
+		if err != nil {
+			return fmt.Errorf("failed to write warehouse stage: %w", err)
+		}
+		for _, file := range files {
+			addArtifact("warehouse", file.name, file.rows)
+			outputs = append(outputs, file.name)
+		}
+	}
+	var patterns []DomainPattern
+	if lookups.Patterns != nil {
+		patterns = lookups.Patterns.Report()
+		if err := writePatternReport(config.PatternsFile, patterns); err != nil {
+			return fmt.Errorf("failed to write patterns file: %w", err)
+		}
+		addArtifact("patterns", config.PatternsFile, len(patterns))
+		lookups.Patterns.Persist()
+	}
+	resourceReport := resources.Report(stats.TotalChecked)
+	if config.ResourceReport != "" {
+		if err := writeResourceReport(config.ResourceReport, resourceReport); err != nil {
+			return fmt.Errorf("failed to write resource report: %w", err)
+		}
+		addArtifact("resources", config.ResourceReport, 1)
+	}
+	// Written last and uploaded last, so a manifest's presence means every artifact is in place
+	if config.ManifestFile != "" {
+		if manifestErr != nil {
+			return manifestErr
+		}
+		if err := writeManifest(stagedOutput(config.ManifestFile), manifest); err != nil {
+			return fmt.Errorf("failed to write manifest: %w", err)
+		}
+		if signingKey != nil {
+			if err := signManifest(config.ManifestFile, signingKey); err != nil {
+				return fmt.Errorf("failed to sign manifest: %w", err)
+			}
+			outputs = append(outputs, signatureFile(config.ManifestFile))
+		}
+		outputs = append(outputs, config.ManifestFile)
+	}
+	if lookups.Domains != nil {
+		if err := lookups.Domains.Save(); err != nil {
+			slog.Warn("failed to save domain store", "error", err)
+		}
+	}
+
+	// Outputs stay staged after a failed upload so the upload subcommand can finish it. Partial
+	// results of an interrupted run stay staged too, rather than replace complete ones downstream.
+	uploadOptions := UploadOptions{PartSizeMB: config.UploadPartSize, Retries: config.UploadRetries}
+	for _, output := range outputs {
+		if _, remote := parseObjectURL(output); remote && stats.Interrupted {
+			slog.Info("partial output left staged, upload it with the upload command", "output", output, "staged", stagedOutput(output))
+			continue
+		}
+		if err := publishOutput(output, uploadOptions); err != nil {
+			return fmt.Errorf("failed to upload %s, resume with the upload command (staged at %s): %w", output, stagedOutput(output), err)
+		}
+	}
+
+	// Every output is in place, so there's nothing left to resume
+	if checkpoint != nil && !stats.Interrupted {
+		if err := checkpoint.Remove(); err != nil {
+			slog.Warn("failed to remove checkpoint", "error", err)
+		}
+	}
+
+	// Print summary
+	elapsed := time.Since(stats.StartTime)
+	summary := []any{
+		"checked", stats.TotalChecked,
+		"total", totalEmails,
+		"valid", stats.TotalValid,
+		"invalid", stats.TotalInvalid,
+		"risky", stats.TotalRisky,
+		"greylisted", stats.Greylisted,
+		"deferred", stats.Deferred,
+		"not_probed", stats.NotProbed,
+		"catch_all", stats.CatchAll,
+		"role_accounts", stats.RoleAccounts,
+		"free", stats.Free,
+		"duplicates", stats.Duplicates,
+		"retries", stats.Retries,
+		"elapsed", elapsed.Round(time.Second),
+		"emails_per_second", math.Round(float64(stats.TotalChecked)/elapsed.Seconds()*100) / 100,
+	}
+	if config.OutputFile == StdoutOutput {
+		summary = append(summary, "output", "stdout")
+	} else {
+		summary = append(summary, "output", config.OutputFile)
+	}
+	if config.DetailsFile != "" {
+		summary = append(summary, "details", config.DetailsFile)
+	}
+	if config.ValidFile != "" {
+		summary = append(summary, "valid_output", config.ValidFile)
+	}
+	if config.FreeFile != "" {
+		summary = append(summary, "free_output", config.FreeFile)
+	}
+	if config.SplitRecords {
+		summary = append(summary, "records_file", config.RecordsFile)
+	}
+	if config.WarehouseStage != "" {
+		summary = append(summary, "warehouse_script", stagePath(config.WarehouseStage, warehouseScript))
+	}
+	if config.ManifestFile != "" {
+		summary = append(summary, "manifest", config.ManifestFile, "manifest_artifacts", len(manifest.Artifacts))
+		if signingKey != nil {
+			summary = append(summary, "manifest_signature", signatureFile(config.ManifestFile))
+		}
+	}
+	if lookups.Patterns != nil {
+		summary = append(summary, "patterns_file", config.PatternsFile, "pattern_domains", len(patterns))
+	}
+	if config.ResourceReport != "" {
+		summary = append(summary, "resource_report", config.ResourceReport)
+	}
+	if config.Allowlist != "" || config.Blocklist != "" {
+		summary = append(summary, "allowlisted", stats.Allowlisted, "blocklisted", stats.Blocklisted)
+	}
+	if config.Actions {
+		summary = append(summary, "delete", stats.ToDelete, "quarantine", stats.ToQuarantine, "retry", stats.ToRetry)
+	}
+	if stats.Interrupted {
+		if checkpoint != nil {
+			summary = append(summary, "resume_with", "resume -checkpoint="+config.CheckpointFile)
+		}
+		slog.Warn("verification interrupted", summary...)
+	} else {
+		slog.Info("verification complete", summary...)
+	}
+	stats.Usage.LogSummary()
+	resourceReport.Log()
+
+	// Scripts must not mistake partial results for a finished run
+	if stats.Interrupted {
+		return ErrInterrupted
+	}
+	return nil
+}
+
+// readRecordsStreaming reads input records from a JSON, CSV, TSV or text file using streaming for memory efficiency
+func readRecordsStreaming(filename, format string, csvInput CSVInput) ([]InputRecord, error) {
+	var input io.Reader = os.Stdin
+	var size int64
+	if filename != StdinInput {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file %s: %w", filename, err)
+		}
+		defer file.Close()
+
+		// Get file size for pre-allocation estimate
+		stat, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		input, size = file, stat.Size()
+	}
+	if format == inputAuto {
+		buffered := bufio.NewReaderSize(input, 1024*1024) // 1MB buffer
+		input, format = buffered, sniffInputFormat(buffered)
+	}
+
+	records := make([]InputRecord, 0, estimateEmails(size))
+	each := func(record InputRecord) {
+		records = append(records, record)
+	}
+	var err error
+	switch format {
+	case inputCSV:
+		err = decodeCSVInput(input, ',', csvInput, each)
+	case inputTSV:
+		err = decodeCSVInput(input, '\t', csvInput, each)
+	case inputText:
+		err = decodeTextInput(input, each)
+	case inputJSONL:
+		err = decodeJSONLines(input, each)
+	case inputVCard:
+		err = decodeVCardInput(input, each)
+	case inputOutlook:
+		err = decodeOutlookInput(input, each)
+	default:
+		err = decodeInput(input, each)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	source := filename
+	if filename == StdinInput {
+		source = "stdin"
+	}
+	slog.Info("loaded emails", "emails", len(records), "source", source)
+	return records, nil
+}
+
+// decodeEmails reads the addresses of an input document; size is a hint for pre-allocation
+func decodeEmails(r io.Reader, size int64) ([]string, error) {
+	emails := make([]string, 0, estimateEmails(size))
+	if err := decodeInput(r, func(record InputRecord) {
+		emails = append(emails, record.Email)
+	}); err != nil {
+		return nil, err
+	}
+	return emails, nil
+}
+
+// estimateEmails guesses how many addresses an input document of size bytes holds
+func estimateEmails(size int64) int64 {
+	// Estimate capacity: assume average email is ~30 bytes + JSON overhead
+	estimatedCapacity := size / 35
+	if estimatedCapacity < 100 {
+		estimatedCapacity = 100
+	}
+	if estimatedCapacity > 10_000_000 {
+		estimatedCapacity = 10_000_000
+	}
+	return estimatedCapacity
+}
+
+// decodeInput calls each for every entry of the "emails" array of an input document
+func decodeInput(r io.Reader, each func(InputRecord)) error {
+	decoder := json.NewDecoder(bufio.NewReaderSize(r, 1024*1024)) // 1MB buffer
+
+	// Read opening brace
+	token, err := decoder.Token()
+	if err != nil {
+		return fmt.Errorf("failed to read JSON: %w", err)
+	}
+	if token != json.Delim('{') {
+		return fmt.Errorf("expected object start, got %v", token)
+	}
+
+	// Read until we find "emails" key
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return fmt.Errorf("failed to read token: %w", err)
+		}
+
+		if key, ok := token.(string); ok && key == "emails" {
+			// Read the array
+			token, err := decoder.Token()
+			if err != nil {
+				return fmt.Errorf("failed to read array start: %w", err)
+			}
+			if token != json.Delim('[') {
+				return fmt.Errorf("expected array start, got %v", token)
+			}
+
+			// Read each email
+			for decoder.More() {
+				var record InputRecord
+				if err := decoder.Decode(&record); err != nil {
+					return fmt.Errorf("failed to decode email: %w", err)
+				}
+				each(record)
+			}
+
+			// Read array end
+			if _, err := decoder.Token(); err != nil {
+				return fmt.Errorf("failed to read array end: %w", err)
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+// writeResultsStreaming writes results using streaming for memory efficiency
+func writeResultsStreaming(filename string, invalidEmails []InvalidEmail, stats *Stats, format OutputFormat) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	if err := encodeResults(file, invalidEmails, stats, format); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return file.Close()
+}
+
+// encodeResults writes the invalid emails and run statistics as the output JSON document.
+// Grouped by domain, the invalid emails are nested under a "domains" array instead.
+func encodeResults(w io.Writer, invalidEmails []InvalidEmail, stats *Stats, format OutputFormat) error {
+	stream := newJSONStream(w, format)
+	stream.BeginObject(false)
+	if format.GroupBy == groupByDomain {
+		writeDomainGroups(stream, "invalid_emails", invalidGroups(invalidEmails))
+	} else {
+		stream.Key("invalid_emails")
+		stream.BeginArray(false)
+		writeEntries(stream, invalidEmails)
+		stream.End()
+	}
+
+	writeStatsFooter(stream, stats)
+	stream.End()
+	return stream.Close()
+}
+
+// writeDetailsFile writes the details to the staged file of an output, in the format of its name
+// or -output-format
+func writeDetailsFile(name string, details []EmailResult, columns []Column, config Config) error {
+	format := config.formatOf(name)
+	if delimiter, ok := formatDelimiter(format); ok {
+		return writeDetailsDelimited(stagedOutput(name), details, columns, delimiter, config.OutputHeader)
+	}
+	if format == outputJSONL {
+		return writeJSONLinesFile(stagedOutput(name), details)
+	}
+	return writeDetailsStreaming(stagedOutput(name), details, config.outputFormat())
+}
+
+// writeDetailsStreaming writes the full per-email results using streaming for memory efficiency
+func writeDetailsStreaming(filename string, details []EmailResult, format OutputFormat) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	stream := newJSONStream(file, format)
+	stream.BeginObject(false)
+	if format.GroupBy == groupByDomain {
+		writeDomainGroups(stream, "results", resultGroups(details))
+	} else {
+		stream.Key("results")
+		stream.BeginArray(false)
+		writeEntries(stream, details)
+		stream.End()
+	}
+	stream.Field("checked_at", time.Now().Format(time.RFC3339))
+	stream.End()
+	if err := stream.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,179 @@
+package verify
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	emailverifier "github.com/AfterShip/email-verifier"
+)
+
+// Verdict classes of a result as CheckStatus tells them apart, which the check command exits
+// with. Setup errors exit with 1 and usage errors with 2, so neither is a verdict.
+const (
+	CheckValid   = 0
+	CheckInvalid = 3
+	CheckRisky   = 4
+	CheckUnknown = 5
+)
+
+// CheckStatus returns the verdict class of a result: valid, invalid, risky, or unknown when
+// verification failed or the server kept deferring
+func CheckStatus(result EmailResult) int {
+	switch {
+	case result.errored || result.Deferred:
+		return CheckUnknown
+	case !result.IsValid:
+		return CheckInvalid
+	case result.Risky:
+		return CheckRisky
+	}
+	return CheckValid
+}
+
+// VerifyOne verifies an address with every configured check and prints the full result
+func VerifyOne(config Config, email string, asJSON bool) (EmailResult, error) {
+	lookups, err := newLookups(config)
+	if err != nil {
+		return EmailResult{}, fmt.Errorf("failed to configure lookups: %w", err)
+	}
+
+	if lookups.InputHook != nil {
+		transformed := applyInputHook(lookups.InputHook, []string{email}, config.Verbose)
+		if len(transformed) == 0 {
+			lookups.Close()
+			return EmailResult{}, fmt.Errorf("pre-hook dropped %s", email)
+		}
+		email = transformed[0]
+	}
+
+	// There is no second pass for a single address, so a greylisted one is reported as unknown
+	var greylist *GreylistQueue
+	if config.EnableSMTP && config.GreylistRetry > 0 {
+		greylist = newGreylistQueue(config.GreylistRetry)
+	}
+
+	verified, repair := repairInput(email, config.Repair)
+	result := verifyEmail(newVerifier(config), lookups, nil, nil, nil, greylist, verified, config)
+	result.Repair = repair
+	raw := result.raw
+	if lookups.ResultHook != nil {
+		result = applyResultHook(lookups.ResultHook, result, config.Verbose)
+	}
+	lookups.Close()
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(struct {
+			EmailResult
+			Details *emailverifier.Result `json:"details,omitempty"`
+		}{result, raw})
+	} else {
+		printResult(result, raw)
+	}
+	return result, nil
+}
+
+// printResult pretty-prints a result and the library details it was based on
+func printResult(result EmailResult, raw *emailverifier.Result) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+
+	verdict := "✅ valid"
+	switch {
+	case result.Risky:
+		verdict = "⚠️  risky"
+	case !result.IsValid:
+		verdict = "❌ invalid"
+	}
+
+	fmt.Fprintf(w, "Email:\t%s\n", result.Email)
+	fmt.Fprintf(w, "Verdict:\t%s\n", verdict)
+	if result.Reason != "" {
+		fmt.Fprintf(w, "Reason:\t%s\n", result.Reason)
+	}
+	if repair := result.Repair; repair != nil {
+		if repair.Candidate != "" {
+			fmt.Fprintf(w, "Did you mean:\t%s (%s)\n", repair.Candidate, strings.Join(repair.Fixes, ", "))
+		} else {
+			fmt.Fprintf(w, "Repaired from:\t%q (%s)\n", repair.Original, strings.Join(repair.Fixes, ", "))
+		}
+	}
+	if result.ExpiresAt != nil {
+		fmt.Fprintf(w, "Re-check after:\t%s\n", result.ExpiresAt.Format(time.RFC3339))
+	}
+
+	if raw != nil {
+		fmt.Fprintf(w, "Syntax:\t%s\n", yesNo(raw.Syntax.Valid))
+		fmt.Fprintf(w, "Domain:\t%s\n", raw.Syntax.Domain)
+		fmt.Fprintf(w, "MX records:\t%s\n", yesNo(raw.HasMxRecords))
+		if raw.SMTP != nil {
+			fmt.Fprintf(w, "SMTP host:\t%s\n", yesNo(raw.SMTP.HostExists))
+			fmt.Fprintf(w, "Deliverable:\t%s\n", yesNo(raw.SMTP.Deliverable))
+			fmt.Fprintf(w, "Catch-all:\t%s\n", yesNo(raw.SMTP.CatchAll))
+			fmt.Fprintf(w, "Full inbox:\t%s\n", yesNo(raw.SMTP.FullInbox))
+			fmt.Fprintf(w, "Disabled:\t%s\n", yesNo(raw.SMTP.Disabled))
+		} else {
+			fmt.Fprintf(w, "SMTP:\tnot checked\n")
+		}
+		fmt.Fprintf(w, "Reachable:\t%s\n", raw.Reachable)
+		fmt.Fprintf(w, "Disposable:\t%s\n", yesNo(raw.Disposable))
+		fmt.Fprintf(w, "Free provider:\t%s\n", yesNo(raw.Free))
+		fmt.Fprintf(w, "Role account:\t%s\n", yesNo(raw.RoleAccount))
+		if raw.Suggestion != "" {
+			fmt.Fprintf(w, "Suggestion:\t%s\n", raw.Suggestion)
+		}
+	}
+
+	if timing := result.RCPTTiming; timing != nil {
+		fmt.Fprintf(w, "RCPT timing:\t%.1fms vs %.1fms control (%+.1fms)\n", timing.TargetMS, timing.ControlMS, timing.DeltaMS)
+	}
+	if result.Country != "" {
+		fmt.Fprintf(w, "Country:\t%s (%s)\n", result.Country, result.CountrySource)
+	}
+	if result.Breached != nil {
+		breached := yesNo(*result.Breached)
+		if len(result.Breaches) > 0 {
+			breached += " (" + strings.Join(result.Breaches, ", ") + ")"
+		}
+		fmt.Fprintf(w, "Breached:\t%s\n", breached)
+	}
+	if company := result.Company; company != nil {
+		fmt.Fprintf(w, "Company:\t%s\n", company.Name)
+		if company.Industry != "" {
+			fmt.Fprintf(w, "Industry:\t%s\n", company.Industry)
+		}
+		if company.Employees > 0 {
+			fmt.Fprintf(w, "Employees:\t%d\n", company.Employees)
+		}
+	}
+
+	names := make([]string, 0, len(result.Checks))
+	for name := range result.Checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		check := result.Checks[name]
+		line := check.Verdict
+		if line == "" {
+			line = "pass"
+		}
+		if check.Reason != "" {
+			line += " - " + check.Reason
+		}
+		fmt.Fprintf(w, "Check %s:\t%s\n", name, line)
+	}
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
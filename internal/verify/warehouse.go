@@ -0,0 +1,238 @@
+package verify
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Warehouses the staging output generates load statements for
+const (
+	warehouseSnowflake = "snowflake"
+	warehouseRedshift  = "redshift"
+)
+
+const (
+	// warehouseScript holds the statements loading the shards, written after them
+	warehouseScript = "load.sql"
+	// warehouseManifest lists the shards for Redshift's COPY ... MANIFEST
+	warehouseManifest = "results.manifest"
+)
+
+// warehouseColumns are the columns of the staged shards, in order, with their SQL types in
+// Snowflake and Redshift
+var warehouseColumns = []struct {
+	name      string
+	snowflake string
+	redshift  string
+}{
+	{"email", "VARCHAR NOT NULL", "VARCHAR(320) NOT NULL"},
+	{"domain", "VARCHAR", "VARCHAR(255)"},
+	{"valid", "BOOLEAN", "BOOLEAN"},
+	{"risky", "BOOLEAN", "BOOLEAN"},
+	{"reason", "VARCHAR", "VARCHAR(1024)"},
+	{"reachable", "VARCHAR", "VARCHAR(16)"},
+	{"disposable", "BOOLEAN", "BOOLEAN"},
+	{"role_account", "BOOLEAN", "BOOLEAN"},
+	{"free", "BOOLEAN", "BOOLEAN"},
+	{"confidence", "FLOAT", "DOUBLE PRECISION"},
+	{"country", "VARCHAR", "VARCHAR(2)"},
+	{"greylisted", "BOOLEAN", "BOOLEAN"},
+	{"deferred", "BOOLEAN", "BOOLEAN"},
+	{"policy", "VARCHAR", "VARCHAR(255)"},
+	{"probed_as", "VARCHAR", "VARCHAR(255)"},
+	{"checked_at", "TIMESTAMP_TZ", "TIMESTAMPTZ"},
+	{"expires_at", "TIMESTAMP_TZ", "TIMESTAMPTZ"},
+}
+
+// sqlIdentifier matches the table names and storage integrations put into the load statements
+// unquoted: a name, optionally qualified by schema and database
+var sqlIdentifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*(\.[A-Za-z_][A-Za-z0-9_$]*){0,2}$`)
+
+// warehouseShard is a staged file of results
+type warehouseShard struct {
+	name string
+	rows int
+	size int64
+}
+
+// stagePath joins a file name onto the stage, a local directory or object storage prefix
+func stagePath(stage, name string) string {
+	if _, remote := parseObjectURL(stage); remote {
+		return strings.TrimSuffix(stage, "/") + "/" + name
+	}
+	return filepath.Join(stage, name)
+}
+
+// writeWarehouseStage writes the results as gzipped CSV shards of the configured size, then the
+// statements loading them into the warehouse, and for Redshift a manifest listing the shards.
+// It returns the files written in upload order, so the script arrives last.
+func writeWarehouseStage(details []EmailResult, config Config) ([]warehouseShard, error) {
+	if _, remote := parseObjectURL(config.WarehouseStage); !remote {
+		if err := os.MkdirAll(config.WarehouseStage, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create stage directory: %w", err)
+		}
+	}
+	columns := make([]Column, len(warehouseColumns))
+	for i, column := range warehouseColumns {
+		columns[i] = Column{Header: column.name, Field: column.name}
+	}
+
+	// An empty run still gets a shard, so the statements load something rather than fail
+	var shards []warehouseShard
+	for start := 0; start == 0 || start < len(details); start += config.WarehouseShardRows {
+		rows := details[start:min(start+config.WarehouseShardRows, len(details))]
+		name := stagePath(config.WarehouseStage, fmt.Sprintf("results-%05d.csv.gz", len(shards)+1))
+		size, err := writeWarehouseShard(stagedOutput(name), rows, columns)
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, warehouseShard{name: name, rows: len(rows), size: size})
+	}
+
+	files := shards
+	if config.Warehouse == warehouseRedshift {
+		manifest := stagePath(config.WarehouseStage, warehouseManifest)
+		if err := writeRedshiftManifest(stagedOutput(manifest), shards); err != nil {
+			return nil, err
+		}
+		files = append(files, warehouseShard{name: manifest, rows: len(shards)})
+	}
+	script := stagePath(config.WarehouseStage, warehouseScript)
+	statements, err := warehouseStatements(config, shards)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(stagedOutput(script), []byte(statements), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", script, err)
+	}
+	return append(files, warehouseShard{name: script}), nil
+}
+
+// writeWarehouseShard writes rows as gzipped CSV with a header, returning the file's size
+func writeWarehouseShard(filename string, rows []EmailResult, columns []Column) (int64, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create file %s: %w", filename, err)
+	}
+	defer file.Close()
+
+	zw := gzip.NewWriter(file)
+	writer := newDelimitedWriter(zw, nil, columns, resultFields, ',', true)
+	for _, result := range rows {
+		if err := writer.Write(result); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", filename, err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	if err := zw.Close(); err != nil {
+		return 0, fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), file.Close()
+}
+
+// writeRedshiftManifest lists the shards, so COPY loads exactly these and fails if one is missing
+func writeRedshiftManifest(filename string, shards []warehouseShard) error {
+	type entry struct {
+		URL       string         `json:"url"`
+		Mandatory bool           `json:"mandatory"`
+		Meta      map[string]any `json:"meta"`
+	}
+	manifest := struct {
+		Entries []entry `json:"entries"`
+	}{}
+	for _, shard := range shards {
+		manifest.Entries = append(manifest.Entries, entry{URL: shard.name, Mandatory: true, Meta: map[string]any{"content_length": shard.size}})
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filename, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filename, err)
+	}
+	return nil
+}
+
+// warehouseStatements generates the script creating the table if needed and loading the shards
+func warehouseStatements(config Config, shards []warehouseShard) (string, error) {
+	var sql strings.Builder
+	names := make([]string, len(warehouseColumns))
+	fmt.Fprintf(&sql, "-- Loads %d shards of email verification results into %s\n", len(shards), config.WarehouseTable)
+	fmt.Fprintf(&sql, "CREATE TABLE IF NOT EXISTS %s (\n", config.WarehouseTable)
+	for i, column := range warehouseColumns {
+		names[i] = column.name
+		typ := column.snowflake
+		if config.Warehouse == warehouseRedshift {
+			typ = column.redshift
+		}
+		separator := ","
+		if i == len(warehouseColumns)-1 {
+			separator = ""
+		}
+		fmt.Fprintf(&sql, "  %s %s%s\n", column.name, typ, separator)
+	}
+	sql.WriteString(");\n\n")
+	columnList := strings.Join(names, ", ")
+
+	if config.Warehouse == warehouseRedshift {
+		fmt.Fprintf(&sql, "COPY %s (%s)\n", config.WarehouseTable, columnList)
+		fmt.Fprintf(&sql, "FROM %s\n", sqlString(stagePath(config.WarehouseStage, warehouseManifest)))
+		if config.WarehouseAuth != "" {
+			fmt.Fprintf(&sql, "IAM_ROLE %s\n", sqlString(config.WarehouseAuth))
+		} else {
+			sql.WriteString("IAM_ROLE default\n")
+		}
+		sql.WriteString("MANIFEST CSV GZIP IGNOREHEADER 1 EMPTYASNULL TIMEFORMAT 'auto';\n")
+		return sql.String(), nil
+	}
+
+	// Snowflake reads object storage directly with a storage integration, and local shards
+	// through the table's own stage after uploading them there
+	stage := strings.TrimSuffix(config.WarehouseStage, "/")
+	obj, remote := parseObjectURL(stage)
+	var source string
+	switch {
+	case !remote:
+		dir, err := filepath.Abs(stage)
+		if err != nil {
+			return "", err
+		}
+		// The table stage of schema.table is @schema.%table
+		dot := strings.LastIndex(config.WarehouseTable, ".") + 1
+		source = "@" + config.WarehouseTable[:dot] + "%" + config.WarehouseTable[dot:]
+		fmt.Fprintf(&sql, "PUT %s %s AUTO_COMPRESS = FALSE OVERWRITE = TRUE;\n\n", sqlString("file://"+filepath.ToSlash(filepath.Join(dir, "results-*.csv.gz"))), source)
+	case obj.Scheme == schemeGCS:
+		// Snowflake names GCS URLs gcs://
+		source = sqlString("gcs://" + strings.TrimPrefix(stage, schemeGCS+"://") + "/")
+	default:
+		source = sqlString(stage + "/")
+	}
+	files := make([]string, len(shards))
+	for i, shard := range shards {
+		files[i] = sqlString(filepath.Base(shard.name))
+	}
+	fmt.Fprintf(&sql, "COPY INTO %s (%s)\n", config.WarehouseTable, columnList)
+	fmt.Fprintf(&sql, "  FROM %s\n", source)
+	if remote && config.WarehouseAuth != "" {
+		fmt.Fprintf(&sql, "  STORAGE_INTEGRATION = %s\n", config.WarehouseAuth)
+	}
+	fmt.Fprintf(&sql, "  FILES = (%s)\n", strings.Join(files, ", "))
+	sql.WriteString("  FILE_FORMAT = (TYPE = CSV COMPRESSION = GZIP SKIP_HEADER = 1 FIELD_OPTIONALLY_ENCLOSED_BY = '\"' EMPTY_FIELD_AS_NULL = TRUE TIMESTAMP_FORMAT = AUTO);\n")
+	return sql.String(), nil
+}
+
+// sqlString quotes a string literal
+func sqlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
@@ -0,0 +1,142 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+)
+
+// wasmModule runs extension methods exported by a sandboxed WASM module.
+//
+// The module must export "memory" and "alloc(size i32) i32". Each method is an
+// export taking (ptr i32, len i32) of a JSON params document and returning an
+// i64 packing (ptr << 32 | len) of the JSON result, or 0 for none. An optional
+// "dealloc(ptr i32, len i32)" export is called to free both buffers.
+// Modules get WASI with no filesystem or network access; stderr is passed through.
+type wasmModule struct {
+	name      string
+	runtime   wazero.Runtime
+	instances chan api.Module
+}
+
+// loadWASMModule compiles a module and instantiates a small pool so workers can call it concurrently
+func loadWASMModule(path string) (*wasmModule, error) {
+	code, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read WASM module %s: %w", path, err)
+	}
+
+	ctx := context.Background()
+	rt := wazero.NewRuntime(ctx)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, rt); err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("failed to instantiate WASI: %w", err)
+	}
+
+	compiled, err := rt.CompileModule(ctx, code)
+	if err != nil {
+		rt.Close(ctx)
+		return nil, fmt.Errorf("failed to compile WASM module %s: %w", path, err)
+	}
+
+	poolSize := runtime.NumCPU()
+	m := &wasmModule{name: path, runtime: rt, instances: make(chan api.Module, poolSize)}
+
+	// Reactor modules initialize via _initialize; running _start would exit them
+	moduleConfig := wazero.NewModuleConfig().
+		WithName("").
+		WithStderr(os.Stderr).
+		WithStartFunctions("_initialize")
+
+	for i := 0; i < poolSize; i++ {
+		instance, err := rt.InstantiateModule(ctx, compiled, moduleConfig)
+		if err != nil {
+			rt.Close(ctx)
+			return nil, fmt.Errorf("failed to instantiate WASM module %s: %w", path, err)
+		}
+		if instance.ExportedFunction("alloc") == nil || instance.Memory() == nil {
+			rt.Close(ctx)
+			return nil, fmt.Errorf("WASM module %s must export memory and alloc", path)
+		}
+		m.instances <- instance
+	}
+
+	return m, nil
+}
+
+// Call invokes an exported method with JSON params and decodes its JSON result into out
+func (m *wasmModule) Call(method string, params, out any) error {
+	input, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s params: %w", method, err)
+	}
+
+	instance := <-m.instances
+	defer func() { m.instances <- instance }()
+
+	output, err := m.invoke(instance, method, input)
+	if err != nil || output == nil {
+		return err
+	}
+	if err := json.Unmarshal(output, out); err != nil {
+		return fmt.Errorf("invalid %s result from %s: %w", method, m.name, err)
+	}
+	return nil
+}
+
+// invoke copies input into the instance, calls the method and copies the result out
+func (m *wasmModule) invoke(instance api.Module, method string, input []byte) ([]byte, error) {
+	ctx := context.Background()
+
+	fn := instance.ExportedFunction(method)
+	if fn == nil {
+		return nil, fmt.Errorf("WASM module %s does not export %s", m.name, method)
+	}
+
+	allocated, err := instance.ExportedFunction("alloc").Call(ctx, uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("%s alloc failed: %w", m.name, err)
+	}
+	inputPtr := uint32(allocated[0])
+	defer m.free(instance, inputPtr, uint32(len(input)))
+
+	if !instance.Memory().Write(inputPtr, input) {
+		return nil, fmt.Errorf("%s alloc returned out-of-range pointer %d", m.name, inputPtr)
+	}
+
+	returned, err := fn.Call(ctx, uint64(inputPtr), uint64(len(input)))
+	if err != nil {
+		return nil, fmt.Errorf("%s %s failed: %w", m.name, method, err)
+	}
+	if len(returned) == 0 || returned[0] == 0 {
+		return nil, nil
+	}
+
+	outputPtr, outputLen := uint32(returned[0]>>32), uint32(returned[0])
+	defer m.free(instance, outputPtr, outputLen)
+
+	view, ok := instance.Memory().Read(outputPtr, outputLen)
+	if !ok {
+		return nil, fmt.Errorf("%s %s returned out-of-range result", m.name, method)
+	}
+	// The view aliases module memory, which later calls may overwrite
+	return append([]byte(nil), view...), nil
+}
+
+// free releases a buffer if the module exports dealloc
+func (m *wasmModule) free(instance api.Module, ptr, size uint32) {
+	if dealloc := instance.ExportedFunction("dealloc"); dealloc != nil {
+		dealloc.Call(context.Background(), uint64(ptr), uint64(size))
+	}
+}
+
+// Close releases the runtime and all instances
+func (m *wasmModule) Close() error {
+	return m.runtime.Close(context.Background())
+}
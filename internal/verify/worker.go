@@ -0,0 +1,268 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// WorkerOptions are the settings of a worker of a distributed server
+type WorkerOptions struct {
+	ServerURL     string        // base URL of a server started with serve -distributed
+	Token         string        // API token the server requires, if any
+	Name          string        // name the worker reports to the server
+	Poll          time.Duration // how often to ask for work while there is none
+	MetricsListen string        // address to serve the worker's /metrics on, empty for none
+}
+
+// Work verifies work units leased from a server running in distributed mode, heartbeating
+// while it works so the server can reassign its units if it dies. On SIGTERM or SIGINT it
+// finishes the addresses in flight, checkpoints them and hands the rest of its unit back, so
+// workers can be scaled down mid-run.
+func Work(config Config, opts WorkerOptions) error {
+	// Workers only report invalid emails back
+	config.DetailsFile = ""
+	config.ValidFile = ""
+	config.FreeFile = ""
+	config.OutputTemplate = ""
+	config.SplitRecords = false
+	config.WarehouseStage = ""
+
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return fmt.Errorf("failed to create data directory: %w", err)
+	}
+	lookups, err := newLookups(config)
+	if err != nil {
+		return fmt.Errorf("failed to configure lookups: %w", err)
+	}
+	defer lookups.Close()
+
+	client := &apiClient{
+		baseURL: strings.TrimSuffix(opts.ServerURL, "/"),
+		token:   opts.Token,
+		http:    &http.Client{Timeout: time.Minute},
+	}
+
+	held := &atomic.Int64{}
+	if opts.MetricsListen != "" {
+		go serveWorkerMetrics(opts.MetricsListen, lookups, held)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	slog.Info("worker taking work", "worker", opts.Name, "server", client.baseURL)
+	failures := 0
+	for ctx.Err() == nil {
+		unit, ok, err := client.lease(opts.Name)
+		if err != nil {
+			failures++
+			wait := min(opts.Poll*time.Duration(failures), time.Minute)
+			slog.Warn("failed to lease work, retrying", "attempt", failures, "backoff", wait, "error", err)
+			sleepContext(ctx, wait)
+			continue
+		}
+		failures = 0
+		if !ok {
+			sleepContext(ctx, opts.Poll)
+			continue
+		}
+		verifyUnit(ctx, client, opts.Name, unit, config, lookups, held, opts.Poll)
+	}
+	slog.Info("worker stopped", "worker", opts.Name)
+	return nil
+}
+
+// sleepContext sleeps for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+	case <-time.After(d):
+	}
+}
+
+// verifyUnit verifies a work unit with the job's options, heartbeating until it is done. Results
+// are checkpointed every unit.Checkpoint addresses; the server may answer a checkpoint with fewer
+// addresses left than the worker holds when another worker took some over.
+func verifyUnit(ctx context.Context, client *apiClient, name string, unit WorkUnit, config Config, lookups *Lookups, held *atomic.Int64, poll time.Duration) {
+	config.Workers = unit.Options.Workers
+	if rate, err := time.ParseDuration(unit.Options.Rate); err == nil {
+		config.RateLimit = rate
+	}
+	config.Level = unit.Options.level()
+	config.EnableSMTP = config.Level == levelSMTP
+
+	interval, err := time.ParseDuration(unit.Heartbeat)
+	if err != nil || interval <= 0 {
+		interval = 10 * time.Second
+	}
+	done := make(chan struct{})
+	var lost atomic.Bool
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				err := client.heartbeat(name, unit.ID)
+				var apiErr *apiError
+				if errors.As(err, &apiErr) {
+					// Someone else has the unit now; its next checkpoint is refused and the worker moves on
+					if !lost.Swap(true) {
+						slog.Warn("lost the lease on work unit", "unit", unit.ID, "error", err)
+					}
+				} else if err != nil {
+					slog.Warn("heartbeat for work unit failed", "unit", unit.ID, "error", err)
+				}
+			}
+		}
+	}()
+	defer close(done)
+
+	slog.Info("verifying work unit", "unit", unit.ID, "job", unit.JobID, "emails", len(unit.Emails))
+	emails := unit.Emails
+	size := unit.Checkpoint
+	if size <= 0 {
+		size = len(emails)
+	}
+	for len(emails) > 0 {
+		held.Store(int64(len(emails)))
+		batch := emails[:min(size, len(emails))]
+		stats := &Stats{StartTime: time.Now()}
+		// Batches always run to the end, since the checkpoint reports them done as a whole. Without
+		// an output or checkpoint of its own there is nothing that can fail to be written.
+		invalid, _, _, _, _ := processEmails(context.Background(), batch, config, lookups, stats, nil, nil)
+
+		remaining, err := client.checkpointRetrying(unit.ID, UnitCheckpoint{
+			UnitResult: UnitResult{
+				Worker:  name,
+				Invalid: invalid,
+				Checked: stats.TotalChecked,
+				Valid:   stats.TotalValid,
+				Risky:   stats.TotalRisky,
+			},
+			Done: len(batch),
+		}, poll)
+		if err != nil {
+			// The unit is done, gone or someone else's; otherwise the server reassigns it once
+			// the lease runs out, from the last checkpoint that got through
+			slog.Warn("failed to checkpoint work unit", "unit", unit.ID, "error", err)
+			break
+		}
+		emails = emails[len(batch):]
+		if remaining < len(emails) {
+			slog.Info("addresses of work unit were handed to another worker", "unit", unit.ID, "emails", len(emails)-remaining)
+			emails = emails[:remaining]
+		}
+		if len(emails) > 0 && ctx.Err() != nil {
+			if err := client.release(name, unit.ID); err != nil {
+				slog.Warn("failed to release work unit", "unit", unit.ID, "error", err)
+			} else {
+				slog.Info("released work unit", "unit", unit.ID, "remaining", len(emails))
+			}
+			break
+		}
+	}
+	held.Store(0)
+}
+
+// lease asks the server for a work unit; ok is false when there is none
+func (c *apiClient) lease(name string) (WorkUnit, bool, error) {
+	resp, err := c.postJSON("/work/lease", map[string]string{"worker": name})
+	if err != nil {
+		return WorkUnit{}, false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNoContent {
+		return WorkUnit{}, false, nil
+	}
+	var unit WorkUnit
+	if err := json.NewDecoder(resp.Body).Decode(&unit); err != nil {
+		return WorkUnit{}, false, fmt.Errorf("failed to decode work unit: %w", err)
+	}
+	return unit, true, nil
+}
+
+// heartbeat tells the server the worker is still on a unit
+func (c *apiClient) heartbeat(name, id string) error {
+	resp, err := c.postJSON("/work/"+id+"/heartbeat", map[string]string{"worker": name})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// checkpointRetrying reports the results of the first addresses of a unit, returning how many
+// the worker still holds. Network errors are retried; rejections are not.
+func (c *apiClient) checkpointRetrying(id string, checkpoint UnitCheckpoint, poll time.Duration) (int, error) {
+	for attempt := 1; ; attempt++ {
+		remaining, err := c.checkpoint(id, checkpoint)
+		var apiErr *apiError
+		if err == nil || errors.As(err, &apiErr) || attempt > 3 {
+			return remaining, err
+		}
+		time.Sleep(time.Duration(attempt) * poll)
+	}
+}
+
+func (c *apiClient) checkpoint(id string, checkpoint UnitCheckpoint) (int, error) {
+	resp, err := c.postJSON("/work/"+id+"/checkpoint", checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		Remaining int `json:"remaining"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return 0, fmt.Errorf("failed to decode checkpoint reply: %w", err)
+	}
+	return reply.Remaining, nil
+}
+
+// release hands a unit back to the server
+func (c *apiClient) release(name, id string) error {
+	resp, err := c.postJSON("/work/"+id+"/release", map[string]string{"worker": name})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// serveWorkerMetrics serves the worker's rate limiter saturation and the addresses it holds
+func serveWorkerMetrics(addr string, lookups *Lookups, held *atomic.Int64) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		m := newMetricsWriter(w)
+		m.gauge("worker_held_addresses", "Addresses of the worker's current unit not yet checkpointed", float64(held.Load()))
+		writeLimiterMetrics(m, lookups)
+		m.Flush()
+	})
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	if err := server.ListenAndServe(); err != nil {
+		slog.Warn("worker metrics server stopped", "error", err)
+	}
+}
+
+func (c *apiClient) postJSON(path string, v any) (*http.Response, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(http.MethodPost, path, bytes.NewReader(body))
+}
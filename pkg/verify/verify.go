@@ -0,0 +1,46 @@
+// Package verify embeds the bulk-verification engine of the email-verification tool in other Go
+// programs. A Runner verifies lists with the worker pool, lookups, checks and verdict rules of a
+// run, minus its files; settings come from DefaultConfig, with errors returned rather than exits.
+package verify
+
+import engine "email-verification/internal/verify"
+
+type (
+	// Runner verifies lists of addresses; see NewRunner
+	Runner = engine.Runner
+	// Config holds every setting of the command-line tool's options
+	Config = engine.Config
+	// EmailResult is the verdict and details of one address
+	EmailResult = engine.EmailResult
+	// Stats are the counts and timings of a run
+	Stats = engine.Stats
+	// Check is a compiled-in custom check, see RegisterCheck
+	Check = engine.Check
+	// CheckResult is the outcome of a custom check
+	CheckResult = engine.CheckResult
+)
+
+// Check verdicts a custom check may return
+const (
+	VerdictPass    = engine.VerdictPass
+	VerdictInvalid = engine.VerdictInvalid
+	VerdictRisky   = engine.VerdictRisky
+)
+
+// DefaultConfig returns the default settings of a run, those of the flags with no environment
+// variables set
+func DefaultConfig() Config {
+	return engine.DefaultConfig()
+}
+
+// NewRunner creates a runner with the given settings, usually DefaultConfig with some changed.
+// It makes no connections; the lookups start with the first Verify or Stream. Close it once done
+// to stop its background checks and extension processes.
+func NewRunner(config Config) (*Runner, error) {
+	return engine.NewRunner(config)
+}
+
+// RegisterCheck adds a compiled-in check for Config.Checks to name, from an init function
+func RegisterCheck(name string, factory func() Check) {
+	engine.RegisterCheck(name, factory)
+}